@@ -162,6 +162,15 @@ class ConfigDataStore @Inject constructor(
         put("authToken",       authToken)
         put("tlsMode",         tlsMode)
         put("fingerprint",     fingerprint)
+        put("preferIpv6",      preferIpv6)
+        put("ipv4Only",        ipv4Only)
+        put("killSwitch",      killSwitch)
+        put("allowDirectFallback", allowDirectFallback)
+        put("allowLan",        allowLan)
+        put("bypassLan",       bypassLan)
+        put("useCellular",     useCellular)
+        put("logcat",          logcat)
+        put("tunMtu",          tunMtu)
     }
 
     private fun JSONObject.toClientConfig() = ClientConfig(
@@ -177,5 +186,14 @@ class ConfigDataStore @Inject constructor(
         authToken      = optString("authToken"),
         tlsMode        = optString("tlsMode"),
         fingerprint    = optString("fingerprint"),
+        preferIpv6     = optBoolean("preferIpv6", false),
+        ipv4Only       = optBoolean("ipv4Only", false),
+        killSwitch     = optBoolean("killSwitch", false),
+        allowDirectFallback = optBoolean("allowDirectFallback", false),
+        allowLan       = optBoolean("allowLan", false),
+        bypassLan      = optBoolean("bypassLan", true),
+        useCellular    = optBoolean("useCellular", false),
+        logcat         = optBoolean("logcat", false),
+        tunMtu         = optInt("tunMtu", ClientConfig.DEFAULT_TUN_MTU),
     )
 }
@@ -5,6 +5,7 @@ import androidx.datastore.core.DataStore
 import androidx.datastore.preferences.core.Preferences
 import androidx.datastore.preferences.core.booleanPreferencesKey
 import androidx.datastore.preferences.core.edit
+import androidx.datastore.preferences.core.stringPreferencesKey
 import androidx.datastore.preferences.core.stringSetPreferencesKey
 import androidx.datastore.preferences.preferencesDataStore
 import dagger.hilt.android.qualifiers.ApplicationContext
@@ -15,13 +16,26 @@ import javax.inject.Singleton
 
 private val Context.splitTunnelStore: DataStore<Preferences> by preferencesDataStore(name = "split_tunnel")
 
+/** How the split-tunnel app list is applied. */
+enum class SplitTunnelMode {
+    /** Every app uses the VPN except the excluded ones (deny list). */
+    EXCLUDE,
+
+    /** Only the included apps use the VPN (allow list). */
+    INCLUDE,
+}
+
 /**
  * Persists split-tunnel settings:
  *   split_tunnel_enabled – whether split tunnelling is active
- *   excluded_apps        – package names of apps that BYPASS the VPN tunnel
+ *   split_tunnel_mode    – [SplitTunnelMode] name
+ *   excluded_apps        – package names of apps that BYPASS the VPN tunnel (EXCLUDE mode)
+ *   included_apps        – package names of the only apps that use it (INCLUDE mode)
  *
  * When split tunnel is OFF all apps go through VPN (except Phoenix itself).
- * When split tunnel is ON, every package in [excludedAppsFlow] bypasses the VPN.
+ * When split tunnel is ON, every package in [excludedAppsFlow] bypasses the VPN,
+ * or in INCLUDE mode only the packages in [includedAppsFlow] use it. Both lists
+ * are kept so switching modes doesn't lose either selection.
  */
 @Singleton
 class SplitTunnelDataStore @Inject constructor(
@@ -29,19 +43,33 @@ class SplitTunnelDataStore @Inject constructor(
 ) {
     private object Keys {
         val ENABLED       = booleanPreferencesKey("split_tunnel_enabled")
+        val MODE          = stringPreferencesKey("split_tunnel_mode")
         val EXCLUDED_APPS = stringSetPreferencesKey("excluded_apps")
+        val INCLUDED_APPS = stringSetPreferencesKey("included_apps")
     }
 
     val enabledFlow: Flow<Boolean> = context.splitTunnelStore.data
         .map { it[Keys.ENABLED] ?: false }
 
+    val modeFlow: Flow<SplitTunnelMode> = context.splitTunnelStore.data
+        .map { prefs ->
+            runCatching { SplitTunnelMode.valueOf(prefs[Keys.MODE] ?: "") }.getOrDefault(SplitTunnelMode.EXCLUDE)
+        }
+
     val excludedAppsFlow: Flow<Set<String>> = context.splitTunnelStore.data
         .map { it[Keys.EXCLUDED_APPS] ?: emptySet() }
 
+    val includedAppsFlow: Flow<Set<String>> = context.splitTunnelStore.data
+        .map { it[Keys.INCLUDED_APPS] ?: emptySet() }
+
     suspend fun setEnabled(enabled: Boolean) {
         context.splitTunnelStore.edit { it[Keys.ENABLED] = enabled }
     }
 
+    suspend fun setMode(mode: SplitTunnelMode) {
+        context.splitTunnelStore.edit { it[Keys.MODE] = mode.name }
+    }
+
     /** Adds or removes [packageName] from the excluded set. */
     suspend fun toggleApp(packageName: String) {
         context.splitTunnelStore.edit { prefs ->
@@ -55,4 +83,18 @@ class SplitTunnelDataStore @Inject constructor(
     suspend fun setExcludedApps(apps: Set<String>) {
         context.splitTunnelStore.edit { it[Keys.EXCLUDED_APPS] = apps }
     }
+
+    /** Adds or removes [packageName] from the included set. */
+    suspend fun toggleIncludedApp(packageName: String) {
+        context.splitTunnelStore.edit { prefs ->
+            val current = prefs[Keys.INCLUDED_APPS] ?: emptySet()
+            prefs[Keys.INCLUDED_APPS] =
+                if (packageName in current) current - packageName else current + packageName
+        }
+    }
+
+    /** Replaces the entire included-apps set at once. */
+    suspend fun setIncludedApps(apps: Set<String>) {
+        context.splitTunnelStore.edit { it[Keys.INCLUDED_APPS] = apps }
+    }
 }
@@ -17,6 +17,23 @@ import java.util.UUID
  *                        transparent proxying. When false, only a local SOCKS5 proxy is used.
  * @param localSocksAddr  Local SOCKS5 listen address. Default: 0.0.0.0:1080.
  * @param enableUdp       Whether to allow SOCKS5 UDP ASSOCIATE.
+ * @param preferIpv6      Connect to the server over IPv6 when it has both (v6-only carriers).
+ * @param ipv4Only        Never connect to the server over IPv6.
+ * @param killSwitch      Block traffic while the tunnel is down instead of letting apps use
+ *                        the direct route (VPN mode keeps the TUN up while it reconnects).
+ * @param allowDirectFallback Connect directly while the server is unreachable. Mutually
+ *                        exclusive with [killSwitch].
+ * @param allowLan        Share the local proxy with other devices on the same network
+ *                        (private-range source addresses only).
+ * @param bypassLan       Reach private, link-local and multicast addresses and .local names
+ *                        directly, so printers and casting devices keep working.
+ * @param useCellular     Bind the connection to the server to the cellular network
+ *                        (`outbound_interface`), even while Wi-Fi is the default network,
+ *                        e.g. when the Wi-Fi sits behind a captive portal.
+ * @param logcat          Also send the Go client's log to logcat (`[log] sink = "logcat"`),
+ *                        tagged "phoenix", for `adb logcat` and bug report tools.
+ * @param tunMtu          MTU of the VPN interface and of the Go netstack (`[tun] mtu`). Lower
+ *                        it on PPPoE or LTE links that drop full-size packets.
  */
 data class ClientConfig(
     val id: String = UUID.randomUUID().toString(),
@@ -31,4 +48,18 @@ data class ClientConfig(
     val authToken: String = "",
     val tlsMode: String = "",        // "" | "system" | "insecure"
     val fingerprint: String = "",    // "" | "chrome" | "firefox" | "safari" | "random"
-)
+    val preferIpv6: Boolean = false,
+    val ipv4Only: Boolean = false,
+    val killSwitch: Boolean = false,
+    val allowDirectFallback: Boolean = false,
+    val allowLan: Boolean = false,
+    val bypassLan: Boolean = true,
+    val useCellular: Boolean = false,
+    val logcat: Boolean = false,
+    val tunMtu: Int = DEFAULT_TUN_MTU,
+) {
+    companion object {
+        const val DEFAULT_TUN_MTU = 1500
+        const val MIN_TUN_MTU = 1280
+    }
+}
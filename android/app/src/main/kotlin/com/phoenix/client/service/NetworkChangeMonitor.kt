@@ -0,0 +1,39 @@
+package com.phoenix.client.service
+
+import android.content.Context
+import android.net.ConnectivityManager
+import android.net.Network
+
+/**
+ * Watches the device's default network and calls [onChange] when it switches
+ * (e.g. Wi-Fi ↔ LTE), so the Go client can drop connections bound to the old
+ * network at once instead of waiting for them to time out.
+ *
+ * Phoenix excludes itself from the VPN, so in VPN mode this still tracks the
+ * underlying physical network rather than the tunnel.
+ */
+class NetworkChangeMonitor(context: Context, private val onChange: () -> Unit) {
+
+    private val connectivity = context.getSystemService(ConnectivityManager::class.java)
+
+    @Volatile
+    private var current: Network? = null
+
+    private val callback = object : ConnectivityManager.NetworkCallback() {
+        override fun onAvailable(network: Network) {
+            val previous = current
+            current = network
+            // The first callback reports the network we started on.
+            if (previous != null && previous != network) onChange()
+        }
+    }
+
+    fun start() {
+        runCatching { connectivity.registerDefaultNetworkCallback(callback) }
+    }
+
+    fun stop() {
+        runCatching { connectivity.unregisterNetworkCallback(callback) }
+        current = null
+    }
+}
@@ -19,6 +19,7 @@ import kotlinx.coroutines.Dispatchers
 import kotlinx.coroutines.SupervisorJob
 import kotlinx.coroutines.cancel
 import kotlinx.coroutines.launch
+import java.io.IOException
 import java.io.InterruptedIOException
 import java.util.concurrent.atomic.AtomicBoolean
 
@@ -41,6 +42,12 @@ class PhoenixService : Service() {
         const val EXTRA_AUTH_TOKEN = "auth_token"
         const val EXTRA_TLS_MODE = "tls_mode"
         const val EXTRA_FINGERPRINT = "fingerprint"
+        const val EXTRA_KILL_SWITCH = "kill_switch"
+        const val EXTRA_ALLOW_DIRECT_FALLBACK = "allow_direct_fallback"
+        const val EXTRA_ALLOW_LAN = "allow_lan"
+        const val EXTRA_BYPASS_LAN = "bypass_lan"
+        const val EXTRA_USE_CELLULAR = "use_cellular"
+        const val EXTRA_LOGCAT = "logcat"
 
         fun startIntent(context: Context, config: ClientConfig): Intent =
             Intent(context, PhoenixService::class.java).apply {
@@ -53,6 +60,12 @@ class PhoenixService : Service() {
                 putExtra(EXTRA_AUTH_TOKEN, config.authToken)
                 putExtra(EXTRA_TLS_MODE, config.tlsMode)
                 putExtra(EXTRA_FINGERPRINT, config.fingerprint)
+                putExtra(EXTRA_KILL_SWITCH, config.killSwitch)
+                putExtra(EXTRA_ALLOW_DIRECT_FALLBACK, config.allowDirectFallback)
+                putExtra(EXTRA_ALLOW_LAN, config.allowLan)
+                putExtra(EXTRA_BYPASS_LAN, config.bypassLan)
+                putExtra(EXTRA_USE_CELLULAR, config.useCellular)
+                putExtra(EXTRA_LOGCAT, config.logcat)
             }
 
         fun stopIntent(context: Context): Intent =
@@ -68,11 +81,18 @@ class PhoenixService : Service() {
      */
     private val intentionallyStopped = AtomicBoolean(false)
 
+    private lateinit var networkMonitor: NetworkChangeMonitor
+    private lateinit var powerMonitor: PowerStateMonitor
+
     override fun onBind(intent: Intent?): IBinder? = null
 
     override fun onCreate() {
         super.onCreate()
         createNotificationChannel()
+        networkMonitor = NetworkChangeMonitor(this) { notifyNetworkChange() }
+        powerMonitor = PowerStateMonitor(this) { lowPower ->
+            sendControl(if (lowPower) "low-power on" else "low-power off")
+        }
     }
 
     override fun onStartCommand(intent: Intent?, flags: Int, startId: Int): Int {
@@ -132,6 +152,7 @@ class PhoenixService : Service() {
             binary.absolutePath,
             "-config", configResult.file.absolutePath,
             "-files-dir", filesDir.absolutePath,
+            "-control-stdin",
         )
         ServiceEvents.emitLog("CMD: ${cmd.joinToString(" ")}")
         Log.i(TAG, "Launching: ${cmd.joinToString(" ")}")
@@ -140,17 +161,20 @@ class PhoenixService : Service() {
             process = ProcessBuilder(*cmd)
                 .redirectErrorStream(true)
                 .start()
+            networkMonitor.start()
+            powerMonitor.start()
 
-            // Wait for the Go binary to confirm it is actually listening before
-            // broadcasting CONNECTED. This gives users accurate status and avoids
-            // showing "Connected" during early startup/TLS handshake phases.
+            // Wait for the Go binary to report "[Ready]" (inbounds bound and the
+            // server answered) before broadcasting CONNECTED. This gives users
+            // accurate status and avoids showing "Connected" during early
+            // startup/TLS handshake phases.
             var listenerStarted = false
 
             process!!.inputStream.bufferedReader().forEachLine { line ->
                 Log.i(TAG, "[go] $line")
                 ServiceEvents.emitLog(line)
 
-                if (!listenerStarted && "Listening on" in line) {
+                if (!listenerStarted && "[Ready]" in line) {
                     listenerStarted = true
                     ServiceEvents.emitStatus(ServiceEvents.StatusEvent.Connected)
                 }
@@ -185,6 +209,8 @@ class PhoenixService : Service() {
                 ServiceEvents.emitStatus(ServiceEvents.StatusEvent.Error(msg))
             }
         } finally {
+            networkMonitor.stop()
+            powerMonitor.stop()
             if (!intentionallyStopped.get()) {
                 stopSelf()
             }
@@ -193,10 +219,30 @@ class PhoenixService : Service() {
 
     private fun killProcess() {
         intentionallyStopped.set(true)
+        networkMonitor.stop()
+        powerMonitor.stop()
         process?.destroy()
         process = null
     }
 
+    /** Tells the Go client to reconnect over the new default network. */
+    private fun notifyNetworkChange() {
+        ServiceEvents.emitLog("Network changed — reconnecting")
+        sendControl("network-change")
+    }
+
+    /** Writes a command line to the Go client's stdin (see -control-stdin). */
+    private fun sendControl(command: String) {
+        try {
+            process?.outputStream?.apply {
+                write("$command\n".toByteArray())
+                flush()
+            }
+        } catch (e: IOException) {
+            Log.w(TAG, "control command '$command' failed: ${e.message}")
+        }
+    }
+
     private fun createNotificationChannel() {
         val channel = NotificationChannel(
             CHANNEL_ID,
@@ -230,5 +276,11 @@ class PhoenixService : Service() {
         authToken = getStringExtra(EXTRA_AUTH_TOKEN) ?: "",
         tlsMode = getStringExtra(EXTRA_TLS_MODE) ?: "",
         fingerprint = getStringExtra(EXTRA_FINGERPRINT) ?: "",
+        killSwitch = getBooleanExtra(EXTRA_KILL_SWITCH, false),
+        allowDirectFallback = getBooleanExtra(EXTRA_ALLOW_DIRECT_FALLBACK, false),
+        allowLan = getBooleanExtra(EXTRA_ALLOW_LAN, false),
+        bypassLan = getBooleanExtra(EXTRA_BYPASS_LAN, true),
+        useCellular = getBooleanExtra(EXTRA_USE_CELLULAR, false),
+        logcat = getBooleanExtra(EXTRA_LOGCAT, false),
     )
 }
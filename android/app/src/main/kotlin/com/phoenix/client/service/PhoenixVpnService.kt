@@ -6,12 +6,15 @@ import android.app.NotificationManager
 import android.app.PendingIntent
 import android.content.Context
 import android.content.Intent
+import android.net.IpPrefix
 import android.net.LocalServerSocket
 import android.net.VpnService
+import android.os.Build
 import android.os.IBinder
 import android.os.ParcelFileDescriptor
 import android.util.Log
 import com.phoenix.client.R
+import com.phoenix.client.data.datastore.SplitTunnelMode
 import com.phoenix.client.domain.model.ClientConfig
 import com.phoenix.client.ui.MainActivity
 import com.phoenix.client.util.BinaryExtractor
@@ -20,8 +23,11 @@ import kotlinx.coroutines.CoroutineScope
 import kotlinx.coroutines.Dispatchers
 import kotlinx.coroutines.SupervisorJob
 import kotlinx.coroutines.cancel
+import kotlinx.coroutines.delay
 import kotlinx.coroutines.launch
+import java.io.IOException
 import java.io.InterruptedIOException
+import java.net.InetAddress
 import java.util.concurrent.atomic.AtomicBoolean
 
 class PhoenixVpnService : VpnService() {
@@ -29,8 +35,16 @@ class PhoenixVpnService : VpnService() {
     companion object {
         private const val TAG = "PhoenixVpnService"
         private const val NOTIFICATION_ID = 2
+        private const val RESTART_BACKOFF_MS = 2_000L // doubled per failed restart, up to 32 s
         private const val CHANNEL_ID = "phoenix_vpn"
 
+        /** Mirrors config.PrivateHosts in Go (without the .local names). */
+        private val LOCAL_ROUTES = listOf(
+            "10.0.0.0" to 8, "172.16.0.0" to 12, "192.168.0.0" to 16,
+            "169.254.0.0" to 16, "224.0.0.0" to 4, "255.255.255.255" to 32,
+            "fc00::" to 7, "fe80::" to 10, "ff00::" to 8,
+        )
+
         const val ACTION_START = "com.phoenix.client.VPN_START"
         const val ACTION_STOP = "com.phoenix.client.VPN_STOP"
 
@@ -42,14 +56,25 @@ class PhoenixVpnService : VpnService() {
         const val EXTRA_AUTH_TOKEN = "auth_token"
         const val EXTRA_TLS_MODE = "tls_mode"
         const val EXTRA_FINGERPRINT = "fingerprint"
+        const val EXTRA_KILL_SWITCH = "kill_switch"
+        const val EXTRA_ALLOW_DIRECT_FALLBACK = "allow_direct_fallback"
+        const val EXTRA_ALLOW_LAN = "allow_lan"
+        const val EXTRA_BYPASS_LAN = "bypass_lan"
+        const val EXTRA_USE_CELLULAR = "use_cellular"
+        const val EXTRA_LOGCAT = "logcat"
+        const val EXTRA_TUN_MTU = "tun_mtu"
         const val EXTRA_SPLIT_TUNNEL_ENABLED = "split_tunnel_enabled"
+        const val EXTRA_SPLIT_TUNNEL_MODE = "split_tunnel_mode"
         const val EXTRA_EXCLUDED_APPS = "excluded_apps"
+        const val EXTRA_INCLUDED_APPS = "included_apps"
 
         fun startIntent(
             context: Context,
             config: ClientConfig,
             splitTunnelEnabled: Boolean = false,
+            splitTunnelMode: SplitTunnelMode = SplitTunnelMode.EXCLUDE,
             excludedApps: Set<String> = emptySet(),
+            includedApps: Set<String> = emptySet(),
         ): Intent =
             Intent(context, PhoenixVpnService::class.java).apply {
                 action = ACTION_START
@@ -61,8 +86,17 @@ class PhoenixVpnService : VpnService() {
                 putExtra(EXTRA_AUTH_TOKEN, config.authToken)
                 putExtra(EXTRA_TLS_MODE, config.tlsMode)
                 putExtra(EXTRA_FINGERPRINT, config.fingerprint)
+                putExtra(EXTRA_KILL_SWITCH, config.killSwitch)
+                putExtra(EXTRA_ALLOW_DIRECT_FALLBACK, config.allowDirectFallback)
+                putExtra(EXTRA_ALLOW_LAN, config.allowLan)
+                putExtra(EXTRA_BYPASS_LAN, config.bypassLan)
+                putExtra(EXTRA_USE_CELLULAR, config.useCellular)
+                putExtra(EXTRA_LOGCAT, config.logcat)
+                putExtra(EXTRA_TUN_MTU, config.tunMtu)
                 putExtra(EXTRA_SPLIT_TUNNEL_ENABLED, splitTunnelEnabled)
+                putExtra(EXTRA_SPLIT_TUNNEL_MODE, splitTunnelMode.name)
                 putStringArrayListExtra(EXTRA_EXCLUDED_APPS, ArrayList(excludedApps))
+                putStringArrayListExtra(EXTRA_INCLUDED_APPS, ArrayList(includedApps))
             }
 
         fun stopIntent(context: Context): Intent =
@@ -73,16 +107,24 @@ class PhoenixVpnService : VpnService() {
     private var process: Process? = null
     private var tunInterface: ParcelFileDescriptor? = null
     private val intentionallyStopped = AtomicBoolean(false)
+    private lateinit var networkMonitor: NetworkChangeMonitor
+    private lateinit var powerMonitor: PowerStateMonitor
 
     // Split-tunnel state set once per start command
     private var splitTunnelEnabled = false
+    private var splitTunnelMode = SplitTunnelMode.EXCLUDE
     private var excludedApps: List<String> = emptyList()
+    private var includedApps: List<String> = emptyList()
 
     override fun onBind(intent: Intent?): IBinder? = null
 
     override fun onCreate() {
         super.onCreate()
         createNotificationChannel()
+        networkMonitor = NetworkChangeMonitor(this) { notifyNetworkChange() }
+        powerMonitor = PowerStateMonitor(this) { lowPower ->
+            sendControl(if (lowPower) "low-power on" else "low-power off")
+        }
     }
 
     override fun onStartCommand(intent: Intent?, flags: Int, startId: Int): Int {
@@ -91,7 +133,11 @@ class PhoenixVpnService : VpnService() {
                 intentionallyStopped.set(false)
                 val config = intent.toClientConfig()
                 splitTunnelEnabled = intent.getBooleanExtra(EXTRA_SPLIT_TUNNEL_ENABLED, false)
+                splitTunnelMode = runCatching {
+                    SplitTunnelMode.valueOf(intent.getStringExtra(EXTRA_SPLIT_TUNNEL_MODE) ?: "")
+                }.getOrDefault(SplitTunnelMode.EXCLUDE)
                 excludedApps = intent.getStringArrayListExtra(EXTRA_EXCLUDED_APPS) ?: emptyList()
+                includedApps = intent.getStringArrayListExtra(EXTRA_INCLUDED_APPS) ?: emptyList()
                 // startForeground() is required by Android to avoid a crash,
                 // but we immediately remove it — the active VPN session keeps
                 // the service alive without a visible notification.
@@ -133,7 +179,7 @@ class PhoenixVpnService : VpnService() {
 
     // ── Private helpers ────────────────────────────────────────────────────────
 
-    private fun launchVpn(config: ClientConfig) {
+    private suspend fun launchVpn(config: ClientConfig) {
         killProcess()
         closeTun()
 
@@ -172,16 +218,9 @@ class PhoenixVpnService : VpnService() {
                 .addRoute("::", 0)
                 .addDnsServer("1.1.1.1")
                 .addDnsServer("8.8.8.8")
-                .setMtu(1500)
-                .addDisallowedApplication(packageName) // always exclude Phoenix itself → no routing loop
-                .also { builder ->
-                    // Split tunnel: exclude additional apps chosen by the user
-                    if (splitTunnelEnabled) {
-                        for (pkg in excludedApps) {
-                            runCatching { builder.addDisallowedApplication(pkg) }
-                        }
-                    }
-                }
+                .setMtu(config.tunMtu)
+                .also { builder -> applySplitTunnel(builder) }
+                .also { builder -> if (config.bypassLan) excludeLocalRoutes(builder) }
                 .establish()
         } catch (e: Exception) {
             ServiceEvents.emitLog("ERROR: TUN setup failed — ${e.message}")
@@ -200,6 +239,41 @@ class PhoenixVpnService : VpnService() {
         tunInterface = tunPfd
         ServiceEvents.emitLog("TUN interface established (fd=${tunPfd.fd})")
 
+        // Without a kill switch a dead client ends the session and Android
+        // tears down the TUN, so apps fall back to the direct route. With it,
+        // the TUN stays up — nothing reads it, so traffic is dropped — while
+        // the client is restarted with growing backoff.
+        var failures = 0
+        while (true) {
+            val listened = runClient(binary.absolutePath, configResult.file.absolutePath, tunPfd, config.killSwitch)
+            if (intentionallyStopped.get() || tunInterface !== tunPfd) return // stopped or superseded
+            if (!config.killSwitch) {
+                stopSelf()
+                return
+            }
+            if (listened) failures = 0
+            val backoffMs = RESTART_BACKOFF_MS shl failures.coerceAtMost(4)
+            failures++
+            ServiceEvents.emitLog("Kill switch: blocking traffic, restarting client in ${backoffMs / 1000}s")
+            ServiceEvents.emitStatus(
+                ServiceEvents.StatusEvent.Blocking("Tunnel down — kill switch is blocking traffic"),
+            )
+            delay(backoffMs)
+            if (intentionallyStopped.get() || tunInterface !== tunPfd) return
+        }
+    }
+
+    /**
+     * Starts the Go client on [tunPfd] and blocks until it exits. Returns
+     * whether it got as far as listening. With [killSwitch] set, exits aren't
+     * reported as Disconnected/Error — the caller restarts the client.
+     */
+    private fun runClient(
+        binaryPath: String,
+        configPath: String,
+        tunPfd: ParcelFileDescriptor,
+        killSwitch: Boolean,
+    ): Boolean {
         // Use an abstract Unix socket to pass the TUN fd to the Go subprocess via SCM_RIGHTS.
         // This is the standard approach on Android — direct fd inheritance is blocked
         // (Android closes non-stdio fds before exec) and /proc/<pid>/fd/ is blocked by SELinux.
@@ -228,26 +302,28 @@ class PhoenixVpnService : VpnService() {
         fdSender.start()
 
         val cmd = arrayOf(
-            binary.absolutePath,
-            "-config", configResult.file.absolutePath,
+            binaryPath,
+            "-config", configPath,
             "-files-dir", filesDir.absolutePath,
             "-tun-socket", socketName,
+            "-control-stdin",
         )
         ServiceEvents.emitLog("CMD: ${cmd.joinToString(" ")}")
         Log.i(TAG, "Launching VPN: ${cmd.joinToString(" ")}")
 
+        var listenerStarted = false
         try {
             process = ProcessBuilder(*cmd)
                 .redirectErrorStream(true)
                 .start()
-
-            var listenerStarted = false
+            networkMonitor.start()
+            powerMonitor.start()
 
             process!!.inputStream.bufferedReader().forEachLine { line ->
                 Log.i(TAG, "[go] $line")
                 ServiceEvents.emitLog(line)
 
-                if (!listenerStarted && "Listening on" in line) {
+                if (!listenerStarted && "[Ready]" in line) {
                     listenerStarted = true
                     ServiceEvents.emitStatus(ServiceEvents.StatusEvent.Connected)
                 }
@@ -256,7 +332,7 @@ class PhoenixVpnService : VpnService() {
             val exitCode = process!!.waitFor()
             ServiceEvents.emitLog("VPN process exited (code $exitCode)")
 
-            if (!intentionallyStopped.get()) {
+            if (!intentionallyStopped.get() && !killSwitch) {
                 if (!listenerStarted) {
                     ServiceEvents.emitStatus(
                         ServiceEvents.StatusEvent.Error(
@@ -274,20 +350,86 @@ class PhoenixVpnService : VpnService() {
                 val msg = "VPN process error: ${e.message}"
                 Log.e(TAG, msg)
                 ServiceEvents.emitLog("ERROR: $msg")
-                ServiceEvents.emitStatus(ServiceEvents.StatusEvent.Error(msg))
+                if (!killSwitch) ServiceEvents.emitStatus(ServiceEvents.StatusEvent.Error(msg))
             }
         } finally {
+            networkMonitor.stop()
+            powerMonitor.stop()
             // Close the server socket to unblock accept() in fdSender if Go never connected.
             try { server.close() } catch (_: Exception) {}
-            if (!intentionallyStopped.get()) stopSelf()
         }
+        return listenerStarted
     }
 
     private fun killProcess() {
+        networkMonitor.stop()
+        powerMonitor.stop()
         process?.destroy()
         process = null
     }
 
+    /**
+     * Chooses which apps' traffic enters the TUN. This has to happen here via
+     * the Builder: the packets the Go side reads from the TUN carry no UID, so
+     * per-app routing can't be decided in the netstack. Android also refuses
+     * to mix allowed and disallowed apps on one Builder, so INCLUDE mode just
+     * leaves Phoenix off the allow list instead of disallowing it.
+     */
+    private fun applySplitTunnel(builder: Builder) {
+        if (splitTunnelEnabled && splitTunnelMode == SplitTunnelMode.INCLUDE) {
+            var allowed = 0
+            for (pkg in includedApps) {
+                if (pkg == packageName) continue
+                if (runCatching { builder.addAllowedApplication(pkg) }.isSuccess) allowed++
+            }
+            if (allowed > 0) {
+                ServiceEvents.emitLog("Split tunnel: routing only $allowed selected app(s) through VPN")
+                return
+            }
+            ServiceEvents.emitLog("Split tunnel: no selected apps installed — routing all apps")
+        }
+
+        builder.addDisallowedApplication(packageName) // always exclude Phoenix itself → no routing loop
+        // Split tunnel: exclude additional apps chosen by the user
+        if (splitTunnelEnabled && splitTunnelMode == SplitTunnelMode.EXCLUDE) {
+            for (pkg in excludedApps) {
+                runCatching { builder.addDisallowedApplication(pkg) }
+            }
+        }
+    }
+
+    /**
+     * Keeps LAN, link-local and multicast traffic off the TUN so it uses the
+     * Wi-Fi directly — this covers UDP such as mDNS discovery, which the Go
+     * side's routing.bypass_private can't see inside a UDP association.
+     * Excluding routes needs API 33; older devices rely on the Go side alone.
+     */
+    private fun excludeLocalRoutes(builder: Builder) {
+        if (Build.VERSION.SDK_INT < Build.VERSION_CODES.TIRAMISU) return
+        for ((addr, prefix) in LOCAL_ROUTES) {
+            runCatching { builder.excludeRoute(IpPrefix(InetAddress.getByName(addr), prefix)) }
+                .onFailure { Log.w(TAG, "excludeRoute $addr/$prefix failed: ${it.message}") }
+        }
+    }
+
+    /** Tells the Go client to reconnect over the new default network. */
+    private fun notifyNetworkChange() {
+        ServiceEvents.emitLog("Network changed — reconnecting")
+        sendControl("network-change")
+    }
+
+    /** Writes a command line to the Go client's stdin (see -control-stdin). */
+    private fun sendControl(command: String) {
+        try {
+            process?.outputStream?.apply {
+                write("$command\n".toByteArray())
+                flush()
+            }
+        } catch (e: IOException) {
+            Log.w(TAG, "control command '$command' failed: ${e.message}")
+        }
+    }
+
     private fun closeTun() {
         try { tunInterface?.close() } catch (_: Exception) {}
         tunInterface = null
@@ -328,5 +470,12 @@ class PhoenixVpnService : VpnService() {
         authToken = getStringExtra(EXTRA_AUTH_TOKEN) ?: "",
         tlsMode = getStringExtra(EXTRA_TLS_MODE) ?: "",
         fingerprint = getStringExtra(EXTRA_FINGERPRINT) ?: "",
+        killSwitch = getBooleanExtra(EXTRA_KILL_SWITCH, false),
+        allowDirectFallback = getBooleanExtra(EXTRA_ALLOW_DIRECT_FALLBACK, false),
+        allowLan = getBooleanExtra(EXTRA_ALLOW_LAN, false),
+        bypassLan = getBooleanExtra(EXTRA_BYPASS_LAN, true),
+        useCellular = getBooleanExtra(EXTRA_USE_CELLULAR, false),
+        logcat = getBooleanExtra(EXTRA_LOGCAT, false),
+        tunMtu = getIntExtra(EXTRA_TUN_MTU, ClientConfig.DEFAULT_TUN_MTU),
     )
 }
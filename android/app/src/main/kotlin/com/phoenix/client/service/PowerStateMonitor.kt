@@ -0,0 +1,53 @@
+package com.phoenix.client.service
+
+import android.content.BroadcastReceiver
+import android.content.Context
+import android.content.Intent
+import android.content.IntentFilter
+import android.os.PowerManager
+
+/**
+ * Reports whether the device is in a low-power state — screen off or Doze —
+ * so the Go client can stretch its keepalive pings. Every ping wakes the
+ * radio; pinging every few seconds while the phone sleeps drains the battery.
+ */
+class PowerStateMonitor(private val context: Context, private val onChange: (lowPower: Boolean) -> Unit) {
+
+    private val power = context.getSystemService(PowerManager::class.java)
+    @Volatile
+    private var registered = false
+    private var lowPower = false
+
+    private val receiver = object : BroadcastReceiver() {
+        override fun onReceive(context: Context, intent: Intent) {
+            update()
+        }
+    }
+
+    fun start() {
+        if (registered) return
+        val filter = IntentFilter().apply {
+            addAction(Intent.ACTION_SCREEN_OFF)
+            addAction(Intent.ACTION_SCREEN_ON)
+            addAction(PowerManager.ACTION_DEVICE_IDLE_MODE_CHANGED)
+        }
+        context.registerReceiver(receiver, filter)
+        registered = true
+        lowPower = false
+        update()
+    }
+
+    fun stop() {
+        if (!registered) return
+        runCatching { context.unregisterReceiver(receiver) }
+        registered = false
+    }
+
+    private fun update() {
+        val now = !power.isInteractive || power.isDeviceIdleMode
+        if (now != lowPower) {
+            lowPower = now
+            onChange(now)
+        }
+    }
+}
@@ -12,6 +12,8 @@ import kotlinx.coroutines.flow.asSharedFlow
  */
 object ServiceEvents {
 
+    private const val NOTICE_MARKER = "[Notice] From server: "
+
     // replay=1 so a new subscriber instantly gets the last known status
     private val _status = MutableSharedFlow<StatusEvent>(replay = 1, extraBufferCapacity = 8)
     val status = _status.asSharedFlow()
@@ -20,17 +22,27 @@ object ServiceEvents {
     private val _log = MutableSharedFlow<String>(replay = 0, extraBufferCapacity = 500)
     val log = _log.asSharedFlow()
 
+    // notices: replay=1 so the home screen shows the latest one when opened
+    private val _notice = MutableSharedFlow<String>(replay = 1, extraBufferCapacity = 8)
+    val notice = _notice.asSharedFlow()
+
     fun emitStatus(event: StatusEvent) {
         _status.tryEmit(event)
     }
 
     fun emitLog(line: String) {
         _log.tryEmit(line)
+        // The Go client logs each notice pushed by the server on one line.
+        val notice = line.substringAfter(NOTICE_MARKER, "")
+        if (notice.isNotEmpty()) _notice.tryEmit(notice)
     }
 
     sealed class StatusEvent {
         data object Connected : StatusEvent()
         data object Disconnected : StatusEvent()
         data class Error(val message: String) : StatusEvent()
+
+        /** Tunnel is down but the kill switch holds the VPN while it reconnects. */
+        data class Blocking(val message: String) : StatusEvent()
     }
 }
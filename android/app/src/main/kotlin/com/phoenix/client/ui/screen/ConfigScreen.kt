@@ -24,6 +24,7 @@ import androidx.compose.foundation.layout.width
 import androidx.compose.foundation.lazy.LazyColumn
 import androidx.compose.foundation.lazy.items
 import androidx.compose.foundation.rememberScrollState
+import androidx.compose.foundation.text.KeyboardOptions
 import androidx.compose.foundation.text.selection.SelectionContainer
 import androidx.compose.foundation.verticalScroll
 import androidx.compose.material.icons.Icons
@@ -71,6 +72,7 @@ import androidx.compose.ui.graphics.Color
 import androidx.compose.ui.text.style.TextAlign
 import androidx.compose.ui.platform.LocalContext
 import androidx.compose.ui.text.font.FontFamily
+import androidx.compose.ui.text.input.KeyboardType
 import androidx.compose.ui.unit.dp
 import androidx.hilt.navigation.compose.hiltViewModel
 import com.phoenix.client.domain.model.ClientConfig
@@ -363,6 +365,15 @@ private fun ConfigEditContent(
     var serverPubKey   by remember { mutableStateOf(initialConfig.serverPubKey) }
     var localSocksAddr by remember { mutableStateOf(initialConfig.localSocksAddr) }
     var enableUdp      by remember { mutableStateOf(initialConfig.enableUdp) }
+    var preferIpv6     by remember { mutableStateOf(initialConfig.preferIpv6) }
+    var ipv4Only       by remember { mutableStateOf(initialConfig.ipv4Only) }
+    var killSwitch     by remember { mutableStateOf(initialConfig.killSwitch) }
+    var allowDirectFallback by remember { mutableStateOf(initialConfig.allowDirectFallback) }
+    var allowLan       by remember { mutableStateOf(initialConfig.allowLan) }
+    var bypassLan      by remember { mutableStateOf(initialConfig.bypassLan) }
+    var useCellular    by remember { mutableStateOf(initialConfig.useCellular) }
+    var logcat         by remember { mutableStateOf(initialConfig.logcat) }
+    var tunMtu         by remember { mutableStateOf(initialConfig.tunMtu.toString()) }
     var authToken      by remember { mutableStateOf(initialConfig.authToken) }
     var tlsMode        by remember { mutableStateOf(initialConfig.tlsMode) }
     var tlsModeExpanded by remember { mutableStateOf(false) }
@@ -379,6 +390,15 @@ private fun ConfigEditContent(
         serverPubKey.trim() != initialConfig.serverPubKey ||
         localSocksAddr.trim() != initialConfig.localSocksAddr ||
         enableUdp != initialConfig.enableUdp ||
+        preferIpv6 != initialConfig.preferIpv6 ||
+        ipv4Only != initialConfig.ipv4Only ||
+        killSwitch != initialConfig.killSwitch ||
+        allowDirectFallback != initialConfig.allowDirectFallback ||
+        allowLan != initialConfig.allowLan ||
+        bypassLan != initialConfig.bypassLan ||
+        useCellular != initialConfig.useCellular ||
+        logcat != initialConfig.logcat ||
+        tunMtu.trim() != initialConfig.tunMtu.toString() ||
         authToken.trim() != initialConfig.authToken ||
         tlsMode != initialConfig.tlsMode ||
         fingerprint != initialConfig.fingerprint
@@ -825,6 +845,18 @@ private fun ConfigEditContent(
 
         Spacer(Modifier.height(16.dp))
 
+        OutlinedTextField(
+            value = tunMtu,
+            onValueChange = { v -> tunMtu = v.filter { it.isDigit() }.take(5) },
+            label = { Text("VPN MTU") },
+            singleLine = true,
+            keyboardOptions = KeyboardOptions(keyboardType = KeyboardType.Number),
+            modifier = Modifier.fillMaxWidth(),
+        )
+        FieldDescription("Packet size of the VPN interface (VPN mode only). Default: 1500. Try 1400 or 1280 if pages stall on mobile data or PPPoE.")
+
+        Spacer(Modifier.height(16.dp))
+
         Row(
             modifier = Modifier.fillMaxWidth(),
             verticalAlignment = Alignment.CenterVertically,
@@ -841,6 +873,163 @@ private fun ConfigEditContent(
             Switch(checked = enableUdp, onCheckedChange = { enableUdp = it })
         }
 
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Allow LAN", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Share the proxy with other devices on your Wi-Fi. Only local network addresses can connect.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = allowLan, onCheckedChange = { allowLan = it })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Bypass Local Network", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Reach printers, casting devices and other local addresses directly instead of through the tunnel.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = bypassLan, onCheckedChange = { bypassLan = it })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Tunnel over Cellular", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Reach the server over mobile data even while Wi-Fi is connected, e.g. behind a captive portal.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = useCellular, onCheckedChange = { useCellular = it })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Log to Logcat", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Also send the client log to logcat, for adb and bug report tools.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = logcat, onCheckedChange = { logcat = it })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Prefer IPv6", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Connect to the server over IPv6 when it has both. Use on IPv6-only mobile networks.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = preferIpv6, onCheckedChange = {
+                preferIpv6 = it
+                if (it) ipv4Only = false
+            })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("IPv4 Only", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Never connect to the server over IPv6. Use when IPv6 is broken on your network.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = ipv4Only, onCheckedChange = {
+                ipv4Only = it
+                if (it) preferIpv6 = false
+            })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Kill Switch", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Block all traffic while the tunnel is down instead of using your normal connection.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = killSwitch, onCheckedChange = {
+                killSwitch = it
+                if (it) allowDirectFallback = false
+            })
+        }
+
+        Spacer(Modifier.height(16.dp))
+
+        Row(
+            modifier = Modifier.fillMaxWidth(),
+            verticalAlignment = Alignment.CenterVertically,
+        ) {
+            Column(Modifier.weight(1f)) {
+                Text("Direct Fallback", style = MaterialTheme.typography.bodyLarge)
+                Text(
+                    "Connect directly, without the tunnel, while the server is unreachable. " +
+                        "Keeps apps online but exposes their traffic to your network.",
+                    style = MaterialTheme.typography.bodySmall,
+                    color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                )
+            }
+            Spacer(Modifier.width(8.dp))
+            Switch(checked = allowDirectFallback, onCheckedChange = {
+                allowDirectFallback = it
+                if (it) killSwitch = false
+            })
+        }
+
         Spacer(Modifier.height(32.dp))
 
         if (hasUnsavedChanges) {
@@ -868,6 +1057,16 @@ private fun ConfigEditContent(
                         authToken       = authToken.trim(),
                         tlsMode         = tlsMode,
                         fingerprint     = fingerprint,
+                        preferIpv6      = preferIpv6,
+                        ipv4Only        = ipv4Only,
+                        killSwitch      = killSwitch,
+                        allowDirectFallback = allowDirectFallback,
+                        allowLan        = allowLan,
+                        bypassLan       = bypassLan,
+                        useCellular     = useCellular,
+                        logcat          = logcat,
+                        tunMtu          = (tunMtu.trim().toIntOrNull() ?: ClientConfig.DEFAULT_TUN_MTU)
+                            .coerceIn(ClientConfig.MIN_TUN_MTU, 65535),
                     ),
                 )
                 onBack()
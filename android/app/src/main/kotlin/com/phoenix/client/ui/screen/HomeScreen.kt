@@ -236,6 +236,58 @@ fun HomeScreen(
                 }
             }
 
+            // ── Server notice banner ────────────────────────────────────────────
+            AnimatedVisibility(
+                visible = uiState.serverNotice != null,
+                enter = expandVertically() + fadeIn(),
+                exit = shrinkVertically() + fadeOut(),
+            ) {
+                Row(
+                    modifier = Modifier
+                        .fillMaxWidth()
+                        .padding(top = 12.dp)
+                        .height(IntrinsicSize.Min)
+                        .clip(RoundedCornerShape(10.dp))
+                        .background(Color(0xFF1C1600)),
+                    verticalAlignment = Alignment.CenterVertically,
+                ) {
+                    Box(
+                        modifier = Modifier
+                            .width(4.dp)
+                            .fillMaxHeight()
+                            .background(PhoenixOrange),
+                    )
+                    Column(
+                        modifier = Modifier
+                            .weight(1f)
+                            .padding(start = 14.dp, end = 8.dp, top = 12.dp, bottom = 12.dp),
+                    ) {
+                        Text(
+                            "Message from server",
+                            style = MaterialTheme.typography.titleSmall,
+                            color = Color.White,
+                        )
+                        Spacer(Modifier.height(4.dp))
+                        Text(
+                            uiState.serverNotice ?: "",
+                            style = MaterialTheme.typography.bodySmall,
+                            color = Color.White.copy(alpha = 0.7f),
+                        )
+                    }
+                    IconButton(
+                        onClick = viewModel::dismissServerNotice,
+                        modifier = Modifier.size(36.dp),
+                    ) {
+                        Icon(
+                            imageVector = Icons.Filled.Close,
+                            contentDescription = "Dismiss",
+                            tint = Color.White.copy(alpha = 0.4f),
+                            modifier = Modifier.size(16.dp),
+                        )
+                    }
+                }
+            }
+
             Spacer(Modifier.height(24.dp))
 
             // ── Status block — prominent, always visible ────────────────────────
@@ -372,7 +424,7 @@ private fun StatusBlock(
         ConnectionStatus.ERROR ->
             errorMessage ?: "An unknown error occurred"
         ConnectionStatus.CONNECTING ->
-            "Establishing secure tunnel…"
+            errorMessage ?: "Establishing secure tunnel…"
         ConnectionStatus.DISCONNECTED ->
             "Your traffic is not protected"
     }
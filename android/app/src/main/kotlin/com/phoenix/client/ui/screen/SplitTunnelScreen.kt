@@ -17,6 +17,8 @@ import androidx.compose.foundation.lazy.items
 import androidx.compose.material.icons.Icons
 import androidx.compose.material.icons.automirrored.filled.ArrowBack
 import androidx.compose.material3.CircularProgressIndicator
+import androidx.compose.material3.FilterChip
+import androidx.compose.material3.FilterChipDefaults
 import androidx.compose.material3.HorizontalDivider
 import androidx.compose.material3.Icon
 import androidx.compose.material3.IconButton
@@ -37,6 +39,7 @@ import androidx.compose.ui.text.style.TextOverflow
 import androidx.compose.ui.unit.dp
 import androidx.core.graphics.drawable.toBitmap
 import androidx.hilt.navigation.compose.hiltViewModel
+import com.phoenix.client.data.datastore.SplitTunnelMode
 import com.phoenix.client.domain.model.AppInfo
 import com.phoenix.client.ui.theme.PhoenixOrange
 import com.phoenix.client.ui.viewmodel.SplitTunnelViewModel
@@ -48,6 +51,8 @@ fun SplitTunnelScreen(
 ) {
     val isEnabled    by viewModel.isEnabled.collectAsState()
     val excludedApps by viewModel.excludedApps.collectAsState()
+    val includedApps by viewModel.includedApps.collectAsState()
+    val mode         by viewModel.mode.collectAsState()
     val installedApps by viewModel.installedApps.collectAsState()
 
     Column(modifier = Modifier.fillMaxSize()) {
@@ -105,6 +110,29 @@ fun SplitTunnelScreen(
             }
 
             if (isEnabled) {
+                // ── Mode: deny list vs allow list ─────────────────────────────
+                item(key = "mode") {
+                    Row(
+                        modifier = Modifier
+                            .fillMaxWidth()
+                            .padding(horizontal = 16.dp, vertical = 6.dp),
+                        horizontalArrangement = Arrangement.spacedBy(8.dp),
+                        verticalAlignment = Alignment.CenterVertically,
+                    ) {
+                        ModeChip("Bypass selected", SplitTunnelMode.EXCLUDE, mode) { viewModel.setMode(it) }
+                        ModeChip("Only selected", SplitTunnelMode.INCLUDE, mode) { viewModel.setMode(it) }
+                    }
+                    if (mode == SplitTunnelMode.INCLUDE && includedApps.isEmpty()) {
+                        Text(
+                            text = "No apps selected — every app will use the VPN until you pick some.",
+                            style = MaterialTheme.typography.bodySmall,
+                            color = MaterialTheme.colorScheme.onSurface.copy(alpha = 0.6f),
+                            modifier = Modifier.padding(horizontal = 16.dp, vertical = 4.dp),
+                        )
+                    }
+                    HorizontalDivider()
+                }
+
                 // ── Select all / Deselect all ─────────────────────────────────
                 item(key = "actions") {
                     Row(
@@ -114,8 +142,7 @@ fun SplitTunnelScreen(
                         horizontalArrangement = Arrangement.spacedBy(4.dp),
                         verticalAlignment = Alignment.CenterVertically,
                     ) {
-                        val throughVpn = installedApps.size - excludedApps
-                            .count { pkg -> installedApps.any { it.packageName == pkg } }
+                        val throughVpn = installedApps.count { isRouted(it.packageName, mode, excludedApps, includedApps) }
                         Text(
                             text = "$throughVpn of ${installedApps.size} apps through VPN",
                             style = MaterialTheme.typography.labelMedium,
@@ -148,7 +175,7 @@ fun SplitTunnelScreen(
                     items(installedApps, key = { it.packageName }) { app ->
                         AppRow(
                             app      = app,
-                            checked  = app.packageName !in excludedApps,
+                            checked  = isRouted(app.packageName, mode, excludedApps, includedApps),
                             onToggle = { viewModel.toggleApp(app.packageName) },
                         )
                     }
@@ -158,6 +185,34 @@ fun SplitTunnelScreen(
     }
 }
 
+/** Whether [packageName] goes through the VPN under the current selection. */
+private fun isRouted(
+    packageName: String,
+    mode: SplitTunnelMode,
+    excludedApps: Set<String>,
+    includedApps: Set<String>,
+): Boolean = when (mode) {
+    SplitTunnelMode.EXCLUDE -> packageName !in excludedApps
+    SplitTunnelMode.INCLUDE -> packageName in includedApps
+}
+
+@Composable
+private fun ModeChip(
+    label: String,
+    value: SplitTunnelMode,
+    current: SplitTunnelMode,
+    onSelect: (SplitTunnelMode) -> Unit,
+) {
+    FilterChip(
+        selected = current == value,
+        onClick = { onSelect(value) },
+        label = { Text(label) },
+        colors = FilterChipDefaults.filterChipColors(
+            selectedContainerColor = PhoenixOrange.copy(alpha = 0.2f),
+        ),
+    )
+}
+
 @Composable
 private fun AppRow(
     app: AppInfo,
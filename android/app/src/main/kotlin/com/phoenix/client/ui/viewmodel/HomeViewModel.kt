@@ -7,6 +7,7 @@ import androidx.lifecycle.AndroidViewModel
 import androidx.lifecycle.viewModelScope
 import com.phoenix.client.BuildConfig
 import com.phoenix.client.data.datastore.SplitTunnelDataStore
+import com.phoenix.client.data.datastore.SplitTunnelMode
 import com.phoenix.client.domain.model.ClientConfig
 import com.phoenix.client.domain.repository.ConfigRepository
 import com.phoenix.client.service.PhoenixService
@@ -49,6 +50,8 @@ data class HomeUiState(
     val updateDownloadUrl: String? = null,
     /** 0.0–1.0 while downloading, null when idle. */
     val updateDownloadProgress: Float? = null,
+    /** Latest notice pushed by the server, e.g. "maintenance: down on Sunday". */
+    val serverNotice: String? = null,
 )
 
 @HiltViewModel
@@ -81,6 +84,12 @@ class HomeViewModel @Inject constructor(
     private val excludedApps: StateFlow<Set<String>> = splitTunnelDataStore.excludedAppsFlow
         .stateIn(viewModelScope, SharingStarted.Eagerly, emptySet())
 
+    private val splitTunnelMode: StateFlow<SplitTunnelMode> = splitTunnelDataStore.modeFlow
+        .stateIn(viewModelScope, SharingStarted.Eagerly, SplitTunnelMode.EXCLUDE)
+
+    private val includedApps: StateFlow<Set<String>> = splitTunnelDataStore.includedAppsFlow
+        .stateIn(viewModelScope, SharingStarted.Eagerly, emptySet())
+
     private var uptimeJob: Job? = null
     private var timeoutJob: Job? = null
 
@@ -104,6 +113,13 @@ class HomeViewModel @Inject constructor(
                             it.copy(connectionStatus = ConnectionStatus.ERROR, errorMessage = event.message)
                         }
                     }
+                    // Shown as CONNECTING so the Cancel button can turn the kill switch off.
+                    is ServiceEvents.StatusEvent.Blocking -> {
+                        stopUptimeClock()
+                        _uiState.update {
+                            it.copy(connectionStatus = ConnectionStatus.CONNECTING, errorMessage = event.message)
+                        }
+                    }
                 }
             }
         }
@@ -118,6 +134,13 @@ class HomeViewModel @Inject constructor(
             }
         }
 
+        // Collect notices pushed by the server.
+        viewModelScope.launch {
+            ServiceEvents.notice.collect { notice ->
+                _uiState.update { it.copy(serverNotice = notice) }
+            }
+        }
+
         // Check for updates on launch.
         viewModelScope.launch {
             val info = UpdateChecker.getLatestUpdate()
@@ -148,6 +171,10 @@ class HomeViewModel @Inject constructor(
         }
     }
 
+    fun dismissServerNotice() {
+        _uiState.update { it.copy(serverNotice = null) }
+    }
+
     fun dismissUpdateBanner() {
         if (_uiState.value.updateDownloadProgress != null) return // don't dismiss while downloading
         _uiState.update { it.copy(updateAvailableVersion = null, updateDownloadUrl = null) }
@@ -243,7 +270,9 @@ class HomeViewModel @Inject constructor(
                     ctx,
                     config.value,
                     splitTunnelEnabled = splitTunnelEnabled.value,
+                    splitTunnelMode    = splitTunnelMode.value,
                     excludedApps       = excludedApps.value,
+                    includedApps       = includedApps.value,
                 ),
             )
         } else {
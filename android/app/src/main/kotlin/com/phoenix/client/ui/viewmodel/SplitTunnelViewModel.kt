@@ -5,6 +5,7 @@ import android.content.pm.PackageManager
 import androidx.lifecycle.AndroidViewModel
 import androidx.lifecycle.viewModelScope
 import com.phoenix.client.data.datastore.SplitTunnelDataStore
+import com.phoenix.client.data.datastore.SplitTunnelMode
 import com.phoenix.client.domain.model.AppInfo
 import dagger.hilt.android.lifecycle.HiltViewModel
 import kotlinx.coroutines.Dispatchers
@@ -32,6 +33,13 @@ class SplitTunnelViewModel @Inject constructor(
     val excludedApps: StateFlow<Set<String>> = dataStore.excludedAppsFlow
         .stateIn(viewModelScope, SharingStarted.WhileSubscribed(5_000), emptySet())
 
+    val mode: StateFlow<SplitTunnelMode> = dataStore.modeFlow
+        .stateIn(viewModelScope, SharingStarted.WhileSubscribed(5_000), SplitTunnelMode.EXCLUDE)
+
+    /** Package names of the only apps routed through the VPN in INCLUDE mode. */
+    val includedApps: StateFlow<Set<String>> = dataStore.includedAppsFlow
+        .stateIn(viewModelScope, SharingStarted.WhileSubscribed(5_000), emptySet())
+
     private val _installedApps = MutableStateFlow<List<AppInfo>>(emptyList())
     val installedApps: StateFlow<List<AppInfo>> = _installedApps.asStateFlow()
 
@@ -62,19 +70,39 @@ class SplitTunnelViewModel @Inject constructor(
         viewModelScope.launch { dataStore.setEnabled(enabled) }
     }
 
+    fun setMode(mode: SplitTunnelMode) {
+        viewModelScope.launch { dataStore.setMode(mode) }
+    }
+
+    /** Flips whether [packageName] goes through the VPN in the current mode. */
     fun toggleApp(packageName: String) {
-        viewModelScope.launch { dataStore.toggleApp(packageName) }
+        viewModelScope.launch {
+            when (mode.value) {
+                SplitTunnelMode.EXCLUDE -> dataStore.toggleApp(packageName)
+                SplitTunnelMode.INCLUDE -> dataStore.toggleIncludedApp(packageName)
+            }
+        }
     }
 
-    /** Routes ALL listed apps through VPN (clears the excluded set). */
+    /** Routes ALL listed apps through VPN. */
     fun includeAll() {
-        viewModelScope.launch { dataStore.setExcludedApps(emptySet()) }
+        viewModelScope.launch {
+            when (mode.value) {
+                SplitTunnelMode.EXCLUDE -> dataStore.setExcludedApps(emptySet())
+                SplitTunnelMode.INCLUDE ->
+                    dataStore.setIncludedApps(_installedApps.value.map { it.packageName }.toSet())
+            }
+        }
     }
 
-    /** Excludes ALL listed apps from VPN (bypasses every app). */
+    /** Takes ALL listed apps out of the VPN (bypasses every app). */
     fun excludeAll() {
         viewModelScope.launch {
-            dataStore.setExcludedApps(_installedApps.value.map { it.packageName }.toSet())
+            when (mode.value) {
+                SplitTunnelMode.EXCLUDE ->
+                    dataStore.setExcludedApps(_installedApps.value.map { it.packageName }.toSet())
+                SplitTunnelMode.INCLUDE -> dataStore.setIncludedApps(emptySet())
+            }
         }
     }
 }
@@ -1,9 +1,13 @@
 package com.phoenix.client.util
 
 import android.content.Context
+import android.net.ConnectivityManager
+import android.net.Network
+import android.net.NetworkCapabilities
 import com.phoenix.client.domain.model.ClientConfig
 import java.io.File
 import java.net.Inet4Address
+import java.net.Inet6Address
 import java.net.InetAddress
 import java.net.URI
 
@@ -39,7 +43,7 @@ object ConfigWriter {
         val logLine: String,
     )
 
-    private fun resolveAddr(addr: String): ResolveResult {
+    private fun resolveAddr(addr: String, preferIpv6: Boolean, ipv4Only: Boolean, network: Network?): ResolveResult {
         val trimmed = addr.trim()
 
         // Prepend a dummy scheme so java.net.URI can parse bare "host:port" strings.
@@ -72,8 +76,22 @@ object ConfigWriter {
 
         // Resolve via Android DNS (called on Dispatchers.IO — blocking is fine)
         return try {
-            val ip = (InetAddress.getAllByName(host).firstOrNull { it is Inet4Address }
-                ?: InetAddress.getByName(host)).hostAddress
+            // Default: IPv4 first (most reliable on mobile). IPv6-only carriers
+            // without 464XLAT need preferIpv6; ipv4Only skips IPv6 entirely.
+            // A network the tunnel is bound to answers for itself: a captive Wi-Fi's DNS
+            // would return the portal.
+            val all = network?.getAllByName(host) ?: InetAddress.getAllByName(host)
+            val v4 = all.firstOrNull { it is Inet4Address }
+            val v6 = all.firstOrNull { it is Inet6Address }
+            val chosen = when {
+                ipv4Only -> v4 ?: return ResolveResult(
+                    originalAddr, null,
+                    "DNS: '$host' has no IPv4 address (ipv4_only is set)",
+                )
+                preferIpv6 -> v6 ?: v4
+                else -> v4 ?: v6
+            } ?: InetAddress.getByName(host)
+            val ip = chosen.hostAddress
                 ?: return ResolveResult(
                     originalAddr, null,
                     "DNS: getHostAddress() returned null for '$host'",
@@ -86,6 +104,26 @@ object ConfigWriter {
         }
     }
 
+    /**
+     * Returns a connected cellular network and its interface name (e.g. "rmnet_data0"), or
+     * null when mobile data is down. Android only keeps it up next to Wi-Fi while
+     * "Mobile data always active" is on (the default on most devices).
+     */
+    private fun cellularNetwork(context: Context): Pair<Network, String>? {
+        val connectivity = context.getSystemService(ConnectivityManager::class.java) ?: return null
+        @Suppress("DEPRECATION")
+        return connectivity.allNetworks.firstNotNullOfOrNull { network ->
+            val caps = connectivity.getNetworkCapabilities(network)
+            if (caps == null || !caps.hasTransport(NetworkCapabilities.TRANSPORT_CELLULAR) ||
+                caps.hasTransport(NetworkCapabilities.TRANSPORT_VPN)
+            ) {
+                null
+            } else {
+                connectivity.getLinkProperties(network)?.interfaceName?.let { network to it }
+            }
+        }
+    }
+
     private fun hostPort(host: String, port: Int, fallback: String) =
         if (port > 0) "$host:$port" else fallback
 
@@ -94,7 +132,16 @@ object ConfigWriter {
 
     fun write(context: Context, config: ClientConfig): Result {
         val file = File(context.filesDir, CONFIG_FILE)
-        val resolved = resolveAddr(config.remoteAddr)
+        val cellular = if (config.useCellular) cellularNetwork(context) else null
+        val resolved = resolveAddr(config.remoteAddr, config.preferIpv6, config.ipv4Only, cellular?.first)
+        var resolveLog = resolved.logLine
+        if (config.useCellular) {
+            resolveLog += if (cellular != null) {
+                "\nCellular: binding the tunnel to ${cellular.second}"
+            } else {
+                "\nCellular: no mobile data network up, using the default network"
+            }
+        }
 
         val toml = buildString {
             // Keep the original domain for Host header and TLS SNI (required by Cloudflare/CDNs).
@@ -105,6 +152,11 @@ object ConfigWriter {
                 appendLine("dial_addr = \"${resolved.dialAddr}\"")
             }
 
+            // Key: "outbound_interface" — binds the connection to the server to this interface
+            if (cellular != null) {
+                appendLine("outbound_interface = \"${cellular.second}\"")
+            }
+
             if (config.privateKeyFile.isNotBlank()) {
                 val absPath = File(context.filesDir, config.privateKeyFile).absolutePath
                 // Key: "private_key" — matches toml:"private_key" in ClientConfig Go struct
@@ -123,10 +175,51 @@ object ConfigWriter {
                 appendLine("tls_mode = \"${config.tlsMode}\"")
             }
 
+            // Keys: "prefer_ipv6" / "ipv4_only" — match the ClientConfig Go struct tags
+            if (config.preferIpv6) {
+                appendLine("prefer_ipv6 = true")
+            }
+            if (config.ipv4Only) {
+                appendLine("ipv4_only = true")
+            }
+
+            // Keys: "kill_switch" / "allow_direct_fallback" — mutually exclusive in Go
+            if (config.killSwitch) {
+                appendLine("kill_switch = true")
+            } else if (config.allowDirectFallback) {
+                appendLine("allow_direct_fallback = true")
+            }
+
+            // Key: "allow_lan" — listens on 0.0.0.0 and only accepts private-range sources
+            if (config.allowLan) {
+                appendLine("allow_lan = true")
+            }
+
             if (config.fingerprint.isNotBlank()) {
                 appendLine("fingerprint = \"${config.fingerprint}\"")
             }
 
+            // Key: "bypass_private" in [routing] — on by default in Go
+            if (!config.bypassLan) {
+                appendLine()
+                appendLine("[routing]")
+                appendLine("bypass_private = false")
+            }
+
+            // Key: "mtu" in [tun] — must match the VpnService MTU; TCP MSS is clamped to fit
+            if (config.tunMtu != ClientConfig.DEFAULT_TUN_MTU) {
+                appendLine()
+                appendLine("[tun]")
+                appendLine("mtu = ${config.tunMtu}")
+            }
+
+            // Key: "sink" in [log] — copies every log line to logcat, tagged "phoenix"
+            if (config.logcat) {
+                appendLine()
+                appendLine("[log]")
+                appendLine("sink = \"logcat\"")
+            }
+
             appendLine()
             appendLine("[[inbounds]]")
             appendLine("protocol = \"socks5\"")
@@ -135,6 +228,6 @@ object ConfigWriter {
         }
 
         file.writeText(toml)
-        return Result(file, toml, resolved.logLine)
+        return Result(file, toml, resolveLog)
     }
 }
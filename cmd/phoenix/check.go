@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"phoenix/pkg/config"
+	"phoenix/pkg/crypto"
+)
+
+// runCheck implements the "check" subcommand. It loads a configuration file,
+// validates it and verifies that every referenced key can be loaded, without
+// opening any network connections.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", "client.toml", "Path to the configuration file to check")
+	isServer := fs.Bool("server", false, "Treat the file as a server configuration")
+	fs.Parse(args)
+
+	var problems []string
+	if *isServer {
+		problems = checkServerConfig(*configPath)
+	} else {
+		problems = checkClientConfig(*configPath)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Printf("FAIL: %s\n", p)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("OK: %s\n", *configPath)
+}
+
+func checkClientConfig(path string) []string {
+	cfg, err := config.LoadClientConfig(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if cfg.PrivateKeyPath != "" {
+		if _, err := crypto.LoadPrivateKey(cfg.PrivateKeyPath); err != nil {
+			problems = append(problems, fmt.Sprintf("private_key: %v", err))
+		}
+	}
+	if cfg.ServerPublicKey != "" {
+		if _, err := crypto.ParsePublicKey(cfg.ServerPublicKey); err != nil {
+			problems = append(problems, fmt.Sprintf("server_public_key: %v", err))
+		}
+	}
+	return problems
+}
+
+func checkServerConfig(path string) []string {
+	cfg, err := config.LoadServerConfig(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if cfg.Security.PrivateKeyPath != "" {
+		if _, err := crypto.LoadPrivateKey(cfg.Security.PrivateKeyPath); err != nil {
+			problems = append(problems, fmt.Sprintf("private_key: %v", err))
+		}
+	}
+	for _, k := range cfg.Security.AuthorizedClientKeys {
+		if _, err := crypto.ParsePublicKey(k); err != nil {
+			problems = append(problems, fmt.Sprintf("authorized_clients %q: %v", k, err))
+		}
+	}
+	return problems
+}
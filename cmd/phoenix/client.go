@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"phoenix/pkg/api"
+	"phoenix/pkg/config"
+	"phoenix/pkg/logbuf"
+	"phoenix/pkg/pac"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/systemd"
+	"phoenix/pkg/transport"
+	"phoenix/pkg/tun"
+	"phoenix/pkg/version"
+	"strings"
+	"syscall"
+)
+
+// runClient implements the "client" subcommand. Its flags are also what the
+// Android service passes when it launches the binary without a subcommand.
+func runClient(args []string) {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	configPath := fs.String("config", "client.toml", "Path to client configuration file")
+	filesDir := fs.String("files-dir", ".", "Directory for writing key files (use Android Context.getFilesDir())")
+	getSS := fs.Bool("get-ss", false, "Generate Shadowsocks config from client config")
+	genKeys := fs.Bool("gen-keys", false, "Generate a new pair of Ed25519 keys (public/private)")
+	keyName := fs.String("key-name", "client.private.key", "Output filename for the generated private key (used with -gen-keys)")
+	tunSocket := fs.String("tun-socket", "", "Abstract Unix socket name for receiving TUN fd via SCM_RIGHTS (VPN mode)")
+	tunDevice := fs.String("tun-device", "", "Create a TUN device with this name and route it like -tun-socket (utun on macOS, WinTun on Windows)")
+	controlStdin := fs.Bool("control-stdin", false, "Read control commands such as network-change from stdin (used by the Android service)")
+	readyFD := fs.Int("ready-fd", -1, "Write \"READY=1\" to this inherited file descriptor and close it once the inbounds are bound and the server answered")
+	fs.Parse(args)
+
+	if *genKeys {
+		writeKeypair(*filesDir, *keyName)
+		return
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if *getSS {
+		generateShadowsocksConfig(cfg)
+		return
+	}
+
+	logs := logbuf.New(logRingSize)
+	log.SetOutput(io.MultiWriter(logOutput(cfg.Log, os.Stderr), logs))
+
+	log.Println(version.String())
+	setLogLevel(cfg.LogLevel)
+	watchLogLevelSignal()
+	client := transport.NewClient(cfg)
+	log.Printf("Phoenix Client started. Connecting to %s", cfg.RemoteAddr)
+	switch {
+	case cfg.KillSwitch:
+		log.Println("[KillSwitch] Enabled: connections fail while the tunnel is down")
+	case cfg.AllowDirectFallback:
+		log.Println("[Fallback] Direct fallback enabled: SOCKS5 targets are dialed directly while the server is unreachable")
+	case len(cfg.Fallback.Hosts) > 0:
+		log.Printf("[Fallback] %d host pattern(s) are dialed directly after %v of server outage", len(cfg.Fallback.Hosts), cfg.Fallback.After)
+	}
+	if cfg.AllowLAN {
+		sources := "private networks"
+		if len(cfg.LANSources) > 0 {
+			sources = strings.Join(cfg.LANSources, ", ")
+		}
+		log.Printf("[LAN] Inbounds are shared with %s", sources)
+	}
+
+	go watchNetwork(client)
+	if *controlStdin {
+		go readControl(os.Stdin, client, logs)
+	}
+
+	started := 0
+	for _, in := range cfg.Inbounds {
+		if err := client.AddInbound(in); err != nil {
+			log.Println(err)
+			continue
+		}
+		started++
+	}
+
+	go notifyReady(client, started, *readyFD)
+
+	if cfg.API.Listen != "" {
+		go func() {
+			if err := api.ListenAndServe(cfg.API, client); err != nil {
+				log.Printf("[API] Stopped: %v", err)
+			}
+		}()
+	} else if started == 0 {
+		log.Fatalf("No inbound could be started")
+	}
+	if cfg.PAC.Listen != "" {
+		go func() {
+			if err := pac.ListenAndServe(cfg); err != nil {
+				log.Printf("[PAC] Stopped: %v", err)
+			}
+		}()
+	}
+
+	if *tunSocket != "" || *tunDevice != "" {
+		// ── VPN mode ─────────────────────────────────────────────────────────
+		// The netstack routes into the first SOCKS5 inbound, which AddInbound
+		// has already bound, so no packet arrives before the proxy is ready.
+		// Use 127.0.0.1 as the connect target regardless of the bind address:
+		// 0.0.0.0/:: are valid bind addresses but not valid TCP connect targets.
+		socksAddr := "127.0.0.1:1080"
+		for _, in := range cfg.Inbounds {
+			if in.Protocol == protocol.ProtocolSOCKS5 {
+				host, port, err := net.SplitHostPort(in.LocalAddr)
+				if err == nil {
+					if host == "0.0.0.0" || host == "::" || host == "" {
+						host = "127.0.0.1"
+					}
+					socksAddr = net.JoinHostPort(host, port)
+				}
+				break
+			}
+		}
+
+		if *tunDevice != "" {
+			dev, err := tun.Open(*tunDevice, tun.MTU(cfg.TUN))
+			if err != nil {
+				log.Fatalf("Failed to create TUN device %q: %v", *tunDevice, err)
+			}
+			log.Printf("TUN device %s created, starting netstack → socks5://%s", dev.Name(), socksAddr)
+			log.Printf("[TUN] Assign %s an address and route traffic into it; keep the route to the server outside", dev.Name())
+			if err := tun.StartDevice(dev, socksAddr, cfg.TUN); err != nil {
+				log.Fatalf("Failed to start netstack: %v", err)
+			}
+		} else {
+			tunFd, err := receiveTunFd(*tunSocket)
+			if err != nil {
+				log.Fatalf("Failed to receive TUN fd: %v", err)
+			}
+			log.Printf("TUN fd received (%d), starting netstack → socks5://%s", tunFd, socksAddr)
+
+			if err := tun.Start(tunFd, socksAddr, cfg.TUN); err != nil {
+				log.Fatalf("Failed to start netstack: %v", err)
+			}
+		}
+	}
+
+	// Inbounds come and go via the API. SIGINT and SIGTERM, which the
+	// Android service sends to stop, let open streams finish first.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Received %v, shutting down", <-sig)
+	if err := client.Close(); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+}
+
+// notifyReady waits for the client's first server check, then tells
+// whoever waits for the client: the log line the Android service watches
+// for, systemd, and readyFD (negative = none).
+func notifyReady(client *transport.Client, inbounds, readyFD int) {
+	<-client.Ready()
+	log.Printf("[Ready] %d inbounds listening, server reachable", inbounds)
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		log.Printf("[systemd] READY notification failed: %v", err)
+	}
+	if readyFD >= 0 {
+		f := os.NewFile(uintptr(readyFD), "ready-fd")
+		if _, err := f.WriteString("READY=1\n"); err != nil {
+			log.Printf("[Ready] Failed to write to fd %d: %v", readyFD, err)
+		}
+		f.Close()
+	}
+}
+
+func generateShadowsocksConfig(cfg *config.ClientConfig) {
+	found := false
+	for _, in := range cfg.Inbounds {
+		if in.Protocol == protocol.ProtocolShadowsocks {
+			found = true
+			if in.Auth == "" {
+				fmt.Println("Error: Shadowsocks inbound found but 'auth' (method:password) is empty.")
+				continue
+			}
+			userInfo := base64.URLEncoding.EncodeToString([]byte(in.Auth))
+			link := fmt.Sprintf("ss://%s@%s#Phoenix-Client", userInfo, in.LocalAddr)
+			fmt.Println("Shadowsocks Configuration:")
+			fmt.Println(link)
+		}
+	}
+	if !found {
+		fmt.Println("No Shadowsocks inbound found in configuration.")
+	}
+}
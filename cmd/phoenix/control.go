@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"phoenix/pkg/config"
+	"phoenix/pkg/logbuf"
+	"phoenix/pkg/logfile"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/logsink"
+	"phoenix/pkg/netmon"
+	"phoenix/pkg/transport"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Number of recent log lines kept for dump-logs.
+const logRingSize = 1000
+
+// watchNetwork reconnects client whenever the OS reports a network change.
+func watchNetwork(client *transport.Client) {
+	err := netmon.Watch(client.NotifyNetworkChange)
+	log.Printf("[NetMon] Network change monitoring unavailable: %v", err)
+}
+
+// readControl handles line commands from the process that launched us (the
+// Android service writes them to our stdin):
+//
+//	network-change   the default network switched; reconnect now
+//	low-power on     the app is backgrounded or the device dozes; ping rarely
+//	low-power off    back in the foreground
+//	dump-logs FILE   write the recent log lines to FILE, e.g. for a bug report
+//	stats            log the traffic counters of each inbound and the server's health
+//	log-level LEVEL  switch the log to "info" or "debug"
+//	flush-dns        empty the DNS cache of the client's own lookups
+func readControl(r io.Reader, client *transport.Client, logs *logbuf.Ring) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		switch cmd := strings.TrimSpace(scanner.Text()); cmd {
+		case "":
+		case "network-change":
+			client.NotifyNetworkChange()
+		case "low-power on":
+			client.SetLowPower(true)
+		case "low-power off":
+			client.SetLowPower(false)
+		case "stats":
+			logInboundStats(client)
+		case "flush-dns":
+			log.Printf("[DNS] Flushed %d cached names", client.FlushDNS())
+		default:
+			if path, ok := strings.CutPrefix(cmd, "dump-logs "); ok {
+				dumpLogs(logs, strings.TrimSpace(path))
+				continue
+			}
+			if level, ok := strings.CutPrefix(cmd, "log-level "); ok {
+				setLogLevel(strings.TrimSpace(level))
+				continue
+			}
+			log.Printf("[Control] Unknown command %q", cmd)
+		}
+	}
+}
+
+// logInboundStats logs one line per inbound, in name order, then the
+// server's health.
+func logInboundStats(client *transport.Client) {
+	stats := client.InboundStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := stats[name]
+		log.Printf("[Stats] Inbound %s: %d active, %d total connections, %d bytes in, %d bytes out",
+			name, s.Active, s.Connections, s.BytesIn, s.BytesOut)
+	}
+	if h := client.Health(); h.Enabled {
+		state := "closed"
+		if h.Open {
+			state = "open"
+		}
+		log.Printf("[Stats] Server: score %.2f over %d dials, %v to answer, circuit breaker %s",
+			h.Score, h.Dials, h.Latency.Round(time.Millisecond), state)
+	}
+}
+
+// dumpLogs writes the lines in logs to path, replacing the file.
+func dumpLogs(logs *logbuf.Ring, path string) {
+	lines := logs.Lines()
+	data := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		log.Printf("[Control] dump-logs failed: %v", err)
+		return
+	}
+	log.Printf("[Control] Wrote %d log lines to %s", len(lines), path)
+}
+
+// setLogLevel applies a log_level setting, keeping the current level if it
+// is invalid.
+func setLogLevel(name string) {
+	level, err := loglevel.Parse(name)
+	if err != nil {
+		log.Printf("[Log] %v", err)
+		return
+	}
+	loglevel.Set(level)
+}
+
+// logOutput returns the [log] file when one is configured, reopened on
+// SIGUSR2, and fallback otherwise, copied to the log sink if there is one.
+func logOutput(cfg config.LogOutput, fallback io.Writer) io.Writer {
+	out := fallback
+	if cfg.File != "" {
+		f, err := logfile.Open(cfg)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		watchReopenSignal(f)
+		out = f
+	}
+	sink, err := logsink.Open(cfg)
+	if err != nil {
+		log.Printf("[Log] Failed to open log sink %s: %v", cfg.Sink, err)
+		return out
+	}
+	if sink == nil {
+		return out
+	}
+	// The sink goes last: the writers after a failing one are skipped.
+	return io.MultiWriter(out, sink)
+}
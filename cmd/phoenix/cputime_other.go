@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// processCPU is only implemented on Unix platforms.
+func processCPU() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/transport"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// doctorTimeout bounds each network step of the doctor subcommand.
+const doctorTimeout = 10 * time.Second
+
+// runDoctor implements the "doctor" subcommand. It checks the connection to
+// the server one layer at a time and prints a result per step, stopping at
+// the first failure since every later step depends on it.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", "client.toml", "Path to the client configuration file")
+	target := fs.String("target", "example.com:80", "HTTP server to fetch through the tunnel")
+	dnsServer := fs.String("dns", "1.1.1.1:53", "DNS server to query through a UDP association")
+	verbose := fs.Bool("v", false, "Show transport logs")
+	fs.Parse(args)
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		fatalf("FAIL: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatalf("FAIL: %v", err)
+	}
+
+	d := &doctor{cfg: cfg}
+	d.step("DNS resolution", d.checkDNS)
+	d.step("TCP connection", d.checkTCP)
+	client := transport.NewClient(cfg)
+	d.step("TLS handshake", func() (string, error) { return client.ProbeTLS() })
+
+	// One stream covers both: the server checks the token before it dials.
+	var stream io.ReadWriteCloser
+	var dialErr error
+	d.step("Authentication", func() (string, error) {
+		stream, dialErr = client.Dial(protocol.ProtocolSOCKS5, *target)
+		if errors.Is(dialErr, transport.ErrUnauthorized) || errors.Is(dialErr, transport.ErrServerUnreachable) {
+			return "", dialErr
+		}
+		return "accepted", nil
+	})
+	d.step("Tunnel stream", func() (string, error) {
+		if dialErr != nil {
+			return "", dialErr
+		}
+		defer stream.Close()
+		return doctorHTTP(stream, *target)
+	})
+	d.step("UDP associate", func() (string, error) {
+		return doctorUDP(client, *dnsServer)
+	})
+
+	if d.failed {
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed.")
+}
+
+// doctor runs the checks of runDoctor in order.
+type doctor struct {
+	cfg    *config.ClientConfig
+	failed bool // A step failed; later steps are skipped
+}
+
+func (d *doctor) step(name string, check func() (string, error)) {
+	if d.failed {
+		fmt.Printf("SKIP  %s\n", name)
+		return
+	}
+	start := time.Now()
+	detail, err := check()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		d.failed = true
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+		return
+	}
+	fmt.Printf("PASS  %s: %s (%v)\n", name, detail, elapsed)
+}
+
+// dialAddr is the address the client opens its TCP connection to.
+func (d *doctor) dialAddr() string {
+	if d.cfg.DialAddr != "" {
+		return d.cfg.DialAddr
+	}
+	return d.cfg.RemoteAddr
+}
+
+func (d *doctor) checkDNS() (string, error) {
+	if len(d.cfg.Chain) > 0 {
+		return "skipped, the server is reached through chain hops", nil
+	}
+	host, _, err := net.SplitHostPort(d.dialAddr())
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return host + " is an IP address", nil
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s -> %s", host, strings.Join(addrs, ", ")), nil
+}
+
+func (d *doctor) checkTCP() (string, error) {
+	if len(d.cfg.Chain) > 0 {
+		return "skipped, the server is reached through chain hops", nil
+	}
+	conn, err := net.DialTimeout("tcp", d.dialAddr(), doctorTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return "connected to " + conn.RemoteAddr().String(), nil
+}
+
+// doctorHTTP sends a HEAD request over stream and returns the status line.
+func doctorHTTP(stream io.ReadWriteCloser, target string) (string, error) {
+	host, _, _ := net.SplitHostPort(target)
+	timer := time.AfterFunc(doctorTimeout, func() { stream.Close() })
+	defer timer.Stop()
+	if _, err := fmt.Fprintf(stream, "HEAD / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadAtLeast(stream, buf, 12)
+	if err != nil {
+		return "", fmt.Errorf("no response from %s: %v", target, err)
+	}
+	line, _, _ := strings.Cut(string(buf[:n]), "\r\n")
+	if !strings.HasPrefix(line, "HTTP/") {
+		return "", fmt.Errorf("unexpected response from %s: %q", target, line)
+	}
+	return fmt.Sprintf("%s answered %q", target, line), nil
+}
+
+// doctorUDP starts a UDP-enabled SOCKS5 inbound, associates through it and
+// sends a DNS query for example.com to server.
+func doctorUDP(client *transport.Client, server string) (string, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return "", err
+	}
+	proxy, err := startLoopbackInbound(client)
+	if err != nil {
+		return "", err
+	}
+	ctrl, relay, err := socks5Associate(proxy)
+	if err != nil {
+		return "", err
+	}
+	defer ctrl.Close()
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	query, err := doctorDNSQuery()
+	if err != nil {
+		return "", err
+	}
+	header := socks5UDPHeader(serverAddr)
+	conn.SetDeadline(time.Now().Add(doctorTimeout))
+	buf := make([]byte, 65535)
+	// Datagrams may be lost; retry a few times within the timeout.
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(append(header, query...)); err != nil {
+			return "", err
+		}
+		conn.SetReadDeadline(time.Now().Add(doctorTimeout / 3))
+		n, err := conn.Read(buf)
+		if err != nil {
+			continue
+		}
+		var msg dnsmessage.Message
+		if n <= len(header) || msg.Unpack(buf[len(header):n]) != nil || !msg.Response {
+			return "", fmt.Errorf("malformed DNS answer from %s", server)
+		}
+		return fmt.Sprintf("%s answered a DNS query (%d records)", server, len(msg.Answers)), nil
+	}
+	return "", fmt.Errorf("no DNS answer from %s", server)
+}
+
+func doctorDNSQuery() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(time.Now().UnixNano()), RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("example.com."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	return b.Finish()
+}
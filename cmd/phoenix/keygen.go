@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/crypto"
+)
+
+// runKeygen implements the "keygen" subcommand.
+func runKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	filesDir := fs.String("files-dir", ".", "Directory to write the private key into")
+	keyName := fs.String("key-name", "client.private.key", "Output filename for the generated private key")
+	ecdsa := fs.Bool("ecdsa", false, "Generate an ECDSA P256 server key instead of Ed25519 (compatible with browser fingerprints)")
+	fs.Parse(args)
+
+	if *ecdsa {
+		priv, err := crypto.GenerateECDSAKey()
+		if err != nil {
+			log.Fatalf("Failed to generate keys: %v", err)
+		}
+		keyPath := filepath.Join(*filesDir, *keyName)
+		if err := os.WriteFile(keyPath, priv, 0600); err != nil {
+			log.Fatalf("Failed to save private key: %v", err)
+		}
+		fmt.Printf("KEY_PATH=%s\n", keyPath)
+		return
+	}
+
+	writeKeypair(*filesDir, *keyName)
+}
+
+// writeKeypair generates an Ed25519 keypair, stores the private key in
+// filesDir/keyName and prints the key path and public key to stdout.
+func writeKeypair(filesDir, keyName string) {
+	priv, pub, err := crypto.GenerateKeypair()
+	if err != nil {
+		log.Fatalf("Failed to generate keys: %v", err)
+	}
+	keyPath := filepath.Join(filesDir, keyName)
+	if err := os.WriteFile(keyPath, priv, 0600); err != nil {
+		log.Fatalf("Failed to save private key: %v", err)
+	}
+	// Print to stdout so the Android Service can read the public key.
+	fmt.Printf("KEY_PATH=%s\n", keyPath)
+	fmt.Printf("PUBLIC_KEY=%s\n", pub)
+}
@@ -0,0 +1,91 @@
+// Command phoenix is the single Phoenix binary. Every tool ships as a
+// subcommand so that one artifact serves servers, desktop clients and the
+// Android app (where it is packaged as libphoenixclient.so):
+//
+//	phoenix server    -config server.toml
+//	phoenix client    -config client.toml
+//	phoenix keygen    -files-dir . -key-name client.private.key
+//	phoenix speedtest -size 256
+//	phoenix check     -config client.toml
+//	phoenix doctor    -config client.toml
+//	phoenix install-service -role server -config /etc/phoenix/server.toml
+//	phoenix version
+//
+// For compatibility with the Android service, which launches the binary with
+// bare flags (e.g. "-config x -files-dir y"), an invocation whose first
+// argument is a flag runs the client subcommand.
+package main
+
+import (
+	"fmt"
+	"os"
+	"phoenix/pkg/version"
+	"strings"
+)
+
+// command is a single phoenix subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{"server", "Run a Phoenix server", runServer},
+	{"client", "Run a Phoenix client (default when invoked with flags only)", runClient},
+	{"keygen", "Generate an Ed25519 keypair", runKeygen},
+	{"speedtest", "Benchmark the tunnel over loopback", runSpeedtest},
+	{"check", "Validate a client or server configuration file", runCheck},
+	{"doctor", "Diagnose the connection to the server step by step", runDoctor},
+	{"install-service", "Install phoenix as a boot-time service (systemd, Windows SCM, launchd)", runInstallService},
+	{"uninstall-service", "Stop and remove an installed service", runUninstallService},
+	{"run-as-service", "Entry point used by the service manager", runRunAsService},
+	{"version", "Print version and build information", runVersion},
+}
+
+func main() {
+	args := os.Args[1:]
+
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		runVersion(nil)
+		return
+	}
+
+	// Legacy invocation: no subcommand, flags only → client.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			usage()
+			return
+		}
+		runClient(args)
+		return
+	}
+
+	name := args[0]
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "phoenix: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func runVersion(args []string) {
+	fmt.Println(version.String())
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "%s\n\nUsage: phoenix <command> [flags]\n\nCommands:\n", version.String())
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'phoenix <command> -h' for command flags.\n")
+}
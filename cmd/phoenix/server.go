@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"phoenix/pkg/api"
+	"phoenix/pkg/config"
+	"phoenix/pkg/sandbox"
+	"phoenix/pkg/systemd"
+	"phoenix/pkg/transport"
+	"phoenix/pkg/userdb"
+	"phoenix/pkg/version"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runServer implements the "server" subcommand.
+func runServer(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	configPath := fs.String("config", "server.toml", "Path to server configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	log.SetOutput(logOutput(cfg.Log, log.Writer()))
+	log.Println(version.String())
+	setLogLevel(cfg.LogLevel)
+	watchLogLevelSignal()
+	log.Printf("Phoenix Server starting on %s", cfg.ListenAddr)
+
+	ln, err := serverListener(cfg.ListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.ListenAddr, err)
+	}
+
+	// Privileges go once the port is bound, before any client is served.
+	read, write := hardeningPaths(*configPath, cfg)
+	if err := sandbox.Apply(cfg.Hardening, read, write); err != nil {
+		log.Fatalf("Failed to apply [hardening]: %v", err)
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		s := <-sig
+		log.Printf("Received %v, shutting down", s)
+		systemd.Notify(systemd.Stopping)
+		if transport.Announce(transport.Notice{Kind: transport.NoticeShutdown, Message: "server shutting down"}) > 0 {
+			grace := cfg.ShutdownGrace
+			if grace <= 0 {
+				grace = time.Second
+			}
+			time.Sleep(grace)
+		}
+		os.Exit(0)
+	}()
+
+	if cfg.API.Listen != "" {
+		var users *userdb.DB
+		if cfg.UserDB != "" {
+			if users, err = userdb.Open(cfg.UserDB); err != nil {
+				log.Fatalf("Failed to open user_db: %v", err)
+			}
+		}
+		go func() {
+			if err := api.ListenAndServeServer(cfg.API, users); err != nil {
+				log.Printf("[API] Stopped: %v", err)
+			}
+		}()
+	}
+
+	// READY waits for Serve to load the keys and TLS config, so a bad key
+	// fails the start instead of looking like one.
+	ready := func(healthy func() error) {
+		if _, err := systemd.Notify(systemd.Ready); err != nil {
+			log.Printf("[systemd] READY notification failed: %v", err)
+		}
+		systemd.StartWatchdog(healthy)
+	}
+	if err := transport.ServeWith(cfg, ln, transport.ServerOptions{Ready: ready}); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// serverListener returns the listening socket systemd passed, so the
+// server needs no right to bind privileged ports and its socket survives
+// restarts, or else binds addr.
+func serverListener(addr string) (net.Listener, error) {
+	lns, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(lns) > 0 {
+		for _, extra := range lns[1:] {
+			log.Printf("[systemd] Ignoring extra socket-activated listener %s", extra.Addr())
+			extra.Close()
+		}
+		log.Printf("[systemd] Using socket-activated listener %s instead of listen_addr", lns[0].Addr())
+		return lns[0], nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// hardeningPaths lists what Landlock must leave readable (the config, its
+// keys and static files, /etc for DNS) and writable (the directories of
+// the files the server creates or replaces).
+func hardeningPaths(configPath string, cfg *config.ServerConfig) (read, write []string) {
+	read = []string{"/etc", configPath}
+	files := []string{cfg.UserDB, cfg.Log.File, cfg.Quota.StateFile}
+	for _, path := range []string{cfg.Security.PrivateKeyPath, cfg.Fallback.Root} {
+		if path != "" {
+			read = append(read, path)
+		}
+	}
+	for _, vh := range cfg.VHosts {
+		if vh.PrivateKeyPath != "" {
+			read = append(read, vh.PrivateKeyPath)
+		}
+	}
+	for _, g := range cfg.GeoData {
+		files = append(files, g.Path)
+	}
+	if path, ok := strings.CutPrefix(cfg.API.Listen, config.UnixPrefix); ok {
+		files = append(files, path)
+	}
+	for _, path := range files {
+		if path != "" {
+			write = append(write, filepath.Dir(path))
+		}
+	}
+	return read, write
+}
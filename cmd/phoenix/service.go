@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// serviceOptions describes a phoenix OS service. Each platform implements
+// installService, uninstallService and runAsService in service_<os>.go:
+// systemd on Linux, the Service Control Manager on Windows and launchd on
+// macOS.
+type serviceOptions struct {
+	Name    string // service / unit / launchd label suffix
+	Role    string // "server" or "client"
+	Config  string // absolute path of the configuration file
+	Binary  string // absolute path of this executable
+	User    string // account to run as (platform specific, optional)
+	LogFile string // log destination when the platform has no journal
+
+	UnitDir  string // systemd: unit directory
+	Watchdog string // systemd: WatchdogSec= value
+	Socket   bool   // systemd: socket-activate the server's listen_addr
+	PerUser  bool   // launchd: install a LaunchAgent instead of a LaunchDaemon
+
+	DryRun bool // print what would be installed instead of doing it
+	Start  bool // start the service right after installing it
+}
+
+// roleArgs returns the command line the service manager runs.
+func (o serviceOptions) roleArgs() []string {
+	args := []string{"run-as-service", "-name", o.Name, "-role", o.Role, "-config", o.Config}
+	if o.LogFile != "" {
+		args = append(args, "-log", o.LogFile)
+	}
+	return args
+}
+
+func parseServiceFlags(cmd string, args []string) serviceOptions {
+	var o serviceOptions
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.StringVar(&o.Name, "name", "phoenix", "Service name")
+	fs.StringVar(&o.Role, "role", defaultServiceRole, "What the service runs: server or client")
+	fs.StringVar(&o.Config, "config", "", "Configuration file the service will use (default depends on -role)")
+	fs.StringVar(&o.User, "user", "", "Account to run the service as (default: platform specific)")
+	fs.StringVar(&o.LogFile, "log", "", "Log file for platforms without a system journal (Windows, macOS)")
+	fs.StringVar(&o.UnitDir, "unit-dir", "/etc/systemd/system", "Linux: directory to write the unit file into")
+	fs.StringVar(&o.Watchdog, "watchdog", "30s", "Linux: WatchdogSec= value; the server pings at half this interval")
+	fs.BoolVar(&o.Socket, "socket", false, "Linux: let a .socket unit bind the server's listen_addr, so the server needs no bind capability and keeps its socket across restarts")
+	fs.BoolVar(&o.PerUser, "per-user", false, "macOS: install a LaunchAgent for the current user instead of a boot-time LaunchDaemon")
+	fs.BoolVar(&o.DryRun, "dry-run", false, "Print what would be installed without changing the system")
+	fs.BoolVar(&o.Start, "enable", false, "Start the service immediately after installing it")
+	fs.Parse(args)
+
+	if o.Role != "server" && o.Role != "client" {
+		log.Fatalf("Invalid -role %q: expected server or client", o.Role)
+	}
+	if o.Config == "" {
+		o.Config = defaultServiceConfig(o.Role)
+	}
+	abs, err := filepath.Abs(o.Config)
+	if err != nil {
+		log.Fatalf("Invalid config path: %v", err)
+	}
+	o.Config = abs
+
+	binary, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to determine binary path: %v", err)
+	}
+	if binary, err = filepath.EvalSymlinks(binary); err != nil {
+		log.Fatalf("Failed to resolve binary path: %v", err)
+	}
+	o.Binary = binary
+	return o
+}
+
+// runInstallService implements the "install-service" subcommand.
+func runInstallService(args []string) {
+	if err := installService(parseServiceFlags("install-service", args)); err != nil {
+		log.Fatalf("Install failed: %v", err)
+	}
+}
+
+// runUninstallService implements the "uninstall-service" subcommand.
+func runUninstallService(args []string) {
+	if err := uninstallService(parseServiceFlags("uninstall-service", args)); err != nil {
+		log.Fatalf("Uninstall failed: %v", err)
+	}
+}
+
+// runRunAsService implements the "run-as-service" subcommand: the entry
+// point service managers invoke. On Windows it speaks the SCM protocol; on
+// other platforms it simply runs the role in the foreground.
+func runRunAsService(args []string) {
+	o := parseServiceFlags("run-as-service", args)
+	if o.LogFile != "" {
+		f, err := os.OpenFile(o.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		log.SetOutput(f)
+	}
+	if err := runAsService(o); err != nil {
+		log.Fatalf("Service failed: %v", err)
+	}
+}
+
+// runRole runs the server or client subcommand for a service.
+func runRole(o serviceOptions) {
+	args := []string{"-config", o.Config}
+	if o.Role == "server" {
+		runServer(args)
+		return
+	}
+	runClient(args)
+}
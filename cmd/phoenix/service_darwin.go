@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const defaultServiceRole = "client"
+
+func defaultServiceConfig(role string) string {
+	return "/usr/local/etc/phoenix/" + role + ".toml"
+}
+
+var launchdPlist = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{.}}</string>
+{{- end}}
+	</array>
+{{- if .User}}
+	<key>UserName</key>
+	<string>{{.User}}</string>
+{{- end}}
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+	<key>StandardOutPath</key>
+	<string>{{.LogFile}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.LogFile}}</string>
+</dict>
+</plist>
+`))
+
+func launchdLabel(o serviceOptions) string {
+	return "com.phoenix." + o.Name
+}
+
+// plistPath returns the LaunchDaemon (boot, root) or LaunchAgent (login,
+// current user) location for the service.
+func plistPath(o serviceOptions) (string, error) {
+	dir := "/Library/LaunchDaemons"
+	if o.PerUser {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, "Library", "LaunchAgents")
+	}
+	return filepath.Join(dir, launchdLabel(o)+".plist"), nil
+}
+
+// installService writes a launchd plist and optionally loads it.
+func installService(o serviceOptions) error {
+	if o.LogFile == "" {
+		o.LogFile = "/usr/local/var/log/phoenix-" + o.Name + ".log"
+		if o.PerUser {
+			home, _ := os.UserHomeDir()
+			o.LogFile = filepath.Join(home, "Library", "Logs", "phoenix-"+o.Name+".log")
+		}
+	}
+	path, err := plistPath(o)
+	if err != nil {
+		return err
+	}
+
+	// launchd captures stdout/stderr itself, so keep -log out of the arguments.
+	args := []string{o.Binary, "run-as-service", "-name", o.Name, "-role", o.Role, "-config", o.Config}
+	var plist strings.Builder
+	err = launchdPlist.Execute(&plist, map[string]interface{}{
+		"Label":   launchdLabel(o),
+		"Args":    args,
+		"User":    o.User,
+		"LogFile": o.LogFile,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render plist: %v", err)
+	}
+
+	if o.DryRun {
+		fmt.Print(plist.String())
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.LogFile), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(plist.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+
+	if !o.Start {
+		fmt.Printf("Run: launchctl load -w %s\n", path)
+		return nil
+	}
+	if err := launchctl("load", "-w", path); err != nil {
+		return err
+	}
+	fmt.Printf("Service %s loaded\n", launchdLabel(o))
+	return nil
+}
+
+// uninstallService unloads the job and removes its plist.
+func uninstallService(o serviceOptions) error {
+	path, err := plistPath(o)
+	if err != nil {
+		return err
+	}
+	if o.DryRun {
+		fmt.Printf("Would run 'launchctl unload -w %s' and remove it\n", path)
+		return nil
+	}
+	if err := launchctl("unload", "-w", path); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	fmt.Printf("Service %s removed\n", launchdLabel(o))
+	return nil
+}
+
+// runAsService runs the role in the foreground; launchd supervises it.
+func runAsService(o serviceOptions) error {
+	runRole(o)
+	return nil
+}
+
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("launchctl %s failed: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+const defaultServiceRole = "server"
+
+func defaultServiceConfig(role string) string {
+	return "/etc/phoenix/" + role + ".toml"
+}
+
+// systemdUnit is a hardened unit for phoenix. The service runs as an
+// unprivileged user; the server keeps only CAP_NET_BIND_SERVICE so it can
+// bind :443 (none with -socket, where systemdSocket binds it), plus
+// CAP_NET_ADMIN for fwmark, and reports readiness/liveness via sd_notify
+// (Type=notify + WatchdogSec). The file system is read-only but for the
+// directories of the files the config writes (see writablePaths).
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=Phoenix tunnel {{.Role}}
+Documentation=https://Fox-Fig.github.io/phoenix/
+After=network-online.target
+Wants=network-online.target
+{{- if .Socket}}
+Requires={{.Socket}}
+After={{.Socket}}
+{{- end}}
+
+[Service]
+{{- if eq .Role "server"}}
+Type=notify
+NotifyAccess=main
+WatchdogSec={{.Watchdog}}
+{{- else}}
+Type=simple
+{{- end}}
+ExecStart={{.ExecStart}}
+Restart=on-failure
+RestartSec=5s
+{{- if .User}}
+User={{.User}}
+Group={{.User}}
+{{- else}}
+DynamicUser=yes
+{{- end}}
+{{- if .Capabilities}}
+AmbientCapabilities={{.Capabilities}}
+CapabilityBoundingSet={{.Capabilities}}
+{{- else}}
+CapabilityBoundingSet=
+{{- end}}
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+{{- if .ConfigDir}}
+ReadOnlyPaths={{.ConfigDir}}
+{{- end}}
+{{- if .WritablePaths}}
+ReadWritePaths={{.WritablePaths}}
+{{- end}}
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// systemdSocket holds the server's listening socket for -socket: systemd
+// binds it, passes it on (LISTEN_FDS) and keeps it open across restarts.
+var systemdSocket = template.Must(template.New("socket").Parse(`[Unit]
+Description=Phoenix tunnel server socket
+Documentation=https://Fox-Fig.github.io/phoenix/
+
+[Socket]
+ListenStream={{.Listen}}
+NoDelay=yes
+
+[Install]
+WantedBy=sockets.target
+`))
+
+func unitPath(o serviceOptions) string {
+	return filepath.Join(o.UnitDir, o.Name+".service")
+}
+
+func socketPath(o serviceOptions) string {
+	return filepath.Join(o.UnitDir, o.Name+".socket")
+}
+
+// listenStream turns listen_addr into a ListenStream= value: systemd takes
+// a bare port for all addresses.
+func listenStream(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return port
+	}
+	return addr
+}
+
+// writablePaths returns the directories of the files the role's config
+// writes: the log, quota state, user_db and geodata caches. A config that
+// fails to load writes none.
+func writablePaths(role, path string) []string {
+	var files []string
+	switch role {
+	case "server":
+		cfg, err := config.LoadServerConfig(path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, cfg.Log.File, cfg.Quota.StateFile, cfg.UserDB)
+		for _, g := range cfg.GeoData {
+			files = append(files, g.Path)
+		}
+	case "client":
+		cfg, err := config.LoadClientConfig(path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, cfg.Log.File)
+		for _, g := range cfg.GeoData {
+			files = append(files, g.Path)
+		}
+	}
+	var dirs []string
+	for _, f := range files {
+		// Whole directories: rotation, SQLite journals and atomic
+		// replaces create files next to these.
+		if f != "" && !slices.Contains(dirs, filepath.Dir(f)) {
+			dirs = append(dirs, filepath.Dir(f))
+		}
+	}
+	return dirs
+}
+
+// serviceCapabilities returns the capabilities the unit keeps: binding
+// :443 without -socket and setting SO_MARK for fwmark.
+func serviceCapabilities(o serviceOptions) []string {
+	if o.Role != "server" {
+		return nil
+	}
+	var caps []string
+	if !o.Socket {
+		caps = append(caps, "CAP_NET_BIND_SERVICE")
+	}
+	if cfg, err := config.LoadServerConfig(o.Config); err == nil && cfg.FWMark != 0 {
+		caps = append(caps, "CAP_NET_ADMIN")
+	}
+	return caps
+}
+
+// renderUnit renders the service unit of o; socketUnit is the name of
+// its socket unit, if any.
+func renderUnit(o serviceOptions, socketUnit string) (string, error) {
+	configDir := filepath.Dir(o.Config)
+	writable := writablePaths(o.Role, o.Config)
+	if slices.Contains(writable, configDir) {
+		configDir = "" // ReadWritePaths= opens it up instead
+	}
+	var unit strings.Builder
+	err := systemdUnit.Execute(&unit, map[string]string{
+		"Role":          o.Role,
+		"ExecStart":     o.Binary + " " + o.Role + " -config " + o.Config,
+		"ConfigDir":     configDir,
+		"WritablePaths": strings.Join(writable, " "),
+		"Capabilities":  strings.Join(serviceCapabilities(o), " "),
+		"User":          o.User,
+		"Watchdog":      o.Watchdog,
+		"Socket":        socketUnit,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render unit: %v", err)
+	}
+	return unit.String(), nil
+}
+
+// installService writes a systemd unit and optionally enables it.
+func installService(o serviceOptions) error {
+	units := []string{o.Name}
+	var socket strings.Builder
+	socketUnit := ""
+	if o.Socket {
+		if o.Role != "server" {
+			return fmt.Errorf("-socket is for the server role")
+		}
+		cfg, err := config.LoadServerConfig(o.Config)
+		if err != nil {
+			return fmt.Errorf("-socket needs the server's listen_addr: %v", err)
+		}
+		if err := systemdSocket.Execute(&socket, map[string]string{"Listen": listenStream(cfg.ListenAddr)}); err != nil {
+			return fmt.Errorf("failed to render socket unit: %v", err)
+		}
+		socketUnit = o.Name + ".socket"
+		units = []string{socketUnit, o.Name}
+	}
+
+	unit, err := renderUnit(o, socketUnit)
+	if err != nil {
+		return err
+	}
+
+	if o.DryRun {
+		if o.Socket {
+			fmt.Printf("# %s\n%s\n# %s\n", socketPath(o), socket.String(), unitPath(o))
+		}
+		fmt.Print(unit)
+		return nil
+	}
+
+	if o.Socket {
+		path := socketPath(o)
+		if err := os.WriteFile(path, []byte(socket.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	path := unitPath(o)
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+
+	if !o.Start {
+		fmt.Printf("Run: systemctl daemon-reload && systemctl enable --now %s\n", strings.Join(units, " "))
+		return nil
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl(append([]string{"enable", "--now"}, units...)...); err != nil {
+		return err
+	}
+	fmt.Printf("Service %s enabled and started\n", o.Name)
+	return nil
+}
+
+// uninstallService stops and disables the unit, then removes it.
+func uninstallService(o serviceOptions) error {
+	path := unitPath(o)
+	if o.DryRun {
+		fmt.Printf("Would run 'systemctl disable --now %s' and remove %s\n", o.Name, path)
+		return nil
+	}
+	if err := systemctl("disable", "--now", o.Name); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if _, err := os.Stat(socketPath(o)); err == nil {
+		if err := systemctl("disable", "--now", o.Name+".socket"); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if err := os.Remove(socketPath(o)); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", socketPath(o), err)
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	fmt.Printf("Service %s removed\n", o.Name)
+	return nil
+}
+
+// runAsService runs the role in the foreground; systemd supervises it
+// directly, so no service protocol is needed beyond sd_notify.
+func runAsService(o serviceOptions) error {
+	runRole(o)
+	return nil
+}
+
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("systemctl %s failed: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderUnit(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.toml")
+	os.WriteFile(plain, []byte(`listen_addr = ":443"`+"\n"), 0644)
+	stateful := filepath.Join(dir, "stateful.toml")
+	os.WriteFile(stateful, []byte(`listen_addr = ":443"
+user_db = "/var/lib/phoenix/users.db"
+fwmark = 51820
+
+[log]
+file = "/var/log/phoenix/server.log"
+
+[quota]
+state_file = "/var/lib/phoenix/quota.json"
+
+[[geodata]]
+name = "ir"
+url = "https://example.com/ir.txt"
+path = "/var/cache/phoenix/ir.txt"
+`), 0644)
+
+	tests := []struct {
+		name    string
+		o       serviceOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "plain server",
+			o:       serviceOptions{Role: "server", Config: plain},
+			want:    []string{"CapabilityBoundingSet=CAP_NET_BIND_SERVICE\n", "ReadOnlyPaths=" + dir + "\n"},
+			notWant: []string{"ReadWritePaths=", "CAP_NET_ADMIN"},
+		},
+		{
+			name: "stateful server",
+			o:    serviceOptions{Role: "server", Config: stateful},
+			want: []string{
+				"CapabilityBoundingSet=CAP_NET_BIND_SERVICE CAP_NET_ADMIN\n",
+				"ReadWritePaths=/var/log/phoenix /var/lib/phoenix /var/cache/phoenix\n",
+			},
+		},
+		{
+			name:    "socket-activated server with fwmark",
+			o:       serviceOptions{Role: "server", Config: stateful, Socket: true},
+			want:    []string{"AmbientCapabilities=CAP_NET_ADMIN\n"},
+			notWant: []string{"CAP_NET_BIND_SERVICE"},
+		},
+		{
+			name:    "client",
+			o:       serviceOptions{Role: "client", Config: filepath.Join(dir, "missing.toml")},
+			want:    []string{"CapabilityBoundingSet=\n"},
+			notWant: []string{"AmbientCapabilities=", "ReadWritePaths="},
+		},
+	}
+	for _, tt := range tests {
+		unit, err := renderUnit(tt.o, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(unit, s) {
+				t.Errorf("%s: Expected %q in the unit:\n%s", tt.name, s, unit)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(unit, s) {
+				t.Errorf("%s: Expected no %q in the unit:\n%s", tt.name, s, unit)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux && !windows && !darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const defaultServiceRole = "server"
+
+func defaultServiceConfig(role string) string {
+	return role + ".toml"
+}
+
+func installService(o serviceOptions) error {
+	return fmt.Errorf("service installation is not supported on %s", runtime.GOOS)
+}
+
+func uninstallService(o serviceOptions) error {
+	return fmt.Errorf("service installation is not supported on %s", runtime.GOOS)
+}
+
+// runAsService runs the role in the foreground.
+func runAsService(o serviceOptions) error {
+	runRole(o)
+	return nil
+}
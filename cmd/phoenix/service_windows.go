@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const defaultServiceRole = "client"
+
+func defaultServiceConfig(role string) string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "Phoenix", role+".toml")
+}
+
+// installService registers phoenix with the Service Control Manager as an
+// auto-start service that restarts on failure.
+func installService(o serviceOptions) error {
+	if o.LogFile == "" {
+		o.LogFile = filepath.Join(filepath.Dir(o.Config), o.Name+".log")
+	}
+	args := o.roleArgs()
+	if o.DryRun {
+		fmt.Printf("Would create service %q: %s %s\n", o.Name, o.Binary, strings.Join(args, " "))
+		return nil
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager (run as Administrator): %v", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(o.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", o.Name)
+	}
+
+	cfg := mgr.Config{
+		DisplayName: "Phoenix tunnel " + o.Role,
+		Description: "Phoenix HTTP/2 tunnel " + o.Role + " (" + o.Config + ")",
+		StartType:   mgr.StartAutomatic,
+	}
+	if o.User != "" {
+		cfg.ServiceStartName = o.User
+	}
+	s, err := m.CreateService(o.Name, o.Binary, cfg, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %v", err)
+	}
+	defer s.Close()
+
+	// Restart after 5s on every failure; reset the failure counter daily.
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}
+	if err := s.SetRecoveryActions(recovery, 24*60*60); err != nil {
+		log.Printf("Warning: failed to set recovery actions: %v", err)
+	}
+	fmt.Printf("Service %s installed (logs: %s)\n", o.Name, o.LogFile)
+
+	if o.Start {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("failed to start service: %v", err)
+		}
+		fmt.Printf("Service %s started\n", o.Name)
+	}
+	return nil
+}
+
+// uninstallService stops the service if it is running and deletes it.
+func uninstallService(o serviceOptions) error {
+	if o.DryRun {
+		fmt.Printf("Would stop and delete service %q\n", o.Name)
+		return nil
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager (run as Administrator): %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(o.Name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", o.Name)
+	}
+	defer s.Close()
+
+	if status, err := s.Control(svc.Stop); err == nil {
+		for i := 0; i < 20 && status.State != svc.Stopped; i++ {
+			time.Sleep(500 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %v", err)
+	}
+	fmt.Printf("Service %s removed\n", o.Name)
+	return nil
+}
+
+// runAsService speaks the SCM protocol when started by the Service Control
+// Manager, and runs the role in the foreground otherwise (e.g. when invoked
+// from a console for debugging).
+func runAsService(o serviceOptions) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		runRole(o)
+		return nil
+	}
+	return svc.Run(o.Name, &windowsService{opts: o})
+}
+
+// windowsService adapts a phoenix role to svc.Handler.
+type windowsService struct {
+	opts serviceOptions
+}
+
+func (ws *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	go runRole(ws.opts)
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			status <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			log.Printf("Service %s stopping", ws.opts.Name)
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "phoenix/pkg/logfile"
+
+// watchLogLevelSignal does nothing: there is no SIGUSR1. The control
+// command and the API still change the level.
+func watchLogLevelSignal() {}
+
+// watchReopenSignal does nothing: there is no SIGUSR2. The [log] file
+// still rotates on its own.
+func watchReopenSignal(*logfile.File) {}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"phoenix/pkg/logfile"
+	"phoenix/pkg/loglevel"
+	"syscall"
+)
+
+// watchLogLevelSignal toggles between the info and debug log levels on
+// every SIGUSR1.
+func watchLogLevelSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	go func() {
+		for range sig {
+			loglevel.Toggle()
+		}
+	}()
+}
+
+// watchReopenSignal reopens the log file on every SIGUSR2, after logrotate
+// moved it away.
+func watchReopenSignal(f *logfile.File) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	go func() {
+		for range sig {
+			if err := f.Reopen(); err != nil {
+				log.Printf("[Log] Reopening the log file failed: %v", err)
+			} else {
+				log.Println("[Log] Log file reopened")
+			}
+		}
+	}()
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/transport"
+	"time"
+)
+
+// Speedtest target commands. Every benchmark stream starts with a 9-byte
+// header: [mode][size uint64].
+const (
+	stModeUpload   = 'U' // client sends size bytes, target acks with one byte
+	stModeDownload = 'D' // target sends size bytes
+	stModePing     = 'P' // target echoes one byte, size ignored
+)
+
+// speedtestResult is the -json output of the speedtest subcommand. Rates
+// are in Mbit/s; Failures lists the thresholds that were not met.
+type speedtestResult struct {
+	SizeBytes    uint64       `json:"size_bytes"`
+	Pings        int          `json:"pings"`
+	LatencyMs    float64      `json:"latency_ms"`
+	UploadMbps   float64      `json:"upload_mbps"`
+	UploadSecs   float64      `json:"upload_seconds"`
+	DownloadMbps float64      `json:"download_mbps"`
+	DownloadSecs float64      `json:"download_seconds"`
+	UDP          *udpJSON     `json:"udp,omitempty"`
+	Streams      *streamsJSON `json:"streams,omitempty"`
+	Pass         bool         `json:"pass"`
+	Failures     []string     `json:"failures,omitempty"`
+}
+
+// udpJSON is the UDP part of speedtestResult.
+type udpJSON struct {
+	PayloadBytes int     `json:"payload_bytes"`
+	Sent         int     `json:"sent"`
+	Received     int     `json:"received"`
+	LossPercent  float64 `json:"loss_percent"`
+	PPS          float64 `json:"pps"`
+	GoodputMbps  float64 `json:"goodput_mbps"`
+	RTTMs        float64 `json:"rtt_ms"`
+	JitterMs     float64 `json:"jitter_ms"`
+}
+
+// streamsJSON is the concurrent-streams part of speedtestResult. CPU is
+// that of the whole process, which runs both server and client.
+type streamsJSON struct {
+	Count         int     `json:"count"`
+	ServerLimit   int     `json:"server_max_streams_per_conn"`
+	Bytes         uint64  `json:"bytes"`
+	Seconds       float64 `json:"seconds"`
+	AggregateMbps float64 `json:"aggregate_mbps"`
+	MinMbps       float64 `json:"per_stream_min_mbps"`
+	MedianMbps    float64 `json:"per_stream_median_mbps"`
+	MaxMbps       float64 `json:"per_stream_max_mbps"`
+	CPUCores      float64 `json:"cpu_cores,omitempty"`
+}
+
+// runSpeedtest implements the "speedtest" subcommand. It starts a Phoenix
+// server, a Phoenix client and a TCP target on loopback, then measures
+// latency and throughput of streams tunneled through the full stack, and of
+// datagrams relayed by a SOCKS5 UDP association; -streams adds a download
+// split across that many parallel streams. With -min-mbps,
+// -max-latency or -max-loss it exits with status 2 when a result misses the
+// threshold, so it can gate performance regressions in CI.
+func runSpeedtest(args []string) {
+	fs := flag.NewFlagSet("speedtest", flag.ExitOnError)
+	sizeMB := fs.Int("size", 64, "Megabytes to transfer in each direction")
+	pings := fs.Int("pings", 10, "Number of round trips for the latency test")
+	jsonOut := fs.Bool("json", false, "Print the results as JSON")
+	minMbps := fs.Float64("min-mbps", 0, "Fail if upload or download is below this many Mbit/s (0 = no threshold)")
+	maxLatency := fs.Duration("max-latency", 0, "Fail if the average latency is above this (0 = no threshold)")
+	udpDuration := fs.Duration("udp-duration", 2*time.Second, "Length of the UDP test (0 = skip)")
+	udpPPS := fs.Int("udp-pps", 10000, "Datagrams per second sent in the UDP test")
+	udpSize := fs.Int("udp-size", 1200, "Payload bytes per UDP datagram")
+	streams := fs.Int("streams", 0, "Parallel streams for the scaling test (1-1000, 0 = skip)")
+	maxLoss := fs.Float64("max-loss", 100, "Fail if UDP loss is above this percentage")
+	verbose := fs.Bool("v", false, "Show transport logs")
+	fs.Parse(args)
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	if *pings < 1 {
+		*pings = 1
+	}
+	if *streams < 0 || *streams > maxSpeedtestStreams {
+		fatalf("-streams must be between 0 and %d", maxSpeedtestStreams)
+	}
+
+	targetAddr, err := startSpeedtestTarget()
+	if err != nil {
+		fatalf("Failed to start target: %v", err)
+	}
+	serverAddr, err := startSpeedtestServer()
+	if err != nil {
+		fatalf("Failed to start server: %v", err)
+	}
+
+	client := transport.NewClient(&config.ClientConfig{RemoteAddr: serverAddr})
+	size := uint64(*sizeMB) * 1024 * 1024
+	res := speedtestResult{SizeBytes: size, Pings: *pings}
+
+	// In JSON mode only the final document goes to stdout.
+	printf := func(format string, args ...interface{}) {
+		if !*jsonOut {
+			fmt.Printf(format, args...)
+		}
+	}
+
+	printf("Phoenix speedtest (h2c over loopback, %d MB)\n", *sizeMB)
+
+	rtt, err := speedtestPing(client, targetAddr, *pings)
+	if err != nil {
+		fatalf("Latency test failed: %v", err)
+	}
+	res.LatencyMs = float64(rtt) / float64(time.Millisecond)
+	printf("  Latency:  %v (avg of %d)\n", rtt, *pings)
+
+	up, err := speedtestTransfer(client, targetAddr, stModeUpload, size)
+	if err != nil {
+		fatalf("Upload test failed: %v", err)
+	}
+	res.UploadMbps, res.UploadSecs = mbps(size, up), up.Seconds()
+	printf("  Upload:   %s\n", formatRate(size, up))
+
+	down, err := speedtestTransfer(client, targetAddr, stModeDownload, size)
+	if err != nil {
+		fatalf("Download test failed: %v", err)
+	}
+	res.DownloadMbps, res.DownloadSecs = mbps(size, down), down.Seconds()
+	printf("  Download: %s\n", formatRate(size, down))
+
+	var udp udpSpeedtest
+	if *udpDuration > 0 {
+		udpTarget, err := startSpeedtestUDPTarget()
+		if err != nil {
+			fatalf("Failed to start UDP target: %v", err)
+		}
+		proxy, err := startLoopbackInbound(client)
+		if err != nil {
+			fatalf("Failed to start SOCKS5 inbound: %v", err)
+		}
+		udp, err = speedtestUDP(proxy, udpTarget, *udpSize, *udpPPS, *udpDuration)
+		if err != nil {
+			fatalf("UDP test failed: %v", err)
+		}
+		goodput := uint64(udp.Received) * uint64(*udpSize)
+		res.UDP = &udpJSON{
+			PayloadBytes: *udpSize,
+			Sent:         udp.Sent,
+			Received:     udp.Received,
+			LossPercent:  udp.lossPercent(),
+			PPS:          udp.pps(),
+			GoodputMbps:  mbps(goodput, udp.Elapsed),
+			RTTMs:        float64(udp.RTT) / float64(time.Millisecond),
+			JitterMs:     float64(udp.Jitter) / float64(time.Millisecond),
+		}
+		printf("  UDP:      %.0f pps, %.1f Mbit/s goodput, %.2f%% loss (%d/%d)\n",
+			res.UDP.PPS, res.UDP.GoodputMbps, res.UDP.LossPercent, udp.Received, udp.Sent)
+		printf("            RTT %v, jitter %v\n", udp.RTT, udp.Jitter)
+	}
+
+	if *streams > 0 {
+		st, err := speedtestStreams(client, targetAddr, *streams, size)
+		if err != nil {
+			fatalf("Concurrent streams test failed: %v", err)
+		}
+		res.Streams = &streamsJSON{
+			Count:         st.Streams,
+			ServerLimit:   speedtestMaxStreams,
+			Bytes:         st.Bytes,
+			Seconds:       st.Elapsed.Seconds(),
+			AggregateMbps: mbps(st.Bytes, st.Elapsed),
+			MinMbps:       st.percentile(0),
+			MedianMbps:    st.percentile(50),
+			MaxMbps:       st.percentile(100),
+			CPUCores:      st.cores(),
+		}
+		printf("  Streams:  %d x %d KB, %s aggregate (server limit %d per connection)\n",
+			st.Streams, st.Bytes/uint64(st.Streams)/1024, formatRate(st.Bytes, st.Elapsed), speedtestMaxStreams)
+		printf("            per stream %.1f / %.1f / %.1f Mbit/s (min / median / max)\n",
+			res.Streams.MinMbps, res.Streams.MedianMbps, res.Streams.MaxMbps)
+		if st.CPU > 0 {
+			printf("            CPU %.2f cores (server and client)\n", res.Streams.CPUCores)
+		}
+	}
+
+	if *maxLatency > 0 && rtt > *maxLatency {
+		res.Failures = append(res.Failures, fmt.Sprintf("latency %v above %v", rtt, *maxLatency))
+	}
+	if *minMbps > 0 && res.UploadMbps < *minMbps {
+		res.Failures = append(res.Failures, fmt.Sprintf("upload %.1f Mbit/s below %.1f", res.UploadMbps, *minMbps))
+	}
+	if *minMbps > 0 && res.DownloadMbps < *minMbps {
+		res.Failures = append(res.Failures, fmt.Sprintf("download %.1f Mbit/s below %.1f", res.DownloadMbps, *minMbps))
+	}
+	if res.UDP != nil && res.UDP.LossPercent > *maxLoss {
+		res.Failures = append(res.Failures, fmt.Sprintf("UDP loss %.2f%% above %.2f%%", res.UDP.LossPercent, *maxLoss))
+	}
+	res.Pass = len(res.Failures) == 0
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+	} else {
+		for _, f := range res.Failures {
+			fmt.Printf("FAIL: %s\n", f)
+		}
+	}
+	if !res.Pass {
+		os.Exit(2)
+	}
+}
+
+// speedtestMaxStreams is the speedtest server's max_streams_per_conn, the
+// server default.
+const speedtestMaxStreams = 500
+
+// startSpeedtestServer runs an h2c Phoenix server on a free loopback port.
+func startSpeedtestServer() (string, error) {
+	ln, err := listenLoopback()
+	if err != nil {
+		return "", err
+	}
+	addr := ln.Addr().String()
+
+	cfg := config.DefaultServerConfig()
+	cfg.ListenAddr = addr
+	cfg.Security.EnableSSH = true
+	cfg.Security.EnableSOCKS5 = true
+	cfg.Security.EnableUDP = true
+	// Let -streams open all its streams at once; concurrency is still
+	// capped by max_streams_per_conn.
+	cfg.Limits.StreamRate = -1
+	cfg.Limits.MaxStreamsPerConn = speedtestMaxStreams
+	go func() {
+		if err := transport.Serve(cfg, ln); err != nil {
+			fatalf("Server failed: %v", err)
+		}
+	}()
+	return addr, nil
+}
+
+// listenLoopback binds a free loopback port, using ::1 on hosts without
+// IPv4 loopback.
+func listenLoopback() (net.Listener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		ln, err = net.Listen("tcp", "[::1]:0")
+	}
+	return ln, err
+}
+
+// startSpeedtestTarget starts the loopback TCP endpoint the tunnel forwards to.
+func startSpeedtestTarget() (string, error) {
+	ln, err := listenLoopback()
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSpeedtestConn(conn)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+func serveSpeedtestConn(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 9)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		size := int64(binary.BigEndian.Uint64(header[1:]))
+		switch header[0] {
+		case stModeUpload:
+			if _, err := io.CopyN(io.Discard, conn, size); err != nil {
+				return
+			}
+			conn.Write([]byte{1})
+		case stModeDownload:
+			if _, err := io.CopyN(conn, zeroReader{}, size); err != nil {
+				return
+			}
+		case stModePing:
+			conn.Write([]byte{1})
+		default:
+			return
+		}
+	}
+}
+
+// speedtestPing measures the average round trip of a one-byte exchange on an
+// already established stream.
+func speedtestPing(client *transport.Client, target string, count int) (time.Duration, error) {
+	stream, err := client.Dial(protocol.ProtocolSSH, target)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	if count < 1 {
+		count = 1
+	}
+	header := speedtestHeader(stModePing, 0)
+	ack := make([]byte, 1)
+	var total time.Duration
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		if _, err := stream.Write(header); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(stream, ack); err != nil {
+			return 0, err
+		}
+		total += time.Since(start)
+	}
+	return total / time.Duration(count), nil
+}
+
+// speedtestTransfer moves size bytes in the direction given by mode and
+// returns the elapsed time.
+func speedtestTransfer(client *transport.Client, target string, mode byte, size uint64) (time.Duration, error) {
+	stream, err := client.Dial(protocol.ProtocolSSH, target)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	start := time.Now()
+	if _, err := stream.Write(speedtestHeader(mode, size)); err != nil {
+		return 0, err
+	}
+	switch mode {
+	case stModeUpload:
+		if _, err := io.CopyN(stream, zeroReader{}, int64(size)); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(stream, make([]byte, 1)); err != nil {
+			return 0, err
+		}
+	case stModeDownload:
+		if _, err := io.CopyN(io.Discard, stream, int64(size)); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+func speedtestHeader(mode byte, size uint64) []byte {
+	header := make([]byte, 9)
+	header[0] = mode
+	binary.BigEndian.PutUint64(header[1:], size)
+	return header
+}
+
+func formatRate(bytes uint64, d time.Duration) string {
+	if d <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f Mbit/s (%.2fs)", mbps(bytes, d), d.Seconds())
+}
+
+// mbps returns the rate of bytes moved in d in Mbit/s, or 0 if d is not
+// positive.
+func mbps(bytes uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / d.Seconds() / 1e6
+}
+
+// fatalf prints to stderr and exits; log output may be discarded in quiet mode.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// zeroReader is an endless source of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
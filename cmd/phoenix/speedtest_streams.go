@@ -0,0 +1,83 @@
+package main
+
+import (
+	"phoenix/pkg/transport"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSpeedtestStreams bounds -streams.
+const maxSpeedtestStreams = 1000
+
+// streamsSpeedtest is the outcome of speedtestStreams. Per-stream rates
+// include the time a stream waited for the server's concurrent stream
+// limit, so streams past that limit show up as the slow tail.
+type streamsSpeedtest struct {
+	Streams   int
+	Bytes     uint64 // Total across all streams
+	Elapsed   time.Duration
+	PerStream []float64     // Mbit/s of each stream, sorted ascending
+	CPU       time.Duration // Process CPU time used (0 if unknown)
+}
+
+// cores returns the CPU time used per second of the test.
+func (r streamsSpeedtest) cores() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return r.CPU.Seconds() / r.Elapsed.Seconds()
+}
+
+// percentile returns the p-th percentile (0–100) of the per-stream rates.
+func (r streamsSpeedtest) percentile(p float64) float64 {
+	if len(r.PerStream) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.PerStream)-1))
+	return r.PerStream[i]
+}
+
+// speedtestStreams downloads size bytes split across n parallel streams,
+// all opened at once, and measures each stream and the aggregate.
+func speedtestStreams(client *transport.Client, target string, n int, size uint64) (streamsSpeedtest, error) {
+	res := streamsSpeedtest{Streams: n}
+	per := size / uint64(n)
+	if per < 64*1024 {
+		per = 64 * 1024
+	}
+	res.Bytes = per * uint64(n)
+
+	cpuStart, cpuOK := processCPU()
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	rates := make([]float64, n)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			streamStart := time.Now()
+			if _, err := speedtestTransfer(client, target, stModeDownload, per); err != nil {
+				errs <- err
+				return
+			}
+			// Timed from before the dial, to include any wait for a stream slot.
+			rates[i] = mbps(per, time.Since(streamStart))
+		}(i)
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	close(errs)
+	if err := <-errs; err != nil {
+		return res, err
+	}
+
+	if cpuEnd, ok := processCPU(); cpuOK && ok {
+		res.CPU = cpuEnd - cpuStart
+	}
+	sort.Float64s(rates)
+	res.PerStream = rates
+	return res, nil
+}
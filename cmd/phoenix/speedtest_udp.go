@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/transport"
+	"sync"
+	"time"
+)
+
+// Every UDP benchmark datagram starts with [seq uint32][sent UnixNano int64];
+// the target echoes datagrams unchanged.
+const stUDPHeader = 12
+
+// udpSpeedtest is the outcome of speedtestUDP. Loss counts datagrams that
+// were not echoed back before the grace period ended; Jitter is the mean
+// difference between consecutive round trips (RFC 3550 style).
+type udpSpeedtest struct {
+	Sent, Received int
+	Elapsed        time.Duration
+	RTT            time.Duration // Average round trip
+	Jitter         time.Duration
+}
+
+func (r udpSpeedtest) lossPercent() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return 100 * float64(r.Sent-r.Received) / float64(r.Sent)
+}
+
+func (r udpSpeedtest) pps() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Elapsed.Seconds()
+}
+
+// startSpeedtestUDPTarget starts the loopback UDP echo endpoint.
+func startSpeedtestUDPTarget() (string, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		pc, err = net.ListenPacket("udp", "[::1]:0")
+	}
+	if err != nil {
+		return "", err
+	}
+	if c, ok := pc.(*net.UDPConn); ok {
+		c.SetReadBuffer(4 * 1024 * 1024)
+		c.SetWriteBuffer(4 * 1024 * 1024)
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().String(), nil
+}
+
+// startLoopbackInbound starts a SOCKS5 inbound with enable_udp on a free
+// loopback port of client and returns its address.
+func startLoopbackInbound(client *transport.Client) (string, error) {
+	ln, err := listenLoopback()
+	if err != nil {
+		return "", err
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	err = client.AddInbound(config.ClientInbound{
+		Protocol:  protocol.ProtocolSOCKS5,
+		LocalAddr: addr,
+		EnableUDP: true,
+	})
+	return addr, err
+}
+
+// socks5Associate performs a no-auth UDP ASSOCIATE on the SOCKS5 proxy at
+// proxyAddr. It returns the control connection, which must stay open for
+// the association to live, and the relay address.
+func socks5Associate(proxyAddr string) (net.Conn, *net.UDPAddr, error) {
+	conn, err := net.DialTimeout("tcp", proxyAddr, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fail := func(format string, args ...interface{}) (net.Conn, *net.UDPAddr, error) {
+		conn.Close()
+		return nil, nil, fmt.Errorf(format, args...)
+	}
+
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return fail("greeting: %v", err)
+	}
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil {
+		return fail("greeting: %v", err)
+	}
+	if method[1] != 0x00 {
+		return fail("proxy wants auth method %d", method[1])
+	}
+
+	// UDP ASSOCIATE with an unspecified client address.
+	if _, err := conn.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		return fail("associate: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fail("associate: %v", err)
+	}
+	if reply[1] != 0x00 {
+		return fail("associate rejected with reply %d", reply[1])
+	}
+	var ip net.IP
+	switch reply[3] {
+	case 0x01:
+		ip = make(net.IP, net.IPv4len)
+	case 0x04:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return fail("unexpected relay address type %d", reply[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, ip); err != nil {
+		return fail("associate: %v", err)
+	}
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return fail("associate: %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(port))}, nil
+}
+
+// socks5UDPHeader returns the SOCKS5 UDP request header for target.
+func socks5UDPHeader(target *net.UDPAddr) []byte {
+	if ip4 := target.IP.To4(); ip4 != nil {
+		h := []byte{0, 0, 0, 0x01}
+		h = append(h, ip4...)
+		return binary.BigEndian.AppendUint16(h, uint16(target.Port))
+	}
+	h := []byte{0, 0, 0, 0x04}
+	h = append(h, target.IP.To16()...)
+	return binary.BigEndian.AppendUint16(h, uint16(target.Port))
+}
+
+// speedtestUDP sends size-byte datagrams at pps through a UDP association
+// of proxyAddr to the echo target for duration, then waits a grace period
+// for the last echoes and reports what came back.
+func speedtestUDP(proxyAddr, target string, size, pps int, duration time.Duration) (udpSpeedtest, error) {
+	var res udpSpeedtest
+	if size < stUDPHeader {
+		size = stUDPHeader
+	}
+	if pps < 1 {
+		pps = 1
+	}
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return res, err
+	}
+	ctrl, relay, err := socks5Associate(proxyAddr)
+	if err != nil {
+		return res, err
+	}
+	defer ctrl.Close()
+
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	conn.SetReadBuffer(4 * 1024 * 1024)
+	conn.SetWriteBuffer(4 * 1024 * 1024)
+
+	header := socks5UDPHeader(targetAddr)
+	var (
+		mu       sync.Mutex
+		seen     = make(map[uint32]bool)
+		rttSum   time.Duration
+		jitSum   time.Duration
+		lastRTT  time.Duration
+		received int
+	)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			if n < len(header)+stUDPHeader {
+				continue
+			}
+			p := buf[len(header):n]
+			seq := binary.BigEndian.Uint32(p)
+			rtt := now.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(p[4:]))))
+			mu.Lock()
+			if !seen[seq] {
+				seen[seq] = true
+				if received > 0 {
+					d := rtt - lastRTT
+					if d < 0 {
+						d = -d
+					}
+					jitSum += d
+				}
+				lastRTT = rtt
+				rttSum += rtt
+				received++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	// Send in 1ms batches to hold the rate without a sleep per datagram.
+	packet := make([]byte, len(header)+size)
+	copy(packet, header)
+	payload := packet[len(header):]
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= duration {
+			break
+		}
+		for due := int(elapsed.Seconds() * float64(pps)); res.Sent < due; res.Sent++ {
+			binary.BigEndian.PutUint32(payload, uint32(res.Sent))
+			binary.BigEndian.PutUint64(payload[4:], uint64(time.Now().UnixNano()))
+			if _, err := conn.Write(packet); err != nil {
+				return res, err
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	res.Elapsed = time.Since(start)
+
+	time.Sleep(500 * time.Millisecond)
+	conn.Close()
+	<-readDone
+
+	res.Received = received
+	if received > 0 {
+		res.RTT = rttSum / time.Duration(received)
+	}
+	if received > 1 {
+		res.Jitter = jitSum / time.Duration(received-1)
+	}
+	return res, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// receiveTunFd is only supported on Unix platforms, where the Android
+// VpnService hands the TUN fd over an abstract socket via SCM_RIGHTS.
+func receiveTunFd(socketName string) (int, error) {
+	return -1, fmt.Errorf("receiving a TUN fd over %q is not supported on this platform", socketName)
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// receiveTunFd connects to the abstract Unix socket created by the Android
+// VpnService, receives the TUN file descriptor via SCM_RIGHTS ancillary data,
+// and returns a duplicate of it that is safe to use in this process.
+func receiveTunFd(socketName string) (int, error) {
+	// Abstract namespace: Go uses "@" prefix which maps to the null byte Linux uses.
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{
+		Name: "@" + socketName,
+		Net:  "unix",
+	})
+	if err != nil {
+		return -1, fmt.Errorf("connect to tun socket %q: %w", socketName, err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4)) // room for exactly one int (fd)
+
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return -1, fmt.Errorf("ReadMsgUnix: %w", err)
+	}
+
+	scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return -1, fmt.Errorf("ParseSocketControlMessage: %w", err)
+	}
+
+	for _, scm := range scms {
+		fds, err := syscall.ParseUnixRights(&scm)
+		if err != nil {
+			continue
+		}
+		if len(fds) > 0 {
+			return fds[0], nil
+		}
+	}
+
+	return -1, fmt.Errorf("no file descriptor in SCM_RIGHTS ancillary data")
+}
@@ -0,0 +1,58 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/core"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// bufConn is a net.Conn that reads r and writes to w.
+type bufConn struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (c bufConn) Read(p []byte) (int, error)     { return c.r.Read(p) }
+func (c bufConn) Write(p []byte) (int, error)    { return c.w.Write(p) }
+func (bufConn) Close() error                     { return nil }
+func (bufConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (bufConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (bufConn) SetDeadline(time.Time) error      { return nil }
+func (bufConn) SetReadDeadline(time.Time) error  { return nil }
+func (bufConn) SetWriteDeadline(time.Time) error { return nil }
+
+type failDialer struct{}
+
+func (failDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	return nil, errors.New("fuzz: no dialing")
+}
+
+// FuzzHandleConn decrypts arbitrary bytes as an AEAD stream from a client
+// and parses the target address from them.
+func FuzzHandleConn(f *testing.F) {
+	ciph, err := core.PickCipher("AEAD_CHACHA20_POLY1305", nil, "fuzz-password")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	// Seed with a valid request, so mutations reach the address parser.
+	var sealed bytes.Buffer
+	w := ciph.StreamConn(bufConn{r: bytes.NewReader(nil), w: &sealed})
+	w.Write(socks.ParseAddr("example.com:443"))
+	w.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	f.Add(sealed.Bytes())
+	f.Add([]byte{})
+
+	log.SetOutput(io.Discard)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn := ciph.StreamConn(bufConn{r: bytes.NewReader(data), w: io.Discard})
+		handleConn(conn, failDialer{})
+	})
+}
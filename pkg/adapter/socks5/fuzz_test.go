@@ -0,0 +1,80 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"testing"
+)
+
+// fuzzConn replays the fuzz input as the client's side of a connection.
+type fuzzConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (fuzzConn) Close() error { return nil }
+
+type failDialer struct{}
+
+func (failDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	if target == "" {
+		panic("empty target")
+	}
+	return nil, errors.New("fuzz: no dialing")
+}
+
+// FuzzHandleConnection runs the greeting and request parser on arbitrary
+// bytes, with every command enabled.
+func FuzzHandleConnection(f *testing.F) {
+	f.Add([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 80})
+	f.Add([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', 1, 187})
+	f.Add([]byte{0x05, 0x02, 0x00, 0x02, 0x05, 0x03, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0})
+	log.SetOutput(io.Discard)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn := fuzzConn{Reader: bytes.NewReader(data), Writer: io.Discard}
+		HandleConnection(conn, failDialer{}, Options{EnableUDP: true})
+	})
+}
+
+// FuzzParseUDPHeader checks that parsed headers never point past the packet.
+func FuzzParseUDPHeader(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0x01, 8, 8, 8, 8, 0, 53, 'q'})
+	f.Add([]byte{0, 0, 0, 0x03, 3, 'a', '.', 'b', 0, 53})
+	f.Add([]byte{0, 0, 0, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 53})
+	log.SetOutput(io.Discard)
+	f.Fuzz(func(t *testing.T, pkt []byte) {
+		dest, offset, ok := parseUDPHeader(pkt)
+		if !ok {
+			return
+		}
+		if offset > len(pkt) {
+			t.Errorf("Expected data offset within the %d-byte packet, got %d", len(pkt), offset)
+		}
+		if dest == "" {
+			t.Errorf("Expected a destination for an accepted header")
+		}
+	})
+}
+
+// FuzzFrameReader checks that UDP-over-stream frames read from arbitrary
+// bytes never exceed the frame size limit.
+func FuzzFrameReader(f *testing.F) {
+	header := []byte{0, 0, 0, 0x01, 127, 0, 0, 1, 0, 53}
+	frame, _ := encodeUDPFrame(header, []byte("datagram"))
+	f.Add(frame)
+	f.Add([]byte{0, 2, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames := newFrameReader(bytes.NewReader(data))
+		for {
+			body, err := frames.next()
+			if err != nil {
+				return
+			}
+			if len(body) > maxUDPFrame {
+				t.Fatalf("Expected frames of at most %d bytes, got %d", maxUDPFrame, len(body))
+			}
+		}
+	})
+}
@@ -2,9 +2,13 @@ package socks5
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"phoenix/pkg/sniff"
+	"time"
 )
 
 // Dialer abstracts the connection creation.
@@ -19,13 +23,64 @@ func (d *NetDialer) Dial(target string) (io.ReadWriteCloser, error) {
 	return net.Dial("tcp", target)
 }
 
+// Options configures HandleConnection.
+type Options struct {
+	// EnableUDP allows UDP ASSOCIATE.
+	EnableUDP bool
+
+	// UDP bounds UDP associations.
+	UDP UDPLimits
+
+	// HandshakeTimeout bounds the method negotiation and request when conn
+	// supports deadlines (zero = no limit). It no longer applies once the
+	// request has been read.
+	HandshakeTimeout time.Duration
+
+	// HandshakeDone, if set, is called when the request has been read,
+	// before the target is dialed. It is not called for failed handshakes.
+	HandshakeDone func()
+
+	// Sniff, for CONNECTs to an IP through a SniffDialer, reads the first
+	// bytes the client sends for the host name they are for (TLS SNI or
+	// HTTP Host) and dials with DialSniffed. The success reply then goes
+	// out before the dial, so a failed dial closes the connection instead
+	// of replying with the reason.
+	Sniff bool
+
+	// SniffTimeout bounds the wait for those bytes (default
+	// DefaultSniffTimeout); protocols in which the server speaks first
+	// start this much later.
+	SniffTimeout time.Duration
+}
+
+// DefaultSniffTimeout is the default Options.SniffTimeout.
+const DefaultSniffTimeout = 300 * time.Millisecond
+
+// maxSniff bounds the bytes read for sniffing.
+const maxSniff = 8 << 10
+
+// SniffDialer is a Dialer that can also dial a target it was told the
+// host name of.
+type SniffDialer interface {
+	Dialer
+
+	// DialSniffed dials target ("ip:port"), which sniffing found to be
+	// for host.
+	DialSniffed(target, host string) (io.ReadWriteCloser, error)
+}
+
 // HandleConnection performs the SOCKS5 handshake.
 // conn: The client connection.
 // dialer: The strategy to connect to the target.
-// enableUDP: Whether to allow UDP ASSOCIATE.
-func HandleConnection(conn io.ReadWriteCloser, dialer Dialer, enableUDP bool) error {
+// opts: Which commands are allowed and their limits.
+func HandleConnection(conn io.ReadWriteCloser, dialer Dialer, opts Options) error {
 	defer conn.Close()
 
+	dl, _ := conn.(interface{ SetDeadline(time.Time) error })
+	if dl != nil && opts.HandshakeTimeout > 0 {
+		dl.SetDeadline(time.Now().Add(opts.HandshakeTimeout))
+	}
+
 	// 1. Negotiation Phase
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(conn, header); err != nil {
@@ -53,9 +108,9 @@ func HandleConnection(conn io.ReadWriteCloser, dialer Dialer, enableUDP bool) er
 
 	cmd := reqHeader[1]
 	if cmd == 0x03 { // UDP ASSOCIATE
-		if !enableUDP {
+		if !opts.EnableUDP {
 			// UDP Disabled
-			conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // Command not supported / prohibited
+			writeReply(conn, ReplyCommandNotSupported)
 			return fmt.Errorf("udp associate disabled")
 		}
 		// Delegate to UDP Handler
@@ -105,24 +160,60 @@ func HandleConnection(conn io.ReadWriteCloser, dialer Dialer, enableUDP bool) er
 	}
 	port := binary.BigEndian.Uint16(portBuf)
 
+	if dl != nil && opts.HandshakeTimeout > 0 {
+		dl.SetDeadline(time.Time{})
+	}
+	if opts.HandshakeDone != nil {
+		opts.HandshakeDone()
+	}
+
 	// If UDP ASSOCIATE, handle it now
 	if cmd == 0x03 {
-		return HandleUDP(conn, dialer)
+		return HandleUDP(conn, dialer, opts.UDP)
 	}
 
 	target := fmt.Sprintf("%s:%d", targetAddr, port)
 
-	// 3. Connect via Dialer
-	destConn, err := dialer.Dial(target)
+	// 3. Connect via Dialer, sniffing the host name first if asked
+	sniffer, _ := dialer.(SniffDialer)
+	if !opts.Sniff || dl == nil || addrType == 0x03 {
+		sniffer = nil
+	}
+	var first []byte
+	var host string
+	if sniffer != nil {
+		writeReply(conn, ReplySucceeded)
+		var err error
+		if first, host, err = sniffConn(conn, dl, opts.SniffTimeout); err != nil {
+			return fmt.Errorf("failed to read from client: %v", err)
+		}
+	}
+	var destConn io.ReadWriteCloser
+	var err error
+	if host != "" {
+		destConn, err = sniffer.DialSniffed(target, host)
+	} else {
+		destConn, err = dialer.Dial(target)
+	}
 	if err != nil {
-		// Error reply
-		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return fmt.Errorf("failed to dial target %s: %v", target, err)
+		if sniffer != nil {
+			return fmt.Errorf("failed to dial target %s: %v", target, err)
+		}
+		// Error reply: say why, e.g. refused vs. unreachable
+		code := ReplyFor(err)
+		writeReply(conn, code)
+		return fmt.Errorf("failed to dial target %s (%s): %v", target, ReplyText(code), err)
 	}
 	defer destConn.Close()
 
-	// Success reply
-	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	if sniffer == nil {
+		// Success reply
+		writeReply(conn, ReplySucceeded)
+	} else if len(first) > 0 {
+		if _, err := destConn.Write(first); err != nil {
+			return err
+		}
+	}
 
 	// 4. Proxy
 	errChan := make(chan error, 2)
@@ -137,3 +228,30 @@ func HandleConnection(conn io.ReadWriteCloser, dialer Dialer, enableUDP bool) er
 
 	return <-errChan
 }
+
+// sniffConn reads what the client sends within timeout, until it names a
+// host or can't. It returns the bytes read and the host, "" for none.
+func sniffConn(conn io.Reader, dl interface{ SetDeadline(time.Time) error }, timeout time.Duration) ([]byte, string, error) {
+	if timeout <= 0 {
+		timeout = DefaultSniffTimeout
+	}
+	dl.SetDeadline(time.Now().Add(timeout))
+	defer dl.SetDeadline(time.Time{})
+	buf := make([]byte, 0, maxSniff)
+	for len(buf) < cap(buf) {
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if n > 0 {
+			host, serr := sniff.Stream(buf)
+			if serr != sniff.ErrShort {
+				return buf, host, nil
+			}
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		} else if err != nil {
+			return nil, "", err
+		}
+	}
+	return buf, "", nil
+}
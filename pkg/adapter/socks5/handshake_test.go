@@ -0,0 +1,56 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// sniffDialer records how it was dialed and hands out one end of a pipe.
+type sniffDialer struct {
+	target, host string
+	peers        chan net.Conn
+}
+
+func (d *sniffDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	return d.DialSniffed(target, "")
+}
+
+func (d *sniffDialer) DialSniffed(target, host string) (io.ReadWriteCloser, error) {
+	d.target, d.host = target, host
+	conn, peer := net.Pipe()
+	d.peers <- peer
+	return conn, nil
+}
+
+func TestHandleConnectionSniff(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	d := &sniffDialer{peers: make(chan net.Conn, 1)}
+	go HandleConnection(server, d, Options{Sniff: true})
+
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	go func() {
+		client.Write([]byte{5, 1, 0})
+		client.Write([]byte{5, 1, 0, 1, 93, 184, 216, 34, 0, 80})
+		client.Write([]byte(request))
+	}()
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != ReplySucceeded {
+		t.Fatalf("got reply %d", reply[3])
+	}
+	got := make([]byte, len(request))
+	peer := <-d.peers
+	if _, err := io.ReadFull(peer, got); err != nil {
+		t.Fatal(err)
+	}
+	if d.target != "93.184.216.34:80" || d.host != "example.com" {
+		t.Errorf("dialed %s as %q", d.target, d.host)
+	}
+	if string(got) != request {
+		t.Errorf("target got %q, want the sniffed request", got)
+	}
+}
@@ -0,0 +1,96 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"phoenix/pkg/outbound"
+	"syscall"
+)
+
+// Reply codes (RFC 1928 section 6).
+const (
+	ReplySucceeded           byte = 0x00
+	ReplyGeneralFailure      byte = 0x01
+	ReplyNotAllowed          byte = 0x02
+	ReplyNetworkUnreachable  byte = 0x03
+	ReplyHostUnreachable     byte = 0x04
+	ReplyConnectionRefused   byte = 0x05
+	ReplyTTLExpired          byte = 0x06
+	ReplyCommandNotSupported byte = 0x07
+	ReplyAddressNotSupported byte = 0x08
+)
+
+// DialError is a failed dial whose reply code is already known, e.g. one
+// reported by a Phoenix server for a tunneled connect.
+type DialError struct {
+	Code byte
+	Err  error
+}
+
+func (e *DialError) Error() string { return e.Err.Error() }
+func (e *DialError) Unwrap() error { return e.Err }
+
+// ReplyFor maps a dial error to the reply code sent to the SOCKS5 client.
+// Timeouts are reported as TTL expired, the closest code RFC 1928 has.
+func ReplyFor(err error) byte {
+	var de *DialError
+	if errors.As(err, &de) {
+		return de.Code
+	}
+	if errors.Is(err, outbound.ErrNotAllowed) {
+		return ReplyNotAllowed
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ReplyTTLExpired
+		}
+		return ReplyHostUnreachable
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.EHOSTDOWN):
+		return ReplyHostUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, syscall.ETIMEDOUT):
+		return ReplyTTLExpired
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ReplyTTLExpired
+	}
+	return ReplyGeneralFailure
+}
+
+// writeReply sends a reply with an empty IPv4 bind address.
+func writeReply(conn io.Writer, code byte) {
+	conn.Write([]byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+}
+
+// ReplyText describes a reply code for logs.
+func ReplyText(code byte) string {
+	switch code {
+	case ReplySucceeded:
+		return "succeeded"
+	case ReplyNotAllowed:
+		return "not allowed by ruleset"
+	case ReplyNetworkUnreachable:
+		return "network unreachable"
+	case ReplyHostUnreachable:
+		return "host unreachable"
+	case ReplyConnectionRefused:
+		return "connection refused"
+	case ReplyTTLExpired:
+		return "TTL expired"
+	case ReplyCommandNotSupported:
+		return "command not supported"
+	case ReplyAddressNotSupported:
+		return "address type not supported"
+	}
+	return fmt.Sprintf("general failure (%d)", code)
+}
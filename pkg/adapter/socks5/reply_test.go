@@ -0,0 +1,35 @@
+package socks5
+
+import (
+	"fmt"
+	"net"
+	"phoenix/pkg/outbound"
+	"testing"
+)
+
+func TestReplyFor(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, refused := net.Dial("tcp", addr)
+
+	cases := []struct {
+		err  error
+		want byte
+	}{
+		{refused, ReplyConnectionRefused},
+		{fmt.Errorf("blocked: %w", outbound.ErrNotAllowed), ReplyNotAllowed},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, ReplyHostUnreachable},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, ReplyTTLExpired},
+		{&DialError{Code: ReplyNetworkUnreachable, Err: fmt.Errorf("remote")}, ReplyNetworkUnreachable},
+		{fmt.Errorf("something else"), ReplyGeneralFailure},
+	}
+	for _, c := range cases {
+		if got := ReplyFor(c.err); got != c.want {
+			t.Errorf("Expected reply %d for %v, got %d", c.want, c.err, got)
+		}
+	}
+}
@@ -7,16 +7,51 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// UDPFilter is a Dialer that also vets the destinations of UDP
+// associations; packets it refuses are dropped.
+type UDPFilter interface {
+	Dialer
+	AllowUDP(dest string) bool
+}
+
+// UDPAnswerer is a Dialer that answers some datagrams itself, such as DNS
+// queries for blocked names.
+type UDPAnswerer interface {
+	Dialer
+
+	// AnswerUDP returns the reply to a datagram to dest, as if from dest,
+	// or nil to relay it.
+	AnswerUDP(dest string, payload []byte) []byte
+}
+
 // HandleUDP establishes a UDP relay.
 // conn: The client TCP connection (must stay open).
 // dialer: The strategy to verify target connectivity (or tunnel).
 // clientAddr: The address of the client requesting UDP.
 // header: The client's UDP request header containing initial destination (optional/ignored for ASSOCIATE usually).
-func HandleUDP(conn io.ReadWriteCloser, dialer Dialer) error {
-	// 1. Listen on a random UDP port
-	udpConn, err := net.ListenPacket("udp", ":0")
+// limits: Session cap, idle timeout and packet size for the association.
+func HandleUDP(conn io.ReadWriteCloser, dialer Dialer, limits UDPLimits) error {
+	release, err := limits.acquireSession()
+	if err != nil {
+		writeReply(conn, ReplyGeneralFailure)
+		return err
+	}
+	defer release()
+
+	// 1. Listen on a random UDP port, on the address the client reached us
+	// at so the reply below names an address of the right family (a
+	// wildcard bind would make us advertise "::" to IPv4 clients).
+	bindAddr := ":0"
+	if c, ok := conn.(net.Conn); ok {
+		if tcp, ok := c.LocalAddr().(*net.TCPAddr); ok && tcp.IP != nil {
+			bindAddr = net.JoinHostPort(tcp.IP.String(), "0")
+		}
+	}
+	udpConn, err := net.ListenPacket("udp", bindAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on UDP: %v", err)
 	}
@@ -31,8 +66,7 @@ func HandleUDP(conn io.ReadWriteCloser, dialer Dialer) error {
 	log.Printf("[SOCKS5] UDP Associate bound to %s", addr)
 
 	// 2. Send Reply: BND.ADDR and BND.PORT
-	// We need IP and Port separate.
-	// Assume IPv4 for simplicity or extract from addr.
+	// IPv4 (including v4-mapped) addresses are sent as ATYP 1, others as ATYP 4.
 	ip := addr.IP.To4()
 	if ip == nil {
 		ip = addr.IP.To16()
@@ -67,7 +101,15 @@ func HandleUDP(conn io.ReadWriteCloser, dialer Dialer) error {
 	defer stream.Close()
 
 	// 4. Relay Loop
-	errChan := make(chan error, 2)
+	errChan := make(chan error, 4)
+	done := make(chan struct{})
+	defer close(done)
+
+	lastActive := time.Now().UnixNano()
+	touch := func() { atomic.StoreInt64(&lastActive, time.Now().UnixNano()) }
+
+	filter, _ := dialer.(UDPFilter)
+	answerer, _ := dialer.(UDPAnswerer)
 
 	// Address Cache: To know where to send responses back to (Client UDP Addr)
 	var clientUDPAddr net.Addr
@@ -91,23 +133,32 @@ func HandleUDP(conn io.ReadWriteCloser, dialer Dialer) error {
 			}
 			mu.Unlock()
 
-			// Validate packet
-			if n < 3 {
-				continue // Too short
+			// Validate packet (drops fragments and truncated headers)
+			dest, off, ok := parseUDPHeader(buf[:n])
+			if !ok || limits.oversize(n-off) {
+				continue
 			}
-			frag := buf[2]
-			if frag != 0x00 {
-				log.Printf("[SOCKS5] UDP Frag %d not supported", frag)
+			if filter != nil && !filter.AllowUDP(dest) {
 				continue
 			}
+			if answerer != nil {
+				if reply := answerer.AnswerUDP(dest, buf[off:n]); reply != nil {
+					// The request header names dest, as the reply's must.
+					packet := append(append([]byte(nil), buf[:off]...), reply...)
+					if _, err := udpConn.WriteTo(packet, peerAddr); err != nil {
+						log.Printf("[SOCKS5-UDP] WriteTo error: %v", err)
+					}
+					continue
+				}
+			}
+			touch()
 
-			// Encapsulate into Stream: [Length (2 bytes)][Packet]
+			// Encapsulate into Stream as one frame (see udp_frame.go).
 			// Packet is buf[:n] (the SOCKS5 UDP request as is).
-
-			// Write length
-			packet := make([]byte, 2+n)
-			binary.BigEndian.PutUint16(packet, uint16(n))
-			copy(packet[2:], buf[:n])
+			packet, ok := encodeUDPFrame(buf[:n], nil)
+			if !ok {
+				continue
+			}
 
 			if _, err := stream.Write(packet); err != nil {
 				log.Printf("[SOCKS5-UDP] Failed to write to stream: %v", err)
@@ -119,28 +170,21 @@ func HandleUDP(conn io.ReadWriteCloser, dialer Dialer) error {
 
 	// Stream -> UDP
 	go func() {
-		header := make([]byte, 2)
+		frames := newFrameReader(stream)
 		for {
-			// Read Length
-			if _, err := io.ReadFull(stream, header); err != nil {
-				errChan <- err
-				return
-			}
-			pktLen := int(binary.BigEndian.Uint16(header))
-			if pktLen > 65535 {
-				errChan <- fmt.Errorf("packet too large: %d", pktLen)
-				return
-			}
-
-			pktBuf := make([]byte, pktLen)
-			if _, err := io.ReadFull(stream, pktBuf); err != nil {
-				log.Printf("[SOCKS5-UDP] Failed to read packet body from stream: %v", err)
+			pktBuf, err := frames.next()
+			if err != nil {
+				log.Printf("[SOCKS5-UDP] Failed to read packet from stream: %v", err)
 				errChan <- err
 				return
 			}
 
 			// The packet is a SOCKS5 UDP header + Data.
-			// Currently we trust Server to send correct packets.
+			_, off, ok := parseUDPHeader(pktBuf)
+			if !ok || limits.oversize(len(pktBuf)-off) {
+				continue
+			}
+			touch()
 
 			// Send to Client
 			mu.Lock()
@@ -173,5 +217,29 @@ func HandleUDP(conn io.ReadWriteCloser, dialer Dialer) error {
 		}
 	}()
 
-	return <-errChan
+	if limits.IdleTimeout > 0 {
+		go func() {
+			ticker := limits.idleTicker()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case now := <-ticker.C:
+					if now.Sub(time.Unix(0, atomic.LoadInt64(&lastActive))) > limits.IdleTimeout {
+						atomic.AddInt64(&udpStats.IdleClosed, 1)
+						errChan <- errUDPIdle
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	err = <-errChan
+	if err == errUDPIdle {
+		log.Printf("[SOCKS5-UDP] Association idle for %v, closing", limits.IdleTimeout)
+		return nil
+	}
+	return err
 }
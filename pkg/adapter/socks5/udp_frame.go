@@ -0,0 +1,71 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// UDP datagrams cross the tunnel as frames on a single HTTP/2 stream:
+//
+//	[Len: 2 bytes, big-endian][SOCKS5 UDP header][payload]
+//
+// Len counts the header and payload. The stream may split or coalesce the
+// bytes of consecutive frames in any way, so a frame is only acted on once
+// Len bytes have been read, and every frame is written with one Write call
+// under a lock so frames from concurrent senders never interleave. One frame
+// always carries exactly one datagram: datagrams that don't fit are dropped
+// rather than split, and SOCKS5 fragments (FRAG != 0) are rejected, so
+// QUIC and DNS see the same datagrams on both ends.
+const (
+	// maxUDPFrame is the largest frame body Len can describe.
+	maxUDPFrame = 0xFFFF
+
+	// minUDPFrame is the shortest valid body: RSV, FRAG, ATYP and a port
+	// after an empty domain.
+	minUDPFrame = 7
+)
+
+// frameReader reads UDP frames from a tunnel stream.
+type frameReader struct {
+	r   io.Reader
+	len [2]byte
+	buf [maxUDPFrame]byte
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: r}
+}
+
+// next returns the body of the next frame. It is only valid until the next
+// call. A frame too short to hold a header means the peer is broken or the
+// stream is out of sync, and is returned as an error.
+func (f *frameReader) next() ([]byte, error) {
+	if _, err := io.ReadFull(f.r, f.len[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(f.len[:]))
+	if n < minUDPFrame {
+		return nil, fmt.Errorf("invalid udp frame length %d", n)
+	}
+	if _, err := io.ReadFull(f.r, f.buf[:n]); err != nil {
+		return nil, fmt.Errorf("truncated udp frame: %v", err)
+	}
+	return f.buf[:n], nil
+}
+
+// encodeUDPFrame builds the frame for header+payload in one buffer, so it
+// can be sent with a single Write. ok is false when the datagram cannot fit.
+func encodeUDPFrame(header, payload []byte) (frame []byte, ok bool) {
+	n := len(header) + len(payload)
+	if n > maxUDPFrame {
+		atomic.AddInt64(&udpStats.OversizeDrops, 1)
+		return nil, false
+	}
+	frame = make([]byte, 2+n)
+	binary.BigEndian.PutUint16(frame, uint16(n))
+	copy(frame[2:], header)
+	copy(frame[2+len(header):], payload)
+	return frame, true
+}
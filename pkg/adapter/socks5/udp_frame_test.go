@@ -0,0 +1,52 @@
+package socks5
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+)
+
+func TestUDPFrames(t *testing.T) {
+	header := []byte{0, 0, 0, 0x01, 127, 0, 0, 1, 0, 53}
+	var stream bytes.Buffer
+	for _, payload := range []string{"first", "", "third datagram"} {
+		frame, ok := encodeUDPFrame(header, []byte(payload))
+		if !ok {
+			t.Fatalf("Expected %q to fit in a frame", payload)
+		}
+		stream.Write(frame)
+	}
+
+	// Deliver the coalesced frames one byte at a time.
+	frames := newFrameReader(iotest.OneByteReader(&stream))
+	for _, want := range []string{"first", "", "third datagram"} {
+		body, err := frames.next()
+		if err != nil {
+			t.Fatalf("Expected frame %q, got error %v", want, err)
+		}
+		if got := string(body[len(header):]); got != want {
+			t.Errorf("Expected payload %q, got %q", want, got)
+		}
+	}
+
+	if _, ok := encodeUDPFrame(header, make([]byte, maxUDPFrame)); ok {
+		t.Errorf("Expected a datagram larger than a frame to be rejected")
+	}
+
+	bad := newFrameReader(bytes.NewReader([]byte{0, 2, 0, 0}))
+	if _, err := bad.next(); err == nil {
+		t.Errorf("Expected a frame shorter than a UDP header to be rejected")
+	}
+}
+
+func TestParseUDPHeaderRejectsFragments(t *testing.T) {
+	pkt := []byte{0, 0, 1, 0x01, 127, 0, 0, 1, 0, 53, 'x'}
+	if _, _, ok := parseUDPHeader(pkt); ok {
+		t.Errorf("Expected fragmented datagram to be rejected")
+	}
+	pkt[2] = 0
+	dest, off, ok := parseUDPHeader(pkt)
+	if !ok || dest != "127.0.0.1:53" || off != 10 {
+		t.Errorf("Expected 127.0.0.1:53 at offset 10, got %q %d %v", dest, off, ok)
+	}
+}
@@ -0,0 +1,127 @@
+package socks5
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the UDPLimits fields that are never unlimited.
+const (
+	DefaultUDPMaxPeers     = 1024            // NAT table entries per session
+	DefaultUDPQueueDepth   = 256             // datagrams queued for a slow client
+	DefaultUDPSocketBuffer = 4 * 1024 * 1024 // bytes per relay socket
+)
+
+// errUDPIdle ends an association that carried no packets for IdleTimeout.
+var errUDPIdle = fmt.Errorf("udp association idle")
+
+// UDPLimits bounds UDP associations. Zero values mean no limit, except
+// for MaxPeers, QueueDepth and SocketBuffer, which have defaults.
+type UDPLimits struct {
+	// IdleTimeout closes an association, and expires NAT table entries,
+	// after this long without packets.
+	IdleTimeout time.Duration
+
+	// MaxSessions caps concurrent associations in this process.
+	MaxSessions int
+
+	// MaxPeers caps the NAT table of one session (contacted peers when
+	// restricted, sockets when symmetric). The least recently used entry is
+	// evicted when it is full.
+	MaxPeers int
+
+	// MaxPacketSize drops datagrams with a larger payload.
+	MaxPacketSize int
+
+	// QueueDepth caps datagrams waiting to be written to a session's
+	// stream; when a client reads too slowly, newer datagrams are dropped
+	// rather than buffered. Server only.
+	QueueDepth int
+
+	// SocketBuffer sets the kernel receive and send buffers of each relay
+	// socket, in bytes. With symmetric NAT every destination has its own
+	// socket, so this is multiplied by the NAT table size. Server only.
+	SocketBuffer int
+}
+
+func (l UDPLimits) queueDepth() int {
+	if l.QueueDepth <= 0 {
+		return DefaultUDPQueueDepth
+	}
+	return l.QueueDepth
+}
+
+func (l UDPLimits) socketBuffer() int {
+	if l.SocketBuffer <= 0 {
+		return DefaultUDPSocketBuffer
+	}
+	return l.SocketBuffer
+}
+
+func (l UDPLimits) maxPeers() int {
+	if l.MaxPeers <= 0 {
+		return DefaultUDPMaxPeers
+	}
+	return l.MaxPeers
+}
+
+func (l UDPLimits) oversize(n int) bool {
+	if l.MaxPacketSize > 0 && n > l.MaxPacketSize {
+		atomic.AddInt64(&udpStats.OversizeDrops, 1)
+		return true
+	}
+	return false
+}
+
+// UDPCounters are process-wide UDP relay statistics.
+type UDPCounters struct {
+	ActiveSessions   int64 // Associations currently open
+	Sessions         int64 // Associations opened since start
+	RejectedSessions int64 // Associations refused by MaxSessions
+	IdleClosed       int64 // Associations closed by IdleTimeout
+	IdleEvictions    int64 // NAT table entries expired by IdleTimeout
+	TableEvictions   int64 // NAT table entries evicted by MaxPeers
+	OversizeDrops    int64 // Datagrams dropped by MaxPacketSize or too large for a frame
+	FragmentDrops    int64 // SOCKS5 fragments (FRAG != 0), which are not supported
+	QueueDrops       int64 // Datagrams dropped because the client's queue was full
+}
+
+var udpStats UDPCounters
+
+// UDPStats returns a snapshot of the UDP relay counters.
+func UDPStats() UDPCounters {
+	return UDPCounters{
+		ActiveSessions:   atomic.LoadInt64(&udpStats.ActiveSessions),
+		Sessions:         atomic.LoadInt64(&udpStats.Sessions),
+		RejectedSessions: atomic.LoadInt64(&udpStats.RejectedSessions),
+		IdleClosed:       atomic.LoadInt64(&udpStats.IdleClosed),
+		IdleEvictions:    atomic.LoadInt64(&udpStats.IdleEvictions),
+		TableEvictions:   atomic.LoadInt64(&udpStats.TableEvictions),
+		OversizeDrops:    atomic.LoadInt64(&udpStats.OversizeDrops),
+		FragmentDrops:    atomic.LoadInt64(&udpStats.FragmentDrops),
+		QueueDrops:       atomic.LoadInt64(&udpStats.QueueDrops),
+	}
+}
+
+// acquireSession counts a new association, refusing it when MaxSessions are
+// already open. Call the returned func when the association ends.
+func (l UDPLimits) acquireSession() (release func(), err error) {
+	n := atomic.AddInt64(&udpStats.ActiveSessions, 1)
+	if l.MaxSessions > 0 && n > int64(l.MaxSessions) {
+		atomic.AddInt64(&udpStats.ActiveSessions, -1)
+		atomic.AddInt64(&udpStats.RejectedSessions, 1)
+		return nil, fmt.Errorf("udp session limit (%d) reached", l.MaxSessions)
+	}
+	atomic.AddInt64(&udpStats.Sessions, 1)
+	return func() { atomic.AddInt64(&udpStats.ActiveSessions, -1) }, nil
+}
+
+// idleTicker returns how often to check for idle associations and entries.
+func (l UDPLimits) idleTicker() *time.Ticker {
+	interval := l.IdleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return time.NewTicker(interval)
+}
@@ -1,175 +1,538 @@
 package socks5
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"phoenix/pkg/sniff"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// NAT behaviors for a server-side UDP session.
+const (
+	// NATFullCone uses one socket per session and accepts packets from any
+	// remote peer on it (endpoint-independent mapping and filtering). Games
+	// and P2P apps need this to receive from peers they haven't contacted.
+	NATFullCone = "full-cone"
+
+	// NATRestricted uses one socket per session but only accepts packets
+	// from address:port pairs the client has sent to.
+	NATRestricted = "restricted"
+
+	// NATSymmetric uses a separate socket per destination, each accepting
+	// packets only from that destination.
+	NATSymmetric = "symmetric"
+)
+
+// UDPTunnelOptions configures a server-side UDP tunnel session.
+type UDPTunnelOptions struct {
+	// LocalAddr is the address the session's socket binds (default ":0").
+	LocalAddr string
+
+	// Control, if set, is called on the session's sockets before they
+	// bind, e.g. to set an outbound interface or firewall mark.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// Allow, if set, is consulted for each destination ("host:port");
+	// packets to disallowed destinations are dropped. QUIC Initial packets
+	// to an IP are also checked with the SNI they carry in place of the IP.
+	Allow func(dest string) bool
+
+	// NAT selects the mapping/filtering behavior (default NATFullCone).
+	NAT string
+
+	// Limits bounds the session's lifetime, NAT table and packet size.
+	Limits UDPLimits
+
+	// Resolve, if set, picks the address for a destination (default
+	// net.ResolveUDPAddr), e.g. to honor an address family preference.
+	Resolve func(dest string) (*net.UDPAddr, error)
+
+	// DNS, if set, answers datagrams to port 53: it gets the query and the
+	// server it was sent to, and returns the response. Those datagrams skip
+	// the session's sockets and NAT table.
+	DNS func(query []byte, server string) ([]byte, error)
+}
+
+// maxDNSInflight bounds concurrent DNS fast-path queries per session; more
+// are relayed like other datagrams.
+const maxDNSInflight = 64
+
+// maxQUICSniffs bounds the QUIC handshakes a session sniffs at once.
+const maxQUICSniffs = 64
+
+// udpSession relays one UDP tunnel stream.
+type udpSession struct {
+	stream io.ReadWriteCloser
+	opts   UDPTunnelOptions
+	errs   chan error
+	done   chan struct{}
+
+	lastActive int64       // UnixNano of the last packet in either direction
+	queue      chan []byte // Frames for the stream, written by writeLoop
+
+	dnsSlots chan struct{}
+
+	hellos map[string]*sniff.QUICHello // By IP destination, for allowed
+
+	mu      sync.Mutex
+	closed  bool
+	shared  net.PacketConn       // full-cone / restricted socket
+	entries map[string]*natEntry // restricted: contacted peers; symmetric: socket per destination
+
+	idleEvictions, tableEvictions int // For the closing log line
+}
+
+// natEntry is one mapping of the session's NAT table.
+type natEntry struct {
+	conn net.PacketConn // symmetric only
+	last time.Time
+}
+
 // HandleUDPTunnel handles the server-side logic for a UDP tunnel stream.
 // It reads encapsulated UDP packets from the stream, sends them to the target,
 // and relays responses back.
-func HandleUDPTunnel(stream io.ReadWriteCloser) error {
+func HandleUDPTunnel(stream io.ReadWriteCloser, opts UDPTunnelOptions) error {
 	defer stream.Close()
 
-	// 1. Create a local UDP socket for this session
-	udpConn, err := net.ListenPacket("udp", ":0")
+	if opts.LocalAddr == "" {
+		opts.LocalAddr = ":0"
+	}
+	switch opts.NAT {
+	case "":
+		opts.NAT = NATFullCone
+	case NATFullCone, NATRestricted, NATSymmetric:
+	default:
+		return fmt.Errorf("unknown udp nat mode %q", opts.NAT)
+	}
+
+	release, err := opts.Limits.acquireSession()
 	if err != nil {
-		return fmt.Errorf("failed to bind udp socket: %v", err)
+		return err
+	}
+	defer release()
+
+	s := &udpSession{
+		stream:   stream,
+		opts:     opts,
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		entries:  map[string]*natEntry{},
+		dnsSlots: make(chan struct{}, maxDNSInflight),
+		hellos:   map[string]*sniff.QUICHello{},
+		queue:    make(chan []byte, opts.Limits.queueDepth()),
 	}
-	defer udpConn.Close()
+	s.touch()
+	defer s.closeSockets()
 
-	// Increase socket buffers to handle bursts (e.g. YouTube QUIC)
-	if c, ok := udpConn.(*net.UDPConn); ok {
-		c.SetReadBuffer(4 * 1024 * 1024)
-		c.SetWriteBuffer(4 * 1024 * 1024)
+	if opts.NAT != NATSymmetric {
+		// 1. Create a local UDP socket for this session
+		conn, err := s.listen()
+		if err != nil {
+			return err
+		}
+		s.shared = conn
+		go s.readLoop(conn, "")
 	}
 
 	// 2. Stream -> UDP Loop
-	errChan := make(chan error, 2)
-	go func() {
-		header := make([]byte, 2)
-		for {
-			// Read Length
-			if _, err := io.ReadFull(stream, header); err != nil {
-				log.Printf("[SOCKS5-UDP-Server] Stream read error: %v", err)
-				errChan <- err
+	go s.streamLoop()
+	go s.writeLoop()
+	if opts.Limits.IdleTimeout > 0 {
+		go s.expireLoop()
+	}
+
+	err = <-s.errs
+	close(s.done)
+	s.mu.Lock()
+	log.Printf("[SOCKS5-UDP-Server] Closing session due to: %v (evicted: %d idle, %d table-full)", err, s.idleEvictions, s.tableEvictions)
+	s.mu.Unlock()
+	if err == errUDPIdle {
+		return nil
+	}
+	return err
+}
+
+// fail ends the session; only the first error is kept.
+func (s *udpSession) fail(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+func (s *udpSession) listen() (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: s.opts.Control}
+	conn, err := lc.ListenPacket(context.Background(), "udp", s.opts.LocalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind udp socket: %v", err)
+	}
+	// Large socket buffers absorb bursts (e.g. YouTube QUIC)
+	if c, ok := conn.(*net.UDPConn); ok {
+		c.SetReadBuffer(s.opts.Limits.socketBuffer())
+		c.SetWriteBuffer(s.opts.Limits.socketBuffer())
+	}
+	return conn, nil
+}
+
+func (s *udpSession) closeSockets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.shared != nil {
+		s.shared.Close()
+	}
+	for _, e := range s.entries {
+		if e.conn != nil {
+			e.conn.Close()
+		}
+	}
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// allowed reports whether opts.Allow passes a packet to dest. Only
+// streamLoop calls it.
+func (s *udpSession) allowed(dest string, payload []byte) bool {
+	if !s.opts.Allow(dest) {
+		return false
+	}
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil || net.ParseIP(host) == nil {
+		return true
+	}
+	// Dropping the Initials that complete a disallowed name is enough: the
+	// handshake can't finish without them.
+	h := s.hellos[dest]
+	if h == nil {
+		if !sniff.IsQUICInitial(payload) {
+			return true
+		}
+		if len(s.hellos) >= maxQUICSniffs {
+			clear(s.hellos)
+		}
+		h = &sniff.QUICHello{}
+		s.hellos[dest] = h
+	}
+	if name, err := h.Add(payload); err == nil {
+		return s.opts.Allow(net.JoinHostPort(name, port))
+	}
+	return true
+}
+
+// expireLoop ends the session after IdleTimeout without packets and drops
+// NAT table entries that have been idle as long.
+func (s *udpSession) expireLoop() {
+	timeout := s.opts.Limits.IdleTimeout
+	ticker := s.opts.Limits.idleTicker()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastActive))) > timeout {
+				atomic.AddInt64(&udpStats.IdleClosed, 1)
+				s.fail(errUDPIdle)
 				return
 			}
-			pktLen := int(binary.BigEndian.Uint16(header))
-			if pktLen > 65535 {
-				errChan <- fmt.Errorf("packet too large: %d", pktLen)
-				return
+			s.mu.Lock()
+			for key, e := range s.entries {
+				if now.Sub(e.last) > timeout {
+					s.removeLocked(key)
+					s.idleEvictions++
+					atomic.AddInt64(&udpStats.IdleEvictions, 1)
+				}
 			}
+			s.mu.Unlock()
+		}
+	}
+}
 
-			pktBuf := make([]byte, pktLen)
-			if _, err := io.ReadFull(stream, pktBuf); err != nil {
-				errChan <- err
-				return
-			}
+// removeLocked drops a NAT table entry. s.mu must be held.
+func (s *udpSession) removeLocked(key string) {
+	if e := s.entries[key]; e != nil && e.conn != nil {
+		e.conn.Close()
+	}
+	delete(s.entries, key)
+}
 
-			// Parse SOCKS5 UDP Header to extract Destination
-			// Format: [RSV][FRAG][ATYP][DST.ADDR][DST.PORT][DATA]
-			if len(pktBuf) < 10 { // Min header size (IPv4)
-				log.Printf("[SOCKS5-UDP] Packet too short")
-				continue
-			}
+// evictOldestLocked makes room in a full NAT table by dropping the least
+// recently used entry. s.mu must be held.
+func (s *udpSession) evictOldestLocked() {
+	var oldest string
+	var oldestTime time.Time
+	for key, e := range s.entries {
+		if oldest == "" || e.last.Before(oldestTime) {
+			oldest, oldestTime = key, e.last
+		}
+	}
+	log.Printf("[SOCKS5-UDP-Server] NAT table full (%d entries), evicting %s", len(s.entries), oldest)
+	s.removeLocked(oldest)
+	s.tableEvictions++
+	atomic.AddInt64(&udpStats.TableEvictions, 1)
+}
 
-			// Offset 3 is ATYP
-			atyp := pktBuf[3]
-			var destAddr string
-			var dataOffset int
+// streamLoop reads encapsulated packets from the stream and sends them out.
+func (s *udpSession) streamLoop() {
+	frames := newFrameReader(s.stream)
+	for {
+		pktBuf, err := frames.next()
+		if err != nil {
+			log.Printf("[SOCKS5-UDP-Server] Stream read error: %v", err)
+			s.fail(err)
+			return
+		}
 
-			switch atyp {
-			case 0x01: // IPv4
-				if len(pktBuf) < 10 {
-					continue
-				}
-				ip := net.IP(pktBuf[4:8])
-				port := binary.BigEndian.Uint16(pktBuf[8:10])
-				destAddr = fmt.Sprintf("%s:%d", ip, port)
-				dataOffset = 10
-			case 0x03: // Domain
-				if len(pktBuf) < 5 {
-					continue
-				}
-				domainLen := int(pktBuf[4])
-				if len(pktBuf) < 5+domainLen+2 {
-					continue
-				}
-				domain := string(pktBuf[5 : 5+domainLen])
-				port := binary.BigEndian.Uint16(pktBuf[5+domainLen : 5+domainLen+2])
-				destAddr = fmt.Sprintf("%s:%d", domain, port)
-				dataOffset = 5 + domainLen + 2
-			case 0x04: // IPv6
-				if len(pktBuf) < 22 {
-					continue
-				}
-				ip := net.IP(pktBuf[4:20])
-				port := binary.BigEndian.Uint16(pktBuf[20:22])
-				destAddr = fmt.Sprintf("[%s]:%d", ip, port)
-				dataOffset = 22
-			default:
-				log.Printf("[SOCKS5-UDP] Unknown ATYP %d", atyp)
-				continue
-			}
+		destAddr, dataOffset, ok := parseUDPHeader(pktBuf)
+		if !ok {
+			continue
+		}
+		if s.opts.Limits.oversize(len(pktBuf) - dataOffset) {
+			continue
+		}
+		s.touch()
 
-			// Resolve Address
-			uAddr, err := net.ResolveUDPAddr("udp", destAddr)
-			if err != nil {
-				log.Printf("[SOCKS5-UDP] Resolve error for %s: %v", destAddr, err)
-				continue
-			}
+		if s.opts.Allow != nil && !s.allowed(destAddr, pktBuf[dataOffset:]) {
+			log.Printf("[SOCKS5-UDP] Dropping packet to %s: destination not allowed", destAddr)
+			continue
+		}
 
-			// Payload
-			payload := pktBuf[dataOffset:]
+		// Resolve Address
+		uAddr, err := s.resolve(destAddr)
+		if err != nil {
+			log.Printf("[SOCKS5-UDP] Resolve error for %s: %v", destAddr, err)
+			continue
+		}
 
-			// Write to Target
-			if _, err := udpConn.WriteTo(payload, uAddr); err != nil {
-				log.Printf("[SOCKS5-UDP] WriteTo error: %v", err)
-				// Don't kill stream on single packet error
-				continue
-			}
+		if s.opts.DNS != nil && uAddr.Port == 53 && s.answerDNS(uAddr, pktBuf[dataOffset:]) {
+			continue
 		}
-	}()
 
-	// 3. UDP -> Stream Loop
+		conn, err := s.socketFor(uAddr)
+		if err != nil {
+			log.Printf("[SOCKS5-UDP] %v", err)
+			continue
+		}
+
+		// Write to Target
+		if _, err := conn.WriteTo(pktBuf[dataOffset:], uAddr); err != nil {
+			log.Printf("[SOCKS5-UDP] WriteTo error: %v", err)
+			// Don't kill stream on single packet error
+			continue
+		}
+	}
+}
+
+func (s *udpSession) resolve(dest string) (*net.UDPAddr, error) {
+	if s.opts.Resolve != nil {
+		return s.opts.Resolve(dest)
+	}
+	return net.ResolveUDPAddr("udp", dest)
+}
+
+// answerDNS resolves query through the DNS fast path in the background and
+// writes the answer back as if it came from server. It returns false when
+// too many queries are already in flight.
+func (s *udpSession) answerDNS(server *net.UDPAddr, query []byte) bool {
+	select {
+	case s.dnsSlots <- struct{}{}:
+	default:
+		return false
+	}
+	query = append([]byte(nil), query...) // The frame buffer is reused
 	go func() {
-		buf := make([]byte, 65535)
-		for {
-			n, peerAddr, err := udpConn.ReadFrom(buf)
-			if err != nil {
-				log.Printf("[SOCKS5-UDP-Server] ReadFrom UDP error: %v", err)
-				errChan <- err
-				return
-			}
+		defer func() { <-s.dnsSlots }()
+		resp, err := s.opts.DNS(query, server.String())
+		if err != nil {
+			log.Printf("[SOCKS5-UDP] DNS fast path: %v", err)
+			return
+		}
+		s.touch()
+		s.queuePacket(server, resp)
+	}()
+	return true
+}
 
-			// Construct SOCKS5 UDP Packet
-			// We need to encode peerAddr back into SOCKS5 headers.
-			// Format: [RSV=0][FRAG=0][ATYP][ADDR][PORT][DATA]
-			//
-			// peerAddr is net.Addr. usually *net.UDPAddr.
-			udpAddr, ok := peerAddr.(*net.UDPAddr)
-			if !ok {
-				continue
-			}
+// socketFor returns the socket used to send to dest, recording dest as a
+// contacted peer (restricted) or creating its mapping (symmetric).
+func (s *udpSession) socketFor(dest *net.UDPAddr) (net.PacketConn, error) {
+	key := dest.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-			ip := udpAddr.IP
-			port := udpAddr.Port
-
-			var header []byte
-			if ip4 := ip.To4(); ip4 != nil {
-				header = make([]byte, 10)
-				header[3] = 0x01 // IPv4
-				copy(header[4:], ip4)
-				binary.BigEndian.PutUint16(header[8:], uint16(port))
-			} else {
-				header = make([]byte, 22) // IPv6
-				header[3] = 0x04
-				copy(header[4:], ip)
-				binary.BigEndian.PutUint16(header[20:], uint16(port))
-			}
+	if s.opts.NAT == NATFullCone {
+		return s.shared, nil
+	}
+	if e, ok := s.entries[key]; ok {
+		e.last = time.Now()
+		if e.conn != nil {
+			return e.conn, nil
+		}
+		return s.shared, nil
+	}
+	if s.closed {
+		return nil, fmt.Errorf("session closed")
+	}
+	if len(s.entries) >= s.opts.Limits.maxPeers() {
+		s.evictOldestLocked()
+	}
 
-			// Pkt = Header + Data
-			totalLen := len(header) + n
+	e := &natEntry{last: time.Now()}
+	if s.opts.NAT == NATSymmetric {
+		c, err := s.listen()
+		if err != nil {
+			return nil, err
+		}
+		e.conn = c
+		go s.readLoop(c, key)
+	}
+	s.entries[key] = e
+	if e.conn != nil {
+		return e.conn, nil
+	}
+	return s.shared, nil
+}
+
+// accepts applies the session's filtering to a packet from peer arriving on
+// a socket mapped to only (symmetric) or shared by all destinations.
+func (s *udpSession) accepts(peer, only string) bool {
+	if s.opts.NAT == NATFullCone {
+		return true
+	}
+	if s.opts.NAT == NATSymmetric && peer != only {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[peer]
+	if ok {
+		e.last = time.Now()
+	}
+	return ok
+}
 
-			// Write Stream: [Len][Header][Data] in single write for atomicity
-			packet := make([]byte, 2+len(header)+n)
-			binary.BigEndian.PutUint16(packet, uint16(totalLen))
-			copy(packet[2:], header)
-			copy(packet[2+len(header):], buf[:n])
+// readLoop relays packets arriving on conn back into the stream.
+func (s *udpSession) readLoop(conn net.PacketConn, only string) {
+	buf := make([]byte, 65535)
+	for {
+		n, peerAddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if only == "" {
+				log.Printf("[SOCKS5-UDP-Server] ReadFrom UDP error: %v", err)
+				s.fail(err)
+			}
+			return
+		}
 
-			if _, err := stream.Write(packet); err != nil {
+		udpAddr, ok := peerAddr.(*net.UDPAddr)
+		if !ok || !s.accepts(udpAddr.String(), only) {
+			continue
+		}
+		if s.opts.Limits.oversize(n) {
+			continue
+		}
+		s.touch()
+		s.queuePacket(udpAddr, buf[:n])
+	}
+}
+
+// writeLoop writes queued frames to the stream.
+func (s *udpSession) writeLoop() {
+	for {
+		select {
+		case packet := <-s.queue:
+			if _, err := s.stream.Write(packet); err != nil {
 				log.Printf("[SOCKS5-UDP-Server] Failed to write to stream: %v", err)
-				errChan <- err
+				s.fail(err)
 				return
 			}
+		case <-s.done:
+			return
 		}
-	}()
+	}
+}
 
-	err = <-errChan
-	log.Printf("[SOCKS5-UDP-Server] Closing session due to: %v", err)
-	return err
+// queuePacket queues a datagram from a remote peer for the client as one
+// frame, dropping it if the client is QueueDepth frames behind.
+func (s *udpSession) queuePacket(from *net.UDPAddr, data []byte) {
+	// Construct SOCKS5 UDP Packet
+	// Format: [RSV=0][FRAG=0][ATYP][ADDR][PORT][DATA]
+	var header []byte
+	if ip4 := from.IP.To4(); ip4 != nil {
+		header = make([]byte, 10)
+		header[3] = 0x01 // IPv4
+		copy(header[4:], ip4)
+		binary.BigEndian.PutUint16(header[8:], uint16(from.Port))
+	} else {
+		header = make([]byte, 22) // IPv6
+		header[3] = 0x04
+		copy(header[4:], from.IP.To16())
+		binary.BigEndian.PutUint16(header[20:], uint16(from.Port))
+	}
+
+	packet, ok := encodeUDPFrame(header, data)
+	if !ok {
+		return
+	}
+	select {
+	case s.queue <- packet:
+	default:
+		atomic.AddInt64(&udpStats.QueueDrops, 1)
+	}
+}
+
+// parseUDPHeader extracts the destination from a SOCKS5 UDP request header.
+// Format: [RSV][FRAG][ATYP][DST.ADDR][DST.PORT][DATA]
+// Fragmented datagrams (FRAG != 0) are rejected.
+func parseUDPHeader(pktBuf []byte) (dest string, dataOffset int, ok bool) {
+	if len(pktBuf) < minUDPFrame {
+		log.Printf("[SOCKS5-UDP] Packet too short")
+		return "", 0, false
+	}
+	if frag := pktBuf[2]; frag != 0x00 {
+		log.Printf("[SOCKS5-UDP] Dropping fragment %d: fragmentation not supported", frag)
+		atomic.AddInt64(&udpStats.FragmentDrops, 1)
+		return "", 0, false
+	}
+
+	// Offset 3 is ATYP
+	switch atyp := pktBuf[3]; atyp {
+	case 0x01: // IPv4
+		if len(pktBuf) < 10 {
+			return "", 0, false
+		}
+		ip := net.IP(pktBuf[4:8])
+		port := binary.BigEndian.Uint16(pktBuf[8:10])
+		return fmt.Sprintf("%s:%d", ip, port), 10, true
+	case 0x03: // Domain
+		if len(pktBuf) < 5 {
+			return "", 0, false
+		}
+		domainLen := int(pktBuf[4])
+		if len(pktBuf) < 5+domainLen+2 {
+			return "", 0, false
+		}
+		domain := string(pktBuf[5 : 5+domainLen])
+		port := binary.BigEndian.Uint16(pktBuf[5+domainLen : 5+domainLen+2])
+		return fmt.Sprintf("%s:%d", domain, port), 5 + domainLen + 2, true
+	case 0x04: // IPv6
+		if len(pktBuf) < 22 {
+			return "", 0, false
+		}
+		ip := net.IP(pktBuf[4:20])
+		port := binary.BigEndian.Uint16(pktBuf[20:22])
+		return fmt.Sprintf("[%s]:%d", ip, port), 22, true
+	default:
+		log.Printf("[SOCKS5-UDP] Unknown ATYP %d", atyp)
+		return "", 0, false
+	}
 }
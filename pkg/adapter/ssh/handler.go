@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 )
 
 // HandleConnection receives an SSH connection from the client and proxies it to the target.
@@ -27,7 +26,9 @@ import (
 //
 // Let's modify the H2C protocol to include a Target header.
 // `X-Nerve-Target: host:port`
-func HandleConnection(rw io.ReadWriteCloser, target string) error {
+//
+// dialer connects to the target (directly or through an upstream proxy).
+func HandleConnection(rw io.ReadWriteCloser, target string, dialer Dialer) error {
 	defer rw.Close()
 
 	if target == "" {
@@ -36,14 +37,38 @@ func HandleConnection(rw io.ReadWriteCloser, target string) error {
 	}
 
 	log.Printf("[SSH] Tunneling to %s", target)
-	destConn, err := net.Dial("tcp", target)
+	destConn, err := dialer.Dial(target)
 	if err != nil {
 		return fmt.Errorf("failed to dial SSH target %s: %v", target, err)
 	}
+	return Relay(rw, destConn)
+}
+
+// Relay copies between rw and an already connected destConn until destConn
+// is done, then closes both.
+func Relay(rw, destConn io.ReadWriteCloser) error {
+	defer rw.Close()
 	defer destConn.Close()
 
-	// Bidirectional copy
-	go io.Copy(destConn, rw)
-	_, err = io.Copy(rw, destConn)
-	return err
+	// Bidirectional copy; the client's EOF is passed on as a TCP half-close.
+	// A failed client side (reset or timed-out stream) aborts the target
+	// so the copy below returns too.
+	aborted := make(chan struct{}, 1)
+	go func() {
+		if _, err := io.Copy(destConn, rw); err != nil {
+			aborted <- struct{}{}
+			destConn.Close()
+			return
+		}
+		if cw, ok := destConn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	_, err := io.Copy(rw, destConn)
+	select {
+	case <-aborted:
+		return nil // The client went away; its error is not the target's
+	default:
+		return err
+	}
 }
@@ -0,0 +1,172 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"phoenix/pkg/crypto"
+	"strconv"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout bounds the SSH handshake and authentication, so
+// clients that stall before logging in don't hold connections open.
+const handshakeTimeout = 30 * time.Second
+
+// Dialer abstracts connection creation to the Phoenix server tunnel.
+type Dialer interface {
+	Dial(target string) (io.ReadWriteCloser, error)
+}
+
+// Server is a minimal SSH server for use as a jump host: it authenticates
+// clients by public key and relays their direct-tcpip channels (ssh -J, -L
+// and -D) through the tunnel. Shell sessions are refused.
+type Server struct {
+	config *gossh.ServerConfig
+}
+
+// NewServer loads the host key (generating it on first use) and the
+// authorized_keys file.
+func NewServer(hostKeyPath, authorizedKeysPath string) (*Server, error) {
+	signer, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(authorizedKeysPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized_keys: %v", err)
+	}
+	authorized := map[string]bool{}
+	for len(bytes.TrimSpace(data)) > 0 {
+		pub, _, _, rest, err := gossh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorized_keys: %v", err)
+		}
+		authorized[string(pub.Marshal())] = true
+		data = rest
+	}
+	if len(authorized) == 0 {
+		return nil, fmt.Errorf("authorized_keys %s contains no keys", authorizedKeysPath)
+	}
+
+	cfg := &gossh.ServerConfig{
+		PublicKeyCallback: func(meta gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if authorized[string(key.Marshal())] {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", meta.User())
+		},
+		ServerVersion: "SSH-2.0-OpenSSH_9.6",
+	}
+	cfg.AddHostKey(signer)
+	return &Server{config: cfg}, nil
+}
+
+// loadHostKey reads a PEM host key, creating an Ed25519 key at path if the
+// file does not exist yet.
+func loadHostKey(path string) (gossh.Signer, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		privPEM, _, err := crypto.GenerateKeypair()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %v", err)
+		}
+		if err := os.WriteFile(path, privPEM, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write host key: %v", err)
+		}
+		log.Printf("[SSH] Generated host key %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %v", err)
+	}
+	signer, err := gossh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %v", err)
+	}
+	return signer, nil
+}
+
+// HandleConnection runs the SSH handshake on conn and serves its channels
+// until the client disconnects.
+func (s *Server) HandleConnection(conn net.Conn, dialer Dialer) error {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sconn, chans, reqs, err := gossh.NewServerConn(conn, s.config)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %v", err)
+	}
+	defer sconn.Close()
+	conn.SetDeadline(time.Time{})
+	log.Printf("[SSH] %s logged in from %s", sconn.User(), sconn.RemoteAddr())
+
+	// Global requests (tcpip-forward, keepalives) are declined.
+	go gossh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "direct-tcpip" {
+			newCh.Reject(gossh.Prohibited, "only port forwarding is supported (use ssh -N, -J, -L or -D)")
+			continue
+		}
+		target, err := parseDirectTCPIP(newCh.ExtraData())
+		if err != nil {
+			newCh.Reject(gossh.ConnectionFailed, err.Error())
+			continue
+		}
+		go relayChannel(newCh, target, dialer)
+	}
+	return nil
+}
+
+// parseDirectTCPIP extracts host:port from a direct-tcpip channel request
+// (RFC 4254 section 7.2).
+func parseDirectTCPIP(data []byte) (string, error) {
+	var msg struct {
+		Host       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	if err := gossh.Unmarshal(data, &msg); err != nil {
+		return "", fmt.Errorf("invalid direct-tcpip request: %v", err)
+	}
+	if msg.Port == 0 || msg.Port > 65535 {
+		return "", fmt.Errorf("invalid port %d", msg.Port)
+	}
+	return net.JoinHostPort(msg.Host, strconv.Itoa(int(msg.Port))), nil
+}
+
+func relayChannel(newCh gossh.NewChannel, target string, dialer Dialer) {
+	stream, err := dialer.Dial(target)
+	if err != nil {
+		log.Printf("[SSH] Failed to open tunnel to %s: %v", target, err)
+		newCh.Reject(gossh.ConnectionFailed, "tunnel dial failed")
+		return
+	}
+	defer stream.Close()
+
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go gossh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(stream, ch)
+		// Pass the client's EOF on but keep reading the response.
+		if cw, ok := stream.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			stream.Close()
+		}
+	}()
+	io.Copy(ch, stream)
+}
@@ -0,0 +1,141 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/crypto"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// echoDialer relays every channel to an echo, recording the targets.
+type echoDialer struct{ targets chan string }
+
+func (d echoDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	d.targets <- target
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		io.Copy(remote, remote)
+	}()
+	return local, nil
+}
+
+// newSigner returns a new Ed25519 SSH key.
+func newSigner(t *testing.T) gossh.Signer {
+	priv, _, err := crypto.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.ParsePrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// testServer returns a Server authorizing only key.
+func testServer(t *testing.T, key gossh.PublicKey) *Server {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "authorized_keys"), gossh.MarshalAuthorizedKey(key), 0600)
+	s, err := NewServer(filepath.Join(dir, "host_key"), filepath.Join(dir, "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// connect logs in to s over loopback TCP with key; net.Pipe can't carry
+// both sides sending their version at once.
+func connect(t *testing.T, s *Server, key gossh.Signer, dialer Dialer) (*gossh.Client, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			s.HandleConnection(conn, dialer)
+		}
+	}()
+	local, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &gossh.ClientConfig{User: "alice", Auth: []gossh.AuthMethod{gossh.PublicKeys(key)}, HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	c, chans, reqs, err := gossh.NewClientConn(local, "jump.test:22", cfg)
+	if err != nil {
+		local.Close()
+		return nil, err
+	}
+	client := gossh.NewClient(c, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	return client, nil
+}
+
+func TestServer(t *testing.T) {
+	key := newSigner(t)
+	s := testServer(t, key.PublicKey())
+	dialer := echoDialer{targets: make(chan string, 1)}
+
+	if _, err := connect(t, s, newSigner(t), dialer); err == nil {
+		t.Errorf("Expected an unknown key to be rejected")
+	}
+
+	client, err := connect(t, s, key, dialer)
+	if err != nil {
+		t.Fatalf("Expected the authorized key to log in, got %v", err)
+	}
+	ch, err := client.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("Failed to open a direct-tcpip channel: %v", err)
+	}
+	if got := <-dialer.targets; got != "example.com:443" {
+		t.Errorf("Expected the tunnel to dial example.com:443, got %s", got)
+	}
+	ch.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(ch, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the echo through the channel, got %q, %v", buf, err)
+	}
+	ch.Close()
+
+	_, err = client.NewSession()
+	var refused *gossh.OpenChannelError
+	if !errors.As(err, &refused) || refused.Reason != gossh.Prohibited {
+		t.Errorf("Expected a session channel to be prohibited, got %v", err)
+	}
+}
+
+func TestParseDirectTCPIP(t *testing.T) {
+	request := func(host string, port uint32) []byte {
+		return gossh.Marshal(struct {
+			Host       string
+			Port       uint32
+			OriginAddr string
+			OriginPort uint32
+		}{host, port, "127.0.0.1", 50000})
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"host", request("example.com", 443), "example.com:443", false},
+		{"IPv6", request("2001:db8::1", 22), "[2001:db8::1]:22", false},
+		{"port 0", request("example.com", 0), "", true},
+		{"port out of range", request("example.com", 70000), "", true},
+		{"truncated", request("example.com", 443)[:6], "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseDirectTCPIP(tt.data)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: Expected %q (error %v), got %q, %v", tt.name, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
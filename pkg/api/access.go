@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"phoenix/pkg/config"
+	"slices"
+	"strings"
+)
+
+// access is how an [api] section guards the API.
+type access struct {
+	token   string   // "" = none
+	origins []string // Allowed web origins; "*" = any
+	unix    bool     // Served on a Unix socket
+}
+
+// guard wraps h in a's checks. Browsers name the page a request comes from
+// in Origin, so pages not in origins are refused, even for the "simple"
+// requests they send without asking first. Token-less TCP APIs also insist
+// on an IP or localhost Host: a page whose name rebinds to 127.0.0.1 is
+// same-origin with the API and sends no Origin, but keeps its own Host.
+func (a access) guard(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !a.allowsOrigin(origin) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		if a.token == "" && !a.unix && !localHost(r.Host) {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		if a.token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				// Browsers can't set headers on WebSockets.
+				got, ok = r.URL.Query().Get("token"), true
+			}
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="phoenix"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a access) allowsOrigin(origin string) bool {
+	return slices.Contains(a.origins, "*") || slices.ContainsFunc(a.origins, func(o string) bool {
+		return strings.EqualFold(strings.TrimSuffix(o, "/"), origin)
+	})
+}
+
+// localHost reports whether a Host header names this machine by address or
+// as localhost.
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil
+}
+
+// listen opens addr, a TCP address or config.UnixPrefix and a path. Unix
+// sockets replace a stale socket file and are private to the user.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, config.UnixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		os.Remove(path)
+	} else if err == nil {
+		return nil, errors.New(path + " exists and is not a socket")
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
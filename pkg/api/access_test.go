@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tc := range []struct {
+		name        string
+		a           access
+		method      string
+		host        string
+		origin, key string
+		want        int
+	}{
+		{"loopback", access{}, "GET", "127.0.0.1:9090", "", "", http.StatusOK},
+		{"rebound name", access{}, "POST", "evil.example:9090", "", "", http.StatusForbidden},
+		{"foreign page", access{}, "POST", "127.0.0.1:9090", "https://evil.example", "", http.StatusForbidden},
+		{"allowed page", access{origins: []string{"https://dash.example"}}, "GET", "localhost:9090", "https://dash.example", "", http.StatusOK},
+		{"preflight", access{origins: []string{"https://dash.example"}}, "OPTIONS", "localhost:9090", "https://dash.example", "", http.StatusNoContent},
+		{"no token", access{token: "s3cret"}, "GET", "api.example", "", "", http.StatusUnauthorized},
+		{"wrong token", access{token: "s3cret"}, "GET", "api.example", "", "Bearer nope", http.StatusUnauthorized},
+		{"token", access{token: "s3cret"}, "GET", "api.example", "", "Bearer s3cret", http.StatusOK},
+		{"unix socket", access{unix: true}, "GET", "localhost", "", "", http.StatusOK},
+	} {
+		r := httptest.NewRequest(tc.method, "/inbounds", nil)
+		r.Host = tc.host
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.key != "" {
+			r.Header.Set("Authorization", tc.key)
+		}
+		w := httptest.NewRecorder()
+		tc.a.guard(ok).ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if tc.want == http.StatusNoContent && w.Header().Get("Access-Control-Allow-Origin") != tc.origin {
+			t.Errorf("%s: missing CORS headers: %v", tc.name, w.Header())
+		}
+	}
+}
@@ -0,0 +1,378 @@
+// Package api serves the client's local management API:
+//
+//	GET    /connections      live streams with their counters, busiest first
+//	GET    /events           WebSocket of stream "open"/"close" events and, every
+//	                         ?interval=1s, "traffic" with the live streams
+//	GET    /dns              the DNS cache of the client's own lookups ([dns])
+//	POST   /dns/flush        empty it, answering {"flushed": N}
+//	GET    /inbounds         running inbounds with their traffic counters
+//	POST   /inbounds         start an inbound (JSON body, see Inbound)
+//	DELETE /inbounds/{name}  stop the inbound with this tag or local address
+//	GET    /notices          recent notices pushed by the server, oldest first
+//	GET    /log-level        the log level, as {"level": "info"}
+//	PUT    /log-level        set it to "info" or "debug" (same JSON body)
+//
+// and the server's admin API, with /connections and /events (every stream
+// of the process, with users and client addresses), /log-level and
+//
+//	GET    /targets          top destination hosts by bytes ([target_stats]);
+//	                         ?n=20 (0 = all), ?user=NAME, ?by=connections
+//
+// and, with user_db, the user table (see User):
+//
+//	GET    /users                 every user, without tokens
+//	POST   /users                 create a user; a new token is made if neither
+//	                              token nor public_key is given
+//	GET    /users/{name}          one user, without token
+//	PUT    /users/{name}          replace a user's settings (empty token = keep)
+//	DELETE /users/{name}          delete a user
+//	POST   /users/{name}/token    rotate the token, answering {"token": "..."}
+//	POST   /users/{name}/disable  reject the user's streams until /enable
+//	POST   /users/{name}/enable
+//
+// With a token ([api] token) every request needs "Authorization: Bearer
+// <token>", or ?token= on /events, since browsers can't set headers on a
+// WebSocket; off loopback a token is required. listen = "unix:/path" serves
+// a private socket instead. Browsers may only call the API from
+// allow_origins, and a TCP API without a token refuses Host headers that
+// aren't IPs, against DNS rebinding. Errors are plain-text bodies with a
+// 4xx/5xx status.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"phoenix/pkg/config"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/transport"
+	"phoenix/pkg/userdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inbound is the JSON form of config.ClientInbound, with the same field
+// names as the TOML config. Listings add the inbound's counters.
+type Inbound struct {
+	Tag            string `json:"tag,omitempty"`
+	Protocol       string `json:"protocol"`
+	LocalAddr      string `json:"local_addr"`
+	EnableUDP      bool   `json:"enable_udp,omitempty"`
+	TargetAddr     string `json:"target_addr,omitempty"`
+	Auth           string `json:"auth,omitempty"`
+	AuthorizedKeys string `json:"authorized_keys,omitempty"`
+	HostKey        string `json:"host_key,omitempty"`
+
+	Stats *transport.InboundCounters `json:"stats,omitempty"`
+}
+
+// User is the JSON form of config.User, with the same field names as the
+// TOML config. Tokens are only shown when created.
+type User struct {
+	Name      string            `json:"name"`
+	Token     string            `json:"token,omitempty"`
+	PublicKey string            `json:"public_key,omitempty"`
+	Protocols []string          `json:"protocols,omitempty"`
+	Allow     []string          `json:"allow,omitempty"`
+	Deny      []string          `json:"deny,omitempty"`
+	Egress    []string          `json:"egress,omitempty"`
+	Quota     *config.UserQuota `json:"quota,omitempty"`
+	Record    string            `json:"record,omitempty"`
+
+	Disabled bool      `json:"disabled,omitempty"`
+	Created  time.Time `json:"created,omitzero"`
+	Updated  time.Time `json:"updated,omitzero"`
+}
+
+func (u User) config() config.User {
+	cu := config.User{Name: u.Name, Token: u.Token, PublicKey: u.PublicKey, Allow: u.Allow, Deny: u.Deny, Egress: u.Egress, Record: u.Record}
+	for _, p := range u.Protocols {
+		cu.Protocols = append(cu.Protocols, protocol.ProtocolType(p))
+	}
+	if u.Quota != nil {
+		cu.Quota = *u.Quota
+	}
+	return cu
+}
+
+func userJSON(e userdb.Entry) User {
+	u := User{Name: e.Name, PublicKey: e.PublicKey, Allow: e.Allow, Deny: e.Deny, Egress: e.Egress, Record: e.Record, Disabled: e.Disabled, Created: e.Created, Updated: e.Updated}
+	for _, p := range e.Protocols {
+		u.Protocols = append(u.Protocols, string(p))
+	}
+	if e.Quota != (config.UserQuota{}) {
+		q := e.Quota
+		u.Quota = &q
+	}
+	return u
+}
+
+// defaultTopTargets is how many hosts /targets lists without ?n.
+const defaultTopTargets = 20
+
+// LogLevel is the body of /log-level.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+func (in Inbound) config() config.ClientInbound {
+	return config.ClientInbound{
+		Tag:            in.Tag,
+		Protocol:       protocol.ProtocolType(in.Protocol),
+		LocalAddr:      in.LocalAddr,
+		EnableUDP:      in.EnableUDP,
+		TargetAddr:     in.TargetAddr,
+		Auth:           in.Auth,
+		AuthorizedKeys: in.AuthorizedKeys,
+		HostKey:        in.HostKey,
+	}
+}
+
+// Handler returns the API handler for client.
+func Handler(client *transport.Client) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /inbounds", func(w http.ResponseWriter, r *http.Request) {
+		stats := client.InboundStats()
+		list := []Inbound{}
+		for _, in := range client.Inbounds() {
+			s := stats[in.Name()]
+			list = append(list, Inbound{
+				Tag:        in.Tag,
+				Protocol:   string(in.Protocol),
+				LocalAddr:  in.LocalAddr,
+				EnableUDP:  in.EnableUDP,
+				TargetAddr: in.TargetAddr,
+				Stats:      &s,
+			})
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("POST /inbounds", func(w http.ResponseWriter, r *http.Request) {
+		var in Inbound
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, fmt.Sprintf("invalid inbound: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, _, err := net.SplitHostPort(in.LocalAddr); err != nil {
+			http.Error(w, fmt.Sprintf("invalid local_addr %q: %v", in.LocalAddr, err), http.StatusBadRequest)
+			return
+		}
+		if err := client.AddInbound(in.config()); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		in.Auth = "" // Don't echo secrets
+		writeJSON(w, http.StatusCreated, in)
+	})
+	mux.HandleFunc("GET /notices", func(w http.ResponseWriter, r *http.Request) {
+		list := client.Notices()
+		if list == nil {
+			list = []transport.Notice{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("DELETE /inbounds/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if err := client.RemoveInbound(r.PathValue("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, client.Connections())
+	})
+	handleEvents(mux, client.WatchConnections, client.Connections)
+	mux.HandleFunc("GET /dns", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, client.DNSCache())
+	})
+	mux.HandleFunc("POST /dns/flush", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]int{"flushed": client.FlushDNS()})
+	})
+	handleLogLevel(mux)
+	return mux
+}
+
+// ServerHandler returns the admin API handler for the servers of this
+// process, with /users for users (nil = no user_db).
+func ServerHandler(users *userdb.DB) http.Handler {
+	mux := http.NewServeMux()
+	if users != nil {
+		handleUsers(mux, users)
+	}
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, transport.Connections())
+	})
+	handleEvents(mux, transport.WatchConnections, transport.Connections)
+	mux.HandleFunc("GET /targets", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		n := defaultTopTargets
+		if v := q.Get("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid n %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+		var byConns bool
+		switch by := q.Get("by"); by {
+		case "", "bytes":
+		case "connections":
+			byConns = true
+		default:
+			http.Error(w, fmt.Sprintf("invalid by %q (want bytes or connections)", by), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, transport.TopTargets(q.Get("user"), n, byConns))
+	})
+	handleLogLevel(mux)
+	return mux
+}
+
+// handleUsers adds /users to mux.
+func handleUsers(mux *http.ServeMux, db *userdb.DB) {
+	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		entries, err := db.List()
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		list := []User{}
+		for _, e := range entries {
+			list = append(list, userJSON(e))
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		var u User
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, fmt.Sprintf("invalid user: %v", err), http.StatusBadRequest)
+			return
+		}
+		e, err := db.Create(u.config())
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		created := userJSON(e)
+		created.Token = e.Token
+		writeJSON(w, http.StatusCreated, created)
+	})
+	mux.HandleFunc("GET /users/{name}", func(w http.ResponseWriter, r *http.Request) {
+		e, err := db.Get(r.PathValue("name"))
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, userJSON(e))
+	})
+	mux.HandleFunc("PUT /users/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var u User
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, fmt.Sprintf("invalid user: %v", err), http.StatusBadRequest)
+			return
+		}
+		u.Name = r.PathValue("name")
+		if u.Token == "" {
+			old, err := db.Get(u.Name)
+			if err != nil {
+				userError(w, err)
+				return
+			}
+			u.Token = old.Token
+		}
+		e, err := db.Update(u.config())
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, userJSON(e))
+	})
+	mux.HandleFunc("DELETE /users/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if err := db.Delete(r.PathValue("name")); err != nil {
+			userError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /users/{name}/token", func(w http.ResponseWriter, r *http.Request) {
+		token, err := db.RotateToken(r.PathValue("name"))
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"token": token})
+	})
+	for action, disabled := range map[string]bool{"disable": true, "enable": false} {
+		mux.HandleFunc("POST /users/{name}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if err := db.SetDisabled(r.PathValue("name"), disabled); err != nil {
+				userError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// userError answers a failed user_db call.
+func userError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, userdb.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, userdb.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// handleLogLevel adds /log-level to mux.
+func handleLogLevel(mux *http.ServeMux) {
+	mux.HandleFunc("GET /log-level", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, LogLevel{Level: loglevel.Get().String()})
+	})
+	mux.HandleFunc("PUT /log-level", func(w http.ResponseWriter, r *http.Request) {
+		var l LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, fmt.Sprintf("invalid log level: %v", err), http.StatusBadRequest)
+			return
+		}
+		level, err := loglevel.Parse(l.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loglevel.Set(level)
+		writeJSON(w, http.StatusOK, LogLevel{Level: level.String()})
+	})
+}
+
+// ListenAndServe serves the API for client as cfg says.
+func ListenAndServe(cfg config.ClientAPI, client *transport.Client) error {
+	return serve(cfg.Listen, access{token: cfg.Token, origins: cfg.AllowOrigins}, Handler(client))
+}
+
+// ListenAndServeServer serves the server admin API as cfg says.
+func ListenAndServeServer(cfg config.ServerAPI, users *userdb.DB) error {
+	return serve(cfg.Listen, access{token: cfg.Token, origins: cfg.AllowOrigins}, ServerHandler(users))
+}
+
+func serve(addr string, a access, h http.Handler) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	a.unix = strings.HasPrefix(addr, config.UnixPrefix)
+	log.Printf("[API] Listening on %s", addr)
+	return http.Serve(ln, a.guard(h))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"phoenix/pkg/transport"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// defaultEventInterval is how often GET /events sends traffic rates when
+// the request sets no interval.
+const defaultEventInterval = time.Second
+
+// trafficEvent lists the live streams and their rates.
+type trafficEvent struct {
+	Type        string                 `json:"type"` // "traffic"
+	Connections []transport.Connection `json:"connections"`
+}
+
+// handleEvents adds GET /events to mux: a WebSocket of JSON messages, the
+// "open" and "close" of each stream as it happens and every interval
+// (?interval=, at least 1s) a "traffic" message with the live streams.
+func handleEvents(mux *http.ServeMux, watch func() (<-chan transport.ConnEvent, func()), list func() []transport.Connection) {
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		interval := defaultEventInterval
+		if v := r.URL.Query().Get("interval"); v != "" {
+			var err error
+			if interval, err = time.ParseDuration(v); err != nil || interval < time.Second {
+				http.Error(w, fmt.Sprintf("invalid interval %q (want a duration of at least 1s)", v), http.StatusBadRequest)
+				return
+			}
+		}
+		// guard has checked Origin.
+		websocket.Server{Handshake: func(*websocket.Config, *http.Request) error { return nil }, Handler: func(ws *websocket.Conn) {
+			events, stop := watch()
+			defer stop()
+			closed := make(chan struct{})
+			go func() {
+				// Nothing is read but the peer closing.
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				close(closed)
+			}()
+			tick := time.NewTicker(interval)
+			defer tick.Stop()
+			for {
+				var err error
+				select {
+				case ev := <-events:
+					err = websocket.JSON.Send(ws, ev)
+				case <-tick.C:
+					err = websocket.JSON.Send(ws, trafficEvent{Type: "traffic", Connections: list()})
+				case <-closed:
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}}.ServeHTTP(w, r)
+	})
+}
@@ -2,10 +2,16 @@ package config
 
 import (
 	"phoenix/pkg/protocol"
+	"strings"
+	"time"
 )
 
 // ClientInbound defines a single inbound protocol binding on the client side.
 type ClientInbound struct {
+	// Tag names the inbound in logs and stats and lets rules such as
+	// fallback.inbounds select it (e.g. "work"). Defaults to LocalAddr.
+	Tag string `toml:"tag,omitempty"`
+
 	// Protocol specifies the protocol type (e.g., "socks5", "shadowsocks", "ssh").
 	Protocol protocol.ProtocolType `toml:"protocol"`
 
@@ -22,6 +28,41 @@ type ClientInbound struct {
 	// For Shadowsocks, this might be "aes-256-gcm:password".
 	// For SSH, this might be a key file path or simple forwarding.
 	Auth string `toml:"auth,omitempty"`
+
+	// AuthorizedKeys is an OpenSSH authorized_keys file. Setting it turns an
+	// "ssh" inbound into an SSH server: standard clients log in with one of
+	// these keys and their port forwards (ssh -J/-L/-D) are tunneled.
+	AuthorizedKeys string `toml:"authorized_keys,omitempty"`
+
+	// HostKey is the PEM host key presented by an SSH server inbound.
+	// Generated on first start if the file does not exist.
+	HostKey string `toml:"host_key,omitempty"`
+
+	// Resumable keeps this inbound's connections open across tunnel drops:
+	// the recent bytes of each are buffered and its stream resumes on a new
+	// server connection, so the application sees a pause rather than a
+	// reset. Suits long-lived sessions such as SSH. Needs a server that
+	// supports it; otherwise drops reset connections as usual.
+	Resumable bool `toml:"resumable,omitempty"`
+
+	// Sniff reads the TLS SNI or HTTP Host of SOCKS5 connections to an IP,
+	// such as those from the TUN device, so domain rules apply to them:
+	// "route" matches rules against the name but still connects to the IP,
+	// "override" connects to the name, resolved by the server. Empty turns
+	// sniffing off. The server checks QUIC by its SNI regardless.
+	Sniff string `toml:"sniff,omitempty"`
+
+	// SkipAdBlock exempts this inbound's connections and DNS queries from
+	// [adblock], e.g. for a work browser profile.
+	SkipAdBlock bool `toml:"skip_adblock,omitempty"`
+}
+
+// Name returns the inbound's tag, or its local address when it has none.
+func (in ClientInbound) Name() string {
+	if in.Tag != "" {
+		return in.Tag
+	}
+	return in.LocalAddr
 }
 
 // ClientConfig defines the full structure of the client configuration.
@@ -37,6 +78,59 @@ type ClientConfig struct {
 	// keeps the original domain for correct Host header and TLS SNI.
 	DialAddr string `toml:"dial_addr,omitempty"`
 
+	// OutboundInterface binds the connection to the server (the entry hop's
+	// with chain) to this network interface, e.g. "rmnet_data0" to stay on
+	// cellular while Wi-Fi sits behind a captive portal. Linux, Android and
+	// macOS. Empty = the default route.
+	OutboundInterface string `toml:"outbound_interface,omitempty"`
+
+	// SourceAddr is the local IP that connection is made from; only server
+	// addresses of its family are tried. Empty = chosen by the OS.
+	SourceAddr string `toml:"source_addr,omitempty"`
+
+	// PreferIPv6 tries the server's IPv6 addresses first when RemoteAddr is
+	// resolved here (both families are still raced). The Android app also
+	// uses it to pick dial_addr, for carriers with IPv6-only or broken IPv4.
+	PreferIPv6 bool `toml:"prefer_ipv6,omitempty"`
+
+	// IPv4Only never connects to the server over IPv6.
+	IPv4Only bool `toml:"ipv4_only,omitempty"`
+
+	// KillSwitch keeps traffic blocked while the tunnel is down: inbounds
+	// answer with errors, and the Android VPN service holds the TUN
+	// interface open while it restarts the client, so apps never fall back
+	// to the device's direct route.
+	KillSwitch bool `toml:"kill_switch,omitempty"`
+
+	// AllowDirectFallback connects SOCKS5 targets directly from this host
+	// when the server can't be reached, for users who prefer availability
+	// over privacy. Targets the server itself refuses are not retried
+	// directly. Mutually exclusive with KillSwitch.
+	AllowDirectFallback bool `toml:"allow_direct_fallback,omitempty"`
+
+	// Fallback limits direct fallback to some destinations and delays it
+	// until the server has been unreachable for a while.
+	Fallback DirectFallback `toml:"fallback"`
+
+	// Routing picks SOCKS5 targets that skip the tunnel.
+	Routing ClientRouting `toml:"routing"`
+
+	// Timezone evaluates the schedules of routing.rules: an IANA name such
+	// as "Europe/Berlin", or empty for the host's local time.
+	Timezone string `toml:"timezone,omitempty"`
+
+	// DNS resolves and caches the client's own lookups: remote_addr and
+	// targets connected to directly.
+	DNS ClientDNS `toml:"dns"`
+
+	// AdBlock refuses ad and tracker hosts on SOCKS5 inbounds.
+	AdBlock ClientAdBlock `toml:"adblock"`
+
+	// GeoData are GeoIP and geosite lists kept up to date from URLs. Host
+	// lists (routing.direct, routing.rules, dns.rules domains, ...) use
+	// them as "geo:<name>"; the PAC file leaves them out.
+	GeoData []GeoData `toml:"geodata,omitempty"`
+
 	// AuthToken is sent to the server for authentication.
 	// Must match the server's auth_token.
 	AuthToken string `toml:"auth_token"`
@@ -45,6 +139,16 @@ type ClientConfig struct {
 	// Each inbound corresponds to a specific protocol and local port.
 	Inbounds []ClientInbound `toml:"inbounds"`
 
+	// AllowLAN shares the inbounds with other devices on the local network:
+	// they listen on 0.0.0.0 (keeping the local_addr port) and accept
+	// connections from loopback and LANSources only.
+	AllowLAN bool `toml:"allow_lan,omitempty"`
+
+	// LANSources are the IPs or CIDRs allowed to connect when AllowLAN is
+	// set (default: the private IPv4 ranges 10.0.0.0/8, 172.16.0.0/12,
+	// 192.168.0.0/16 and link-local 169.254.0.0/16).
+	LANSources []string `toml:"lan_sources,omitempty"`
+
 	// ClientID is a unique identifier or token for authentication with the server (optional, for future use).
 	ClientID string `toml:"client_id,omitempty"`
 
@@ -67,6 +171,625 @@ type ClientConfig struct {
 	// "safari"  → Mimic Safari
 	// "random"  → Random browser fingerprint per connection
 	Fingerprint string `toml:"fingerprint"`
+
+	// MetadataFrame sends the protocol, target and auth token in an encrypted
+	// frame at the start of each stream instead of plaintext HTTP headers,
+	// hiding destinations from CDN logs. Requires http.secret or auth_token
+	// (used as the encryption key) and a server that supports "meta-frame".
+	MetadataFrame bool `toml:"metadata_frame,omitempty"`
+
+	// HTTP customizes the tunnel request path, method and header names.
+	// Must match the server's [http] section.
+	HTTP HTTPProfile `toml:"http"`
+
+	// Cover configures decoy web requests mixed into the tunnel connection.
+	Cover CoverTraffic `toml:"cover"`
+
+	// Fragment splits the TLS ClientHello to defeat SNI extraction by DPI.
+	Fragment TLSFragment `toml:"fragment"`
+
+	// SessionTickets resumes the TLS session of an earlier server
+	// connection on reconnects (default true), so the handshake after a
+	// network change or hard reset skips the certificate exchange: the
+	// server's key was pinned when the ticket was issued, and mTLS servers
+	// find the client's key in it. Browsers resume too; fingerprints then
+	// send a pre_shared_key extension. The random fingerprint never
+	// resumes.
+	SessionTickets *bool `toml:"session_tickets,omitempty"`
+
+	// Notices keeps a stream open on which the server pushes notices
+	// (default true): quota warnings, maintenance and shutdown, requests to
+	// move to another server or to disconnect. They are logged as
+	// "[Notice] From server:" lines, which the Android app shows, and
+	// listed by the API's /notices. The server's tuning hints arrive on it
+	// too.
+	Notices *bool `toml:"notices,omitempty"`
+
+	// Chain lists Phoenix servers to tunnel through before reaching
+	// RemoteAddr (the exit), starting with the entry node. Each hop's
+	// connection runs inside a stream through the previous hop, so the entry
+	// sees the user's IP but not the destination, and the exit sees the
+	// destination but not the user's IP. Every hop except the exit must have
+	// enable_socks5 set.
+	Chain []ChainHop `toml:"chain,omitempty"`
+
+	// UDP bounds UDP associations of SOCKS5 inbounds with enable_udp.
+	// The nat and max_peers settings only apply to servers.
+	UDP UDPConfig `toml:"udp"`
+
+	// SOCKS5 bounds the SOCKS5 inbounds, so a misbehaving local app or a
+	// scanner on the LAN (with allow_lan) can't exhaust the client.
+	SOCKS5 SOCKS5Limits `toml:"socks5"`
+
+	// Keepalive schedules HTTP/2 PINGs on the server connection.
+	Keepalive Keepalive `toml:"keepalive"`
+
+	// Connections stripes streams over this many parallel HTTP/2
+	// connections to the server (default 1: one until it is full), each
+	// new stream taking the least busy one. It works around ISPs that
+	// throttle each flow and limits TCP head-of-line blocking to the
+	// streams of one connection.
+	Connections int `toml:"connections,omitempty"`
+
+	// Congestion adapts the number of server connections to the loss on
+	// the path, as reported on a control stream.
+	Congestion CongestionFeedback `toml:"congestion"`
+
+	// Health scores the server by its recent answers and stops opening
+	// streams to it while the score is too low.
+	Health HealthCheck `toml:"health"`
+
+	// Retry retries Dials that fail to reach the server, so a momentary
+	// blip doesn't reach the application as a failed connection.
+	Retry DialRetry `toml:"retry"`
+
+	// LogLevel is "info" (default) or "debug", which adds per-stream and
+	// keepalive detail. It can be changed while running: SIGUSR1 toggles
+	// it, as do the "log-level" control command and the API's /log-level.
+	LogLevel string `toml:"log_level,omitempty"`
+
+	// Log writes the log to a rotated file instead of stderr.
+	Log LogOutput `toml:"log"`
+
+	// API serves a local HTTP API for managing the running client.
+	API ClientAPI `toml:"api"`
+
+	// PAC serves a proxy auto-config file that mirrors Routing, for
+	// browsers and systems that only take a PAC URL.
+	PAC PACServer `toml:"pac"`
+
+	// TUN configures the network stack of VPN mode (-tun-socket).
+	TUN TUNConfig `toml:"tun"`
+}
+
+// ResumesSessions reports whether session_tickets is on.
+func (c *ClientConfig) ResumesSessions() bool {
+	return c.SessionTickets == nil || *c.SessionTickets
+}
+
+// ReceivesNotices reports whether notices is on.
+func (c *ClientConfig) ReceivesNotices() bool {
+	return c.Notices == nil || *c.Notices
+}
+
+// TUNConfig sizes packets in VPN mode and picks the network stack that
+// terminates the device's TCP connections and UDP flows and hands them to
+// the first SOCKS5 inbound.
+type TUNConfig struct {
+	// Stack is "gvisor" (default) or "system". gvisor runs a complete
+	// userspace TCP/IP stack: portable, but every segment costs CPU in
+	// Go. system lets the kernel terminate TCP by rewriting connections
+	// to a local listener on Address, which is much faster on routers;
+	// UDP is still relayed in userspace.
+	Stack string `toml:"stack,omitempty"`
+
+	// Address is the TUN device's IPv4 address and prefix, used by the
+	// system stack (default "10.233.233.1/30", as set by the Android
+	// VpnService). The address after it in the prefix must be unused.
+	Address string `toml:"address,omitempty"`
+
+	// Address6 is the device's IPv6 address and prefix. The system stack
+	// only handles IPv6 TCP when it is set.
+	Address6 string `toml:"address6,omitempty"`
+
+	// MTU of the network stack (default 1500). It must match the TUN
+	// device's MTU; the Android VpnService sets the device from the same
+	// value. Lower it (e.g. 1492 for PPPoE, 1400 for some LTE carriers)
+	// when full-size packets are dropped on the way.
+	MTU int `toml:"mtu,omitempty"`
+
+	// MSS clamps the maximum segment size announced in TCP SYNs crossing
+	// the device, so apps never send segments that don't fit in MTU.
+	// 0 (default) derives it from MTU: MTU-40 for IPv4, MTU-60 for IPv6.
+	// A positive value clamps IPv4 SYNs to it (IPv6 to 20 bytes less);
+	// -1 turns clamping off.
+	MSS int `toml:"mss,omitempty"`
+}
+
+// SOCKS5Limits bounds connections to the client's SOCKS5 inbounds.
+type SOCKS5Limits struct {
+	// HandshakeTimeout closes connections that haven't sent their SOCKS5
+	// request this long after connecting (default 10s).
+	HandshakeTimeout time.Duration `toml:"handshake_timeout,omitempty"`
+
+	// MaxHandshakes caps connections in their handshake at once, across all
+	// SOCKS5 inbounds (default 128). Further connections wait in the
+	// listen backlog until a handshake ends.
+	MaxHandshakes int `toml:"max_handshakes,omitempty"`
+
+	// MaxConnsPerSource caps open connections from one source IP (default
+	// 256). Loopback is exempt: in VPN mode all traffic comes from
+	// tun2socks on 127.0.0.1.
+	MaxConnsPerSource int `toml:"max_conns_per_source,omitempty"`
+}
+
+// ClientRouting decides per target whether a SOCKS5 connection goes through
+// the tunnel or directly from this host.
+type ClientRouting struct {
+	// Direct are destinations connected to directly: domains (matching
+	// subdomains too), IPs or CIDRs. Everything else is tunneled.
+	// "process:qbittorrent" entries route every connection of a local
+	// application directly, by executable name or full path, on Linux,
+	// Windows and macOS.
+	Direct []string `toml:"direct,omitempty"`
+
+	// BypassPrivate also connects to PrivateHosts directly, so printers,
+	// casting devices and other LAN services keep working while the proxy
+	// is system-wide (default true). Set to false to tunnel them too.
+	BypassPrivate *bool `toml:"bypass_private,omitempty"`
+
+	// Interactive and Bulk give tunneled destinations (same syntax as
+	// Direct) a priority class, e.g. an SSH host and a download mirror.
+	// Bulk streams send in small pieces and wait while an interactive
+	// stream on the same connection is sending, so a large transfer
+	// doesn't add latency to browsing. The server schedules downloads the
+	// same way if it supports the "priority" feature. Other destinations
+	// are not scheduled.
+	Interactive []string `toml:"interactive,omitempty"`
+	Bulk        []string `toml:"bulk,omitempty"`
+
+	// Rules override the lists above for their destinations, e.g. to
+	// tunnel a site only during working hours or send downloads to bulk
+	// at peak times. The first rule that matches and is in schedule wins.
+	Rules []RoutingRule `toml:"rules,omitempty"`
+
+	// Outbounds are upstreams other than the tunnel that rules with
+	// action = "outbound" send connections to, e.g. to migrate some sites
+	// from an existing proxy gradually, or to send others through Tor (a
+	// "privacy" outbound). They are reached from this host.
+	Outbounds []RoutingOutbound `toml:"outbounds,omitempty"`
+}
+
+// RoutingOutbound is an upstream for routing rules.
+type RoutingOutbound struct {
+	// Tag names the outbound in routing rules.
+	Tag string `toml:"tag"`
+
+	// URL is the upstream:
+	//   "http://[user:pass@]host:port"        an HTTP CONNECT proxy
+	//   "socks5://[user:pass@]host:port"      a SOCKS5 proxy
+	//   "wireguard:///etc/wireguard/wg0.conf" a WireGuard peer of a wg-quick
+	//                                         config, in userspace (no TUN)
+	//   "ssh://user@host?key=/path/id_ed25519&host_key=SHA256:..."
+	//       an SSH server's direct-tcpip channels (ssh -W); a password in
+	//       the URL works too, known_hosts=/path replaces host_key
+	//   "tor://127.0.0.1:9050"                a local Tor daemon's SOCKS port;
+	//                                         each site gets its own circuits
+	//                                         unless ?isolate=false
+	// UDP of routed targets is tunneled, except with Tor, which drops it.
+	URL string `toml:"url"`
+}
+
+// RoutingRule routes some destinations, optionally only at some times.
+type RoutingRule struct {
+	// Hosts are domains, IPs or CIDRs as in Direct; empty = any host.
+	Hosts []string `toml:"hosts,omitempty"`
+
+	// Ports are destination ports or ranges ("443", "27015-27030"); empty
+	// = any port.
+	Ports []string `toml:"ports,omitempty"`
+
+	// Action is "direct", "tunnel", "interactive" or "bulk" (both
+	// tunneled, with that priority class), "outbound" or "block".
+	Action string `toml:"action"`
+
+	// Outbound is the tag of the routing.outbounds entry connections go
+	// to with action = "outbound".
+	Outbound string `toml:"outbound,omitempty"`
+
+	// Block is how blocked connections fail: "reset" (default) refuses
+	// them at once, "drop" leaves them unanswered, "http" answers port 80
+	// with a 403 page and resets the rest.
+	Block string `toml:"block,omitempty"`
+
+	// Schedule limits the rule to these windows, in timezone. Empty =
+	// always.
+	Schedule []Schedule `toml:"schedule,omitempty"`
+}
+
+// ClientAdBlock blocks hosts from lists such as StevenBlack's hosts or
+// AdGuard's DNS filter, both at DNS, where queries relayed through SOCKS5
+// UDP (the TUN device's included) get NXDOMAIN, and at routing, where
+// connections fail per block. Blocking applies before routing.rules.
+type ClientAdBlock struct {
+	// Hosts are the blocked hosts: "geo:<name>" lists of [[geodata]]
+	// (format = "hosts" or "adguard"), domains, IPs or CIDRs. Empty =
+	// blocking off.
+	Hosts []string `toml:"hosts,omitempty"`
+
+	// Allow exempts hosts the lists would block.
+	Allow []string `toml:"allow,omitempty"`
+
+	// Block is how blocked connections fail: "reset" (default), "drop" or
+	// "http", as in routing rules.
+	Block string `toml:"block,omitempty"`
+}
+
+// ClientDNS configures the lookups the client makes itself. Tunneled targets
+// are resolved by the server.
+type ClientDNS struct {
+	// Servers are resolvers queried directly, rotating and failing over:
+	//   "1.1.1.1:53"                        plain DNS (also "udp://", "tcp://")
+	//   "tls://1.1.1.1" or "tls://dns.google"  DNS-over-TLS, port 853
+	//   "quic://94.140.14.14"               DNS-over-QUIC, port 853
+	//   "https://1.1.1.1/dns-query"         DNS-over-HTTPS
+	// They also make the client work where the system resolver can't, such
+	// as on Android without dial_addr; give encrypted servers by IP there.
+	// Empty = the system resolver, whose answers carry no TTL and are kept
+	// for min_ttl.
+	Servers []string `toml:"servers,omitempty"`
+
+	// Rules send some domains to other servers; the first match wins.
+	Rules []DNSRule `toml:"rules,omitempty"`
+
+	// Hosts are static addresses that take precedence over every server,
+	// e.g. for lab machines or to pin a captive portal's host:
+	//   [dns.hosts]
+	//   "nas.lab" = ["192.168.1.10"]
+	// Tunneled targets with a static address are sent to the server by
+	// that address.
+	Hosts map[string][]string `toml:"hosts,omitempty"`
+
+	// HostsFile loads more static addresses from a hosts file; "system" is
+	// the system's (/etc/hosts). Entries in hosts win over it.
+	HostsFile string `toml:"hosts_file,omitempty"`
+
+	// CacheSize is the number of names cached (default 1024; -1 disables
+	// the cache).
+	CacheSize int `toml:"cache_size,omitempty"`
+
+	// MinTTL and MaxTTL clamp how long an answer is kept (defaults 30s and
+	// 1h); a low TTL is raised to min_ttl to save queries.
+	MinTTL time.Duration `toml:"min_ttl,omitempty"`
+	MaxTTL time.Duration `toml:"max_ttl,omitempty"`
+
+	// Timeout bounds each query to a server (default 2s).
+	Timeout time.Duration `toml:"timeout,omitempty"`
+}
+
+// DNSRule sends the lookups of some domains to their own servers (split
+// DNS), e.g. corporate domains to the company's resolver through the
+// tunnel, or domestic sites routed directly to the ISP's.
+type DNSRule struct {
+	// Domains are matched like routing.direct entries: "example.com" and
+	// "*.example.com" both match the domain and its subdomains.
+	Domains []string `toml:"domains,omitempty"`
+
+	// Routing matches by routing decision instead: "direct" for the names
+	// routing.direct (and bypass_private) connects to directly, "tunnel"
+	// for all others. With Domains, a name must match both.
+	Routing string `toml:"routing,omitempty"`
+
+	// Servers answer them, written like dns.servers; ["system"] is the
+	// system resolver.
+	Servers []string `toml:"servers"`
+
+	// Tunnel sends the queries through the tunnel, hiding them from the
+	// local network while the connections themselves go direct. Needs
+	// tcp://, tls:// or https:// servers.
+	Tunnel bool `toml:"tunnel,omitempty"`
+}
+
+// PrivateHosts are the private and special-use destinations skipped by
+// routing.bypass_private: RFC 1918 and unique local ranges, link-local,
+// multicast, and mDNS (.local) names. Loopback is still tunneled, so
+// 127.0.0.1 targets keep meaning the server itself.
+var PrivateHosts = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+	"169.254.0.0/16", "fe80::/10",
+	"224.0.0.0/4", "255.255.255.255", "ff00::/8",
+	"local",
+}
+
+// ProcessPrefix marks Direct entries that match local processes.
+const ProcessPrefix = "process:"
+
+// DirectHosts returns the destinations of Direct plus PrivateHosts unless
+// bypass_private is off.
+func (r ClientRouting) DirectHosts() []string {
+	var hosts []string
+	for _, h := range r.Direct {
+		if !strings.HasPrefix(h, ProcessPrefix) {
+			hosts = append(hosts, h)
+		}
+	}
+	if r.BypassPrivate != nil && !*r.BypassPrivate {
+		return hosts
+	}
+	return append(hosts, PrivateHosts...)
+}
+
+// DirectProcesses returns the process names and paths of Direct.
+func (r ClientRouting) DirectProcesses() []string {
+	var procs []string
+	for _, h := range r.Direct {
+		if p, ok := strings.CutPrefix(h, ProcessPrefix); ok {
+			procs = append(procs, p)
+		}
+	}
+	return procs
+}
+
+// GeoData is a list of domains, IPs and CIDRs downloaded from a URL and
+// refreshed on a schedule, such as a country's IP ranges, its domestic
+// sites or a rule set of thousands of domains.
+type GeoData struct {
+	// Name is what host lists refer to, as "geo:<name>".
+	Name string `toml:"name"`
+
+	// URL serves the list.
+	URL string `toml:"url"`
+
+	// Format is "list" (default: one entry per line), "clash" (a Clash
+	// rule-provider of the domain, ipcidr or classical behavior, YAML or
+	// text), "v2ray" (a v2fly domain-list-community file), "hosts" (a
+	// hosts file such as StevenBlack's) or "adguard" (an AdGuard DNS
+	// filter). Rules without a host equivalent, such as PROCESS-NAME or
+	// AdGuard exceptions, are skipped.
+	Format string `toml:"format,omitempty"`
+
+	// SHA256URL serves the list's SHA-256 digest in hex, as written by
+	// sha256sum. A list that doesn't match is discarded.
+	SHA256URL string `toml:"sha256_url,omitempty"`
+
+	// SignatureURL serves an Ed25519 signature of the list (raw or
+	// Base64) made with the key of PublicKey (Base64).
+	SignatureURL string `toml:"signature_url,omitempty"`
+	PublicKey    string `toml:"public_key,omitempty"`
+
+	// Path caches the last good copy, used at startup until the first
+	// update (default: none, the list is empty until downloaded).
+	Path string `toml:"path,omitempty"`
+
+	// Interval is how often the list is refreshed (default 24h).
+	Interval time.Duration `toml:"interval,omitempty"`
+
+	// Tunnel downloads the list through the tunnel, for URLs blocked on
+	// the local network.
+	Tunnel bool `toml:"tunnel,omitempty"`
+}
+
+// PACServer configures the PAC file server. The file sends the destinations
+// of Routing.DirectHosts DIRECT and everything else to a SOCKS5 inbound.
+type PACServer struct {
+	// Listen is the PAC server address, e.g. "127.0.0.1:8090" (empty =
+	// disabled). The file is served on every path, e.g. /proxy.pac.
+	Listen string `toml:"listen,omitempty"`
+
+	// Inbound is the tag of the SOCKS5 inbound the file points at (default:
+	// the first SOCKS5 inbound).
+	Inbound string `toml:"inbound,omitempty"`
+}
+
+// UnixPrefix marks api.listen addresses that are Unix socket paths.
+const UnixPrefix = "unix:"
+
+// ClientAPI configures the client's local management API, which lists,
+// adds and removes inbounds without restarting the tunnel.
+type ClientAPI struct {
+	// Listen is the API address, e.g. "127.0.0.1:9090", or "unix:" and the
+	// path of a Unix socket only the user running phoenix can open (empty =
+	// disabled).
+	Listen string `toml:"listen,omitempty"`
+
+	// Token, if set, is required of every request as "Authorization:
+	// Bearer <token>". Needed unless listen is loopback or a Unix socket.
+	Token string `toml:"token,omitempty"`
+
+	// AllowOrigins are the web origins ("https://dash.example") whose pages
+	// may call the API; browsers' requests from any other page are refused.
+	// "*" allows every origin and needs a token.
+	AllowOrigins []string `toml:"allow_origins,omitempty"`
+}
+
+// DirectFallback sends matching SOCKS5 targets directly from this host once
+// the server has been unreachable for After, and back through the tunnel as
+// soon as a probe reaches the server again. With allow_direct_fallback every
+// target matches; Inbounds applies either way.
+type DirectFallback struct {
+	// Hosts are the destinations allowed to fall back: domains (matching
+	// subdomains too), IPs or CIDRs.
+	Hosts []string `toml:"hosts,omitempty"`
+
+	// Inbounds restricts fallback to connections from inbounds with these
+	// tags (default: all inbounds).
+	Inbounds []string `toml:"inbounds,omitempty"`
+
+	// After is how long the server must be unreachable before matching
+	// targets go direct (default 0: at the first failed dial).
+	After time.Duration `toml:"after,omitempty"`
+
+	// ProbeInterval is the pause between reachability probes while falling
+	// back (default 10s).
+	ProbeInterval time.Duration `toml:"probe_interval,omitempty"`
+}
+
+// Keepalive configures HTTP/2 PINGs, which keep NAT and firewall mappings of
+// an idle connection open and detect dead connections before the next Dial
+// stalls on them. Every ping wakes a phone's radio, so the interval stretches
+// to LowPowerInterval while the app reports it is in the background or the
+// device is dozing (the "low-power" control command).
+type Keepalive struct {
+	// Interval between pings (default 30s).
+	Interval time.Duration `toml:"interval,omitempty"`
+
+	// LowPowerInterval between pings in low-power mode (default 5m).
+	LowPowerInterval time.Duration `toml:"low_power_interval,omitempty"`
+
+	// Timeout closes a connection whose ping isn't answered in time
+	// (default 5s).
+	Timeout time.Duration `toml:"timeout,omitempty"`
+}
+
+// CongestionFeedback configures the congestion control stream. The server
+// reports the RTT and retransmission rate of its side of the connection
+// (downloads) and the client measures its own (uploads), both from the
+// kernel's TCP statistics, which Linux and Android provide. While either
+// direction loses packets, new streams are spread over up to MaxConns
+// parallel connections, since one TCP connection's throughput collapses
+// under loss; once the path is clean again they go back to one, or to
+// connections.
+type CongestionFeedback struct {
+	// Enabled opens the control stream (default false). The server needs the
+	// "feedback" feature.
+	Enabled bool `toml:"enabled"`
+
+	// Interval between reports (default 5s). Polling pauses in low-power
+	// mode.
+	Interval time.Duration `toml:"interval,omitempty"`
+
+	// MaxConns caps the parallel server connections (default 4).
+	MaxConns int `toml:"max_conns,omitempty"`
+}
+
+// HealthCheck configures the circuit breaker on the server connection.
+// Each Dial is scored: 1 for a prompt answer, 0.5 for one slower than
+// SlowDial, 0 when the server can't be reached or a CDN in front of it
+// answers 502, 503 or 504. Refusals such as a wrong token or a blocked
+// target don't count, since the server answered. Once the average over
+// Window falls below Threshold, Dials fail at once with "server
+// unreachable", so the direct fallback applies without waiting for
+// timeouts, and a probe lets them through again when the server answers.
+type HealthCheck struct {
+	// Enabled turns the breaker on (default true).
+	Enabled *bool `toml:"enabled,omitempty"`
+
+	// Window is how long Dial outcomes count (default 1m). The breaker
+	// needs 5 of them to open.
+	Window time.Duration `toml:"window,omitempty"`
+
+	// Threshold is the score, between 0 and 1, below which the breaker
+	// opens (default 0.5).
+	Threshold float64 `toml:"threshold,omitempty"`
+
+	// SlowDial is the answer time past which a Dial scores 0.5
+	// (default 3s).
+	SlowDial time.Duration `toml:"slow_dial,omitempty"`
+
+	// ProbeInterval is the pause between probes while the breaker is open
+	// (default 5s).
+	ProbeInterval time.Duration `toml:"probe_interval,omitempty"`
+}
+
+// On reports whether the breaker is enabled.
+func (h HealthCheck) On() bool {
+	return h.Enabled == nil || *h.Enabled
+}
+
+// DialRetry configures retries of Dials that fail on the way to the
+// server: a connection reset, a GOAWAY, a timeout or a CDN answering for
+// a server it can't reach. Refusals by the server, failed target dials
+// and an open circuit breaker are not retried. The pause before each
+// retry doubles from Backoff up to MaxBackoff.
+type DialRetry struct {
+	// MaxAttempts is how often a Dial is tried in total (default 1, no
+	// retries).
+	MaxAttempts int `toml:"max_attempts,omitempty"`
+
+	// Backoff is the pause before the first retry (default 200ms).
+	Backoff time.Duration `toml:"backoff,omitempty"`
+
+	// MaxBackoff caps the pause between retries (default 2s).
+	MaxBackoff time.Duration `toml:"max_backoff,omitempty"`
+}
+
+// ChainHop is one intermediate server in ClientConfig.Chain. The fields mean
+// the same as their ClientConfig counterparts.
+type ChainHop struct {
+	RemoteAddr      string      `toml:"remote_addr"`
+	DialAddr        string      `toml:"dial_addr,omitempty"`
+	AuthToken       string      `toml:"auth_token"`
+	PrivateKeyPath  string      `toml:"private_key"`
+	ServerPublicKey string      `toml:"server_public_key"`
+	TLSMode         string      `toml:"tls_mode"`
+	Fingerprint     string      `toml:"fingerprint"`
+	MetadataFrame   bool        `toml:"metadata_frame,omitempty"`
+	HTTP            HTTPProfile `toml:"http"`
+}
+
+// ClientConfig returns the settings for connecting to this hop.
+func (h ChainHop) ClientConfig() *ClientConfig {
+	return &ClientConfig{
+		RemoteAddr:      h.RemoteAddr,
+		DialAddr:        h.DialAddr,
+		AuthToken:       h.AuthToken,
+		PrivateKeyPath:  h.PrivateKeyPath,
+		ServerPublicKey: h.ServerPublicKey,
+		TLSMode:         h.TLSMode,
+		Fingerprint:     h.Fingerprint,
+		MetadataFrame:   h.MetadataFrame,
+		HTTP:            h.HTTP,
+	}
+}
+
+// TLSFragment configures ClientHello fragmentation. With only Enabled set,
+// the hello is split into two TLS records in the middle of the SNI hostname
+// and each record is sent in its own TCP segment.
+type TLSFragment struct {
+	// Enabled turns fragmentation on (TLS modes only; ignored for h2c).
+	Enabled bool `toml:"enabled"`
+
+	// RecordSize splits the hello into TLS records of at most this many
+	// bytes instead of a single cut inside the SNI.
+	RecordSize int `toml:"record_size,omitempty"`
+
+	// SegmentSize further splits each record into TCP writes of at most
+	// this many bytes (default: one write per record).
+	SegmentSize int `toml:"segment_size,omitempty"`
+
+	// Delay is the pause between TCP writes (e.g. "10ms"), which stops
+	// middleboxes from reassembling segments that arrive together.
+	Delay time.Duration `toml:"delay,omitempty"`
+
+	// Padding grows the ClientHello to at least this many bytes with the
+	// TLS padding extension. Requires a uTLS fingerprint.
+	Padding int `toml:"padding,omitempty"`
+}
+
+// CoverTraffic configures the decoy request generator. While the tunnel is
+// in use, the client periodically "visits a page" on the fronting domain:
+// one HTML request followed by a burst of asset requests, sent over the same
+// HTTP/2 connection as tunnel streams so flow statistics resemble browsing.
+// Decoys need the server's [fallback] site: paths it doesn't answer with
+// 2xx are dropped, and the generator stops when no page is left.
+type CoverTraffic struct {
+	// Enabled turns the generator on.
+	Enabled bool `toml:"enabled"`
+
+	// Pages are HTML paths to visit (default: a few common site paths).
+	Pages []string `toml:"pages,omitempty"`
+
+	// Assets are image/CSS/JS paths fetched after each page (default: common asset paths).
+	Assets []string `toml:"assets,omitempty"`
+
+	// MinInterval and MaxInterval bound the random delay between page visits
+	// (default 20s–90s).
+	MinInterval time.Duration `toml:"min_interval,omitempty"`
+	MaxInterval time.Duration `toml:"max_interval,omitempty"`
+
+	// IdleTimeout pauses the generator when no tunnel stream was opened for
+	// this long, so an idle device doesn't produce traffic (default 2m).
+	IdleTimeout time.Duration `toml:"idle_timeout,omitempty"`
 }
 
 // DefaultClientConfig returns a basic client configuration with a single SOCKS5 inbound.
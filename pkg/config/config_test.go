@@ -3,6 +3,7 @@ package config
 import (
 	"phoenix/pkg/protocol"
 	"testing"
+	"time"
 
 	"github.com/pelletier/go-toml"
 )
@@ -63,3 +64,478 @@ auth = "/key"
 		t.Errorf("Expected inbound 1 to be ssh, got %s", config.Inbounds[1].Protocol)
 	}
 }
+
+func TestClientConfigValidate(t *testing.T) {
+	config := DefaultClientConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected default config to be valid, got %v", err)
+	}
+
+	config.Inbounds = append(config.Inbounds, ClientInbound{
+		Protocol:  protocol.ProtocolShadowsocks,
+		LocalAddr: "127.0.0.1:8388",
+	})
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected shadowsocks inbound without auth to be rejected")
+	}
+
+	config = DefaultClientConfig()
+	config.TLSMode = "bogus"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected unknown tls_mode to be rejected")
+	}
+
+	config = DefaultClientConfig()
+	config.AllowLAN = true
+	config.LANSources = []string{"192.168.1.0/24", "10.0.0.7"}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected lan_sources with a CIDR and an IP to be valid, got %v", err)
+	}
+	config.LANSources = []string{"home.lan"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a domain in lan_sources to be rejected")
+	}
+
+	config = DefaultClientConfig()
+	config.TUN = TUNConfig{MTU: 1400, MSS: -1}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected tun.mtu 1400 without clamping to be valid, got %v", err)
+	}
+	config.TUN.MTU = 1000
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a tun.mtu below the IPv6 minimum to be rejected")
+	}
+
+	config = DefaultClientConfig()
+	config.TUN = TUNConfig{Stack: "system", Address: "192.168.50.1/24", Address6: "fd00::1/64"}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected a system stack with both addresses to be valid, got %v", err)
+	}
+	config.TUN.Address = "192.168.50.1/32"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a tun.address without room for the NAT address to be rejected")
+	}
+	config.TUN = TUNConfig{Stack: "lwip"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an unknown tun.stack to be rejected")
+	}
+}
+
+func TestHTTPProfile(t *testing.T) {
+	tomlData := `
+remote_addr = "example.com:443"
+
+[http]
+secret = "shared"
+method = "PUT"
+user_agent = "curl/8.0"
+
+[http.headers]
+Accept-Language = "en-US"
+`
+	config := DefaultClientConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal client config: %v", err)
+	}
+	if config.HTTP.Secret != "shared" || config.HTTP.Method != "PUT" {
+		t.Errorf("Expected secret/method shared/PUT, got %s/%s", config.HTTP.Secret, config.HTTP.Method)
+	}
+	if config.HTTP.Headers["Accept-Language"] != "en-US" {
+		t.Errorf("Expected decoy header Accept-Language, got %v", config.HTTP.Headers)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	config.HTTP.Method = "GET"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected GET method to be rejected")
+	}
+}
+
+func TestServerUsers(t *testing.T) {
+	tomlData := `
+listen_addr = ":443"
+
+[[users]]
+name = "guest"
+token = "abc"
+protocols = ["socks5"]
+deny = ["10.0.0.0/8"]
+`
+	config := DefaultServerConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal server config: %v", err)
+	}
+	if len(config.Users) != 1 || config.Users[0].Protocols[0] != protocol.ProtocolSOCKS5 {
+		t.Fatalf("Expected one guest user limited to socks5, got %+v", config.Users)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected config to be valid, got %v", err)
+	}
+	if len(config.BlockedPorts) != len(DefaultBlockedPorts) {
+		t.Errorf("Expected default blocked_ports to be kept, got %v", config.BlockedPorts)
+	}
+
+	config.Users = append(config.Users, User{Name: "guest", Token: "def"})
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected duplicate user name to be rejected")
+	}
+}
+
+func TestServerVHosts(t *testing.T) {
+	tomlData := `
+listen_addr = ":443"
+
+[security]
+private_key = "default.key"
+
+[[vhosts]]
+server_names = ["a.example.com", "*.b.example.com"]
+private_key = "a.key"
+auth_token = "secret"
+
+[[vhosts.users]]
+name = "alice"
+token = "abc"
+`
+	config := DefaultServerConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal server config: %v", err)
+	}
+	if len(config.VHosts) != 1 || len(config.VHosts[0].Users) != 1 {
+		t.Fatalf("Expected one vhost with one user, got %+v", config.VHosts)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected config to be valid, got %v", err)
+	}
+
+	config.VHosts = append(config.VHosts, config.VHosts[0])
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a server name used twice to be rejected")
+	}
+	config.VHosts = config.VHosts[:1]
+	config.VHosts[0].PrivateKeyPath = ""
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an h2c vhost next to a TLS endpoint to be rejected")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	config := DefaultServerConfig()
+	config.TrustedProxies = []string{"173.245.48.0/20", "2400:cb00::/32", "10.0.0.5"}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected trusted proxies to be valid, got %v", err)
+	}
+	config.TrustedProxies = []string{"cdn.example.com"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a hostname in trusted_proxies to be rejected")
+	}
+}
+
+func TestUDPLimits(t *testing.T) {
+	tomlData := `
+remote_addr = "example.com:443"
+
+[[inbounds]]
+protocol = "socks5"
+local_addr = "127.0.0.1:1080"
+enable_udp = true
+
+[udp]
+idle_timeout = "10m"
+max_packet_size = 1500
+`
+	config := DefaultClientConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal client config: %v", err)
+	}
+	if config.UDP.IdleTimeout != 10*time.Minute || config.UDP.MaxPacketSize != 1500 {
+		t.Errorf("Expected idle_timeout 10m and max_packet_size 1500, got %+v", config.UDP)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected config to be valid, got %v", err)
+	}
+
+	config.UDP.MaxPacketSize = 70000
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected oversized max_packet_size to be rejected")
+	}
+}
+
+func TestRoutingDirectHosts(t *testing.T) {
+	var config ClientConfig
+	if err := toml.Unmarshal([]byte("[routing]\ndirect = [\"example.com\"]\n"), &config); err != nil {
+		t.Fatalf("Failed to unmarshal routing: %v", err)
+	}
+	if got := len(config.Routing.DirectHosts()); got != 1+len(PrivateHosts) {
+		t.Errorf("Expected private hosts to be bypassed by default, got %d hosts", got)
+	}
+
+	config = ClientConfig{}
+	if err := toml.Unmarshal([]byte("[routing]\ndirect = [\"example.com\"]\nbypass_private = false\n"), &config); err != nil {
+		t.Fatalf("Failed to unmarshal routing: %v", err)
+	}
+	if got := config.Routing.DirectHosts(); len(got) != 1 || got[0] != "example.com" {
+		t.Errorf("Expected only example.com with bypass_private = false, got %v", got)
+	}
+
+	config.Routing.Direct = append(config.Routing.Direct, "process:qbittorrent")
+	if got := config.Routing.DirectHosts(); len(got) != 1 {
+		t.Errorf("Expected process entries to be left out of the hosts, got %v", got)
+	}
+	if got := config.Routing.DirectProcesses(); len(got) != 1 || got[0] != "qbittorrent" {
+		t.Errorf("Expected the qbittorrent process, got %v", got)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var config ClientConfig
+	if !config.Health.On() {
+		t.Errorf("Expected the circuit breaker to be on by default")
+	}
+	if err := toml.Unmarshal([]byte("remote_addr = \"example.com:443\"\n[health]\nenabled = false\nthreshold = 0.8\n"), &config); err != nil {
+		t.Fatalf("Failed to unmarshal health: %v", err)
+	}
+	if config.Health.On() || config.Health.Threshold != 0.8 {
+		t.Errorf("Expected a disabled breaker with threshold 0.8, got %+v", config.Health)
+	}
+	config.Health.Threshold = 1.5
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a threshold above 1 to be rejected")
+	}
+}
+
+func TestServerNotices(t *testing.T) {
+	tomlData := `
+listen_addr = ":443"
+
+[[notices]]
+kind = "migrate"
+server = "new.example.com:443"
+message = "Please switch to the new server"
+`
+	config := DefaultServerConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal server config: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected config to be valid, got %v", err)
+	}
+
+	config.Notices[0].Server = ""
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a migrate notice without a server to be rejected")
+	}
+	config.Notices[0].Kind = "urgent"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an unknown notice kind to be rejected")
+	}
+
+	config.Notices = nil
+	config.Hints.ALPN = []string{"http/1.1"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected hints.alpn without h2 to be rejected")
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	tomlData := `
+listen_addr = ":443"
+
+[log]
+file = "/var/log/phoenix.log"
+max_size = 10485760
+rotate_every = "24h"
+max_backups = 7
+`
+	config := DefaultServerConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal server config: %v", err)
+	}
+	if l := config.Log; l.File != "/var/log/phoenix.log" || l.MaxSize != 10<<20 || l.RotateEvery != 24*time.Hour || l.MaxBackups != 7 {
+		t.Errorf("Unexpected log settings: %+v", l)
+	}
+	config.Log.MaxAge = -time.Hour
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a negative log.max_age to be rejected")
+	}
+}
+
+func TestLogSink(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Log.Sink = "syslog"
+	config.Log.SyslogAddr = "192.168.1.1:514"
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected a remote syslog sink to validate, got %v", err)
+	}
+	config.Log.Sink = "logcat"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected syslog_addr without the syslog sink to be rejected")
+	}
+	config.Log = LogOutput{Sink: "journal"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an unknown sink to be rejected")
+	}
+}
+
+func TestUserQuota(t *testing.T) {
+	tomlData := `
+listen_addr = ":443"
+
+[quota]
+webhook = "https://hooks.example.com/phoenix"
+
+[[users]]
+name = "alice"
+token = "secret"
+[users.quota]
+bytes = 107374182400
+throttle = 125000
+`
+	config := DefaultServerConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal server config: %v", err)
+	}
+	if q := config.Users[0].Quota; q.Bytes != 100<<30 || q.Throttle != 125000 {
+		t.Errorf("Unexpected quota: %+v", q)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the quota to validate, got %v", err)
+	}
+	config.Users[0].Quota.Period = "hourly"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an unknown quota.period to be rejected")
+	}
+}
+
+func TestOutboundInterface(t *testing.T) {
+	tomlData := `
+listen_addr = ":443"
+outbound_interface = "wg0"
+fwmark = 51820
+`
+	config := DefaultServerConfig()
+	if err := toml.Unmarshal([]byte(tomlData), config); err != nil {
+		t.Fatalf("Failed to unmarshal server config: %v", err)
+	}
+	if config.OutboundInterface != "wg0" || config.FWMark != 51820 {
+		t.Errorf("Unexpected outbound_interface %q / fwmark %d", config.OutboundInterface, config.FWMark)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the fwmark to validate, got %v", err)
+	}
+	config.FWMark = -1
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a negative fwmark to be rejected")
+	}
+}
+
+func TestClientDNS(t *testing.T) {
+	var config ClientConfig
+	tomlData := "remote_addr = \"example.com:443\"\n[dns]\nservers = [\"1.1.1.1:53\"]\nmin_ttl = \"1m\"\nmax_ttl = \"10m\"\n\n[[inbounds]]\nprotocol = \"socks5\"\nlocal_addr = \"127.0.0.1:1080\"\n"
+	if err := toml.Unmarshal([]byte(tomlData), &config); err != nil {
+		t.Fatalf("Failed to unmarshal dns: %v", err)
+	}
+	if config.DNS.MinTTL != time.Minute || config.DNS.MaxTTL != 10*time.Minute {
+		t.Errorf("Unexpected TTL clamps: %+v", config.DNS)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the dns section to validate, got %v", err)
+	}
+	config.DNS.Servers = []string{"dns.google:53"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a hostname in dns.servers to be rejected")
+	}
+}
+
+func TestDNSRules(t *testing.T) {
+	var config ClientConfig
+	tomlData := "remote_addr = \"example.com:443\"\n[dns]\nservers = [\"quic://94.140.14.14\"]\n[[dns.rules]]\ndomains = [\"ir\"]\nservers = [\"system\"]\n[[dns.rules]]\ndomains = [\"example.org\"]\nservers = [\"https://1.1.1.1/dns-query\"]\ntunnel = true\n\n[[inbounds]]\nprotocol = \"socks5\"\nlocal_addr = \"127.0.0.1:1080\"\n"
+	if err := toml.Unmarshal([]byte(tomlData), &config); err != nil {
+		t.Fatalf("Failed to unmarshal dns rules: %v", err)
+	}
+	if len(config.DNS.Rules) != 2 || !config.DNS.Rules[1].Tunnel {
+		t.Fatalf("Unexpected dns rules: %+v", config.DNS.Rules)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the dns rules to validate, got %v", err)
+	}
+	config.DNS.Rules[1].Servers = []string{"quic://94.140.14.14"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected a tunneled QUIC server to be rejected")
+	}
+}
+
+func TestDNSHosts(t *testing.T) {
+	var config ClientConfig
+	tomlData := "remote_addr = \"example.com:443\"\n[dns]\nhosts_file = \"system\"\n[dns.hosts]\n\"nas.lab\" = [\"192.168.1.10\", \"fd00::10\"]\n\n[[inbounds]]\nprotocol = \"socks5\"\nlocal_addr = \"127.0.0.1:1080\"\n"
+	if err := toml.Unmarshal([]byte(tomlData), &config); err != nil {
+		t.Fatalf("Failed to unmarshal dns hosts: %v", err)
+	}
+	if ips := config.DNS.Hosts["nas.lab"]; len(ips) != 2 || config.DNS.HostsFile != "system" {
+		t.Fatalf("Unexpected dns hosts: %+v", config.DNS)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the dns hosts to validate, got %v", err)
+	}
+	config.DNS.Hosts["nas.lab"] = []string{"nas"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an invalid static address to be rejected")
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	at := func(day time.Weekday, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		// 2024-01-07 is a Sunday.
+		return time.Date(2024, 1, 7+int(day), c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	work, err := Schedule{Days: []string{"mon-fri"}, From: "09:00", To: "17:00"}.Window()
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	night, err := Schedule{Days: []string{"fri-sat"}, From: "22:00", To: "06:00"}.Window()
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	for _, tc := range []struct {
+		w    Window
+		at   time.Time
+		want bool
+	}{
+		{work, at(time.Monday, "09:00"), true},
+		{work, at(time.Friday, "16:59"), true},
+		{work, at(time.Friday, "17:00"), false},
+		{work, at(time.Saturday, "12:00"), false},
+		{night, at(time.Friday, "23:00"), true},
+		{night, at(time.Sunday, "05:00"), true}, // Saturday night
+		{night, at(time.Sunday, "23:00"), false},
+		{night, at(time.Friday, "05:00"), false}, // Thursday night
+	} {
+		if got := tc.w.Contains(tc.at); got != tc.want {
+			t.Errorf("Contains(%s) = %v, want %v", tc.at.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+
+	var config ServerConfig
+	tomlData := "listen_addr = \":443\"\ntimezone = \"Asia/Tehran\"\n[[acl]]\nports = [\"27015-27030\"]\nschedule = [{ days = [\"mon-fri\"], from = \"09:00\", to = \"17:00\" }]\n"
+	if err := toml.Unmarshal([]byte(tomlData), &config); err != nil {
+		t.Fatalf("Failed to unmarshal acl: %v", err)
+	}
+	if len(config.ACL) != 1 || len(config.ACL[0].Schedule) != 1 || config.ACL[0].Schedule[0].To != "17:00" {
+		t.Fatalf("Unexpected acl: %+v", config.ACL)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the acl to validate, got %v", err)
+	}
+	config.ACL[0].Schedule[0].Days = []string{"weekdays"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an invalid schedule day to be rejected")
+	}
+	config.ACL[0].Schedule = nil
+	config.Timezone = "Mars/Olympus"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an unknown timezone to be rejected")
+	}
+}
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+// FuzzParseConfig feeds arbitrary TOML to both loaders and validators, which
+// must reject bad input with an error rather than panic.
+func FuzzParseConfig(f *testing.F) {
+	f.Add([]byte("remote_addr = \"example.com:443\"\n[[inbounds]]\nprotocol = \"socks5\"\nlocal_addr = \"127.0.0.1:1080\"\n"))
+	f.Add([]byte("listen_addr = \":443\"\n[security]\nenable_socks5 = true\n[[users]]\nname = \"a\"\nallow = [\"10.0.0.0/8\"]\n"))
+	f.Add([]byte("[routing]\nbypass_private = false\n[fallback]\nafter = \"5s\"\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if c, err := parseClientConfig(data); err == nil {
+			c.Validate()
+			for _, in := range c.Inbounds {
+				in.Name()
+			}
+			c.Routing.DirectHosts()
+		}
+		if s, err := parseServerConfig(data); err == nil {
+			s.Validate()
+		}
+	})
+}
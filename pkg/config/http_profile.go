@@ -0,0 +1,34 @@
+package config
+
+// HTTPProfile controls how tunnel requests look on the wire. The client and
+// server must use the same profile. The zero value reproduces the original
+// Phoenix wire format (POST to "/" with X-Nerve-* headers).
+type HTTPProfile struct {
+	// Secret, when set, derives the header names and a pool of request paths
+	// from an HMAC of the secret, so every deployment has its own signature.
+	// The client picks a random path from the pool for each stream.
+	// Explicit header names and Path below take precedence over derived ones.
+	Secret string `toml:"secret,omitempty"`
+
+	// Path is the request path for tunnel streams (e.g. "/api/v2/sync").
+	// Empty (and no Secret) = "/" with the server accepting any path.
+	Path string `toml:"path,omitempty"`
+
+	// Method is the HTTP method for tunnel streams: POST (default), PUT or PATCH.
+	Method string `toml:"method,omitempty"`
+
+	// Header names carrying tunnel metadata. Empty = X-Nerve-* default or
+	// secret-derived name.
+	ProtocolHeader string `toml:"protocol_header,omitempty"`
+	TargetHeader   string `toml:"target_header,omitempty"`
+	TokenHeader    string `toml:"token_header,omitempty"`
+	VersionHeader  string `toml:"version_header,omitempty"`
+
+	// UserAgent and Accept are sent as decoy headers by the client.
+	// An empty UserAgent matches the configured TLS fingerprint's browser.
+	UserAgent string `toml:"user_agent,omitempty"`
+	Accept    string `toml:"accept,omitempty"`
+
+	// Headers are extra static decoy headers added to every tunnel request.
+	Headers map[string]string `toml:"headers,omitempty"`
+}
@@ -10,6 +10,23 @@ import (
 
 // LoadServerConfig reads and parses a server configuration file.
 func LoadServerConfig(filePath string) (*ServerConfig, error) {
+	data, err := readConfigFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseServerConfig(data)
+}
+
+// LoadClientConfig reads and parses a client configuration file.
+func LoadClientConfig(filePath string) (*ClientConfig, error) {
+	data, err := readConfigFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseClientConfig(data)
+}
+
+func readConfigFile(filePath string) ([]byte, error) {
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -17,29 +34,21 @@ func LoadServerConfig(filePath string) (*ServerConfig, error) {
 		}
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return data, nil
+}
 
+func parseServerConfig(data []byte) (*ServerConfig, error) {
 	config := DefaultServerConfig()
 	if err := toml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse TOML configuration: %w", err)
 	}
-
 	return config, nil
 }
 
-// LoadClientConfig reads and parses a client configuration file.
-func LoadClientConfig(filePath string) (*ClientConfig, error) {
-	data, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("config file not found: %s", filePath)
-		}
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
+func parseClientConfig(data []byte) (*ClientConfig, error) {
 	config := DefaultClientConfig()
 	if err := toml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse TOML configuration: %w", err)
 	}
-
 	return config, nil
 }
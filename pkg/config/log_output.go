@@ -0,0 +1,37 @@
+package config
+
+import "time"
+
+// LogOutput writes the log to a file instead of stderr, rotating it by size
+// and time, and can copy it to syslog or Android's logcat. Rotated files get the rotation time appended to their name
+// (e.g. "phoenix.log.20261014-153045"). SIGUSR2 reopens the file, for
+// external tools such as logrotate that move it away themselves.
+type LogOutput struct {
+	// File is the log file's path. Empty = stderr.
+	File string `toml:"file,omitempty"`
+
+	// MaxSize rotates the file before it grows past this many bytes
+	// (default 0, no size limit).
+	MaxSize int64 `toml:"max_size,omitempty"`
+
+	// RotateEvery rotates the file at multiples of this interval since
+	// midnight UTC, e.g. "24h" for daily files (default 0, never).
+	RotateEvery time.Duration `toml:"rotate_every,omitempty"`
+
+	// MaxBackups is how many rotated files are kept (default 0, all).
+	MaxBackups int `toml:"max_backups,omitempty"`
+
+	// MaxAge deletes rotated files older than this (default 0, never).
+	MaxAge time.Duration `toml:"max_age,omitempty"`
+
+	// Sink also sends every log line to "syslog" (the local daemon, or
+	// SyslogAddr) or "logcat" (Android's log, with Tag). Empty = neither.
+	Sink string `toml:"sink,omitempty"`
+
+	// SyslogAddr is the host:port of a remote syslog server, reached over
+	// UDP. Empty = the local daemon.
+	SyslogAddr string `toml:"syslog_addr,omitempty"`
+
+	// Tag names the program in syslog and logcat (default "phoenix").
+	Tag string `toml:"tag,omitempty"`
+}
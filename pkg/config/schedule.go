@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Schedules name IANA time zones, which Android and Windows don't ship
+	// in the form the time package reads.
+	_ "time/tzdata"
+)
+
+// Schedule is a weekly time window: rules that carry schedules only apply
+// inside one of them.
+//
+//	schedule = [{ days = ["mon-fri"], from = "09:00", to = "17:00" }]
+type Schedule struct {
+	// Days are weekdays ("mon" ... "sun") or ranges of them ("mon-fri",
+	// "sat-sun"). Empty = every day.
+	Days []string `toml:"days,omitempty"`
+
+	// From and To bound the window as "15:04" in the config's timezone
+	// (defaults 00:00 and 24:00). A window with To before From runs past
+	// midnight, e.g. "22:00" to "06:00", and counts for the day it starts.
+	From string `toml:"from,omitempty"`
+	To   string `toml:"to,omitempty"`
+}
+
+// Window is a parsed Schedule.
+type Window struct {
+	days     [7]bool // By time.Weekday
+	from, to int     // Minutes since midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window parses s.
+func (s Schedule) Window() (Window, error) {
+	w := Window{to: 24 * 60}
+	if len(s.Days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, d := range s.Days {
+		lo, hi, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(d)), "-")
+		if !isRange {
+			hi = lo
+		}
+		first, ok1 := weekdays[lo]
+		last, ok2 := weekdays[hi]
+		if !ok1 || !ok2 {
+			return Window{}, fmt.Errorf("invalid schedule day %q", d)
+		}
+		// "fri-mon" wraps over the weekend.
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	var err error
+	if s.From != "" {
+		if w.from, err = parseClock(s.From); err != nil {
+			return Window{}, err
+		}
+	}
+	if s.To != "" {
+		if w.to, err = parseClock(s.To); err != nil {
+			return Window{}, err
+		}
+	}
+	if w.from == w.to {
+		return Window{}, fmt.Errorf("schedule from %q to %q is empty", s.From, s.To)
+	}
+	return w, nil
+}
+
+// parseClock parses "15:04" ("24:00" included) to minutes since midnight.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule time %q, want hh:mm", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t, in the schedule's timezone, is inside w.
+func (w Window) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.from < w.to {
+		return w.days[t.Weekday()] && m >= w.from && m < w.to
+	}
+	// Past midnight: the evening of a listed day, or the morning after one.
+	return w.days[t.Weekday()] && m >= w.from || w.days[(t.Weekday()+6)%7] && m < w.to
+}
+
+// LoadTimezone returns the location of a timezone setting: an IANA name
+// such as "Asia/Tehran", "UTC", or "" for the host's local time.
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %v", name, err)
+	}
+	return loc, nil
+}
+
+func validateSchedules(schedules []Schedule) error {
+	for _, s := range schedules {
+		if _, err := s.Window(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"phoenix/pkg/protocol"
+	"time"
+)
+
 // ServerSecurity defines the security configuration for the server.
 // It controls which protocols are allowed to be tunneled.
 type ServerSecurity struct {
@@ -20,6 +25,11 @@ type ServerSecurity struct {
 	// EnableSSH enables or disables SSH tunneling.
 	EnableSSH bool `toml:"enable_ssh"`
 
+	// ReverseListen lists the addresses clients may have the server listen
+	// on for them (Client.Listen), e.g. "127.0.0.1:8080" or ":9000". The
+	// first client, or user, to listen on one holds it. Empty = disabled.
+	ReverseListen []string `toml:"reverse_listen,omitempty"`
+
 	// PrivateKeyPath is the path to the server's private key file (PEM).
 	PrivateKeyPath string `toml:"private_key"`
 
@@ -44,12 +54,579 @@ type ServerConfig struct {
 
 	// Security defines the protocol access controls.
 	Security ServerSecurity `toml:"security"`
+
+	// MinClientVersion rejects clients reporting an older X-Nerve-Version
+	// (e.g. "v1.2.0") with 426 Upgrade Required. Clients that send no version
+	// header predate version reporting and are rejected too, as are versions
+	// that don't parse, except "dev" builds. Empty = allow all.
+	MinClientVersion string `toml:"min_client_version,omitempty"`
+
+	// LogLevel is "info" (default) or "debug", which adds per-stream
+	// detail. SIGUSR1 toggles it while the server runs.
+	LogLevel string `toml:"log_level,omitempty"`
+
+	// Log writes the log to a rotated file instead of stderr.
+	Log LogOutput `toml:"log"`
+
+	// DisabledFeatures lists protocol features (see protocol.Feature) the
+	// server refuses during negotiation even though it implements them.
+	DisabledFeatures []protocol.Feature `toml:"disabled_features,omitempty"`
+
+	// HTTP customizes the tunnel request path, method and header names.
+	// Must match the clients' [http] section.
+	HTTP HTTPProfile `toml:"http"`
+
+	// OutboundProxy sends TCP streams to their targets through an upstream
+	// instead of dialing them directly, for two-hop setups:
+	//   "socks5://[user:pass@]host:port"
+	//   "http://[user:pass@]host:port"  (HTTP CONNECT)
+	//   "phoenix:///etc/phoenix/exit.toml" (a client config for a second Phoenix server)
+	// UDP associations are always sent directly. Empty = dial directly.
+	OutboundProxy string `toml:"outbound_proxy,omitempty"`
+
+	// Egress selects the local source address for direct outbound dials on
+	// hosts with several public IPs. Ignored when outbound_proxy is set.
+	Egress EgressConfig `toml:"egress"`
+
+	// Dial tunes how the server connects to targets directly.
+	Dial DialConfig `toml:"dial"`
+
+	// OutboundInterface binds the sockets of direct TCP dials and UDP
+	// relay sessions to this interface (e.g. "wg0"), so egress can leave
+	// through a tunnel without touching the host's default route. Linux
+	// (needs CAP_NET_RAW) and macOS. Empty = the routing table decides.
+	OutboundInterface string `toml:"outbound_interface,omitempty"`
+
+	// FWMark sets SO_MARK on the same sockets, for policy routing with
+	// "ip rule add fwmark N table T". Linux only (needs CAP_NET_ADMIN).
+	// 0 = unmarked.
+	FWMark int `toml:"fwmark,omitempty"`
+
+	// BlockedPorts lists destination ports ("25", "135-139") that clients
+	// may not connect to, TCP or UDP. Defaults to DefaultBlockedPorts, which
+	// covers ports commonly abused for spam, Windows file sharing and UDP
+	// amplification; set to [] to allow everything.
+	BlockedPorts []string `toml:"blocked_ports"`
+
+	// ACL blocks or throttles some destinations, for some users or at some
+	// times, e.g. gaming ports during working hours. The first rule that
+	// matches and is in schedule applies.
+	ACL []ACLRule `toml:"acl,omitempty"`
+
+	// Timezone evaluates the schedules of acl: an IANA name such as
+	// "Asia/Tehran", or empty for the host's local time.
+	Timezone string `toml:"timezone,omitempty"`
+
+	// GeoData are GeoIP and geosite lists kept up to date from URLs, used
+	// as "geo:<name>" in acl hosts, users' allow and deny, and egress
+	// rules. See the client's [[geodata]]; tunnel is ignored.
+	GeoData []GeoData `toml:"geodata,omitempty"`
+
+	// UDP tunes the UDP relay used for SOCKS5 UDP associate.
+	UDP UDPConfig `toml:"udp"`
+
+	// DNS configures the DNS fast path of the UDP relay.
+	DNS ServerDNS `toml:"dns"`
+
+	// Streams bounds how long a tunneled stream may live.
+	Streams StreamConfig `toml:"streams"`
+
+	// Limits protects the endpoint against stream and connection floods.
+	Limits ServerLimits `toml:"limits"`
+
+	// TrustedProxies lists the IPs and CIDRs of CDN edges or load balancers
+	// in front of the server. For connections from them the client address
+	// is taken from CF-Connecting-IP or X-Forwarded-For, for logs and
+	// per-client limits; anyone else's forwarding headers are ignored.
+	// limits.handshakes_per_minute, enforced before any header is read,
+	// still counts per edge.
+	TrustedProxies []string `toml:"trusted_proxies,omitempty"`
+
+	// Users is the user table. When set, a client must present a user's
+	// token (or mTLS key) or the global auth_token, and each user's
+	// permissions apply to their streams.
+	Users []User `toml:"users,omitempty"`
+
+	// UserDB is the path of a SQLite database holding the user table
+	// instead of [[users]], which must then be empty. Users are managed
+	// through the admin API ([api] listen) and take effect at once. The
+	// database is created if it doesn't exist.
+	UserDB string `toml:"user_db,omitempty"`
+
+	// Quota configures alerts and persistence of the users' quotas.
+	Quota QuotaConfig `toml:"quota"`
+
+	// Fallback sends connections and requests that aren't Phoenix tunnels
+	// to a real web site, so the port doubles as one. Together with
+	// http.path or http.secret the tunnel then hides at a path of the site.
+	Fallback ServerFallback `toml:"fallback"`
+
+	// VHosts serve further Phoenix endpoints on the same listener, picked
+	// by the TLS SNI or, over h2c, by the Host header. Each has its own
+	// key, credentials and users; protocols, limits and outbound settings
+	// are shared. Other names get the top-level endpoint.
+	VHosts []VHost `toml:"vhosts,omitempty"`
+
+	// Notices are pushed to clients when their notice stream opens, e.g. a
+	// planned maintenance window or a request to move to another server.
+	Notices []ServerNotice `toml:"notices,omitempty"`
+
+	// Hints suggest tuning to clients on their notice stream, e.g. after
+	// seeing the network between them and the server drop idle
+	// connections. Clients apply them without a restart wherever their own
+	// config leaves the setting at its default.
+	Hints ClientHints `toml:"hints"`
+
+	// ShutdownGrace is how long the server waits after telling connected
+	// clients it is shutting down (SIGINT or SIGTERM) before it exits
+	// (default 1s), so they can move new streams elsewhere first.
+	ShutdownGrace time.Duration `toml:"shutdown_grace,omitempty"`
+
+	// API serves a local HTTP API for inspecting the running server.
+	API ServerAPI `toml:"api"`
+
+	// TargetStats aggregates traffic by destination host, listed by the
+	// API's /targets.
+	TargetStats TargetStats `toml:"target_stats"`
+
+	// FlowExport sends per-stream flow records to a NetFlow/IPFIX
+	// collector.
+	FlowExport FlowExport `toml:"flow_export"`
+
+	// Hardening drops privileges and confines the process once its
+	// listener is bound (Linux).
+	Hardening Hardening `toml:"hardening"`
+}
+
+// ServerAPI configures the server's local admin API, which lists the live
+// streams (GET /connections) and the top targets (GET /targets), reads and
+// sets the log level, and manages the users of user_db (/users).
+type ServerAPI struct {
+	// Listen is the API address, e.g. "127.0.0.1:9091", or "unix:" and the
+	// path of a Unix socket (empty = disabled). The API lists users and
+	// their targets: keep it on loopback or set a token.
+	Listen string `toml:"listen,omitempty"`
+
+	// Token and AllowOrigins guard the API as in the client's [api].
+	Token        string   `toml:"token,omitempty"`
+	AllowOrigins []string `toml:"allow_origins,omitempty"`
+}
+
+// TargetStats configures the per-target statistics: connections and bytes
+// by destination host (ports dropped) and user, to spot abuse without
+// logging traffic. Only streams the client names a target for are counted.
+type TargetStats struct {
+	// Enabled turns the statistics on (default off).
+	Enabled bool `toml:"enabled,omitempty"`
+
+	// HideUsers aggregates all users together.
+	HideUsers bool `toml:"hide_users,omitempty"`
+
+	// HashHosts lists hosts as a keyed hash, stable until the server
+	// restarts, instead of by name.
+	HashHosts bool `toml:"hash_hosts,omitempty"`
+}
+
+// Hardening confines the server after it binds listen_addr, to limit what
+// a compromised process can reach. Files the config names (keys, user_db,
+// log, geodata caches) must be readable by user, and inside chroot.
+type Hardening struct {
+	// User and Group are the account to switch to, by name or number
+	// (default group: the user's). Switching needs the server to start as
+	// root.
+	User  string `toml:"user,omitempty"`
+	Group string `toml:"group,omitempty"`
+
+	// Chroot is a directory to confine the process to before switching
+	// users; it needs /etc/resolv.conf and the config's files inside.
+	Chroot string `toml:"chroot,omitempty"`
+
+	// Landlock limits file access to /etc and the files the config names
+	// (written to where needed) plus ReadPaths and WritePaths, on kernels
+	// that support it. It needs a binary built with CGO_ENABLED=0.
+	Landlock   bool     `toml:"landlock,omitempty"`
+	ReadPaths  []string `toml:"read_paths,omitempty"`
+	WritePaths []string `toml:"write_paths,omitempty"`
+
+	// Seccomp refuses system calls a proxy never needs: running programs,
+	// tracing, mounting, loading modules or BPF, and namespaces.
+	Seccomp bool `toml:"seccomp,omitempty"`
+}
+
+// FlowExport configures IPFIX (NetFlow v10) export over UDP: one record per
+// stream with the client's address, the user, the target and the bytes each
+// way, for existing network accounting. As for TargetStats, only streams
+// the client names a target for are exported.
+type FlowExport struct {
+	// Collector is the collector's UDP address, e.g. "10.0.0.5:4739"
+	// (empty = disabled).
+	Collector string `toml:"collector,omitempty"`
+
+	// ActiveTimeout is how often streams that are still open are reported,
+	// with the bytes since their last record (default 60s).
+	ActiveTimeout time.Duration `toml:"active_timeout,omitempty"`
+
+	// DomainID is the observation domain ID of the records (default 0).
+	DomainID uint32 `toml:"domain_id,omitempty"`
+}
+
+// ClientHints are tuning values suggested to clients.
+type ClientHints struct {
+	// KeepaliveInterval replaces the client's default keepalive.interval,
+	// e.g. "20s" where a middlebox drops connections idle for 30s. Clients
+	// ping at most every 10s.
+	KeepaliveInterval time.Duration `toml:"keepalive_interval,omitempty"`
+
+	// Padding grows the ClientHello of new connections to this many bytes,
+	// like fragment.padding. Only clients with a fingerprint pad.
+	Padding int `toml:"padding,omitempty"`
+
+	// ALPN is the list of protocols offered in the ClientHello of new
+	// connections, e.g. ["h2", "http/1.1"] as browsers do; it must include
+	// "h2". Fingerprints keep their browser's list.
+	ALPN []string `toml:"alpn,omitempty"`
+}
+
+// ServerNotice is a notice pushed to clients.
+type ServerNotice struct {
+	// Kind is "info" (default), "quota", "maintenance", "shutdown",
+	// "migrate" or "disconnect". Clients stop tunneling on "disconnect".
+	Kind string `toml:"kind,omitempty"`
+
+	// Message is shown to the user.
+	Message string `toml:"message,omitempty"`
+
+	// Server is the "host:port" a "migrate" notice asks clients to move to.
+	Server string `toml:"server,omitempty"`
+
+	// Users limits the notice to these users of the user table (default:
+	// every client).
+	Users []string `toml:"users,omitempty"`
+}
+
+// ServerFallback configures the backend behind the tunnel endpoint.
+type ServerFallback struct {
+	// Backend is the web server's address, e.g. "127.0.0.1:8443" for a
+	// local nginx. Over TLS, ClientHellos that don't offer h2 go to it;
+	// HTTP requests that aren't tunnel streams (wrong method, path or
+	// headers) are proxied to it with their Host header. Empty = answer
+	// them with errors.
+	Backend string `toml:"backend,omitempty"`
+
+	// BackendTLS is set when Backend expects TLS. Non-h2 TLS connections
+	// then reach it untouched, so it presents its own certificate;
+	// requests Phoenix decrypted are re-encrypted without verifying its
+	// certificate. Otherwise the server terminates TLS and sends plain
+	// HTTP.
+	BackendTLS bool `toml:"backend_tls,omitempty"`
+
+	// Root is a directory of static files served instead of a Backend,
+	// e.g. "/var/www/html". Directories are only served through their
+	// index.html, never listed.
+	Root string `toml:"root,omitempty"`
+}
+
+// VHost is a virtual endpoint of the server, e.g. one per domain or
+// operator sharing a :443.
+type VHost struct {
+	// ServerNames are the domains this endpoint answers for;
+	// "*.example.com" matches any subdomain.
+	ServerNames []string `toml:"server_names"`
+
+	// PrivateKeyPath is the endpoint's TLS key. Either every endpoint,
+	// the top-level one included, has a key, or none has (h2c).
+	PrivateKeyPath string `toml:"private_key,omitempty"`
+
+	// AuthToken, AuthorizedClientKeys and Users work like their
+	// top-level counterparts, for this endpoint only.
+	AuthToken            string   `toml:"auth_token,omitempty"`
+	AuthorizedClientKeys []string `toml:"authorized_clients,omitempty"`
+	Users                []User   `toml:"users,omitempty"`
 }
 
+// UDPConfig tunes UDP relaying. The same section exists in client and
+// server configs; zero values mean no limit.
+type UDPConfig struct {
+	// NAT selects how the server maps and filters UDP for a client:
+	// "full-cone" (default) accepts packets from any peer on the session's
+	// port, which games and P2P apps need; "restricted" only accepts
+	// replies from peers the client sent to; "symmetric" uses a separate
+	// port per destination. Server only.
+	NAT string `toml:"nat,omitempty"`
+
+	// IdleTimeout closes a UDP association after this long without packets
+	// (e.g. "10m"), and on the server also expires NAT table entries idle as
+	// long. Default: associations live as long as their SOCKS5 control
+	// connection, so WireGuard or game sessions with sparse keepalives
+	// are never cut.
+	IdleTimeout time.Duration `toml:"idle_timeout,omitempty"`
+
+	// MaxSessions caps concurrent UDP associations.
+	MaxSessions int `toml:"max_sessions,omitempty"`
+
+	// MaxPeers caps the server's NAT table per association (default 1024);
+	// the least recently used entry is evicted when full. Server only.
+	MaxPeers int `toml:"max_peers,omitempty"`
+
+	// MaxPacketSize drops datagrams whose payload is larger (bytes), e.g.
+	// 1452 to stay under a known path MTU. Datagrams are never fragmented;
+	// anything above 65535 bytes including the SOCKS5 header is dropped.
+	MaxPacketSize int `toml:"max_packet_size,omitempty"`
+
+	// QueueDepth caps datagrams buffered for a client that reads slower
+	// than its peers send (default 256); newer ones are dropped. Server
+	// only.
+	QueueDepth int `toml:"queue_depth,omitempty"`
+
+	// SocketBuffer is the kernel buffer size of each relay socket in bytes
+	// (default 4 MiB). Symmetric NAT opens one socket per destination, so
+	// lower it there when memory is tight. Server only.
+	SocketBuffer int `toml:"socket_buffer,omitempty"`
+}
+
+// ServerDNS configures how the server handles DNS carried over UDP associate.
+type ServerDNS struct {
+	// FastPath answers datagrams to port 53 with a shared resolver and
+	// cache instead of relaying them from the session's own socket, saving
+	// an upstream round trip on cache hits and keeping DNS out of the NAT
+	// table.
+	FastPath bool `toml:"fast_path"`
+
+	// Upstreams are resolvers to use instead of the one each query was
+	// addressed to (e.g. ["1.1.1.1:53", "9.9.9.9:53"]), or DNS-over-TLS,
+	// -QUIC and -HTTPS servers written like the client's dns.servers.
+	// Queries rotate over them and fail over on timeout.
+	Upstreams []string `toml:"upstreams,omitempty"`
+
+	// CacheSize is the number of cached responses (default 4096; -1
+	// disables the cache).
+	CacheSize int `toml:"cache_size,omitempty"`
+
+	// Timeout bounds each upstream attempt (default 2s).
+	Timeout time.Duration `toml:"timeout,omitempty"`
+}
+
+// User is one entry of the server's user table.
+type User struct {
+	// Name identifies the user in logs.
+	Name string `toml:"name"`
+
+	// Token authenticates the user (sent as the client's auth_token).
+	Token string `toml:"token,omitempty"`
+
+	// PublicKey authenticates the user by mTLS client key (Base64 Ed25519).
+	// Listed keys are authorized automatically.
+	PublicKey string `toml:"public_key,omitempty"`
+
+	// Protocols limits which protocols the user may open, e.g. ["socks5"]
+	// for a guest without UDP. Empty = everything enabled in [security].
+	Protocols []protocol.ProtocolType `toml:"protocols,omitempty"`
+
+	// Allow restricts destinations to these domains, IPs or CIDRs.
+	// Empty = any destination.
+	Allow []string `toml:"allow,omitempty"`
+
+	// Deny blocks these destinations; checked before Allow.
+	Deny []string `toml:"deny,omitempty"`
+
+	// Egress lists source IPs or CIDRs for this user's outbound
+	// connections, overriding [egress]. Ignored with outbound_proxy.
+	Egress []string `toml:"egress,omitempty"`
+
+	// Quota caps the user's traffic per period.
+	Quota UserQuota `toml:"quota"`
+
+	// Record is the user's consent to having their streams passed to the
+	// server's Recorder: "" (default, never), "metadata" (who connected
+	// where, when and how much) or "content" (metadata and the data
+	// relayed). Servers without a Recorder record nothing.
+	Record string `toml:"record,omitempty"`
+}
+
+// ACLRule blocks or throttles the streams it matches.
+type ACLRule struct {
+	// Users limits the rule to these users of the user table; empty =
+	// every client.
+	Users []string `toml:"users,omitempty"`
+
+	// Hosts are domains, IPs or CIDRs; empty = any host.
+	Hosts []string `toml:"hosts,omitempty"`
+
+	// Ports are destination ports or ranges ("3074", "27015-27030");
+	// empty = any port.
+	Ports []string `toml:"ports,omitempty"`
+
+	// Action is "block" (default) or "throttle".
+	Action string `toml:"action,omitempty"`
+
+	// Block is how blocked streams fail, as in routing rules: "reset"
+	// (default), "drop" or "http".
+	Block string `toml:"block,omitempty"`
+
+	// Rate is the bytes per second a user's matching streams share
+	// together, both directions, when throttled.
+	Rate int64 `toml:"rate,omitempty"`
+
+	// Schedule limits the rule to these windows, in timezone. Empty =
+	// always. Streams opened inside a throttle window stay throttled
+	// only while it lasts.
+	Schedule []Schedule `toml:"schedule,omitempty"`
+}
+
+// UserQuota is a user's traffic allowance. Both directions count, and
+// control streams (notices, feedback) are exempt. Periods start at local
+// midnight.
+type UserQuota struct {
+	// Bytes is the allowance per period, e.g. 107374182400 for 100 GiB
+	// (default 0, unlimited).
+	Bytes int64 `toml:"bytes,omitempty"`
+
+	// Period resets the usage "daily", "weekly" (on Mondays) or "monthly"
+	// (default).
+	Period string `toml:"period,omitempty"`
+
+	// ResetDay is the day of the month monthly periods start on, 1 to 28
+	// (default 1).
+	ResetDay int `toml:"reset_day,omitempty"`
+
+	// Throttle slows the user to this many bytes per second once the
+	// allowance is used up, e.g. 125000 for 1 Mbit/s. Default 0 refuses
+	// new streams and cuts open ones instead.
+	Throttle int64 `toml:"throttle,omitempty"`
+}
+
+// QuotaConfig configures quota alerts and persistence for [[users]] with a
+// quota.
+type QuotaConfig struct {
+	// Alerts are the usage percentages at which a user is warned by a
+	// "quota" notice and Webhook is called (default [80, 100]).
+	Alerts []int `toml:"alerts,omitempty"`
+
+	// Webhook is POSTed a JSON QuotaAlert (see transport) at each alert.
+	// Empty = notices and the log only.
+	Webhook string `toml:"webhook,omitempty"`
+
+	// StateFile keeps the usage of the current periods across restarts
+	// (saved every minute). Empty = usage starts over on restart. The
+	// users of [[vhosts]] aren't kept.
+	StateFile string `toml:"state_file,omitempty"`
+}
+
+// StreamConfig limits server streams so that ones abandoned by crashed or
+// vanished clients are torn down instead of holding a goroutine, an HTTP/2
+// stream and a target connection forever. Zero values mean no limit.
+type StreamConfig struct {
+	// IdleTimeout closes a stream after this long with no data in either
+	// direction (e.g. "15m"). Keep it above the keepalive interval of
+	// protocols such as SSH that are tunneled idle for long periods.
+	IdleTimeout time.Duration `toml:"idle_timeout,omitempty"`
+
+	// MaxLifetime closes a stream this long after it was opened, however
+	// busy it is (e.g. "24h").
+	MaxLifetime time.Duration `toml:"max_lifetime,omitempty"`
+}
+
+// ServerLimits caps what a single client can make the server do, mitigating
+// HTTP/2 rapid reset (CVE-2023-44487) and similar floods.
+type ServerLimits struct {
+	// MaxStreamsPerConn caps concurrent streams on one HTTP/2 connection
+	// (default 500).
+	MaxStreamsPerConn int `toml:"max_streams_per_conn,omitempty"`
+
+	// StreamRate caps new streams per second on one connection, with
+	// bursts of twice as many (default 200, -1 = unlimited). A connection
+	// that exceeds it, e.g. by opening and resetting streams in a loop, is
+	// closed.
+	StreamRate int `toml:"stream_rate,omitempty"`
+
+	// MaxStreamsPerClient caps concurrent streams per client IP across all
+	// of its connections. Extra streams are refused with 429. Default 0
+	// (unlimited), since many users can share one NAT address.
+	MaxStreamsPerClient int `toml:"max_streams_per_client,omitempty"`
+
+	// HandshakesPerMinute caps new connections (TLS handshakes) per client
+	// IP, with bursts of as many. Connections over the limit are closed
+	// before the handshake. Default 0 (unlimited).
+	HandshakesPerMinute int `toml:"handshakes_per_minute,omitempty"`
+
+	// StreamBuffer caps data received from the client and buffered per
+	// stream before the target accepts it, in bytes (default 1 MiB). This
+	// is the stream's HTTP/2 flow-control window.
+	StreamBuffer int `toml:"stream_buffer,omitempty"`
+
+	// ConnBuffer caps the same across all streams of one connection
+	// (default 1 MiB, raised to stream_buffer if smaller).
+	ConnBuffer int `toml:"conn_buffer,omitempty"`
+
+	// MaxStreams caps concurrent streams across the whole server, and
+	// MaxGoroutines refuses new streams while the process runs more
+	// goroutines (each stream takes a few). Default 0 (unlimited) for
+	// both. Streams over these limits or under memory pressure are
+	// refused with 503 and Retry-After, which clients retry.
+	MaxStreams    int `toml:"max_streams,omitempty"`
+	MaxGoroutines int `toml:"max_goroutines,omitempty"`
+
+	// MemoryLimit is a soft limit on the server's memory in bytes, given
+	// to the Go runtime (debug.SetMemoryLimit) so it collects garbage
+	// harder near it; past 90% of it new streams are refused. Default 0
+	// (none; GOMEMLIMIT still applies).
+	MemoryLimit int64 `toml:"memory_limit,omitempty"`
+}
+
+// DialConfig tunes direct target dials. Hostnames are resolved and tried
+// over IPv6 and IPv4 in parallel (Happy Eyeballs, RFC 8305).
+type DialConfig struct {
+	// Family orders or restricts the address families tried: "auto"
+	// (default; the resolver's order, usually IPv6 first), "prefer-ipv4",
+	// "prefer-ipv6", "ipv4-only" or "ipv6-only".
+	Family string `toml:"family,omitempty"`
+
+	// AttemptDelay is how long an attempt may run before the next address
+	// is tried in parallel (default 250ms).
+	AttemptDelay time.Duration `toml:"attempt_delay,omitempty"`
+
+	// Timeout bounds resolving and connecting to a target (default 10s).
+	Timeout time.Duration `toml:"timeout,omitempty"`
+
+	// Retries redials a target this many more times after a timeout or
+	// unreachable error (not after "connection refused" or an unknown
+	// host). Applies to outbound_proxy dials too. Default 0.
+	Retries int `toml:"retries,omitempty"`
+
+	// RetryDelay is the pause before each retry (default 500ms).
+	RetryDelay time.Duration `toml:"retry_delay,omitempty"`
+}
+
+// EgressConfig assigns source addresses to outbound connections.
+type EgressConfig struct {
+	// Bind lists source IPs or CIDRs (e.g. "203.0.113.8/29") used in
+	// round-robin for all targets. Empty = let the OS choose. Every address
+	// must be assigned to this host.
+	Bind []string `toml:"bind,omitempty"`
+
+	// Rules override Bind for matching targets; the first match wins.
+	Rules []EgressRule `toml:"rules,omitempty"`
+}
+
+// EgressRule sends matching targets out of its own source addresses.
+type EgressRule struct {
+	// Targets are domains (matching subdomains too), IPs or CIDRs.
+	Targets []string `toml:"targets"`
+
+	// Bind lists source IPs or CIDRs for these targets.
+	Bind []string `toml:"bind"`
+}
+
+// DefaultBlockedPorts are the destination ports blocked unless BlockedPorts
+// is set: SMTP relay (25), chargen (19), RPC/NetBIOS/SMB (135-139, 445),
+// SSDP (1900) and memcached (11211).
+var DefaultBlockedPorts = []string{"19", "25", "135-139", "445", "1900", "11211"}
+
 // DefaultServerConfig returns a server configuration with safe defaults.
 func DefaultServerConfig() *ServerConfig {
 	return &ServerConfig{
-		ListenAddr: ":8080",
-		Security:   DefaultServerSecurity(),
+		ListenAddr:   ":8080",
+		Security:     DefaultServerSecurity(),
+		BlockedPorts: append([]string(nil), DefaultBlockedPorts...),
 	}
 }
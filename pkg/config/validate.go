@@ -0,0 +1,760 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/protocol"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Validate checks the client configuration for structural errors that would
+// otherwise only surface at connect time.
+func (c *ClientConfig) Validate() error {
+	if _, err := loglevel.Parse(c.LogLevel); err != nil {
+		return err
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if err := c.validateConnection(); err != nil {
+		return err
+	}
+	for i, hop := range c.Chain {
+		if err := hop.ClientConfig().validateConnection(); err != nil {
+			return fmt.Errorf("chain hop %d: %v", i, err)
+		}
+	}
+	if c.SourceAddr != "" && net.ParseIP(c.SourceAddr) == nil {
+		return fmt.Errorf("invalid source_addr %q", c.SourceAddr)
+	}
+	if err := c.DNS.validate(); err != nil {
+		return err
+	}
+
+	if c.Cover.MaxInterval > 0 && c.Cover.MaxInterval < c.Cover.MinInterval {
+		return fmt.Errorf("cover.max_interval must not be less than cover.min_interval")
+	}
+	for _, p := range append(append([]string{}, c.Cover.Pages...), c.Cover.Assets...) {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("cover path %q must start with /", p)
+		}
+	}
+
+	if c.Fragment.RecordSize < 0 || c.Fragment.SegmentSize < 0 || c.Fragment.Delay < 0 {
+		return fmt.Errorf("fragment sizes and delay must not be negative")
+	}
+	if c.Fragment.Padding < 0 || c.Fragment.Padding > 16000 {
+		return fmt.Errorf("fragment.padding must be between 0 and 16000")
+	}
+	if c.Fragment.Padding > 0 && c.Fingerprint == "" {
+		return fmt.Errorf("fragment.padding requires a fingerprint")
+	}
+	if err := c.UDP.validate(); err != nil {
+		return err
+	}
+	if s := c.SOCKS5; s.HandshakeTimeout < 0 || s.MaxHandshakes < 0 || s.MaxConnsPerSource < 0 {
+		return fmt.Errorf("socks5 limits must not be negative")
+	}
+	if c.Connections < 0 {
+		return fmt.Errorf("connections must not be negative")
+	}
+	if cf := c.Congestion; cf.Interval < 0 || cf.MaxConns < 0 {
+		return fmt.Errorf("congestion.interval and max_conns must not be negative")
+	}
+	if h := c.Health; h.Window < 0 || h.SlowDial < 0 || h.ProbeInterval < 0 {
+		return fmt.Errorf("health durations must not be negative")
+	}
+	if c.Health.Threshold < 0 || c.Health.Threshold > 1 {
+		return fmt.Errorf("health.threshold must be between 0 and 1")
+	}
+	if r := c.Retry; r.MaxAttempts < 0 || r.Backoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("retry.max_attempts, backoff and max_backoff must not be negative")
+	}
+	if k := c.Keepalive; k.Interval < 0 || k.LowPowerInterval < 0 || k.Timeout < 0 {
+		return fmt.Errorf("keepalive intervals must not be negative")
+	}
+	if c.PreferIPv6 && c.IPv4Only {
+		return fmt.Errorf("prefer_ipv6 and ipv4_only are mutually exclusive")
+	}
+	if c.KillSwitch && (c.AllowDirectFallback || len(c.Fallback.Hosts) > 0) {
+		return fmt.Errorf("kill_switch and direct fallback are mutually exclusive")
+	}
+	if c.Fallback.After < 0 || c.Fallback.ProbeInterval < 0 {
+		return fmt.Errorf("fallback.after and fallback.probe_interval must not be negative")
+	}
+
+	if err := validateAPI(c.API.Listen, c.API.Token, c.API.AllowOrigins); err != nil {
+		return err
+	}
+
+	for _, h := range c.Routing.Direct {
+		if h == ProcessPrefix {
+			return fmt.Errorf("routing.direct entry %q needs a process name", h)
+		}
+		if strings.HasPrefix(h, ProcessPrefix) {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(h); strings.Contains(h, "/") && err != nil {
+			return fmt.Errorf("invalid routing.direct entry %q: %v", h, err)
+		}
+	}
+	if err := validateGeoData(c.GeoData); err != nil {
+		return err
+	}
+	outbounds := map[string]bool{}
+	for i, o := range c.Routing.Outbounds {
+		if o.Tag == "" {
+			return fmt.Errorf("routing outbound %d: tag is required", i)
+		}
+		if outbounds[o.Tag] {
+			return fmt.Errorf("duplicate routing outbound %q", o.Tag)
+		}
+		outbounds[o.Tag] = true
+		if err := validateOutboundURL(o.URL); err != nil {
+			return fmt.Errorf("routing outbound %q: %v", o.Tag, err)
+		}
+	}
+	for i, r := range c.Routing.Rules {
+		switch r.Action {
+		case "direct", "tunnel", "interactive", "bulk", "block":
+			if r.Outbound != "" {
+				return fmt.Errorf("routing rule %d: outbound needs action = \"outbound\"", i)
+			}
+		case "outbound":
+			if !outbounds[r.Outbound] {
+				return fmt.Errorf("routing rule %d: no routing outbound tagged %q", i, r.Outbound)
+			}
+		default:
+			return fmt.Errorf("routing rule %d: action must be direct, tunnel, interactive, bulk, outbound or block, not %q", i, r.Action)
+		}
+		if err := validateBlock(r.Block); err != nil {
+			return fmt.Errorf("routing rule %d: %v", i, err)
+		}
+		if err := validateSchedules(r.Schedule); err != nil {
+			return fmt.Errorf("routing rule %d: %v", i, err)
+		}
+	}
+	if _, err := LoadTimezone(c.Timezone); err != nil {
+		return err
+	}
+	if err := validateBlock(c.AdBlock.Block); err != nil {
+		return fmt.Errorf("adblock: %v", err)
+	}
+	if c.PAC.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.PAC.Listen); err != nil {
+			return fmt.Errorf("invalid pac.listen %q: %v", c.PAC.Listen, err)
+		}
+	}
+
+	if m := c.TUN.MTU; m != 0 && (m < 1280 || m > 65535) {
+		return fmt.Errorf("tun.mtu must be between 1280 and 65535")
+	}
+	if m := c.TUN.MSS; m < -1 || (m > 0 && m < 536) {
+		return fmt.Errorf("tun.mss must be -1, 0 or at least 536")
+	}
+	switch c.TUN.Stack {
+	case "", "gvisor", "system":
+	default:
+		return fmt.Errorf("tun.stack %q must be gvisor or system", c.TUN.Stack)
+	}
+	if err := validateTUNAddress("tun.address", c.TUN.Address, false); err != nil {
+		return err
+	}
+	if err := validateTUNAddress("tun.address6", c.TUN.Address6, true); err != nil {
+		return err
+	}
+
+	for _, src := range c.LANSources {
+		if _, _, err := net.ParseCIDR(src); err != nil && net.ParseIP(src) == nil {
+			return fmt.Errorf("invalid lan_sources entry %q: want an IP or CIDR", src)
+		}
+	}
+
+	if len(c.Inbounds) == 0 {
+		return fmt.Errorf("at least one inbound is required")
+	}
+	names := make(map[string]bool)
+	for i, in := range c.Inbounds {
+		if _, _, err := net.SplitHostPort(in.LocalAddr); err != nil {
+			return fmt.Errorf("inbound %d: invalid local_addr %q: %v", i, in.LocalAddr, err)
+		}
+		if names[in.Name()] {
+			return fmt.Errorf("inbound %d: duplicate tag %q", i, in.Name())
+		}
+		names[in.Name()] = true
+		switch in.Sniff {
+		case "", "route", "override":
+			if in.Sniff != "" && in.Protocol != protocol.ProtocolSOCKS5 {
+				return fmt.Errorf("inbound %d: sniff needs protocol socks5", i)
+			}
+		default:
+			return fmt.Errorf("inbound %d: invalid sniff %q: want route or override", i, in.Sniff)
+		}
+		switch in.Protocol {
+		case protocol.ProtocolSOCKS5:
+		case protocol.ProtocolSSH:
+			if in.AuthorizedKeys != "" && in.HostKey == "" {
+				return fmt.Errorf("inbound %d: ssh server mode requires host_key", i)
+			}
+		case protocol.ProtocolShadowsocks:
+			if in.Auth == "" {
+				return fmt.Errorf("inbound %d: shadowsocks requires auth (method:password)", i)
+			}
+		default:
+			return fmt.Errorf("inbound %d: unknown protocol %q", i, in.Protocol)
+		}
+	}
+	for _, tag := range c.Fallback.Inbounds {
+		if !names[tag] {
+			return fmt.Errorf("fallback.inbounds: no inbound tagged %q", tag)
+		}
+	}
+	if c.PAC.Inbound != "" && !names[c.PAC.Inbound] {
+		return fmt.Errorf("pac.inbound: no inbound tagged %q", c.PAC.Inbound)
+	}
+	return nil
+}
+
+func (d ClientDNS) validate() error {
+	for _, s := range d.Servers {
+		if err := validateDNSServer(s); err != nil {
+			return err
+		}
+	}
+	for i, r := range d.Rules {
+		if (len(r.Domains) == 0 && r.Routing == "") || len(r.Servers) == 0 {
+			return fmt.Errorf("dns rule %d needs domains or routing, and servers", i)
+		}
+		switch r.Routing {
+		case "", "direct", "tunnel":
+		default:
+			return fmt.Errorf("dns rule %d: unknown routing %q", i, r.Routing)
+		}
+		for _, s := range r.Servers {
+			if s == "system" && len(r.Servers) == 1 && !r.Tunnel {
+				continue
+			}
+			if err := validateDNSServer(s); err != nil {
+				return fmt.Errorf("dns rule %d: %v", i, err)
+			}
+			if r.Tunnel && !strings.HasPrefix(s, "tcp://") && !strings.HasPrefix(s, "tls://") && !strings.HasPrefix(s, "https://") {
+				return fmt.Errorf("dns rule %d: tunnel needs tcp://, tls:// or https:// servers, not %q", i, s)
+			}
+		}
+	}
+	for name, ips := range d.Hosts {
+		if name == "" || len(ips) == 0 {
+			return fmt.Errorf("dns.hosts entries need a name and addresses")
+		}
+		for _, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("dns.hosts %q: invalid IP %q", name, ip)
+			}
+		}
+	}
+	if d.CacheSize < -1 || d.MinTTL < 0 || d.MaxTTL < 0 || d.Timeout < 0 {
+		return fmt.Errorf("dns settings must not be negative (cache_size may be -1)")
+	}
+	if d.MaxTTL > 0 && d.MaxTTL < d.MinTTL {
+		return fmt.Errorf("dns.max_ttl must not be less than dns.min_ttl")
+	}
+	return nil
+}
+
+func validateGeoData(lists []GeoData) error {
+	names := map[string]bool{}
+	for _, g := range lists {
+		if err := g.validate(); err != nil {
+			return err
+		}
+		if names[g.Name] {
+			return fmt.Errorf("duplicate geodata name %q", g.Name)
+		}
+		names[g.Name] = true
+	}
+	return nil
+}
+
+func (g GeoData) validate() error {
+	if g.Name == "" || strings.ContainsAny(g.Name, ": ") {
+		return fmt.Errorf("geodata needs a name without spaces or colons, got %q", g.Name)
+	}
+	for i, u := range []string{g.URL, g.SHA256URL, g.SignatureURL} {
+		if i > 0 && u == "" {
+			continue // Optional
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("geodata %s: invalid url %q", g.Name, u)
+		}
+	}
+	switch g.Format {
+	case "", "list", "clash", "v2ray", "hosts", "adguard":
+	default:
+		return fmt.Errorf("geodata %s: format must be list, clash, v2ray, hosts or adguard, not %q", g.Name, g.Format)
+	}
+	if (g.SignatureURL == "") != (g.PublicKey == "") {
+		return fmt.Errorf("geodata %s: signature_url and public_key go together", g.Name)
+	}
+	if g.Interval < 0 || g.Interval > 0 && g.Interval < time.Minute {
+		return fmt.Errorf("geodata %s: interval must be at least 1m", g.Name)
+	}
+	return nil
+}
+
+// validateDNSServer checks a DNS server: "ip[:port]", or a udp://, tcp://,
+// tls://, quic:// or https:// URL. Plain servers need an IP, as there is
+// nothing to resolve their name with.
+func validateDNSServer(spec string) error {
+	s := spec
+	if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid dns server %q: %v", spec, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if net.ParseIP(u.Hostname()) == nil {
+			return fmt.Errorf("invalid dns server %q: want an IP address", spec)
+		}
+	case "tls", "quic", "https":
+		if u.Hostname() == "" {
+			return fmt.Errorf("dns server %q has no host", spec)
+		}
+	default:
+		return fmt.Errorf("unsupported dns server scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// validateConnection checks the settings used to reach a server; chain hops
+// share them with the exit.
+func (c *ClientConfig) validateConnection() error {
+	if c.RemoteAddr == "" {
+		return fmt.Errorf("remote_addr is required")
+	}
+	if _, _, err := net.SplitHostPort(c.RemoteAddr); err != nil {
+		return fmt.Errorf("invalid remote_addr %q: %v", c.RemoteAddr, err)
+	}
+	if c.DialAddr != "" {
+		if _, _, err := net.SplitHostPort(c.DialAddr); err != nil {
+			return fmt.Errorf("invalid dial_addr %q: %v", c.DialAddr, err)
+		}
+	}
+
+	switch c.TLSMode {
+	case "", "system", "insecure":
+	default:
+		return fmt.Errorf("unknown tls_mode %q", c.TLSMode)
+	}
+
+	switch c.Fingerprint {
+	case "", "chrome", "firefox", "safari", "random":
+	default:
+		return fmt.Errorf("unknown fingerprint %q", c.Fingerprint)
+	}
+
+	if err := c.HTTP.validate(); err != nil {
+		return err
+	}
+	if c.MetadataFrame && c.HTTP.Secret == "" && c.AuthToken == "" {
+		return fmt.Errorf("metadata_frame requires http.secret or auth_token as the encryption key")
+	}
+	return nil
+}
+
+// Validate checks the server configuration for structural errors.
+func (c *ServerConfig) Validate() error {
+	if _, err := loglevel.Parse(c.LogLevel); err != nil {
+		return err
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("invalid listen_addr %q: %v", c.ListenAddr, err)
+	}
+	for _, addr := range c.Security.ReverseListen {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "0" {
+			return fmt.Errorf("invalid reverse_listen address %q: want host:port", addr)
+		}
+	}
+	if len(c.Security.AuthorizedClientKeys) > 0 && c.Security.PrivateKeyPath == "" {
+		return fmt.Errorf("authorized_clients requires private_key (mTLS needs a server key)")
+	}
+	switch c.UDP.NAT {
+	case "", "full-cone", "restricted", "symmetric":
+	default:
+		return fmt.Errorf("unknown udp.nat %q", c.UDP.NAT)
+	}
+	if err := c.UDP.validate(); err != nil {
+		return err
+	}
+	switch c.Dial.Family {
+	case "", "auto", "prefer-ipv4", "prefer-ipv6", "ipv4-only", "ipv6-only":
+	default:
+		return fmt.Errorf("unknown dial.family %q", c.Dial.Family)
+	}
+	if c.Dial.AttemptDelay < 0 || c.Dial.Timeout < 0 || c.Dial.Retries < 0 || c.Dial.RetryDelay < 0 {
+		return fmt.Errorf("dial settings must not be negative")
+	}
+	if c.FWMark < 0 || int64(c.FWMark) > math.MaxUint32 {
+		return fmt.Errorf("fwmark %d out of range", c.FWMark)
+	}
+	if l := c.Limits; l.MaxStreamsPerConn < 0 || l.StreamRate < -1 || l.MaxStreamsPerClient < 0 || l.HandshakesPerMinute < 0 || l.StreamBuffer < 0 || l.ConnBuffer < 0 ||
+		l.MaxStreams < 0 || l.MaxGoroutines < 0 || l.MemoryLimit < 0 {
+		return fmt.Errorf("invalid limits: values must not be negative (stream_rate may be -1)")
+	}
+	if c.Limits.StreamBuffer > math.MaxInt32 || c.Limits.ConnBuffer > math.MaxInt32 {
+		return fmt.Errorf("limits.stream_buffer and conn_buffer must not exceed %d bytes", math.MaxInt32)
+	}
+	for _, p := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return fmt.Errorf("invalid trusted_proxies entry %q: want an IP or CIDR", p)
+		}
+	}
+	if c.Streams.IdleTimeout < 0 || c.Streams.MaxLifetime < 0 {
+		return fmt.Errorf("streams limits must not be negative")
+	}
+	for i, n := range c.Notices {
+		switch n.Kind {
+		case "", "info", "quota", "maintenance", "shutdown", "migrate", "disconnect":
+		default:
+			return fmt.Errorf("notice %d: unknown kind %q", i, n.Kind)
+		}
+		if n.Kind == "migrate" {
+			if _, _, err := net.SplitHostPort(n.Server); err != nil {
+				return fmt.Errorf("notice %d: migrate needs server as host:port: %v", i, err)
+			}
+		}
+	}
+	if h := c.Hints; h.KeepaliveInterval < 0 || h.Padding < 0 || h.Padding > 16000 {
+		return fmt.Errorf("hints.keepalive_interval must not be negative and hints.padding must be between 0 and 16000")
+	}
+	if len(c.Hints.ALPN) > 0 && !slices.Contains(c.Hints.ALPN, "h2") {
+		return fmt.Errorf("hints.alpn must include \"h2\"")
+	}
+	if c.ShutdownGrace < 0 {
+		return fmt.Errorf("shutdown_grace must not be negative")
+	}
+	if err := validateAPI(c.API.Listen, c.API.Token, c.API.AllowOrigins); err != nil {
+		return err
+	}
+	if c.FlowExport.Collector != "" {
+		if _, _, err := net.SplitHostPort(c.FlowExport.Collector); err != nil {
+			return fmt.Errorf("invalid flow_export.collector %q: %v", c.FlowExport.Collector, err)
+		}
+	}
+	if c.FlowExport.ActiveTimeout < 0 {
+		return fmt.Errorf("flow_export.active_timeout must not be negative")
+	}
+	if h := c.Hardening; h.Group != "" && h.User == "" {
+		return fmt.Errorf("hardening.group needs hardening.user")
+	}
+	if h := c.Hardening; h.Chroot != "" && !filepath.IsAbs(h.Chroot) {
+		return fmt.Errorf("hardening.chroot must be an absolute path")
+	}
+	for _, u := range c.DNS.Upstreams {
+		if err := validateDNSServer(u); err != nil {
+			return fmt.Errorf("invalid dns upstream: %v", err)
+		}
+	}
+	if c.DNS.CacheSize < -1 || c.DNS.Timeout < 0 {
+		return fmt.Errorf("invalid dns cache_size or timeout")
+	}
+	for _, a := range c.Quota.Alerts {
+		if a < 1 || a > 100 {
+			return fmt.Errorf("quota.alerts must be percentages between 1 and 100")
+		}
+	}
+	if c.Quota.Webhook != "" {
+		if u, err := url.Parse(c.Quota.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid quota.webhook %q: want an http or https URL", c.Quota.Webhook)
+		}
+	}
+	if c.UserDB != "" && len(c.Users) > 0 {
+		return fmt.Errorf("user_db replaces [[users]]; move them to the database")
+	}
+	if err := validateUsers(c.Users, c.Security.PrivateKeyPath != ""); err != nil {
+		return err
+	}
+	hosts := map[string]bool{}
+	for i, v := range c.VHosts {
+		if len(v.ServerNames) == 0 {
+			return fmt.Errorf("vhost %d: server_names is required", i)
+		}
+		for _, name := range v.ServerNames {
+			name = strings.ToLower(name)
+			if name == "" || strings.ContainsAny(name, ":/ ") || strings.Contains(name[1:], "*") || (name[0] == '*' && !strings.HasPrefix(name, "*.")) {
+				return fmt.Errorf("vhost %d: invalid server name %q", i, name)
+			}
+			if hosts[name] {
+				return fmt.Errorf("vhost %d: server name %q is already used", i, name)
+			}
+			hosts[name] = true
+		}
+		if (v.PrivateKeyPath != "") != (c.Security.PrivateKeyPath != "") {
+			return fmt.Errorf("vhost %s: private_key must be set on every endpoint or on none (TLS and h2c cannot share a listener)", v.ServerNames[0])
+		}
+		if len(v.AuthorizedClientKeys) > 0 && v.PrivateKeyPath == "" {
+			return fmt.Errorf("vhost %s: authorized_clients requires private_key (mTLS needs a server key)", v.ServerNames[0])
+		}
+		if err := validateUsers(v.Users, v.PrivateKeyPath != ""); err != nil {
+			return fmt.Errorf("vhost %s: %v", v.ServerNames[0], err)
+		}
+	}
+	if err := validateGeoData(c.GeoData); err != nil {
+		return err
+	}
+	for i, r := range c.ACL {
+		switch r.Action {
+		case "", "block":
+		case "throttle":
+			if r.Rate <= 0 {
+				return fmt.Errorf("acl rule %d: throttle needs a rate", i)
+			}
+		default:
+			return fmt.Errorf("acl rule %d: action must be block or throttle, not %q", i, r.Action)
+		}
+		if err := validateBlock(r.Block); err != nil {
+			return fmt.Errorf("acl rule %d: %v", i, err)
+		}
+		if err := validateSchedules(r.Schedule); err != nil {
+			return fmt.Errorf("acl rule %d: %v", i, err)
+		}
+	}
+	if _, err := LoadTimezone(c.Timezone); err != nil {
+		return err
+	}
+	if c.Fallback.Backend != "" && c.Fallback.Root != "" {
+		return fmt.Errorf("fallback.backend and fallback.root are mutually exclusive")
+	}
+	if c.Fallback.Backend != "" {
+		if _, _, err := net.SplitHostPort(c.Fallback.Backend); err != nil {
+			return fmt.Errorf("invalid fallback.backend %q: %v", c.Fallback.Backend, err)
+		}
+	}
+	if c.OutboundProxy != "" {
+		u, err := url.Parse(c.OutboundProxy)
+		if err != nil {
+			return fmt.Errorf("invalid outbound_proxy: %v", err)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h", "http":
+			if u.Host == "" {
+				return fmt.Errorf("outbound_proxy %q has no host", c.OutboundProxy)
+			}
+		case "phoenix":
+			if u.Path == "" {
+				return fmt.Errorf("outbound_proxy %q has no config path", c.OutboundProxy)
+			}
+		default:
+			return fmt.Errorf("unsupported outbound_proxy scheme %q", u.Scheme)
+		}
+	}
+	return c.HTTP.validate()
+}
+
+func (p *HTTPProfile) validate() error {
+	switch p.Method {
+	case "", "POST", "PUT", "PATCH":
+	default:
+		return fmt.Errorf("http.method %q must be POST, PUT or PATCH (the tunnel needs a request body)", p.Method)
+	}
+	if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("http.path %q must start with /", p.Path)
+	}
+	return nil
+}
+
+func (q UserQuota) validate() error {
+	if q.Bytes < 0 || q.Throttle < 0 {
+		return fmt.Errorf("quota.bytes and quota.throttle must not be negative")
+	}
+	switch q.Period {
+	case "", "daily", "weekly", "monthly":
+	default:
+		return fmt.Errorf("unknown quota.period %q (want daily, weekly or monthly)", q.Period)
+	}
+	if q.ResetDay < 0 || q.ResetDay > 28 {
+		return fmt.Errorf("quota.reset_day must be between 1 and 28")
+	}
+	return nil
+}
+
+// validate checks the [log] settings shared by client and server configs.
+func (l LogOutput) validate() error {
+	if l.MaxSize < 0 || l.RotateEvery < 0 || l.MaxBackups < 0 || l.MaxAge < 0 {
+		return fmt.Errorf("log limits must not be negative")
+	}
+	switch l.Sink {
+	case "", "syslog", "logcat":
+	default:
+		return fmt.Errorf("unknown log.sink %q (want syslog or logcat)", l.Sink)
+	}
+	if l.SyslogAddr != "" {
+		if l.Sink != "syslog" {
+			return fmt.Errorf("log.syslog_addr requires sink = \"syslog\"")
+		}
+		if _, _, err := net.SplitHostPort(l.SyslogAddr); err != nil {
+			return fmt.Errorf("invalid log.syslog_addr %q: %v", l.SyslogAddr, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the [udp] limits shared by client and server configs.
+func (u UDPConfig) validate() error {
+	if u.IdleTimeout < 0 || u.MaxSessions < 0 || u.MaxPeers < 0 {
+		return fmt.Errorf("udp limits must not be negative")
+	}
+	if u.QueueDepth < 0 || u.SocketBuffer < 0 {
+		return fmt.Errorf("udp limits must not be negative")
+	}
+	if u.MaxPacketSize < 0 || u.MaxPacketSize > 65535 {
+		return fmt.Errorf("udp.max_packet_size must be between 0 and 65535")
+	}
+	return nil
+}
+
+// validateTUNAddress checks a tun.address or tun.address6 value: an
+// address of the right family with its prefix, leaving room for the
+// system stack's NAT address right after it.
+func validateTUNAddress(key, value string, v6 bool) error {
+	if value == "" {
+		return nil
+	}
+	p, err := netip.ParsePrefix(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: want an address with prefix, e.g. 10.0.0.1/30", key, value)
+	}
+	if p.Addr().Is6() != v6 {
+		return fmt.Errorf("%s %q has the wrong address family", key, value)
+	}
+	if !p.Contains(p.Addr().Next()) {
+		return fmt.Errorf("%s %q leaves no host address after it in the prefix", key, value)
+	}
+	return nil
+}
+
+// validateUsers checks a user table; hasKey reports whether its endpoint
+// has a TLS key, which users with a public_key need.
+func validateUsers(users []User, hasKey bool) error {
+	names := map[string]bool{}
+	for i, u := range users {
+		if u.Name == "" {
+			return fmt.Errorf("user %d: name is required", i)
+		}
+		if names[u.Name] {
+			return fmt.Errorf("duplicate user %q", u.Name)
+		}
+		names[u.Name] = true
+		if err := u.Validate(); err != nil {
+			return err
+		}
+		if u.PublicKey != "" && !hasKey {
+			return fmt.Errorf("user %q: public_key requires private_key (mTLS needs a server key)", u.Name)
+		}
+	}
+	return nil
+}
+
+// Validate checks one user table entry on its own.
+func (u User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	if u.Token == "" && u.PublicKey == "" {
+		return fmt.Errorf("user %q: token or public_key is required", u.Name)
+	}
+	if err := u.Quota.validate(); err != nil {
+		return fmt.Errorf("user %q: %v", u.Name, err)
+	}
+	switch u.Record {
+	case "", "metadata", "content":
+	default:
+		return fmt.Errorf("user %q: record must be metadata or content, not %q", u.Name, u.Record)
+	}
+	return nil
+}
+
+// validateOutboundURL checks the URL of a routing outbound.
+func validateOutboundURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+		if u.Host == "" {
+			return fmt.Errorf("url %q has no host", raw)
+		}
+	case "wireguard":
+		if u.Path == "" {
+			return fmt.Errorf("url %q has no config path", raw)
+		}
+	case "tor":
+	case "ssh":
+		q := u.Query()
+		switch {
+		case u.Host == "" || u.User.Username() == "":
+			return fmt.Errorf("url %q needs ssh://user@host", raw)
+		case q.Get("host_key") == "" && q.Get("known_hosts") == "":
+			return fmt.Errorf("url %q needs host_key or known_hosts", raw)
+		}
+	default:
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// validateBlock checks the block behavior of a rule.
+func validateBlock(block string) error {
+	switch block {
+	case "", "reset", "drop", "http":
+		return nil
+	}
+	return fmt.Errorf("block must be reset, drop or http, not %q", block)
+}
+
+// validateAPI checks an [api] section: APIs others can reach need a token,
+// as do APIs any web page may call.
+func validateAPI(listen, token string, origins []string) error {
+	if listen == "" {
+		return nil
+	}
+	if path, ok := strings.CutPrefix(listen, UnixPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("api.listen %q needs a socket path", listen)
+		}
+	} else {
+		host, _, err := net.SplitHostPort(listen)
+		if err != nil {
+			return fmt.Errorf("invalid api.listen %q: %v", listen, err)
+		}
+		if ip := net.ParseIP(host); token == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("api.listen %q is not loopback: set api.token", listen)
+		}
+	}
+	for _, o := range origins {
+		if o == "*" {
+			if token == "" {
+				return fmt.Errorf("api.allow_origins \"*\" needs api.token")
+			}
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid api.allow_origins entry %q: want scheme://host[:port]", o)
+		}
+	}
+	return nil
+}
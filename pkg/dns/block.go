@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// QueryName returns the name asked about in a wire-format query, lowercase
+// and without the trailing dot.
+func QueryName(query []byte) (string, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return "", err
+	}
+	if h.Response {
+		return "", fmt.Errorf("not a dns query")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSuffix(q.Name.String(), ".")), nil
+}
+
+// NXDomain returns the response to query saying its name doesn't exist.
+func NXDomain(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 h.ID,
+			Response:           true,
+			OpCode:             h.OpCode,
+			RecursionDesired:   h.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              dnsmessage.RCodeNameError,
+		},
+		Questions: []dnsmessage.Question{q},
+	}
+	return resp.Pack()
+}
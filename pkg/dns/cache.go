@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// maxTTL caps how long any response is cached.
+	maxTTL = time.Hour
+
+	// maxNegativeTTL caps caching of NXDOMAIN and empty answers.
+	maxNegativeTTL = 5 * time.Minute
+)
+
+// Cache stores DNS responses by question until their TTL runs out.
+type Cache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[cacheKey]*cacheEntry
+
+	hits, misses uint64 // Atomic
+}
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type cacheEntry struct {
+	msg     dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+}
+
+// NewCache returns a cache holding at most max responses.
+func NewCache(max int) *Cache {
+	return &Cache{max: max, entries: map[cacheKey]*cacheEntry{}}
+}
+
+func keyOf(q dnsmessage.Question) cacheKey {
+	return cacheKey{name: strings.ToLower(q.Name.String()), qtype: q.Type, class: q.Class}
+}
+
+// Get returns a copy of the cached response to q with TTLs reduced by the
+// time it has been cached.
+func (c *Cache) Get(q dnsmessage.Question) (*dnsmessage.Message, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[keyOf(q)]
+	if ok && !now.Before(e.expires) {
+		delete(c.entries, keyOf(q))
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+
+	age := uint32(now.Sub(e.stored) / time.Second)
+	msg := e.msg
+	msg.Answers = agedCopy(e.msg.Answers, age)
+	msg.Authorities = agedCopy(e.msg.Authorities, age)
+	msg.Additionals = agedCopy(e.msg.Additionals, age)
+	return &msg, true
+}
+
+func agedCopy(rrs []dnsmessage.Resource, age uint32) []dnsmessage.Resource {
+	out := make([]dnsmessage.Resource, len(rrs))
+	copy(out, rrs)
+	for i := range out {
+		if out[i].Header.Type == dnsmessage.TypeOPT {
+			continue // OPT "TTL" holds EDNS flags
+		}
+		if out[i].Header.TTL > age {
+			out[i].Header.TTL -= age
+		} else {
+			out[i].Header.TTL = 0
+		}
+	}
+	return out
+}
+
+// Put caches msg as the response to its question, unless it is an error,
+// truncated, or carries no TTL.
+func (c *Cache) Put(msg *dnsmessage.Message) {
+	if len(msg.Questions) != 1 || msg.Truncated {
+		return
+	}
+	ttl, ok := cacheTTL(msg)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.max {
+		c.evictLocked(now)
+	}
+	c.entries[keyOf(msg.Questions[0])] = &cacheEntry{msg: *msg, stored: now, expires: now.Add(ttl)}
+}
+
+// evictLocked drops expired entries, or an arbitrary one if none expired.
+func (c *Cache) evictLocked(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.max {
+			return
+		}
+		delete(c.entries, k)
+	}
+}
+
+// cacheTTL is the lowest TTL of the answer records, or for NXDOMAIN and
+// empty answers the SOA minimum (RFC 2308).
+func cacheTTL(msg *dnsmessage.Message) (time.Duration, bool) {
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return 0, false
+	}
+	if msg.RCode == dnsmessage.RCodeSuccess && len(msg.Answers) > 0 {
+		min := msg.Answers[0].Header.TTL
+		for _, rr := range msg.Answers[1:] {
+			if rr.Header.TTL < min {
+				min = rr.Header.TTL
+			}
+		}
+		ttl := time.Duration(min) * time.Second
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+		return ttl, ttl > 0
+	}
+	for _, rr := range msg.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			ttl := time.Duration(soa.MinTTL) * time.Second
+			if h := time.Duration(rr.Header.TTL) * time.Second; h < ttl {
+				ttl = h
+			}
+			if ttl > maxNegativeTTL {
+				ttl = maxNegativeTTL
+			}
+			return ttl, ttl > 0
+		}
+	}
+	return 0, false
+}
+
+// Stats returns the number of cache hits and misses.
+func (c *Cache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCache(t *testing.T) {
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName("Example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	resp := &dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true},
+		Questions: []dnsmessage.Question{q},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}},
+	}
+
+	c := NewCache(2)
+	c.Put(resp)
+	q.Name = dnsmessage.MustNewName("example.com.")
+	got, ok := c.Get(q)
+	if !ok {
+		t.Fatalf("Expected cached answer for %s", q.Name)
+	}
+	if ttl := got.Answers[0].Header.TTL; ttl != 300 {
+		t.Errorf("Expected TTL 300, got %d", ttl)
+	}
+
+	servfail := &dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeServerFailure},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("fail.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	c.Put(servfail)
+	if _, ok := c.Get(servfail.Questions[0]); ok {
+		t.Errorf("Expected SERVFAIL not to be cached")
+	}
+
+	nx := &dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeNameError},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("nx.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		Authorities: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+			Body:   &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns.example."), MBox: dnsmessage.MustNewName("admin.example."), MinTTL: 60},
+		}},
+	}
+	if ttl, ok := cacheTTL(nx); !ok || ttl.Seconds() != 60 {
+		t.Errorf("Expected NXDOMAIN to be cached for the SOA minimum (60s), got %v %v", ttl, ok)
+	}
+}
@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Hosts are static addresses by lowercase name, taking precedence over
+// the resolvers of a Lookup.
+type Hosts map[string][]net.IP
+
+// Add maps name to ip, after the addresses it already has.
+func (h Hosts) Add(name string, ip net.IP) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	h[name] = append(h[name], ip)
+}
+
+// ParseHosts reads a hosts file: an IP and its names per line, "#" starting
+// a comment.
+func ParseHosts(r io.Reader) (Hosts, error) {
+	hosts := Hosts{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Drop an IPv6 zone ("fe80::1%lo0"); it means nothing to a dial.
+		addr, _, _ := strings.Cut(fields[0], "%")
+		ip := net.ParseIP(addr)
+		if ip == nil || len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected an IP and host names", n)
+		}
+		for _, name := range fields[1:] {
+			hosts.Add(name, ip)
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// LoadHosts reads the hosts file at path; "system" is the system's.
+func LoadHosts(path string) (Hosts, error) {
+	if path == "system" {
+		path = systemHostsPath()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hosts, err := ParseHosts(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return hosts, nil
+}
+
+func systemHostsPath() string {
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
@@ -0,0 +1,245 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Lookup resolves host names for the client's own dials and keeps the
+// addresses for their TTL, clamped to [minTTL, maxTTL]. A Resolver is
+// queried directly and tells the TTLs; the system resolver's answers carry
+// none and are kept for minTTL.
+type Lookup struct {
+	resolver       *Resolver // nil = the system resolver
+	rules          []LookupRule
+	minTTL, maxTTL time.Duration
+	max            int
+
+	mu      sync.Mutex
+	entries map[string]*lookupEntry
+	hosts   Hosts
+
+	hits, misses uint64 // Atomic
+}
+
+type lookupEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// LookupRule sends the lookups of names Match accepts to Resolver (nil =
+// the system resolver).
+type LookupRule struct {
+	Match    func(name string) bool
+	Resolver *Resolver
+}
+
+// NewLookup returns a lookup cache of at most size names (0 = resolve
+// without caching). Names go to the first matching rule's resolver, others
+// to resolver (nil = the system resolver). The resolvers should not cache
+// themselves.
+func NewLookup(resolver *Resolver, rules []LookupRule, size int, minTTL, maxTTL time.Duration) *Lookup {
+	return &Lookup{resolver: resolver, rules: rules, minTTL: minTTL, maxTTL: maxTTL, max: size, entries: map[string]*lookupEntry{}}
+}
+
+// SetHosts sets the static addresses, which are answered before the cache
+// and the resolvers.
+func (l *Lookup) SetHosts(hosts Hosts) {
+	l.mu.Lock()
+	l.hosts = hosts
+	l.mu.Unlock()
+}
+
+// Static returns the static addresses of host, nil if it has none.
+func (l *Lookup) Static(host string) []net.IP {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hosts[name]
+}
+
+// LookupIP returns the static addresses of host, or those resolved, from
+// the cache while they are fresh.
+func (l *Lookup) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	now := time.Now()
+	l.mu.Lock()
+	if ips, ok := l.hosts[name]; ok {
+		l.mu.Unlock()
+		return ips, nil
+	}
+	e, ok := l.entries[name]
+	if ok && !now.Before(e.expires) {
+		delete(l.entries, name)
+		ok = false
+	}
+	l.mu.Unlock()
+	if ok {
+		atomic.AddUint64(&l.hits, 1)
+		return e.ips, nil
+	}
+	atomic.AddUint64(&l.misses, 1)
+
+	ips, ttl, err := l.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if l.max <= 0 {
+		return ips, nil
+	}
+	ttl = min(max(ttl, l.minTTL), l.maxTTL)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) >= l.max {
+		l.evictLocked(now)
+	}
+	l.entries[name] = &lookupEntry{ips: ips, expires: now.Add(ttl)}
+	return ips, nil
+}
+
+// resolve looks name up, returning the lowest TTL of the answers (0 from
+// the system resolver).
+func (l *Lookup) resolve(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	resolver := l.resolver
+	for _, r := range l.rules {
+		if r.Match(name) {
+			resolver = r.Resolver
+			break
+		}
+	}
+	if resolver == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		ips := make([]net.IP, len(addrs))
+		for i, a := range addrs {
+			ips[i] = a.IP
+		}
+		return ips, 0, nil
+	}
+
+	type answer struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	answers := make(chan answer, 2)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA} {
+		go func() {
+			ips, ttl, err := query(resolver, name, qtype)
+			answers <- answer{ips, ttl, err}
+		}()
+	}
+	var ips []net.IP
+	var ttl time.Duration
+	var firstErr error
+	for range 2 {
+		a := <-answers
+		if a.err != nil {
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			continue
+		}
+		if len(a.ips) > 0 && (ttl == 0 || a.ttl < ttl) {
+			ttl = a.ttl
+		}
+		// IPv6 first, as the system resolver usually orders them.
+		if len(a.ips) > 0 && a.ips[0].To4() == nil {
+			ips = append(a.ips, ips...)
+		} else {
+			ips = append(ips, a.ips...)
+		}
+	}
+	if len(ips) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no address for %s", name)
+		}
+		return nil, 0, firstErr
+	}
+	return ips, ttl, nil
+}
+
+// query asks r for the qtype records of name.
+func query(r *Resolver, name string, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(time.Now().UnixNano()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := r.Exchange(query, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := msg.Unpack(resp); err != nil {
+		return nil, 0, fmt.Errorf("invalid dns response: %v", err)
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("lookup %s: %v", name, msg.RCode)
+	}
+	var ips []net.IP
+	var ttl uint32
+	for _, rr := range msg.Answers {
+		var ip net.IP
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(b.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(b.AAAA[:])
+		default:
+			continue // CNAMEs in front of the address records
+		}
+		if len(ips) == 0 || rr.Header.TTL < ttl {
+			ttl = rr.Header.TTL
+		}
+		ips = append(ips, ip)
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// evictLocked drops expired entries, or an arbitrary one if none expired.
+func (l *Lookup) evictLocked(now time.Time) {
+	for k, e := range l.entries {
+		if !now.Before(e.expires) {
+			delete(l.entries, k)
+		}
+	}
+	for k := range l.entries {
+		if len(l.entries) < l.max {
+			return
+		}
+		delete(l.entries, k)
+	}
+}
+
+// Flush empties the cache and returns how many names it held.
+func (l *Lookup) Flush() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(l.entries)
+	clear(l.entries)
+	return n
+}
+
+// Stats returns the number of cache hits and misses and the names cached.
+func (l *Lookup) Stats() (hits, misses uint64, size int) {
+	l.mu.Lock()
+	size = len(l.entries)
+	l.mu.Unlock()
+	return atomic.LoadUint64(&l.hits), atomic.LoadUint64(&l.misses), size
+}
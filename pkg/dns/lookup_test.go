@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answer responds to a query with ip (TTL ttl) for A questions and no
+// records for others.
+func answer(query []byte, ip [4]byte, ttl uint32) []byte {
+	var msg dnsmessage.Message
+	if msg.Unpack(query) != nil {
+		return nil
+	}
+	q := msg.Questions[0]
+	msg.Header.Response = true
+	if q.Type == dnsmessage.TypeA {
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: ip},
+		}}
+	}
+	resp, _ := msg.Pack()
+	return resp
+}
+
+// fakeUpstream serves answer over UDP, counting the queries.
+func fakeUpstream(t *testing.T, ip [4]byte, ttl uint32) (*Resolver, *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			conn.WriteTo(answer(buf[:n], ip, ttl), addr)
+		}
+	}()
+	u, err := ParseUpstream(conn.LocalAddr().String(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse upstream: %v", err)
+	}
+	return NewResolver([]Upstream{u}, 0, time.Second), &queries
+}
+
+func TestLookup(t *testing.T) {
+	r, queries := fakeUpstream(t, [4]byte{192, 0, 2, 1}, 300)
+	l := NewLookup(r, nil, 16, time.Second, time.Hour)
+
+	for range 2 {
+		ips, err := l.LookupIP(context.Background(), "Example.com")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+			t.Fatalf("Expected 192.0.2.1, got %v", ips)
+		}
+	}
+	if n := queries.Load(); n != 2 {
+		t.Errorf("Expected one A and one AAAA query, got %d queries", n)
+	}
+	if hits, misses, size := l.Stats(); hits != 1 || misses != 1 || size != 1 {
+		t.Errorf("Expected 1 hit, 1 miss, 1 name; got %d, %d, %d", hits, misses, size)
+	}
+	if n := l.Flush(); n != 1 {
+		t.Errorf("Expected 1 name flushed, got %d", n)
+	}
+	l.LookupIP(context.Background(), "example.com")
+	if n := queries.Load(); n != 4 {
+		t.Errorf("Expected a flushed name to be queried again, got %d queries", n)
+	}
+}
+
+func TestLookupMaxTTL(t *testing.T) {
+	r, queries := fakeUpstream(t, [4]byte{192, 0, 2, 1}, 300)
+	l := NewLookup(r, nil, 16, 0, time.Millisecond)
+	l.LookupIP(context.Background(), "example.com")
+	time.Sleep(5 * time.Millisecond)
+	l.LookupIP(context.Background(), "example.com")
+	if n := queries.Load(); n != 4 {
+		t.Errorf("Expected max_ttl to expire the answer, got %d queries", n)
+	}
+}
+
+func TestLookupRules(t *testing.T) {
+	def, _ := fakeUpstream(t, [4]byte{192, 0, 2, 1}, 300)
+	domestic, _ := fakeUpstream(t, [4]byte{198, 51, 100, 1}, 300)
+	ir := func(name string) bool { return name == "ir" || strings.HasSuffix(name, ".ir") }
+	l := NewLookup(def, []LookupRule{{Match: ir, Resolver: domestic}}, 16, time.Second, time.Hour)
+
+	for host, want := range map[string]string{"Shop.Example.IR": "198.51.100.1", "example.com": "192.0.2.1", "iran.com": "192.0.2.1"} {
+		ips, err := l.LookupIP(context.Background(), host)
+		if err != nil {
+			t.Fatalf("Lookup of %s failed: %v", host, err)
+		}
+		if !ips[0].Equal(net.ParseIP(want)) {
+			t.Errorf("Expected %s for %s, got %v", want, host, ips)
+		}
+	}
+}
+
+func TestLookupHosts(t *testing.T) {
+	r, queries := fakeUpstream(t, [4]byte{192, 0, 2, 1}, 300)
+	hosts, err := ParseHosts(strings.NewReader("# lab\n10.0.0.5  nas.lab NAS2.lab.  # storage\n\nfe80::1%lo0 router.lab\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse hosts: %v", err)
+	}
+	l := NewLookup(r, nil, 16, time.Second, time.Hour)
+	l.SetHosts(hosts)
+
+	for host, want := range map[string]string{"nas.lab": "10.0.0.5", "nas2.lab": "10.0.0.5", "Router.Lab": "fe80::1", "example.com": "192.0.2.1"} {
+		ips, err := l.LookupIP(context.Background(), host)
+		if err != nil {
+			t.Fatalf("Lookup of %s failed: %v", host, err)
+		}
+		if !ips[0].Equal(net.ParseIP(want)) {
+			t.Errorf("Expected %s for %s, got %v", want, host, ips)
+		}
+	}
+	if n := queries.Load(); n != 2 {
+		t.Errorf("Expected only example.com to be queried, got %d queries", n)
+	}
+	if _, err := ParseHosts(strings.NewReader("nas.lab 10.0.0.5\n")); err == nil {
+		t.Errorf("Expected a line without an IP first to be rejected")
+	}
+}
@@ -0,0 +1,81 @@
+// Package dns forwards and caches DNS queries for the server's UDP relay,
+// and caches the client's own lookups.
+package dns
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver forwards wire-format DNS queries to a set of upstream servers,
+// answering repeated questions from its cache.
+type Resolver struct {
+	upstreams []Upstream
+	timeout   time.Duration
+	cache     *Cache // nil = caching disabled
+	next      uint32
+}
+
+// NewResolver returns a resolver for upstreams (see ParseUpstream). With no
+// upstreams, queries go to the server they were addressed to. A cacheSize
+// of 0 disables caching.
+func NewResolver(upstreams []Upstream, cacheSize int, timeout time.Duration) *Resolver {
+	r := &Resolver{upstreams: upstreams, timeout: timeout}
+	if cacheSize > 0 {
+		r.cache = NewCache(cacheSize)
+	}
+	return r
+}
+
+// Cache returns the resolver's cache (nil when disabled).
+func (r *Resolver) Cache() *Cache {
+	return r.cache
+}
+
+// Exchange answers query, which was sent to server ("ip:port"), returning
+// the response in wire format.
+func (r *Resolver) Exchange(query []byte, server string) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns query: %v", err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns question: %v", err)
+	}
+
+	cacheable := r.cache != nil && !h.Response && h.OpCode == 0
+	if cacheable {
+		if msg, ok := r.cache.Get(q); ok {
+			msg.Header.ID = h.ID
+			return msg.Pack()
+		}
+	}
+
+	servers := r.upstreams
+	if len(servers) == 0 {
+		servers = []Upstream{&plainUpstream{addr: server, timeout: r.timeout, dial: directDial}}
+	}
+	start := int(atomic.AddUint32(&r.next, 1))
+	var lastErr error
+	for i := range servers {
+		upstream := servers[(start+i)%len(servers)]
+		resp, err := upstream.Exchange(query)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if cacheable {
+			var msg dnsmessage.Message
+			if err := msg.Unpack(resp); err == nil {
+				r.cache.Put(&msg)
+			}
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,318 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Upstream sends wire-format DNS queries to one server.
+type Upstream interface {
+	Exchange(query []byte) ([]byte, error)
+	String() string
+}
+
+// DialFunc opens the connections of an upstream, e.g. through a tunnel.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// directDial is the DialFunc of upstreams reached from this host.
+var directDial DialFunc = (&net.Dialer{}).DialContext
+
+// ParseUpstream parses an upstream server:
+//
+//	"1.1.1.1:53", "udp://1.1.1.1:53"  plain DNS, over TCP when the answer is truncated
+//	"tcp://1.1.1.1:53"                 plain DNS over TCP
+//	"tls://1.1.1.1", "tls://dns.google:853"
+//	                                   DNS-over-TLS (RFC 7858, port 853 by default)
+//	"quic://dns.adguard-dns.com"       DNS-over-QUIC (RFC 9250, port 853 by default)
+//	"https://1.1.1.1/dns-query"        DNS-over-HTTPS (RFC 8484)
+//
+// Server names are resolved by the system resolver and verified against
+// the server's certificate. dial (nil = direct) opens the tcp, tls and
+// https connections.
+func ParseUpstream(spec string, timeout time.Duration, dial DialFunc) (Upstream, error) {
+	if dial == nil {
+		dial = directDial
+	}
+	if !strings.Contains(spec, "://") {
+		spec = "udp://" + spec
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns server %q: %v", spec, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("dns server %q has no host", spec)
+	}
+	addr := func(port string) string {
+		if u.Port() != "" {
+			return u.Host
+		}
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		return &plainUpstream{addr: addr("53"), tcp: u.Scheme == "tcp", timeout: timeout, dial: dial}, nil
+	case "tls":
+		tlsConfig := &tls.Config{ServerName: u.Hostname(), ClientSessionCache: tls.NewLRUClientSessionCache(4)}
+		return &tlsUpstream{addr: addr("853"), timeout: timeout, dial: dial, config: tlsConfig}, nil
+	case "quic":
+		tlsConfig := &tls.Config{ServerName: u.Hostname(), NextProtos: []string{"doq"}, ClientSessionCache: tls.NewLRUClientSessionCache(4)}
+		return &quicUpstream{addr: addr("853"), timeout: timeout, config: tlsConfig}, nil
+	case "https":
+		tr := &http.Transport{DialContext: dial, ForceAttemptHTTP2: true, MaxIdleConns: 4, IdleConnTimeout: time.Minute}
+		return &httpsUpstream{url: u.String(), client: &http.Client{Transport: tr, Timeout: timeout}}, nil
+	}
+	return nil, fmt.Errorf("unsupported dns server scheme %q", u.Scheme)
+}
+
+// plainUpstream is DNS over UDP or TCP (RFC 1035).
+type plainUpstream struct {
+	addr    string
+	tcp     bool
+	timeout time.Duration
+	dial    DialFunc
+}
+
+func (p *plainUpstream) String() string {
+	if p.tcp {
+		return "tcp://" + p.addr
+	}
+	return p.addr
+}
+
+// Exchange sends query over UDP, retrying over TCP if the answer is
+// truncated.
+func (p *plainUpstream) Exchange(query []byte) ([]byte, error) {
+	if p.tcp {
+		return p.exchangeTCP(query)
+	}
+	resp, err := p.exchangeUDP(query)
+	if err != nil {
+		return nil, err
+	}
+	var h dnsmessage.Header
+	var parser dnsmessage.Parser
+	if h, err = parser.Start(resp); err == nil && h.Truncated {
+		return p.exchangeTCP(query)
+	}
+	return resp, nil
+}
+
+func (p *plainUpstream) exchangeUDP(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", p.addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.timeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("dns query to %s: %v", p.addr, err)
+		}
+		// Skip stray datagrams that don't answer this query's ID.
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (p *plainUpstream) exchangeTCP(query []byte) ([]byte, error) {
+	conn, err := dialTimeout(p.dial, "tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer closeAfter(conn, p.timeout).Stop()
+	resp, err := exchangeStream(conn, query)
+	if err != nil {
+		return nil, fmt.Errorf("dns tcp query to %s: %v", p.addr, err)
+	}
+	return resp, nil
+}
+
+// tlsUpstream is DNS-over-TLS, one connection per query; session tickets
+// keep the reconnects cheap.
+type tlsUpstream struct {
+	addr    string
+	timeout time.Duration
+	dial    DialFunc
+	config  *tls.Config
+}
+
+func (t *tlsUpstream) String() string { return "tls://" + t.addr }
+
+func (t *tlsUpstream) Exchange(query []byte) ([]byte, error) {
+	raw, err := dialTimeout(t.dial, "tcp", t.addr, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, t.config)
+	defer conn.Close()
+	defer closeAfter(raw, t.timeout).Stop()
+	resp, err := exchangeStream(conn, query)
+	if err != nil {
+		return nil, fmt.Errorf("dns query to %s: %v", t, err)
+	}
+	return resp, nil
+}
+
+// quicUpstream is DNS-over-QUIC: one connection, kept open, with a stream
+// per query.
+type quicUpstream struct {
+	addr    string
+	timeout time.Duration
+	config  *tls.Config
+
+	mu   sync.Mutex
+	conn *quic.Conn
+}
+
+func (q *quicUpstream) String() string { return "quic://" + q.addr }
+
+func (q *quicUpstream) Exchange(query []byte) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, fmt.Errorf("dns query too short")
+	}
+	// RFC 9250 section 4.2.1: the message ID must be 0 on the wire.
+	id := [2]byte{query[0], query[1]}
+	query = append([]byte{0, 0}, query[2:]...)
+
+	resp, err := q.exchange(query, false)
+	if err != nil {
+		// The kept connection may have gone idle at the server.
+		resp, err = q.exchange(query, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dns query to %s: %v", q, err)
+	}
+	if len(resp) >= 2 {
+		resp[0], resp[1] = id[0], id[1]
+	}
+	return resp, nil
+}
+
+func (q *quicUpstream) exchange(query []byte, redial bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+	conn, err := q.connection(ctx, redial)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetDeadline(time.Now().Add(q.timeout))
+	if err := writeMsg(stream, query); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	stream.Close() // The client sends one query per stream
+	return readMsg(stream)
+}
+
+// connection returns the open connection, dialing one when there is none
+// or redial is set.
+func (q *quicUpstream) connection(ctx context.Context, redial bool) (*quic.Conn, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.conn != nil && !redial && q.conn.Context().Err() == nil {
+		return q.conn, nil
+	}
+	if q.conn != nil {
+		q.conn.CloseWithError(0, "")
+		q.conn = nil
+	}
+	conn, err := quic.DialAddr(ctx, q.addr, q.config, &quic.Config{MaxIdleTimeout: time.Minute})
+	if err != nil {
+		return nil, err
+	}
+	q.conn = conn
+	return conn, nil
+}
+
+// httpsUpstream is DNS-over-HTTPS, POSTing the query.
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpsUpstream) String() string { return h.url }
+
+func (h *httpsUpstream) Exchange(query []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("dns query to %s: %v", h.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns query to %s: %s", h.url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+func dialTimeout(dial DialFunc, network, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dial(ctx, network, addr)
+}
+
+// closeAfter closes conn once timeout passes; unlike a deadline it also
+// works on tunneled connections.
+func closeAfter(conn net.Conn, timeout time.Duration) *time.Timer {
+	return time.AfterFunc(timeout, func() { conn.Close() })
+}
+
+// exchangeStream sends query on a stream transport and reads the answer,
+// both with the two-byte length prefix of RFC 1035 section 4.2.2.
+func exchangeStream(conn io.ReadWriter, query []byte) ([]byte, error) {
+	if err := writeMsg(conn, query); err != nil {
+		return nil, err
+	}
+	return readMsg(conn)
+}
+
+func writeMsg(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readMsg(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"phoenix/pkg/crypto"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testCert(t *testing.T) tls.Certificate {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	cert, err := crypto.GenerateTLSCertificate(priv)
+	if err != nil {
+		t.Fatalf("Failed to make a certificate: %v", err)
+	}
+	return cert
+}
+
+func testQuery(t *testing.T) []byte {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 0x1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack query: %v", err)
+	}
+	return query
+}
+
+// checkAnswer exchanges a query over u and checks it got answer's reply.
+func checkAnswer(t *testing.T, u Upstream) {
+	resp, err := u.Exchange(testQuery(t))
+	if err != nil {
+		t.Fatalf("Exchange over %s failed: %v", u, err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatalf("Invalid response over %s: %v", u, err)
+	}
+	if msg.ID != 0x1234 || len(msg.Answers) != 1 {
+		t.Errorf("Expected the answer to query 0x1234 over %s, got ID %#x with %d answers", u, msg.ID, len(msg.Answers))
+	}
+}
+
+func TestTLSUpstream(t *testing.T) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{testCert(t)}})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if query, err := readMsg(conn); err == nil {
+					writeMsg(conn, answer(query, [4]byte{192, 0, 2, 1}, 60))
+				}
+			}()
+		}
+	}()
+
+	u, err := ParseUpstream("tls://"+ln.Addr().String(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse upstream: %v", err)
+	}
+	u.(*tlsUpstream).config.InsecureSkipVerify = true
+	checkAnswer(t, u)
+}
+
+func TestQUICUpstream(t *testing.T) {
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{testCert(t)}, NextProtos: []string{"doq"}}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					query, err := readMsg(stream)
+					if err != nil || query[0] != 0 || query[1] != 0 {
+						stream.CancelWrite(1) // Nonzero IDs are a protocol error
+						continue
+					}
+					writeMsg(stream, answer(query, [4]byte{192, 0, 2, 1}, 60))
+					stream.Close()
+				}
+			}()
+		}
+	}()
+
+	u, err := ParseUpstream("quic://"+ln.Addr().String(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse upstream: %v", err)
+	}
+	u.(*quicUpstream).config.InsecureSkipVerify = true
+	for range 2 { // The second query reuses the connection
+		checkAnswer(t, u)
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	for spec, want := range map[string]string{
+		"1.1.1.1:53":                 "1.1.1.1:53",
+		"udp://1.1.1.1":              "1.1.1.1:53",
+		"tls://dns.google":           "tls://dns.google:853",
+		"quic://[2a10:50c0::ad1:ff]": "quic://[2a10:50c0::ad1:ff]:853",
+		"https://1.1.1.1/dns-query":  "https://1.1.1.1/dns-query",
+	} {
+		u, err := ParseUpstream(spec, time.Second, nil)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", spec, err)
+			continue
+		}
+		if u.String() != want {
+			t.Errorf("Expected %q to be %s, got %s", spec, want, u)
+		}
+	}
+	if _, err := ParseUpstream("sdns://AQ", time.Second, nil); err == nil {
+		t.Errorf("Expected an unknown scheme to be rejected")
+	}
+}
@@ -0,0 +1,177 @@
+package geodata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"phoenix/pkg/outbound"
+	"strings"
+)
+
+// Formats of a list ([[geodata]] format).
+const (
+	FormatList    = "list"    // One domain, IP or CIDR per line
+	FormatClash   = "clash"   // Clash rule-provider, YAML payload or text
+	FormatV2Ray   = "v2ray"   // v2fly domain-list-community data file
+	FormatHosts   = "hosts"   // Hosts file (StevenBlack), names of any address
+	FormatAdGuard = "adguard" // AdGuard/uBlock DNS filter ("||example.com^")
+)
+
+// Parse reads a list in format ("" = FormatList) into host matcher entries.
+// Rules that have no host matcher equivalent, such as Clash's PROCESS-NAME,
+// v2fly's include: or AdGuard's exceptions and cosmetic rules, are skipped.
+func Parse(r io.Reader, format string) ([]string, error) {
+	var parse func(line string) (string, bool)
+	switch format {
+	case "", FormatList:
+		parse = func(line string) (string, bool) { return line, true }
+	case FormatClash:
+		parse = parseClash
+	case FormatV2Ray:
+		parse = parseV2Ray
+	case FormatHosts:
+		parse = parseHosts
+	case FormatAdGuard:
+		parse = parseAdGuard
+	default:
+		return nil, fmt.Errorf("unknown geodata format %q", format)
+	}
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if format == FormatAdGuard {
+			// "#" starts cosmetic rules ("example.com##.ad"), not comments.
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+		} else {
+			line, _, _ = strings.Cut(line, "#")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		entry, ok := parse(line)
+		if !ok {
+			continue
+		}
+		// A list naming another would let lists loop.
+		if strings.HasPrefix(entry, outbound.SetPrefix) {
+			return nil, fmt.Errorf("geodata lists cannot include %q", entry)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// parseClash converts a line of a rule-provider: a YAML "payload:" item or
+// a line of the text format, in the domain ("+.example.com"), ipcidr or
+// classical ("DOMAIN-SUFFIX,example.com") behavior.
+func parseClash(line string) (string, bool) {
+	if line == "payload:" {
+		return "", false
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+	line = strings.Trim(line, `'"`)
+	if kind, value, ok := strings.Cut(line, ","); ok {
+		value, _, _ = strings.Cut(value, ",") // Drop options such as no-resolve
+		switch strings.ToUpper(kind) {
+		case "DOMAIN":
+			return "full:" + value, true
+		case "DOMAIN-SUFFIX":
+			return value, true
+		case "DOMAIN-KEYWORD":
+			return "keyword:" + value, true
+		case "DOMAIN-REGEX":
+			return "regexp:" + value, true
+		case "IP-CIDR", "IP-CIDR6":
+			return value, true
+		}
+		return "", false
+	}
+	if strings.Contains(line, "/") || net.ParseIP(line) != nil {
+		return line, true
+	}
+	switch {
+	case strings.HasPrefix(line, "+."):
+		return line[2:], true
+	case strings.HasPrefix(line, "."), strings.HasPrefix(line, "*."):
+		// Subdomains only; the domain itself matching too is close enough.
+		return strings.TrimLeft(line, "*."), true
+	case strings.Contains(line, "*"):
+		return "", false
+	}
+	return "full:" + line, true
+}
+
+// parseV2Ray converts a line of a domain-list-community file: "domain:",
+// "full:", "keyword:" and "regexp:" rules or plain domains, with optional
+// "@attribute"s, which are ignored.
+func parseV2Ray(line string) (string, bool) {
+	rule, _, _ := strings.Cut(line, " @")
+	rule = strings.TrimSpace(rule)
+	kind, value, ok := strings.Cut(rule, ":")
+	if !ok {
+		return rule, true
+	}
+	switch kind {
+	case "domain":
+		return value, true
+	case "full", "keyword", "regexp":
+		return rule, true
+	}
+	return "", false // include: and unknown rules
+}
+
+// hostsNames are the names hosts files map for the machine itself.
+var hostsNames = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// parseHosts converts a hosts file line ("0.0.0.0 ads.example.com"): each
+// name is blocked exactly, as hosts files list subdomains themselves. Only
+// the first name of a line is kept.
+func parseHosts(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return "", false
+	}
+	name := strings.ToLower(fields[1])
+	if hostsNames[name] {
+		return "", false
+	}
+	return "full:" + name, true
+}
+
+// parseAdGuard converts a DNS filter rule: "||example.com^" blocks the
+// domain and its subdomains, "/regexp/" names by pattern, and hosts-style
+// lines and bare domains are accepted too. Exceptions ("@@"), rules with
+// modifiers other than $important, and browser-only rules are skipped.
+func parseAdGuard(line string) (string, bool) {
+	if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "@@") || strings.Contains(line, "##") || strings.Contains(line, "#@#") || strings.Contains(line, "#$#") {
+		return "", false
+	}
+	if rule, mods, ok := strings.Cut(line, "$"); ok {
+		if mods != "important" {
+			return "", false
+		}
+		line = rule
+	}
+	if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+		return "regexp:" + line[1:len(line)-1], true
+	}
+	if entry, ok := parseHosts(line); ok {
+		return entry, true
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(line, "||"), "^")
+	name = strings.TrimSuffix(name, "|")
+	if name == "" || strings.ContainsAny(name, "/^|*:? ") || !strings.Contains(name, ".") {
+		return "", false
+	}
+	return strings.ToLower(name), true
+}
@@ -0,0 +1,220 @@
+// Package geodata keeps GeoIP and geosite lists and rule sets up to date
+// ([[geodata]]): it fetches each list from its URL on a schedule, in its
+// own, the Clash or the v2fly format, verifies it against a SHA-256
+// checksum or Ed25519 signature, and swaps it into the routing engine's
+// HostSet of the same name, so that "geo:<name>" entries pick it up without
+// a restart.
+package geodata
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"phoenix/pkg/crypto"
+	"phoenix/pkg/outbound"
+	"strings"
+	"time"
+)
+
+// DefaultInterval is how often lists are refreshed when no interval is set.
+const DefaultInterval = 24 * time.Hour
+
+// retry is the pause after a failed update, shorter than the interval so a
+// list missing at startup arrives soon.
+const retry = 5 * time.Minute
+
+// maxSize bounds a downloaded list.
+const maxSize = 64 << 20
+
+// ErrVerify is wrapped by errors for lists whose checksum or signature
+// doesn't match.
+var ErrVerify = errors.New("verification failed")
+
+// Source is one list and the HostSet it fills.
+type Source struct {
+	cfg    config.GeoData
+	key    ed25519.PublicKey // nil = no signature check
+	client *http.Client
+	set    *outbound.HostSet
+
+	updated time.Time // Of the entries in set
+	entries int
+}
+
+// New returns the source of cfg, fetched with client (nil = a direct
+// http.Client).
+func New(cfg config.GeoData, client *http.Client) (*Source, error) {
+	s := &Source{cfg: cfg, client: client, set: outbound.NamedHostSet(cfg.Name)}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: time.Minute}
+	}
+	if cfg.PublicKey != "" {
+		key, err := crypto.ParsePublicKey(cfg.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("geodata %s: invalid public_key: %v", cfg.Name, err)
+		}
+		s.key = key.(ed25519.PublicKey)
+	}
+	if s.cfg.Interval <= 0 {
+		s.cfg.Interval = DefaultInterval
+	}
+	return s, nil
+}
+
+// Run loads the cached copy, then keeps the list up to date until ctx is
+// done.
+func (s *Source) Run(ctx context.Context) {
+	if err := s.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[GeoData] Ignoring cached %s: %v", s.cfg.Name, err)
+	}
+	for {
+		if wait := s.cfg.Interval - time.Since(s.updated); wait > 0 && !sleep(ctx, wait) {
+			return
+		}
+		if err := s.Update(); err != nil {
+			log.Printf("[GeoData] Failed to update %s: %v", s.cfg.Name, err)
+			if !sleep(ctx, retry) {
+				return
+			}
+			continue
+		}
+		log.Printf("[GeoData] Updated %s: %d entries", s.cfg.Name, s.entries)
+	}
+}
+
+// sleep waits for d, reporting false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Load fills the set from the cached copy at path, if one is configured.
+// The file's modification time counts as the last update.
+func (s *Source) Load() error {
+	if s.cfg.Path == "" {
+		return os.ErrNotExist
+	}
+	data, err := os.ReadFile(s.cfg.Path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(s.cfg.Path)
+	if err != nil {
+		return err
+	}
+	if err := s.swap(data); err != nil {
+		return err
+	}
+	s.updated = info.ModTime()
+	return nil
+}
+
+// Update fetches and verifies the list, swaps it in and caches it.
+func (s *Source) Update() error {
+	data, err := s.fetch(s.cfg.URL)
+	if err != nil {
+		return err
+	}
+	if err := s.verify(data); err != nil {
+		return err
+	}
+	if err := s.swap(data); err != nil {
+		return err
+	}
+	s.updated = time.Now()
+	if s.cfg.Path == "" {
+		return nil
+	}
+	// Replace the cache atomically, so a crash leaves the old copy.
+	tmp := s.cfg.Path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cfg.Path)
+}
+
+// verify checks data against sha256_url and signature_url, if set.
+func (s *Source) verify(data []byte) error {
+	if s.cfg.SHA256URL != "" {
+		sum, err := s.fetch(s.cfg.SHA256URL)
+		if err != nil {
+			return fmt.Errorf("checksum: %v", err)
+		}
+		// "<hex>  <file name>", as written by sha256sum.
+		fields := strings.Fields(string(sum))
+		got := sha256.Sum256(data)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], hex.EncodeToString(got[:])) {
+			return fmt.Errorf("sha256 %w", ErrVerify)
+		}
+	}
+	if s.key != nil {
+		sig, err := s.fetch(s.cfg.SignatureURL)
+		if err != nil {
+			return fmt.Errorf("signature: %v", err)
+		}
+		// Raw, or Base64 as phoenix keys are written.
+		if len(sig) != ed25519.SignatureSize {
+			if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+				return fmt.Errorf("signature %w: %v", ErrVerify, err)
+			}
+		}
+		if !ed25519.Verify(s.key, data, sig) {
+			return fmt.Errorf("signature %w", ErrVerify)
+		}
+	}
+	return nil
+}
+
+func (s *Source) fetch(url string) ([]byte, error) {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%s: larger than %d MB", url, maxSize>>20)
+	}
+	return data, nil
+}
+
+// swap parses data and replaces the set's entries with it.
+func (s *Source) swap(data []byte) error {
+	entries, err := Parse(bytes.NewReader(data), s.cfg.Format)
+	if err != nil {
+		return err
+	}
+	m, err := outbound.ParseHostMatcher(entries)
+	if err != nil {
+		return err
+	}
+	s.set.Store(m)
+	s.entries = len(entries)
+	return nil
+}
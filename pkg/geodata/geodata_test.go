@@ -0,0 +1,106 @@
+package geodata
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"strings"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	list := []byte("# Iran\n2.144.0.0/14\nshop.ir  # domestic\n")
+	sum := sha256.Sum256(list)
+	files := map[string][]byte{
+		"/ir.txt":        list,
+		"/ir.txt.sha256": []byte(hex.EncodeToString(sum[:]) + "  ir.txt\n"),
+		"/ir.txt.sig":    []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, list))),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	cfg := config.GeoData{
+		Name:         "test-update",
+		URL:          srv.URL + "/ir.txt",
+		SHA256URL:    srv.URL + "/ir.txt.sha256",
+		SignatureURL: srv.URL + "/ir.txt.sig",
+		PublicKey:    base64.StdEncoding.EncodeToString(pub),
+		Path:         filepath.Join(t.TempDir(), "ir.txt"),
+	}
+	s, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := s.Update(); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	set := outbound.NamedHostSet("test-update")
+	if !set.Match("2.145.1.1") || !set.Match("www.shop.ir") || set.Match("example.com") {
+		t.Errorf("Expected the list to be swapped in")
+	}
+
+	// A tampered list is refused and the last good one kept.
+	files["/ir.txt"] = []byte("0.0.0.0/0\n")
+	if err := s.Update(); !errors.Is(err, ErrVerify) {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+	if !set.Match("shop.ir") || set.Match("example.com") {
+		t.Errorf("Expected the verified list to stay in place")
+	}
+
+	// The cached copy fills a new source's set.
+	outbound.NamedHostSet("test-update").Store(nil)
+	s, _ = New(cfg, nil)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !set.Match("shop.ir") {
+		t.Errorf("Expected the cached list to be loaded")
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	clash := "payload:\n  - '+.google.com'\n  - 'www.example.com'\n  - DOMAIN-KEYWORD,tracker\n  - IP-CIDR,1.0.0.0/8,no-resolve\n  - PROCESS-NAME,curl\n"
+	v2ray := "# ads\ndomain:ads.example\nfull:pixel.example.org @ads\nkeyword:doubleclick\ninclude:other\nplain.example\n"
+	hosts := "# StevenBlack\n127.0.0.1 localhost\n0.0.0.0 0.0.0.0\n0.0.0.0 ads.example.com # banner\n0.0.0.0 Track.Example.NET\n"
+	adguard := "! Title: DNS filter\n||ads.example^\n||pixel.example.org^$important\n@@||ok.ads.example^\n||cdn.example^$third-party\nnews.example##.banner\n/^ad[0-9]+\\./\n0.0.0.0 tracker.example\n"
+	for _, tc := range []struct {
+		format, data string
+		match, miss  []string
+	}{
+		{FormatClash, clash, []string{"mail.google.com", "www.example.com", "mytracker.net", "1.2.3.4"}, []string{"example.com", "2.0.0.1"}},
+		{FormatV2Ray, v2ray, []string{"x.ads.example", "pixel.example.org", "doubleclick.net", "a.plain.example"}, []string{"other", "www.pixel.example.org"}},
+		{FormatHosts, hosts, []string{"ads.example.com", "track.example.net"}, []string{"localhost", "www.ads.example.com", "banner"}},
+		{FormatAdGuard, adguard, []string{"ads.example", "x.ads.example", "pixel.example.org", "ad12.example", "tracker.example"}, []string{"cdn.example", "news.example"}},
+	} {
+		entries, err := Parse(strings.NewReader(tc.data), tc.format)
+		if err != nil {
+			t.Fatalf("Parse %s failed: %v", tc.format, err)
+		}
+		m, err := outbound.ParseHostMatcher(entries)
+		if err != nil {
+			t.Fatalf("Invalid %s entries %v: %v", tc.format, entries, err)
+		}
+		for _, h := range tc.match {
+			if !m.Match(h) {
+				t.Errorf("Expected the %s list to match %s (entries %v)", tc.format, h, entries)
+			}
+		}
+		for _, h := range tc.miss {
+			if m.Match(h) {
+				t.Errorf("Expected the %s list not to match %s", tc.format, h)
+			}
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package ipfix exports flow records to an IPFIX (RFC 7011, "NetFlow
+// v10") collector over UDP. A flow is one tunneled stream: the client's
+// address, the target, the user and the bytes each way, the download
+// direction as the RFC 5103 reverse octet count.
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// End reasons of a record (flowEndReason).
+const (
+	ReasonActive = 2 // Active timeout: the stream goes on
+	ReasonEnd    = 3 // The stream ended
+)
+
+// Flow is one record.
+type Flow struct {
+	Source         netip.AddrPort // The client
+	Target         string         // "host:port"; hosts that aren't IPs are sent as httpRequestHost
+	User           string
+	Start, End     time.Time
+	BytesUp        uint64 // Client to target, since the previous record of the flow
+	BytesDn        uint64
+	Reason         uint8
+	ProtocolNumber uint8 // protocolIdentifier, 6 = TCP
+}
+
+// Information elements (IANA registry) and the enterprise number of the
+// RFC 5103 reverse elements.
+const (
+	ieOctetDeltaCount       = 1
+	ieProtocolIdentifier    = 4
+	ieSourceTransportPort   = 7
+	ieSourceIPv4Address     = 8
+	ieDestTransportPort     = 11
+	ieDestIPv4Address       = 12
+	ieSourceIPv6Address     = 27
+	ieDestIPv6Address       = 28
+	ieFlowEndReason         = 136
+	ieFlowStartMilliseconds = 152
+	ieFlowEndMilliseconds   = 153
+	ieUserName              = 371
+	ieHTTPRequestHost       = 460
+
+	reversePEN = 29305
+
+	varLen = 0xffff
+)
+
+// field is a template field: an element, its length, and for enterprise
+// elements their enterprise number.
+type field struct {
+	id, length uint16
+	pen        uint32
+}
+
+// Templates differ by source family and by destination kind (IPv4, IPv6,
+// name); their IDs are templateBase + 3*srcKind + dstKind.
+const templateBase = 256
+
+const (
+	kindV4 = iota
+	kindV6
+	kindName
+)
+
+func templateFields(src, dst int) []field {
+	fields := []field{{id: ieFlowStartMilliseconds, length: 8}, {id: ieFlowEndMilliseconds, length: 8}}
+	if src == kindV4 {
+		fields = append(fields, field{id: ieSourceIPv4Address, length: 4})
+	} else {
+		fields = append(fields, field{id: ieSourceIPv6Address, length: 16})
+	}
+	fields = append(fields, field{id: ieSourceTransportPort, length: 2})
+	switch dst {
+	case kindV4:
+		fields = append(fields, field{id: ieDestIPv4Address, length: 4})
+	case kindV6:
+		fields = append(fields, field{id: ieDestIPv6Address, length: 16})
+	default:
+		fields = append(fields, field{id: ieHTTPRequestHost, length: varLen})
+	}
+	return append(fields,
+		field{id: ieDestTransportPort, length: 2},
+		field{id: ieProtocolIdentifier, length: 1},
+		field{id: ieOctetDeltaCount, length: 8},
+		field{id: ieOctetDeltaCount, length: 8, pen: reversePEN},
+		field{id: ieFlowEndReason, length: 1},
+		field{id: ieUserName, length: varLen},
+	)
+}
+
+const (
+	// maxMessage keeps messages within one unfragmented datagram.
+	maxMessage = 1400
+
+	// flushInterval bounds how long records wait for a full message.
+	flushInterval = time.Second
+
+	// templateInterval is how often templates are resent, for collectors
+	// that start after the exporter (RFC 7011 section 8.4).
+	templateInterval = time.Minute
+)
+
+// Exporter batches records into messages to one collector.
+type Exporter struct {
+	conn   net.Conn
+	domain uint32
+
+	mu        sync.Mutex
+	records   [][]byte // Encoded data records, by template
+	templates []uint16
+	size      int
+	seq       uint32 // Data records sent
+	templated time.Time
+}
+
+// NewExporter returns an exporter to collector ("host:port", UDP) in
+// observation domain domain. It flushes records in the background.
+func NewExporter(collector string, domain uint32) (*Exporter, error) {
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return nil, err
+	}
+	e := &Exporter{conn: conn, domain: domain}
+	go func() {
+		for range time.Tick(flushInterval) {
+			e.Flush()
+		}
+	}()
+	return e, nil
+}
+
+// Export queues f, sending a message once enough are queued.
+func (e *Exporter) Export(f Flow) {
+	src := kindV6
+	if f.Source.Addr().Unmap().Is4() {
+		src = kindV4
+	}
+	host, portStr, err := net.SplitHostPort(f.Target)
+	if err != nil {
+		return
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+	dst := kindName
+	dstAddr, err := netip.ParseAddr(host)
+	if err == nil {
+		dstAddr = dstAddr.Unmap()
+		dst = kindV6
+		if dstAddr.Is4() {
+			dst = kindV4
+		}
+	}
+
+	var b []byte
+	b = binary.BigEndian.AppendUint64(b, uint64(f.Start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(f.End.UnixMilli()))
+	if src == kindV4 {
+		a := f.Source.Addr().Unmap().As4()
+		b = append(b, a[:]...)
+	} else {
+		a := f.Source.Addr().As16()
+		b = append(b, a[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, f.Source.Port())
+	switch dst {
+	case kindV4:
+		a := dstAddr.As4()
+		b = append(b, a[:]...)
+	case kindV6:
+		a := dstAddr.As16()
+		b = append(b, a[:]...)
+	default:
+		b = appendString(b, host)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	b = append(b, f.ProtocolNumber)
+	b = binary.BigEndian.AppendUint64(b, f.BytesUp)
+	b = binary.BigEndian.AppendUint64(b, f.BytesDn)
+	b = append(b, f.Reason)
+	b = appendString(b, f.User)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.size+len(b)+4 > maxMessage-templatesSize {
+		e.flushLocked()
+	}
+	e.records = append(e.records, b)
+	e.templates = append(e.templates, uint16(templateBase+3*src+dst))
+	e.size += len(b) + 4
+}
+
+// appendString appends s as a variable-length field (RFC 7011 section
+// 7), cut to 255 bytes.
+func appendString(b []byte, s string) []byte {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+	} else {
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	}
+	return append(b, s...)
+}
+
+// templatesSize is the size of the template set, left room for in every
+// message.
+var templatesSize = len(templateSet())
+
+// templateSet returns the template set (set ID 2) of every template.
+func templateSet() []byte {
+	b := []byte{0, 2, 0, 0}
+	for src := kindV4; src <= kindV6; src++ {
+		for dst := kindV4; dst <= kindName; dst++ {
+			fields := templateFields(src, dst)
+			b = binary.BigEndian.AppendUint16(b, uint16(templateBase+3*src+dst))
+			b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+			for _, f := range fields {
+				if f.pen != 0 {
+					b = binary.BigEndian.AppendUint16(b, f.id|0x8000)
+					b = binary.BigEndian.AppendUint16(b, f.length)
+					b = binary.BigEndian.AppendUint32(b, f.pen)
+				} else {
+					b = binary.BigEndian.AppendUint16(b, f.id)
+					b = binary.BigEndian.AppendUint16(b, f.length)
+				}
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// Flush sends the queued records.
+func (e *Exporter) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.flushLocked()
+}
+
+func (e *Exporter) flushLocked() {
+	now := time.Now()
+	sendTemplates := now.Sub(e.templated) >= templateInterval
+	if len(e.records) == 0 && !sendTemplates {
+		return
+	}
+	msg := make([]byte, 16, maxMessage)
+	binary.BigEndian.PutUint16(msg[0:], 10) // Version
+	binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[8:], e.seq)
+	binary.BigEndian.PutUint32(msg[12:], e.domain)
+	if sendTemplates {
+		msg = append(msg, templateSet()...)
+		e.templated = now
+	}
+	// One data set per run of records of the same template.
+	for i := 0; i < len(e.records); {
+		id := e.templates[i]
+		start := len(msg)
+		msg = binary.BigEndian.AppendUint16(msg, id)
+		msg = append(msg, 0, 0)
+		for ; i < len(e.records) && e.templates[i] == id; i++ {
+			msg = append(msg, e.records[i]...)
+		}
+		binary.BigEndian.PutUint16(msg[start+2:], uint16(len(msg)-start))
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	e.conn.Write(msg)
+	e.seq += uint32(len(e.records))
+	e.records, e.templates, e.size = e.records[:0], e.templates[:0], 0
+}
@@ -0,0 +1,71 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	e, err := NewExporter(pc.LocalAddr().String(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.UnixMilli(1700000000000)
+	e.Export(Flow{
+		Source:         netip.MustParseAddrPort("198.51.100.7:50123"),
+		Target:         "example.com:443",
+		User:           "alice",
+		Start:          start,
+		End:            start.Add(3 * time.Second),
+		BytesUp:        1200,
+		BytesDn:        64000,
+		Reason:         ReasonEnd,
+		ProtocolNumber: 6,
+	})
+	e.Flush()
+
+	buf := make([]byte, 65535)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := buf[:n]
+	if v, l, domain := binary.BigEndian.Uint16(msg), binary.BigEndian.Uint16(msg[2:]), binary.BigEndian.Uint32(msg[12:]); v != 10 || int(l) != n || domain != 7 {
+		t.Fatalf("Bad header: version %d, length %d of %d, domain %d", v, l, n, domain)
+	}
+
+	// The first message carries the templates, then the record.
+	sets := map[uint16][]byte{}
+	for b := msg[16:]; len(b) >= 4; {
+		id, l := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		sets[id] = b[4:l]
+		b = b[l:]
+	}
+	if _, ok := sets[2]; !ok {
+		t.Fatalf("Expected a template set, got sets %v", sets)
+	}
+	rec, ok := sets[templateBase+3*kindV4+kindName]
+	if !ok {
+		t.Fatalf("Expected an IPv4-to-name record, got sets %v", sets)
+	}
+	if got := binary.BigEndian.Uint64(rec[8:]) - binary.BigEndian.Uint64(rec); got != 3000 {
+		t.Errorf("Expected a 3s flow, got %dms", got)
+	}
+	if net.IP(rec[16:20]).String() != "198.51.100.7" {
+		t.Errorf("Bad source %v", rec[16:20])
+	}
+	s := string(rec)
+	if !strings.Contains(s, "\x0bexample.com") || !strings.HasSuffix(s, "\x05alice") {
+		t.Errorf("Expected the target and user strings in the record %q", s)
+	}
+}
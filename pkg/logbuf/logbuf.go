@@ -0,0 +1,93 @@
+// Package logbuf keeps the most recent log lines in memory and streams new
+// ones to subscribers, so an app embedding Phoenix can show logs and attach
+// them to bug reports without capturing the process output.
+package logbuf
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Ring is an io.Writer that splits its input into lines and keeps the last
+// Size of them. Use it as (part of) the log output:
+//
+//	log.SetOutput(io.MultiWriter(os.Stderr, ring))
+type Ring struct {
+	mu      sync.Mutex
+	lines   []string // Circular; next is the oldest once full
+	next    int
+	full    bool
+	partial []byte // Unterminated tail of the last Write
+	subs    map[int]func(line string)
+	nextSub int
+}
+
+// New returns a ring holding up to size lines.
+func New(size int) *Ring {
+	if size < 1 {
+		size = 1
+	}
+	return &Ring{lines: make([]string, size), subs: make(map[int]func(string))}
+}
+
+// Write stores every complete line in p. It never fails.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	var done []string
+	data := append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(data[:i])
+		data = data[i+1:]
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % len(r.lines)
+		if r.next == 0 {
+			r.full = true
+		}
+		done = append(done, line)
+	}
+	r.partial = append([]byte(nil), data...)
+	subs := make([]func(string), 0, len(r.subs))
+	for _, fn := range r.subs {
+		subs = append(subs, fn)
+	}
+	r.mu.Unlock()
+
+	// Outside the lock, so a callback may log or read the ring.
+	for _, line := range done {
+		for _, fn := range subs {
+			fn(line)
+		}
+	}
+	return len(p), nil
+}
+
+// Lines returns the stored lines, oldest first.
+func (r *Ring) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	out := make([]string, 0, len(r.lines))
+	out = append(out, r.lines[r.next:]...)
+	return append(out, r.lines[:r.next]...)
+}
+
+// Subscribe calls fn with every line written from now on, on the writing
+// goroutine, until the returned function is called. fn must not block.
+func (r *Ring) Subscribe(fn func(line string)) (cancel func()) {
+	r.mu.Lock()
+	id := r.nextSub
+	r.nextSub++
+	r.subs[id] = fn
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		delete(r.subs, id)
+		r.mu.Unlock()
+	}
+}
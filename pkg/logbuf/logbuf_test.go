@@ -0,0 +1,30 @@
+package logbuf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := New(3)
+	var streamed []string
+	cancel := r.Subscribe(func(line string) { streamed = append(streamed, line) })
+
+	r.Write([]byte("one\ntwo\nthr"))
+	if got := strings.Join(r.Lines(), ","); got != "one,two" {
+		t.Errorf("Expected one,two, got %s", got)
+	}
+	r.Write([]byte("ee\nfour\n"))
+	if got := strings.Join(r.Lines(), ","); got != "two,three,four" {
+		t.Errorf("Expected two,three,four, got %s", got)
+	}
+	if got := strings.Join(streamed, ","); got != "one,two,three,four" {
+		t.Errorf("Expected all four lines streamed, got %s", got)
+	}
+
+	cancel()
+	r.Write([]byte("five\n"))
+	if len(streamed) != 4 {
+		t.Errorf("Expected no lines after cancel, got %d", len(streamed)-4)
+	}
+}
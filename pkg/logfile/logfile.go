@@ -0,0 +1,169 @@
+// Package logfile writes the log to a file that rotates by size and time
+// and prunes its old copies, so a long-running server doesn't depend on
+// whatever captures its output.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+// backupTime is the suffix format of rotated files. It sorts by time.
+const backupTime = "20060102-150405.000"
+
+// File is an io.Writer appending to the [log] file. Use it as the log
+// output:
+//
+//	log.SetOutput(f)
+type File struct {
+	cfg config.LogOutput
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	period time.Time // Start of the RotateEvery interval the file belongs to
+}
+
+// Open opens (or creates) cfg.File for appending.
+func Open(cfg config.LogOutput) (*File, error) {
+	l := &File{cfg: cfg}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file. Callers hold mu (or own l).
+func (l *File) open() error {
+	f, err := os.OpenFile(l.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open log file: %v", err)
+	}
+	l.f, l.size = f, info.Size()
+	// A file carried over from a previous run belongs to the interval it
+	// was last written in.
+	l.period = l.periodOf(info.ModTime())
+	if info.Size() == 0 {
+		l.period = l.periodOf(time.Now())
+	}
+	return nil
+}
+
+// periodOf returns the start of t's RotateEvery interval, zero when the
+// file doesn't rotate by time.
+func (l *File) periodOf(t time.Time) time.Time {
+	if l.cfg.RotateEvery <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(l.cfg.RotateEvery)
+}
+
+// Write appends p, rotating the file first when p would take it past
+// MaxSize or a new RotateEvery interval has begun. After a failed rotation
+// it keeps writing to the current file and tries again a MaxSize or an
+// interval later.
+func (l *File) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	now := time.Now()
+	full := l.cfg.MaxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.cfg.MaxSize
+	if full || !l.periodOf(now).Equal(l.period) {
+		if err := l.rotate(now); err != nil {
+			fmt.Fprintf(os.Stderr, "[Log] Rotating %s failed: %v\n", l.cfg.File, err)
+			l.size, l.period = 0, l.periodOf(now)
+		}
+		if l.f == nil {
+			return 0, os.ErrClosed
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, opens a new one and prunes the old copies.
+// The file is closed first, as Windows can't rename it while open. Callers
+// hold mu.
+func (l *File) rotate(now time.Time) error {
+	l.f.Close()
+	backup := l.cfg.File + "." + now.UTC().Format(backupTime)
+	renamed := os.Rename(l.cfg.File, backup)
+	if err := l.open(); err != nil {
+		l.f = nil
+		return err
+	}
+	if renamed != nil {
+		return renamed
+	}
+	l.period = l.periodOf(now)
+	l.prune(now)
+	return nil
+}
+
+// prune removes rotated files beyond MaxBackups or older than MaxAge.
+// Callers hold mu.
+func (l *File) prune(now time.Time) {
+	if l.cfg.MaxBackups <= 0 && l.cfg.MaxAge <= 0 {
+		return
+	}
+	backups, _ := filepath.Glob(l.cfg.File + ".*")
+	var keep []string
+	for _, b := range backups {
+		t, err := time.Parse(backupTime, b[len(l.cfg.File)+1:])
+		if err != nil {
+			continue // Not ours
+		}
+		if l.cfg.MaxAge > 0 && now.Sub(t) > l.cfg.MaxAge {
+			os.Remove(b)
+			continue
+		}
+		keep = append(keep, b)
+	}
+	if l.cfg.MaxBackups <= 0 || len(keep) <= l.cfg.MaxBackups {
+		return
+	}
+	sort.Strings(keep)
+	for _, b := range keep[:len(keep)-l.cfg.MaxBackups] {
+		os.Remove(b)
+	}
+}
+
+// Reopen closes the file and opens the path again, for when logrotate or
+// an operator moved it away.
+func (l *File) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+	}
+	if err := l.open(); err != nil {
+		l.f = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the file. Later Writes fail.
+func (l *File) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,54 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phoenix.log")
+	f, err := Open(config.LogOutput{File: path, MaxSize: 100, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond) // Distinct backup names
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("Expected 2 backups kept, got %v", backups)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != line {
+		t.Errorf("Expected the current file to hold one line, got %q", data)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phoenix.log")
+	f, err := Open(config.LogOutput{File: path})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	f.Write([]byte("old\n"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	f.Write([]byte("new\n"))
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("Expected the reopened file to hold the new line, got %q", data)
+	}
+}
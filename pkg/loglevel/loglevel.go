@@ -0,0 +1,67 @@
+// Package loglevel switches the verbosity of the process log at runtime, so
+// a running client or server can be debugged without a restart. Ordinary
+// log.Printf lines are always written; Debugf lines only at Debug.
+package loglevel
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level is a log verbosity.
+type Level int32
+
+const (
+	Info Level = iota
+	Debug
+)
+
+var level atomic.Int32
+
+// Parse maps a log_level setting to a Level; "" is Info.
+func Parse(s string) (Level, error) {
+	switch s {
+	case "", "info":
+		return Info, nil
+	case "debug":
+		return Debug, nil
+	}
+	return Info, fmt.Errorf("unknown log level %q (want info or debug)", s)
+}
+
+func (l Level) String() string {
+	if l == Debug {
+		return "debug"
+	}
+	return "info"
+}
+
+// Get returns the current level.
+func Get() Level {
+	return Level(level.Load())
+}
+
+// Set changes the level, logging the change.
+func Set(l Level) {
+	if Level(level.Swap(int32(l))) != l {
+		log.Printf("[Log] Level set to %s", l)
+	}
+}
+
+// Toggle switches between Info and Debug and returns the new level.
+func Toggle() Level {
+	l := Debug
+	if Get() == Debug {
+		l = Info
+	}
+	Set(l)
+	return l
+}
+
+// Debugf logs like log.Printf while the level is Debug.
+func Debugf(format string, args ...any) {
+	if Get() == Debug {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,33 @@
+package loglevel
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestDebugf(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	Debugf("hidden")
+	if l, err := Parse("debug"); err != nil || l != Debug {
+		t.Fatalf("Expected debug to parse, got %v, %v", l, err)
+	}
+	Set(Debug)
+	Debugf("shown")
+	if Toggle() != Info {
+		t.Errorf("Expected Toggle to return to info")
+	}
+	Debugf("hidden again")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Errorf("Expected only the debug line logged at Debug, got %q", out)
+	}
+	if _, err := Parse("verbose"); err == nil {
+		t.Errorf("Expected an unknown level to be rejected")
+	}
+}
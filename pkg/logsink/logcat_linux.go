@@ -0,0 +1,59 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// logdSocket is where Android's logd takes log entries.
+const logdSocket = "/dev/socket/logdw"
+
+const (
+	logIDMain    = 0
+	priorityInfo = 4
+)
+
+// logcat writes log lines to logd, each as one entry of the main buffer.
+type logcat struct {
+	conn net.Conn
+	tag  string
+}
+
+// openLogcat connects to logd. It fails off Android, where the socket
+// doesn't exist.
+func openLogcat(tag string) (io.WriteCloser, error) {
+	conn, err := net.Dial("unixgram", logdSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &logcat{conn: conn, tag: tag}, nil
+}
+
+// Write sends p as one entry: logd's header (buffer id, thread id,
+// realtime), then the priority, tag and message, each string
+// NUL-terminated.
+func (l *logcat) Write(p []byte) (int, error) {
+	now := time.Now()
+	var b bytes.Buffer
+	b.WriteByte(logIDMain)
+	binary.Write(&b, binary.LittleEndian, uint16(os.Getpid()))
+	binary.Write(&b, binary.LittleEndian, uint32(now.Unix()))
+	binary.Write(&b, binary.LittleEndian, uint32(now.Nanosecond()))
+	b.WriteByte(priorityInfo)
+	b.WriteString(l.tag)
+	b.WriteByte(0)
+	b.Write(bytes.TrimRight(p, "\n"))
+	b.WriteByte(0)
+	if _, err := l.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *logcat) Close() error {
+	return l.conn.Close()
+}
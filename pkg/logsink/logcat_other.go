@@ -0,0 +1,12 @@
+//go:build !linux
+
+package logsink
+
+import (
+	"errors"
+	"io"
+)
+
+func openLogcat(tag string) (io.WriteCloser, error) {
+	return nil, errors.New("logcat is only available on Android")
+}
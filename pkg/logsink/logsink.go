@@ -0,0 +1,30 @@
+// Package logsink copies the log to the system's log service: syslog on
+// routers and servers, logcat on Android.
+package logsink
+
+import (
+	"fmt"
+	"io"
+	"phoenix/pkg/config"
+)
+
+// defaultTag names the program when log.tag is empty.
+const defaultTag = "phoenix"
+
+// Open returns the writer for cfg.Sink, nil when it is empty. Each Write
+// should be one log line, as the log package makes them.
+func Open(cfg config.LogOutput) (io.WriteCloser, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultTag
+	}
+	switch cfg.Sink {
+	case "":
+		return nil, nil
+	case "syslog":
+		return openSyslog(cfg.SyslogAddr, tag)
+	case "logcat":
+		return openLogcat(tag)
+	}
+	return nil, fmt.Errorf("unknown log sink %q", cfg.Sink)
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package logsink
+
+import (
+	"net"
+	"phoenix/pkg/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteSyslog(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	w, err := Open(config.LogOutput{Sink: "syslog", SyslogAddr: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer w.Close()
+	w.Write([]byte("[Notice] hello\n"))
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No syslog message arrived: %v", err)
+	}
+	if msg := string(buf[:n]); !strings.Contains(msg, "phoenix") || !strings.Contains(msg, "[Notice] hello") {
+		t.Errorf("Expected a tagged message, got %q", msg)
+	}
+}
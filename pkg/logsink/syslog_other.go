@@ -0,0 +1,12 @@
+//go:build !unix
+
+package logsink
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog(addr, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package logsink
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// openSyslog dials the local syslog daemon, or addr over UDP.
+func openSyslog(addr, tag string) (io.WriteCloser, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+	}
+	return syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
@@ -0,0 +1,51 @@
+// Package netmon reports changes of the host's network configuration, such
+// as a laptop moving between Wi-Fi networks or a phone switching from Wi-Fi
+// to LTE, so long-lived tunnel connections can be re-established at once.
+package netmon
+
+import (
+	"errors"
+	"time"
+)
+
+// settle is how long the network must stay quiet before a change is
+// reported; switching networks produces a burst of address and route events.
+const settle = time.Second
+
+// ErrUnsupported is returned by Watch on platforms without a monitor.
+var ErrUnsupported = errors.New("network monitoring is not supported on this platform")
+
+// Watch calls onChange after every burst of network changes. It blocks for
+// as long as monitoring works and returns the error that stopped it.
+func Watch(onChange func()) error {
+	events := make(chan struct{}, 1)
+	go debounce(events, onChange)
+	err := watch(events)
+	close(events)
+	return err
+}
+
+// debounce calls onChange once events have been quiet for settle.
+func debounce(events <-chan struct{}, onChange func()) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+			timer = time.After(settle)
+		case <-timer:
+			timer = nil
+			onChange()
+		}
+	}
+}
+
+// signal records an event without blocking the reader.
+func signal(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,35 @@
+package netmon
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// watch reads the routing socket for interface and address changes.
+func watch(events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return fmt.Errorf("route socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("route socket read: %v", err)
+		}
+		// Every routing message starts with msglen (2), version (1), type (1).
+		// Route additions are skipped: ARP and neighbor entries are routes too.
+		if n < 4 {
+			continue
+		}
+		switch buf[3] {
+		case syscall.RTM_NEWADDR, syscall.RTM_DELADDR, syscall.RTM_IFINFO:
+			signal(events)
+		}
+	}
+}
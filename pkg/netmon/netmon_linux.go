@@ -0,0 +1,63 @@
+package netmon
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// watch reads rtnetlink notifications for address changes and default route
+// changes. Android apps may be denied the netlink socket; the Android service
+// reports network changes itself (see the client's -control-stdin flag).
+func watch(events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+			unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("netlink bind: %v", err)
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.ENOBUFS {
+				// ENOBUFS: notifications were lost, so something changed.
+				signal(events)
+				continue
+			}
+			return fmt.Errorf("netlink read: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			if relevant(m) {
+				signal(events)
+			}
+		}
+	}
+}
+
+// relevant filters out route churn that doesn't change where traffic goes:
+// only address changes and default routes (destination length 0) count.
+func relevant(m syscall.NetlinkMessage) bool {
+	switch m.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		return true
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		// struct rtmsg: family, dst_len, ...
+		return len(m.Data) >= 2 && m.Data[1] == 0
+	}
+	return false
+}
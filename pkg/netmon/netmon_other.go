@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package netmon
+
+func watch(events chan<- struct{}) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,106 @@
+package outbound
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Behaviors of blocked connections (block = ... of routing rules and acl).
+const (
+	// BlockReset fails the connection at once: the dial is refused, which
+	// the TUN device's system stack answers with an RST.
+	BlockReset = "reset"
+
+	// BlockDrop accepts the connection and never answers, leaving the
+	// application to time out. Slower for it, but gives trackers that
+	// retry on errors nothing to react to.
+	BlockDrop = "drop"
+
+	// BlockHTTP answers port 80 requests with a 403 page and resets
+	// connections to other ports.
+	BlockHTTP = "http"
+)
+
+// forbiddenPage is BlockHTTP's response.
+var forbiddenPage = func() []byte {
+	body := "<html><head><title>403 Forbidden</title></head><body><h1>Blocked</h1><p>This address is blocked by the proxy's rules.</p></body></html>\n"
+	return fmt.Appendf(nil, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: %d\r\nCache-Control: no-store\r\nConnection: close\r\n\r\n%s", len(body), body)
+}()
+
+// Block returns what blocked connections to target get: the stand-in the
+// behavior ("" = BlockReset) calls for, or for resets an error wrapping
+// ErrNotAllowed.
+func Block(target, behavior string) (io.ReadWriteCloser, error) {
+	switch {
+	case BlockRefuses(target, behavior):
+		return nil, fmt.Errorf("destination %s blocked: %w", target, ErrNotAllowed)
+	case behavior == BlockHTTP:
+		return newBlockConn(forbiddenPage), nil
+	}
+	return newBlockConn(nil), nil
+}
+
+// BlockRefuses reports whether Block fails for target rather than
+// returning a stand-in.
+func BlockRefuses(target, behavior string) bool {
+	switch behavior {
+	case BlockDrop:
+		return false
+	case BlockHTTP:
+		_, port, _ := net.SplitHostPort(target)
+		return port != "80"
+	}
+	return true
+}
+
+// blockConn discards what is written to it and, once written to, reads
+// back response, if any. Without one reads wait for Close.
+type blockConn struct {
+	response *bytes.Reader
+
+	writeOnce sync.Once
+	written   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newBlockConn(response []byte) *blockConn {
+	c := &blockConn{written: make(chan struct{}), closed: make(chan struct{})}
+	if response != nil {
+		c.response = bytes.NewReader(response)
+	}
+	return c
+}
+
+func (c *blockConn) Read(p []byte) (int, error) {
+	if c.response == nil {
+		<-c.closed
+		return 0, io.EOF
+	}
+	select {
+	case <-c.written:
+	case <-c.closed:
+		return 0, io.EOF
+	}
+	return c.response.Read(p)
+}
+
+func (c *blockConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	if len(p) > 0 {
+		c.writeOnce.Do(func() { close(c.written) })
+	}
+	return len(p), nil
+}
+
+func (c *blockConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
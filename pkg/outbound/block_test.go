@@ -0,0 +1,56 @@
+package outbound
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBlock(t *testing.T) {
+	for _, behavior := range []string{"", BlockReset} {
+		if _, err := Block("ads.example:443", behavior); !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("Block(%q) = %v, want ErrNotAllowed", behavior, err)
+		}
+	}
+	if _, err := Block("ads.example:443", BlockHTTP); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("http block of port 443: got %v, want ErrNotAllowed", err)
+	}
+
+	conn, err := Block("ads.example:80", BlockHTTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /banner.js HTTP/1.1\r\nHost: ads.example\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %s", resp.Status)
+	}
+
+	conn, err = Block("ads.example:443", BlockDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		read <- err
+	}()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-read:
+		t.Fatalf("dropped connection answered: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn.Close()
+	if err := <-read; err != io.EOF {
+		t.Errorf("read after close: got %v, want EOF", err)
+	}
+}
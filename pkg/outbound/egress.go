@@ -0,0 +1,232 @@
+package outbound
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Largest CIDR accepted in an egress pool (a /24 or a /120).
+const maxPoolSize = 256
+
+// Pool is a set of local source addresses handed out round-robin, so
+// outbound connections are spread over several public IPs.
+type Pool struct {
+	addrs []net.IP
+	next  uint32
+
+	// Timeout bounds each connect (default 10s).
+	Timeout time.Duration
+
+	// Socket is set on every connection's socket.
+	Socket Socket
+}
+
+// ParsePool parses source addresses given as IPs or CIDRs
+// ("203.0.113.8/29" expands to all eight addresses).
+func ParsePool(specs []string) (*Pool, error) {
+	p := &Pool{}
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid egress address %q", spec)
+			}
+			p.addrs = append(p.addrs, ip)
+			continue
+		}
+		ip, ipnet, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress range %q: %v", spec, err)
+		}
+		ones, bits := ipnet.Mask.Size()
+		if bits-ones > 8 {
+			return nil, fmt.Errorf("egress range %q is larger than %d addresses", spec, maxPoolSize)
+		}
+		for ip = ip.Mask(ipnet.Mask); ipnet.Contains(ip); ip = nextIP(ip) {
+			p.addrs = append(p.addrs, ip)
+		}
+	}
+	if len(p.addrs) == 0 {
+		return nil, fmt.Errorf("egress pool is empty")
+	}
+	return p, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	out := make(net.IP, len(ip))
+	copy(out, ip)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i]++
+		if out[i] != 0 {
+			break
+		}
+	}
+	return out
+}
+
+// Next returns the next source address.
+func (p *Pool) Next() net.IP {
+	n := atomic.AddUint32(&p.next, 1)
+	return p.addrs[int(n-1)%len(p.addrs)]
+}
+
+// Dial connects to target from the next source address. The address family
+// of the source decides whether target is resolved to IPv4 or IPv6.
+func (p *Pool) Dial(target string) (io.ReadWriteCloser, error) {
+	ip := p.Next()
+	network := "tcp6"
+	if ip.To4() != nil {
+		network = "tcp4"
+	}
+	d := &net.Dialer{Timeout: timeoutOr(p.Timeout), LocalAddr: &net.TCPAddr{IP: ip}, Control: p.Socket.Control()}
+	return d.Dial(network, target)
+}
+
+// Egress picks the source address pool for each target: the first rule whose
+// hosts match, otherwise the default pool (or Direct when nil).
+type Egress struct {
+	Default *Pool
+	Rules   []EgressRule
+	Direct  Direct
+}
+
+// EgressRule sends targets matching Hosts out of Pool.
+type EgressRule struct {
+	Hosts *HostMatcher
+	Pool  *Pool
+}
+
+func (e *Egress) Dial(target string) (io.ReadWriteCloser, error) {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range e.Rules {
+		if r.Hosts.Match(host) {
+			return r.Pool.Dial(target)
+		}
+	}
+	if e.Default != nil {
+		return e.Default.Dial(target)
+	}
+	return e.Direct.Dial(target)
+}
+
+// HostMatcher matches destination hosts against domains (which also match
+// their subdomains), IP addresses, CIDR ranges and named HostSets.
+type HostMatcher struct {
+	domains  []string
+	suffix   map[string]bool // domains, for lists of thousands
+	exact    map[string]bool
+	keywords []string
+	regexps  []*regexp.Regexp
+	nets     []*net.IPNet
+	sets     []*HostSet
+}
+
+// ParseHostMatcher parses entries like "example.com", "10.0.0.0/8",
+// "192.0.2.1" or "geo:ir" (the HostSet of that name). As in rule-set
+// lists, "full:www.example.com" matches that name only,
+// "keyword:tracker" names containing it, and "regexp:^ads?\." names
+// matching the expression.
+func ParseHostMatcher(entries []string) (*HostMatcher, error) {
+	m := &HostMatcher{suffix: map[string]bool{}, exact: map[string]bool{}}
+	for _, e := range entries {
+		kind, value, _ := strings.Cut(e, ":")
+		switch {
+		case strings.HasPrefix(e, SetPrefix):
+			m.sets = append(m.sets, NamedHostSet(strings.TrimPrefix(e, SetPrefix)))
+		case kind == "full":
+			m.exact[strings.ToLower(strings.TrimSuffix(value, "."))] = true
+		case kind == "keyword":
+			m.keywords = append(m.keywords, strings.ToLower(value))
+		case kind == "regexp":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp %q: %v", value, err)
+			}
+			m.regexps = append(m.regexps, re)
+		case strings.Contains(e, "/"):
+			_, ipnet, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %v", e, err)
+			}
+			m.nets = append(m.nets, ipnet)
+		case net.ParseIP(e) != nil:
+			ip := net.ParseIP(e)
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			m.nets = append(m.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		case e != "":
+			d := strings.ToLower(strings.TrimPrefix(strings.TrimSuffix(e, "."), "*."))
+			m.domains = append(m.domains, d)
+			m.suffix[d] = true
+		}
+	}
+	return m, nil
+}
+
+// Match reports whether host (a domain or IP literal) matches.
+func (m *HostMatcher) Match(host string) bool {
+	for _, s := range m.sets {
+		if s.Match(host) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range m.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if m.exact[host] {
+		return true
+	}
+	for _, k := range m.keywords {
+		if strings.Contains(host, k) {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	// Try host, then each parent domain.
+	for {
+		if m.suffix[host] {
+			return true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return false
+		}
+		host = parent
+	}
+}
+
+// Domains returns the domain entries, lower-cased and without a leading "*.".
+func (m *HostMatcher) Domains() []string {
+	return m.domains
+}
+
+// Networks returns the IP and CIDR entries; single IPs are full-length masks.
+func (m *HostMatcher) Networks() []*net.IPNet {
+	return m.nets
+}
+
+// HasNetworks reports whether any IP or CIDR entries are present, or a
+// HostSet that may hold some.
+func (m *HostMatcher) HasNetworks() bool {
+	return len(m.nets) > 0 || len(m.sets) > 0
+}
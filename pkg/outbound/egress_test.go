@@ -0,0 +1,63 @@
+package outbound
+
+import "testing"
+
+func TestPool(t *testing.T) {
+	p, err := ParsePool([]string{"203.0.113.8/30", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Failed to parse pool: %v", err)
+	}
+	want := []string{"203.0.113.8", "203.0.113.9", "203.0.113.10", "203.0.113.11", "2001:db8::1", "203.0.113.8"}
+	for i, w := range want {
+		if got := p.Next().String(); got != w {
+			t.Errorf("Expected address %d to be %s, got %s", i, w, got)
+		}
+	}
+
+	if _, err := ParsePool([]string{"10.0.0.0/16"}); err == nil {
+		t.Errorf("Expected error for oversized range")
+	}
+}
+
+func TestHostMatcher(t *testing.T) {
+	m, err := ParseHostMatcher([]string{"example.com", "*.test.org", "10.0.0.0/8", "192.0.2.1", "full:www.exact.net", "keyword:tracker", `regexp:^ads?\.`})
+	if err != nil {
+		t.Fatalf("Failed to parse matcher: %v", err)
+	}
+	for host, want := range map[string]bool{
+		"example.com":     true,
+		"www.Example.com": true,
+		"badexample.com":  false,
+		"a.test.org":      true,
+		"10.1.2.3":        true,
+		"192.0.2.1":       true,
+		"192.0.2.2":       false,
+		"www.exact.net":   true,
+		"exact.net":       false,
+		"a.www.exact.net": false,
+		"mytracker.io":    true,
+		"ad.example.org":  true,
+		"bad.example.org": false,
+	} {
+		if got := m.Match(host); got != want {
+			t.Errorf("Expected Match(%q) = %v, got %v", host, want, got)
+		}
+	}
+}
+
+func TestHostSet(t *testing.T) {
+	m, err := ParseHostMatcher([]string{"example.com", "geo:test-ir"})
+	if err != nil {
+		t.Fatalf("Failed to parse matcher: %v", err)
+	}
+	if m.Match("shop.ir") {
+		t.Errorf("Expected an empty set to match nothing")
+	}
+	ir, _ := ParseHostMatcher([]string{"ir", "2.144.0.0/14"})
+	NamedHostSet("test-ir").Store(ir)
+	for host, want := range map[string]bool{"shop.ir": true, "2.144.1.1": true, "example.com": true, "example.org": false} {
+		if got := m.Match(host); got != want {
+			t.Errorf("Expected Match(%q) = %v after the swap, got %v", host, want, got)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Address family preferences for Direct.
+const (
+	// FamilyAuto tries both families, starting with the one the system
+	// resolver lists first (normally IPv6 when the host has IPv6 routes).
+	FamilyAuto = "auto"
+
+	FamilyPreferIPv4 = "prefer-ipv4"
+	FamilyPreferIPv6 = "prefer-ipv6"
+	FamilyIPv4Only   = "ipv4-only"
+	FamilyIPv6Only   = "ipv6-only"
+)
+
+// Default pause between connection attempts (RFC 8305 recommends 250ms).
+const defaultAttemptDelay = 250 * time.Millisecond
+
+// Direct dials targets from this host. Hostnames are resolved and their
+// addresses tried with Happy Eyeballs (RFC 8305): attempts alternate between
+// IPv6 and IPv4 and start AttemptDelay apart, or as soon as the previous one
+// fails, and the first connection to succeed wins. This keeps targets with
+// broken IPv4 or IPv6 reachable without waiting for a timeout.
+type Direct struct {
+	// Family is one of the Family* constants ("" = FamilyAuto).
+	Family string
+
+	// AttemptDelay is the head start each attempt gets before the next
+	// address is tried (default 250ms).
+	AttemptDelay time.Duration
+
+	// Timeout bounds resolving and connecting (default 10s).
+	Timeout time.Duration
+
+	// Resolve, if set, looks up hostnames instead of the system resolver,
+	// e.g. through a cache.
+	Resolve func(ctx context.Context, host string) ([]net.IP, error)
+
+	// LocalAddr is the source address of connections (nil = chosen by the
+	// OS). Only targets of its address family are tried.
+	LocalAddr net.IP
+
+	// Socket is set on every connection's socket.
+	Socket Socket
+}
+
+func (d Direct) Dial(target string) (io.ReadWriteCloser, error) {
+	return d.DialConn(target)
+}
+
+// DialConn is Dial returning the net.Conn.
+func (d Direct) DialConn(target string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutOr(d.Timeout))
+	defer cancel()
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if d.Resolve != nil {
+		if ips, err = d.Resolve(ctx, host); err != nil {
+			return nil, err
+		}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	family := d.Family
+	dialer := &net.Dialer{Control: d.Socket.Control()}
+	if d.LocalAddr != nil {
+		family = FamilyIPv6Only
+		if d.LocalAddr.To4() != nil {
+			family = FamilyIPv4Only
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: d.LocalAddr}
+	}
+	ips = sortAddrs(ips, family)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s allowed by address family %s", host, family)
+	}
+
+	delay := d.AttemptDelay
+	if delay <= 0 {
+		delay = defaultAttemptDelay
+	}
+	return happyDial(ctx, dialer, ips, port, delay)
+}
+
+// FamilyFor maps the client's prefer_ipv6 / ipv4_only knobs to a Family.
+func FamilyFor(preferIPv6, ipv4Only bool) string {
+	switch {
+	case ipv4Only:
+		return FamilyIPv4Only
+	case preferIPv6:
+		return FamilyPreferIPv6
+	}
+	return FamilyAuto
+}
+
+// ResolveUDP resolves dest ("host:port") to one address of the preferred
+// family.
+func ResolveUDP(dest, family string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return net.ResolveUDPAddr("udp", dest)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	ips = sortAddrs(ips, family)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s allowed by address family %s", host, family)
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0].String(), port))
+}
+
+// sortAddrs filters ips by family and interleaves them, preferred family
+// first (RFC 8305 section 4).
+func sortAddrs(ips []net.IP, family string) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	first, second := v6, v4
+	switch family {
+	case FamilyIPv4Only:
+		return v4
+	case FamilyIPv6Only:
+		return v6
+	case FamilyPreferIPv4:
+		first, second = v4, v6
+	case FamilyPreferIPv6:
+	default:
+		if len(ips) > 0 && ips[0].To4() != nil {
+			first, second = v4, v6
+		}
+	}
+
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// happyDial races connection attempts to ips, starting a new one every
+// delay or whenever an attempt fails, and returns the first connection.
+func happyDial(ctx context.Context, dialer *net.Dialer, ips []net.IP, port string, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			results <- result{conn, err}
+		}()
+	}
+
+	start()
+	var firstErr error
+	for pending > 0 {
+		var wait <-chan time.Time
+		if next < len(ips) {
+			wait = time.After(delay)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close connections of attempts that lose the race.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				start()
+			}
+		case <-wait:
+			start()
+		}
+	}
+	return nil, firstErr
+}
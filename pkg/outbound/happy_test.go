@@ -0,0 +1,69 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSortAddrs(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")}
+
+	got := sortAddrs(ips, FamilyAuto)
+	if len(got) != 3 || got[0].String() != "192.0.2.1" || got[1].String() != "2001:db8::1" {
+		t.Errorf("Expected resolver's family first, interleaved, got %v", got)
+	}
+	if got := sortAddrs(ips, FamilyPreferIPv6); got[0].String() != "2001:db8::1" {
+		t.Errorf("Expected IPv6 first, got %v", got)
+	}
+	if got := sortAddrs(ips, FamilyIPv4Only); len(got) != 2 {
+		t.Errorf("Expected only IPv4 addresses, got %v", got)
+	}
+	if got := sortAddrs(ips[:2], FamilyIPv6Only); len(got) != 0 {
+		t.Errorf("Expected no addresses, got %v", got)
+	}
+}
+
+func TestHappyDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// The first address never answers; the second must win after the delay.
+	ips := []net.IP{net.ParseIP("10.255.255.1"), net.ParseIP("127.0.0.1")}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	conn, err := happyDial(ctx, &net.Dialer{}, ips, port, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected connection, got %v", err)
+	}
+	conn.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected fallback within a second, took %v", d)
+	}
+}
+
+func TestDirectLocalAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := Direct{LocalAddr: net.ParseIP("127.0.0.1")}.DialConn(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected connection, got %v", err)
+	}
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected source 127.0.0.1, got %v", ip)
+	}
+	conn.Close()
+	if _, err := (Direct{LocalAddr: net.ParseIP("::1")}).DialConn(ln.Addr().String()); err == nil {
+		t.Errorf("Expected an IPv6 source to skip an IPv4 target")
+	}
+}
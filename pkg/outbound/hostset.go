@@ -0,0 +1,45 @@
+package outbound
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SetPrefix marks host list entries that name a HostSet, e.g. "geo:ir".
+const SetPrefix = "geo:"
+
+// HostSet is a HostMatcher that can be replaced while it is in use, such
+// as a GeoIP or geosite list kept up to date from a URL. Until a matcher
+// is stored it matches nothing.
+type HostSet struct {
+	m atomic.Pointer[HostMatcher]
+}
+
+var (
+	hostSetsMu sync.Mutex
+	hostSets   = map[string]*HostSet{}
+)
+
+// NamedHostSet returns the process-wide set of name, creating an empty one
+// the first time.
+func NamedHostSet(name string) *HostSet {
+	hostSetsMu.Lock()
+	defer hostSetsMu.Unlock()
+	s := hostSets[name]
+	if s == nil {
+		s = &HostSet{}
+		hostSets[name] = s
+	}
+	return s
+}
+
+// Store replaces the set's entries with m.
+func (s *HostSet) Store(m *HostMatcher) {
+	s.m.Store(m)
+}
+
+// Match reports whether host matches the current entries.
+func (s *HostSet) Match(host string) bool {
+	m := s.m.Load()
+	return m != nil && m.Match(host)
+}
@@ -0,0 +1,145 @@
+// Package outbound implements how tunnel streams reach their destination:
+// directly, through an upstream SOCKS5 or HTTP CONNECT proxy, or through
+// an SSH server or Tor.
+package outbound
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer connects to a target address ("host:port") for a tunnel stream.
+type Dialer interface {
+	Dial(target string) (io.ReadWriteCloser, error)
+}
+
+// Default timeout for connecting to targets and upstream proxies.
+const dialTimeout = 10 * time.Second
+
+func timeoutOr(d time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return dialTimeout
+}
+
+// ErrNotAllowed is wrapped by errors for targets refused by server policy.
+var ErrNotAllowed = errors.New("destination not allowed by server policy")
+
+// Parse builds a proxy dialer from a URL:
+//
+//	socks5://[user:pass@]host:port
+//	http://[user:pass@]host:port   (HTTP CONNECT)
+//
+// Other schemes are left to the caller (e.g. "phoenix" in the transport package).
+func Parse(rawURL string) (Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", rawURL)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: pass}
+		}
+		d, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: dialTimeout})
+		if err != nil {
+			return nil, err
+		}
+		return &SOCKS5{dialer: d}, nil
+	case "http":
+		return &HTTPConnect{Addr: u.Host, User: u.User}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// SOCKS5 dials targets through an upstream SOCKS5 proxy.
+type SOCKS5 struct {
+	dialer proxy.Dialer
+}
+
+func (s *SOCKS5) Dial(target string) (io.ReadWriteCloser, error) {
+	return s.dialer.Dial("tcp", target)
+}
+
+// HTTPConnect dials targets through an upstream HTTP proxy using CONNECT.
+type HTTPConnect struct {
+	Addr string
+	User *url.Userinfo // optional Basic auth
+}
+
+func (h *HTTPConnect) Dial(target string) (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", h.Addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: http.Header{},
+	}
+	if h.User != nil {
+		pass, _ := h.User.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(h.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", target, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes the proxy sent right after its CONNECT reply
+// before reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		return tc.CloseWrite()
+	}
+	return nil
+}
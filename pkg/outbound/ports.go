@@ -0,0 +1,70 @@
+package outbound
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortSet is a set of TCP/UDP ports given as single ports or ranges.
+type PortSet struct {
+	ranges [][2]int
+}
+
+// ParsePortSet parses entries like "25" or "135-139".
+func ParsePortSet(entries []string) (*PortSet, error) {
+	ps := &PortSet{}
+	for _, e := range entries {
+		lo, hi, found := strings.Cut(strings.TrimSpace(e), "-")
+		from, err := strconv.Atoi(lo)
+		if err != nil || from < 1 || from > 65535 {
+			return nil, fmt.Errorf("invalid port %q", e)
+		}
+		to := from
+		if found {
+			to, err = strconv.Atoi(hi)
+			if err != nil || to < from || to > 65535 {
+				return nil, fmt.Errorf("invalid port range %q", e)
+			}
+		}
+		ps.ranges = append(ps.ranges, [2]int{from, to})
+	}
+	return ps, nil
+}
+
+// Contains reports whether port is in the set.
+func (ps *PortSet) Contains(port int) bool {
+	for _, r := range ps.ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks reports whether the port of target ("host:port") is in the set.
+// Malformed targets are blocked.
+func (ps *PortSet) Blocks(target string) bool {
+	_, p, err := net.SplitHostPort(target)
+	if err != nil {
+		return true
+	}
+	port, err := strconv.Atoi(p)
+	return err != nil || ps.Contains(port)
+}
+
+// PortFilter refuses targets whose port is in Blocked and passes the rest
+// to Next.
+type PortFilter struct {
+	Blocked *PortSet
+	Next    Dialer
+}
+
+func (f *PortFilter) Dial(target string) (io.ReadWriteCloser, error) {
+	if f.Blocked.Blocks(target) {
+		return nil, fmt.Errorf("destination port of %s is blocked: %w", target, ErrNotAllowed)
+	}
+	return f.Next.Dial(target)
+}
@@ -0,0 +1,25 @@
+package outbound
+
+import "testing"
+
+func TestPortSet(t *testing.T) {
+	ps, err := ParsePortSet([]string{"25", "135-139"})
+	if err != nil {
+		t.Fatalf("Failed to parse ports: %v", err)
+	}
+	for target, want := range map[string]bool{
+		"mail.example.com:25": true,
+		"10.0.0.1:137":        true,
+		"example.com:443":     false,
+		"[2001:db8::1]:139":   true,
+		"no-port":             true,
+	} {
+		if got := ps.Blocks(target); got != want {
+			t.Errorf("Expected Blocks(%q) = %v, got %v", target, want, got)
+		}
+	}
+
+	if _, err := ParsePortSet([]string{"200-100"}); err == nil {
+		t.Errorf("Expected error for reversed range")
+	}
+}
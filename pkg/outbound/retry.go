@@ -0,0 +1,40 @@
+package outbound
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Retry redials targets whose dial failed transiently, up to Retries more
+// times, Delay apart. Refused connections, unknown hosts and policy denials
+// are final and returned at once.
+type Retry struct {
+	Next    Dialer
+	Retries int
+	Delay   time.Duration
+}
+
+func (r *Retry) Dial(target string) (io.ReadWriteCloser, error) {
+	conn, err := r.Next.Dial(target)
+	for i := 0; err != nil && i < r.Retries && retryable(err); i++ {
+		log.Printf("[Outbound] Dial %s failed (%v), retrying (%d/%d)", target, err, i+1, r.Retries)
+		time.Sleep(r.Delay)
+		conn, err = r.Next.Dial(target)
+	}
+	return conn, err
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotAllowed) || errors.Is(err, syscall.ECONNREFUSED) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return true
+}
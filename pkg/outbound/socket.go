@@ -0,0 +1,30 @@
+package outbound
+
+import "syscall"
+
+// Socket holds options set on the sockets of outbound connections, so
+// their traffic can be policy-routed apart from the host's own.
+type Socket struct {
+	// Interface binds sockets to this network interface (e.g. "wg0").
+	Interface string
+
+	// Mark is the firewall mark (SO_MARK) of the sockets, for "ip rule
+	// fwmark" routing. Linux only.
+	Mark int
+}
+
+// Control returns the function to set s on a socket before it connects or
+// binds, for net.Dialer.Control and net.ListenConfig.Control; nil when s
+// sets nothing.
+func (s Socket) Control() func(network, address string, c syscall.RawConn) error {
+	if s == (Socket{}) {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = s.apply(network, int(fd)) }); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,42 @@
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Check reports whether s can be applied on this system.
+func (s Socket) Check() error {
+	if s.Mark != 0 {
+		return errors.New("fwmark is only supported on Linux")
+	}
+	if s.Interface != "" {
+		if _, err := net.InterfaceByName(s.Interface); err != nil {
+			return fmt.Errorf("interface %s: %v", s.Interface, err)
+		}
+	}
+	return nil
+}
+
+func (s Socket) apply(network string, fd int) error {
+	if s.Interface == "" {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(s.Interface)
+	if err != nil {
+		return fmt.Errorf("failed to bind to interface %s: %v", s.Interface, err)
+	}
+	if strings.HasSuffix(network, "6") {
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifi.Index)
+	} else {
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_BOUND_IF, ifi.Index)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind to interface %s: %v", s.Interface, err)
+	}
+	return nil
+}
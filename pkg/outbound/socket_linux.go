@@ -0,0 +1,26 @@
+package outbound
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Check reports whether s can be applied on this system.
+func (s Socket) Check() error {
+	return nil
+}
+
+func (s Socket) apply(network string, fd int) error {
+	if s.Interface != "" {
+		if err := unix.BindToDevice(fd, s.Interface); err != nil {
+			return fmt.Errorf("failed to bind to interface %s: %v", s.Interface, err)
+		}
+	}
+	if s.Mark != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, s.Mark); err != nil {
+			return fmt.Errorf("failed to set fwmark %d: %v", s.Mark, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package outbound
+
+import "errors"
+
+// Check reports whether s can be applied on this system.
+func (s Socket) Check() error {
+	if s != (Socket{}) {
+		return errors.New("outbound interface and fwmark are only supported on Linux and macOS")
+	}
+	return nil
+}
+
+func (s Socket) apply(network string, fd int) error {
+	return s.Check()
+}
@@ -0,0 +1,97 @@
+package outbound
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/adapter/ssh"
+	"phoenix/pkg/crypto"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSSH(t *testing.T) {
+	dir := t.TempDir()
+	keyPEM, _, err := crypto.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	signer, err := gossh.ParsePrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(keyPath, keyPEM, 0600)
+	os.WriteFile(filepath.Join(dir, "authorized_keys"), gossh.MarshalAuthorizedKey(signer.PublicKey()), 0600)
+	server, err := ssh.NewServer(filepath.Join(dir, "host_key"), filepath.Join(dir, "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPEM, _ := os.ReadFile(filepath.Join(dir, "host_key"))
+	host, err := gossh.ParsePrivateKey(hostPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.HandleConnection(conn, Direct{})
+		}
+	}()
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	base := "ssh://alice@" + ln.Addr().String() + "?key=" + keyPath
+	d, err := ParseSSH(base + "&host_key=" + gossh.FingerprintSHA256(host.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	conn, err := d.Dial(echo.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial through SSH: %v", err)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the echo through SSH, got %q, %v", buf, err)
+	}
+	conn.Close()
+
+	wrong, err := ParseSSH(base + "&host_key=SHA256:AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Dial(echo.Addr().String()); err == nil {
+		t.Errorf("Expected a mismatched host key to fail")
+	}
+	if _, err := ParseSSH(base); err == nil {
+		t.Errorf("Expected a URL without host_key or known_hosts to be refused")
+	}
+}
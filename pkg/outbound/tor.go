@@ -0,0 +1,74 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// torSOCKSAddr is the SOCKS port of a Tor daemon with default settings.
+	torSOCKSAddr = "127.0.0.1:9050"
+
+	// torDialTimeout bounds a connection through Tor, circuit building
+	// included, which takes much longer than a direct dial.
+	torDialTimeout = time.Minute
+)
+
+// Tor dials targets through the SOCKS port of a local Tor daemon. Host
+// names go to Tor unresolved, so they are looked up at the exit and .onion
+// services work. With Isolate, every destination host gets circuits of its
+// own (Tor's IsolateSOCKSAuth, on by default), so exits can't link a
+// user's visits to different sites.
+type Tor struct {
+	Addr    string
+	Isolate bool
+}
+
+// ParseTor builds a Tor dialer from a URL:
+//
+//	tor://[host:port][?isolate=false]
+//
+// The SOCKS port defaults to 127.0.0.1:9050.
+func ParseTor(rawURL string) (*Tor, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tor URL: %v", err)
+	}
+	if u.Scheme != "tor" {
+		return nil, fmt.Errorf("tor URL %q needs the tor scheme", rawURL)
+	}
+	t := &Tor{Addr: u.Host, Isolate: u.Query().Get("isolate") != "false"}
+	if t.Addr == "" {
+		t.Addr = torSOCKSAddr
+	}
+	return t, nil
+}
+
+func (t *Tor) Dial(target string) (io.ReadWriteCloser, error) {
+	var auth *proxy.Auth
+	if t.Isolate {
+		host, _, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		// Tor keeps streams with different credentials on different circuits.
+		auth = &proxy.Auth{User: host, Password: "phoenix"}
+	}
+	d, err := proxy.SOCKS5("tcp", t.Addr, auth, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), torDialTimeout)
+	defer cancel()
+	conn, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, fmt.Errorf("tor: %v", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,80 @@
+package outbound
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+)
+
+// torStub is a SOCKS5 server that answers each connection with the user
+// name and destination it was asked for.
+func torStub(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				read := func(n int) []byte {
+					b := make([]byte, n)
+					io.ReadFull(r, b)
+					return b
+				}
+				methods := read(2)
+				offered := read(int(methods[1]))
+				user := ""
+				if offered[0] == 2 || len(offered) > 1 && offered[1] == 2 {
+					conn.Write([]byte{5, 2})
+					head := read(2)
+					user = string(read(int(head[1])))
+					read(int(read(1)[0])) // password
+					conn.Write([]byte{1, 0})
+				} else {
+					conn.Write([]byte{5, 0})
+				}
+				req := read(5) // VER CMD RSV ATYP LEN: domains only
+				host := string(read(int(req[4])))
+				read(2)
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				io.WriteString(conn, user+" "+host)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTor(t *testing.T) {
+	addr := torStub(t)
+	dial := func(rawURL, target string) string {
+		tor, err := ParseTor(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tor.Dial(target)
+		if err != nil {
+			t.Fatalf("Failed to dial %s through Tor: %v", target, err)
+		}
+		defer conn.Close()
+		got, _ := io.ReadAll(conn)
+		return string(got)
+	}
+
+	if got := dial("tor://"+addr, "example.onion:80"); got != "example.onion example.onion" {
+		t.Errorf("Expected an unresolved name on circuits of its own, got %q", got)
+	}
+	if got := dial("tor://"+addr+"?isolate=false", "example.com:443"); got != " example.com" {
+		t.Errorf("Expected no isolation credentials, got %q", got)
+	}
+	if tor, _ := ParseTor("tor://"); tor.Addr != "127.0.0.1:9050" {
+		t.Errorf("Expected the default SOCKS port, got %s", tor.Addr)
+	}
+}
@@ -0,0 +1,116 @@
+// Package pac serves a proxy auto-config (PAC) file built from the client's
+// routing rules, so browsers that only take a PAC URL route the same
+// destinations directly as the client's SOCKS5 inbounds.
+package pac
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"strings"
+)
+
+// Generate returns a PAC file that sends hosts matching direct to DIRECT and
+// everything else to the SOCKS5 proxy at proxyAddr ("host:port"). Like the
+// client, it never resolves names: IP and CIDR entries only match IP
+// literals, and IPv6 ranges are left out because PAC's isInNet is IPv4-only.
+func Generate(proxyAddr string, direct *outbound.HostMatcher) string {
+	domains := []string{}
+	nets := [][2]string{}
+	if direct != nil {
+		domains = append(domains, direct.Domains()...)
+		for _, n := range direct.Networks() {
+			if ip := n.IP.To4(); ip != nil && len(n.Mask) == net.IPv4len {
+				nets = append(nets, [2]string{ip.String(), net.IP(n.Mask).String()})
+			}
+		}
+	}
+	domainsJSON, _ := json.Marshal(domains)
+	netsJSON, _ := json.Marshal(nets)
+	proxy, _ := json.Marshal(fmt.Sprintf("SOCKS5 %s; SOCKS %s", proxyAddr, proxyAddr))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "var directDomains = %s;\n", domainsJSON)
+	fmt.Fprintf(&b, "var directNets = %s;\n\n", netsJSON)
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("\thost = host.toLowerCase();\n")
+	b.WriteString("\tfor (var i = 0; i < directDomains.length; i++) {\n")
+	b.WriteString("\t\tvar d = directDomains[i];\n")
+	b.WriteString("\t\tif (host == d || dnsDomainIs(host, \".\" + d)) return \"DIRECT\";\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif (/^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host)) {\n")
+	b.WriteString("\t\tfor (var i = 0; i < directNets.length; i++) {\n")
+	b.WriteString("\t\t\tif (isInNet(host, directNets[i][0], directNets[i][1])) return \"DIRECT\";\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	fmt.Fprintf(&b, "\treturn %s;\n", proxy)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Handler serves the PAC file of cfg on every path. The proxy address is the
+// local_addr of pac.inbound (or the first SOCKS5 inbound); when that listens
+// on all interfaces (0.0.0.0 or allow_lan), the host the PAC file was
+// requested from is used instead, so other devices on the LAN get an address
+// they can reach.
+func Handler(cfg *config.ClientConfig) (http.Handler, error) {
+	in, err := proxyInbound(cfg)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(in.LocalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("inbound %s: invalid local_addr: %v", in.Name(), err)
+	}
+	direct, err := outbound.ParseHostMatcher(cfg.Routing.DirectHosts())
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing.direct: %v", err)
+	}
+	anyHost := cfg.AllowLAN || host == "" || net.ParseIP(host).IsUnspecified()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := host
+		if anyHost {
+			h = r.Host
+			if rh, _, err := net.SplitHostPort(r.Host); err == nil {
+				h = rh
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Write([]byte(Generate(net.JoinHostPort(h, port), direct)))
+	}), nil
+}
+
+func proxyInbound(cfg *config.ClientConfig) (config.ClientInbound, error) {
+	for _, in := range cfg.Inbounds {
+		if in.Protocol != protocol.ProtocolSOCKS5 {
+			continue
+		}
+		if cfg.PAC.Inbound == "" || in.Name() == cfg.PAC.Inbound {
+			return in, nil
+		}
+	}
+	if cfg.PAC.Inbound != "" {
+		return config.ClientInbound{}, fmt.Errorf("no SOCKS5 inbound tagged %q", cfg.PAC.Inbound)
+	}
+	return config.ClientInbound{}, fmt.Errorf("no SOCKS5 inbound to point the PAC file at")
+}
+
+// ListenAndServe serves the PAC file of cfg on cfg.PAC.Listen.
+func ListenAndServe(cfg *config.ClientConfig) error {
+	h, err := Handler(cfg)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cfg.PAC.Listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PAC] Serving http://%s/proxy.pac", cfg.PAC.Listen)
+	return http.Serve(ln, h)
+}
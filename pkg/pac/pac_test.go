@@ -0,0 +1,25 @@
+package pac
+
+import (
+	"phoenix/pkg/outbound"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	direct, err := outbound.ParseHostMatcher([]string{"*.Example.com", "10.0.0.0/8", "192.0.2.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := Generate("127.0.0.1:1080", direct)
+
+	for _, want := range []string{
+		`var directDomains = ["example.com"];`,
+		`var directNets = [["10.0.0.0","255.0.0.0"],["192.0.2.1","255.255.255.255"]];`,
+		`return "SOCKS5 127.0.0.1:1080; SOCKS 127.0.0.1:1080";`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected PAC file to contain %s, got:\n%s", want, script)
+		}
+	}
+}
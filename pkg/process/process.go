@@ -0,0 +1,61 @@
+// Package process finds the local process behind a TCP connection, so the
+// client can route by application: a connection to the SOCKS5 inbound from
+// 127.0.0.1:54321 belongs to the process whose socket is bound there.
+package process
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrUnsupported is returned by FindTCP on platforms without a lookup.
+var ErrUnsupported = errors.New("process lookup is not supported on this platform")
+
+// ErrNotFound is returned by FindTCP when no local socket has the address.
+var ErrNotFound = errors.New("no process owns the connection")
+
+// FindTCP returns the executable path (or, where that is hidden, the name)
+// of the process owning the TCP socket bound to local.
+func FindTCP(local *net.TCPAddr) (string, error) {
+	if local == nil || local.IP == nil {
+		return "", ErrNotFound
+	}
+	return findTCP(local)
+}
+
+// Matcher matches executables against a list of process names and paths.
+type Matcher struct {
+	names map[string]bool // Lowercase base names, without ".exe"
+	paths map[string]bool // Lowercase full paths
+}
+
+// NewMatcher returns the matcher of entries, or nil when there are none.
+// An entry with a path separator matches that executable only, others any
+// executable of that name: "qbittorrent" matches /usr/bin/qbittorrent and
+// C:\Program Files\qBittorrent\qbittorrent.exe.
+func NewMatcher(entries []string) *Matcher {
+	if len(entries) == 0 {
+		return nil
+	}
+	m := &Matcher{names: map[string]bool{}, paths: map[string]bool{}}
+	for _, e := range entries {
+		e = strings.ToLower(e)
+		if strings.ContainsAny(e, `/\`) {
+			m.paths[e] = true
+		} else {
+			m.names[strings.TrimSuffix(e, ".exe")] = true
+		}
+	}
+	return m
+}
+
+// Match reports whether path, as returned by FindTCP, is in the list.
+func (m *Matcher) Match(path string) bool {
+	path = strings.ToLower(path)
+	if m.paths[path] {
+		return true
+	}
+	name := path[strings.LastIndexAny(path, `/\`)+1:]
+	return m.names[strings.TrimSuffix(name, ".exe")]
+}
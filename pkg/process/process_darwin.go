@@ -0,0 +1,74 @@
+package process
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into the net.inet.tcp.pcblist_n records, from xnu's
+// bsd/netinet/in_pcblist.c (get_pcblist_n). Each record is the xinpcb_n,
+// xsocket_n, two xsockbuf_n, xsockstat_n and xtcpcb_n structs, each
+// rounded up to 8 bytes; xinpcb_n grew in Darwin 22 (macOS 13).
+const (
+	pcbListHeader = 24  // xinpgen
+	xsocketAt     = 104 // After xinpcb_n
+	xtcpcbSize    = 208
+
+	procPIDPathInfo     = 0xb  // PROC_PIDPATHINFO
+	procPIDPathInfoSize = 1024 // PROC_PIDPATHINFO_MAXSIZE
+	procCallNumPIDInfo  = 0x2  // proc_info call number of proc_pidinfo
+)
+
+var pcbSize = func() int {
+	release, _ := unix.Sysctl("kern.osrelease")
+	major, _, _ := strings.Cut(release, ".")
+	if n, _ := strconv.Atoi(major); n >= 22 {
+		return 408
+	}
+	return 384
+}()
+
+// findTCP finds the socket among the kernel's TCP PCBs, then the path of
+// its last user with proc_pidpath.
+func findTCP(local *net.TCPAddr) (string, error) {
+	buf, err := unix.SysctlRaw("net.inet.tcp.pcblist_n")
+	if err != nil {
+		return "", err
+	}
+	v4 := local.IP.To4() != nil
+	size := pcbSize + xtcpcbSize
+	for i := pcbListHeader; i+size <= len(buf); i += size {
+		inp, so := buf[i:], buf[i+xsocketAt:]
+		if int(binary.BigEndian.Uint16(inp[18:20])) != local.Port {
+			continue
+		}
+		var ip net.IP
+		switch flag := inp[44]; { // inp_vflag
+		case flag&0x1 != 0 && v4: // INP_IPV4
+			ip = net.IP(inp[76:80])
+		case flag&0x2 != 0 && !v4: // INP_IPV6
+			ip = net.IP(inp[64:80])
+		default:
+			continue
+		}
+		if ip.Equal(local.IP) {
+			return pidPath(binary.LittleEndian.Uint32(so[68:72])) // so_last_pid
+		}
+	}
+	return "", ErrNotFound
+}
+
+func pidPath(pid uint32) (string, error) {
+	buf := make([]byte, procPIDPathInfoSize)
+	_, _, errno := syscall.Syscall6(syscall.SYS_PROC_INFO, procCallNumPIDInfo, uintptr(pid), procPIDPathInfo, 0, uintptr(unsafe.Pointer(&buf[0])), procPIDPathInfoSize)
+	if errno != 0 {
+		return "", errno
+	}
+	return unix.ByteSliceToString(buf), nil
+}
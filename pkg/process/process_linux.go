@@ -0,0 +1,107 @@
+package process
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findTCP finds the socket's inode in /proc/net/tcp{,6}, then the process
+// holding a descriptor for it under /proc/*/fd.
+func findTCP(local *net.TCPAddr) (string, error) {
+	inode, err := socketInode(local)
+	if err != nil {
+		return "", err
+	}
+	pid, err := socketOwner(inode)
+	if err != nil {
+		return "", err
+	}
+	if path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		return path, nil
+	}
+	// Other users' executables are hidden without CAP_SYS_PTRACE; their
+	// names are not.
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
+
+// socketInode returns the inode of the TCP socket bound to local.
+func socketInode(local *net.TCPAddr) (string, error) {
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(table)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // Header
+		for scanner.Scan() {
+			// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 {
+				continue
+			}
+			ip, port, ok := parseProcAddr(fields[1])
+			if ok && port == local.Port && ip.Equal(local.IP) {
+				f.Close()
+				return fields[9], nil
+			}
+		}
+		f.Close()
+	}
+	return "", ErrNotFound
+}
+
+// parseProcAddr parses a /proc/net/tcp address, "0100007F:1F90": the IP in
+// 32-bit words of host byte order, then the port.
+func parseProcAddr(s string) (net.IP, int, bool) {
+	addr, portHex, ok := strings.Cut(s, ":")
+	b, err := hex.DecodeString(addr)
+	if !ok || err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+	return ip, int(port), true
+}
+
+// socketOwner returns the first process with a descriptor for the socket.
+func socketOwner(inode string) (int, error) {
+	link := "socket:[" + inode + "]"
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue // Exited, or another user's
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(dir, fd.Name())); err == nil && target == link {
+				return pid, nil
+			}
+		}
+	}
+	return 0, ErrNotFound
+}
@@ -0,0 +1,28 @@
+package process
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestFindTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	path, err := FindTCP(conn.LocalAddr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("FindTCP failed: %v", err)
+	}
+	if self, _ := os.Executable(); path != self {
+		t.Errorf("Expected the test binary %s, got %s", self, path)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package process
+
+import "net"
+
+func findTCP(local *net.TCPAddr) (string, error) {
+	return "", ErrUnsupported
+}
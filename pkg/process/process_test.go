@@ -0,0 +1,19 @@
+package process
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	m := NewMatcher([]string{"qBittorrent", "/opt/tools/curl"})
+	for path, want := range map[string]bool{
+		"/usr/bin/qbittorrent":                         true,
+		`C:\Program Files\qBittorrent\qbittorrent.exe`: true,
+		"qbittorrent":                                  true, // A name from /proc/<pid>/comm
+		"/opt/tools/curl":                              true,
+		"/usr/bin/curl":                                false,
+		"/usr/bin/qbittorrent-nox":                     false,
+	} {
+		if got := m.Match(path); got != want {
+			t.Errorf("Match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package process
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetExtendedTcpTable = windows.NewLazySystemDLL("iphlpapi.dll").NewProc("GetExtendedTcpTable")
+
+// tcpTableOwnerPIDAll is TCP_TABLE_OWNER_PID_ALL: every connection, with
+// its owner's PID.
+const tcpTableOwnerPIDAll = 5
+
+// findTCP looks the socket up in GetExtendedTcpTable, then the owner's
+// image with QueryFullProcessImageName.
+func findTCP(local *net.TCPAddr) (string, error) {
+	// A dual-stack socket lists an IPv4 peer in the IPv6 table, mapped.
+	families := []uint32{windows.AF_INET6}
+	if local.IP.To4() != nil {
+		families = []uint32{windows.AF_INET, windows.AF_INET6}
+	}
+	for _, family := range families {
+		table, err := tcpTable(family)
+		if err != nil {
+			return "", err
+		}
+		if pid, ok := tableOwner(table, family, local); ok {
+			return imagePath(pid)
+		}
+	}
+	return "", ErrNotFound
+}
+
+// tcpTable returns the MIB_TCPTABLE_OWNER_PID (or MIB_TCP6TABLE_OWNER_PID)
+// of family.
+func tcpTable(family uint32) ([]byte, error) {
+	var size uint32
+	for range 4 {
+		var buf []byte
+		p := uintptr(0)
+		if size > 0 {
+			buf = make([]byte, size)
+			p = uintptr(unsafe.Pointer(&buf[0]))
+		}
+		r, _, _ := procGetExtendedTcpTable.Call(p, uintptr(unsafe.Pointer(&size)), 0, uintptr(family), tcpTableOwnerPIDAll, 0)
+		switch syscall.Errno(r) {
+		case 0:
+			return buf, nil
+		case windows.ERROR_INSUFFICIENT_BUFFER:
+			continue // Sized now, or grown since
+		default:
+			return nil, syscall.Errno(r)
+		}
+	}
+	return nil, windows.ERROR_INSUFFICIENT_BUFFER
+}
+
+// tableOwner returns the PID of the row bound to local. The table is
+// dwNumEntries, then MIB_TCPROW_OWNER_PID (24 bytes: state, address,
+// port, remote address and port, PID) or MIB_TCP6ROW_OWNER_PID rows (56
+// bytes: address, scope, port, remote address, scope and port, state,
+// PID). Ports are in network byte order.
+func tableOwner(table []byte, family uint32, local *net.TCPAddr) (uint32, bool) {
+	if len(table) < 4 {
+		return 0, false
+	}
+	rowSize, addrAt, addrLen, portAt, pidAt := 24, 4, 4, 8, 20
+	if family == windows.AF_INET6 {
+		rowSize, addrAt, addrLen, portAt, pidAt = 56, 0, 16, 20, 52
+	}
+	n := int(binary.LittleEndian.Uint32(table))
+	for i := 0; i < n && 4+(i+1)*rowSize <= len(table); i++ {
+		row := table[4+i*rowSize:]
+		if int(binary.BigEndian.Uint16(row[portAt:])) != local.Port {
+			continue
+		}
+		if net.IP(row[addrAt : addrAt+addrLen]).Equal(local.IP) {
+			return binary.LittleEndian.Uint32(row[pidAt:]), true
+		}
+	}
+	return 0, false
+}
+
+func imagePath(pid uint32) (string, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &n); err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(buf[:n]), nil
+}
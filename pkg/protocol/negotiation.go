@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the revision of the Phoenix tunnel protocol spoken by this build.
+// Peers that send no version header are treated as Version 0 (pre-negotiation
+// releases) and get the legacy behavior.
+const Version = 1
+
+// Feature names an optional tunnel capability (e.g. compression, padding,
+// mux). A feature is only used on a stream when both peers advertise it, so
+// new capabilities can ship without breaking clients that update slowly.
+type Feature string
+
+// supported lists the features implemented by this build. Packages add to it
+// via RegisterFeature from init functions.
+var supported = map[Feature]bool{}
+
+// RegisterFeature marks f as implemented by this build.
+func RegisterFeature(f Feature) {
+	supported[f] = true
+}
+
+// SupportedFeatures returns the features implemented by this build, sorted.
+func SupportedFeatures() []Feature {
+	out := make([]Feature, 0, len(supported))
+	for f := range supported {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Negotiate returns the features offered by the peer that this build also
+// supports and that are not disabled, preserving the peer's order.
+func Negotiate(offered []Feature, disabled []Feature) []Feature {
+	var out []Feature
+	for _, f := range offered {
+		if supported[f] && !HasFeature(disabled, f) && !HasFeature(out, f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// HasFeature reports whether f is in list.
+func HasFeature(list []Feature, f Feature) bool {
+	for _, x := range list {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFeatures parses a comma-separated feature header value.
+func ParseFeatures(value string) []Feature {
+	var out []Feature
+	for _, part := range strings.Split(value, ",") {
+		if f := Feature(strings.TrimSpace(part)); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// FormatFeatures formats features as a comma-separated header value.
+func FormatFeatures(list []Feature) string {
+	parts := make([]string, len(list))
+	for i, f := range list {
+		parts[i] = string(f)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseVersion parses a protocol version header; missing or malformed values
+// mean Version 0.
+func ParseVersion(value string) int {
+	v, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
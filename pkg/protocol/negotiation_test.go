@@ -0,0 +1,21 @@
+package protocol
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	RegisterFeature("test-a")
+	RegisterFeature("test-b")
+
+	offered := ParseFeatures(" test-b, unknown ,test-a,test-b")
+	got := Negotiate(offered, []Feature{"test-a"})
+	if FormatFeatures(got) != "test-b" {
+		t.Errorf("Expected negotiated features test-b, got %q", FormatFeatures(got))
+	}
+
+	if v := ParseVersion(""); v != 0 {
+		t.Errorf("Expected missing version to parse as 0, got %d", v)
+	}
+	if v := ParseVersion("3"); v != 3 {
+		t.Errorf("Expected version 3, got %d", v)
+	}
+}
@@ -14,6 +14,9 @@ const (
 	ProtocolSSH ProtocolType = "ssh"
 	// ProtocolHTTP represents HTTP proxying (for future use).
 	ProtocolHTTP ProtocolType = "http"
+	// ProtocolReverse represents reverse forwarding: the server accepts
+	// connections on an address for the client (Client.Listen).
+	ProtocolReverse ProtocolType = "reverse"
 )
 
 // Inbound defines a single listener on the client side.
@@ -0,0 +1,4 @@
+// Package sandbox confines the server once it is listening ([hardening]):
+// a chroot, a switch to an unprivileged account, Landlock rules on file
+// access and a seccomp filter of system calls. All of them are Linux only.
+package sandbox
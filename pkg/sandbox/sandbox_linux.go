@@ -0,0 +1,232 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"phoenix/pkg/config"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Apply enforces cfg on the whole process, in the order each step still
+// has the rights it needs: chroot, the switch to cfg.User, Landlock (with
+// read and write added to cfg's paths) and seccomp.
+func Apply(cfg config.Hardening, read, write []string) error {
+	// Accounts are looked up before chroot hides /etc/passwd.
+	uid, gid := -1, -1
+	if cfg.User != "" {
+		var err error
+		if uid, gid, err = lookup(cfg.User, cfg.Group); err != nil {
+			return err
+		}
+	}
+	if cfg.Chroot != "" {
+		if err := syscall.Chroot(cfg.Chroot); err != nil {
+			return fmt.Errorf("chroot %s: %v", cfg.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return err
+		}
+		log.Printf("[Hardening] Confined to %s", cfg.Chroot)
+	}
+	if uid >= 0 && (uid != os.Getuid() || gid != os.Getgid()) {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %v", gid, err)
+		}
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %v", uid, err)
+		}
+		log.Printf("[Hardening] Running as uid %d, gid %d", uid, gid)
+	}
+	if cfg.Landlock {
+		if err := landlock(append(read, cfg.ReadPaths...), append(write, cfg.WritePaths...)); err != nil {
+			return fmt.Errorf("landlock: %v", err)
+		}
+	}
+	if cfg.Seccomp {
+		if err := seccomp(); err != nil {
+			return fmt.Errorf("seccomp: %v", err)
+		}
+		log.Printf("[Hardening] seccomp filter installed")
+	}
+	return nil
+}
+
+// lookup resolves an account and group by name or number.
+func lookup(name, group string) (uid, gid int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %q", name)
+		}
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, fmt.Errorf("unknown group %q", group)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// Landlock rights by ABI version: v1 has the first 13, later versions
+// add renaming across directories, truncation and device ioctls (v4 only
+// adds network rights).
+const (
+	fsV1 = unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1
+	fsV2 = fsV1 | unix.LANDLOCK_ACCESS_FS_REFER
+	fsV3 = fsV2 | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	fsV5 = fsV3 | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+	// fileRights are those a rule on a file, not a directory, may grant.
+	fileRights = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+	readRights = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+)
+
+// landlock limits file access to read and write (with what's beneath
+// them), on every thread. Kernels without Landlock are left as they are.
+func landlock(read, write []string) error {
+	abi, _, errno := syscall.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		log.Printf("[Hardening] Landlock unavailable (%v), file access not restricted", errno)
+		return nil
+	}
+	var handled uint64
+	switch {
+	case abi >= 5:
+		handled = fsV5
+	case abi >= 3:
+		handled = fsV3
+	case abi == 2:
+		handled = fsV2
+	default:
+		handled = fsV1
+	}
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := syscall.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create ruleset: %v", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	allow := func(path string, rights uint64) error {
+		f, err := os.OpenFile(path, os.O_RDONLY|unix.O_PATH|syscall.O_CLOEXEC, 0)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			rights &= fileRights
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: rights & handled, Parent_fd: int32(f.Fd())}
+		if _, _, errno := syscall.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("%s: %v", path, errno)
+		}
+		return nil
+	}
+	for _, path := range read {
+		if err := allow(path, readRights); err != nil {
+			return err
+		}
+	}
+	for _, path := range write {
+		if err := allow(path, handled&^unix.LANDLOCK_ACCESS_FS_EXECUTE); err != nil {
+			return err
+		}
+	}
+	// Rulesets bind the thread that applies them, with no_new_privs set,
+	// so every thread Go has started applies it.
+	for _, call := range [][3]uintptr{{syscall.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1}, {unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0}} {
+		if _, _, errno := syscall.AllThreadsSyscall(call[0], call[1], call[2], 0); errno == syscall.ENOTSUP {
+			return errors.New("needs a build without cgo (CGO_ENABLED=0)")
+		} else if errno != 0 {
+			return fmt.Errorf("restrict: %v", errno)
+		}
+	}
+	log.Printf("[Hardening] Landlock ABI %d restricts file access to %d paths", abi, len(read)+len(write))
+	return nil
+}
+
+// denied are the system calls seccomp refuses with EPERM.
+var denied = []uintptr{
+	unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT, unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE, unix.SYS_KEXEC_LOAD, unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD, unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_OPEN_BY_HANDLE_AT,
+}
+
+// auditArch is the seccomp_data.arch of the build's architecture.
+var auditArch = map[string]uint32{
+	"amd64":   unix.AUDIT_ARCH_X86_64,
+	"arm64":   unix.AUDIT_ARCH_AARCH64,
+	"386":     unix.AUDIT_ARCH_I386,
+	"arm":     unix.AUDIT_ARCH_ARM,
+	"riscv64": unix.AUDIT_ARCH_RISCV64,
+}
+
+// x32Bit marks x32 system calls on amd64, which would bypass the numbers
+// above.
+const x32Bit = 0x40000000
+
+// seccomp installs a filter refusing denied on every thread. Calls made
+// for another architecture are refused too.
+func seccomp() error {
+	arch, ok := auditArch[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(syscall.EPERM))
+	stmt := func(code uint16, k uint32) unix.SockFilter { return unix.SockFilter{Code: code, K: k} }
+	// seccomp_data: nr at offset 0, arch at 4.
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4),
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		stmt(unix.BPF_RET|unix.BPF_K, deny),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0),
+	}
+	// Each check jumps to the deny at the end, past the remaining checks
+	// and the allow.
+	checks := len(denied)
+	if runtime.GOARCH == "amd64" {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: uint8(checks + 1), K: x32Bit})
+	}
+	for _, nr := range denied {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: uint8(checks), K: uint32(nr)})
+		checks--
+	}
+	prog = append(prog, stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW), stmt(unix.BPF_RET|unix.BPF_K, deny))
+
+	// The filter needs no_new_privs, which TSYNC copies to the threads it
+	// installs the filter on.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("no_new_privs: %v", err)
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := syscall.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"phoenix/pkg/config"
+	"syscall"
+	"testing"
+)
+
+// TestSeccomp installs the filter in a child test process, which then
+// tries to run a program.
+func TestSeccomp(t *testing.T) {
+	if os.Getenv("PHOENIX_SANDBOX_CHILD") == "1" {
+		if err := Apply(config.Hardening{Seccomp: true}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec.Command("/bin/true").Run(); !errors.Is(err, syscall.EPERM) {
+			t.Fatalf("Expected exec to fail with EPERM, got %v", err)
+		}
+		return
+	}
+	if _, err := os.Stat("/bin/true"); err != nil {
+		t.Skip("no /bin/true")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSeccomp$")
+	cmd.Env = append(os.Environ(), "PHOENIX_SANDBOX_CHILD=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Child failed: %v\n%s", err, out)
+	}
+	// The filter is the child's alone.
+	if err := exec.Command("/bin/true").Run(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"phoenix/pkg/config"
+)
+
+// Apply refuses any hardening: it is only implemented on Linux.
+func Apply(cfg config.Hardening, read, write []string) error {
+	if cfg.User != "" || cfg.Chroot != "" || cfg.Landlock || cfg.Seccomp {
+		return errors.New("[hardening] is only supported on Linux")
+	}
+	return nil
+}
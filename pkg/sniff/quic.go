@@ -0,0 +1,198 @@
+package sniff
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// initialSalt derives QUIC v1 Initial keys (RFC 9001 section 5.2).
+var initialSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+// maxQUICHello bounds the ClientHello bytes a QUICHello collects.
+const maxQUICHello = 16 << 10
+
+// QUICHello reassembles the ClientHello of a QUIC v1 connection from the
+// client's Initial packets. These are only obfuscated, with keys derived
+// from the connection ID they carry, so any observer can read them.
+// Clients spread the ClientHello over several, and may hide the SNI in a
+// later one.
+type QUICHello struct {
+	dcid   []byte
+	chunks []chunk
+	size   int
+	name   string
+}
+
+type chunk struct {
+	offset uint64
+	data   []byte
+}
+
+// IsQUICInitial reports whether packet looks like a QUIC v1 Initial.
+func IsQUICInitial(packet []byte) bool {
+	// Long header, fixed bit, type Initial, version 1.
+	return len(packet) >= 5 && packet[0]&0xf0 == 0xc0 && binary.BigEndian.Uint32(packet[1:5]) == 1
+}
+
+// Add reads a client packet and returns the SNI once the hello has it.
+// It returns ErrShort while more Initials are needed and ErrNoName for
+// other packets and hellos without a name. An Initial of a new connection
+// (another destination connection ID) starts over.
+func (h *QUICHello) Add(packet []byte) (string, error) {
+	if !IsQUICInitial(packet) {
+		return "", ErrNoName
+	}
+	r := reader(packet[5:])
+	dcidLen, ok := r.u8()
+	if !ok || dcidLen > 20 {
+		return "", ErrNoName
+	}
+	dcid, ok := r.bytes(int(dcidLen))
+	if !ok || !r.skipVec8() { // Source connection ID
+		return "", ErrNoName
+	}
+	tokenLen, ok := r.varint()
+	if !ok || !r.skip(int(min(tokenLen, uint64(len(packet))+1))) {
+		return "", ErrNoName
+	}
+	length, ok := r.varint()
+	if !ok || length > uint64(len(r)) || length < 20 {
+		return "", ErrNoName
+	}
+	if !bytes.Equal(dcid, h.dcid) {
+		*h = QUICHello{dcid: append([]byte(nil), dcid...)}
+	}
+	if h.name != "" {
+		return h.name, nil
+	}
+	pnOffset := len(packet) - len(r)
+	payload, err := openInitial(packet[:pnOffset+int(length)], pnOffset, dcid)
+	if err != nil {
+		return "", ErrNoName
+	}
+	if !h.addFrames(payload) {
+		return "", ErrNoName
+	}
+	name, err := clientHelloName(h.assemble())
+	if err == nil {
+		h.name = name
+	}
+	return name, err
+}
+
+// openInitial removes header protection from and decrypts a client
+// Initial packet whose packet number starts at pnOffset.
+func openInitial(packet []byte, pnOffset int, dcid []byte) ([]byte, error) {
+	secret, err := hkdf.Extract(sha256.New, dcid, initialSalt)
+	if err != nil {
+		return nil, err
+	}
+	client, err := expandLabel(secret, "client in", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	key, err := expandLabel(client, "quic key", 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := expandLabel(client, "quic iv", 12)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := expandLabel(client, "quic hp", 16)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header protection (RFC 9001 section 5.4): a mask from a sample of the
+	// ciphertext hides the packet number length and the packet number.
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(nil), packet[:pnOffset+4]...)
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	var pn uint64
+	for i := range pnLen {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := append([]byte(nil), iv...)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return aead.Open(nil, nonce, packet[pnOffset+pnLen:], header)
+}
+
+// expandLabel is HKDF-Expand-Label of TLS 1.3 with an empty context.
+func expandLabel(secret []byte, label string, length int) ([]byte, error) {
+	label = "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// addFrames keeps the CRYPTO frames of an Initial payload; clients may
+// split and reorder the stream.
+func (h *QUICHello) addFrames(payload []byte) bool {
+	r := reader(payload)
+	for len(r) > 0 {
+		typ, _ := r.varint()
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x06: // CRYPTO
+			offset, ok1 := r.varint()
+			n, ok2 := r.varint()
+			if !ok1 || !ok2 || n > uint64(len(r)) {
+				return false
+			}
+			data, _ := r.bytes(int(n))
+			h.size += len(data)
+			if h.size > maxQUICHello {
+				return false
+			}
+			h.chunks = append(h.chunks, chunk{offset, append([]byte(nil), data...)})
+		default:
+			// ACK and CONNECTION_CLOSE carry nothing wanted; stop at them
+			// rather than parse their fields.
+			r = nil
+		}
+	}
+	return true
+}
+
+// assemble returns the contiguous start of the CRYPTO stream.
+func (h *QUICHello) assemble() []byte {
+	var hello []byte
+	for progress := true; progress; {
+		progress = false
+		for _, c := range h.chunks {
+			if c.offset <= uint64(len(hello)) && c.offset+uint64(len(c.data)) > uint64(len(hello)) {
+				hello = append(hello, c.data[uint64(len(hello))-c.offset:]...)
+				progress = true
+			}
+		}
+	}
+	return hello
+}
@@ -0,0 +1,226 @@
+// Package sniff finds the host name a connection is for in its first
+// bytes: the SNI of a TLS or QUIC ClientHello, or the Host header of an
+// HTTP request. It lets domain rules apply to traffic that arrives
+// addressed to an IP, such as from the TUN device.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrShort is returned while the bytes so far may start a request
+	// whose name is still to come.
+	ErrShort = errors.New("sniff: need more data")
+
+	// ErrNoName is returned for bytes that carry no host name.
+	ErrNoName = errors.New("sniff: no host name")
+)
+
+// Stream returns the host name in the first bytes a client sent on a TCP
+// connection: a TLS ClientHello's SNI or an HTTP/1 request's Host.
+func Stream(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", ErrShort
+	}
+	if b[0] == 0x16 {
+		return tlsRecordName(b)
+	}
+	return httpHost(b)
+}
+
+// tlsRecordName reads the SNI of a ClientHello in TLS records.
+func tlsRecordName(b []byte) (string, error) {
+	// Concatenate the handshake records; a large ClientHello (post-quantum
+	// key shares) spans several.
+	var hello []byte
+	for len(b) > 0 {
+		if len(b) < 5 {
+			break
+		}
+		if b[0] != 0x16 || b[1] != 3 {
+			return "", ErrNoName
+		}
+		n := int(binary.BigEndian.Uint16(b[3:5]))
+		if len(b) < 5+n {
+			hello = append(hello, b[5:]...)
+			break
+		}
+		hello = append(hello, b[5:5+n]...)
+		b = b[5+n:]
+	}
+	name, err := clientHelloName(hello)
+	if err == ErrShort && len(hello) >= 4 {
+		if total := 4 + int(hello[1])<<16 | int(hello[2])<<8 | int(hello[3]); len(hello) >= total {
+			return "", ErrNoName
+		}
+	}
+	return name, err
+}
+
+// clientHelloName reads the server_name extension of a ClientHello
+// handshake message, returning ErrShort if b ends before the extension.
+func clientHelloName(b []byte) (string, error) {
+	r := reader(b)
+	typ, ok := r.u8()
+	if !ok {
+		return "", ErrShort
+	}
+	if typ != 1 { // client_hello
+		return "", ErrNoName
+	}
+	if !r.skip(3 + 2 + 32) { // Length, legacy_version, random
+		return "", ErrShort
+	}
+	if !r.skipVec8() || !r.skipVec16() || !r.skipVec8() { // Session ID, cipher suites, compression
+		return "", ErrShort
+	}
+	extLen, ok := r.u16()
+	if !ok {
+		return "", ErrShort
+	}
+	short := len(r) < int(extLen)
+	r = r[:min(len(r), int(extLen))]
+	for {
+		if len(r) == 0 && !short {
+			return "", ErrNoName
+		}
+		typ, ok1 := r.u16()
+		n, ok2 := r.u16()
+		if !ok1 || !ok2 {
+			return "", ErrShort
+		}
+		ext, ok := r.bytes(int(n))
+		if !ok {
+			return "", ErrShort
+		}
+		if typ != 0 { // server_name
+			continue
+		}
+		e := reader(ext)
+		if _, ok := e.u16(); !ok { // server_name_list length
+			return "", ErrNoName
+		}
+		for {
+			nameType, ok1 := e.u8()
+			n, ok2 := e.u16()
+			name, ok3 := e.bytes(int(n))
+			if !ok1 || !ok2 || !ok3 {
+				return "", ErrNoName
+			}
+			if nameType == 0 { // host_name
+				return validName(string(name))
+			}
+		}
+	}
+}
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// httpHost reads the Host header of an HTTP/1 request.
+func httpHost(b []byte) (string, error) {
+	start := string(b[:min(len(b), 8)])
+	method := false
+	for _, m := range httpMethods {
+		if strings.HasPrefix(start, m) {
+			method = true
+			break
+		} else if strings.HasPrefix(m, start) {
+			return "", ErrShort
+		}
+	}
+	if !method {
+		return "", ErrNoName
+	}
+	head, _, complete := bytes.Cut(b, []byte("\r\n\r\n"))
+	lines := strings.Split(string(head), "\r\n")
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "host") {
+			host := strings.TrimSpace(value)
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return validName(strings.Trim(host, "[]"))
+		}
+	}
+	if !complete {
+		return "", ErrShort
+	}
+	return "", ErrNoName
+}
+
+// validName returns name if it is a host name; IP literals carry no more
+// than the address already did.
+func validName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" || len(name) > 253 || net.ParseIP(name) != nil || strings.ContainsAny(name, " /\\@") {
+		return "", ErrNoName
+	}
+	return name, nil
+}
+
+// reader reads big-endian fields off a byte slice.
+type reader []byte
+
+func (r *reader) bytes(n int) ([]byte, bool) {
+	if n < 0 || len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *reader) skip(n int) bool {
+	_, ok := r.bytes(n)
+	return ok
+}
+
+func (r *reader) u8() (byte, bool) {
+	b, ok := r.bytes(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *reader) u16() (uint16, bool) {
+	b, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+func (r *reader) skipVec8() bool {
+	n, ok := r.u8()
+	return ok && r.skip(int(n))
+}
+
+func (r *reader) skipVec16() bool {
+	n, ok := r.u16()
+	return ok && r.skip(int(n))
+}
+
+// varint reads a QUIC variable-length integer (RFC 9000 section 16).
+func (r *reader) varint() (uint64, bool) {
+	first, ok := r.u8()
+	if !ok {
+		return 0, false
+	}
+	n := 1 << (first >> 6)
+	v := uint64(first & 0x3f)
+	rest, ok := r.bytes(n - 1)
+	if !ok {
+		return 0, false
+	}
+	for _, b := range rest {
+		v = v<<8 | uint64(b)
+	}
+	return v, true
+}
@@ -0,0 +1,104 @@
+package sniff
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// clientHello returns the first bytes a TLS client sends for serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	buf := make([]byte, 0, 16<<10)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, err := server.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if _, serr := Stream(buf); serr != ErrShort || err != nil {
+			client.Close()
+			return buf
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	hello := clientHello(t, "Example.COM")
+	if name, err := Stream(hello); err != nil || name != "example.com" {
+		t.Fatalf("tls: got %q, %v", name, err)
+	}
+	if _, err := Stream(hello[:40]); err != ErrShort {
+		t.Errorf("truncated hello: got %v, want ErrShort", err)
+	}
+	if _, err := Stream(clientHello(t, "")); err != ErrNoName {
+		t.Errorf("hello without sni: got %v, want ErrNoName", err)
+	}
+
+	tests := []struct {
+		in   string
+		name string
+		err  error
+	}{
+		{"GET / HTTP/1.1\r\nUser-Agent: x\r\nhost: www.example.com:8080\r\n\r\n", "www.example.com", nil},
+		{"POST /upload HTTP/1.1\r\nHost: api.example.com\r\n", "api.example.com", nil},
+		{"GET / HTTP/1.1\r\nAccept: */*\r\n", "", ErrShort},
+		{"GE", "", ErrShort},
+		{"GET / HTTP/1.1\r\nHost: 10.0.0.1\r\n\r\n", "", ErrNoName},
+		{"GET / HTTP/1.0\r\n\r\n", "", ErrNoName},
+		{"SSH-2.0-OpenSSH_9.6\r\n", "", ErrNoName},
+	}
+	for _, tt := range tests {
+		if name, err := Stream([]byte(tt.in)); name != tt.name || err != tt.err {
+			t.Errorf("Stream(%q) = %q, %v; want %q, %v", tt.in, name, err, tt.name, tt.err)
+		}
+	}
+}
+
+func TestQUIC(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go quic.DialAddr(ctx, pc.LocalAddr().String(), &tls.Config{ServerName: "quic.example.com", NextProtos: []string{"h3"}}, nil)
+
+	// Read the client's Initials until one completes the name; quic-go,
+	// like browsers, puts the SNI in a later packet than the hello's start.
+	var h QUICHello
+	var first []byte
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	name, err := "", ErrShort
+	for err == ErrShort {
+		buf := make([]byte, 2048)
+		n, _, rerr := pc.ReadFrom(buf)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if first == nil {
+			first = buf[:n]
+		}
+		name, err = h.Add(buf[:n])
+	}
+	if err != nil || name != "quic.example.com" {
+		t.Fatalf("got %q, %v", name, err)
+	}
+	if name, err := h.Add(first); name != "quic.example.com" || err != nil {
+		t.Errorf("retransmitted initial: got %q, %v", name, err)
+	}
+
+	var fresh QUICHello
+	first[len(first)/2] ^= 0xff
+	if _, err := fresh.Add(first); err != ErrNoName {
+		t.Errorf("corrupted packet: got %v, want ErrNoName", err)
+	}
+	if _, err := fresh.Add([]byte{0x40, 1, 2, 3}); err != ErrNoName {
+		t.Errorf("short header packet: got %v, want ErrNoName", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package systemd
+
+import "net"
+
+// Listeners returns nil: socket activation is a Linux feature.
+func Listeners() ([]net.Listener, error) {
+	return nil, nil
+}
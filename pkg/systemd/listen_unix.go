@@ -0,0 +1,46 @@
+//go:build unix
+
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor systemd passes (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// Listeners returns the sockets systemd passed under socket activation
+// (LISTEN_FDS, see sd_listen_fds(3)), in the order of the .socket unit, or
+// nil when there are none for this process. The variables are unset so
+// child processes don't take the sockets for theirs.
+func Listeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	lns := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener holds a copy
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket-activated fd %d: %v", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
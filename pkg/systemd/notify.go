@@ -0,0 +1,84 @@
+// Package systemd implements the small subset of the systemd service
+// protocol Phoenix needs (sd_notify, the watchdog and socket activation)
+// without linking libsystemd. Every function is a no-op when the process is
+// not supervised by systemd, so callers don't need to check.
+package systemd
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states understood by systemd (see sd_notify(3)).
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket named by $NOTIFY_SOCKET. It returns false
+// without error when no notification socket is configured.
+func Notify(state string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return false, nil
+	}
+
+	// Abstract namespace sockets are announced with a leading "@".
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured by WatchdogSec=,
+// or 0 if the watchdog is disabled or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog pings the systemd watchdog at half the configured interval
+// for as long as healthy returns nil. A failed health check skips the ping,
+// so a wedged process is restarted by systemd once the timeout elapses.
+// It returns immediately if the watchdog is not enabled.
+func StartWatchdog(healthy func() error) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	log.Printf("[systemd] Watchdog enabled (timeout %v)", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if healthy != nil {
+				if err := healthy(); err != nil {
+					log.Printf("[systemd] Health check failed, skipping watchdog ping: %v", err)
+					continue
+				}
+			}
+			if _, err := Notify(Watchdog); err != nil {
+				log.Printf("[systemd] Watchdog ping failed: %v", err)
+			}
+		}
+	}()
+}
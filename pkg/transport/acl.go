@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"slices"
+	"sync"
+	"time"
+)
+
+// aclRule is a parsed config.ACLRule.
+type aclRule struct {
+	targetMatch
+	users    []string
+	throttle float64 // Bytes per second; 0 = block
+	block    string  // Behavior when blocking
+	when     schedule
+
+	mu    sync.Mutex
+	paces map[string]*pacer // By user: a user's throttled streams share the rate
+}
+
+// newACL parses acl, evaluated in cfg's timezone.
+func newACL(cfg *config.ServerConfig) ([]*aclRule, error) {
+	if len(cfg.ACL) == 0 {
+		return nil, nil
+	}
+	loc, err := config.LoadTimezone(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*aclRule
+	for i, r := range cfg.ACL {
+		m, err := newTargetMatch(r.Hosts, r.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("acl rule %d: %v", i, err)
+		}
+		when, err := newSchedule(r.Schedule, loc)
+		if err != nil {
+			return nil, fmt.Errorf("acl rule %d: %v", i, err)
+		}
+		rule := &aclRule{targetMatch: m, users: r.Users, block: r.Block, when: when, paces: map[string]*pacer{}}
+		if r.Action == "throttle" {
+			rule.throttle = float64(r.Rate)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// aclFor returns the first rule that matches user's ("" outside the user
+// table) target and is in schedule now, nil for none.
+func (s *Server) aclFor(user, target string) *aclRule {
+	if target == "" {
+		return nil
+	}
+	now := time.Now()
+	for _, r := range s.acl {
+		if (len(r.users) == 0 || slices.Contains(r.users, user)) && r.matches(target) && r.when.active(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+// aclBlock returns the block rule that refuses user's target now, nil for
+// none.
+func (s *Server) aclBlock(user, target string) *aclRule {
+	if r := s.aclFor(user, target); r != nil && r.throttle == 0 {
+		return r
+	}
+	return nil
+}
+
+// aclBlocks reports whether a block rule refuses user's target now.
+func (s *Server) aclBlocks(user, target string) bool {
+	return s.aclBlock(user, target) != nil
+}
+
+// pace returns user's pacer of the rule.
+func (r *aclRule) pace(user string) *pacer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.paces[user]
+	if p == nil {
+		p = &pacer{rate: r.throttle}
+		r.paces[user] = p
+	}
+	return p
+}
+
+// ruleDialer enforces the block rules of [[acl]] on every dial, including
+// targets only known after a server-side SOCKS5 handshake. Blocked dials
+// get the rule's stand-in connection or fail.
+type ruleDialer struct {
+	server *Server
+	user   string
+	next   outbound.Dialer
+}
+
+func (d *ruleDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	if r := d.server.aclBlock(d.user, target); r != nil {
+		log.Printf("Blocked destination %s: acl", target)
+		return outbound.Block(target, r.block)
+	}
+	return d.next.Dial(target)
+}
+
+// throttledStream paces a stream while its throttle rule is in schedule.
+type throttledStream struct {
+	io.ReadWriteCloser
+	rule *aclRule
+	pace *pacer
+}
+
+func (s *throttledStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	if n > 0 && s.rule.when.active(time.Now()) {
+		s.pace.wait(n)
+	}
+	return n, err
+}
+
+func (s *throttledStream) Write(p []byte) (int, error) {
+	if s.rule.when.active(time.Now()) {
+		s.pace.wait(len(p))
+	}
+	return s.ReadWriteCloser.Write(p)
+}
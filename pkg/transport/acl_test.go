@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"phoenix/pkg/config"
+	"testing"
+	"time"
+)
+
+func TestACL(t *testing.T) {
+	now := time.Now().UTC()
+	inside := config.Schedule{From: now.Add(-time.Hour).Format("15:04"), To: now.Add(time.Hour).Format("15:04")}
+	outside := config.Schedule{From: now.Add(time.Hour).Format("15:04"), To: now.Add(2 * time.Hour).Format("15:04")}
+	cfg := &config.ServerConfig{Timezone: "UTC", ACL: []config.ACLRule{
+		{Ports: []string{"3074"}, Schedule: []config.Schedule{inside}},
+		{Hosts: []string{"example.com"}, Schedule: []config.Schedule{outside}},
+		{Users: []string{"kid"}, Hosts: []string{"games.example"}},
+		{Hosts: []string{"mirror.example"}, Action: "throttle", Rate: 1 << 20},
+	}}
+	acl, err := newACL(cfg)
+	if err != nil {
+		t.Fatalf("Failed to parse acl: %v", err)
+	}
+	s := &Server{Config: cfg, acl: acl}
+	for _, tc := range []struct {
+		user, target string
+		want         bool
+	}{
+		{"", "203.0.113.1:3074", true},
+		{"", "example.com:443", false}, // Out of schedule
+		{"kid", "games.example:443", true},
+		{"parent", "games.example:443", false},
+		{"", "mirror.example:443", false}, // Throttled, not blocked
+	} {
+		if got := s.aclBlocks(tc.user, tc.target); got != tc.want {
+			t.Errorf("aclBlocks(%q, %s) = %v, want %v", tc.user, tc.target, got, tc.want)
+		}
+	}
+	if r := s.aclFor("", "mirror.example:443"); r == nil || r.throttle != 1<<20 || r.pace("") != r.pace("") {
+		t.Errorf("Expected a shared throttle for mirror.example, got %+v", r)
+	}
+}
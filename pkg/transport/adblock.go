@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/dns"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/outbound"
+)
+
+// adBlock is the parsed [adblock] section.
+type adBlock struct {
+	hosts *outbound.HostMatcher
+	allow *outbound.HostMatcher // nil = no exceptions
+	block string
+}
+
+// newAdBlock returns nil when cfg blocks nothing.
+func newAdBlock(cfg config.ClientAdBlock) (*adBlock, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, nil
+	}
+	hosts, err := outbound.ParseHostMatcher(cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	a := &adBlock{hosts: hosts, block: cfg.Block}
+	if len(cfg.Allow) > 0 {
+		if a.allow, err = outbound.ParseHostMatcher(cfg.Allow); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// blocks reports whether host is blocked; a nil adBlock blocks nothing.
+func (a *adBlock) blocks(host string) bool {
+	if a == nil || host == "" {
+		return false
+	}
+	return a.hosts.Match(host) && (a.allow == nil || !a.allow.Match(host))
+}
+
+// blocksTarget is blocks for a "host:port" target.
+func (a *adBlock) blocksTarget(target string) bool {
+	host, _, err := net.SplitHostPort(target)
+	return err == nil && a.blocks(host)
+}
+
+// answer returns the NXDOMAIN response to a DNS query to port 53 for a
+// blocked name, nil for other datagrams.
+func (a *adBlock) answer(dest string, payload []byte) []byte {
+	if a == nil {
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(dest); err != nil || port != "53" {
+		return nil
+	}
+	name, err := dns.QueryName(payload)
+	if err != nil || !a.blocks(name) {
+		return nil
+	}
+	resp, err := dns.NXDomain(payload)
+	if err != nil {
+		return nil
+	}
+	loglevel.Debugf("[AdBlock] Blocked query for %s", name)
+	return resp
+}
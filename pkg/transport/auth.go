@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"slices"
+)
+
+// Authenticator decides whom a stream's credentials belong to. It replaces
+// the server's auth_token, users and user_db checks on every endpoint
+// (authorized_clients still gates the TLS handshake), so embedders can
+// authenticate against LDAP, OAuth or a database of their own.
+//
+// ValidateToken returns the user the stream runs as, with the user's
+// protocols, ACL, egress and quota applied as for a configured user;
+// a user without a Name gets the server-wide permissions. An error
+// wrapping ErrUnauthorized refuses the stream with 401; other errors mean
+// the authenticator itself failed, and ask the client to retry (503). It
+// is called for every stream, from many goroutines at once.
+type Authenticator interface {
+	ValidateToken(ctx context.Context, token string, meta AuthMeta) (config.User, error)
+}
+
+// AuthMeta describes the stream being authenticated.
+type AuthMeta struct {
+	Remote        string // The client's address
+	ServerName    string // TLS SNI, or the Host over h2c
+	ClientKey     string // Base64 Ed25519 key of the mTLS client certificate; "" without one
+	ClientVersion string
+	Protocol      protocol.ProtocolType
+	Target        string // "" when the server handles the handshake (SOCKS5, SSH)
+}
+
+// staticAuth is the built-in Authenticator: the auth_token, the user
+// table and authorized_clients of one endpoint.
+type staticAuth struct {
+	cfg   *config.ServerConfig
+	users *userTable // nil = no user table
+}
+
+// NewStaticAuthenticator returns the server's built-in Authenticator for
+// the auth_token, users and authorized_clients of cfg, for Authenticators
+// that fall back to it.
+func NewStaticAuthenticator(cfg *config.ServerConfig) (Authenticator, error) {
+	a := staticAuth{cfg: cfg}
+	if len(cfg.Users) > 0 {
+		users, err := newUserTable(cfg, outbound.Direct{}, cfg.Users)
+		if err != nil {
+			return nil, err
+		}
+		a.users = users
+	}
+	return a, nil
+}
+
+func (a staticAuth) ValidateToken(_ context.Context, token string, meta AuthMeta) (config.User, error) {
+	cfg := a.cfg.Security
+	globalOK := cfg.AuthToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AuthToken)) == 1
+
+	if a.users == nil {
+		if cfg.AuthToken == "" || globalOK {
+			return config.User{}, nil
+		}
+		return config.User{}, ErrUnauthorized
+	}
+	if u := a.users.lookup(token, meta.ClientKey); u != nil {
+		return u.cfg, nil
+	}
+	// mTLS already verified the key; authorized_clients entries that aren't
+	// users keep the server-wide permissions. Keys of users that were
+	// disabled or deleted since don't.
+	if globalOK || meta.ClientKey != "" && slices.Contains(cfg.AuthorizedClientKeys, meta.ClientKey) {
+		return config.User{}, nil
+	}
+	return config.User{}, ErrUnauthorized
+}
+
+// authenticate checks the stream's credentials with the server's
+// Authenticator. It returns the matching user, nil for streams with the
+// server-wide permissions (the global auth_token, an authorized_clients
+// key or an open server).
+func (s *Server) authenticate(r *http.Request, meta streamMeta, clientVersion string) (*user, error) {
+	am := AuthMeta{Remote: r.RemoteAddr, ServerName: r.Host, ClientVersion: clientVersion, Protocol: protocol.ProtocolType(meta.Protocol), Target: meta.Target}
+	if r.TLS != nil {
+		am.ServerName = r.TLS.ServerName
+		if len(r.TLS.PeerCertificates) > 0 {
+			if pub, ok := r.TLS.PeerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+				am.ClientKey = base64.StdEncoding.EncodeToString(pub)
+			}
+		}
+	}
+	auth := s.auth
+	if auth == nil {
+		auth = staticAuth{cfg: s.Config, users: s.users}
+	}
+	cu, err := auth.ValidateToken(r.Context(), meta.Token, am)
+	if err != nil || cu.Name == "" {
+		return nil, err
+	}
+	return s.users.resolve(cu)
+}
@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"phoenix/pkg/adapter/ssh"
+	"phoenix/pkg/config"
+	"slices"
+	"time"
+)
+
+// helloTimeout bounds reading a connection's ClientHello.
+const helloTimeout = 10 * time.Second
+
+// backend is the web site behind the endpoint ([fallback]): a web server
+// at addr, or the static files of a directory. Requests and connections
+// that aren't Phoenix tunnels go to it, so probing the port finds an
+// ordinary web site.
+type backend struct {
+	addr    string // Empty for a directory
+	tls     bool
+	handler http.Handler
+}
+
+func newBackend(cfg config.ServerFallback) *backend {
+	if cfg.Root != "" {
+		return &backend{handler: http.FileServer(siteDir{http.Dir(cfg.Root)})}
+	}
+	b := &backend{addr: cfg.Backend, tls: cfg.BackendTLS}
+	target := &url.URL{Scheme: "http", Host: cfg.Backend}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.BackendTLS {
+		target.Scheme = "https"
+		// A local peer chosen by the operator; its certificate is for the
+		// public name, not the address we dial.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	b.handler = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+		},
+		Transport: transport,
+		ErrorLog:  log.New(log.Writer(), "[Fallback] ", log.Flags()),
+	}
+	return b
+}
+
+// ServeHTTP answers a request that isn't a tunnel stream.
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.handler.ServeHTTP(w, r)
+}
+
+// siteDir serves a directory like a web server would: directories without
+// an index.html are not found rather than listed.
+type siteDir struct {
+	http.FileSystem
+}
+
+func (d siteDir) Open(name string) (http.File, error) {
+	f, err := d.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if st, err := f.Stat(); err == nil && st.IsDir() {
+		index, err := d.FileSystem.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, fs.ErrNotExist
+		}
+		index.Close()
+	}
+	return f, nil
+}
+
+// relay connects conn to the backend byte for byte.
+func (b *backend) relay(conn net.Conn) {
+	dest, err := net.DialTimeout("tcp", b.addr, 10*time.Second)
+	if err != nil {
+		log.Printf("[Fallback] Failed to reach backend %s: %v", b.addr, err)
+		conn.Close()
+		return
+	}
+	ssh.Relay(conn, dest)
+}
+
+// sniffListener reads the ClientHello of each accepted connection before
+// the TLS listener gets it. Connections offering h2 are handed on with the
+// hello replayed; the rest go to the backend: untouched when it speaks TLS,
+// decrypted with tlsConfig otherwise.
+type sniffListener struct {
+	net.Listener
+	backend   *backend
+	tlsConfig *tls.Config
+
+	conns chan net.Conn
+	err   error // Set before done is closed
+	done  chan struct{}
+}
+
+func newSniffListener(ln net.Listener, b *backend, tlsConfig *tls.Config) *sniffListener {
+	cfg := tlsConfig.Clone()
+	cfg.NextProtos = []string{"http/1.1"}
+	l := &sniffListener{
+		Listener:  ln,
+		backend:   b,
+		tlsConfig: cfg,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *sniffListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.sniff(conn)
+	}
+}
+
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *sniffListener) sniff(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	protos, hello, err := peekClientHello(conn)
+	conn.SetReadDeadline(time.Time{})
+	replay := &replayConn{Conn: conn, buf: hello}
+
+	switch {
+	case err == nil && slices.Contains(protos, "h2"):
+		select {
+		case l.conns <- replay:
+		case <-l.done:
+			conn.Close()
+		}
+	case err == nil && !l.backend.tls:
+		tlsConn := tls.Server(replay, l.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return
+		}
+		l.backend.relay(tlsConn)
+	default:
+		// Not sure what it is: let the backend answer, e.g. with its
+		// "plain HTTP request sent to HTTPS port" page.
+		l.backend.relay(replay)
+	}
+}
+
+// errHelloRead stops the handshake of peekClientHello once the ClientHello
+// has been parsed.
+var errHelloRead = errors.New("client hello read")
+
+// peekClientHello reads conn's ClientHello and returns the offered ALPN
+// protocols together with every byte read, for replaying. The error is nil
+// only for a well-formed hello.
+func peekClientHello(conn net.Conn) ([]string, []byte, error) {
+	rec := &recordingConn{Conn: conn}
+	var protos []string
+	err := tls.Server(rec, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			protos = hello.SupportedProtos
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if errors.Is(err, errHelloRead) {
+		err = nil
+	}
+	return protos, rec.buf.Bytes(), err
+}
+
+// recordingConn keeps what is read from it and discards writes, such as
+// the alert that aborts peekClientHello's handshake.
+type recordingConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) { return len(p), nil }
+
+// replayConn returns buf before reading from the connection.
+type replayConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// CloseWrite lets relays half-close TCP connections.
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
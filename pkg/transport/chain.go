@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"io"
+	"log"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"time"
+)
+
+// chainDialer returns the TCP dialer for cfg's connection, which is
+// tunneled through cfg.Chain (entry first): each hop's connection is a
+// stream through the hop before it. The first server, the entry hop or
+// RemoteAddr itself, is reached with entry. The hops' clients are returned
+// entry first.
+func chainDialer(cfg *config.ClientConfig, entry func(network, addr string) (net.Conn, error)) (func(network, addr string) (net.Conn, error), []*Client) {
+	dial := entry
+	var hops []*Client
+	for i, hop := range cfg.Chain {
+		hc := newClient(hop.ClientConfig(), dial)
+		hops = append(hops, hc)
+		log.Printf("[Chain] Hop %d: %s", i+1, hop.RemoteAddr)
+		dial = func(network, addr string) (net.Conn, error) {
+			stream, err := hc.Dial(protocol.ProtocolSOCKS5, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &streamConn{ReadWriteCloser: stream, local: "chain", remote: addr}, nil
+		}
+	}
+	return dial, hops
+}
+
+// streamConn presents a tunnel stream as a net.Conn so TLS and HTTP/2 to the
+// next hop can run over it. Deadlines are not supported; the hop's own
+// connection carries the timeouts.
+type streamConn struct {
+	io.ReadWriteCloser
+	local, remote string
+}
+
+type chainAddr string
+
+func (a chainAddr) Network() string { return "phoenix" }
+func (a chainAddr) String() string  { return string(a) }
+
+func (c *streamConn) LocalAddr() net.Addr                { return chainAddr(c.local) }
+func (c *streamConn) RemoteAddr() net.Addr               { return chainAddr(c.remote) }
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
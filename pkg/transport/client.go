@@ -1,6 +1,28 @@
+// Package transport carries the tunnel: the client multiplexes its inbounds'
+// streams over HTTP/2 to the server, which authenticates them and relays
+// them to their targets.
+//
+// Embedders drive the client with Client.Close and Shutdown, which stop the
+// inbounds, control streams and background loops and drain the open
+// streams until a deadline; Client.OnProgress and Stream.OnProgress, which
+// report the bytes moved and rates per stream; Client.Listen, a listener of
+// the connections the server accepts on one of its reverse_listen
+// addresses; and Client.SetDialer and SetResolver, which replace how the
+// socket to the (entry) server is opened or its name resolved, e.g. to
+// protect it from a VPN. Routing rules with action = "outbound" go to the
+// [[routing.outbounds]] upstreams (HTTP CONNECT, SOCKS5, WireGuard, SSH or
+// Tor) by tag.
+//
+// ServeWith runs the server with ServerOptions: an Authenticator in place
+// of the auth_token, users and user_db checks (NewStaticAuthenticator is
+// the built-in one), a TargetDialer in place of the direct dials, egress
+// and outbound_proxy (blocked ports, user ACLs, acl rules and dial retries
+// still apply; UDP stays direct), a Recorder for the streams of users who
+// consent to it, and a Ready callback for once the server is serving.
 package transport
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
@@ -11,9 +33,16 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"phoenix/pkg/adapter/socks5"
 	"phoenix/pkg/config"
 	"phoenix/pkg/crypto"
+	"phoenix/pkg/dns"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/process"
 	"phoenix/pkg/protocol"
+	"phoenix/pkg/version"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +51,25 @@ import (
 	"golang.org/x/net/http2"
 )
 
+// ErrServerUnreachable wraps Dial errors where no stream to the server could
+// be opened, as opposed to the server refusing or failing the dial.
+var ErrServerUnreachable = errors.New("server unreachable")
+
+// ErrServerBusy wraps Dial errors where the server was too loaded to take
+// the stream (HTTP 503 with Retry-After). Like ErrServerUnreachable, they
+// are retried.
+var ErrServerBusy = errors.New("server busy")
+
+// ErrUnauthorized wraps Dial errors where the server rejected the client's
+// auth_token or key (HTTP 401).
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Defaults for config.DialRetry.
+const (
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
 // Client handles outgoing connections to the Server.
 type Client struct {
 	Config       *config.ClientConfig
@@ -30,12 +78,138 @@ type Client struct {
 	failureCount uint32       // Atomic counter
 	mu           sync.RWMutex // Protects httpClient
 	lastReset    time.Time    // Timestamp of last reset (for debounce)
+	profile      *wireProfile // Resolved request path/method/header names
+	lastDial     int64        // Atomic UnixNano of the last Dial (drives cover traffic)
+	downSince    atomic.Int64 // UnixNano of the first unreachable Dial since the server last answered (0 = reachable)
+	parallel     atomic.Int32 // Server connections to stripe streams over: connections, adjusted by congestion feedback
+	health       *health      // Circuit breaker (nil = off)
+
+	// TLS session caches for session_tickets (nil = off). They outlive
+	// the HTTP client, so reconnects after a reset resume.
+	sessions  tls.ClientSessionCache
+	usessions utls.ClientSessionCache
+	resumed   atomic.Bool // The last TLS handshake resumed a session
+
+	// dialRaw opens the TCP connection to the server: a direct dial, or a stream
+	// through the previous hop when the client is part of a chain.
+	dialRaw func(network, addr string) (net.Conn, error)
+	server  *serverDialer // Reaches the first server (SetDialer); nil for pipe clients and hops
+
+	peerMu       sync.Mutex         // Protects peerVersion and peerFeatures
+	peerVersion  int                // Server protocol version seen on the last stream
+	peerFeatures []protocol.Feature // Features the server accepted on the last stream
+
+	conns             connTracker           // Open server connections, for NotifyNetworkChange
+	accepted          connTracker           // Open inbound connections, for Close
+	inbounds          inboundStats          // Per-inbound counters (CountConn)
+	streams           connTable             // Open streams, for Connections
+	listeners         inboundSet            // Inbounds started by AddInbound
+	fallback          *Fallback             // nil = no direct fallback
+	lan               *outbound.HostMatcher // allow_lan sources (nil = no filter)
+	socks             *socksGate            // socks5 limits of the SOCKS5 inbounds
+	direct            *outbound.HostMatcher // routing.direct and bypass_private (nil = tunnel everything)
+	adblock           *adBlock              // nil = no ad blocking
+	directProcs       *process.Matcher      // routing.direct "process:" entries (nil = none)
+	rules             []routingRule         // routing.rules
+	outbounds         outboundSet           // routing.outbounds
+	lookup            *dns.Lookup           // Resolves remote_addr and direct targets ([dns]; nil = system resolver)
+	priorities        *priorityHosts        // routing.interactive and routing.bulk (nil = none)
+	prio              *prioGate             // Schedules uploads by priority class
+	hops              []*Client             // Clients of cfg.Chain, entry first
+	lastNetworkChange time.Time             // Protected by mu
+
+	noticeMu     sync.Mutex
+	notices      []Notice               // Latest keptNotices from the server
+	disconnected atomic.Pointer[string] // Reason of a "disconnect" notice
+	hintMu       sync.Mutex
+	hints        ConfigHints // Server hints the config leaves room for
+
+	lowPower     atomic.Bool   // Keepalive at the low-power interval
+	powerChanged chan struct{} // Wakes runKeepalive when lowPower flips
+
+	ctx    context.Context // Done once Close starts
+	cancel context.CancelFunc
+	ready  chan struct{} // Closed once the server first answers (Ready)
 }
 
-// NewClient creates a new Phoenix client instance.
+// NewClient creates a new Phoenix client instance. When cfg.Chain is set,
+// the connection to RemoteAddr is tunneled through each hop in turn.
 func NewClient(cfg *config.ClientConfig) *Client {
+	var c *Client
+	lookup, err := newLookup(cfg, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return c.tunnelDial(ctx, network, addr)
+	})
+	if err != nil {
+		log.Printf("[DNS] Ignoring invalid [dns]: %v", err)
+	}
+	server := newServerDialer(cfg, lookup)
+	dial, hops := chainDialer(cfg, server.dialConn)
+	c = newClient(cfg, dial)
+	c.hops = hops
+	c.server = server
+	c.lookup = lookup
+	fallback, err := newFallback(c)
+	if err != nil {
+		log.Printf("[Fallback] Disabled, invalid fallback.hosts: %v", err)
+	}
+	c.fallback = fallback
+	lan, err := lanACL(cfg)
+	if err != nil {
+		// Fail closed: an invalid list must not open the inbounds to everyone.
+		log.Printf("[LAN] Invalid lan_sources, only loopback allowed: %v", err)
+		lan = &outbound.HostMatcher{}
+	}
+	c.lan = lan
+	c.socks = newSOCKSGate(cfg.SOCKS5)
+	direct, err := directHosts(cfg)
+	if err != nil {
+		log.Printf("[Routing] Ignoring invalid routing.direct: %v", err)
+	}
+	c.direct = direct
+	c.directProcs = process.NewMatcher(cfg.Routing.DirectProcesses())
+	rules, err := newRoutingRules(cfg)
+	if err != nil {
+		log.Printf("[Routing] Ignoring invalid routing.rules: %v", err)
+	}
+	c.rules = rules
+	outbounds, err := newOutbounds(cfg)
+	if err != nil {
+		log.Printf("[Routing] Ignoring invalid routing.outbounds: %v", err)
+	}
+	c.outbounds = outbounds
+	priorities, err := newPriorityHosts(cfg.Routing.Interactive, cfg.Routing.Bulk)
+	if err != nil {
+		log.Printf("[Routing] Ignoring invalid routing.interactive or routing.bulk: %v", err)
+	}
+	c.priorities = priorities
+	adblock, err := newAdBlock(cfg.AdBlock)
+	if err != nil {
+		log.Printf("[AdBlock] Disabled, invalid adblock lists: %v", err)
+	}
+	c.adblock = adblock
+	c.runGeoData()
+	for _, h := range hops {
+		h.start()
+	}
+	c.start()
+	return c
+}
+
+func newClient(cfg *config.ClientConfig, dialRaw func(network, addr string) (net.Conn, error)) *Client {
 	c := &Client{
-		Config: cfg,
+		Config:       cfg,
+		profile:      newWireProfile(cfg.HTTP, cfg.Fingerprint),
+		prio:         newPrioGate(),
+		powerChanged: make(chan struct{}, 1),
+		ready:        make(chan struct{}),
+		health:       newHealth(cfg.Health),
+	}
+	c.dialRaw = c.conns.wrap(dialRaw)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.parallel.Store(int32(cfg.Connections))
+	if cfg.ResumesSessions() {
+		c.sessions = tls.NewLRUClientSessionCache(sessionCacheSize)
+		c.usessions = utls.NewLRUClientSessionCache(sessionCacheSize)
 	}
 
 	// Initialize scheme based on config
@@ -53,10 +227,30 @@ func NewClient(cfg *config.ClientConfig) *Client {
 	return c
 }
 
+// start runs the client's background loops. Their streams read the routing
+// and priority settings, so it is called once every field is set.
+func (c *Client) start() {
+	cfg := c.Config
+	go c.checkReady()
+	go c.runKeepalive()
+	if cfg.Cover.Enabled {
+		go c.runCoverTraffic(cfg.Cover)
+	}
+	if cfg.Congestion.Enabled {
+		go c.runCongestionFeedback(cfg.Congestion)
+	}
+	if cfg.ReceivesNotices() {
+		go c.runNotices()
+	}
+}
+
 // dialWithFingerprint dials a TLS connection using uTLS to spoof a browser fingerprint.
-// If fingerprint is empty, falls back to standard Go TLS.
+// If the fingerprint setting is empty, falls back to standard Go TLS.
 // Always negotiates HTTP/2 (ALPN "h2") regardless of fingerprint mode.
-func dialWithFingerprint(network, addr string, tlsCfg *tls.Config, fingerprint string) (net.Conn, error) {
+// The ClientHello is optionally split (see config.TLSFragment).
+func (c *Client) dialWithFingerprint(network, addr string, tlsCfg *tls.Config) (net.Conn, error) {
+	fingerprint, frag := c.Config.Fingerprint, c.Config.Fragment
+
 	// Ensure ALPN h2 is set (http2.Transport normally does this, but custom DialTLS bypasses it)
 	if tlsCfg == nil {
 		tlsCfg = &tls.Config{}
@@ -64,15 +258,13 @@ func dialWithFingerprint(network, addr string, tlsCfg *tls.Config, fingerprint s
 	if len(tlsCfg.NextProtos) == 0 {
 		cloned := tlsCfg.Clone()
 		cloned.NextProtos = []string{"h2"}
+		if alpn := c.hinted().ALPN; len(alpn) > 0 {
+			cloned.NextProtos = alpn
+		}
 		tlsCfg = cloned
 	}
 
-	if fingerprint == "" {
-		// Standard TLS — no spoofing
-		return tls.Dial(network, addr, tlsCfg)
-	}
-
-	rawConn, err := net.Dial(network, addr)
+	rawConn, err := c.dialRaw(network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +276,23 @@ func dialWithFingerprint(network, addr string, tlsCfg *tls.Config, fingerprint s
 	if tlsCfg != nil && tlsCfg.ServerName != "" {
 		sni = tlsCfg.ServerName
 	}
+	fragConn := newFragmentConn(rawConn, frag, sni)
+
+	if fingerprint == "" {
+		// Standard TLS — no spoofing
+		cfg := tlsCfg.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = sni
+		}
+		cfg.ClientSessionCache = c.sessions
+		conn := tls.Client(fragConn, cfg)
+		if err := conn.Handshake(); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		c.resumed.Store(conn.ConnectionState().DidResume)
+		return conn, nil
+	}
 
 	utlsCfg := &utls.Config{
 		ServerName:         sni,
@@ -94,11 +303,35 @@ func dialWithFingerprint(network, addr string, tlsCfg *tls.Config, fingerprint s
 		utlsCfg.RootCAs = tlsCfg.RootCAs
 	}
 
-	uConn := utls.UClient(rawConn, utlsCfg, pickHelloID(fingerprint))
+	helloID := pickHelloID(fingerprint)
+	var spec *utls.ClientHelloSpec
+	if c.usessions != nil && helloID != utls.HelloRandomized {
+		if spec, err = resumableSpec(helloID); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		utlsCfg.ClientSessionCache = c.usessions
+		utlsCfg.OmitEmptyPsk = true // No ticket yet: a plain first hello
+		helloID = utls.HelloCustom
+	}
+	uConn := utls.UClient(fragConn, utlsCfg, helloID)
+	if spec != nil {
+		if err := uConn.ApplyPreset(spec); err != nil {
+			rawConn.Close()
+			return nil, fmt.Errorf("failed to apply ClientHello: %v", err)
+		}
+	}
+	if padding := c.helloPadding(); padding > 0 {
+		if err := padClientHello(uConn, padding); err != nil {
+			rawConn.Close()
+			return nil, fmt.Errorf("failed to pad ClientHello: %v", err)
+		}
+	}
 	if err := uConn.Handshake(); err != nil {
 		rawConn.Close()
 		return nil, fmt.Errorf("utls handshake failed: %v", err)
 	}
+	c.resumed.Store(uConn.ConnectionState().DidResume)
 
 	// If caller provided custom VerifyPeerCertificate, run it now
 	if tlsCfg.VerifyPeerCertificate != nil {
@@ -116,6 +349,28 @@ func dialWithFingerprint(network, addr string, tlsCfg *tls.Config, fingerprint s
 	return uConn, nil
 }
 
+// sessionCacheSize is the number of TLS sessions kept per client. One server
+// name needs one; chains hold theirs in the hop clients.
+const sessionCacheSize = 4
+
+// resumableSpec returns the ClientHello of id with a pre_shared_key
+// extension, which the Chrome, Firefox and Safari parrots lack, so
+// sessions can be resumed as the browsers do.
+func resumableSpec(id utls.ClientHelloID) (*utls.ClientHelloSpec, error) {
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s ClientHello: %v", id.Str(), err)
+	}
+	for _, ext := range spec.Extensions {
+		if _, ok := ext.(utls.PreSharedKeyExtension); ok {
+			return &spec, nil
+		}
+	}
+	// pre_shared_key must be the last extension.
+	spec.Extensions = append(spec.Extensions, &utls.UtlsPreSharedKeyExtension{})
+	return &spec, nil
+}
+
 // pickHelloID maps a fingerprint name to a uTLS ClientHelloID.
 func pickHelloID(fp string) utls.ClientHelloID {
 	switch fp {
@@ -157,7 +412,7 @@ func (c *Client) createHTTPClient() *http.Client {
 		baseTLS := &tls.Config{ServerName: sniHost}
 		tr = &http2.Transport{
 			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-				return dialWithFingerprint(network, target, baseTLS, c.Config.Fingerprint)
+				return c.dialWithFingerprint(network, target, baseTLS)
 			},
 			StrictMaxConcurrentStreams: true,
 			ReadIdleTimeout:            0,
@@ -171,7 +426,7 @@ func (c *Client) createHTTPClient() *http.Client {
 		baseTLS := &tls.Config{InsecureSkipVerify: true, ServerName: sniHost} //nolint:gosec
 		tr = &http2.Transport{
 			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-				return dialWithFingerprint(network, target, baseTLS, c.Config.Fingerprint)
+				return c.dialWithFingerprint(network, target, baseTLS)
 			},
 			StrictMaxConcurrentStreams: true,
 			ReadIdleTimeout:            0,
@@ -230,7 +485,7 @@ func (c *Client) createHTTPClient() *http.Client {
 		target := dialTarget()
 		tr = &http2.Transport{
 			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
-				return dialWithFingerprint(network, target, tlsConfig, c.Config.Fingerprint)
+				return c.dialWithFingerprint(network, target, tlsConfig)
 			},
 			StrictMaxConcurrentStreams: true,
 			ReadIdleTimeout:            0,
@@ -244,7 +499,7 @@ func (c *Client) createHTTPClient() *http.Client {
 		tr = &http2.Transport{
 			AllowHTTP: true,
 			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-				return net.Dial(network, target)
+				return c.dialRaw(network, target)
 			},
 			StrictMaxConcurrentStreams: true,
 			ReadIdleTimeout:            0,
@@ -252,6 +507,7 @@ func (c *Client) createHTTPClient() *http.Client {
 		}
 	}
 
+	newConnPool(tr, &c.parallel)
 	return &http.Client{Transport: tr}
 }
 
@@ -285,6 +541,56 @@ func (c *Client) logSecurityMode() {
 // Dial initiates a tunnel for a specific protocol.
 // It connects to the server and returns the stream to be used by the local listener.
 func (c *Client) Dial(proto protocol.ProtocolType, target string) (io.ReadWriteCloser, error) {
+	st, _, err := c.dial(proto, target, "")
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// dial opens a stream like Dial. A non-empty resume is sent in the
+// FeatureResume header; the server's answer to it is returned. Attempts
+// that don't reach the server are retried as configured in [retry].
+func (c *Client) dial(proto protocol.ProtocolType, target, resume string) (*Stream, string, error) {
+	r := c.Config.Retry
+	attempts := max(r.MaxAttempts, 1)
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := r.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		if err := c.closedErr(); err != nil {
+			return nil, "", err
+		}
+		if err := c.disconnectedErr(); err != nil {
+			return nil, "", err
+		}
+		if c.health != nil {
+			if err := c.health.admit(); err != nil {
+				return nil, "", err
+			}
+		}
+		st, answer, err := c.open(proto, target, resume)
+		if err == nil || attempt == attempts || !errors.Is(err, ErrServerUnreachable) && !errors.Is(err, ErrServerBusy) {
+			return st, answer, err
+		}
+		log.Printf("[Retry] Dial %s failed (attempt %d/%d), retrying in %v: %v", target, attempt, attempts, backoff, err)
+		if !c.sleep(backoff) {
+			return nil, "", ErrClosed
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// open is dial without the circuit breaker, which it feeds.
+func (c *Client) open(proto protocol.ProtocolType, target, resume string) (*Stream, string, error) {
+	start := time.Now()
+	atomic.StoreInt64(&c.lastDial, start.UnixNano())
+
 	// Get current HTTP client (Read Lock)
 	c.mu.RLock()
 	client := c.httpClient
@@ -293,18 +599,56 @@ func (c *Client) Dial(proto protocol.ProtocolType, target string) (io.ReadWriteC
 	// We use io.Pipe to bridge the local connection to the request body.
 	pr, pw := io.Pipe()
 
-	req, err := http.NewRequest("POST", c.Scheme+"://"+c.Config.RemoteAddr, pr)
+	wp := c.profile
+	req, err := http.NewRequest(wp.method, c.Scheme+"://"+c.Config.RemoteAddr+wp.requestPath(), pr)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Set headers
-	req.Header.Set("X-Nerve-Protocol", string(proto))
-	if target != "" {
-		req.Header.Set("X-Nerve-Target", target)
+	for k, v := range wp.decoy {
+		req.Header[k] = v
 	}
-	if c.Config.AuthToken != "" {
-		req.Header.Set("X-Nerve-Token", c.Config.AuthToken)
+	req.Header.Set(wp.hVersion, version.Version)
+	req.Header.Set(wp.hProtoVer, strconv.Itoa(protocol.Version))
+	if features := protocol.SupportedFeatures(); len(features) > 0 {
+		req.Header.Set(wp.hFeatures, protocol.FormatFeatures(features))
+	}
+	_, _, resumed := parseResume(resume)
+	if resume != "" {
+		req.Header.Set(wp.hResume, resume)
+	}
+
+	// Stream metadata goes either into an encrypted first frame (the server
+	// detects it by the missing protocol header) or into plaintext headers.
+	prio := c.priorityOf(target)
+	var frameDone chan error
+	if c.Config.MetadataFrame {
+		frame, err := encodeMetaFrame(metaSecret(c.Config.HTTP.Secret, c.Config.AuthToken), streamMeta{
+			Protocol: string(proto),
+			Target:   target,
+			Token:    c.Config.AuthToken,
+			Priority: prio.String(),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		frameDone = make(chan error, 1)
+		go func() {
+			_, err := pw.Write(frame)
+			frameDone <- err
+		}()
+	} else {
+		req.Header.Set(wp.hProtocol, string(proto))
+		if target != "" {
+			req.Header.Set(wp.hTarget, target)
+		}
+		if c.Config.AuthToken != "" {
+			req.Header.Set(wp.hToken, c.Config.AuthToken)
+		}
+		if prio != priorityNormal {
+			req.Header.Set(wp.hPriority, prio.String())
+		}
 	}
 
 	respChan := make(chan *http.Response, 1)
@@ -325,27 +669,104 @@ func (c *Client) Dial(proto protocol.ProtocolType, target string) (io.ReadWriteC
 		// Connection Successful
 		atomic.StoreUint32(&c.failureCount, 0) // Reset failure count
 
+		if resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "" {
+			// The server itself shed the stream: it is up, just loaded.
+			resp.Body.Close()
+			c.recordDial(start, nil)
+			c.downSince.Store(0)
+			return nil, "", fmt.Errorf("%w: status %d", ErrServerBusy, resp.StatusCode)
+		}
+		if unhealthyStatus(resp.StatusCode) {
+			// A CDN in front of the server answered for it.
+			resp.Body.Close()
+			err := fmt.Errorf("%w: proxy answered with status %d", ErrServerUnreachable, resp.StatusCode)
+			c.recordDial(start, err)
+			c.downSince.CompareAndSwap(0, time.Now().UnixNano())
+			return nil, "", err
+		}
+		c.recordDial(start, nil)
+		c.downSince.Store(0)
+
 		if resp.StatusCode != http.StatusOK {
 			resp.Body.Close()
-			return nil, fmt.Errorf("server rejected connection with status: %d", resp.StatusCode)
+			err := fmt.Errorf("server rejected connection with status: %d", resp.StatusCode)
+			if resp.StatusCode == http.StatusUnauthorized {
+				return nil, "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+			}
+			if resp.StatusCode == http.StatusForbidden && target != "" {
+				// Port, destination and ACL policy all answer 403.
+				return nil, "", &socks5.DialError{Code: socks5.ReplyNotAllowed, Err: err}
+			}
+			return nil, "", err
+		}
+		if frameDone != nil {
+			// The server only answers after reading the frame, so this
+			// returns immediately; it keeps caller writes ordered after it.
+			if err := <-frameDone; err != nil {
+				resp.Body.Close()
+				return nil, "", fmt.Errorf("failed to send metadata frame: %v", err)
+			}
+		}
+
+		// Servers predating negotiation send neither header: version 0, no features.
+		features := protocol.ParseFeatures(resp.Header.Get(wp.hFeatures))
+		c.peerMu.Lock()
+		c.peerVersion = protocol.ParseVersion(resp.Header.Get(wp.hProtoVer))
+		c.peerFeatures = features
+		c.peerMu.Unlock()
+
+		// A resumed stream's target is already connected.
+		if target != "" && !resumed && proto != protocol.ProtocolReverse && protocol.HasFeature(features, FeatureDialStatus) {
+			// The server's dial timeout and retries bound the wait; the
+			// timer only guards against a server that never answers.
+			timer := time.AfterFunc(dialStatusTimeout, func() { resp.Body.Close() })
+			err := readDialStatus(resp.Body, target)
+			timer.Stop()
+			if err != nil {
+				resp.Body.Close()
+				pw.Close()
+				return nil, "", err
+			}
 		}
+
+		loglevel.Debugf("[Stream] Opened %s stream (Target: %s) in %v", proto, target, time.Since(start).Round(time.Millisecond))
+		lc := c.streams.add(Connection{Protocol: string(proto), Target: target}, nil)
 		return &Stream{
-			Writer: pw,
-			Reader: resp.Body,
-			Closer: resp.Body,
-		}, nil
+			Writer:   &countedWriter{w: c.prio.writer(pw, prio), lc: lc},
+			Reader:   &countedReader{r: resp.Body, lc: lc},
+			Closer:   &untrackingCloser{Closer: resp.Body, t: &c.streams, lc: lc},
+			Features: features,
+			pw:       pw,
+			lc:       lc,
+		}, resp.Header.Get(wp.hResume), nil
 
 	case err := <-errChan:
 		c.handleConnectionFailure(err)
-		return nil, err
+		err = fmt.Errorf("%w: %v", ErrServerUnreachable, err)
+		c.recordDial(start, err)
+		c.downSince.CompareAndSwap(0, time.Now().UnixNano())
+		return nil, "", err
 
 	case <-time.After(10 * time.Second):
 		err := fmt.Errorf("connection to server timed out")
 		c.handleConnectionFailure(err)
-		return nil, err
+		err = fmt.Errorf("%w: %v", ErrServerUnreachable, err)
+		c.recordDial(start, err)
+		c.downSince.CompareAndSwap(0, time.Now().UnixNano())
+		return nil, "", err
 	}
 }
 
+// unreachableFor reports how long Dial has been failing to reach the server,
+// or 0 while it is reachable.
+func (c *Client) unreachableFor() time.Duration {
+	since := c.downSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
 // handleConnectionFailure increments failure count and triggers Hard Reset if needed.
 func (c *Client) handleConnectionFailure(err error) {
 	newCount := atomic.AddUint32(&c.failureCount, 1)
@@ -372,13 +793,15 @@ func (c *Client) resetClient() {
 
 	// Close old connections to free resources
 	if c.httpClient != nil {
-		c.httpClient.CloseIdleConnections()
+		poolOf(c.httpClient).closeIdle()
 	}
 
 	// Create new client
 	// Note: Creating new http.Client creates new Transport, which creates new TCP connection pool.
 	c.httpClient = c.createHTTPClient()
 
+	go c.warmUp(c.httpClient)
+
 	// Update timestamp and reset failure count
 	c.lastReset = time.Now()
 	atomic.StoreUint32(&c.failureCount, 0)
@@ -388,11 +811,56 @@ func (c *Client) resetClient() {
 	log.Println("Client re-initialized. Ready for new connections.")
 }
 
+// warmUp connects hc to the server in the background after a reset, so the
+// first Dial finds a connection instead of waiting for TCP and TLS. It
+// stays quiet in low-power mode rather than wake the radio.
+func (c *Client) warmUp(hc *http.Client) {
+	if c.lowPower.Load() {
+		return
+	}
+	start := time.Now()
+	if err := poolOf(hc).probe(defaultKeepaliveTimeout); err != nil {
+		return // The next Dial retries and reports
+	}
+	how := ""
+	if c.Scheme == "https" {
+		how = " (full TLS handshake)"
+		if c.resumed.Load() {
+			how = " (TLS session resumed)"
+		}
+	}
+	log.Printf("[Transport] Reconnected in %v%s", time.Since(start).Round(time.Millisecond), how)
+}
+
+// ServerProtocol returns the protocol version and features the server
+// reported on the most recent stream. Before the first successful Dial, or
+// against servers that predate negotiation, it returns version 0.
+func (c *Client) ServerProtocol() (int, []protocol.Feature) {
+	c.peerMu.Lock()
+	defer c.peerMu.Unlock()
+	return c.peerVersion, c.peerFeatures
+}
+
 // Stream wraps the pipe endpoint to implement io.ReadWriteCloser.
 type Stream struct {
 	io.Writer
 	io.Reader
 	io.Closer
+
+	// Features negotiated with the server for this stream.
+	Features []protocol.Feature
+
+	pw *io.PipeWriter // The request body, under Writer
+	lc *liveConn      // The stream's Connections entry
+}
+
+// CloseWrite ends the upload direction (the server sees EOF on the request
+// body) while the response can still be read.
+func (s *Stream) CloseWrite() error {
+	if w, ok := s.Writer.(io.Closer); ok {
+		return w.Close()
+	}
+	return nil
 }
 
 func (s *Stream) Close() error {
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrClosed is returned by Dial once the client is closed.
+var ErrClosed = errors.New("client closed")
+
+const (
+	// defaultCloseGrace is how long Close lets open streams finish.
+	defaultCloseGrace = 5 * time.Second
+
+	// drainPoll is how often Shutdown checks for streams left.
+	drainPoll = 50 * time.Millisecond
+)
+
+// Close shuts the client down as Shutdown does, aborting the streams still
+// open after 5 seconds.
+func (c *Client) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseGrace)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown stops the inbounds, the notice and congestion feedback streams
+// and the client's background work, and makes Dial fail with ErrClosed.
+// It then waits for the open streams and inbound connections to end.
+// Those left when ctx is done are aborted by closing the connections to
+// the server and the inbound connections, which also releases their
+// SOCKS5 UDP relay sockets; Shutdown then returns ctx.Err(). Shutting down
+// the entry client of a chain shuts down its hops too.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.cancel()
+	for _, in := range c.Inbounds() {
+		c.RemoveInbound(in.Name())
+	}
+
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+	var err error
+drain:
+	for c.accepted.len() > 0 || c.streams.len() > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			log.Printf("[Transport] Aborting %d streams and %d inbound connections on close", c.streams.len(), c.accepted.len())
+			err = ctx.Err()
+			break drain
+		}
+	}
+	c.accepted.closeAll()
+	c.conns.closeAll()
+	c.outbounds.close()
+	for _, hop := range c.hops {
+		hop.Shutdown(ctx)
+	}
+	return err
+}
+
+// closedErr returns ErrClosed once Close has started.
+func (c *Client) closedErr() error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	return nil
+}
+
+// sleep waits for d, reporting false if the client is closed first.
+func (c *Client) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection is one live stream, as listed by Connections and
+// Client.Connections. Up is the client-to-target direction on both sides.
+type Connection struct {
+	ID       uint64        `json:"id"`
+	User     string        `json:"user,omitempty"`   // Server side, with a user table
+	Remote   string        `json:"remote,omitempty"` // Server side: the client's address
+	Protocol string        `json:"protocol"`
+	Target   string        `json:"target,omitempty"`
+	Started  time.Time     `json:"started"`
+	Age      time.Duration `json:"age"` // Nanoseconds
+	BytesUp  int64         `json:"bytes_up"`
+	BytesDn  int64         `json:"bytes_down"`
+
+	// Rates in bytes per second since the previous listing (at least
+	// rateInterval ago), or since the stream started.
+	RateUp float64 `json:"rate_up"`
+	RateDn float64 `json:"rate_down"`
+}
+
+// rateInterval is the shortest span Connection rates are measured over, so
+// listings in quick succession don't report noise.
+const rateInterval = time.Second
+
+// liveConn is a connTable entry.
+type liveConn struct {
+	info     Connection // Static fields
+	up, down atomic.Int64
+	target   *targetKey    // Where the stream is counted in TopTargets; nil = not counted
+	done     chan struct{} // Closed on remove
+
+	mu                 sync.Mutex // Protects the rate sample and the flow export
+	sampled            time.Time
+	sampleUp, sampleDn int64
+	rateUp, rateDn     float64
+	flows              *flowExporter // Where the stream is exported; nil = not exported
+	flowAt             time.Time     // Last flow record; zero = none yet
+	flowUp, flowDn     int64
+}
+
+// connTable lists the live streams of a client or of the server.
+type connTable struct {
+	mu       sync.Mutex
+	next     uint64
+	live     map[uint64]*liveConn
+	watchers map[chan ConnEvent]struct{}
+}
+
+// ConnEvent is a stream opening or closing, as sent by WatchConnections.
+type ConnEvent struct {
+	Type       string     `json:"type"` // "open" or "close"
+	Connection Connection `json:"connection"`
+}
+
+// watchBuffer is how many events a watcher may fall behind by; further
+// events are dropped for it rather than slowing streams down.
+const watchBuffer = 256
+
+// serverConns lists the streams of every server in the process.
+var serverConns = &connTable{}
+
+// Connections returns the live streams of every server in this process,
+// busiest first.
+func Connections() []Connection {
+	return serverConns.list()
+}
+
+// Connections returns the live streams of the client, busiest first.
+func (c *Client) Connections() []Connection {
+	return c.streams.list()
+}
+
+// WatchConnections streams the opening and closing of every server
+// stream in this process until stop.
+func WatchConnections() (events <-chan ConnEvent, stop func()) {
+	return serverConns.watch()
+}
+
+// WatchConnections streams the opening and closing of the client's
+// streams until stop.
+func (c *Client) WatchConnections() (events <-chan ConnEvent, stop func()) {
+	return c.streams.watch()
+}
+
+// add lists a stream until remove.
+func (t *connTable) add(info Connection, target *targetKey) *liveConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.live == nil {
+		t.live = make(map[uint64]*liveConn)
+	}
+	t.next++
+	info.ID = t.next
+	info.Started = time.Now()
+	lc := &liveConn{info: info, target: target, sampled: info.Started, done: make(chan struct{})}
+	t.live[info.ID] = lc
+	t.notify("open", info)
+	return lc
+}
+
+func (t *connTable) remove(lc *liveConn) {
+	t.mu.Lock()
+	delete(t.live, lc.info.ID)
+	close(lc.done)
+	if len(t.watchers) > 0 {
+		t.notify("close", lc.snapshot(time.Now()))
+	}
+	t.mu.Unlock()
+}
+
+func (t *connTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.live)
+}
+
+func (t *connTable) watch() (<-chan ConnEvent, func()) {
+	ch := make(chan ConnEvent, watchBuffer)
+	t.mu.Lock()
+	if t.watchers == nil {
+		t.watchers = make(map[chan ConnEvent]struct{})
+	}
+	t.watchers[ch] = struct{}{}
+	t.mu.Unlock()
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.watchers, ch)
+		t.mu.Unlock()
+	}
+}
+
+// notify sends an event to the watchers; t.mu is held.
+func (t *connTable) notify(typ string, c Connection) {
+	for ch := range t.watchers {
+		select {
+		case ch <- ConnEvent{Type: typ, Connection: c}:
+		default:
+		}
+	}
+}
+
+func (t *connTable) list() []Connection {
+	t.mu.Lock()
+	live := make([]*liveConn, 0, len(t.live))
+	for _, lc := range t.live {
+		live = append(live, lc)
+	}
+	t.mu.Unlock()
+
+	now := time.Now()
+	list := make([]Connection, 0, len(live))
+	for _, lc := range live {
+		list = append(list, lc.snapshot(now))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ri, rj := list[i].RateUp+list[i].RateDn, list[j].RateUp+list[j].RateDn
+		if ri != rj {
+			return ri > rj
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// snapshot returns the entry with its counters, taking a new rate sample
+// when the last one is at least rateInterval old.
+func (lc *liveConn) snapshot(now time.Time) Connection {
+	c := lc.info
+	c.Age = now.Sub(c.Started)
+	c.BytesUp, c.BytesDn = lc.up.Load(), lc.down.Load()
+
+	lc.mu.Lock()
+	if dt := now.Sub(lc.sampled); dt >= rateInterval {
+		lc.rateUp = float64(c.BytesUp-lc.sampleUp) / dt.Seconds()
+		lc.rateDn = float64(c.BytesDn-lc.sampleDn) / dt.Seconds()
+		lc.sampled, lc.sampleUp, lc.sampleDn = now, c.BytesUp, c.BytesDn
+	}
+	c.RateUp, c.RateDn = lc.rateUp, lc.rateDn
+	lc.mu.Unlock()
+	return c
+}
+
+// countedStream counts a server stream's bytes into its entry: reads come
+// from the client, writes go to it.
+type countedStream struct {
+	io.ReadWriteCloser
+	lc *liveConn
+}
+
+func (s *countedStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	s.lc.up.Add(int64(n))
+	return n, err
+}
+
+func (s *countedStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Write(p)
+	s.lc.down.Add(int64(n))
+	return n, err
+}
+
+// countedWriter counts a client stream's uploads. Close reaches the
+// wrapped writer, which Stream.CloseWrite relies on.
+type countedWriter struct {
+	w  io.Writer
+	lc *liveConn
+}
+
+func (w *countedWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.lc.up.Add(int64(n))
+	return n, err
+}
+
+func (w *countedWriter) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// countedReader counts a client stream's downloads.
+type countedReader struct {
+	r  io.Reader
+	lc *liveConn
+}
+
+func (r *countedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.lc.down.Add(int64(n))
+	return n, err
+}
+
+// untrackingCloser removes a client stream from the table when it closes.
+type untrackingCloser struct {
+	io.Closer
+	t    *connTable
+	lc   *liveConn
+	once sync.Once
+}
+
+func (c *untrackingCloser) Close() error {
+	c.once.Do(func() { c.t.remove(c.lc) })
+	return c.Closer.Close()
+}
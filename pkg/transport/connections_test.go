@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"io"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func TestPipeConnections(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Users = []config.User{{Name: "conns-test", Token: "secret"}}
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: "secret"}, pipeServer(t, serverCfg))
+	events, stop := client.WatchConnections()
+	defer stop()
+
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	msg := []byte("hello")
+	stream.Write(msg)
+	io.ReadFull(stream, make([]byte, len(msg)))
+
+	find := func(list []Connection) *Connection {
+		for _, c := range list {
+			if c.Target == target {
+				return &c
+			}
+		}
+		return nil
+	}
+	if c := find(client.Connections()); c == nil || c.BytesUp != 5 || c.BytesDn != 5 {
+		t.Errorf("Expected the client to list the stream with 5 bytes each way, got %+v", c)
+	}
+	var server *Connection
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if server = find(Connections()); server != nil && server.BytesUp == 5 {
+			break
+		}
+	}
+	if server == nil || server.User != "conns-test" || server.Protocol != string(protocol.ProtocolSOCKS5) || server.BytesUp != 5 {
+		t.Errorf("Expected the server to list the user's stream, got %+v", server)
+	}
+
+	stream.Close()
+	if c := find(client.Connections()); c != nil {
+		t.Errorf("Expected the closed stream to be gone, got %+v", c)
+	}
+	next := func() ConnEvent {
+		for ev := range events {
+			if ev.Connection.Target == target {
+				return ev
+			}
+		}
+		return ConnEvent{}
+	}
+	if ev := next(); ev.Type != "open" {
+		t.Errorf("Expected an open event for the stream, got %+v", ev)
+	}
+	if ev := next(); ev.Type != "close" || ev.Connection.BytesUp != 5 {
+		t.Errorf("Expected a close event with the stream's bytes, got %+v", ev)
+	}
+}
+
+func TestPipeProgress(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+	all := make(chan Progress, 100)
+	defer client.OnProgress(10*time.Millisecond, func(p Progress) { all <- p })()
+
+	conn, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	stream := conn.(*Stream)
+	reports := make(chan Progress, 100)
+	stream.OnProgress(10*time.Millisecond, func(p Progress) { reports <- p })
+	msg := []byte("hello")
+	stream.Write(msg)
+	io.ReadFull(stream, make([]byte, len(msg)))
+	if p := <-reports; p.Done || p.BytesUp+p.BytesDn == 0 {
+		t.Errorf("Expected a progress report while the stream is open, got %+v", p)
+	}
+	stream.Close()
+
+	// Reports end with the stream's totals, on both callbacks.
+	for _, ch := range []chan Progress{reports, all} {
+		timeout := time.After(5 * time.Second)
+		for done := false; !done; {
+			select {
+			case p := <-ch:
+				if p.Target != target || !p.Done {
+					continue
+				}
+				done = true
+				if p.BytesUp != 5 || p.BytesDn != 5 {
+					t.Errorf("Expected a final report of 5 bytes each way, got %+v", p)
+				}
+			case <-timeout:
+				t.Fatal("Expected a final report for the closed stream")
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package transport
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"phoenix/pkg/config"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the cover traffic generator (see config.CoverTraffic).
+var (
+	defaultCoverPages = []string{"/", "/index.html", "/about", "/blog", "/news", "/products", "/contact"}
+
+	defaultCoverAssets = []string{"/favicon.ico", "/assets/logo.png", "/images/hero.jpg", "/images/banner.webp",
+		"/static/css/main.css", "/static/js/app.js", "/static/js/vendor.js", "/fonts/inter.woff2"}
+)
+
+const (
+	defaultCoverMinInterval = 20 * time.Second
+	defaultCoverMaxInterval = 90 * time.Second
+	defaultCoverIdleTimeout = 2 * time.Minute
+
+	// Cap on how much of each decoy response is read before it is discarded.
+	maxCoverBodySize = 4 << 20
+)
+
+// coverAccept returns the Accept header a browser sends for a resource,
+// guessed from the path extension.
+func coverAccept(path string) string {
+	switch ext := pathExt(path); ext {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".ico", ".svg":
+		return "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	case ".css":
+		return "text/css,*/*;q=0.1"
+	case ".js", ".woff2", ".woff":
+		return "*/*"
+	default:
+		return "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	}
+}
+
+func pathExt(path string) string {
+	for i := len(path) - 1; i >= 0 && path[i] != '/'; i-- {
+		if path[i] == '.' {
+			return path[i:]
+		}
+	}
+	return ""
+}
+
+// coverSite holds the decoy paths still in rotation. Paths the server
+// doesn't answer with 2xx are dropped: without a [fallback] site on the
+// server every GET gets 405, and a stream of errors looks nothing like
+// browsing.
+type coverSite struct {
+	mu            sync.Mutex
+	pages, assets []string
+}
+
+// page returns a random page, or "" once none is left.
+func (s *coverSite) page() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pages) == 0 {
+		return ""
+	}
+	return s.pages[rand.Intn(len(s.pages))]
+}
+
+// pickAssets returns up to n random assets.
+func (s *coverSite) pickAssets(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, i := range rand.Perm(len(s.assets)) {
+		if len(out) == n {
+			break
+		}
+		out = append(out, s.assets[i])
+	}
+	return out
+}
+
+// drop takes path out of rotation.
+func (s *coverSite) drop(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The lists may be the shared defaults, so they are copied.
+	match := func(p string) bool { return p == path }
+	s.pages = slices.DeleteFunc(slices.Clone(s.pages), match)
+	s.assets = slices.DeleteFunc(slices.Clone(s.assets), match)
+	log.Printf("[Cover] Dropping decoy %s: the server answered %d", path, status)
+}
+
+// runCoverTraffic periodically loads a decoy "page" while the tunnel is in
+// use. Requests go through the current tunnel http.Client, so they share the
+// HTTP/2 connection with tunnel streams. It stops once no page gets a 2xx.
+func (c *Client) runCoverTraffic(cfg config.CoverTraffic) {
+	site := &coverSite{pages: cfg.Pages, assets: cfg.Assets}
+	if len(site.pages) == 0 {
+		site.pages = defaultCoverPages
+	}
+	if len(site.assets) == 0 {
+		site.assets = defaultCoverAssets
+	}
+	minInterval, maxInterval := cfg.MinInterval, cfg.MaxInterval
+	if minInterval <= 0 {
+		minInterval = defaultCoverMinInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval + (defaultCoverMaxInterval - defaultCoverMinInterval)
+	}
+	idleTimeout := cfg.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultCoverIdleTimeout
+	}
+
+	log.Printf("[Cover] Decoy traffic enabled (%v-%v between page loads)", minInterval, maxInterval)
+	for {
+		if !c.sleep(minInterval + time.Duration(rand.Int63n(int64(maxInterval-minInterval)+1))) {
+			return
+		}
+
+		last := atomic.LoadInt64(&c.lastDial)
+		if last == 0 || time.Since(time.Unix(0, last)) > idleTimeout {
+			continue
+		}
+		page := site.page()
+		if page == "" {
+			log.Printf("[Cover] Decoy traffic disabled: no page was answered with 2xx; the server needs a [fallback] site")
+			return
+		}
+		c.loadCoverPage(site, page)
+	}
+}
+
+// loadCoverPage fetches page, then a random handful of assets in parallel
+// after a short "parse" delay, the way a browser loads a site.
+func (c *Client) loadCoverPage(site *coverSite, page string) {
+	c.mu.RLock()
+	client := c.httpClient
+	c.mu.RUnlock()
+
+	base := c.Scheme + "://" + c.Config.RemoteAddr
+	status, err := c.coverFetch(client, base+page, "")
+	if err != nil {
+		log.Printf("[Cover] Decoy request failed: %v", err)
+		return
+	}
+	if !coverOK(status) {
+		site.drop(page, status)
+		return
+	}
+
+	time.Sleep(time.Duration(50+rand.Intn(250)) * time.Millisecond)
+	var wg sync.WaitGroup
+	for _, path := range site.pickAssets(2 + rand.Intn(5)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, err := c.coverFetch(client, base+path, base+page); err == nil && !coverOK(status) {
+				site.drop(path, status)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func coverOK(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// coverFetch issues a GET with browser-like headers and discards the body,
+// returning the response status.
+func (c *Client) coverFetch(client *http.Client, url, referer string) (int, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if ua := c.profile.decoy.Get("User-Agent"); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+	req.Header.Set("Accept", coverAccept(req.URL.Path))
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, maxCoverBodySize))
+	return resp.StatusCode, err
+}
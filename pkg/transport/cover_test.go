@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"slices"
+	"testing"
+)
+
+func TestPipeCoverPaths(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(root, "logo.png"), []byte("png"), 0644)
+	site := config.DefaultServerConfig()
+	site.Fallback.Root = root
+	bare := config.DefaultServerConfig()
+
+	tests := []struct {
+		name       string
+		server     *config.ServerConfig
+		wantPages  []string
+		wantAssets []string
+	}{
+		{"fallback site", site, []string{"/"}, []string{"/logo.png"}},
+		{"no fallback", bare, nil, []string{"/logo.png", "/missing.css"}}, // Assets aren't tried without a page
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, tt.server))
+			<-client.Ready()
+			cs := &coverSite{pages: []string{"/"}, assets: []string{"/logo.png", "/missing.css"}}
+			client.loadCoverPage(cs, "/")
+			slices.Sort(cs.assets)
+			if !slices.Equal(cs.pages, tt.wantPages) || !slices.Equal(cs.assets, tt.wantAssets) {
+				t.Errorf("Expected pages %v and assets %v in rotation, got %v and %v", tt.wantPages, tt.wantAssets, cs.pages, cs.assets)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"context"
+	"log"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/dns"
+	"phoenix/pkg/outbound"
+	"sync/atomic"
+	"time"
+)
+
+// serverDialTimeout bounds the connection to the server made by a
+// SetDialer function.
+const serverDialTimeout = 10 * time.Second
+
+// DialFunc opens a connection to addr, as net.Dialer.DialContext does.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ResolveFunc looks up the addresses of host.
+type ResolveFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+// serverDialer opens the TCP connections to the first server of the
+// client: with the embedder's DialFunc, or with Happy Eyeballs over the
+// families allowed by prefer_ipv6 / ipv4_only, from outbound_interface and
+// source_addr, resolving through the embedder's ResolveFunc, [dns] or the
+// system resolver.
+type serverDialer struct {
+	direct  outbound.Direct
+	dial    atomic.Pointer[DialFunc]
+	resolve atomic.Pointer[ResolveFunc]
+}
+
+func newServerDialer(cfg *config.ClientConfig, lookup *dns.Lookup) *serverDialer {
+	d := &serverDialer{direct: outbound.Direct{
+		Family:    outbound.FamilyFor(cfg.PreferIPv6, cfg.IPv4Only),
+		LocalAddr: net.ParseIP(cfg.SourceAddr),
+		Socket:    outbound.Socket{Interface: cfg.OutboundInterface},
+	}}
+	if lookup != nil {
+		d.direct.Resolve = lookup.LookupIP
+	}
+	if err := d.direct.Socket.Check(); err != nil {
+		log.Printf("[Transport] Ignoring outbound_interface: %v", err)
+		d.direct.Socket = outbound.Socket{}
+	}
+	return d
+}
+
+func (d *serverDialer) dialConn(network, addr string) (net.Conn, error) {
+	if dial := d.dial.Load(); dial != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), serverDialTimeout)
+		defer cancel()
+		return (*dial)(ctx, network, addr)
+	}
+	direct := d.direct
+	if resolve := d.resolve.Load(); resolve != nil {
+		direct.Resolve = *resolve
+	}
+	return direct.DialConn(addr)
+}
+
+// SetDialer makes dial open the client's TCP connections to the server (to
+// the entry hop of a chain): dial_addr or remote_addr, unresolved, which
+// dial must resolve itself. Embedders use it to protect the socket from a
+// VPN it carries, to run over networks of their own or to connect test
+// harnesses. outbound_interface, source_addr and the address family
+// settings are then up to dial. nil restores the built-in dialer. The
+// connections already open are kept; NotifyNetworkChange replaces them.
+func (c *Client) SetDialer(dial DialFunc) {
+	if c.server == nil {
+		return // A pipe client
+	}
+	if dial == nil {
+		c.server.dial.Store(nil)
+		return
+	}
+	c.server.dial.Store(&dial)
+}
+
+// SetResolver makes resolve look up the server's host name for the
+// built-in dialer, instead of [dns] or the system resolver. Targets routed
+// directly keep resolving as configured. nil restores the default.
+func (c *Client) SetResolver(resolve ResolveFunc) {
+	if c.server == nil {
+		return
+	}
+	if resolve == nil {
+		c.server.resolve.Store(nil)
+		return
+	}
+	c.server.resolve.Store(&resolve)
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetDialer(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	pl := pipeServer(t, serverCfg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go Serve(serverCfg, ln)
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	echo := func(client *Client) error {
+		stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		stream.Write([]byte("ping"))
+		_, err = io.ReadFull(stream, make([]byte, 4))
+		return err
+	}
+
+	// Neither name resolves: only the injected functions reach the servers.
+	var dialed atomic.Value
+	client := NewClient(&config.ClientConfig{RemoteAddr: "phoenix.invalid:80"})
+	defer client.Close()
+	client.SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed.Store(addr)
+		return pl.Dial(network, addr)
+	})
+	if err := echo(client); err != nil {
+		t.Fatalf("Dial over SetDialer failed: %v", err)
+	}
+	if got, _ := dialed.Load().(string); got != "phoenix.invalid:80" {
+		t.Errorf("Expected the dialer to get the unresolved remote_addr, got %q", got)
+	}
+
+	client = NewClient(&config.ClientConfig{RemoteAddr: "phoenix.invalid:" + strconv.Itoa(port)})
+	defer client.Close()
+	client.SetResolver(func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+	})
+	if err := echo(client); err != nil {
+		t.Fatalf("Dial over SetResolver failed: %v", err)
+	}
+}
+
+// redirectDialer reaches every target at addr, recording what it dialed.
+type redirectDialer struct {
+	addr string
+	last atomic.Pointer[DialMeta]
+}
+
+func (d *redirectDialer) DialTarget(ctx context.Context, target string, meta DialMeta) (net.Conn, error) {
+	d.last.Store(&meta)
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", d.addr)
+}
+
+func TestPipeTargetDialer(t *testing.T) {
+	t.Parallel()
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	ln := NewPipeListener("phoenix-server")
+	t.Cleanup(func() { ln.Close() })
+	dialer := &redirectDialer{addr: echoTarget(t)}
+	go ServeWith(serverCfg, ln, ServerOptions{TargetDialer: dialer})
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, ln)
+
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, "intranet.example:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Expected the echo through the TargetDialer, got %q, %v", buf, err)
+	}
+	if meta := dialer.last.Load(); meta == nil || meta.Protocol != protocol.ProtocolSOCKS5 || meta.Remote == "" {
+		t.Errorf("Unexpected DialMeta %+v", meta)
+	}
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"phoenix/pkg/adapter/socks5"
+	"phoenix/pkg/adapter/ssh"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"time"
+)
+
+// FeatureDialStatus makes the server report whether it reached a stream's
+// target before relaying: the first byte of the response body is 0 on
+// success, otherwise the SOCKS5 reply code (RFC 1928) for the failure, and
+// the stream ends. Clients can then give their SOCKS5 clients "connection
+// refused" or "host unreachable" instead of success followed by EOF. Only
+// streams that carry a target get the status byte.
+const FeatureDialStatus protocol.Feature = "dial-status"
+
+// dialStatusTimeout caps how long a client waits for the status byte.
+const dialStatusTimeout = 2 * time.Minute
+
+func init() {
+	protocol.RegisterFeature(FeatureDialStatus)
+}
+
+// relayTarget dials target and relays stream to it, first reporting the
+// outcome when the client negotiated FeatureDialStatus.
+func relayTarget(stream io.ReadWriteCloser, target string, dialer outbound.Dialer, report bool) error {
+	destConn, err := dialTarget(stream, target, dialer, report)
+	if err != nil {
+		return err
+	}
+	return ssh.Relay(stream, destConn)
+}
+
+// dialTarget dials target like relayTarget, closing stream on failure.
+func dialTarget(stream io.ReadWriteCloser, target string, dialer outbound.Dialer, report bool) (io.ReadWriteCloser, error) {
+	destConn, err := dialer.Dial(target)
+	if report {
+		code := socks5.ReplySucceeded
+		if err != nil {
+			code = socks5.ReplyFor(err)
+		}
+		if _, werr := stream.Write([]byte{code}); werr != nil && err == nil {
+			destConn.Close()
+			return nil, werr
+		}
+	}
+	if err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("failed to dial target %s: %v", target, err)
+	}
+	return destConn, nil
+}
+
+// readDialStatus consumes the status byte of a FeatureDialStatus stream and
+// turns a failure into a *socks5.DialError.
+func readDialStatus(body io.Reader, target string) error {
+	var status [1]byte
+	if _, err := io.ReadFull(body, status[:]); err != nil {
+		return fmt.Errorf("failed to read dial status for %s: %v", target, err)
+	}
+	if status[0] != socks5.ReplySucceeded {
+		return &socks5.DialError{
+			Code: status[0],
+			Err:  fmt.Errorf("server failed to reach %s: %s", target, socks5.ReplyText(status[0])),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/dns"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"strings"
+	"time"
+)
+
+// Defaults for config.ClientDNS.
+const (
+	defaultDNSCacheSize = 1024
+	defaultDNSMinTTL    = 30 * time.Second
+	defaultDNSMaxTTL    = time.Hour
+	defaultDNSTimeout   = 2 * time.Second
+)
+
+// newLookup returns the resolver of client's own lookups ([dns]), or nil
+// when there are neither servers, rules, hosts nor a cache. tunnel opens the
+// connections of rules with tunnel set.
+func newLookup(client *config.ClientConfig, tunnel dns.DialFunc) (*dns.Lookup, error) {
+	cfg := client.DNS
+	size := cfg.CacheSize
+	switch {
+	case size == 0:
+		size = defaultDNSCacheSize
+	case size < 0:
+		if len(cfg.Servers) == 0 && len(cfg.Rules) == 0 && len(cfg.Hosts) == 0 && cfg.HostsFile == "" {
+			return nil, nil
+		}
+		size = 0
+	}
+	minTTL, maxTTL, timeout := cfg.MinTTL, cfg.MaxTTL, cfg.Timeout
+	if minTTL == 0 {
+		minTTL = defaultDNSMinTTL
+	}
+	if maxTTL == 0 {
+		maxTTL = defaultDNSMaxTTL
+	}
+	if timeout == 0 {
+		timeout = defaultDNSTimeout
+	}
+	resolver, err := dnsResolver(cfg.Servers, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	var rules []dns.LookupRule
+	direct, err := directHosts(client)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range cfg.Rules {
+		var dial dns.DialFunc
+		if r.Tunnel {
+			dial = tunnel
+		}
+		rr, err := dnsResolver(r.Servers, timeout, dial)
+		if err != nil {
+			return nil, fmt.Errorf("dns rule %d: %v", i, err)
+		}
+		match, err := dnsRuleMatcher(r, direct)
+		if err != nil {
+			return nil, fmt.Errorf("dns rule %d: %v", i, err)
+		}
+		rules = append(rules, dns.LookupRule{Match: match, Resolver: rr})
+	}
+	l := dns.NewLookup(resolver, rules, size, min(minTTL, maxTTL), maxTTL)
+	l.SetHosts(staticHosts(cfg))
+	return l, nil
+}
+
+// staticHosts returns the entries of dns.hosts_file overlaid with
+// dns.hosts. An unreadable file is logged and skipped.
+func staticHosts(cfg config.ClientDNS) dns.Hosts {
+	hosts := dns.Hosts{}
+	if cfg.HostsFile != "" {
+		loaded, err := dns.LoadHosts(cfg.HostsFile)
+		if err != nil {
+			log.Printf("[DNS] Ignoring hosts_file: %v", err)
+		} else {
+			hosts = loaded
+		}
+	}
+	for name, ips := range cfg.Hosts {
+		delete(hosts, strings.ToLower(strings.TrimSuffix(name, ".")))
+		for _, ip := range ips {
+			hosts.Add(name, net.ParseIP(ip))
+		}
+	}
+	return hosts
+}
+
+// dnsRuleMatcher returns the names r applies to, using the routing
+// engine's matchers: r.Domains parsed like routing.direct, and direct
+// (nil = everything tunneled) for r.Routing.
+func dnsRuleMatcher(r config.DNSRule, direct *outbound.HostMatcher) (func(name string) bool, error) {
+	domains := func(string) bool { return true }
+	if len(r.Domains) > 0 {
+		m, err := outbound.ParseHostMatcher(r.Domains)
+		if err != nil {
+			return nil, err
+		}
+		domains = m.Match
+	}
+	switch r.Routing {
+	case "direct":
+		return func(name string) bool { return direct != nil && direct.Match(name) && domains(name) }, nil
+	case "tunnel":
+		return func(name string) bool { return (direct == nil || !direct.Match(name)) && domains(name) }, nil
+	}
+	return domains, nil
+}
+
+// dnsResolver returns an uncached resolver for servers, nil for none or
+// ["system"].
+func dnsResolver(servers []string, timeout time.Duration, dial dns.DialFunc) (*dns.Resolver, error) {
+	if len(servers) == 0 || len(servers) == 1 && servers[0] == "system" {
+		return nil, nil
+	}
+	upstreams, err := dnsUpstreams(servers, timeout, dial)
+	if err != nil {
+		return nil, err
+	}
+	return dns.NewResolver(upstreams, 0, timeout), nil
+}
+
+func dnsUpstreams(servers []string, timeout time.Duration, dial dns.DialFunc) ([]dns.Upstream, error) {
+	var upstreams []dns.Upstream
+	for _, s := range servers {
+		u, err := dns.ParseUpstream(s, timeout, dial)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
+
+// tunnelDial opens a connection of the client's own requests, such as a
+// DNS upstream's or a geodata download, as a tunnel stream.
+func (c *Client) tunnelDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	stream, err := c.Dial(protocol.ProtocolSOCKS5, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{ReadWriteCloser: stream, remote: addr}, nil
+}
+
+// DNSCache is a snapshot of the client's DNS cache.
+type DNSCache struct {
+	Enabled bool   `json:"enabled"`
+	Names   int    `json:"names"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// DNSCache returns the state of the cache of the client's own lookups.
+func (c *Client) DNSCache() DNSCache {
+	if c.lookup == nil || c.Config.DNS.CacheSize < 0 {
+		return DNSCache{}
+	}
+	hits, misses, names := c.lookup.Stats()
+	return DNSCache{Enabled: true, Names: names, Hits: hits, Misses: misses}
+}
+
+// FlushDNS empties the DNS cache, e.g. after the server moved to a new
+// address, and returns how many names it held.
+func (c *Client) FlushDNS() int {
+	if c.lookup == nil {
+		return 0
+	}
+	return c.lookup.Flush()
+}
+
+// staticTarget replaces the host of target ("host:port") by its first
+// static address, if it has one.
+func (c *Client) staticTarget(target string) string {
+	if c.lookup == nil || target == "" {
+		return target
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return target
+	}
+	if ips := c.lookup.Static(host); len(ips) > 0 {
+		return net.JoinHostPort(ips[0].String(), port)
+	}
+	return target
+}
+
+// directDialer returns the dialer of targets connected to directly.
+func (c *Client) directDialer() outbound.Direct {
+	var d outbound.Direct
+	if c.lookup != nil {
+		d.Resolve = c.lookup.LookupIP
+	}
+	return d
+}
@@ -0,0 +1,32 @@
+package transport
+
+import (
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"testing"
+)
+
+func TestDNSRuleMatcher(t *testing.T) {
+	direct, _ := outbound.ParseHostMatcher([]string{"example.ir", "10.0.0.0/8"})
+	cases := []struct {
+		rule config.DNSRule
+		name string
+		want bool
+	}{
+		{config.DNSRule{Domains: []string{"*.corp.example"}}, "git.corp.example", true},
+		{config.DNSRule{Domains: []string{"*.corp.example"}}, "example", false},
+		{config.DNSRule{Routing: "direct"}, "shop.example.ir", true},
+		{config.DNSRule{Routing: "direct"}, "example.com", false},
+		{config.DNSRule{Routing: "tunnel"}, "example.com", true},
+		{config.DNSRule{Routing: "tunnel", Domains: []string{"corp.example"}}, "example.com", false},
+	}
+	for _, c := range cases {
+		match, err := dnsRuleMatcher(c.rule, direct)
+		if err != nil {
+			t.Fatalf("Failed to build matcher for %+v: %v", c.rule, err)
+		}
+		if got := match(c.name); got != c.want {
+			t.Errorf("Expected %+v to match %s: %v, got %v", c.rule, c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"sync/atomic"
+	"time"
+)
+
+// Default pause between reachability probes while falling back.
+const defaultProbeInterval = 10 * time.Second
+
+// Fallback implements allow_direct_fallback and [fallback]: once the server
+// has been unreachable for fallback.after, covered targets are dialed
+// directly without trying the tunnel first. A probe checks the server every
+// fallback.probe_interval and switches back to the tunnel when it answers;
+// connections opened directly in the meantime stay direct until they close.
+type Fallback struct {
+	client        *Client
+	hosts         *outbound.HostMatcher // nil = every target
+	inbounds      map[string]bool       // nil = every inbound
+	after         time.Duration
+	probeInterval time.Duration
+	direct        outbound.Direct
+	active        atomic.Bool
+}
+
+// newFallback returns the direct fallback policy of c.Config, or nil when
+// neither allow_direct_fallback nor fallback.hosts is set.
+func newFallback(c *Client) (*Fallback, error) {
+	cfg := c.Config
+	if !cfg.AllowDirectFallback && len(cfg.Fallback.Hosts) == 0 {
+		return nil, nil
+	}
+	f := &Fallback{
+		client:        c,
+		direct:        c.directDialer(),
+		after:         cfg.Fallback.After,
+		probeInterval: cfg.Fallback.ProbeInterval,
+	}
+	if f.probeInterval <= 0 {
+		f.probeInterval = defaultProbeInterval
+	}
+	if !cfg.AllowDirectFallback {
+		hosts, err := outbound.ParseHostMatcher(cfg.Fallback.Hosts)
+		if err != nil {
+			return nil, err
+		}
+		f.hosts = hosts
+	}
+	if len(cfg.Fallback.Inbounds) > 0 {
+		f.inbounds = make(map[string]bool)
+		for _, tag := range cfg.Fallback.Inbounds {
+			f.inbounds[tag] = true
+		}
+	}
+	return f, nil
+}
+
+// Dial opens a stream to target for the named inbound like Client.Dial, or
+// a direct connection while the fallback is active and covers both. Streams
+// without a target (UDP associations, SSH and Shadowsocks inbounds) never
+// fall back.
+func (f *Fallback) Dial(inbound string, proto protocol.ProtocolType, target string) (io.ReadWriteCloser, error) {
+	covered := f.covers(inbound, target)
+	if covered && f.active.Load() {
+		return f.direct.Dial(target)
+	}
+	conn, err := f.client.dialFrom(inbound, proto, target)
+	if err == nil || !covered || !errors.Is(err, ErrServerUnreachable) {
+		return conn, err
+	}
+	down := f.client.unreachableFor()
+	if down < f.after {
+		return nil, err
+	}
+	if f.active.CompareAndSwap(false, true) {
+		log.Printf("[Fallback] Server unreachable for %v (%v), connecting directly", down.Round(time.Second), err)
+		go f.probe()
+	}
+	return f.direct.Dial(target)
+}
+
+func (f *Fallback) covers(inbound, target string) bool {
+	if target == "" || (f.inbounds != nil && !f.inbounds[inbound]) {
+		return false
+	}
+	if f.hosts == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(target)
+	return err == nil && f.hosts.Match(host)
+}
+
+// probe waits for the server to answer again, then ends the fallback.
+func (f *Fallback) probe() {
+	for {
+		time.Sleep(f.probeInterval)
+		f.client.mu.RLock()
+		pool := poolOf(f.client.httpClient)
+		f.client.mu.RUnlock()
+		if err := pool.probe(defaultKeepaliveTimeout); err != nil {
+			continue
+		}
+		f.client.downSince.Store(0)
+		f.active.Store(false)
+		log.Println("[Fallback] Server reachable again, returning to the tunnel")
+		return
+	}
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"time"
+)
+
+// FeatureFeedback marks servers that serve the congestion control stream:
+// for every byte the client writes on a protocolFeedback stream, the server
+// answers with a report of the TCP statistics of the connection carrying
+// it, 12 bytes: smoothed RTT in microseconds, data segments sent and
+// segments retransmitted, each a big-endian uint32. Servers that can't read
+// the statistics report zeroes.
+const FeatureFeedback protocol.Feature = "feedback"
+
+// protocolFeedback is the protocol of the control stream.
+const protocolFeedback protocol.ProtocolType = "feedback"
+
+func init() {
+	protocol.RegisterFeature(FeatureFeedback)
+}
+
+// Defaults for config.CongestionFeedback.
+const (
+	defaultFeedbackInterval = 5 * time.Second
+	defaultMaxConns         = 4
+)
+
+const (
+	feedbackReportSize = 12
+
+	// A direction that sent fewer segments in an interval says nothing
+	// about loss.
+	minFeedbackSegments = 100
+
+	// lossHigh adds a connection; lossLow for calmIntervals in a row
+	// removes one.
+	lossHigh      = 0.02
+	lossLow       = 0.005
+	calmIntervals = 3
+)
+
+// tcpStats are the kernel's statistics of one TCP socket.
+type tcpStats struct {
+	rtt     time.Duration
+	segsOut uint32
+	retrans uint32
+}
+
+func (s tcpStats) report() []byte {
+	b := make([]byte, feedbackReportSize)
+	binary.BigEndian.PutUint32(b[0:], uint32(s.rtt/time.Microsecond))
+	binary.BigEndian.PutUint32(b[4:], s.segsOut)
+	binary.BigEndian.PutUint32(b[8:], s.retrans)
+	return b
+}
+
+func parseReport(b []byte) tcpStats {
+	return tcpStats{
+		rtt:     time.Duration(binary.BigEndian.Uint32(b[0:])) * time.Microsecond,
+		segsOut: binary.BigEndian.Uint32(b[4:]),
+		retrans: binary.BigEndian.Uint32(b[8:]),
+	}
+}
+
+// serveFeedback answers the client's polls on a control stream with reports
+// about conn, until the stream ends.
+func serveFeedback(stream io.ReadWriter, conn net.Conn) error {
+	var poll [1]byte
+	for {
+		if _, err := io.ReadFull(stream, poll[:]); err != nil {
+			return nil
+		}
+		var st tcpStats
+		if conn != nil {
+			st, _ = readTCPInfo(conn)
+		}
+		if _, err := stream.Write(st.report()); err != nil {
+			return err
+		}
+	}
+}
+
+// tcpDelta sums the segments sent and retransmitted by the tracked
+// connections since the previous call with the same prev, which it updates.
+// Connections are counted from their second call on.
+func (t *connTracker) tcpDelta(prev map[*trackedConn]tcpStats) (segs, retrans uint32) {
+	t.mu.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	seen := make(map[*trackedConn]bool, len(conns))
+	for _, c := range conns {
+		st, ok := readTCPInfo(c)
+		if !ok {
+			continue
+		}
+		seen[c] = true
+		if p, ok := prev[c]; ok {
+			segs += st.segsOut - p.segsOut
+			retrans += st.retrans - p.retrans
+		}
+		prev[c] = st
+	}
+	for c := range prev {
+		if !seen[c] {
+			delete(prev, c)
+		}
+	}
+	return segs, retrans
+}
+
+// congestionControl picks the number of parallel server connections from
+// the loss of each interval.
+type congestionControl struct {
+	min, max, want int
+	calm           int // Consecutive intervals below lossLow
+}
+
+// update feeds the loss of one interval and reports whether want changed.
+func (cc *congestionControl) update(loss float64) bool {
+	switch {
+	case loss >= lossHigh:
+		cc.calm = 0
+		if cc.want < cc.max {
+			cc.want++
+			return true
+		}
+	case loss < lossLow:
+		if cc.calm++; cc.calm >= calmIntervals && cc.want > cc.min {
+			cc.calm = 0
+			cc.want--
+			return true
+		}
+	default:
+		cc.calm = 0
+	}
+	return false
+}
+
+// lossRate returns retrans/segs, or false when too few segments were sent.
+func lossRate(segs, retrans uint32) (float64, bool) {
+	if segs < minFeedbackSegments {
+		return 0, false
+	}
+	return float64(retrans) / float64(segs), true
+}
+
+// runCongestionFeedback keeps a control stream open for as long as the
+// client lives and sets the number of parallel connections from it.
+func (c *Client) runCongestionFeedback(cfg config.CongestionFeedback) {
+	interval, maxConns := cfg.Interval, cfg.MaxConns
+	if interval <= 0 {
+		interval = defaultFeedbackInterval
+	}
+	if maxConns <= 0 {
+		maxConns = defaultMaxConns
+	}
+	base := max(c.Config.Connections, 1)
+	cc := &congestionControl{min: base, max: max(maxConns, base), want: base}
+	var lastErr string
+	for {
+		err := c.pollFeedback(interval, cc)
+		if c.closedErr() != nil {
+			return
+		}
+		if msg := fmt.Sprint(err); err != nil && msg != lastErr {
+			log.Printf("[Congestion] Control stream failed: %v", err)
+			lastErr = msg
+		}
+		if !c.sleep(interval) {
+			return
+		}
+	}
+}
+
+// pollFeedback runs one control stream until it fails.
+func (c *Client) pollFeedback(interval time.Duration, cc *congestionControl) error {
+	rwc, err := c.Dial(protocolFeedback, "")
+	if err != nil {
+		return err
+	}
+	defer rwc.Close()
+	defer context.AfterFunc(c.ctx, func() { rwc.Close() })()
+	if s, ok := rwc.(*Stream); ok && !protocol.HasFeature(s.Features, FeatureFeedback) {
+		return errors.New("server does not support congestion feedback")
+	}
+
+	var down tcpStats
+	haveDown := false
+	up := map[*trackedConn]tcpStats{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	report := make([]byte, feedbackReportSize)
+	for range ticker.C {
+		if c.lowPower.Load() {
+			continue
+		}
+		timer := time.AfterFunc(interval, func() { rwc.Close() })
+		start := time.Now()
+		_, err := rwc.Write([]byte{0})
+		if err == nil {
+			_, err = io.ReadFull(rwc, report)
+		}
+		timer.Stop()
+		if err != nil {
+			return err
+		}
+		rtt := time.Since(start)
+
+		st := parseReport(report)
+		downLoss, downOK := 0.0, false
+		if haveDown {
+			downLoss, downOK = lossRate(st.segsOut-down.segsOut, st.retrans-down.retrans)
+		}
+		down, haveDown = st, true
+		upLoss, upOK := lossRate(c.conns.tcpDelta(up))
+		if !downOK && !upOK {
+			continue
+		}
+		if cc.update(max(downLoss, upLoss)) {
+			c.parallel.Store(int32(cc.want))
+			log.Printf("[Congestion] Loss %.1f%% down, %.1f%% up, RTT %v (stream %v): using %d parallel connections",
+				downLoss*100, upLoss*100, st.rtt, rtt.Round(time.Millisecond), cc.want)
+		}
+	}
+	return nil
+}
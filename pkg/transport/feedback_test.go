@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"io"
+	"phoenix/pkg/config"
+	"testing"
+)
+
+func TestCongestionControl(t *testing.T) {
+	cc := &congestionControl{min: 1, max: 2, want: 1}
+	steps := []struct {
+		loss float64
+		want int
+	}{
+		{0.05, 2},
+		{0.05, 2}, // Capped at max
+		{0.001, 2},
+		{0.01, 2}, // Between the thresholds: the calm streak restarts
+		{0.001, 2},
+		{0.001, 2},
+		{0.001, 1},
+		{0.001, 1},
+	}
+	for i, s := range steps {
+		cc.update(s.loss)
+		if cc.want != s.want {
+			t.Errorf("Step %d (loss %v): Expected %d connections, got %d", i, s.loss, s.want, cc.want)
+		}
+	}
+}
+
+func TestPipeFeedback(t *testing.T) {
+	t.Parallel()
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, config.DefaultServerConfig()))
+	stream, err := client.Dial(protocolFeedback, "")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer stream.Close()
+	report := make([]byte, feedbackReportSize)
+	for range 2 {
+		stream.Write([]byte{0})
+		if _, err := io.ReadFull(stream, report); err != nil {
+			t.Fatalf("Failed to read report: %v", err)
+		}
+	}
+	// Pipes have no TCP statistics.
+	if st := parseReport(report); st != (tcpStats{}) {
+		t.Errorf("Expected an empty report, got %+v", st)
+	}
+}
@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"phoenix/pkg/config"
+	"sync"
+	"time"
+)
+
+// Defaults for [limits].
+const (
+	defaultMaxStreamsPerConn = 500
+	defaultStreamRate        = 200
+	defaultStreamBuffer      = 1 << 20
+)
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to
+// burst. It is not safe for concurrent use.
+type tokenBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+	dropping    bool // the last take failed; used to log floods once
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket has refilled, i.e. holds no state worth
+// keeping.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// floodGuard enforces [limits] across connections: handshakes per IP, new
+// streams per connection and concurrent streams per IP.
+type floodGuard struct {
+	cfg config.ServerLimits
+
+	mu         sync.Mutex
+	handshakes map[string]*tokenBucket
+	streams    map[string]int
+	lastSweep  time.Time
+}
+
+func newFloodGuard(cfg config.ServerLimits) *floodGuard {
+	if cfg.MaxStreamsPerConn == 0 {
+		cfg.MaxStreamsPerConn = defaultMaxStreamsPerConn
+	}
+	if cfg.StreamRate == 0 {
+		cfg.StreamRate = defaultStreamRate
+	}
+	if cfg.StreamBuffer == 0 {
+		cfg.StreamBuffer = defaultStreamBuffer
+	}
+	if cfg.ConnBuffer == 0 {
+		cfg.ConnBuffer = defaultStreamBuffer
+	}
+	// A connection window smaller than a stream's would stall that stream.
+	cfg.ConnBuffer = max(cfg.ConnBuffer, cfg.StreamBuffer)
+	return &floodGuard{
+		cfg:        cfg,
+		handshakes: make(map[string]*tokenBucket),
+		streams:    make(map[string]int),
+		lastSweep:  time.Now(),
+	}
+}
+
+// connKey is the context key for a connection's *connLimits.
+type connKey struct{}
+
+// connLimits is the per-connection state, attached to every request context
+// through http.Server.ConnContext.
+type connLimits struct {
+	conn net.Conn
+	mu   sync.Mutex
+	rate *tokenBucket // nil = unlimited
+	prio *prioGate    // Schedules the connection's streams by priority class
+}
+
+// connContext is used as http.Server.ConnContext.
+func (g *floodGuard) connContext(ctx context.Context, c net.Conn) context.Context {
+	cl := &connLimits{conn: c, prio: newPrioGate()}
+	if g.cfg.StreamRate > 0 {
+		cl.rate = newTokenBucket(float64(g.cfg.StreamRate), 2*float64(g.cfg.StreamRate))
+	}
+	return context.WithValue(ctx, connKey{}, cl)
+}
+
+// allowStream counts a new stream against its connection's rate. When the
+// rate is exceeded the whole connection is closed: a client resetting
+// streams as fast as it opens them never lets them reach a handler limit.
+func (g *floodGuard) allowStream(r *http.Request) bool {
+	cl, _ := r.Context().Value(connKey{}).(*connLimits)
+	if cl == nil || cl.rate == nil {
+		return true
+	}
+	cl.mu.Lock()
+	ok := cl.rate.take(time.Now())
+	cl.mu.Unlock()
+	if !ok {
+		log.Printf("[Limits] Closing connection from %s: more than %d new streams/s", r.RemoteAddr, g.cfg.StreamRate)
+		cl.conn.Close()
+	}
+	return ok
+}
+
+// acquireClient reserves one of the client IP's concurrent streams. release
+// must be called when the stream ends.
+func (g *floodGuard) acquireClient(remoteAddr string) (release func(), ok bool) {
+	if g.cfg.MaxStreamsPerClient <= 0 {
+		return func() {}, true
+	}
+	ip := hostOf(remoteAddr)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.streams[ip] >= g.cfg.MaxStreamsPerClient {
+		return nil, false
+	}
+	g.streams[ip]++
+	return func() {
+		g.mu.Lock()
+		if g.streams[ip]--; g.streams[ip] <= 0 {
+			delete(g.streams, ip)
+		}
+		g.mu.Unlock()
+	}, true
+}
+
+// allowHandshake counts a new connection from addr.
+func (g *floodGuard) allowHandshake(addr net.Addr) bool {
+	ip := hostOf(addr.String())
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.lastSweep) > time.Minute {
+		for k, b := range g.handshakes {
+			if b.full(now) {
+				delete(g.handshakes, k)
+			}
+		}
+		g.lastSweep = now
+	}
+	b := g.handshakes[ip]
+	if b == nil {
+		n := float64(g.cfg.HandshakesPerMinute)
+		b = newTokenBucket(n/60, n)
+		g.handshakes[ip] = b
+	}
+	if !b.take(now) {
+		if !b.dropping {
+			log.Printf("[Limits] Refusing connections from %s: more than %d handshakes/min", ip, g.cfg.HandshakesPerMinute)
+			b.dropping = true
+		}
+		return false
+	}
+	b.dropping = false
+	return true
+}
+
+// listener applies HandshakesPerMinute to ln.
+func (g *floodGuard) listener(ln net.Listener) net.Listener {
+	if g.cfg.HandshakesPerMinute <= 0 {
+		return ln
+	}
+	return &floodListener{Listener: ln, guard: g}
+}
+
+type floodListener struct {
+	net.Listener
+	guard *floodGuard
+}
+
+func (l *floodListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.guard.allowHandshake(c.RemoteAddr()) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
+
+// hostOf returns the host part of a "host:port" address.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"phoenix/pkg/config"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 2, burst: 2, tokens: 2, last: now}
+	if !b.take(now) || !b.take(now) {
+		t.Fatalf("Expected the burst to be available")
+	}
+	if b.take(now) {
+		t.Errorf("Expected the empty bucket to refuse")
+	}
+	if b.full(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Expected the bucket to refill 1 token in 500ms, not 2")
+	}
+	if !b.take(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Expected a token after 500ms at 2/s")
+	}
+	if !b.full(now.Add(10 * time.Second)) {
+		t.Errorf("Expected the bucket to be full again after 10s")
+	}
+}
+
+func TestFloodStreamRate(t *testing.T) {
+	g := newFloodGuard(config.ServerLimits{StreamRate: 2})
+	local, remote := net.Pipe()
+	defer remote.Close()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(g.connContext(r.Context(), local))
+
+	// The burst is twice the rate.
+	for i := range 4 {
+		if !g.allowStream(r) {
+			t.Fatalf("Expected stream %d within the burst to be allowed", i)
+		}
+	}
+	if g.allowStream(r) {
+		t.Errorf("Expected the stream over the rate to be refused")
+	}
+	if _, err := local.Write([]byte{0}); err == nil {
+		t.Errorf("Expected the flooding connection to be closed")
+	}
+}
+
+func TestFloodStreamsPerClient(t *testing.T) {
+	g := newFloodGuard(config.ServerLimits{MaxStreamsPerClient: 2})
+	var releases []func()
+	for i := range 2 {
+		release, ok := g.acquireClient("192.0.2.1:1000")
+		if !ok {
+			t.Fatalf("Expected stream %d to be allowed", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := g.acquireClient("192.0.2.1:2000"); ok {
+		t.Errorf("Expected a third stream from the same IP to be refused")
+	}
+	if release, ok := g.acquireClient("192.0.2.2:1000"); !ok {
+		t.Errorf("Expected another IP to be unaffected")
+	} else {
+		release()
+	}
+	releases[0]()
+	if release, ok := g.acquireClient("192.0.2.1:3000"); !ok {
+		t.Errorf("Expected a released stream to free its slot")
+	} else {
+		release()
+	}
+	releases[1]()
+	if len(g.streams) != 0 {
+		t.Errorf("Expected no state left for idle IPs, got %v", g.streams)
+	}
+}
+
+func TestFloodHandshakes(t *testing.T) {
+	g := newFloodGuard(config.ServerLimits{HandshakesPerMinute: 2})
+	flooder := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+	for i := range 2 {
+		if !g.allowHandshake(flooder) {
+			t.Fatalf("Expected handshake %d to be allowed", i)
+		}
+	}
+	if g.allowHandshake(flooder) {
+		t.Errorf("Expected the third handshake in a minute to be refused")
+	}
+	if !g.allowHandshake(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}) {
+		t.Errorf("Expected another IP to be unaffected")
+	}
+
+	// 2/min refills one token every 30s.
+	g.mu.Lock()
+	g.handshakes["192.0.2.1"].last = time.Now().Add(-31 * time.Second)
+	g.mu.Unlock()
+	if !g.allowHandshake(flooder) {
+		t.Errorf("Expected a handshake after the bucket refilled")
+	}
+	if g.allowHandshake(flooder) {
+		t.Errorf("Expected only one token to have refilled")
+	}
+}
+
+func TestFloodResponses(t *testing.T) {
+	cfg := config.DefaultServerConfig()
+	cfg.Limits.MaxStreamsPerClient = 1
+	s := NewServer(cfg)
+	release, _ := s.flood.acquireClient("192.0.2.1:1000")
+	defer release()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "192.0.2.1:2000"
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 over max_streams_per_client, got %d", w.Code)
+	}
+
+	// Any process runs more than one goroutine, so every stream is shed.
+	cfg = config.DefaultServerConfig()
+	cfg.Limits.MaxGoroutines = 1
+	w = httptest.NewRecorder()
+	NewServer(cfg).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != busyRetryAfter {
+		t.Errorf("Expected 503 with Retry-After when overloaded, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"net/netip"
+	"phoenix/pkg/config"
+	"phoenix/pkg/ipfix"
+	"time"
+)
+
+// defaultActiveTimeout is how often open streams are reported when
+// flow_export.active_timeout is unset.
+const defaultActiveTimeout = 60 * time.Second
+
+// flowExporter reports a server's streams to its flow_export collector:
+// once when they end and every active timeout while they last, each record
+// with the bytes since the stream's previous one.
+type flowExporter struct {
+	out    *ipfix.Exporter
+	active time.Duration
+}
+
+// newFlowExporter returns nil when flow export is disabled.
+func newFlowExporter(cfg config.FlowExport) (*flowExporter, error) {
+	if cfg.Collector == "" {
+		return nil, nil
+	}
+	out, err := ipfix.NewExporter(cfg.Collector, cfg.DomainID)
+	if err != nil {
+		return nil, err
+	}
+	f := &flowExporter{out: out, active: cfg.ActiveTimeout}
+	if f.active == 0 {
+		f.active = defaultActiveTimeout
+	}
+	go f.run()
+	return f, nil
+}
+
+// run reports the streams whose last record is an active timeout old.
+func (f *flowExporter) run() {
+	for range time.Tick(time.Second) {
+		serverConns.mu.Lock()
+		var due []*liveConn
+		now := time.Now()
+		for _, lc := range serverConns.live {
+			if at, ok := lc.flowMark(f); ok && now.Sub(at) >= f.active {
+				due = append(due, lc)
+			}
+		}
+		serverConns.mu.Unlock()
+		for _, lc := range due {
+			f.report(lc, ipfix.ReasonActive)
+		}
+	}
+}
+
+// report exports lc's bytes since its previous record.
+func (f *flowExporter) report(lc *liveConn, reason uint8) {
+	now := time.Now()
+	up, down := lc.up.Load(), lc.down.Load()
+	lc.mu.Lock()
+	dUp, dDn := up-lc.flowUp, down-lc.flowDn
+	lc.flowUp, lc.flowDn, lc.flowAt = up, down, now
+	lc.mu.Unlock()
+
+	src, _ := netip.ParseAddrPort(lc.info.Remote)
+	f.out.Export(ipfix.Flow{
+		Source:         src,
+		Target:         lc.info.Target,
+		User:           lc.info.User,
+		Start:          lc.info.Started,
+		End:            now,
+		BytesUp:        uint64(dUp),
+		BytesDn:        uint64(dDn),
+		Reason:         reason,
+		ProtocolNumber: 6, // Targets are TCP
+	})
+}
+
+// flowMark returns when lc was last reported, if f exports it.
+func (lc *liveConn) flowMark(f *flowExporter) (time.Time, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.flows != f {
+		return time.Time{}, false
+	}
+	if lc.flowAt.IsZero() {
+		return lc.info.Started, true
+	}
+	return lc.flowAt, true
+}
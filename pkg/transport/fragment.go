@@ -0,0 +1,174 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"phoenix/pkg/config"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	recordTypeHandshake = 0x16
+	recordHeaderLen     = 5
+)
+
+// fragmentConn rewrites the first TLS flight (the ClientHello) written to it:
+// the handshake message is split across several TLS records, and the records
+// are sent in separate TCP segments. Both are legal TLS, but DPI boxes that
+// only inspect the first record or first segment no longer see a complete SNI.
+type fragmentConn struct {
+	net.Conn
+	cfg  config.TLSFragment
+	sni  string
+	done bool
+}
+
+// newFragmentConn wraps conn when fragmentation is enabled.
+func newFragmentConn(conn net.Conn, cfg config.TLSFragment, sni string) net.Conn {
+	if !cfg.Enabled {
+		return conn
+	}
+	raw := conn
+	if tc, ok := raw.(*trackedConn); ok {
+		raw = tc.Conn
+	}
+	if tc, ok := raw.(*net.TCPConn); ok {
+		tc.SetNoDelay(true)
+	}
+	return &fragmentConn{Conn: conn, cfg: cfg, sni: sni}
+}
+
+func (c *fragmentConn) Write(b []byte) (int, error) {
+	if c.done {
+		return c.Conn.Write(b)
+	}
+	c.done = true
+
+	if len(b) < recordHeaderLen || b[0] != recordTypeHandshake {
+		return c.Conn.Write(b)
+	}
+	size := int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < recordHeaderLen+size {
+		return c.Conn.Write(b)
+	}
+
+	records := splitHandshakeRecord(b[:recordHeaderLen], b[recordHeaderLen:recordHeaderLen+size], c.splitPoints(b[recordHeaderLen:recordHeaderLen+size]))
+	rest := b[recordHeaderLen+size:]
+
+	for _, rec := range records {
+		for _, seg := range c.segments(rec) {
+			if _, err := c.Conn.Write(seg); err != nil {
+				return 0, err
+			}
+			if c.cfg.Delay > 0 {
+				time.Sleep(c.cfg.Delay)
+			}
+		}
+	}
+	if len(rest) > 0 {
+		if _, err := c.Conn.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// splitPoints returns the offsets at which the handshake payload is cut into
+// records: every record_size bytes, or by default once in the middle of the
+// SNI hostname.
+func (c *fragmentConn) splitPoints(payload []byte) []int {
+	var points []int
+	if c.cfg.RecordSize > 0 {
+		for off := c.cfg.RecordSize; off < len(payload); off += c.cfg.RecordSize {
+			points = append(points, off)
+		}
+		return points
+	}
+	if c.sni != "" {
+		if i := bytes.Index(payload, []byte(c.sni)); i >= 0 {
+			return []int{i + len(c.sni)/2}
+		}
+	}
+	// No SNI found: cut somewhere inside the first bytes of the hello.
+	if len(payload) < 2 {
+		return nil
+	}
+	return []int{1 + rand.Intn(min(len(payload)-1, 32))}
+}
+
+// splitHandshakeRecord re-frames payload as one record per fragment, reusing
+// the original record header's type and version.
+func splitHandshakeRecord(header, payload []byte, points []int) [][]byte {
+	var out [][]byte
+	prev := 0
+	for _, p := range append(points, len(payload)) {
+		if p <= prev || p > len(payload) {
+			continue
+		}
+		rec := make([]byte, recordHeaderLen, recordHeaderLen+p-prev)
+		copy(rec, header[:3])
+		binary.BigEndian.PutUint16(rec[3:], uint16(p-prev))
+		out = append(out, append(rec, payload[prev:p]...))
+		prev = p
+	}
+	return out
+}
+
+// segments splits one record into TCP writes of at most segment_size bytes
+// (the whole record when unset).
+func (c *fragmentConn) segments(rec []byte) [][]byte {
+	n := c.cfg.SegmentSize
+	if n <= 0 || n >= len(rec) {
+		return [][]byte{rec}
+	}
+	var out [][]byte
+	for len(rec) > n {
+		out = append(out, rec[:n])
+		rec = rec[n:]
+	}
+	return append(out, rec)
+}
+
+// padClientHello grows a uTLS ClientHello to at least size bytes using the
+// padding extension (RFC 7685), so the SNI no longer fits in a first segment
+// of typical size.
+func padClientHello(uConn *utls.UConn, size int) error {
+	if err := uConn.BuildHandshakeState(); err != nil {
+		return err
+	}
+	padLen := func(unpadded int) (int, bool) {
+		// Jitter the final length so padded hellos don't share one size.
+		n := size - unpadded - 4 + rand.Intn(64)
+		if n <= 0 {
+			return 0, false
+		}
+		return n, true
+	}
+
+	var pad *utls.UtlsPaddingExtension
+	for _, ext := range uConn.Extensions {
+		if p, ok := ext.(*utls.UtlsPaddingExtension); ok {
+			pad = p
+		}
+	}
+	if pad == nil {
+		pad = &utls.UtlsPaddingExtension{}
+		// pre_shared_key must stay the last extension.
+		exts := uConn.Extensions
+		if n := len(exts); n > 0 {
+			if _, ok := exts[n-1].(utls.PreSharedKeyExtension); ok {
+				uConn.Extensions = append(append(exts[:n-1:n-1], pad), exts[n-1])
+			} else {
+				uConn.Extensions = append(exts, pad)
+			}
+		} else {
+			uConn.Extensions = append(exts, pad)
+		}
+	}
+	pad.GetPaddingLen = padLen
+	return uConn.MarshalClientHello()
+}
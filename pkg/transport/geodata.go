@@ -0,0 +1,34 @@
+package transport
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"phoenix/pkg/config"
+	"phoenix/pkg/geodata"
+	"time"
+)
+
+// runGeoData starts the client's updaters of [[geodata]], downloading
+// lists with tunnel set through the tunnel.
+func (c *Client) runGeoData() {
+	tunnel := &http.Client{Timeout: time.Minute, Transport: &http.Transport{DialContext: c.tunnelDial}}
+	runGeoData(c.ctx, c.Config.GeoData, tunnel)
+}
+
+// runGeoData starts the updaters of lists until ctx is done; tunnel
+// fetches those with tunnel set (nil = directly).
+func runGeoData(ctx context.Context, lists []config.GeoData, tunnel *http.Client) {
+	for _, g := range lists {
+		var client *http.Client
+		if g.Tunnel {
+			client = tunnel
+		}
+		src, err := geodata.New(g, client)
+		if err != nil {
+			log.Printf("[GeoData] Ignoring %s: %v", g.Name, err)
+			continue
+		}
+		go src.Run(ctx)
+	}
+}
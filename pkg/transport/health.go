@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"phoenix/pkg/config"
+	"phoenix/pkg/loglevel"
+	"sync"
+	"time"
+)
+
+// Defaults for config.HealthCheck.
+const (
+	defaultHealthWindow    = time.Minute
+	defaultHealthThreshold = 0.5
+	defaultSlowDial        = 3 * time.Second
+	defaultHealthProbe     = 5 * time.Second
+
+	// healthMinDials is how many outcomes the window needs before the
+	// breaker may open, so one failed Dial after a quiet hour doesn't.
+	healthMinDials = 5
+)
+
+// dialOutcome is one scored Dial.
+type dialOutcome struct {
+	at      time.Time
+	score   float64
+	latency time.Duration // Time to the server's answer; 0 when it never came
+}
+
+// health is the circuit breaker of config.HealthCheck. Unlike failureCount,
+// which counts transport errors to decide on a hard reset, it only scores
+// whether the server answers, and how fast.
+type health struct {
+	window, slow, probeInterval time.Duration
+	threshold                   float64
+
+	mu       sync.Mutex
+	outcomes []dialOutcome // Oldest first, within window
+	open     bool          // Dials fail at once; a probe is running
+}
+
+// newHealth returns the breaker for cfg, or nil when it is disabled.
+func newHealth(cfg config.HealthCheck) *health {
+	if !cfg.On() {
+		return nil
+	}
+	h := &health{
+		window:        cfg.Window,
+		slow:          cfg.SlowDial,
+		probeInterval: cfg.ProbeInterval,
+		threshold:     cfg.Threshold,
+	}
+	if h.window <= 0 {
+		h.window = defaultHealthWindow
+	}
+	if h.slow <= 0 {
+		h.slow = defaultSlowDial
+	}
+	if h.probeInterval <= 0 {
+		h.probeInterval = defaultHealthProbe
+	}
+	if h.threshold <= 0 {
+		h.threshold = defaultHealthThreshold
+	}
+	return h
+}
+
+// unhealthyStatus reports whether an HTTP status means the server behind a
+// CDN or reverse proxy didn't answer: 502-504, and Cloudflare's 520-526.
+func unhealthyStatus(code int) bool {
+	switch {
+	case code == http.StatusBadGateway, code == http.StatusServiceUnavailable, code == http.StatusGatewayTimeout:
+		return true
+	case code >= 520 && code <= 526:
+		return true
+	}
+	return false
+}
+
+// record scores a Dial that took latency and failed with err, and reports
+// whether this opened the breaker.
+func (h *health) record(latency time.Duration, err error) bool {
+	o := dialOutcome{at: time.Now(), score: 1, latency: latency}
+	switch {
+	case errors.Is(err, ErrServerUnreachable):
+		o.score, o.latency = 0, 0
+	case latency > h.slow:
+		o.score = 0.5
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire(o.at)
+	h.outcomes = append(h.outcomes, o)
+	if h.open || len(h.outcomes) < healthMinDials {
+		return false
+	}
+	if score, _ := h.score(); score < h.threshold {
+		h.open = true
+		return true
+	}
+	return false
+}
+
+// expire drops outcomes older than the window. Callers hold mu.
+func (h *health) expire(now time.Time) {
+	i := 0
+	for i < len(h.outcomes) && now.Sub(h.outcomes[i].at) > h.window {
+		i++
+	}
+	h.outcomes = h.outcomes[i:]
+}
+
+// score returns the mean score and answer latency of the window, 1 and 0
+// when it is empty. Callers hold mu.
+func (h *health) score() (float64, time.Duration) {
+	if len(h.outcomes) == 0 {
+		return 1, 0
+	}
+	var sum float64
+	var latency time.Duration
+	answered := 0
+	for _, o := range h.outcomes {
+		sum += o.score
+		if o.latency > 0 {
+			latency += o.latency
+			answered++
+		}
+	}
+	if answered > 0 {
+		latency /= time.Duration(answered)
+	}
+	return sum / float64(len(h.outcomes)), latency
+}
+
+// admit returns an error wrapping ErrServerUnreachable while the breaker
+// is open.
+func (h *health) admit() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.open {
+		return nil
+	}
+	score, _ := h.score()
+	return fmt.Errorf("%w: circuit breaker open (score %.2f)", ErrServerUnreachable, score)
+}
+
+// close ends an open breaker with a fresh window.
+func (h *health) close() {
+	h.mu.Lock()
+	h.open = false
+	h.outcomes = nil
+	h.mu.Unlock()
+}
+
+// ServerHealth is a snapshot of the circuit breaker.
+type ServerHealth struct {
+	Enabled bool
+	Score   float64       // Mean Dial score over the window, 0 to 1
+	Latency time.Duration // Mean time to the server's answer
+	Dials   int           // Dials in the window
+	Open    bool          // New Dials fail at once
+}
+
+// Health returns the state of the circuit breaker.
+func (c *Client) Health() ServerHealth {
+	h := c.health
+	if h == nil {
+		return ServerHealth{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire(time.Now())
+	score, latency := h.score()
+	return ServerHealth{Enabled: true, Score: score, Latency: latency, Dials: len(h.outcomes), Open: h.open}
+}
+
+// recordDial feeds a Dial outcome to the breaker, opening it and starting
+// the probe when the score collapses.
+func (c *Client) recordDial(start time.Time, err error) {
+	h := c.health
+	if h == nil {
+		return
+	}
+	opened := h.record(time.Since(start), err)
+	if loglevel.Get() == loglevel.Debug {
+		st := c.Health()
+		loglevel.Debugf("[Health] Score %.2f over %d dials", st.Score, st.Dials)
+	}
+	if !opened {
+		return
+	}
+	st := c.Health()
+	log.Printf("[Health] Server score %.2f over %d dials, below %.2f: circuit breaker open", st.Score, st.Dials, h.threshold)
+	c.downSince.CompareAndSwap(0, time.Now().UnixNano())
+	go c.probeHealth()
+}
+
+// probeHealth opens a stream every probe interval, bypassing the breaker,
+// and closes it once the server answers. Any answer will do, even a
+// refusal: it shows the server is back.
+func (c *Client) probeHealth() {
+	h := c.health
+	for c.sleep(h.probeInterval) {
+		if c.lowPower.Load() {
+			continue
+		}
+		st, _, err := c.open(protocolFeedback, "", "")
+		if errors.Is(err, ErrServerUnreachable) {
+			continue
+		}
+		if st != nil {
+			st.Close()
+		}
+		h.close()
+		c.downSince.Store(0)
+		log.Println("[Health] Server answering again, circuit breaker closed")
+		return
+	}
+}
@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"phoenix/pkg/config"
+)
+
+func TestHealthBreaker(t *testing.T) {
+	h := newHealth(config.HealthCheck{SlowDial: time.Second})
+	unreachable := fmt.Errorf("%w: connection refused", ErrServerUnreachable)
+
+	// Refusals are answers; slow answers count half.
+	for _, err := range []error{nil, ErrUnauthorized, nil} {
+		if h.record(10*time.Millisecond, err) {
+			t.Fatalf("Expected answered dials to keep the breaker closed")
+		}
+	}
+	h.record(2*time.Second, nil)
+	if h.record(0, unreachable) {
+		t.Fatalf("Expected a score of 0.7 to keep the breaker closed")
+	}
+	h.record(0, unreachable)
+	h.record(0, unreachable)
+	if !h.record(0, unreachable) {
+		t.Fatalf("Expected the breaker to open once the score falls below 0.5")
+	}
+	if err := h.admit(); !errors.Is(err, ErrServerUnreachable) {
+		t.Fatalf("Expected an open breaker to fail dials as unreachable, got %v", err)
+	}
+
+	h.close()
+	if err := h.admit(); err != nil {
+		t.Fatalf("Expected a closed breaker to admit dials, got %v", err)
+	}
+	if score, _ := h.score(); score != 1 {
+		t.Errorf("Expected a fresh window after closing, got score %.2f", score)
+	}
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"log"
+	"phoenix/pkg/config"
+	"slices"
+	"time"
+)
+
+// NoticeHints is the kind of the notice carrying config.ClientHints. It is
+// applied, not shown.
+const NoticeHints = "hints"
+
+// minHintedKeepalive bounds how often a server can make the client ping.
+const minHintedKeepalive = 10 * time.Second
+
+// ConfigHints are the tuning values of a "hints" notice.
+type ConfigHints struct {
+	KeepaliveInterval time.Duration `json:"keepalive_interval,omitempty"` // Nanoseconds
+	Padding           int           `json:"padding,omitempty"`
+	ALPN              []string      `json:"alpn,omitempty"`
+}
+
+// hintsNotice returns the notice for the server's [hints], or false when
+// none are set.
+func hintsNotice(cfg config.ClientHints) (Notice, bool) {
+	h := &ConfigHints{KeepaliveInterval: cfg.KeepaliveInterval, Padding: cfg.Padding, ALPN: cfg.ALPN}
+	if h.KeepaliveInterval == 0 && h.Padding == 0 && len(h.ALPN) == 0 {
+		return Notice{}, false
+	}
+	return Notice{Kind: NoticeHints, Hints: h, At: time.Now()}, true
+}
+
+// applyHints takes the hints the client's config leaves to the server.
+func (c *Client) applyHints(h ConfigHints) {
+	if h.KeepaliveInterval > 0 && c.Config.Keepalive.Interval == 0 {
+		h.KeepaliveInterval = max(h.KeepaliveInterval, minHintedKeepalive)
+	} else {
+		h.KeepaliveInterval = 0
+	}
+	if c.Config.Fragment.Enabled && c.Config.Fragment.Padding > 0 {
+		h.Padding = 0
+	}
+	if !slices.Contains(h.ALPN, "h2") {
+		h.ALPN = nil
+	}
+
+	c.hintMu.Lock()
+	changed := h.KeepaliveInterval != c.hints.KeepaliveInterval || h.Padding != c.hints.Padding || !slices.Equal(h.ALPN, c.hints.ALPN)
+	c.hints = h
+	c.hintMu.Unlock()
+	if changed {
+		log.Printf("[Hints] Applying server hints: keepalive interval %v, padding %d, ALPN %q", h.KeepaliveInterval, h.Padding, h.ALPN)
+	}
+}
+
+// hinted returns the hints in effect.
+func (c *Client) hinted() ConfigHints {
+	c.hintMu.Lock()
+	defer c.hintMu.Unlock()
+	return c.hints
+}
+
+// keepaliveInterval is keepalive.interval, else the server's hint, else
+// the default.
+func (c *Client) keepaliveInterval() time.Duration {
+	if c.Config.Keepalive.Interval > 0 {
+		return c.Config.Keepalive.Interval
+	}
+	if h := c.hinted().KeepaliveInterval; h > 0 {
+		return h
+	}
+	return defaultKeepaliveInterval
+}
+
+// helloPadding is fragment.padding, else the server's hint.
+func (c *Client) helloPadding() int {
+	if frag := c.Config.Fragment; frag.Enabled && frag.Padding > 0 {
+		return frag.Padding
+	}
+	return c.hinted().Padding
+}
@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Default retransmission penalty of Impairment.Loss.
+const defaultLossPenalty = 200 * time.Millisecond
+
+// Impairment degrades a PipeListener's connections like a mobile link, to
+// exercise reconnects, stalls and HTTP/2 flow control in tests. Each
+// direction is impaired on its own. The connections stay reliable and in
+// order, like the TCP connection they stand in for, so loss shows up as a
+// retransmission delay rather than missing bytes.
+type Impairment struct {
+	// Latency delays every write by this much (one way).
+	Latency time.Duration
+
+	// Jitter adds up to this much random delay per write; data still
+	// arrives in order.
+	Jitter time.Duration
+
+	// Loss is the probability (0–1) that a write is "lost" and arrives
+	// LossPenalty late, like a TCP retransmission.
+	Loss float64
+
+	// LossPenalty is the extra delay of a lost write (default 200ms).
+	LossPenalty time.Duration
+
+	// Bandwidth caps throughput in bytes per second (0 = unlimited).
+	Bandwidth int64
+}
+
+func (imp Impairment) active() bool {
+	return imp.Latency > 0 || imp.Jitter > 0 || imp.Loss > 0 || imp.Bandwidth > 0
+}
+
+// deliveryTime schedules a write of n bytes: it leaves after the bytes
+// queued before it at Bandwidth, then takes Latency plus Jitter and any
+// loss penalty, and never arrives before the previous chunk. Called with
+// b.mu held.
+func (b *pipeBuffer) deliveryTime(n int) time.Time {
+	imp := b.impair
+	now := time.Now()
+	sent := now
+	if imp.Bandwidth > 0 {
+		if b.busyUntil.After(sent) {
+			sent = b.busyUntil
+		}
+		sent = sent.Add(time.Duration(int64(n) * int64(time.Second) / imp.Bandwidth))
+		b.busyUntil = sent
+	}
+	at := sent.Add(imp.Latency)
+	if imp.Jitter > 0 {
+		at = at.Add(time.Duration(rand.Int63n(int64(imp.Jitter) + 1)))
+	}
+	if imp.Loss > 0 && rand.Float64() < imp.Loss {
+		penalty := imp.LossPenalty
+		if penalty <= 0 {
+			penalty = defaultLossPenalty
+		}
+		at = at.Add(penalty)
+	}
+	if last := len(b.chunks) - 1; last >= 0 && b.chunks[last].at.After(at) {
+		at = b.chunks[last].at
+	}
+	return at
+}
@@ -0,0 +1,304 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"phoenix/pkg/adapter/socks5"
+	"phoenix/pkg/adapter/ssh"
+	"phoenix/pkg/config"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"sync"
+)
+
+// runningInbound is a listener started by AddInbound.
+type runningInbound struct {
+	cfg config.ClientInbound
+	ln  net.Listener
+}
+
+// inboundSet holds the running inbounds by name.
+type inboundSet struct {
+	mu    sync.Mutex
+	byID  map[string]*runningInbound
+	order []string // Names in the order they were added
+}
+
+// AddInbound starts a listener for in and serves its connections through
+// the tunnel. The listener is bound when it returns. in.Name() must not be
+// in use by another running inbound.
+func (c *Client) AddInbound(in config.ClientInbound) error {
+	name := in.Name()
+	switch in.Protocol {
+	case protocol.ProtocolSOCKS5, protocol.ProtocolSSH, protocol.ProtocolShadowsocks:
+	default:
+		return fmt.Errorf("inbound %s: unknown protocol %q", name, in.Protocol)
+	}
+
+	// SSH inbounds with authorized_keys act as an SSH jump host.
+	var sshServer *ssh.Server
+	if in.Protocol == protocol.ProtocolSSH && in.AuthorizedKeys != "" {
+		var err error
+		sshServer, err = ssh.NewServer(in.HostKey, in.AuthorizedKeys)
+		if err != nil {
+			return fmt.Errorf("failed to start SSH server on %s: %v", in.LocalAddr, err)
+		}
+	}
+
+	c.listeners.mu.Lock()
+	defer c.listeners.mu.Unlock()
+	if _, ok := c.listeners.byID[name]; ok {
+		return fmt.Errorf("inbound %s already exists", name)
+	}
+	addr := in.LocalAddr
+	if c.lan != nil {
+		addr = lanAddr(addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	if c.listeners.byID == nil {
+		c.listeners.byID = make(map[string]*runningInbound)
+	}
+	c.listeners.byID[name] = &runningInbound{cfg: in, ln: ln}
+	c.listeners.order = append(c.listeners.order, name)
+
+	if in.Tag != "" {
+		log.Printf("Listening on %s (%s, tag %s)", addr, in.Protocol, in.Tag)
+	} else {
+		log.Printf("Listening on %s (%s)", addr, in.Protocol)
+	}
+	go c.serveInbound(in, ln, sshServer)
+	return nil
+}
+
+// RemoveInbound closes the listener of the inbound named name. Connections
+// it already accepted keep running until they end.
+func (c *Client) RemoveInbound(name string) error {
+	c.listeners.mu.Lock()
+	ib, ok := c.listeners.byID[name]
+	if ok {
+		delete(c.listeners.byID, name)
+		for i, n := range c.listeners.order {
+			if n == name {
+				c.listeners.order = append(c.listeners.order[:i], c.listeners.order[i+1:]...)
+				break
+			}
+		}
+	}
+	c.listeners.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no inbound named %s", name)
+	}
+	log.Printf("Stopped listening on %s (%s)", ib.cfg.LocalAddr, name)
+	return ib.ln.Close()
+}
+
+// Inbounds returns the configuration of the running inbounds in the order
+// they were added.
+func (c *Client) Inbounds() []config.ClientInbound {
+	c.listeners.mu.Lock()
+	defer c.listeners.mu.Unlock()
+	out := make([]config.ClientInbound, 0, len(c.listeners.order))
+	for _, name := range c.listeners.order {
+		out = append(out, c.listeners.byID[name].cfg)
+	}
+	return out
+}
+
+// serveInbound accepts connections on ln until it is closed. SOCKS5
+// inbounds wait for a handshake slot of c.socks before each Accept.
+func (c *Client) serveInbound(in config.ClientInbound, ln net.Listener, sshServer *ssh.Server) {
+	for {
+		handshakeDone := func() {}
+		if in.Protocol == protocol.ProtocolSOCKS5 {
+			handshakeDone = c.socks.slot()
+		}
+		raw, err := ln.Accept()
+		if err != nil {
+			handshakeDone()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Accept error on %s: %v", in.LocalAddr, err)
+			continue
+		}
+		var conn net.Conn = c.accepted.track(raw)
+		if !c.allowSource(conn.RemoteAddr()) {
+			handshakeDone()
+			log.Printf("[LAN] Rejected connection from %s on %s", conn.RemoteAddr(), in.Name())
+			conn.Close()
+			continue
+		}
+		if in.Protocol == protocol.ProtocolSOCKS5 {
+			release, ok := c.socks.admit(conn.RemoteAddr())
+			if !ok {
+				handshakeDone()
+				log.Printf("[SOCKS5] Rejected connection from %s on %s: too many connections from this source", conn.RemoteAddr(), in.Name())
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer release()
+				defer handshakeDone()
+				c.handleSOCKS5(in, c.CountConn(in.Name(), conn), handshakeDone)
+			}()
+			continue
+		}
+		conn = c.CountConn(in.Name(), conn)
+		if sshServer != nil {
+			go func() {
+				dialer := &tunnelDialer{client: c, proto: protocol.ProtocolSSH, inbound: in.Name(), source: conn.RemoteAddr(), adblock: !in.SkipAdBlock}
+				if err := sshServer.HandleConnection(conn, dialer); err != nil {
+					log.Printf("SSH Handler Error (%s): %v", in.Name(), err)
+				}
+			}()
+			continue
+		}
+		go c.handleInbound(in, conn)
+	}
+}
+
+// handleSOCKS5 serves one SOCKS5 connection; handshakeDone frees its
+// handshake slot once the request has been read.
+func (c *Client) handleSOCKS5(in config.ClientInbound, conn net.Conn, handshakeDone func()) {
+	dialer := &tunnelDialer{client: c, proto: protocol.ProtocolSOCKS5, inbound: in.Name(), source: conn.RemoteAddr(), sniff: in.Sniff, adblock: !in.SkipAdBlock}
+	opts := socks5.Options{
+		EnableUDP:        in.EnableUDP,
+		Sniff:            in.Sniff != "",
+		UDP:              UDPLimits(c.Config.UDP),
+		HandshakeTimeout: c.socks.timeout,
+		HandshakeDone:    handshakeDone,
+	}
+	if err := socks5.HandleConnection(conn, dialer, opts); err != nil {
+		log.Printf("SOCKS5 Handler Error (%s): %v", in.Name(), err)
+	}
+}
+
+// handleInbound pipes an SSH or Shadowsocks connection to its target_addr
+// through the tunnel.
+func (c *Client) handleInbound(in config.ClientInbound, conn net.Conn) {
+	stream, err := c.dialFrom(in.Name(), in.Protocol, in.TargetAddr)
+	if err != nil {
+		log.Printf("Failed to dial server (%s): %v", in.Name(), err)
+		conn.Close()
+		return
+	}
+	go func() {
+		defer conn.Close()
+		defer stream.Close()
+		io.Copy(conn, stream)
+	}()
+	go func() {
+		defer conn.Close()
+		defer stream.Close()
+		io.Copy(stream, conn)
+	}()
+}
+
+// tunnelDialer implements socks5.Dialer (and the SSH server's dialer) by
+// opening tunnel streams, or direct connections for routing.direct targets
+// and when the fallback applies.
+type tunnelDialer struct {
+	client  *Client
+	proto   protocol.ProtocolType
+	inbound string   // Name of the inbound the connection came in on
+	source  net.Addr // The application's end of the connection
+	sniff   string   // The inbound's sniff mode
+	adblock bool     // [adblock] applies to the inbound
+
+	procOnce   sync.Once
+	procDirect bool // source is a routing.direct process
+}
+
+func (d *tunnelDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	proto := d.proto
+	if target == "udp-tunnel" {
+		proto = protocol.ProtocolSOCKS5UDP
+		target = ""
+	}
+	return d.dial(proto, d.client.staticTarget(target), target)
+}
+
+// DialSniffed implements socks5.SniffDialer: it routes target by the host
+// sniffing found and, in "override" mode, connects to that host instead.
+func (d *tunnelDialer) DialSniffed(target, host string) (io.ReadWriteCloser, error) {
+	_, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	named := net.JoinHostPort(host, port)
+	loglevel.Debugf("[Sniff] %s is %s", target, host)
+	if d.sniff == "override" {
+		return d.Dial(named)
+	}
+	return d.dial(d.proto, target, named)
+}
+
+// AllowUDP implements socks5.UDPFilter: block rules and [adblock] drop
+// datagrams too.
+func (d *tunnelDialer) AllowUDP(dest string) bool {
+	if d.adblock && d.client.adblock.blocksTarget(dest) {
+		return false
+	}
+	r := d.client.rule(dest)
+	if r != nil && r.action == "outbound" {
+		// Tor carries no UDP; apps fall back to TCP (QUIC to HTTPS) rather
+		// than have the datagrams leave another way.
+		_, tor := d.client.outbounds[r.outbound].(*outbound.Tor)
+		return !tor
+	}
+	return r == nil || r.action != "block"
+}
+
+// AnswerUDP implements socks5.UDPAnswerer: DNS queries for names [adblock]
+// blocks get NXDOMAIN.
+func (d *tunnelDialer) AnswerUDP(dest string, payload []byte) []byte {
+	if !d.adblock {
+		return nil
+	}
+	return d.client.adblock.answer(dest, payload)
+}
+
+// dial connects to target, routed as if it were route.
+func (d *tunnelDialer) dial(proto protocol.ProtocolType, target, route string) (io.ReadWriteCloser, error) {
+	if a := d.client.adblock; d.adblock && a.blocksTarget(route) {
+		loglevel.Debugf("[AdBlock] Blocked %s", route)
+		return outbound.Block(target, a.block)
+	}
+	direct := false
+	rule := d.client.rule(route)
+	action := ""
+	if rule != nil {
+		action = rule.action
+	}
+	switch action {
+	case "block":
+		loglevel.Debugf("[Routing] Blocked %s", route)
+		return outbound.Block(target, rule.block)
+	case "direct":
+		direct = true
+	case "outbound":
+		d, ok := d.client.outbounds[rule.outbound]
+		if !ok {
+			return nil, fmt.Errorf("routing outbound %q unavailable", rule.outbound)
+		}
+		loglevel.Debugf("[Routing] Sending %s to outbound %s", route, rule.outbound)
+		return d.Dial(target)
+	case "":
+		d.procOnce.Do(func() { d.procDirect = d.client.processDirect(d.source) })
+		direct = d.procDirect || d.client.routesDirect(route)
+	}
+	if direct {
+		return d.client.directDialer().Dial(target)
+	}
+	if f := d.client.fallback; f != nil {
+		return f.Dial(d.inbound, proto, target)
+	}
+	return d.client.dialFrom(d.inbound, proto, target)
+}
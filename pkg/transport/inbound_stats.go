@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// InboundCounters are traffic counters of one client inbound. Bytes are
+// counted on the local side, before tunnel framing.
+type InboundCounters struct {
+	Active      int64 // Open connections
+	Connections int64 // Connections accepted since start
+	BytesIn     int64 // Bytes received from local applications
+	BytesOut    int64 // Bytes sent to local applications
+}
+
+// inboundStats holds the counters of each inbound by name.
+type inboundStats struct {
+	mu   sync.Mutex
+	byID map[string]*InboundCounters
+}
+
+func (s *inboundStats) get(name string) *InboundCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byID == nil {
+		s.byID = make(map[string]*InboundCounters)
+	}
+	c := s.byID[name]
+	if c == nil {
+		c = &InboundCounters{}
+		s.byID[name] = c
+	}
+	return c
+}
+
+// CountConn returns conn counted in the stats of the named inbound (see
+// config.ClientInbound.Name) until it is closed.
+func (c *Client) CountConn(inbound string, conn net.Conn) net.Conn {
+	counters := c.inbounds.get(inbound)
+	atomic.AddInt64(&counters.Active, 1)
+	atomic.AddInt64(&counters.Connections, 1)
+	return &countedConn{Conn: conn, c: counters}
+}
+
+// InboundStats returns a snapshot of the counters of every inbound that has
+// accepted a connection.
+func (c *Client) InboundStats() map[string]InboundCounters {
+	c.inbounds.mu.Lock()
+	defer c.inbounds.mu.Unlock()
+	out := make(map[string]InboundCounters, len(c.inbounds.byID))
+	for name, s := range c.inbounds.byID {
+		out[name] = InboundCounters{
+			Active:      atomic.LoadInt64(&s.Active),
+			Connections: atomic.LoadInt64(&s.Connections),
+			BytesIn:     atomic.LoadInt64(&s.BytesIn),
+			BytesOut:    atomic.LoadInt64(&s.BytesOut),
+		}
+	}
+	return out
+}
+
+type countedConn struct {
+	net.Conn
+	c    *InboundCounters
+	once sync.Once
+}
+
+func (c *countedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.c.BytesIn, int64(n))
+	return n, err
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.c.BytesOut, int64(n))
+	return n, err
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.c.Active, -1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite keeps half-close working for TCP connections.
+func (c *countedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
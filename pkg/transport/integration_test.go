@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"flag"
+	"io"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"strings"
+	"testing"
+)
+
+var phases = flag.String("phases", "", "comma-separated TestIntegration phases to run, e.g. mtls,token (default all)")
+
+// integrationPhase is a server setup with clients expected to get through
+// it or be refused.
+type integrationPhase struct {
+	name  string
+	setup func(t *testing.T, dir string) (*config.ServerConfig, []integrationClient)
+}
+
+type integrationClient struct {
+	name    string
+	cfg     config.ClientConfig // RemoteAddr is filled in
+	wantErr bool
+}
+
+var integrationPhases = []integrationPhase{
+	{"h2c", func(t *testing.T, dir string) (*config.ServerConfig, []integrationClient) {
+		return config.DefaultServerConfig(), []integrationClient{{"open", config.ClientConfig{}, false}}
+	}},
+	{"token", func(t *testing.T, dir string) (*config.ServerConfig, []integrationClient) {
+		cfg := config.DefaultServerConfig()
+		cfg.Security.AuthToken = "secret"
+		return cfg, []integrationClient{
+			{"right token", config.ClientConfig{AuthToken: "secret"}, false},
+			{"wrong token", config.ClientConfig{AuthToken: "wrong"}, true},
+		}
+	}},
+	{"tls", func(t *testing.T, dir string) (*config.ServerConfig, []integrationClient) {
+		serverKey, serverPub := keyFile(t, dir, "server.key")
+		_, otherPub := keyFile(t, dir, "other.key")
+		cfg := config.DefaultServerConfig()
+		cfg.Security.PrivateKeyPath = serverKey
+		return cfg, []integrationClient{
+			{"pinned key", config.ClientConfig{ServerPublicKey: serverPub}, false},
+			{"pinned key mismatch", config.ClientConfig{ServerPublicKey: otherPub}, true},
+		}
+	}},
+	{"mtls", func(t *testing.T, dir string) (*config.ServerConfig, []integrationClient) {
+		serverKey, serverPub := keyFile(t, dir, "server.key")
+		clientKey, clientPub := keyFile(t, dir, "client.key")
+		strangerKey, _ := keyFile(t, dir, "stranger.key")
+		cfg := config.DefaultServerConfig()
+		cfg.Security.PrivateKeyPath = serverKey
+		cfg.Security.AuthorizedClientKeys = []string{clientPub}
+		return cfg, []integrationClient{
+			{"authorized key", config.ClientConfig{PrivateKeyPath: clientKey, ServerPublicKey: serverPub}, false},
+			{"unauthorized key", config.ClientConfig{PrivateKeyPath: strangerKey, ServerPublicKey: serverPub}, true},
+		}
+	}},
+	{"users", func(t *testing.T, dir string) (*config.ServerConfig, []integrationClient) {
+		cfg := config.DefaultServerConfig()
+		cfg.Users = []config.User{{Name: "alice", Token: "alice-token"}, {Name: "guest", Token: "guest-token", Deny: []string{"127.0.0.0/8"}}}
+		return cfg, []integrationClient{
+			{"user", config.ClientConfig{AuthToken: "alice-token"}, false},
+			{"acl denies loopback", config.ClientConfig{AuthToken: "guest-token"}, true},
+			{"unknown token", config.ClientConfig{AuthToken: "nobody"}, true},
+		}
+	}},
+}
+
+// TestIntegration runs the phases over real TCP, each against its own
+// server on its own port and temp dir, in parallel. Select phases with
+// go test -run TestIntegration -phases mtls,token.
+func TestIntegration(t *testing.T) {
+	t.Parallel()
+	selected := map[string]bool{}
+	for _, name := range strings.Split(*phases, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			selected[name] = true
+		}
+	}
+	for _, p := range integrationPhases {
+		if len(selected) > 0 && !selected[p.name] {
+			continue
+		}
+		t.Run(p.name, func(t *testing.T) {
+			t.Parallel()
+			target := echoTarget(t)
+			serverCfg, clients := p.setup(t, t.TempDir())
+			serverCfg.Security.EnableSOCKS5 = true
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { ln.Close() })
+			go Serve(serverCfg, ln)
+
+			for _, ic := range clients {
+				t.Run(ic.name, func(t *testing.T) {
+					t.Parallel()
+					cfg := ic.cfg
+					cfg.RemoteAddr = ln.Addr().String()
+					client := NewClient(&cfg)
+					t.Cleanup(func() { client.Close() })
+					stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+					if err == nil {
+						// A stream the server refuses after accepting it ends without data.
+						stream.Write([]byte("ping"))
+						buf := make([]byte, 4)
+						_, err = io.ReadFull(stream, buf)
+						stream.Close()
+					}
+					if ic.wantErr && err == nil {
+						t.Errorf("Expected the stream to be refused")
+					}
+					if !ic.wantErr && err != nil {
+						t.Errorf("Expected the stream to work, got %v", err)
+					}
+				})
+			}
+		})
+	}
+}
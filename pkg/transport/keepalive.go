@@ -0,0 +1,243 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"phoenix/pkg/loglevel"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Defaults for config.Keepalive.
+const (
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultLowPowerInterval  = 5 * time.Minute
+	defaultKeepaliveTimeout  = 5 * time.Second
+)
+
+// connPool is the http2.ClientConnPool of a tunnel transport. It does what
+// the default pool does for our single server address, reusing a connection
+// with a free stream slot or dialing a new one, but also exposes its
+// connections so the client can ping them on its own schedule. The default
+// pool only pings via ReadIdleTimeout, which is fixed per connection.
+type connPool struct {
+	dial func() (*http2.ClientConn, error)
+	want *atomic.Int32 // Parallel connections to spread streams over (<2 = fill one at a time)
+
+	dialMu sync.Mutex // One dial at a time, like the default pool
+	mu     sync.Mutex
+	conns  []*http2.ClientConn
+}
+
+// newConnPool installs a connPool on tr, dialing through tr.DialTLS.
+func newConnPool(tr *http2.Transport, want *atomic.Int32) *connPool {
+	p := &connPool{want: want}
+	p.dial = func() (*http2.ClientConn, error) {
+		conn, err := tr.DialTLS("tcp", "", nil)
+		if err != nil {
+			return nil, err
+		}
+		cc, err := tr.NewClientConn(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return cc, nil
+	}
+	tr.ConnPool = p
+	return p
+}
+
+// poolOf returns the connPool behind an http.Client from createHTTPClient.
+func poolOf(hc *http.Client) *connPool {
+	return hc.Transport.(*http2.Transport).ConnPool.(*connPool)
+}
+
+func (p *connPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	if cc := p.reserve(); cc != nil {
+		return cc, nil
+	}
+	p.dialMu.Lock()
+	defer p.dialMu.Unlock()
+	if cc := p.reserve(); cc != nil {
+		return cc, nil // Dialed while we waited
+	}
+	cc, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	if !cc.ReserveNewRequest() {
+		cc.Close()
+		return nil, errors.New("new server connection refused streams")
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, cc)
+	p.mu.Unlock()
+	return cc, nil
+}
+
+func (p *connPool) reserve() *http2.ClientConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if want := int(p.want.Load()); want > 1 {
+		return p.reserveSpread(want)
+	}
+	for _, cc := range p.conns {
+		if cc.ReserveNewRequest() {
+			return cc
+		}
+	}
+	return nil
+}
+
+// reserveSpread picks the least busy connection, or nil to have a new one
+// dialed while there are fewer than want and all of them are busy.
+func (p *connPool) reserveSpread(want int) *http2.ClientConn {
+	busy := func(cc *http2.ClientConn) int {
+		st := cc.State()
+		return st.StreamsActive + st.StreamsReserved + st.StreamsPending
+	}
+	conns := append([]*http2.ClientConn(nil), p.conns...)
+	slices.SortStableFunc(conns, func(a, b *http2.ClientConn) int { return busy(a) - busy(b) })
+	for _, cc := range conns {
+		if len(p.conns) < want && busy(cc) > 0 {
+			return nil
+		}
+		if cc.ReserveNewRequest() {
+			return cc
+		}
+	}
+	return nil
+}
+
+// MarkDead is called by the transport when cc fails or closes.
+func (p *connPool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, c := range p.conns {
+		if c == cc {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *connPool) snapshot() []*http2.ClientConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*http2.ClientConn(nil), p.conns...)
+}
+
+// closeIdle closes connections without streams, as
+// http.Client.CloseIdleConnections does for the default pool.
+func (p *connPool) closeIdle() {
+	for _, cc := range p.snapshot() {
+		if st := cc.State(); st.StreamsActive == 0 && st.StreamsReserved == 0 && st.StreamsPending == 0 {
+			cc.Close()
+		}
+	}
+}
+
+// ping sends a PING on every connection and closes those that don't answer
+// within timeout, so the next Dial opens a fresh one.
+func (p *connPool) ping(timeout time.Duration) {
+	for _, cc := range p.snapshot() {
+		go func(cc *http2.ClientConn) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			start := time.Now()
+			if err := cc.Ping(ctx); err != nil {
+				log.Printf("[Keepalive] Server connection did not answer PING (%v), closing it", err)
+				cc.Close()
+				return
+			}
+			loglevel.Debugf("[Keepalive] PING answered in %v", time.Since(start).Round(time.Millisecond))
+		}(cc)
+	}
+}
+
+// probe checks that the server answers a PING on a pooled connection,
+// dialing one first if the pool is empty.
+func (p *connPool) probe(timeout time.Duration) error {
+	conns := p.snapshot()
+	if len(conns) == 0 {
+		p.dialMu.Lock()
+		if conns = p.snapshot(); len(conns) == 0 { // Or dialed while we waited
+			cc, err := p.dial()
+			if err != nil {
+				p.dialMu.Unlock()
+				return err
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, cc)
+			p.mu.Unlock()
+			conns = append(conns, cc)
+		}
+		p.dialMu.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return conns[0].Ping(ctx)
+}
+
+// SetLowPower switches keepalive pings between keepalive.interval and
+// keepalive.low_power_interval. The Android service enables it while the app
+// is in the background or the device dozes. Leaving low-power mode pings at
+// once, which also finds connections that died during doze.
+func (c *Client) SetLowPower(on bool) {
+	if c.lowPower.Swap(on) != on {
+		if on {
+			log.Println("[Keepalive] Low-power mode on")
+		} else {
+			log.Println("[Keepalive] Low-power mode off")
+		}
+		select {
+		case c.powerChanged <- struct{}{}:
+		default:
+		}
+	}
+	for _, hop := range c.hops {
+		hop.SetLowPower(on)
+	}
+}
+
+// runKeepalive pings the server connections for as long as the client lives.
+func (c *Client) runKeepalive() {
+	cfg := c.Config.Keepalive
+	lowPower, timeout := cfg.LowPowerInterval, cfg.Timeout
+	if lowPower <= 0 {
+		lowPower = defaultLowPowerInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultKeepaliveTimeout
+	}
+
+	for {
+		wait := c.keepaliveInterval() // Server hints may change it
+		if c.lowPower.Load() {
+			wait = lowPower
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-c.powerChanged:
+			timer.Stop()
+			if c.lowPower.Load() {
+				continue // Just reschedule at the longer interval
+			}
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		}
+		c.mu.RLock()
+		pool := poolOf(c.httpClient)
+		c.mu.RUnlock()
+		pool.ping(timeout)
+	}
+}
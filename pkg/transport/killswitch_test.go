@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"sync/atomic"
+	"testing"
+)
+
+// TestPipeKillSwitch checks that with kill_switch, inbound connections fail
+// while the server is unreachable, none of them reaching the target some
+// other way, and go through again once the server is back.
+func TestPipeKillSwitch(t *testing.T) {
+	t.Parallel()
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	ln := pipeServer(t, serverCfg)
+	var down atomic.Bool
+	down.Store(true)
+	client := newClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", KillSwitch: true}, func(network, addr string) (net.Conn, error) {
+		if down.Load() {
+			return nil, errors.New("network is unreachable")
+		}
+		return ln.Dial(network, addr)
+	})
+	if client.fallback, err = newFallback(client); err != nil {
+		t.Fatal(err)
+	}
+	dialer := &tunnelDialer{client: client, proto: protocol.ProtocolSOCKS5}
+
+	for i := range 3 {
+		if conn, err := dialer.Dial(target.Addr().String()); !errors.Is(err, ErrServerUnreachable) {
+			if conn != nil {
+				conn.Close()
+			}
+			t.Fatalf("Dial %d: Expected the server to be unreachable, got %v", i, err)
+		}
+	}
+	if n := accepted.Load(); n != 0 {
+		t.Fatalf("Expected no connection to reach the target while the tunnel is down, got %d", n)
+	}
+
+	down.Store(false)
+	conn, err := dialer.Dial(target.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected traffic to resume once the server is back, got %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the echo through the tunnel, got %q, %v", buf, err)
+	}
+}
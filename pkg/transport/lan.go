@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+)
+
+// Sources allowed by allow_lan when lan_sources is empty.
+var defaultLANSources = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16"}
+
+// lanACL returns the source filter of allow_lan, or nil when inbounds only
+// listen where local_addr says.
+func lanACL(cfg *config.ClientConfig) (*outbound.HostMatcher, error) {
+	if !cfg.AllowLAN {
+		return nil, nil
+	}
+	sources := cfg.LANSources
+	if len(sources) == 0 {
+		sources = defaultLANSources
+	}
+	return outbound.ParseHostMatcher(sources)
+}
+
+// lanAddr returns the listen address of an inbound under allow_lan: every
+// IPv4 interface on the port of localAddr.
+func lanAddr(localAddr string) string {
+	_, port, err := net.SplitHostPort(localAddr)
+	if err != nil {
+		return localAddr
+	}
+	return net.JoinHostPort("0.0.0.0", port)
+}
+
+// allowSource reports whether a connection from addr passes the allow_lan
+// filter. Loopback is always allowed.
+func (c *Client) allowSource(addr net.Addr) bool {
+	if c.lan == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	if v4 := ip.To4(); v4 != nil {
+		host = v4.String()
+	}
+	return c.lan.Match(host)
+}
@@ -0,0 +1,229 @@
+package transport
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"phoenix/pkg/protocol"
+	"sync"
+	"time"
+)
+
+// FeatureMetadataFrame carries protocol/target/token in an encrypted frame at
+// the start of the request body instead of plaintext request headers, so CDN
+// logs and TLS-terminating middleboxes never see tunnel destinations.
+//
+// Frame layout: [len uint16][nonce 12][AES-256-GCM ciphertext]. The
+// plaintext is [unix time uint64] followed by TLV fields [type][len uint16][value].
+// The server accepts a frame once, within maxMetaClockSkew of its time.
+const FeatureMetadataFrame protocol.Feature = "meta-frame"
+
+func init() {
+	protocol.RegisterFeature(FeatureMetadataFrame)
+}
+
+const (
+	metaFieldProtocol = 1
+	metaFieldTarget   = 2
+	metaFieldToken    = 3
+	metaFieldPriority = 4
+	metaFieldPadding  = 0xFF
+
+	maxMetaFrameSize = 4096
+	maxMetaClockSkew = 2 * time.Minute
+
+	// maxMetaNonces bounds the replay cache. It holds the frames of the
+	// last 2*maxMetaClockSkew at most; past the bound the oldest are
+	// forgotten first.
+	maxMetaNonces = 1 << 16
+)
+
+// streamMeta is the per-stream metadata a client sends to the server.
+type streamMeta struct {
+	Protocol string
+	Target   string
+	Token    string
+	Priority string // FeaturePriority class; empty = normal
+}
+
+// metaSecret picks the shared secret used to encrypt metadata frames: the
+// HTTP profile secret when configured, otherwise the auth token.
+func metaSecret(httpSecret, authToken string) string {
+	if httpSecret != "" {
+		return httpSecret
+	}
+	return authToken
+}
+
+// metaKey derives the frame key from the shared secret (HTTP profile secret
+// or auth token) that both sides already hold.
+func metaKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("phoenix-metadata-frame-v1"))
+	return mac.Sum(nil)
+}
+
+func metaAEAD(secret string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(metaKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encodeMetaFrame seals meta into a length-prefixed frame. Random padding
+// hides the length of the target address.
+func encodeMetaFrame(secret string, meta streamMeta) ([]byte, error) {
+	aead, err := metaAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, 8, 256)
+	binary.BigEndian.PutUint64(plain, uint64(time.Now().Unix()))
+	for _, f := range []struct {
+		typ byte
+		val string
+	}{
+		{metaFieldProtocol, meta.Protocol},
+		{metaFieldTarget, meta.Target},
+		{metaFieldToken, meta.Token},
+		{metaFieldPriority, meta.Priority},
+	} {
+		if f.val != "" {
+			plain = appendMetaField(plain, f.typ, []byte(f.val))
+		}
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(256))
+	if err != nil {
+		return nil, err
+	}
+	plain = appendMetaField(plain, metaFieldPadding, make([]byte, n.Int64()))
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, nil)
+	if len(sealed) > maxMetaFrameSize {
+		return nil, fmt.Errorf("metadata frame too large: %d bytes", len(sealed))
+	}
+
+	frame := make([]byte, 2+len(sealed))
+	binary.BigEndian.PutUint16(frame, uint16(len(sealed)))
+	copy(frame[2:], sealed)
+	return frame, nil
+}
+
+func appendMetaField(b []byte, typ byte, val []byte) []byte {
+	b = append(b, typ, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(val)))
+	return append(b, val...)
+}
+
+// readMetaFrame reads and opens the metadata frame at the start of r,
+// rejecting frames whose nonce seen already holds.
+func readMetaFrame(r io.Reader, secret string, seen *nonceCache) (streamMeta, error) {
+	var meta streamMeta
+	aead, err := metaAEAD(secret)
+	if err != nil {
+		return meta, err
+	}
+
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return meta, fmt.Errorf("failed to read metadata frame length: %v", err)
+	}
+	size := int(binary.BigEndian.Uint16(lenBuf[:]))
+	if size < aead.NonceSize()+aead.Overhead() || size > maxMetaFrameSize {
+		return meta, fmt.Errorf("invalid metadata frame size: %d", size)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return meta, fmt.Errorf("failed to read metadata frame: %v", err)
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return meta, errors.New("metadata frame authentication failed")
+	}
+	if len(plain) < 8 {
+		return meta, errors.New("metadata frame truncated")
+	}
+
+	sent := time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)
+	if skew := time.Since(sent); skew > maxMetaClockSkew || skew < -maxMetaClockSkew {
+		return meta, fmt.Errorf("metadata frame timestamp out of range (skew %v)", skew.Round(time.Second))
+	}
+	if !seen.add(nonce, sent.Add(maxMetaClockSkew)) {
+		return meta, errors.New("metadata frame replayed")
+	}
+	return parseMetaFields(plain[8:])
+}
+
+// nonceCache remembers the nonces of accepted metadata frames until their
+// timestamp leaves the clock skew window, after which a replay is refused
+// anyway.
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time // Nonce → expiry
+	order []string             // Nonces, oldest first
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add records nonce until expires. It returns false when nonce is already
+// recorded.
+func (c *nonceCache) add(nonce []byte, expires time.Time) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.order) > 0 && (len(c.order) >= maxMetaNonces || now.After(c.seen[c.order[0]])) {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+	key := string(nonce)
+	if exp, ok := c.seen[key]; ok && !now.After(exp) {
+		return false
+	}
+	c.seen[key] = expires
+	c.order = append(c.order, key)
+	return true
+}
+
+// parseMetaFields decodes the type-length-value fields of an opened frame.
+func parseMetaFields(b []byte) (streamMeta, error) {
+	var meta streamMeta
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return meta, errors.New("metadata field truncated")
+		}
+		typ, n := b[0], int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return meta, errors.New("metadata field truncated")
+		}
+		val := string(b[3 : 3+n])
+		switch typ {
+		case metaFieldProtocol:
+			meta.Protocol = val
+		case metaFieldTarget:
+			meta.Target = val
+		case metaFieldToken:
+			meta.Token = val
+		case metaFieldPriority:
+			meta.Priority = val
+		}
+		b = b[3+n:]
+	}
+	return meta, nil
+}
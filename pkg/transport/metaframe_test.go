@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// FuzzReadMetaFrame feeds arbitrary bytes to the server's metadata frame
+// reader, and arbitrary field lists to the parser behind the AEAD.
+func FuzzReadMetaFrame(f *testing.F) {
+	frame, err := encodeMetaFrame("secret", streamMeta{Protocol: "socks5", Target: "example.com:443", Token: "t"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(frame)
+	f.Add([]byte{0, 0})
+	f.Add([]byte{0x01, 0, 6, 's', 'o', 'c', 'k', 's', '5', 0x02, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readMetaFrame(bytes.NewReader(data), "secret", newNonceCache())
+		parseMetaFields(data)
+	})
+}
+
+func TestMetaFrameReplay(t *testing.T) {
+	frame, err := encodeMetaFrame("secret", streamMeta{Protocol: "socks5", Target: "example.com:443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := newNonceCache()
+	if meta, err := readMetaFrame(bytes.NewReader(frame), "secret", seen); err != nil || meta.Target != "example.com:443" {
+		t.Fatalf("Expected the frame to open, got %+v, %v", meta, err)
+	}
+	if _, err := readMetaFrame(bytes.NewReader(frame), "secret", seen); err == nil {
+		t.Errorf("Expected the replayed frame to be rejected")
+	}
+	if _, err := readMetaFrame(bytes.NewReader(frame), "secret", newNonceCache()); err != nil {
+		t.Errorf("Expected another server to accept the frame, got %v", err)
+	}
+
+	// Expired nonces are dropped, and the cache never outgrows its bound.
+	seen = newNonceCache()
+	seen.add([]byte("expired"), time.Now().Add(-time.Second))
+	for i := range maxMetaNonces + 10 {
+		if !seen.add(binary.BigEndian.AppendUint32(nil, uint32(i)), time.Now().Add(time.Minute)) {
+			t.Fatalf("Expected nonce %d to be new", i)
+		}
+	}
+	if _, ok := seen.seen["expired"]; ok {
+		t.Errorf("Expected the expired nonce to be dropped")
+	}
+	if len(seen.seen) > maxMetaNonces || len(seen.order) > maxMetaNonces {
+		t.Errorf("Expected at most %d nonces, got %d", maxMetaNonces, len(seen.seen))
+	}
+}
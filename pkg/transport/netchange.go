@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// networkChangeDebounce ignores repeated notifications for one switch, e.g.
+// from both the OS monitor and the Android service.
+const networkChangeDebounce = 2 * time.Second
+
+// connTracker remembers the client's open server connections so they can
+// be closed when the network changes. Idle-connection cleanup alone leaves
+// busy connections pinned to a network that no longer exists until their
+// streams time out.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+type trackedConn struct {
+	net.Conn
+	t    *connTracker
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.t.mu.Lock()
+		delete(c.t.conns, c)
+		c.t.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// CloseWrite keeps half-close working for TCP connections.
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// wrap returns dial with every connection it opens tracked.
+func (t *connTracker) wrap(dial func(network, addr string) (net.Conn, error)) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return t.track(conn), nil
+	}
+}
+
+// track returns conn, tracked until it is closed.
+func (t *connTracker) track(conn net.Conn) *trackedConn {
+	tc := &trackedConn{Conn: conn, t: t}
+	t.mu.Lock()
+	if t.conns == nil {
+		t.conns = make(map[*trackedConn]struct{})
+	}
+	t.conns[tc] = struct{}{}
+	t.mu.Unlock()
+	return tc
+}
+
+// len returns how many tracked connections are open.
+func (t *connTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// closeAll closes every tracked connection and returns how many there were.
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// NotifyNetworkChange tells the client that the host switched networks
+// (e.g. Wi-Fi to LTE). Connections to the server, including those of chain
+// hops, are closed immediately and a new one is opened over the new
+// network, resuming the TLS session, instead of waiting for three dials to
+// fail. Streams in flight
+// end with an error; their applications reconnect as they would after any
+// drop. Safe to call from any goroutine.
+func (c *Client) NotifyNetworkChange() {
+	c.mu.Lock()
+	if time.Since(c.lastNetworkChange) < networkChangeDebounce {
+		c.mu.Unlock()
+		return
+	}
+	c.lastNetworkChange = time.Now()
+	old := c.httpClient
+	c.httpClient = c.createHTTPClient()
+	fresh := c.httpClient
+	c.lastReset = time.Now()
+	c.mu.Unlock()
+
+	// Entry hop first, so the connections tunneled through it go with it.
+	for _, hop := range c.hops {
+		hop.NotifyNetworkChange()
+	}
+	if old != nil {
+		poolOf(old).closeIdle()
+	}
+	n := c.conns.closeAll()
+	log.Printf("[Transport] Network changed: closed %d server connection(s), reconnecting", n)
+	go c.warmUp(fresh)
+}
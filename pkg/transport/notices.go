@@ -0,0 +1,286 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FeatureNotices marks servers that push notices on a protocolNotices
+// stream: one JSON Notice per line, for as long as the stream is open,
+// starting with the configured notices and hints. The client never writes
+// to it.
+const FeatureNotices protocol.Feature = "notices"
+
+// protocolNotices is the protocol of the notice stream.
+const protocolNotices protocol.ProtocolType = "notices"
+
+func init() {
+	protocol.RegisterFeature(FeatureNotices)
+}
+
+// Notice kinds.
+const (
+	NoticeInfo        = "info"
+	NoticeQuota       = "quota"       // The user nears a traffic or time quota
+	NoticeMaintenance = "maintenance" // Planned downtime
+	NoticeShutdown    = "shutdown"    // The server is going down now
+	NoticeMigrate     = "migrate"     // Please move to Server
+	NoticeDisconnect  = "disconnect"  // The client stops tunneling
+)
+
+const (
+	// noticeRetry is the pause before reopening a failed notice stream.
+	noticeRetry = 30 * time.Second
+
+	// keptNotices is how many notices Client.Notices returns.
+	keptNotices = 20
+
+	// noticeQueue is how many notices a slow stream may fall behind
+	// before further ones are dropped for it.
+	noticeQueue = 16
+)
+
+// ErrDisconnected is returned by Dial after the server sent a "disconnect"
+// notice.
+var ErrDisconnected = errors.New("disconnected by server")
+
+// Notice is a message the server pushes to clients.
+type Notice struct {
+	Kind    string       `json:"kind"`
+	Message string       `json:"message,omitempty"`
+	Server  string       `json:"server,omitempty"` // Where "migrate" asks to move to
+	Hints   *ConfigHints `json:"hints,omitempty"`  // Of a "hints" notice
+	At      time.Time    `json:"at"`
+
+	// Users limits an Announce to these users of the user table; empty
+	// reaches every client. Not sent.
+	Users []string `json:"-"`
+}
+
+func (n Notice) String() string {
+	s := n.Kind
+	if n.Server != "" {
+		s += " to " + n.Server
+	}
+	if n.Message != "" {
+		s += ": " + n.Message
+	}
+	return s
+}
+
+// reaches reports whether the notice is addressed to user ("" for clients
+// that aren't in the user table).
+func (n Notice) reaches(user string) bool {
+	return len(n.Users) == 0 || slices.Contains(n.Users, user)
+}
+
+// noticeSub is one open notice stream on the server.
+type noticeSub struct {
+	user string
+	ch   chan Notice
+}
+
+// noticeBoard fans Announce out to the open notice streams.
+type noticeBoard struct {
+	mu   sync.Mutex
+	subs map[*noticeSub]struct{}
+}
+
+// notices is the board of every server in the process.
+var notices = &noticeBoard{subs: map[*noticeSub]struct{}{}}
+
+// Announce pushes n to the clients of every server in this process that
+// have a notice stream open, and returns how many it reached. A zero At is
+// set to now.
+func Announce(n Notice) int {
+	if n.At.IsZero() {
+		n.At = time.Now()
+	}
+	notices.mu.Lock()
+	defer notices.mu.Unlock()
+	sent := 0
+	for sub := range notices.subs {
+		if !n.reaches(sub.user) {
+			continue
+		}
+		select {
+		case sub.ch <- n:
+			sent++
+		default:
+		}
+	}
+	log.Printf("[Notice] Announced %s to %d clients", n, sent)
+	return sent
+}
+
+func (b *noticeBoard) subscribe(user string) *noticeSub {
+	sub := &noticeSub{user: user, ch: make(chan Notice, noticeQueue)}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *noticeBoard) unsubscribe(sub *noticeSub) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+}
+
+// serveNotices sends user the configured notices and hints, then those
+// announced, until the client closes the stream.
+func serveNotices(stream io.ReadWriter, user string, configured []config.ServerNotice, hints config.ClientHints) error {
+	sub := notices.subscribe(user)
+	defer notices.unsubscribe(sub)
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, stream)
+		close(closed)
+	}()
+
+	enc := json.NewEncoder(stream)
+	now := time.Now()
+	for _, c := range configured {
+		n := Notice{Kind: c.Kind, Message: c.Message, Server: c.Server, At: now, Users: c.Users}
+		if n.Kind == "" {
+			n.Kind = NoticeInfo
+		}
+		if !n.reaches(user) {
+			continue
+		}
+		if err := enc.Encode(n); err != nil {
+			return err
+		}
+	}
+	if n, ok := hintsNotice(hints); ok {
+		if err := enc.Encode(n); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case n := <-sub.ch:
+			if err := enc.Encode(n); err != nil {
+				return err
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// Notices returns the most recent notices from the server, oldest first.
+func (c *Client) Notices() []Notice {
+	c.noticeMu.Lock()
+	defer c.noticeMu.Unlock()
+	return slices.Clone(c.notices)
+}
+
+// runNotices keeps a notice stream open for as long as the client lives,
+// unless the server doesn't push notices or disconnects the client.
+func (c *Client) runNotices() {
+	var lastErr string
+	for {
+		err := c.readNotices()
+		switch {
+		case errors.Is(err, ErrDisconnected), c.closedErr() != nil:
+			return
+		case errors.Is(err, errNoNotices):
+			log.Printf("[Notice] %v", err)
+			return
+		}
+		if msg := fmt.Sprint(err); err != nil && msg != lastErr {
+			log.Printf("[Notice] Notice stream failed: %v", err)
+			lastErr = msg
+		}
+		if !c.sleep(noticeRetry) {
+			return
+		}
+		for c.lowPower.Load() {
+			if !c.sleep(noticeRetry) {
+				return
+			}
+		}
+	}
+}
+
+var errNoNotices = errors.New("server does not push notices")
+
+// readNotices reads one notice stream until it fails.
+func (c *Client) readNotices() error {
+	st, _, err := c.dial(protocolNotices, "", "")
+	if err != nil {
+		if errors.Is(err, ErrServerUnreachable) || errors.Is(err, ErrDisconnected) || errors.Is(err, ErrClosed) {
+			return err
+		}
+		// Older servers refuse the protocol.
+		return fmt.Errorf("%w (%v)", errNoNotices, err)
+	}
+	defer st.Close()
+	defer context.AfterFunc(c.ctx, func() { st.Close() })()
+	if !protocol.HasFeature(st.Features, FeatureNotices) {
+		return errNoNotices
+	}
+	scanner := bufio.NewScanner(st)
+	for scanner.Scan() {
+		var n Notice
+		if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+			return fmt.Errorf("invalid notice: %v", err)
+		}
+		if c.handleNotice(n) {
+			return ErrDisconnected
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// handleNotice logs and keeps n, and reports whether it disconnects the
+// client.
+func (c *Client) handleNotice(n Notice) bool {
+	if n.Kind == NoticeHints {
+		if n.Hints != nil {
+			c.applyHints(*n.Hints)
+		}
+		return false
+	}
+	log.Printf("[Notice] From server: %s", n)
+	c.noticeMu.Lock()
+	c.notices = append(c.notices, n)
+	if len(c.notices) > keptNotices {
+		c.notices = c.notices[len(c.notices)-keptNotices:]
+	}
+	c.noticeMu.Unlock()
+	if n.Kind != NoticeDisconnect {
+		return false
+	}
+	msg := n.Message
+	c.disconnected.Store(&msg)
+	c.conns.closeAll()
+	return true
+}
+
+// disconnectedErr returns ErrDisconnected with the server's reason once a
+// "disconnect" notice arrived, nil otherwise.
+func (c *Client) disconnectedErr() error {
+	msg := c.disconnected.Load()
+	if msg == nil {
+		return nil
+	}
+	if *msg == "" {
+		return ErrDisconnected
+	}
+	return fmt.Errorf("%w: %s", ErrDisconnected, *msg)
+}
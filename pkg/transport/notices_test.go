@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"errors"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func TestPipeNotices(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Users = []config.User{{Name: "notice-test", Token: "secret"}}
+	serverCfg.Notices = []config.ServerNotice{
+		{Kind: NoticeMaintenance, Message: "Down on Sunday"},
+		{Message: "For someone else", Users: []string{"other"}},
+	}
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: "secret"}, pipeServer(t, serverCfg))
+
+	waitNotices := func(n int) []Notice {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if got := client.Notices(); len(got) >= n {
+				return got
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected %d notices, got %v", n, client.Notices())
+		return nil
+	}
+	if got := waitNotices(1); got[0].Kind != NoticeMaintenance || got[0].Message != "Down on Sunday" {
+		t.Errorf("Expected the configured maintenance notice, got %+v", got[0])
+	}
+
+	// Only this test's user is disconnected, not the clients of parallel tests.
+	for Announce(Notice{Kind: NoticeDisconnect, Message: "Account suspended", Users: []string{"notice-test"}}) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := waitNotices(2); len(got) != 2 || got[1].Kind != NoticeDisconnect {
+		t.Fatalf("Expected the disconnect notice second, got %+v", got)
+	}
+	if _, err := client.Dial(protocol.ProtocolSOCKS5, target); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("Expected Dial to fail after the disconnect notice, got %v", err)
+	}
+}
+
+func TestPipeHints(t *testing.T) {
+	t.Parallel()
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Hints = config.ClientHints{KeepaliveInterval: 20 * time.Second, ALPN: []string{"h2", "http/1.1"}}
+	ln := pipeServer(t, serverCfg)
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, ln)
+	own := &config.ClientConfig{RemoteAddr: "phoenix.test:80"}
+	own.Keepalive.Interval = time.Minute
+	ownClient := NewPipeClient(own, ln)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.keepaliveInterval() != 20*time.Second || len(ownClient.hinted().ALPN) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the hints to be applied, got %+v and %+v", client.hinted(), ownClient.hinted())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := ownClient.keepaliveInterval(); got != time.Minute {
+		t.Errorf("Expected the configured keepalive interval to win over the hint, got %v", got)
+	}
+	if len(client.Notices()) != 0 {
+		t.Errorf("Expected hints not to be listed as notices, got %+v", client.Notices())
+	}
+}
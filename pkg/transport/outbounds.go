@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/wireguard"
+)
+
+// outboundSet holds the dialers of routing.outbounds by tag.
+type outboundSet map[string]outbound.Dialer
+
+func newOutbounds(cfg *config.ClientConfig) (outboundSet, error) {
+	if len(cfg.Routing.Outbounds) == 0 {
+		return nil, nil
+	}
+	dialers := make(outboundSet, len(cfg.Routing.Outbounds))
+	for _, o := range cfg.Routing.Outbounds {
+		d, err := newOutbound(o.URL)
+		if err != nil {
+			dialers.close()
+			return nil, fmt.Errorf("outbound %q: %v", o.Tag, err)
+		}
+		dialers[o.Tag] = d
+	}
+	return dialers, nil
+}
+
+// newOutbound builds the dialer of an outbound URL.
+func newOutbound(rawURL string) (outbound.Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "wireguard":
+		d, err := wireguard.Open(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[Routing] WireGuard outbound up from %s", u.Path)
+		return d, nil
+	case "ssh":
+		return outbound.ParseSSH(rawURL)
+	case "tor":
+		return outbound.ParseTor(rawURL)
+	}
+	return outbound.Parse(rawURL)
+}
+
+// close releases the outbounds that hold resources, such as WireGuard
+// tunnels.
+func (s outboundSet) close() {
+	for _, d := range s {
+		if c, ok := d.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
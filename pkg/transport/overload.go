@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"fmt"
+	"log"
+	"phoenix/pkg/config"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memoryPressure is the share of [limits] memory_limit above which new
+	// streams are refused, leaving the rest to the streams already open.
+	memoryPressure = 0.9
+
+	// memorySample is how long a memory reading is reused.
+	memorySample = 100 * time.Millisecond
+
+	// busyRetryAfter is the Retry-After, in seconds, of refused streams.
+	busyRetryAfter = "1"
+)
+
+// overloadGuard enforces the server-wide [limits]: concurrent streams,
+// goroutines and memory. Streams over them are refused with 503 and
+// Retry-After, which clients retry, so a load spike slows new streams
+// down instead of getting the whole server killed.
+type overloadGuard struct {
+	maxStreams, maxGoroutines int
+	memLimit                  uint64 // bytes; 0 = unchecked
+
+	streams   atomic.Int64
+	rejecting atomic.Bool // the last stream was refused; logs overloads once
+
+	mu        sync.Mutex
+	sampledAt time.Time
+	memUsed   uint64
+	samples   []metrics.Sample
+}
+
+func newOverloadGuard(cfg config.ServerLimits) *overloadGuard {
+	return &overloadGuard{
+		maxStreams:    cfg.MaxStreams,
+		maxGoroutines: cfg.MaxGoroutines,
+		memLimit:      uint64(float64(cfg.MemoryLimit) * memoryPressure),
+		samples: []metrics.Sample{
+			{Name: "/memory/classes/total:bytes"},
+			{Name: "/memory/classes/heap/released:bytes"},
+		},
+	}
+}
+
+// setMemoryLimit passes [limits] memory_limit to the Go runtime, which
+// then collects garbage harder as the process nears it.
+func setMemoryLimit(limit int64) {
+	if limit > 0 {
+		debug.SetMemoryLimit(limit)
+		log.Printf("[Limits] Soft memory limit %d MiB", limit>>20)
+	}
+}
+
+// acquire reserves a stream. release must be called when it ends; when
+// ok is false, reason says which limit refused it.
+func (g *overloadGuard) acquire() (release func(), reason string, ok bool) {
+	n := g.streams.Add(1)
+	release = func() { g.streams.Add(-1) }
+	switch {
+	case g.maxStreams > 0 && n > int64(g.maxStreams):
+		reason = fmt.Sprintf("%d streams open", n-1)
+	case g.maxGoroutines > 0 && runtime.NumGoroutine() > g.maxGoroutines:
+		reason = fmt.Sprintf("%d goroutines running", runtime.NumGoroutine())
+	case g.memLimit > 0:
+		if used := g.memory(); used > g.memLimit {
+			reason = fmt.Sprintf("%d MiB of memory in use", used>>20)
+		}
+	}
+	if reason != "" {
+		release()
+		if !g.rejecting.Swap(true) {
+			log.Printf("[Limits] Server overloaded (%s), refusing new streams", reason)
+		}
+		return nil, reason, false
+	}
+	if g.rejecting.Swap(false) {
+		log.Printf("[Limits] Accepting new streams again")
+	}
+	return release, "", true
+}
+
+// memory returns the memory the Go runtime holds from the OS, as counted
+// against its memory limit, read at most every memorySample.
+func (g *overloadGuard) memory() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now := time.Now(); now.Sub(g.sampledAt) >= memorySample {
+		metrics.Read(g.samples)
+		g.memUsed = g.samples[0].Value.Uint64() - g.samples[1].Value.Uint64()
+		g.sampledAt = now
+	}
+	return g.memUsed
+}
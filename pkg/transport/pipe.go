@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"os"
+	"phoenix/pkg/config"
+	"sync"
+	"time"
+)
+
+// PipeListener is an in-memory net.Listener: each Dial creates a connected
+// pair of memConns and hands one end to Accept. Serve a server on it and
+// connect a client with NewPipeClient to run the whole tunnel in one process
+// without binding a port.
+type PipeListener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+
+	mu     sync.Mutex
+	impair Impairment // Applied to connections dialed after SetImpairment
+}
+
+// NewPipeListener returns an open PipeListener whose address is name.
+func NewPipeListener(name string) *PipeListener {
+	return &PipeListener{
+		name:  name,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr { return pipeAddr(l.name) }
+
+// SetImpairment degrades both directions of connections dialed from now on.
+func (l *PipeListener) SetImpairment(imp Impairment) {
+	l.mu.Lock()
+	l.impair = imp
+	l.mu.Unlock()
+}
+
+// Dial connects to the listener; network and addr are ignored. It blocks
+// until the connection is accepted.
+func (l *PipeListener) Dial(network, addr string) (net.Conn, error) {
+	l.mu.Lock()
+	imp := l.impair
+	l.mu.Unlock()
+	up, down := newPipeBuffer(imp), newPipeBuffer(imp)
+	client := &memConn{in: down, out: up, local: l.name + "-client", remote: l.name}
+	server := &memConn{in: up, out: down, local: l.name, remote: l.name + "-client"}
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// NewPipeClient returns a client whose server connections are dialed on l
+// instead of cfg.RemoteAddr, which is still used for the Host header. The
+// TLS settings of cfg apply over the pipe as they would over TCP.
+func NewPipeClient(cfg *config.ClientConfig, l *PipeListener) *Client {
+	c := newClient(cfg, l.Dial)
+	c.start()
+	return c
+}
+
+// memConn is one end of an in-memory connection. Unlike net.Pipe, writes
+// are buffered and return at once, as on a TCP socket, so a TLS peer can
+// send an alert while the other side is still writing its own flight.
+// Buffers are unbounded; this is meant for tests.
+type memConn struct {
+	in, out       *pipeBuffer
+	local, remote string
+}
+
+func (c *memConn) Read(p []byte) (int, error)  { return c.in.read(p) }
+func (c *memConn) Write(p []byte) (int, error) { return c.out.write(p) }
+
+func (c *memConn) Close() error {
+	c.in.closeRead()
+	c.out.closeWrite()
+	return nil
+}
+
+// CloseWrite sends EOF to the peer and keeps reading.
+func (c *memConn) CloseWrite() error {
+	c.out.closeWrite()
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr  { return pipeAddr(c.local) }
+func (c *memConn) RemoteAddr() net.Addr { return pipeAddr(c.remote) }
+
+func (c *memConn) SetDeadline(t time.Time) error     { return c.in.setDeadline(t) }
+func (c *memConn) SetReadDeadline(t time.Time) error { return c.in.setDeadline(t) }
+
+// SetWriteDeadline has nothing to bound: writes never wait.
+func (c *memConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// pipeBuffer carries one direction of a memConn. Each write becomes a chunk
+// that the reader sees once its delivery time, set by the impairment, has
+// passed; chunks stay in order, as on a TCP connection.
+type pipeBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	chunks   []pipeChunk
+	eof      bool // Writer closed
+	closed   bool // Reader closed
+	deadline time.Time
+	timer    *time.Timer
+
+	impair    Impairment
+	busyUntil time.Time // When the bandwidth cap has sent the queued bytes
+	wake      *time.Timer
+}
+
+type pipeChunk struct {
+	data []byte
+	at   time.Time // Delivery time (zero = immediately)
+}
+
+func newPipeBuffer(imp Impairment) *pipeBuffer {
+	b := &pipeBuffer{impair: imp}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.closed {
+		if len(b.chunks) > 0 {
+			wait := time.Until(b.chunks[0].at)
+			if wait <= 0 {
+				break
+			}
+			b.wakeIn(wait)
+		} else if b.eof {
+			break
+		}
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, net.ErrClosed
+	}
+	if len(b.chunks) == 0 {
+		return 0, io.EOF
+	}
+	c := &b.chunks[0]
+	n := copy(p, c.data)
+	if c.data = c.data[n:]; len(c.data) == 0 {
+		b.chunks = b.chunks[1:]
+	}
+	return n, nil
+}
+
+// wakeIn wakes readers after d, when the next chunk is due.
+func (b *pipeBuffer) wakeIn(d time.Duration) {
+	if b.wake != nil {
+		b.wake.Stop()
+	}
+	b.wake = time.AfterFunc(d, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.eof || b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c := pipeChunk{data: append([]byte(nil), p...)}
+	if b.impair.active() {
+		c.at = b.deliveryTime(len(p))
+	}
+	b.chunks = append(b.chunks, c)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+func (b *pipeBuffer) closeWrite() {
+	b.mu.Lock()
+	b.eof = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *pipeBuffer) closeRead() {
+	b.mu.Lock()
+	b.closed = true
+	b.chunks = nil
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *pipeBuffer) setDeadline(t time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if !t.IsZero() {
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
@@ -0,0 +1,520 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/adapter/socks5"
+	"phoenix/pkg/config"
+	"phoenix/pkg/crypto"
+	"phoenix/pkg/protocol"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoTarget listens on loopback and echoes every connection.
+func echoTarget(t *testing.T) string {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() { io.Copy(c, c); c.Close() }()
+		}
+	}()
+	return target.Addr().String()
+}
+
+// pipeServer serves cfg on a PipeListener for the duration of the test.
+func pipeServer(t *testing.T, cfg *config.ServerConfig) *PipeListener {
+	ln := NewPipeListener("phoenix-server")
+	t.Cleanup(func() { ln.Close() })
+	go Serve(cfg, ln)
+	return ln
+}
+
+// keyFile writes a new Ed25519 key to dir and returns its path and public key.
+func keyFile(t *testing.T, dir, name string) (path, pub string) {
+	priv, pub, err := crypto.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, name)
+	if err := os.WriteFile(path, priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, pub
+}
+
+func TestPipeTunnel(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+
+	// SOCKS5 inbound → tunnel → server → target, all over pipes except the target.
+	local, remote := net.Pipe()
+	go socks5.HandleConnection(remote, &tunnelDialer{client: client, proto: protocol.ProtocolSOCKS5}, socks5.Options{})
+
+	tcp, _ := net.ResolveTCPAddr("tcp", target)
+	req := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01}
+	req = append(req, tcp.IP.To4()...)
+	req = append(req, byte(tcp.Port>>8), byte(tcp.Port))
+	go local.Write(req)
+
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(local, reply); err != nil {
+		t.Fatalf("Failed to read SOCKS5 replies: %v", err)
+	}
+	if reply[3] != socks5.ReplySucceeded {
+		t.Fatalf("Expected CONNECT to succeed, got reply %d", reply[3])
+	}
+
+	go local.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(local, echo); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if string(echo) != "ping" {
+		t.Errorf("Expected ping, got %q", echo)
+	}
+	local.Close()
+}
+
+// TestPipeRejects checks that each misconfigured client fails to open a
+// stream, next to a correctly configured one that succeeds. Every case runs
+// in parallel against its own server; select cases with e.g.
+// go test -run 'PipeRejects/token'.
+func TestPipeRejects(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	dir := t.TempDir()
+	serverKey, serverPub := keyFile(t, dir, "server.key")
+	clientKey, clientPub := keyFile(t, dir, "client.key")
+	strangerKey, _ := keyFile(t, dir, "stranger.key")
+	otherKey, otherPub := keyFile(t, dir, "other.key")
+
+	tokenServer := config.DefaultServerConfig()
+	tokenServer.Security.EnableSOCKS5 = true
+	tokenServer.Security.AuthToken = "secret"
+
+	mtlsServer := config.DefaultServerConfig()
+	mtlsServer.Security.EnableSOCKS5 = true
+	mtlsServer.Security.PrivateKeyPath = serverKey
+	mtlsServer.Security.AuthorizedClientKeys = []string{clientPub}
+
+	aclServer := config.DefaultServerConfig()
+	aclServer.Security.EnableSOCKS5 = true
+	aclServer.Users = []config.User{{Name: "guest", Token: "guest-token", Deny: []string{"127.0.0.0/8"}}}
+
+	// One endpoint per name: SNI picks the certificate and credentials.
+	vhostServer := config.DefaultServerConfig()
+	vhostServer.Security.EnableSOCKS5 = true
+	vhostServer.Security.PrivateKeyPath = serverKey
+	vhostServer.Security.AuthorizedClientKeys = []string{clientPub}
+	vhostServer.VHosts = []config.VHost{{ServerNames: []string{"*.vhost.test"}, PrivateKeyPath: otherKey, AuthToken: "vhost-secret"}}
+
+	// Any process runs more than one goroutine, so every stream is shed.
+	busyServer := config.DefaultServerConfig()
+	busyServer.Security.EnableSOCKS5 = true
+	busyServer.Limits.MaxGoroutines = 1
+
+	tests := []struct {
+		name    string
+		server  *config.ServerConfig
+		client  config.ClientConfig
+		wantErr bool
+	}{
+		{"right token", tokenServer, config.ClientConfig{AuthToken: "secret"}, false},
+		{"wrong token", tokenServer, config.ClientConfig{AuthToken: "wrong"}, true},
+		{"authorized key", mtlsServer, config.ClientConfig{PrivateKeyPath: clientKey, ServerPublicKey: serverPub}, false},
+		{"unauthorized key", mtlsServer, config.ClientConfig{PrivateKeyPath: strangerKey, ServerPublicKey: serverPub}, true},
+		{"pinned key mismatch", mtlsServer, config.ClientConfig{PrivateKeyPath: clientKey, ServerPublicKey: otherPub}, true},
+		{"acl denies loopback", aclServer, config.ClientConfig{AuthToken: "guest-token"}, true},
+		{"vhost token", vhostServer, config.ClientConfig{RemoteAddr: "a.vhost.test:443", ServerPublicKey: otherPub, AuthToken: "vhost-secret"}, false},
+		{"vhost without token", vhostServer, config.ClientConfig{RemoteAddr: "a.vhost.test:443", ServerPublicKey: otherPub}, true},
+		{"vhost pinned to default key", vhostServer, config.ClientConfig{RemoteAddr: "a.vhost.test:443", ServerPublicKey: serverPub, AuthToken: "vhost-secret"}, true},
+		{"default host of vhost server", vhostServer, config.ClientConfig{PrivateKeyPath: clientKey, ServerPublicKey: serverPub}, false},
+		{"overloaded", busyServer, config.ClientConfig{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := tt.client
+			if cfg.RemoteAddr == "" {
+				cfg.RemoteAddr = "phoenix.test:443"
+			}
+			client := NewPipeClient(&cfg, pipeServer(t, tt.server))
+			stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+			if err == nil {
+				// A stream the server refuses after accepting it ends without data.
+				stream.Write([]byte("ping"))
+				buf := make([]byte, 4)
+				_, err = io.ReadFull(stream, buf)
+				stream.Close()
+			}
+			if tt.wantErr && err == nil {
+				t.Errorf("Expected the stream to be refused")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Expected the stream to work, got %v", err)
+			}
+			if tt.name == "acl denies loopback" && err != nil && socks5.ReplyFor(err) != socks5.ReplyNotAllowed {
+				t.Errorf("Expected a not-allowed reply, got %v", err)
+			}
+			if tt.name == "overloaded" && !errors.Is(err, ErrServerBusy) {
+				t.Errorf("Expected ErrServerBusy, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPipeImpairment(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	ln := pipeServer(t, serverCfg)
+	ln.SetImpairment(Impairment{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, Loss: 0.1, Bandwidth: 1 << 20})
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, ln)
+
+	start := time.Now()
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer stream.Close()
+	if rtt := time.Since(start); rtt < 40*time.Millisecond {
+		t.Errorf("Expected Dial to take at least one 40ms round trip, took %v", rtt)
+	}
+
+	payload := bytes.Repeat([]byte("phoenix!"), 32<<10) // 256 KiB
+	start = time.Now()
+	go stream.Write(payload)
+	echo := make([]byte, len(payload))
+	if _, err := io.ReadFull(stream, echo); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if !bytes.Equal(echo, payload) {
+		t.Errorf("Echo differs from payload")
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("Expected 256 KiB at 1 MiB/s to take at least 250ms, took %v", d)
+	}
+}
+
+func TestPipeFallback(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "web site "+r.Host)
+	}))
+	t.Cleanup(web.Close)
+	serverKey, serverPub := keyFile(t, t.TempDir(), "server.key")
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Security.PrivateKeyPath = serverKey
+	serverCfg.Fallback.Backend = web.Listener.Addr().String()
+	ln := pipeServer(t, serverCfg)
+
+	// Tunnels still work...
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:443", ServerPublicKey: serverPub}, ln)
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	stream.Close()
+
+	// ...while HTTP/1.1 and non-tunnel h2 requests reach the web site.
+	for _, proto := range []string{"http/1.1", "h2"} {
+		status, body := browse(t, ln, proto, "https://www.example.com/")
+		if body != "web site www.example.com" {
+			t.Errorf("%s: Expected the backend's page, got %d %q", proto, status, body)
+		}
+	}
+}
+
+func TestPipeSite(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("home"), 0644)
+	os.Mkdir(filepath.Join(root, "assets"), 0755)
+	serverKey, serverPub := keyFile(t, t.TempDir(), "server.key")
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Security.PrivateKeyPath = serverKey
+	serverCfg.HTTP.Path = "/assets/app.js"
+	serverCfg.Fallback.Root = root
+	ln := pipeServer(t, serverCfg)
+
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:443", ServerPublicKey: serverPub, HTTP: serverCfg.HTTP}, ln)
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	stream.Close()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/", http.StatusOK, "home"},
+		{"/assets/", http.StatusNotFound, "404 page not found\n"}, // Not listed
+		{"/assets/app.js", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		status, body := browse(t, ln, "http/1.1", "https://www.example.com"+tt.path)
+		if status != tt.wantStatus || body != tt.wantBody {
+			t.Errorf("GET %s: Expected %d %q, got %d %q", tt.path, tt.wantStatus, tt.wantBody, status, body)
+		}
+	}
+}
+
+// browse GETs url from the TLS server on ln like a browser negotiating
+// proto, returning the status and body.
+func browse(t *testing.T, ln *PipeListener, proto, url string) (int, string) {
+	tr := &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := ln.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return tls.Client(conn, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{proto}}), nil
+		},
+		ForceAttemptHTTP2: proto == "h2",
+	}
+	defer tr.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: tr}).Get(url)
+	if err != nil {
+		t.Fatalf("%s: GET %s failed: %v", proto, url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestPipeStriping(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", Connections: 3}, pipeServer(t, serverCfg))
+
+	for i := range 4 {
+		stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		defer stream.Close()
+	}
+	conns := poolOf(client.httpClient).snapshot()
+	if len(conns) != 3 {
+		t.Fatalf("Expected streams striped over 3 connections, got %d", len(conns))
+	}
+	for _, cc := range conns {
+		if n := cc.State().StreamsActive; n < 1 || n > 2 {
+			t.Errorf("Expected 1 or 2 streams per connection, got %d", n)
+		}
+	}
+}
+
+func TestPipeSessionResumption(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	dir := t.TempDir()
+	serverKey, serverPub := keyFile(t, dir, "server.key")
+	clientKey, clientPub := keyFile(t, dir, "client.key")
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Security.PrivateKeyPath = serverKey
+	serverCfg.Security.AuthorizedClientKeys = []string{clientPub}
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:443", PrivateKeyPath: clientKey, ServerPublicKey: serverPub}, pipeServer(t, serverCfg))
+	<-client.Ready() // The first connection is a full handshake
+
+	for i, want := range []bool{false, true} {
+		stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		stream.Write([]byte("ping"))
+		io.ReadFull(stream, make([]byte, 4)) // The ticket arrives after the handshake
+		stream.Close()
+		if got := client.resumed.Load(); got != want {
+			t.Errorf("Connection %d: Expected resumed=%v, got %v", i, want, got)
+		}
+		client.NotifyNetworkChange()
+	}
+}
+
+func TestPipeResume(t *testing.T) {
+	t.Parallel()
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+			go io.Copy(c, c)
+		}
+	}()
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+	client.listeners.byID = map[string]*runningInbound{"ssh": {cfg: config.ClientInbound{Resumable: true}}}
+
+	stream, err := client.dialFrom("ssh", protocol.ProtocolSOCKS5, target.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer stream.Close()
+	if _, ok := stream.(*resumableStream); !ok {
+		t.Fatalf("Expected a resumable stream, got %T", stream)
+	}
+	for i, msg := range []string{"before the drop", "after the drop"} {
+		if _, err := stream.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+		echo := make([]byte, len(msg))
+		if _, err := io.ReadFull(stream, echo); err != nil {
+			t.Fatalf("Read %d failed: %v", i, err)
+		}
+		if string(echo) != msg {
+			t.Errorf("Expected %q, got %q", msg, echo)
+		}
+		client.NotifyNetworkChange()
+	}
+	<-accepted
+	select {
+	case <-accepted:
+		t.Errorf("Expected the target connection to survive the drop, got a second one")
+	default:
+	}
+}
+
+func TestPipeDialRetry(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	ln := pipeServer(t, serverCfg)
+
+	// The first connection attempt is reset.
+	var dials atomic.Int32
+	dial := func(network, addr string) (net.Conn, error) {
+		if dials.Add(1) == 1 {
+			return nil, errors.New("connection reset by peer")
+		}
+		return ln.Dial(network, addr)
+	}
+	cfg := &config.ClientConfig{RemoteAddr: "phoenix.test:80"}
+	cfg.Retry.MaxAttempts = 2
+	cfg.Retry.Backoff = time.Millisecond
+	stream, err := newClient(cfg, dial).Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Expected the reset dial to be retried, got %v", err)
+	}
+	stream.Close()
+	if n := dials.Load(); n != 2 {
+		t.Errorf("Expected 2 connection attempts, got %d", n)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+
+	// A stream that ends while Shutdown drains lets it return cleanly.
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { stream.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Errorf("Expected the drained shutdown to succeed, got %v", err)
+	}
+	if _, err := client.Dial(protocol.ProtocolSOCKS5, target); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected Dial after Close to fail with ErrClosed, got %v", err)
+	}
+
+	// Streams still open at the deadline are aborted.
+	client = NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+	stream, err = client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the shutdown to time out, got %v", err)
+	}
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the aborted stream to fail")
+	}
+}
+
+func TestPipeListen(t *testing.T) {
+	t.Parallel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.ReverseListen = []string{addr}
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+
+	if _, err := client.Listen("tcp", "127.0.0.1:1"); err == nil {
+		t.Errorf("Expected an address outside reverse_listen to be refused")
+	}
+	ln, err := client.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the tunnel from "+r.RemoteAddr)
+	}))
+
+	// Each request needs a connection of its own, accepted in turn.
+	for range 3 {
+		resp, err := (&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}).Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("Request through the server failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !bytes.HasPrefix(body, []byte("through the tunnel from 127.0.0.1:")) {
+			t.Errorf("Unexpected answer %q", body)
+		}
+	}
+}
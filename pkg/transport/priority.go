@@ -0,0 +1,203 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"sync"
+	"time"
+)
+
+// FeaturePriority lets a client give a stream a priority class (routing.
+// interactive and routing.bulk), sent in the priority header or metadata
+// field. Go's HTTP/2 stack neither sends nor honours PRIORITY frames, so each
+// end schedules its own writes by class: the server its downloads, the client
+// its uploads.
+const FeaturePriority protocol.Feature = "priority"
+
+func init() {
+	protocol.RegisterFeature(FeaturePriority)
+}
+
+// priority is a stream's class. Normal streams are not scheduled.
+type priority byte
+
+const (
+	priorityNormal priority = iota
+	priorityInteractive
+	priorityBulk
+)
+
+func (p priority) String() string {
+	switch p {
+	case priorityInteractive:
+		return "interactive"
+	case priorityBulk:
+		return "bulk"
+	}
+	return ""
+}
+
+func parsePriority(s string) priority {
+	switch s {
+	case "interactive":
+		return priorityInteractive
+	case "bulk":
+		return priorityBulk
+	}
+	return priorityNormal
+}
+
+const (
+	// bulkChunk is the largest write of a bulk stream, so an interactive
+	// write never queues behind a whole buffer of bulk data.
+	bulkChunk = 16 << 10
+
+	// maxBulkWait bounds how long a bulk chunk waits for interactive
+	// writes, which can block on a slow reader's flow control window.
+	maxBulkWait = 50 * time.Millisecond
+)
+
+// prioGate schedules the writes of the streams sharing a connection: bulk
+// streams wait while an interactive stream is writing.
+type prioGate struct {
+	mu     sync.Mutex
+	active int           // Interactive writes in progress
+	idle   chan struct{} // Closed while active is 0
+}
+
+func newPrioGate() *prioGate {
+	g := &prioGate{idle: make(chan struct{})}
+	close(g.idle)
+	return g
+}
+
+func (g *prioGate) begin() {
+	g.mu.Lock()
+	if g.active == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+func (g *prioGate) end() {
+	g.mu.Lock()
+	if g.active--; g.active == 0 {
+		close(g.idle)
+	}
+	g.mu.Unlock()
+}
+
+// wait returns once no interactive write is in progress, or after
+// maxBulkWait.
+func (g *prioGate) wait() {
+	g.mu.Lock()
+	idle := g.idle
+	g.mu.Unlock()
+	select {
+	case <-idle:
+		return
+	default:
+	}
+	t := time.NewTimer(maxBulkWait)
+	defer t.Stop()
+	select {
+	case <-idle:
+	case <-t.C:
+	}
+}
+
+// writer returns w scheduled as class p. A nil gate leaves w alone.
+func (g *prioGate) writer(w io.Writer, p priority) io.Writer {
+	if g == nil || p == priorityNormal {
+		return w
+	}
+	return &prioWriter{w: w, gate: g, class: p}
+}
+
+// prioWriter is a stream's writer scheduled through a prioGate.
+type prioWriter struct {
+	w     io.Writer
+	gate  *prioGate
+	class priority
+}
+
+func (w *prioWriter) Write(p []byte) (int, error) {
+	if w.class == priorityInteractive {
+		w.gate.begin()
+		defer w.gate.end()
+		return w.w.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		w.gate.wait()
+		n, err := w.w.Write(p[:min(len(p), bulkChunk)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the underlying writer, so Stream.CloseWrite keeps working.
+func (w *prioWriter) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// priorityHosts holds the matchers of routing.interactive and routing.bulk.
+type priorityHosts struct {
+	interactive, bulk *outbound.HostMatcher
+}
+
+func newPriorityHosts(interactive, bulk []string) (*priorityHosts, error) {
+	if len(interactive) == 0 && len(bulk) == 0 {
+		return nil, nil
+	}
+	h := &priorityHosts{}
+	var err error
+	if h.interactive, err = outbound.ParseHostMatcher(interactive); err != nil {
+		return nil, err
+	}
+	if h.bulk, err = outbound.ParseHostMatcher(bulk); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// priorityOf returns the class of target: that of a routing rule's
+// interactive or bulk action, otherwise of the lists.
+func (c *Client) priorityOf(target string) priority {
+	switch c.ruleAction(target) {
+	case "interactive":
+		return priorityInteractive
+	case "bulk":
+		return priorityBulk
+	}
+	return c.priorities.of(target)
+}
+
+// of returns the class of target ("host:port"); interactive wins when both
+// lists match.
+func (h *priorityHosts) of(target string) priority {
+	if h == nil || target == "" {
+		return priorityNormal
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return priorityNormal
+	}
+	switch {
+	case h.interactive.Match(host):
+		return priorityInteractive
+	case h.bulk.Match(host):
+		return priorityBulk
+	}
+	return priorityNormal
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks every Write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestPrioGate(t *testing.T) {
+	g := newPrioGate()
+	slow := &blockingWriter{release: make(chan struct{})}
+	interactive := g.writer(slow, priorityInteractive)
+	done := make(chan struct{})
+	go func() {
+		interactive.Write([]byte("keystroke"))
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); ; {
+		g.mu.Lock()
+		active := g.active
+		g.mu.Unlock()
+		if active == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Interactive write never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A bulk chunk waits for the interactive write, but not forever.
+	var buf bytes.Buffer
+	bulk := g.writer(&buf, priorityBulk)
+	start := time.Now()
+	if _, err := bulk.Write([]byte("chunk")); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < maxBulkWait {
+		t.Errorf("Expected the bulk write to wait %v for the interactive one, waited %v", maxBulkWait, waited)
+	}
+
+	close(slow.release)
+	<-done
+	payload := bytes.Repeat([]byte{1}, 3*bulkChunk+1)
+	start = time.Now()
+	if n, err := bulk.Write(payload); n != len(payload) || err != nil {
+		t.Fatalf("Expected a %d byte bulk write, got %d, %v", len(payload), n, err)
+	}
+	if waited := time.Since(start); waited >= maxBulkWait {
+		t.Errorf("Expected an idle gate to let bulk writes through, took %v", waited)
+	}
+	if w := g.writer(io.Discard, priorityNormal); w != io.Discard {
+		t.Errorf("Expected normal streams to be unscheduled")
+	}
+}
@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// ProbeTLS opens one connection to the server exactly as the tunnel would
+// (chain hops, fingerprint, ClientHello fragmentation and key pinning
+// included), completes the handshake and closes it again. It describes the
+// negotiated session; in h2c mode no TLS is involved and it only reports
+// that the connection opened.
+func (c *Client) ProbeTLS() (string, error) {
+	c.mu.RLock()
+	tr, ok := c.httpClient.Transport.(*http2.Transport)
+	c.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unexpected transport %T", c.httpClient.Transport)
+	}
+	conn, err := tr.DialTLS("tcp", c.Config.RemoteAddr, nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	switch tc := conn.(type) {
+	case *tls.Conn:
+		s := tc.ConnectionState()
+		return fmt.Sprintf("%s, ALPN %q", tls.VersionName(s.Version), s.NegotiatedProtocol), nil
+	case *utls.UConn:
+		s := tc.ConnectionState()
+		return fmt.Sprintf("%s, ALPN %q, fingerprint %s", tls.VersionName(s.Version), s.NegotiatedProtocol, c.Config.Fingerprint), nil
+	}
+	return "cleartext h2c, no TLS", nil
+}
@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"phoenix/pkg/config"
+	"strings"
+)
+
+// Number of request paths derived from an HTTP profile secret.
+const derivedPathCount = 8
+
+// wireProfile is the resolved form of config.HTTPProfile: the concrete
+// method, paths and header names used for tunnel requests.
+type wireProfile struct {
+	method    string
+	paths     []string // empty = any path accepted, "/" sent
+	hProtocol string
+	hTarget   string
+	hToken    string
+	hVersion  string
+	hProtoVer string
+	hFeatures string
+	hPriority string
+	hResume   string
+	decoy     http.Header
+	anyPath   bool
+}
+
+// Words used to build plausible-looking derived header names and paths.
+var (
+	headerWords = []string{"Request", "Trace", "Client", "Session", "Cache", "Edge", "Origin", "Context",
+		"Route", "Span", "Data", "Meta", "Flow", "State", "Hint", "Build", "Chunk", "Sync", "Locale", "Device"}
+	pathWords = []string{"api", "v1", "v2", "assets", "static", "cdn", "sync", "upload", "data", "media",
+		"events", "collect", "rpc", "updates", "content", "stream", "batch", "telemetry"}
+)
+
+// browserUserAgents matches the User-Agent to the uTLS fingerprint so the
+// HTTP layer doesn't contradict the ClientHello.
+var browserUserAgents = map[string]string{
+	"chrome":  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+	"firefox": "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0",
+	"safari":  "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.1 Safari/605.1.15",
+}
+
+// newWireProfile resolves a profile. fingerprint is the client's TLS
+// fingerprint setting (empty on the server) and selects the default UA.
+func newWireProfile(p config.HTTPProfile, fingerprint string) *wireProfile {
+	wp := &wireProfile{
+		method:    "POST",
+		hProtocol: "X-Nerve-Protocol",
+		hTarget:   "X-Nerve-Target",
+		hToken:    "X-Nerve-Token",
+		hVersion:  "X-Nerve-Version",
+		hProtoVer: "X-Nerve-Proto-Version",
+		hFeatures: "X-Nerve-Features",
+		hPriority: "X-Nerve-Priority",
+		hResume:   "X-Nerve-Resume",
+		decoy:     http.Header{},
+	}
+	if p.Method != "" {
+		wp.method = strings.ToUpper(p.Method)
+	}
+
+	if p.Secret != "" {
+		d := &deriver{key: []byte(p.Secret)}
+		names := d.headerNames(8)
+		wp.hProtocol, wp.hTarget, wp.hToken = names[0], names[1], names[2]
+		wp.hVersion, wp.hProtoVer, wp.hFeatures = names[3], names[4], names[5]
+		wp.hPriority, wp.hResume = names[6], names[7]
+		for i := 0; i < derivedPathCount; i++ {
+			wp.paths = append(wp.paths, d.path(i))
+		}
+	}
+
+	if p.Path != "" {
+		wp.paths = []string{p.Path}
+	}
+	wp.anyPath = len(wp.paths) == 0
+
+	override := func(dst *string, v string) {
+		if v != "" {
+			*dst = http.CanonicalHeaderKey(v)
+		}
+	}
+	override(&wp.hProtocol, p.ProtocolHeader)
+	override(&wp.hTarget, p.TargetHeader)
+	override(&wp.hToken, p.TokenHeader)
+	override(&wp.hVersion, p.VersionHeader)
+
+	ua := p.UserAgent
+	if ua == "" {
+		ua = browserUserAgents[fingerprint]
+	}
+	if ua != "" {
+		wp.decoy.Set("User-Agent", ua)
+	}
+	if p.Accept != "" {
+		wp.decoy.Set("Accept", p.Accept)
+	}
+	for k, v := range p.Headers {
+		wp.decoy.Set(k, v)
+	}
+	return wp
+}
+
+// requestPath picks the path for a new stream.
+func (wp *wireProfile) requestPath() string {
+	if len(wp.paths) == 0 {
+		return "/"
+	}
+	return wp.paths[rand.Intn(len(wp.paths))]
+}
+
+// acceptsPath reports whether the server should treat a request for path as
+// a tunnel stream.
+func (wp *wireProfile) acceptsPath(path string) bool {
+	if wp.anyPath {
+		return true
+	}
+	for _, p := range wp.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// deriver produces deterministic names from an HMAC-SHA256 keyed by the
+// profile secret, so client and server agree without exchanging them.
+type deriver struct {
+	key []byte
+}
+
+func (d *deriver) sum(label string, n int) []byte {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte(label))
+	var ctr [4]byte
+	binary.BigEndian.PutUint32(ctr[:], uint32(n))
+	mac.Write(ctr[:])
+	return mac.Sum(nil)
+}
+
+// headerNames derives count distinct header names of the form X-Word-Word.
+func (d *deriver) headerNames(count int) []string {
+	seen := map[string]bool{}
+	var out []string
+	for n := 0; len(out) < count; n++ {
+		b := d.sum("phoenix-header", n)
+		i, j := int(b[0])%len(headerWords), int(b[1])%len(headerWords)
+		name := "X-" + headerWords[i] + "-" + headerWords[j]
+		if i == j || seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	return out
+}
+
+// path derives the i-th request path, e.g. "/api/sync/4f1c9a2e".
+func (d *deriver) path(i int) string {
+	b := d.sum("phoenix-path", i)
+	return "/" + pathWords[int(b[0])%len(pathWords)] + "/" + pathWords[int(b[1])%len(pathWords)] + "/" + hex.EncodeToString(b[2:6])
+}
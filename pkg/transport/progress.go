@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress is a stream's transfer so far, as passed to progress callbacks.
+// Rates are refreshed at most every second, however often it is reported.
+type Progress struct {
+	Connection
+	Done bool // The stream has closed; this is its last report
+}
+
+// OnProgress calls fn every interval for each of the client's streams that
+// moved data since the previous call, and once more as each one closes,
+// until stop. Calls are made one at a time from a single goroutine, so fn
+// should return quickly. Use it to show transfer progress without wrapping
+// the streams' readers and writers.
+func (c *Client) OnProgress(interval time.Duration, fn func(Progress)) (stop func()) {
+	events, stopWatch := c.streams.watch()
+	done := make(chan struct{})
+	go func() {
+		defer stopWatch()
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		moved := map[uint64]int64{}
+		for {
+			select {
+			case <-tick.C:
+				last := moved
+				moved = make(map[uint64]int64, len(last))
+				for _, conn := range c.streams.list() {
+					n := conn.BytesUp + conn.BytesDn
+					if n != last[conn.ID] {
+						fn(Progress{Connection: conn})
+					}
+					moved[conn.ID] = n
+				}
+			case ev := <-events:
+				if ev.Type == "close" {
+					delete(moved, ev.Connection.ID)
+					fn(Progress{Connection: ev.Connection, Done: true})
+				}
+			case <-done:
+				return
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// OnProgress calls fn with the stream's transfer every interval while it
+// moves data, and once more when it closes. Calls come from one goroutine.
+func (s *Stream) OnProgress(interval time.Duration, fn func(Progress)) {
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		var moved int64
+		for {
+			select {
+			case now := <-tick.C:
+				conn := s.lc.snapshot(now)
+				if n := conn.BytesUp + conn.BytesDn; n != moved {
+					moved = n
+					fn(Progress{Connection: conn})
+				}
+			case <-s.lc.done:
+				fn(Progress{Connection: s.lc.snapshot(time.Now()), Done: true})
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,299 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"phoenix/pkg/config"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// quotaSaveInterval is how often quota.state_file is written.
+	quotaSaveInterval = time.Minute
+
+	// webhookTimeout bounds each quota.webhook call.
+	webhookTimeout = 10 * time.Second
+)
+
+// defaultQuotaAlerts are the alert percentages without quota.alerts.
+var defaultQuotaAlerts = []int{80, 100}
+
+// ErrQuotaExceeded cuts the streams of a user without quota.throttle once
+// the allowance is used up.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaAlert is the JSON body POSTed to quota.webhook.
+type QuotaAlert struct {
+	User      string    `json:"user"`
+	Percent   int       `json:"percent"` // The alert threshold reached
+	Used      int64     `json:"used"`    // Bytes this period
+	Limit     int64     `json:"limit"`
+	Period    time.Time `json:"period"`    // Start of the period
+	Throttled bool      `json:"throttled"` // Used up, and slowed rather than cut off
+}
+
+// quota tracks one user's usage of config.UserQuota.
+type quota struct {
+	user    string
+	cfg     config.UserQuota
+	alerts  []int  // Ascending
+	webhook string // Empty = none
+	pace    *pacer // Throttle once used up; nil = refuse
+
+	mu      sync.Mutex
+	period  time.Time // Start of the current period
+	used    int64
+	alerted int // Highest alert percentage sent this period
+}
+
+// newQuota returns the quota of user, or nil when q is unlimited.
+func newQuota(user string, q config.UserQuota, cfg config.QuotaConfig) *quota {
+	if q.Bytes <= 0 {
+		return nil
+	}
+	alerts := slices.Clone(cfg.Alerts)
+	if len(alerts) == 0 {
+		alerts = defaultQuotaAlerts
+	}
+	slices.Sort(alerts)
+	qu := &quota{user: user, cfg: q, alerts: alerts, webhook: cfg.Webhook}
+	if q.Throttle > 0 {
+		qu.pace = &pacer{rate: float64(q.Throttle)}
+	}
+	qu.period = periodStart(time.Now(), q)
+	return qu
+}
+
+// carry takes over the usage of old, the user's quota before its settings
+// changed, if they are in the same period.
+func (q *quota) carry(old *quota) {
+	if old == nil {
+		return
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	old.roll(time.Now())
+	if !old.period.Equal(q.period) {
+		return
+	}
+	q.used = old.used
+	for _, a := range q.alerts {
+		if int64(a)*q.cfg.Bytes <= q.used*100 {
+			q.alerted = a // Already past it under the new allowance
+		}
+	}
+}
+
+// periodStart returns the start of the quota period containing now.
+func periodStart(now time.Time, q config.UserQuota) time.Time {
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	switch q.Period {
+	case "daily":
+		return day
+	case "weekly":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	reset := max(q.ResetDay, 1)
+	start := time.Date(y, m, reset, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// roll starts a new period once the current one is over. Callers hold mu.
+func (q *quota) roll(now time.Time) {
+	if start := periodStart(now, q.cfg); !start.Equal(q.period) {
+		if q.used > 0 {
+			log.Printf("[Quota] New %s period for user %s (used %d of %d bytes)", q.periodName(), q.user, q.used, q.cfg.Bytes)
+		}
+		q.period, q.used, q.alerted = start, 0, 0
+	}
+}
+
+func (q *quota) periodName() string {
+	if q.cfg.Period == "" {
+		return "monthly"
+	}
+	return q.cfg.Period
+}
+
+// add counts n bytes and reports whether the allowance is used up.
+func (q *quota) add(n int) bool {
+	q.mu.Lock()
+	q.roll(time.Now())
+	q.used += int64(n)
+	pct := int(q.used * 100 / q.cfg.Bytes)
+	var due []QuotaAlert
+	for _, a := range q.alerts {
+		if a > q.alerted && a <= pct {
+			due = append(due, QuotaAlert{User: q.user, Percent: a, Used: q.used, Limit: q.cfg.Bytes, Period: q.period, Throttled: a == 100 && q.pace != nil})
+			q.alerted = a
+		}
+	}
+	over := q.used >= q.cfg.Bytes
+	q.mu.Unlock()
+
+	for _, a := range due {
+		q.alert(a)
+	}
+	return over
+}
+
+// refuses reports whether new streams are refused: the allowance is used
+// up and there's no throttle.
+func (q *quota) refuses() bool {
+	if q.pace != nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	return q.used >= q.cfg.Bytes
+}
+
+// alert logs a, tells the user with a "quota" notice and calls the webhook.
+func (q *quota) alert(a QuotaAlert) {
+	log.Printf("[Quota] User %s reached %d%% of the %s quota (%d of %d bytes)", a.User, a.Percent, q.periodName(), a.Used, a.Limit)
+	msg := fmt.Sprintf("%d%% of your %s traffic quota used", a.Percent, q.periodName())
+	switch {
+	case a.Throttled:
+		msg += ", speed is now limited"
+	case a.Percent >= 100:
+		msg += ", new connections are refused"
+	}
+	Announce(Notice{Kind: NoticeQuota, Message: msg, Users: []string{a.User}})
+	if q.webhook != "" {
+		go postQuotaAlert(q.webhook, a)
+	}
+}
+
+func postQuotaAlert(url string, a QuotaAlert) {
+	body, _ := json.Marshal(a)
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[Quota] Webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("[Quota] Webhook answered with status %d", resp.StatusCode)
+	}
+}
+
+// pacer spaces out transfers to rate bytes per second, shared by all of a
+// user's streams.
+type pacer struct {
+	rate float64
+
+	mu   sync.Mutex
+	next time.Time // When the next transfer may start
+}
+
+// wait blocks for the time n bytes take at the rate.
+func (p *pacer) wait(n int) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	d := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+	p.mu.Unlock()
+	time.Sleep(d)
+}
+
+// quotaStream counts a stream's traffic against a quota, slowing or
+// cutting it once the allowance is used up.
+type quotaStream struct {
+	io.ReadWriteCloser
+	q *quota
+}
+
+func (s *quotaStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	if n > 0 && s.q.add(n) {
+		if s.q.pace == nil {
+			return 0, ErrQuotaExceeded
+		}
+		s.q.pace.wait(n)
+	}
+	return n, err
+}
+
+func (s *quotaStream) Write(p []byte) (int, error) {
+	if s.q.refuses() {
+		return 0, ErrQuotaExceeded
+	}
+	n, err := s.ReadWriteCloser.Write(p)
+	if n > 0 && s.q.add(n) && s.q.pace != nil {
+		s.q.pace.wait(n)
+	}
+	return n, err
+}
+
+// quotaState is a user's entry in quota.state_file.
+type quotaState struct {
+	Period  time.Time `json:"period"`
+	Used    int64     `json:"used"`
+	Alerted int       `json:"alerted"`
+}
+
+// keepQuotas restores the usage of the current periods from path and
+// saves it there every quotaSaveInterval.
+func keepQuotas(path string, current func() []*quota) {
+	quotas := current()
+	if data, err := os.ReadFile(path); err == nil {
+		var states map[string]quotaState
+		if err := json.Unmarshal(data, &states); err != nil {
+			log.Printf("[Quota] Ignoring invalid %s: %v", path, err)
+		}
+		for _, q := range quotas {
+			st, ok := states[q.user]
+			q.mu.Lock()
+			if ok && st.Period.Equal(q.period) {
+				q.used, q.alerted = st.Used, st.Alerted
+			}
+			q.mu.Unlock()
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("[Quota] Failed to read %s: %v", path, err)
+	}
+
+	go func() {
+		for range time.Tick(quotaSaveInterval) {
+			if err := saveQuotas(path, current()); err != nil {
+				log.Printf("[Quota] Failed to save %s: %v", path, err)
+			}
+		}
+	}()
+}
+
+func saveQuotas(path string, quotas []*quota) error {
+	states := make(map[string]quotaState, len(quotas))
+	for _, q := range quotas {
+		q.mu.Lock()
+		q.roll(time.Now())
+		states[q.user] = quotaState{Period: q.period, Used: q.used, Alerted: q.alerted}
+		q.mu.Unlock()
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
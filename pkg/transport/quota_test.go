@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"io"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC) // A Wednesday
+	for _, tt := range []struct {
+		q    config.UserQuota
+		want time.Time
+	}{
+		{config.UserQuota{Period: "daily"}, time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{config.UserQuota{Period: "weekly"}, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{config.UserQuota{}, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{config.UserQuota{Period: "monthly", ResetDay: 15}, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+	} {
+		if got := periodStart(now, tt.q); !got.Equal(tt.want) {
+			t.Errorf("%+v: expected the period to start %v, got %v", tt.q, tt.want, got)
+		}
+	}
+}
+
+func TestPipeQuota(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Users = []config.User{{Name: "quota-test", Token: "secret", Quota: config.UserQuota{Bytes: 10}}}
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: "secret"}, pipeServer(t, serverCfg))
+
+	// The alerts reach the client on its notice stream.
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if list := client.Connections(); len(list) > 0 && list[0].Protocol == string(protocolNotices) {
+			break
+		}
+	}
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	stream.Write([]byte("hello"))
+	io.ReadFull(stream, make([]byte, 5))
+	stream.Close()
+
+	var notices []Notice
+	for deadline := time.Now().Add(5 * time.Second); len(notices) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		notices = client.Notices()
+	}
+	if len(notices) != 2 || notices[0].Kind != NoticeQuota || notices[1].Message != "100% of your monthly traffic quota used, new connections are refused" {
+		t.Errorf("Expected the 80%% and 100%% quota notices, got %+v", notices)
+	}
+	if _, err := client.Dial(protocol.ProtocolSOCKS5, target); err == nil {
+		t.Errorf("Expected Dial to be refused once the quota is used up")
+	}
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"log"
+	"time"
+)
+
+// Pauses between the startup checks of the server, doubling from the first.
+const (
+	readyRetry    = 500 * time.Millisecond
+	maxReadyRetry = 10 * time.Second
+)
+
+// Ready returns a channel closed once the server has first answered a
+// health check (an HTTP/2 ping over a fresh connection). Check the client
+// is ready before reporting it up, instead of waiting a fixed time.
+func (c *Client) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// checkReady pings the server until it answers, then closes c.ready.
+func (c *Client) checkReady() {
+	wait := readyRetry
+	for {
+		c.mu.RLock()
+		pool := poolOf(c.httpClient)
+		c.mu.RUnlock()
+		start := time.Now()
+		if err := pool.probe(defaultKeepaliveTimeout); err == nil {
+			log.Printf("[Transport] Server answered in %v", time.Since(start).Round(time.Millisecond))
+			close(c.ready)
+			return
+		}
+		if !c.sleep(wait) {
+			return
+		}
+		wait = min(2*wait, maxReadyRetry)
+	}
+}
@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"phoenix/pkg/outbound"
+	"strings"
+)
+
+// realRemoteAddr returns the address of the client behind r. When the peer
+// is one of trusted_proxies (a CDN edge or load balancer) that is the
+// address it reports in CF-Connecting-IP, or else the last X-Forwarded-For
+// hop it doesn't trust. Otherwise, and whenever no header names a valid
+// address, it is r.RemoteAddr: headers from anyone else are ignored.
+func realRemoteAddr(r *http.Request, trusted *outbound.HostMatcher) string {
+	if trusted == nil || !trusted.Match(hostOf(r.RemoteAddr)) {
+		return r.RemoteAddr
+	}
+	if ip, ok := headerIP(r.Header.Get("CF-Connecting-IP")); ok {
+		return net.JoinHostPort(ip, "0")
+	}
+	// Hops are appended, so walk back from the nearest one.
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := headerIP(hops[i])
+		if !ok {
+			break
+		}
+		client = ip
+		if !trusted.Match(ip) {
+			break
+		}
+	}
+	if client == "" {
+		return r.RemoteAddr
+	}
+	return net.JoinHostPort(client, "0")
+}
+
+// headerIP parses an address from a forwarding header, which some proxies
+// send with a port.
+func headerIP(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap().String(), true
+	}
+	if a, err := netip.ParseAddr(s); err == nil && a.Zone() == "" {
+		return a.Unmap().String(), true
+	}
+	return "", false
+}
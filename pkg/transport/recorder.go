@@ -0,0 +1,48 @@
+package transport
+
+import "io"
+
+// Recorder taps streams for deployments that must keep records of them,
+// e.g. for compliance. It is off unless set in ServerOptions, and even
+// then only sees the streams of users whose record setting consents to it:
+// "metadata" users get RecordStream and End, "content" users also the data
+// as sent on the stream, server-side handshakes and the dial status
+// included. Streams authenticated without a user, and control streams,
+// are never recorded.
+type Recorder interface {
+	// RecordStream is called as a stream is accepted; content reports
+	// whether the user consented to content recording. A nil result skips
+	// the stream. It is called from many goroutines at once.
+	RecordStream(conn Connection, content bool) StreamRecording
+}
+
+// StreamRecording receives one stream. Upload and Download are called on
+// the stream's relay goroutines, so they should return quickly; p is only
+// valid during the call.
+type StreamRecording interface {
+	Upload(p []byte)     // Data from the client
+	Download(p []byte)   // Data to the client
+	End(conn Connection) // The stream closed; conn has its final counts
+}
+
+// recordedStream passes a stream's data to its recording.
+type recordedStream struct {
+	io.ReadWriteCloser
+	rec StreamRecording
+}
+
+func (s *recordedStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	if n > 0 {
+		s.rec.Upload(p[:n])
+	}
+	return n, err
+}
+
+func (s *recordedStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Write(p)
+	if n > 0 {
+		s.rec.Download(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,608 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FeatureResume lets the streams of resumable inbounds outlive the tunnel
+// stream carrying them. The client names each stream with a random id in
+// the resume header. When the stream fails it opens a new one with
+// "id:offset", offset being the bytes it has received, and the server
+// answers with the bytes it has received. Both sides then resend what the
+// other missed and the target connection never notices the drop.
+const FeatureResume protocol.Feature = "resume"
+
+func init() {
+	protocol.RegisterFeature(FeatureResume)
+}
+
+const (
+	// resumeBuffer is how many recently sent bytes each side keeps for
+	// resending. It is larger than the HTTP/2 flow-control windows, which
+	// bound what can be lost in flight.
+	resumeBuffer = 8 << 20
+	// resumeGrace is how long a dropped stream can be resumed.
+	resumeGrace = 30 * time.Second
+)
+
+// errStreamDropped aborts the request body of a stream being replaced, so
+// the server doesn't take it for the end of the upload.
+var errStreamDropped = errors.New("stream dropped")
+
+// resendBuffer keeps the last resumeBuffer bytes written to a stream in a
+// ring: the byte at offset o is at o % resumeBuffer.
+type resendBuffer struct {
+	buf []byte // Grows to resumeBuffer
+	end int64  // Bytes written so far
+}
+
+func (b *resendBuffer) add(p []byte) {
+	start := b.end
+	b.end += int64(len(p))
+	if len(p) > resumeBuffer {
+		start += int64(len(p) - resumeBuffer)
+		p = p[len(p)-resumeBuffer:]
+	}
+	for len(p) > 0 {
+		i := int(start % resumeBuffer)
+		var n int
+		if i == len(b.buf) && i < resumeBuffer {
+			n = min(len(p), resumeBuffer-i)
+			b.buf = append(b.buf, p[:n]...)
+		} else {
+			if i > len(b.buf) {
+				b.buf = append(b.buf, make([]byte, resumeBuffer-len(b.buf))...)
+			}
+			n = copy(b.buf[i:], p)
+		}
+		p, start = p[n:], start+int64(n)
+	}
+}
+
+// since returns a copy of the bytes written after the first off, or false
+// when they are no longer kept.
+func (b *resendBuffer) since(off int64) ([]byte, bool) {
+	if off > b.end || b.end-off > int64(len(b.buf)) {
+		return nil, false
+	}
+	out := make([]byte, b.end-off)
+	n := copy(out, b.buf[off%resumeBuffer:])
+	copy(out[n:], b.buf)
+	return out, true
+}
+
+func newResumeID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseResume splits a resume header into the stream id and, for a
+// resumed stream, the offset.
+func parseResume(v string) (id string, off int64, resumed bool) {
+	id, offset, resumed := strings.Cut(v, ":")
+	if !resumed {
+		return id, 0, false
+	}
+	off, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil || off < 0 {
+		return "", 0, false
+	}
+	return id, off, true
+}
+
+// dialFrom opens a stream for a connection of the named inbound, resumable
+// when the inbound asks for it.
+func (c *Client) dialFrom(inbound string, proto protocol.ProtocolType, target string) (io.ReadWriteCloser, error) {
+	if target == "" || !c.resumable(inbound) {
+		return c.Dial(proto, target)
+	}
+	id := newResumeID()
+	st, _, err := c.dial(proto, target, id)
+	if err != nil {
+		return nil, err
+	}
+	if !protocol.HasFeature(st.Features, FeatureResume) {
+		return st, nil // The server can't, so drops reset the stream as usual
+	}
+	return &resumableStream{c: c, proto: proto, target: target, id: id, cur: st, wcur: st}, nil
+}
+
+func (c *Client) resumable(inbound string) bool {
+	c.listeners.mu.Lock()
+	defer c.listeners.mu.Unlock()
+	ib, ok := c.listeners.byID[inbound]
+	return ok && ib.cfg.Resumable
+}
+
+// resumableStream is a client stream that moves to a new tunnel stream when
+// the current one fails, instead of failing the caller's reads and writes.
+// A stream whose upload has ended is not resumed.
+type resumableStream struct {
+	c      *Client
+	proto  protocol.ProtocolType
+	target string
+	id     string
+
+	mu   sync.Mutex // Protects cur and dead; held while resuming
+	cur  *Stream
+	dead error // Set once the stream can no longer be resumed
+
+	rmu      sync.Mutex // Held by Read, so resume sees a settled count
+	received int64      // Download bytes passed to the caller
+
+	wmu     sync.Mutex   // Serializes writes with resending
+	wcur    *Stream      // Stream writes go to
+	sent    resendBuffer // Upload bytes
+	sentEOF bool         // CloseWrite was called
+}
+
+func (s *resumableStream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		st, dead := s.cur, s.dead
+		s.mu.Unlock()
+		if dead != nil {
+			return 0, dead
+		}
+		s.rmu.Lock()
+		n, err := st.Read(p)
+		s.received += int64(n)
+		s.rmu.Unlock()
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		if err == io.EOF {
+			return 0, err
+		}
+		if err := s.resume(st, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *resumableStream) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	s.sent.add(p)
+	st := s.wcur
+	_, err := st.Write(p)
+	s.wmu.Unlock()
+	if err != nil {
+		// Resuming resends p.
+		if err := s.resume(st, err); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *resumableStream) CloseWrite() error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.sentEOF = true
+	return s.wcur.CloseWrite()
+}
+
+// Close ends the upload and lets the server finish the stream, reading
+// what is left in the background for up to resumeGrace.
+func (s *resumableStream) Close() error {
+	s.mu.Lock()
+	if s.dead != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.dead = net.ErrClosed
+	st := s.cur
+	s.mu.Unlock()
+	s.CloseWrite()
+	go func() {
+		timer := time.AfterFunc(resumeGrace, func() { st.Close() })
+		io.Copy(io.Discard, st)
+		timer.Stop()
+		st.Close()
+	}()
+	return nil
+}
+
+// resume replaces the failed stream st with a new one attached to the same
+// server-side stream and resends the upload bytes the server missed. It
+// returns nil when st was already replaced.
+func (s *resumableStream) resume(st *Stream, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dead != nil {
+		return s.dead
+	}
+	if s.cur != st {
+		return nil
+	}
+	drop(st)
+	s.rmu.Lock()
+	received := s.received
+	s.rmu.Unlock()
+	s.wmu.Lock()
+	ended := s.sentEOF
+	s.wmu.Unlock()
+	if ended {
+		s.dead = cause
+		return cause
+	}
+
+	log.Printf("[Resume] Stream to %s dropped (%v), resuming", s.target, cause)
+	start := time.Now()
+	for {
+		next, acked, err := s.c.dial(s.proto, s.target, s.id+":"+strconv.FormatInt(received, 10))
+		if err == nil {
+			if err = s.resend(next, acked); err == nil {
+				s.cur = next
+				log.Printf("[Resume] Stream to %s resumed after %v", s.target, time.Since(start).Round(time.Millisecond))
+				return nil
+			}
+			drop(next)
+		}
+		if !errors.Is(err, ErrServerUnreachable) || time.Since(start) >= resumeGrace {
+			s.dead = fmt.Errorf("failed to resume stream to %s: %v", s.target, err)
+			log.Printf("[Resume] %v", s.dead)
+			return s.dead
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// resend makes st the stream writes go to and writes it the upload bytes
+// after the first acked. The bytes are written in the background, with
+// writes held back until they are out: the server may be resending too,
+// and waits for the caller to read.
+func (s *resumableStream) resend(st *Stream, acked string) error {
+	off, err := strconv.ParseInt(acked, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid resume offset %q", acked)
+	}
+	s.wmu.Lock()
+	data, ok := s.sent.since(off)
+	if !ok {
+		s.wmu.Unlock()
+		return fmt.Errorf("server is at byte %d, %d bytes behind the last %d kept", off, s.sent.end-off, len(s.sent.buf))
+	}
+	s.wcur = st
+	go func() {
+		defer s.wmu.Unlock()
+		if _, err := st.Write(data); err != nil {
+			drop(st) // Fails the next read or write, which resumes again
+		}
+	}()
+	return nil
+}
+
+// drop abandons st without ending the upload, which the server would take
+// for a close.
+func drop(st *Stream) {
+	st.pw.CloseWithError(errStreamDropped)
+	st.Close()
+}
+
+// resumeTable holds the server halves of resumable streams by id.
+type resumeTable struct {
+	mu       sync.Mutex
+	sessions map[string]*resumeSession
+}
+
+func newResumeTable() *resumeTable {
+	return &resumeTable{sessions: make(map[string]*resumeSession)}
+}
+
+// resumeSession is the server half of a resumable stream: the target
+// connection and the tunnel stream attached to it, if any.
+type resumeSession struct {
+	id, user, target string
+	dest             io.ReadWriteCloser
+	table            *resumeTable
+
+	mu     sync.Mutex
+	link   *resumeLink // nil while detached
+	gen    int         // Counts claims; only the latest may attach
+	in     int64       // Upload bytes taken for dest
+	upEOF  bool
+	timer  *time.Timer // Ends a detached session
+	closed bool
+	ended  chan struct{}
+
+	dmu sync.Mutex // Orders the uploads of successive links
+
+	wmu      sync.Mutex   // Serializes downloads with resending
+	attached *sync.Cond   // Signals a new wlink; uses wmu
+	wlink    *resumeLink  // Link downloads go to
+	out      resendBuffer // Download bytes
+	downEOF  bool
+	eof      chan struct{} // Closed when dest has nothing more to send
+}
+
+// resumeLink is a tunnel stream attached to a session.
+type resumeLink struct {
+	rw      io.ReadWriteCloser
+	rc      *http.ResponseController
+	gen     int
+	failed  chan struct{}
+	once    sync.Once
+	expired func() bool // The stream hit a [streams] limit
+}
+
+func newResumeLink(stream io.ReadWriteCloser, w http.ResponseWriter) *resumeLink {
+	return &resumeLink{
+		rw:     stream,
+		rc:     http.NewResponseController(w),
+		failed: make(chan struct{}),
+		expired: func() bool {
+			g, ok := stream.(*streamGuard)
+			return ok && g.expired.Load()
+		},
+	}
+}
+
+// fail unblocks the link's pending reads and writes.
+func (l *resumeLink) fail() {
+	l.once.Do(func() {
+		now := time.Now()
+		l.rc.SetReadDeadline(now)
+		l.rc.SetWriteDeadline(now)
+		l.rw.Close()
+		close(l.failed)
+	})
+}
+
+func (l *resumeLink) isFailed() bool {
+	select {
+	case <-l.failed:
+		return true
+	default:
+		return false
+	}
+}
+
+// start dials target for a new resumable stream and serves it on link.
+func (t *resumeTable) start(id, user string, link *resumeLink, target string, dialer outbound.Dialer, report bool) error {
+	dest, err := dialTarget(link.rw, target, dialer, report)
+	if err != nil {
+		return err
+	}
+	s := &resumeSession{id: id, user: user, target: target, dest: dest, table: t, ended: make(chan struct{}), eof: make(chan struct{})}
+	s.attached = sync.NewCond(&s.wmu)
+	t.mu.Lock()
+	if _, ok := t.sessions[id]; ok {
+		t.mu.Unlock()
+		dest.Close()
+		return fmt.Errorf("duplicate resumable stream id")
+	}
+	t.sessions[id] = s
+	t.mu.Unlock()
+	go s.download()
+	return s.serve(link, 0, 0)
+}
+
+// claim takes the session id of user from its current link for a resumed
+// stream and returns it with the upload bytes it has received, or nil when
+// no such session is waiting.
+func (t *resumeTable) claim(id, user string) (s *resumeSession, gen int, in int64) {
+	t.mu.Lock()
+	s = t.sessions[id]
+	t.mu.Unlock()
+	if s == nil || s.user != user {
+		return nil, 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, 0, 0
+	}
+	// From here the old link's upload drops what it reads.
+	s.gen++
+	if s.link != nil {
+		s.link.fail()
+	}
+	return s, s.gen, s.in
+}
+
+// serve attaches link to the session, after resending the download bytes
+// after the first from, and relays until the link fails or the stream
+// ends. Only the latest claim, gen, may attach.
+func (s *resumeSession) serve(link *resumeLink, from int64, gen int) error {
+	s.mu.Lock()
+	if s.closed || gen != s.gen {
+		s.mu.Unlock()
+		return fmt.Errorf("resumable stream to %s superseded", s.target)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	link.gen = gen
+	s.link = link
+	s.mu.Unlock()
+	go s.upload(link)
+
+	s.wmu.Lock()
+	data, ok := s.out.since(from)
+	if !ok {
+		s.wmu.Unlock()
+		s.end()
+		return fmt.Errorf("resumable stream to %s: client is %d bytes behind the last %d kept", s.target, s.out.end-from, len(s.out.buf))
+	}
+	if len(data) > 0 {
+		if _, err := link.rw.Write(data); err != nil {
+			link.fail()
+		}
+	}
+	s.wlink = link
+	s.attached.Broadcast()
+	s.wmu.Unlock()
+	if gen > 0 {
+		log.Printf("[Resume] Stream to %s resumed", s.target)
+	}
+
+	select {
+	case <-link.failed:
+	case <-s.eof:
+	case <-s.ended:
+		return nil
+	}
+
+	s.wmu.Lock()
+	delivered := s.downEOF && s.wlink == link && !link.isFailed()
+	s.wmu.Unlock()
+	if delivered {
+		s.end() // The handler returns and the client reads EOF
+		return nil
+	}
+	s.detach(link)
+	return nil
+}
+
+// detach leaves the session waiting resumeGrace for the client to resume
+// it, unless the client closed it or the link hit a stream limit.
+func (s *resumeSession) detach(link *resumeLink) {
+	link.fail()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.link != link || s.closed {
+		return // Claimed by a resumed stream
+	}
+	s.link = nil
+	if s.upEOF || link.expired() {
+		go s.end()
+		return
+	}
+	log.Printf("[Resume] Stream to %s dropped, waiting %v for the client", s.target, resumeGrace)
+	s.timer = time.AfterFunc(resumeGrace, func() {
+		log.Printf("[Resume] Stream to %s not resumed, closing", s.target)
+		s.end()
+	})
+}
+
+// upload copies link to dest until the link fails or is claimed away.
+func (s *resumeSession) upload(link *resumeLink) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := link.rw.Read(buf)
+		if n > 0 && !s.take(link, buf[:n]) {
+			return
+		}
+		if err == io.EOF {
+			s.mu.Lock()
+			s.upEOF = true
+			gone := s.link == nil && link.gen == s.gen
+			s.mu.Unlock()
+			if gone {
+				s.end() // Detached already: the client closed the stream
+				return
+			}
+			if cw, ok := s.dest.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+			return
+		}
+		if err != nil {
+			link.fail()
+			return
+		}
+	}
+}
+
+// take writes p, read from link, to dest. Once the link has been claimed
+// by a resumed stream, whose client resends p, it drops p and returns
+// false.
+func (s *resumeSession) take(link *resumeLink, p []byte) bool {
+	s.dmu.Lock()
+	defer s.dmu.Unlock()
+	s.mu.Lock()
+	if link.gen != s.gen {
+		s.mu.Unlock()
+		return false
+	}
+	s.in += int64(len(p))
+	s.mu.Unlock()
+	if _, err := s.dest.Write(p); err != nil {
+		s.end()
+		return false
+	}
+	return true
+}
+
+// download copies dest to the attached link, keeping the bytes for
+// resending.
+func (s *resumeSession) download() {
+	buf := make([]byte, 32*1024)
+	for {
+		// Reading on while detached would lose bytes to the buffer limit;
+		// the target waits instead.
+		s.wmu.Lock()
+		for (s.wlink == nil || s.wlink.isFailed()) && !s.isEnded() {
+			s.attached.Wait()
+		}
+		s.wmu.Unlock()
+		if s.isEnded() {
+			return
+		}
+		n, err := s.dest.Read(buf)
+		s.wmu.Lock()
+		if n > 0 {
+			s.out.add(buf[:n])
+			if l := s.wlink; !l.isFailed() {
+				if _, werr := l.rw.Write(buf[:n]); werr != nil {
+					l.fail()
+				}
+			}
+		}
+		if err != nil {
+			s.downEOF = true
+			close(s.eof)
+		}
+		s.wmu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *resumeSession) isEnded() bool {
+	select {
+	case <-s.ended:
+		return true
+	default:
+		return false
+	}
+}
+
+// end closes the target connection and forgets the session.
+func (s *resumeSession) end() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	close(s.ended)
+	s.dest.Close()
+	s.wmu.Lock()
+	s.attached.Broadcast()
+	s.wmu.Unlock()
+	s.table.mu.Lock()
+	delete(s.table.sessions, s.id)
+	s.table.mu.Unlock()
+}
@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResendBuffer(t *testing.T) {
+	var b resendBuffer
+	var all []byte
+	for i := 0; len(all) < 2*resumeBuffer+12345; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 100000+i)
+		b.add(chunk)
+		all = append(all, chunk...)
+	}
+	if len(b.buf) != resumeBuffer {
+		t.Fatalf("Expected %d bytes kept, got %d", resumeBuffer, len(b.buf))
+	}
+	for _, back := range []int{0, 1, 99999, resumeBuffer / 2, resumeBuffer} {
+		got, ok := b.since(int64(len(all) - back))
+		if !ok || !bytes.Equal(got, all[len(all)-back:]) {
+			t.Errorf("Expected the last %d bytes back, got %d (ok=%v)", back, len(got), ok)
+		}
+	}
+	if _, ok := b.since(int64(len(all) - resumeBuffer - 1)); ok {
+		t.Errorf("Expected bytes older than the buffer to be gone")
+	}
+	if _, ok := b.since(int64(len(all) + 1)); ok {
+		t.Errorf("Expected an offset past the end to fail")
+	}
+}
@@ -0,0 +1,307 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"phoenix/pkg/adapter/ssh"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/protocol"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// reverseLinger is how long the server keeps listening on an address
+	// after its client stopped waiting for connections, so a client that
+	// reconnects finds the connections that arrived meanwhile.
+	reverseLinger = 30 * time.Second
+
+	// reverseRetry is the pause before a listener waits again after the
+	// server dropped a wait, doubling up to maxReverseRetry while the
+	// server can't be reached.
+	reverseRetry    = time.Second
+	maxReverseRetry = 30 * time.Second
+)
+
+// errReverseInUse refuses an address another user listens on.
+var errReverseInUse = errors.New("address in use by another client")
+
+// Reverse forwarding: every Accept of a Client.Listen listener is a
+// ProtocolReverse stream with the address as its target, held open by
+// the server until a connection arrives there. The server then writes the
+// connection's remote address and a newline, and relays it over the
+// stream. The listening socket is shared by the waiting streams.
+
+// reverseTable holds the server's reverse listeners by address.
+type reverseTable struct {
+	mu  sync.Mutex
+	lns map[string]*reverseListener
+}
+
+// reverseListener is a socket the server listens on for a client.
+type reverseListener struct {
+	addr    string
+	owner   string // The user holding it
+	ln      net.Listener
+	conns   chan net.Conn // Accepted connections, handed to waiting streams
+	closed  chan struct{}
+	waiting int         // Streams waiting; under reverseTable.mu
+	idle    *time.Timer // Closes the listener once nobody waits; nil = active
+}
+
+// acquire returns the listener for addr, listening if nobody does yet.
+// release must be called once the stream stops waiting.
+func (t *reverseTable) acquire(addr, owner string) (*reverseListener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rl := t.lns[addr]
+	if rl == nil {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" {
+			log.Printf("[Reverse] Listening on %s for user %s", ln.Addr(), owner)
+		} else {
+			log.Printf("[Reverse] Listening on %s", ln.Addr())
+		}
+		rl = &reverseListener{addr: addr, owner: owner, ln: ln, conns: make(chan net.Conn), closed: make(chan struct{})}
+		if t.lns == nil {
+			t.lns = make(map[string]*reverseListener)
+		}
+		t.lns[addr] = rl
+		go rl.run()
+	} else if rl.owner != owner {
+		return nil, errReverseInUse
+	}
+	if rl.idle != nil {
+		rl.idle.Stop()
+		rl.idle = nil
+	}
+	rl.waiting++
+	return rl, nil
+}
+
+func (t *reverseTable) release(rl *reverseListener) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rl.waiting--; rl.waiting > 0 {
+		return
+	}
+	var idle *time.Timer
+	idle = time.AfterFunc(reverseLinger, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if rl.idle != idle {
+			return
+		}
+		log.Printf("[Reverse] Closing %s: no client waiting", rl.ln.Addr())
+		delete(t.lns, rl.addr)
+		close(rl.closed)
+		rl.ln.Close()
+	})
+	rl.idle = idle
+}
+
+// run accepts connections until the listener closes, each one as soon as
+// a stream waits for it.
+func (rl *reverseListener) run() {
+	for {
+		conn, err := rl.ln.Accept()
+		if err != nil {
+			select {
+			case <-rl.closed:
+			default:
+				log.Printf("[Reverse] Accept on %s failed: %v", rl.addr, err)
+			}
+			return
+		}
+		select {
+		case rl.conns <- conn:
+		case <-rl.closed:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// wait returns the next connection to the listener, or ctx.Err() when
+// the stream waiting for it ends first.
+func (rl *reverseListener) wait(ctx context.Context) (net.Conn, error) {
+	select {
+	case conn := <-rl.conns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// relayReverse announces conn on stream and relays it.
+func relayReverse(stream io.ReadWriteCloser, conn net.Conn) error {
+	if _, err := fmt.Fprintf(stream, "%s\n", conn.RemoteAddr()); err != nil {
+		conn.Close()
+		return err
+	}
+	return ssh.Relay(stream, conn)
+}
+
+// allowsReverse reports whether clients may listen on addr.
+func (s *Server) allowsReverse(addr string) bool {
+	return slices.Contains(s.Config.Security.ReverseListen, addr)
+}
+
+// Listen has the server listen on addr, one of its reverse_listen
+// addresses, and returns a listener of the connections arriving there, so
+// that servers from the standard library (http.Serve and the like) are
+// reachable through the Phoenix server. network must be "tcp". The first
+// wait is made before Listen returns, so a refused address fails here.
+// Connections don't support deadlines; their RemoteAddr is the peer's
+// address as the server saw it.
+func (c *Client) Listen(network, addr string) (net.Listener, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("listen %s: unsupported network %q", addr, network)
+	}
+	l := &tunnelListener{c: c, addr: addr, done: make(chan struct{})}
+	st, err := c.waitReverse(addr)
+	if err != nil {
+		return nil, err
+	}
+	l.next = st
+	return l, nil
+}
+
+// waitReverse opens a stream waiting for the next connection to addr.
+func (c *Client) waitReverse(addr string) (*Stream, error) {
+	st, _, err := c.dial(protocol.ProtocolReverse, addr, "")
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+	return st, nil
+}
+
+// tunnelListener is the net.Listener of Client.Listen.
+type tunnelListener struct {
+	c      *Client
+	addr   string
+	accept sync.Mutex // Serializes Accepts over the one waiting stream
+
+	mu     sync.Mutex
+	next   *Stream // The stream waiting for the next connection; nil while reopened
+	closed bool
+	done   chan struct{} // Closed by Close
+}
+
+func (l *tunnelListener) Accept() (net.Conn, error) {
+	l.accept.Lock()
+	defer l.accept.Unlock()
+	var retry time.Duration
+	for {
+		st, err := l.wait()
+		if err != nil {
+			return nil, err
+		}
+		if st == nil {
+			if retry > 0 && !l.sleep(retry) {
+				return nil, net.ErrClosed
+			}
+			st, err = l.c.waitReverse(l.addr)
+			switch {
+			case errors.Is(err, ErrServerUnreachable), errors.Is(err, ErrServerBusy):
+				retry = min(max(2*retry, reverseRetry), maxReverseRetry)
+				log.Printf("[Reverse] Waiting on %s failed, retrying in %v: %v", l.addr, retry, err)
+				continue
+			case err != nil:
+				return nil, err
+			}
+			l.mu.Lock()
+			if l.closed {
+				l.mu.Unlock()
+				st.Close()
+				return nil, net.ErrClosed
+			}
+			l.next = st
+			l.mu.Unlock()
+		}
+
+		remote, err := readReverseLine(st)
+		l.mu.Lock()
+		l.next = nil
+		closed := l.closed
+		l.mu.Unlock()
+		if err == nil {
+			return &streamConn{ReadWriteCloser: st, local: l.addr, remote: remote}, nil
+		}
+		st.Close()
+		if closed {
+			return nil, net.ErrClosed
+		}
+		// The server dropped the wait, e.g. on a stream idle timeout or a
+		// restart: wait again.
+		loglevel.Debugf("[Reverse] Wait on %s ended: %v", l.addr, err)
+		retry = max(retry, reverseRetry)
+	}
+}
+
+// wait returns the waiting stream, if any, or net.ErrClosed.
+func (l *tunnelListener) wait() (*Stream, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, net.ErrClosed
+	}
+	return l.next, nil
+}
+
+// sleep waits for d, reporting false if the listener or client closes
+// first.
+func (l *tunnelListener) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-l.done:
+	case <-l.c.ctx.Done():
+	}
+	return false
+}
+
+// readReverseLine reads the remote address the server announces a
+// connection with, byte by byte so no data after it is consumed.
+func readReverseLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 64 {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("malformed reverse connection header")
+}
+
+// Close stops waiting for connections; those accepted stay open. The
+// server stops listening reverseLinger later.
+func (l *tunnelListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	if l.next != nil {
+		l.next.Close()
+	}
+	return nil
+}
+
+func (l *tunnelListener) Addr() net.Addr { return chainAddr(l.addr) }
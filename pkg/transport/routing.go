@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"errors"
+	"log"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/process"
+	"sync"
+)
+
+// directHosts returns the matcher of routing.direct and
+// routing.bypass_private, or nil when every target is tunneled.
+func directHosts(cfg *config.ClientConfig) (*outbound.HostMatcher, error) {
+	hosts := cfg.Routing.DirectHosts()
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	return outbound.ParseHostMatcher(hosts)
+}
+
+// routesDirect reports whether target ("host:port") skips the tunnel.
+func (c *Client) routesDirect(target string) bool {
+	if c.direct == nil || target == "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(target)
+	return err == nil && c.direct.Match(host)
+}
+
+var processUnsupported sync.Once
+
+// processDirect reports whether source, a local application's end of an
+// inbound connection, belongs to a routing.direct process.
+func (c *Client) processDirect(source net.Addr) bool {
+	addr, ok := source.(*net.TCPAddr)
+	if c.directProcs == nil || !ok || !addr.IP.IsLoopback() {
+		return false
+	}
+	path, err := process.FindTCP(addr)
+	if errors.Is(err, process.ErrUnsupported) {
+		processUnsupported.Do(func() { log.Printf("[Routing] Ignoring process entries of routing.direct: %v", err) })
+		return false
+	}
+	if err != nil {
+		loglevel.Debugf("[Routing] No process for %s: %v", addr, err)
+		return false
+	}
+	if !c.directProcs.Match(path) {
+		return false
+	}
+	loglevel.Debugf("[Routing] Connection from %s (%s) goes direct", addr, path)
+	return true
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"runtime"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestProcessDirect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process lookup is tested on Linux")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatalf("No executable: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	for entry, want := range map[string]bool{"process:" + filepath.Base(self): true, "process:qbittorrent": false} {
+		c := NewClient(&config.ClientConfig{RemoteAddr: "127.0.0.1:1", Routing: config.ClientRouting{Direct: []string{entry}}})
+		if got := c.processDirect(conn.LocalAddr()); got != want {
+			t.Errorf("processDirect with %s = %v, want %v", entry, got, want)
+		}
+	}
+}
+
+func TestAdBlock(t *testing.T) {
+	a, err := newAdBlock(config.ClientAdBlock{Hosts: []string{"ads.example", "full:pixel.example"}, Allow: []string{"ok.ads.example"}})
+	if err != nil {
+		t.Fatalf("Failed to parse adblock: %v", err)
+	}
+	c := &Client{Config: &config.ClientConfig{}, adblock: a}
+	d := &tunnelDialer{client: c, adblock: true}
+	if _, err := d.Dial("x.ads.example:443"); !errors.Is(err, outbound.ErrNotAllowed) {
+		t.Errorf("Expected x.ads.example to be blocked, got %v", err)
+	}
+	if d.AllowUDP("pixel.example:443") || !d.AllowUDP("www.pixel.example:443") || !d.AllowUDP("ok.ads.example:443") {
+		t.Errorf("Wrong UDP blocking")
+	}
+
+	query := func(name string) []byte {
+		msg := dnsmessage.Message{Header: dnsmessage.Header{ID: 7, RecursionDesired: true}, Questions: []dnsmessage.Question{{
+			Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET,
+		}}}
+		b, _ := msg.Pack()
+		return b
+	}
+	resp := d.AnswerUDP("1.1.1.1:53", query("www.ads.example."))
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil || msg.ID != 7 || msg.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Expected NXDOMAIN for a blocked name, got %+v, %v", msg.Header, err)
+	}
+	if d.AnswerUDP("1.1.1.1:53", query("ok.ads.example.")) != nil || d.AnswerUDP("1.1.1.1:443", query("ads.example.")) != nil {
+		t.Errorf("Expected allowed names and other ports to be relayed")
+	}
+	skip := &tunnelDialer{client: c}
+	if skip.AnswerUDP("1.1.1.1:53", query("ads.example.")) != nil {
+		t.Errorf("Expected skip_adblock inbounds to be relayed")
+	}
+}
+
+func TestRoutingOutbound(t *testing.T) {
+	// An HTTP CONNECT proxy that answers with the target it was asked for.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\n\r\n%s", req.Host)
+			}()
+		}
+	}()
+
+	c := NewClient(&config.ClientConfig{RemoteAddr: "127.0.0.1:1", Routing: config.ClientRouting{
+		Rules:     []config.RoutingRule{{Hosts: []string{"intranet.example"}, Action: "outbound", Outbound: "corp"}},
+		Outbounds: []config.RoutingOutbound{{Tag: "corp", URL: "http://" + ln.Addr().String()}},
+	}})
+	defer c.Close()
+	d := &tunnelDialer{client: c}
+	conn, err := d.Dial("wiki.intranet.example:80")
+	if err != nil {
+		t.Fatalf("Failed to dial through the outbound: %v", err)
+	}
+	defer conn.Close()
+	got, _ := io.ReadAll(conn)
+	if string(got) != "wiki.intranet.example:80" {
+		t.Errorf("Expected the proxy to be asked for the target, got %q", got)
+	}
+
+	tor := NewClient(&config.ClientConfig{RemoteAddr: "127.0.0.1:1", Routing: config.ClientRouting{
+		Rules:     []config.RoutingRule{{Hosts: []string{"private.example"}, Action: "outbound", Outbound: "privacy"}},
+		Outbounds: []config.RoutingOutbound{{Tag: "privacy", URL: "tor://"}},
+	}})
+	defer tor.Close()
+	d = &tunnelDialer{client: tor}
+	if d.AllowUDP("private.example:443") || !d.AllowUDP("public.example:443") {
+		t.Errorf("Expected UDP to be dropped for Tor routes only")
+	}
+}
@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"strconv"
+	"time"
+)
+
+// schedule is the parsed schedule of a rule.
+type schedule struct {
+	windows []config.Window // Empty = always
+	loc     *time.Location
+}
+
+func newSchedule(schedules []config.Schedule, loc *time.Location) (schedule, error) {
+	s := schedule{loc: loc}
+	for _, cs := range schedules {
+		w, err := cs.Window()
+		if err != nil {
+			return schedule{}, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// active reports whether the rule applies at t.
+func (s schedule) active(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	t = t.In(s.loc)
+	for _, w := range s.windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetMatch matches targets ("host:port") by host and port; nil lists
+// match any.
+type targetMatch struct {
+	hosts *outbound.HostMatcher
+	ports *outbound.PortSet
+}
+
+func newTargetMatch(hosts, ports []string) (targetMatch, error) {
+	var m targetMatch
+	var err error
+	if len(hosts) > 0 {
+		if m.hosts, err = outbound.ParseHostMatcher(hosts); err != nil {
+			return targetMatch{}, err
+		}
+	}
+	if len(ports) > 0 {
+		if m.ports, err = outbound.ParsePortSet(ports); err != nil {
+			return targetMatch{}, err
+		}
+	}
+	return m, nil
+}
+
+func (m targetMatch) matches(target string) bool {
+	host, p, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	if m.hosts != nil && !m.hosts.Match(host) {
+		return false
+	}
+	if m.ports != nil {
+		port, err := strconv.Atoi(p)
+		return err == nil && m.ports.Contains(port)
+	}
+	return true
+}
+
+// routingRule is a parsed config.RoutingRule.
+type routingRule struct {
+	targetMatch
+	action   string
+	block    string
+	outbound string // Tag of the routing.outbounds entry of action "outbound"
+	when     schedule
+}
+
+// newRoutingRules parses routing.rules, evaluated in cfg's timezone.
+func newRoutingRules(cfg *config.ClientConfig) ([]routingRule, error) {
+	if len(cfg.Routing.Rules) == 0 {
+		return nil, nil
+	}
+	loc, err := config.LoadTimezone(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	var rules []routingRule
+	for _, r := range cfg.Routing.Rules {
+		m, err := newTargetMatch(r.Hosts, r.Ports)
+		if err != nil {
+			return nil, err
+		}
+		when, err := newSchedule(r.Schedule, loc)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, routingRule{targetMatch: m, action: r.Action, block: r.Block, outbound: r.Outbound, when: when})
+	}
+	return rules, nil
+}
+
+// rule returns the first routing rule that matches target and is in
+// schedule now, nil for none.
+func (c *Client) rule(target string) *routingRule {
+	if target == "" {
+		return nil
+	}
+	now := time.Now()
+	for i, r := range c.rules {
+		if r.matches(target) && r.when.active(now) {
+			return &c.rules[i]
+		}
+	}
+	return nil
+}
+
+// ruleAction returns the action of target's routing rule, "" for none.
+func (c *Client) ruleAction(target string) string {
+	if r := c.rule(target); r != nil {
+		return r.action
+	}
+	return ""
+}
@@ -1,8 +1,8 @@
 package transport
 
 import (
+	"context"
 	"crypto/ed25519"
-	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -10,12 +10,23 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"phoenix/pkg/adapter/socks5"
 	"phoenix/pkg/adapter/ssh"
 	"phoenix/pkg/config"
 	"phoenix/pkg/crypto"
+	"phoenix/pkg/dns"
+	"phoenix/pkg/ipfix"
+	"phoenix/pkg/loglevel"
+	"phoenix/pkg/outbound"
 	"phoenix/pkg/protocol"
+	"phoenix/pkg/userdb"
+	"phoenix/pkg/version"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -24,38 +35,218 @@ import (
 
 // Server handles incoming H2C connections and routes them to the appropriate protocol handler.
 type Server struct {
-	Config *config.ServerConfig
+	Config  *config.ServerConfig
+	profile *wireProfile
+	dialer  outbound.Dialer // Connects to stream targets (see outbound_proxy)
+	users   *userTable      // nil when no user table is configured
+	blocked *outbound.PortSet
+	acl     []*aclRule
+	dns     *dns.Resolver // DNS fast path; nil when disabled
+	flood   *floodGuard
+	load    *overloadGuard
+	backend *backend              // [fallback] web server; nil when unset
+	proxies *outbound.HostMatcher // trusted_proxies; nil trusts none
+	resumes *resumeTable          // FeatureResume streams by id
+	flows   *flowExporter         // [flow_export]; nil when disabled
+	reverse *reverseTable         // Listeners of Client.Listen
+	nonces  *nonceCache           // Metadata frames seen within the clock skew
+	auth    Authenticator         // ServerOptions.Authenticator; nil = the configured credentials
+	targets TargetDialer          // ServerOptions.TargetDialer; nil = dialer
+	records Recorder              // ServerOptions.Recorder; nil records nothing
 }
 
 // NewServer creates a new H2C server instance.
 func NewServer(cfg *config.ServerConfig) *Server {
-	return &Server{Config: cfg}
+	return &Server{Config: cfg, profile: newWireProfile(cfg.HTTP, ""), dialer: outbound.Direct{}, blocked: &outbound.PortSet{}, flood: newFloodGuard(cfg.Limits), load: newOverloadGuard(cfg.Limits), resumes: newResumeTable(), reverse: &reverseTable{}, nonces: newNonceCache()}
+}
+
+// newOutboundDialer resolves cfg.OutboundProxy. A "phoenix" URL names a
+// client config file for a second Phoenix server that targets are reached
+// through; other schemes are handled by the outbound package.
+func newOutboundDialer(cfg *config.ServerConfig) (outbound.Dialer, error) {
+	if err := outboundSocket(cfg).Check(); err != nil {
+		return nil, err
+	}
+	if cfg.OutboundProxy == "" {
+		return newEgress(cfg)
+	}
+	u, err := url.Parse(cfg.OutboundProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outbound_proxy: %v", err)
+	}
+	if u.Scheme != "phoenix" {
+		return outbound.Parse(cfg.OutboundProxy)
+	}
+
+	upstreamCfg, err := config.LoadClientConfig(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upstream config: %v", err)
+	}
+	return &PhoenixDialer{Client: NewClient(upstreamCfg)}, nil
+}
+
+// outboundSocket returns the socket options of cfg's direct dials and UDP
+// relay sessions.
+func outboundSocket(cfg *config.ServerConfig) outbound.Socket {
+	return outbound.Socket{Interface: cfg.OutboundInterface, Mark: cfg.FWMark}
+}
+
+// newEgress builds the direct dialer, binding source addresses per
+// cfg.Egress.
+func newEgress(server *config.ServerConfig) (outbound.Dialer, error) {
+	socket := outboundSocket(server)
+	direct := outbound.Direct{Family: server.Dial.Family, AttemptDelay: server.Dial.AttemptDelay, Timeout: server.Dial.Timeout, Socket: socket}
+	cfg := server.Egress
+	if len(cfg.Bind) == 0 && len(cfg.Rules) == 0 {
+		return direct, nil
+	}
+	e := &outbound.Egress{Direct: direct}
+	if len(cfg.Bind) > 0 {
+		pool, err := outbound.ParsePool(cfg.Bind)
+		if err != nil {
+			return nil, err
+		}
+		pool.Timeout, pool.Socket = server.Dial.Timeout, socket
+		e.Default = pool
+	}
+	for i, r := range cfg.Rules {
+		hosts, err := outbound.ParseHostMatcher(r.Targets)
+		if err != nil {
+			return nil, fmt.Errorf("egress rule %d: %v", i, err)
+		}
+		pool, err := outbound.ParsePool(r.Bind)
+		if err != nil {
+			return nil, fmt.Errorf("egress rule %d: %v", i, err)
+		}
+		pool.Timeout, pool.Socket = server.Dial.Timeout, socket
+		e.Rules = append(e.Rules, outbound.EgressRule{Hosts: hosts, Pool: pool})
+	}
+	return e, nil
+}
+
+// udpBindAddr returns the local address for a UDP session's socket: the next
+// egress address of the user or the server, or any address.
+func (s *Server) udpBindAddr(u *user) string {
+	if u != nil {
+		if acl, ok := u.dialer.(*aclDialer); ok {
+			if pool, ok := acl.next.(*outbound.Pool); ok {
+				return net.JoinHostPort(pool.Next().String(), "0")
+			}
+		}
+	}
+	if e, ok := s.dialer.(*outbound.Egress); ok && e.Default != nil {
+		return net.JoinHostPort(e.Default.Next().String(), "0")
+	}
+	return ":0"
+}
+
+// PhoenixDialer reaches targets through another Phoenix server. The upstream
+// must have enable_socks5 set.
+type PhoenixDialer struct {
+	Client *Client
+}
+
+func (d *PhoenixDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	return d.Client.Dial(protocol.ProtocolSOCKS5, target)
 }
 
 // ServeHTTP implements the http.Handler interface.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	// Logs and per-client limits see the client, not the CDN edge. The
+	// request is the server's own copy, so rewriting it is fine.
+	r.RemoteAddr = realRemoteAddr(r, s.proxies)
+	if !s.flood.allowStream(r) {
 		return
 	}
+	release, ok := s.flood.acquireClient(r.RemoteAddr)
+	if !ok {
+		http.Error(w, "Too Many Streams", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+	releaseLoad, _, ok := s.load.acquire()
+	if !ok {
+		w.Header().Set("Retry-After", busyRetryAfter)
+		http.Error(w, "Server Busy", http.StatusServiceUnavailable)
+		return
+	}
+	defer releaseLoad()
 
-	// Token Authentication
-	if s.Config.Security.AuthToken != "" {
-		token := r.Header.Get("X-Nerve-Token")
-		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.Security.AuthToken)) != 1 {
-			log.Printf("Rejected unauthorized connection from %s", r.RemoteAddr)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	wp := s.profile
+	if !wp.acceptsPath(r.URL.Path) {
+		s.notTunnel(w, r, http.StatusNotFound, "404 page not found")
+		return
+	}
+	if r.Method != wp.method {
+		s.notTunnel(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
 	}
 
-	proto := r.Header.Get("X-Nerve-Protocol")
+	offered := protocol.ParseFeatures(r.Header.Get(wp.hFeatures))
+	meta, err := s.readStreamMeta(r, offered)
+	if err != nil {
+		log.Printf("Rejected stream from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	proto := meta.Protocol
 	if proto == "" {
-		http.Error(w, "Missing Protocol Header", http.StatusBadRequest)
+		s.notTunnel(w, r, http.StatusBadRequest, "Missing Protocol Header")
+		return
+	}
+
+	// Token / user authentication
+	clientVersion := r.Header.Get(wp.hVersion)
+	u, err := s.authenticate(r, meta, clientVersion)
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		log.Printf("Rejected unauthorized connection from %s", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Printf("[Auth] Authenticating %s failed: %v", r.RemoteAddr, err)
+		w.Header().Set("Retry-After", busyRetryAfter)
+		http.Error(w, "Server Busy", http.StatusServiceUnavailable)
+		return
+	}
+	next := s.dialer
+	switch {
+	case s.targets != nil:
+		meta := DialMeta{Remote: r.RemoteAddr, Protocol: protocol.ProtocolType(proto)}
+		if u != nil {
+			meta.User = u.name
+		}
+		next = &streamDialer{dialer: s.targets, ctx: r.Context(), timeout: s.Config.Dial.Timeout, meta: meta}
+		if u != nil {
+			next = &aclDialer{user: u, next: next}
+		}
+	case u != nil:
+		next = u.dialer
+	}
+	if dc := s.Config.Dial; dc.Retries > 0 {
+		delay := dc.RetryDelay
+		if delay == 0 {
+			delay = 500 * time.Millisecond
+		}
+		next = &outbound.Retry{Next: next, Retries: dc.Retries, Delay: delay}
+	}
+	var userName string
+	if u != nil {
+		userName = u.name
+	}
+	var dialer outbound.Dialer = &outbound.PortFilter{Blocked: s.blocked, Next: next}
+	if s.acl != nil {
+		dialer = &ruleDialer{server: s, user: userName, next: dialer}
+	}
+
+	if !s.clientVersionAllowed(clientVersion) {
+		log.Printf("Rejected outdated client %s (version %q, minimum %s)", r.RemoteAddr, clientVersion, s.Config.MinClientVersion)
+		http.Error(w, "Client Upgrade Required", http.StatusUpgradeRequired)
 		return
 	}
 
-	target := r.Header.Get("X-Nerve-Target")
+	target := meta.Target
 
 	allowed := false
 	switch protocol.ProtocolType(proto) {
@@ -67,6 +258,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		allowed = s.Config.Security.EnableShadowsocks
 	case protocol.ProtocolSSH:
 		allowed = s.Config.Security.EnableSSH
+	case protocol.ProtocolReverse:
+		allowed = s.allowsReverse(target)
+	case protocolFeedback:
+		allowed = !protocol.HasFeature(s.Config.DisabledFeatures, FeatureFeedback)
+	case protocolNotices:
+		allowed = !protocol.HasFeature(s.Config.DisabledFeatures, FeatureNotices)
 	default:
 		log.Printf("Unknown protocol requested: %s", proto)
 	}
@@ -76,6 +273,49 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Protocol Disabled by Server", http.StatusForbidden)
 		return
 	}
+	if u != nil && !u.allowsProtocol(protocol.ProtocolType(proto)) {
+		log.Printf("Blocked request for protocol %s from user %s (%s)", proto, u.name, r.RemoteAddr)
+		http.Error(w, "Protocol Not Allowed", http.StatusForbidden)
+		return
+	}
+	// A reverse stream's target is where the server listens, not a destination.
+	reverse := protocol.ProtocolType(proto) == protocol.ProtocolReverse
+	if !reverse && target != "" && s.blocked.Blocks(target) {
+		log.Printf("Blocked destination %s from %s: port blocked by policy", target, r.RemoteAddr)
+		http.Error(w, "Destination Port Blocked", http.StatusForbidden)
+		return
+	}
+	if !reverse && u != nil && target != "" && !u.allowsTarget(target) {
+		log.Printf("Blocked destination %s for user %s (%s)", target, u.name, r.RemoteAddr)
+		http.Error(w, "Destination Not Allowed", http.StatusForbidden)
+		return
+	}
+	// Rules that answer for the target do so from ruleDialer.
+	if rule := s.aclBlock(userName, target); !reverse && rule != nil && outbound.BlockRefuses(target, rule.block) {
+		log.Printf("Blocked destination %s from %s: acl", target, r.RemoteAddr)
+		http.Error(w, "Destination Not Allowed", http.StatusForbidden)
+		return
+	}
+
+	var q *quota
+	if u != nil && !controlProtocol(protocol.ProtocolType(proto)) {
+		q = u.quota
+	}
+	if q != nil && q.refuses() {
+		log.Printf("Blocked stream from user %s (%s): quota exceeded", u.name, r.RemoteAddr)
+		http.Error(w, "Quota Exceeded", http.StatusForbidden)
+		return
+	}
+
+	var rl *reverseListener
+	if reverse {
+		if rl, err = s.reverse.acquire(target, userName); err != nil {
+			log.Printf("[Reverse] Refused to listen on %s for %s: %v", target, r.RemoteAddr, err)
+			http.Error(w, "Cannot Listen", http.StatusConflict)
+			return
+		}
+		defer s.reverse.release(rl)
+	}
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -83,44 +323,155 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Accepted stream for protocol %s from %s (Target: %s)", proto, r.RemoteAddr, target)
+	// Version/feature negotiation: answer with our protocol version and the
+	// subset of the client's offered features we accept for this stream.
+	features := protocol.Negotiate(offered, s.Config.DisabledFeatures)
+	w.Header().Set(wp.hProtoVer, strconv.Itoa(protocol.Version))
+	if len(features) > 0 {
+		w.Header().Set(wp.hFeatures, protocol.FormatFeatures(features))
+	}
+
+	// A resumed stream takes over the target connection of a dropped one.
+	resumeID, resumeFrom, resumed := parseResume(r.Header.Get(wp.hResume))
+	if target == "" || !protocol.HasFeature(features, FeatureResume) {
+		resumeID = ""
+	}
+	var session *resumeSession
+	var claim int
+	if resumeID != "" && resumed {
+		var received int64
+		session, claim, received = s.resumes.claim(resumeID, userName)
+		if session == nil {
+			log.Printf("[Resume] No stream %s to resume for %s (Target: %s)", resumeID, r.RemoteAddr, target)
+			http.Error(w, "Stream Expired", http.StatusGone)
+			return
+		}
+		w.Header().Set(wp.hResume, strconv.FormatInt(received, 10))
+	}
+
+	if u != nil {
+		log.Printf("Accepted stream for protocol %s from %s (Target: %s, Client: %s, User: %s)", proto, r.RemoteAddr, target, clientVersion, u.name)
+	} else {
+		log.Printf("Accepted stream for protocol %s from %s (Target: %s, Client: %s)", proto, r.RemoteAddr, target, clientVersion)
+	}
 	w.WriteHeader(http.StatusOK)
 	flusher.Flush()
+	accepted := time.Now()
 
 	// Wrap the request body and response writer into a ReadWriteCloser-like interface
-	stream := &H2Stream{
+	cl, _ := r.Context().Value(connKey{}).(*connLimits)
+	var writer io.Writer = w
+	if cl != nil && protocol.HasFeature(features, FeaturePriority) {
+		writer = cl.prio.writer(w, parsePriority(meta.Priority))
+	}
+	var stream io.ReadWriteCloser = &H2Stream{
 		Reader:  r.Body,
-		Writer:  w,
+		Writer:  writer,
 		Flusher: flusher,
 	}
+	lc := serverConns.add(Connection{User: userName, Remote: r.RemoteAddr, Protocol: proto, Target: target}, targetKeyOf(s.Config.TargetStats, userName, target))
+	if target != "" && s.flows != nil {
+		lc.mu.Lock()
+		lc.flows = s.flows
+		lc.mu.Unlock()
+	}
+	defer func() {
+		serverConns.remove(lc)
+		if lc.target != nil {
+			targets.add(*lc.target, lc.up.Load(), lc.down.Load())
+		}
+		if target != "" && s.flows != nil {
+			s.flows.report(lc, ipfix.ReasonEnd)
+		}
+	}()
+	stream = &countedStream{ReadWriteCloser: stream, lc: lc}
+	if s.records != nil && u != nil && u.cfg.Record != "" && !controlProtocol(protocol.ProtocolType(proto)) {
+		if rec := s.records.RecordStream(lc.snapshot(accepted), u.cfg.Record == "content"); rec != nil {
+			if u.cfg.Record == "content" {
+				stream = &recordedStream{ReadWriteCloser: stream, rec: rec}
+			}
+			defer func() { rec.End(lc.snapshot(time.Now())) }()
+		}
+	}
+	if q != nil {
+		stream = &quotaStream{ReadWriteCloser: stream, q: q}
+	}
+	if rule := s.aclFor(userName, target); rule != nil && rule.throttle > 0 {
+		stream = &throttledStream{ReadWriteCloser: stream, rule: rule, pace: rule.pace(userName)}
+	}
+	// A reverse stream idles until its connection arrives; the stream
+	// limits apply from then on.
+	var conn net.Conn
+	if rl != nil {
+		if conn, err = rl.wait(r.Context()); err != nil {
+			return
+		}
+	}
+	limits := s.Config.Streams
+	stream, stop := guardStream(stream, w, limits.IdleTimeout, limits.MaxLifetime, fmt.Sprintf("%s stream from %s (Target: %s)", proto, r.RemoteAddr, target))
+	defer stop()
 
-	var err error
 	// If target is provided in header, we assume the handshake is already done (e.g. at client side)
 	// and we just need to tunnel to the target.
-	if target != "" {
-		err = ssh.HandleConnection(stream, target)
-	} else {
+	switch {
+	case conn != nil:
+		err = relayReverse(stream, conn)
+	case session != nil:
+		err = session.serve(newResumeLink(stream, w), resumeFrom, claim)
+	case resumeID != "":
+		err = s.resumes.start(resumeID, userName, newResumeLink(stream, w), target, dialer, protocol.HasFeature(features, FeatureDialStatus))
+	case target != "":
+		err = relayTarget(stream, target, dialer, protocol.HasFeature(features, FeatureDialStatus))
+	default:
 		switch protocol.ProtocolType(proto) {
 		case protocol.ProtocolSOCKS5:
 			// Server handles SOCKS5 handshake
-			err = socks5.HandleConnection(stream, &socks5.NetDialer{}, s.Config.Security.EnableUDP)
+			err = socks5.HandleConnection(stream, dialer, socks5.Options{
+				EnableUDP: s.Config.Security.EnableUDP,
+				UDP:       UDPLimits(s.Config.UDP),
+			})
 		case protocol.ProtocolSOCKS5UDP:
 			// Server handles SOCKS5 UDP Tunnel
 			if !s.Config.Security.EnableUDP {
 				http.Error(w, "UDP Disabled", http.StatusForbidden)
 				return
 			}
-			err = socks5.HandleUDPTunnel(stream)
+			opts := socks5.UDPTunnelOptions{
+				LocalAddr: s.udpBindAddr(u),
+				Control:   outboundSocket(s.Config).Control(),
+				NAT:       s.Config.UDP.NAT,
+				Limits:    UDPLimits(s.Config.UDP),
+				Allow: func(dest string) bool {
+					return !s.blocked.Blocks(dest) && (u == nil || u.allowsTarget(dest)) && !s.aclBlocks(userName, dest)
+				},
+			}
+			if family := s.Config.Dial.Family; family != "" && family != outbound.FamilyAuto {
+				opts.Resolve = func(dest string) (*net.UDPAddr, error) {
+					return outbound.ResolveUDP(dest, family)
+				}
+			}
+			if s.dns != nil {
+				opts.DNS = s.dns.Exchange
+			}
+			err = socks5.HandleUDPTunnel(stream, opts)
 		case protocol.ProtocolShadowsocks:
 			// SS is decrypted on client side; server gets target in header.
 			// If no target, we can't do anything.
 			err = fmt.Errorf("shadowsocks requires target address")
+		case protocolFeedback:
+			var conn net.Conn
+			if cl != nil {
+				conn = cl.conn
+			}
+			err = serveFeedback(stream, conn)
+		case protocolNotices:
+			err = serveNotices(stream, userName, s.Config.Notices, s.Config.Hints)
 		case protocol.ProtocolSSH:
 			// No target provided, impossible for tunnel unless Server is destination
 			// or we implement SSH handshake parsing.
 			// Revert to default handling or error?
 			// For now, assume SSH forwarding always comes with target or Client is "Smart".
-			err = ssh.HandleConnection(stream, "")
+			err = ssh.HandleConnection(stream, "", dialer)
 		default:
 			_, err = io.Copy(stream, stream)
 		}
@@ -129,6 +480,52 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil && err != io.EOF {
 		log.Printf("Stream error: %v", err)
 	}
+	loglevel.Debugf("[Stream] %s stream from %s (Target: %s) ended after %v", proto, r.RemoteAddr, target, time.Since(accepted).Round(time.Millisecond))
+}
+
+// notTunnel answers a request that isn't a tunnel stream: the backend
+// serves it when [fallback] is set, otherwise it fails with status.
+func (s *Server) notTunnel(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if s.backend != nil {
+		s.backend.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// readStreamMeta returns the stream's protocol, target and token. Clients
+// using FeatureMetadataFrame omit the protocol header and send the metadata
+// encrypted at the start of the body; everyone else uses plaintext headers.
+func (s *Server) readStreamMeta(r *http.Request, offered []protocol.Feature) (streamMeta, error) {
+	wp := s.profile
+	if r.Header.Get(wp.hProtocol) != "" || !protocol.HasFeature(offered, FeatureMetadataFrame) {
+		return streamMeta{
+			Protocol: r.Header.Get(wp.hProtocol),
+			Target:   r.Header.Get(wp.hTarget),
+			Token:    r.Header.Get(wp.hToken),
+			Priority: r.Header.Get(wp.hPriority),
+		}, nil
+	}
+
+	if protocol.HasFeature(s.Config.DisabledFeatures, FeatureMetadataFrame) {
+		return streamMeta{}, fmt.Errorf("metadata frames are disabled")
+	}
+	secret := metaSecret(s.Config.HTTP.Secret, s.Config.Security.AuthToken)
+	if secret == "" {
+		return streamMeta{}, fmt.Errorf("metadata frame received but no http.secret or auth_token is configured")
+	}
+	return readMetaFrame(r.Body, secret, s.nonces)
+}
+
+// clientVersionAllowed enforces MinClientVersion. Local "dev" builds are let
+// through so that development clients keep working; other versions that
+// don't parse are rejected.
+func (s *Server) clientVersionAllowed(v string) bool {
+	if s.Config.MinClientVersion == "" || v == "dev" {
+		return true
+	}
+	cmp, ok := version.Compare(v, s.Config.MinClientVersion)
+	return ok && cmp >= 0
 }
 
 // H2Stream adapts request/response to ReadWriteCloser
@@ -155,130 +552,366 @@ func (s *H2Stream) Close() error {
 	return nil
 }
 
+// redactURL hides the password in a proxy URL for logging.
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
+
 // logServerSecurityMode prints the server's security status at startup.
 func logServerSecurityMode(cfg *config.ServerConfig) {
-	// Auth mode
+	log.Printf("Security Mode: %s", securityMode(cfg.Security.AuthToken, cfg.Security.AuthorizedClientKeys, cfg.Security.PrivateKeyPath))
+}
+
+// securityMode describes how an endpoint authenticates clients.
+func securityMode(authToken string, authorizedKeys []string, privateKey string) string {
 	switch {
-	case cfg.Security.AuthToken != "" && len(cfg.Security.AuthorizedClientKeys) > 0:
-		log.Printf("Security Mode: mTLS (Ed25519) + Token Auth ENABLED")
-	case cfg.Security.AuthToken != "":
-		log.Printf("Security Mode: Token Auth ENABLED (h2c or TLS depending on private_key)")
-	case len(cfg.Security.AuthorizedClientKeys) > 0:
-		log.Printf("Security Mode: mTLS (Ed25519) — %d authorized clients", len(cfg.Security.AuthorizedClientKeys))
-	case cfg.Security.PrivateKeyPath != "":
-		log.Printf("Security Mode: ONE-WAY TLS (Ed25519) — no client auth")
+	case authToken != "" && len(authorizedKeys) > 0:
+		return "mTLS (Ed25519) + Token Auth ENABLED"
+	case authToken != "":
+		return "Token Auth ENABLED (h2c or TLS depending on private_key)"
+	case len(authorizedKeys) > 0:
+		return fmt.Sprintf("mTLS (Ed25519) — %d authorized clients", len(authorizedKeys))
+	case privateKey != "":
+		return "ONE-WAY TLS (Ed25519) — no client auth"
 	default:
-		log.Printf("Security Mode: OPEN — No authentication configured!")
+		return "OPEN — No authentication configured!"
 	}
 }
 
-// StartServer starts the H2C/H2 Server.
+// StartServer binds cfg.ListenAddr and starts the H2C/H2 Server.
 func StartServer(cfg *config.ServerConfig) error {
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", cfg.ListenAddr, err)
+	}
+	return Serve(cfg, ln)
+}
+
+// Serve runs the H2C/H2 Server on an already bound TCP listener. Callers that
+// need to act once the port is bound (readiness notification, socket
+// activation) create the listener themselves and pass it here.
+func Serve(cfg *config.ServerConfig, ln net.Listener) error {
+	return ServeWith(cfg, ln, ServerOptions{})
+}
+
+// ServerOptions are the parts of a server that embedders replace with
+// their own implementations.
+type ServerOptions struct {
+	// Authenticator checks the credentials of every stream instead of the
+	// auth_token, users and user_db of the config.
+	Authenticator Authenticator
+
+	// TargetDialer connects to the targets of streams instead of the
+	// direct dials, egress and outbound_proxy of the config.
+	TargetDialer TargetDialer
+
+	// Recorder receives the streams of users whose record setting
+	// consents to it. nil (the default) records nothing.
+	Recorder Recorder
+
+	// Ready, if set, is called once keys, TLS and users are loaded and the
+	// server is about to accept connections, with a check that it still
+	// serves (for liveness probes such as the systemd watchdog).
+	Ready func(healthy func() error)
+}
+
+// ServeWith is Serve with the given options.
+func ServeWith(cfg *config.ServerConfig, ln net.Listener, opts ServerOptions) error {
 	srv := NewServer(cfg)
+	srv.auth, srv.targets, srv.records = opts.Authenticator, opts.TargetDialer, opts.Recorder
+	dialer, err := newOutboundDialer(cfg)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	srv.dialer = dialer
+	if srv.blocked, err = outbound.ParsePortSet(cfg.BlockedPorts); err != nil {
+		ln.Close()
+		return fmt.Errorf("invalid blocked_ports: %v", err)
+	}
+	if srv.acl, err = newACL(cfg); err != nil {
+		ln.Close()
+		return err
+	}
+	runGeoData(context.Background(), cfg.GeoData, nil)
+	setMemoryLimit(cfg.Limits.MemoryLimit)
+	if srv.flows, err = newFlowExporter(cfg.FlowExport); err != nil {
+		ln.Close()
+		return fmt.Errorf("invalid flow_export: %v", err)
+	}
+	if srv.flows != nil {
+		log.Printf("Exporting flows to IPFIX collector %s", cfg.FlowExport.Collector)
+	}
+	switch {
+	case cfg.UserDB != "":
+		db, err := userdb.Open(cfg.UserDB)
+		if err == nil {
+			srv.users, err = newUserTable(cfg, dialer, nil)
+		}
+		if err == nil {
+			err = srv.users.watch(db)
+		}
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		log.Printf("User table: %d users in %s", srv.users.len(), cfg.UserDB)
+	case len(cfg.Users) > 0:
+		if srv.users, err = newUserTable(cfg, dialer, cfg.Users); err != nil {
+			ln.Close()
+			return err
+		}
+		log.Printf("User table: %d users", len(cfg.Users))
+	}
+	if srv.auth != nil {
+		// Holds the users the Authenticator returns.
+		if srv.users == nil {
+			srv.users, _ = newUserTable(cfg, dialer, nil)
+		}
+		log.Printf("[Auth] Streams authenticated by %T", srv.auth)
+	}
+	if srv.records != nil {
+		log.Printf("[Record] Recording the streams of consenting users with %T", srv.records)
+	}
+	if cfg.DNS.FastPath {
+		if srv.dns, err = newDNSResolver(cfg.DNS); err != nil {
+			ln.Close()
+			return err
+		}
+	}
 
 	// Log security status
 	logServerSecurityMode(cfg)
+	switch {
+	case srv.targets != nil:
+		log.Printf("Outbound: dialing targets with %T (UDP is sent directly)", srv.targets)
+	case cfg.OutboundProxy != "":
+		log.Printf("Outbound: dialing targets via %s (UDP is sent directly)", redactURL(cfg.OutboundProxy))
+	}
 
-	// Check if Private Key is configured for TLS
-	if cfg.Security.PrivateKeyPath != "" {
-		// Load Private Key
-		priv, err := crypto.LoadPrivateKey(cfg.Security.PrivateKeyPath)
+	if len(cfg.TrustedProxies) > 0 {
+		proxies, err := outbound.ParseHostMatcher(cfg.TrustedProxies)
 		if err != nil {
-			return fmt.Errorf("failed to load private key: %v", err)
+			ln.Close()
+			return err
 		}
+		srv.proxies = proxies
+		log.Printf("Trusting client addresses forwarded by %s", strings.Join(cfg.TrustedProxies, ", "))
+	}
+
+	if fb := cfg.Fallback; fb.Backend != "" || fb.Root != "" {
+		srv.backend = newBackend(fb)
+		log.Printf("[Fallback] Serving other requests from %s%s", fb.Backend, fb.Root)
+	}
 
-		// Generate Self-Signed Certificate
-		cert, err := crypto.GenerateTLSCertificate(priv)
+	tlsConfig, err := endpointTLS(cfg, srv.users)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	var handler http.Handler = srv
+	if len(cfg.VHosts) > 0 {
+		router, err := newVHostRouter(srv)
 		if err != nil {
-			return fmt.Errorf("failed to generate TLS certificate: %v", err)
+			ln.Close()
+			return err
 		}
-
-		// Determine Authorized Public Keys
-		authorizedKeys := make(map[string]bool)
-		for _, k := range cfg.Security.AuthorizedClientKeys {
-			authorizedKeys[k] = true
+		handler = router
+		if tlsConfig != nil {
+			tlsConfig.GetConfigForClient = router.tlsConfig
 		}
+	}
 
-		var clientAuth tls.ClientAuthType
-		var verifyPeer func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
-
-		if len(authorizedKeys) > 0 {
-			log.Printf("Starting server in SECURE mode (mTLS) with %d authorized clients", len(authorizedKeys))
-			clientAuth = tls.RequireAnyClientCert
-			verifyPeer = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-				if len(rawCerts) == 0 {
-					return errors.New("no client certificate provided")
-				}
-				// Parse leaf certificate
-				leaf, err := x509.ParseCertificate(rawCerts[0])
-				if err != nil {
-					return fmt.Errorf("failed to parse client certificate: %v", err)
-				}
-
-				// Verify Public Key
-				pub := leaf.PublicKey
-				pubBytes, ok := pub.(ed25519.PublicKey)
-				if !ok {
-					// Also support other keys if needed, but we default to Ed25519
-					return errors.New("unsupported public key type (expected Ed25519)")
-				}
-
-				pubStr := base64.StdEncoding.EncodeToString(pubBytes)
-				if !authorizedKeys[pubStr] {
-					return fmt.Errorf("unauthorized client key: %s", pubStr)
-				}
-
-				return nil
-			}
+	if tlsConfig != nil {
+		if tlsConfig.ClientAuth == tls.RequireAnyClientCert {
+			log.Printf("Starting server in SECURE mode (mTLS) with %d authorized clients", len(authorizedKeys(cfg.Security.AuthorizedClientKeys, cfg.Users)))
 		} else {
 			log.Println("Starting server in ONE-WAY TLS mode (No Client Auth)")
-			clientAuth = tls.NoClientCert
-			verifyPeer = nil
-		}
-
-		// Configure TLS
-		tlsConfig := &tls.Config{
-			Certificates:          []tls.Certificate{cert},
-			ClientAuth:            clientAuth,
-			NextProtos:            []string{"h2"},
-			VerifyPeerCertificate: verifyPeer,
 		}
 
-		ln, err := tls.Listen("tcp", cfg.ListenAddr, tlsConfig)
-		if err != nil {
-			return fmt.Errorf("failed to listen on %s: %v", cfg.ListenAddr, err)
+		inner := srv.flood.listener(ln)
+		if srv.backend != nil && srv.backend.addr != "" {
+			// A directory is served by s below, HTTP/1.1 included.
+			inner = newSniffListener(inner, srv.backend, tlsConfig)
 		}
+		tlsLn := tls.NewListener(inner, tlsConfig)
 
 		// Standard HTTP server for TLS (Go handles H2 automatically)
 		s := &http.Server{
-			Handler:      srv, // Direct handler, no h2c
+			Handler:      handler, // Direct handler, no h2c
 			ReadTimeout:  0,
 			WriteTimeout: 0,
 			IdleTimeout:  0,
+			ConnContext:  srv.flood.connContext,
+			HTTP2: &http.HTTP2Config{
+				MaxConcurrentStreams:          srv.flood.cfg.MaxStreamsPerConn,
+				MaxReceiveBufferPerStream:     srv.flood.cfg.StreamBuffer,
+				MaxReceiveBufferPerConnection: srv.flood.cfg.ConnBuffer,
+			},
 		}
 
-		log.Printf("Listening on %s (TLS)", cfg.ListenAddr)
-		return s.Serve(ln)
+		log.Printf("Listening on %s (TLS)", ln.Addr())
+		return serveReady(s, tlsLn, opts.Ready)
 
 	} else {
 		log.Println("Starting server in INSECURE mode (h2c)")
 		// Fallback to H2C (Cleartext)
 		h2s := &http2.Server{
-			MaxConcurrentStreams: 500,         // Increase concurrency
-			MaxReadFrameSize:     1024 * 1024, // 1MB frames if possible
-			IdleTimeout:          10 * time.Second,
+			MaxConcurrentStreams:         uint32(srv.flood.cfg.MaxStreamsPerConn),
+			MaxReadFrameSize:             1024 * 1024, // 1MB frames if possible
+			MaxUploadBufferPerStream:     int32(srv.flood.cfg.StreamBuffer),
+			MaxUploadBufferPerConnection: int32(srv.flood.cfg.ConnBuffer),
+			IdleTimeout:                  10 * time.Second,
 		}
-		handler := h2c.NewHandler(srv, h2s)
 
 		s := &http.Server{
-			Addr:         cfg.ListenAddr,
-			Handler:      handler,
+			Handler:      h2c.NewHandler(handler, h2s),
 			ReadTimeout:  0, // Disable read timeout for streaming
 			WriteTimeout: 0, // Disable write timeout for streaming
 			IdleTimeout:  0, // Disable idle timeout
+			ConnContext:  srv.flood.connContext,
+		}
+
+		log.Printf("Listening on %s", ln.Addr())
+		return serveReady(s, srv.flood.listener(ln), opts.Ready)
+	}
+}
+
+// serveReady runs s on ln, telling ready first. The health check fails
+// once s has stopped serving.
+func serveReady(s *http.Server, ln net.Listener, ready func(healthy func() error)) error {
+	var serving atomic.Bool
+	serving.Store(true)
+	if ready != nil {
+		ready(func() error {
+			if !serving.Load() {
+				return errors.New("server stopped serving")
+			}
+			return nil
+		})
+	}
+	defer serving.Store(false)
+	return s.Serve(ln)
+}
+
+// authorizedKeys returns the client keys accepted over mTLS: the
+// authorized_clients list plus every user's public key.
+func authorizedKeys(clients []string, users []config.User) map[string]bool {
+	keys := make(map[string]bool)
+	for _, k := range clients {
+		keys[k] = true
+	}
+	for _, u := range users {
+		if u.PublicKey != "" {
+			keys[u.PublicKey] = true
+		}
+	}
+	return keys
+}
+
+// endpointTLS returns the TLS settings of cfg's endpoint: a self-signed
+// certificate from its private key and, when client keys are authorized,
+// mTLS. Keys of users are checked against users at each handshake, so
+// users added later are accepted, as long as mTLS is on, and disabled or
+// deleted ones are refused. It returns nil for h2c.
+func endpointTLS(cfg *config.ServerConfig, users *userTable) (*tls.Config, error) {
+	if cfg.Security.PrivateKeyPath == "" {
+		return nil, nil
+	}
+
+	// Load Private Key
+	priv, err := crypto.LoadPrivateKey(cfg.Security.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key: %v", err)
+	}
+
+	// Generate Self-Signed Certificate
+	cert, err := crypto.GenerateTLSCertificate(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TLS certificate: %v", err)
+	}
+
+	// Determine Authorized Public Keys; the user table holds cfg.Users.
+	authorized := authorizedKeys(cfg.Security.AuthorizedClientKeys, nil)
+	if users == nil {
+		authorized = authorizedKeys(cfg.Security.AuthorizedClientKeys, cfg.Users)
+	}
+
+	var clientAuth tls.ClientAuthType
+	var verifyPeer func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	if len(authorized) > 0 || users != nil && len(users.keys()) > 0 {
+		clientAuth = tls.RequireAnyClientCert
+		verifyPeer = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no client certificate provided")
+			}
+			// Parse leaf certificate
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return fmt.Errorf("failed to parse client certificate: %v", err)
+			}
+
+			// Verify Public Key
+			pub := leaf.PublicKey
+			pubBytes, ok := pub.(ed25519.PublicKey)
+			if !ok {
+				// Also support other keys if needed, but we default to Ed25519
+				return errors.New("unsupported public key type (expected Ed25519)")
+			}
+
+			pubStr := base64.StdEncoding.EncodeToString(pubBytes)
+			if !authorized[pubStr] && (users == nil || !users.hasKey(pubStr)) {
+				return fmt.Errorf("unauthorized client key: %s", pubStr)
+			}
+
+			return nil
 		}
+	} else {
+		clientAuth = tls.NoClientCert
+	}
+
+	// Configure TLS
+	return &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		ClientAuth:            clientAuth,
+		NextProtos:            []string{"h2"},
+		VerifyPeerCertificate: verifyPeer,
+	}, nil
+}
 
-		log.Printf("Listening on %s", cfg.ListenAddr)
-		return s.ListenAndServe()
+// UDPLimits converts a [udp] config section to relay limits.
+func UDPLimits(cfg config.UDPConfig) socks5.UDPLimits {
+	return socks5.UDPLimits{
+		IdleTimeout:   cfg.IdleTimeout,
+		MaxSessions:   cfg.MaxSessions,
+		MaxPeers:      cfg.MaxPeers,
+		MaxPacketSize: cfg.MaxPacketSize,
+		QueueDepth:    cfg.QueueDepth,
+		SocketBuffer:  cfg.SocketBuffer,
+	}
+}
+
+// newDNSResolver builds the DNS fast-path resolver from cfg.
+func newDNSResolver(cfg config.ServerDNS) (*dns.Resolver, error) {
+	size, timeout := cfg.CacheSize, cfg.Timeout
+	if size == 0 {
+		size = 4096
+	}
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+	upstreams, err := dnsUpstreams(cfg.Upstreams, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	to := "the queried resolvers"
+	if len(cfg.Upstreams) > 0 {
+		to = strings.Join(cfg.Upstreams, ", ")
 	}
+	log.Printf("DNS fast path: forwarding to %s (cache %d)", to, max(size, 0))
+	return dns.NewResolver(upstreams, size, timeout), nil
 }
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"testing"
+)
+
+func TestClientVersionAllowed(t *testing.T) {
+	s := &Server{Config: &config.ServerConfig{MinClientVersion: "v1.2.0"}}
+	for _, tc := range []struct {
+		version string
+		want    bool
+	}{
+		{"v1.2.0", true},
+		{"v1.10.3", true},
+		{"v1.1.9", false},
+		{"", false},
+		{"dev", true},
+		{"garbage", false},
+	} {
+		if got := s.clientVersionAllowed(tc.version); got != tc.want {
+			t.Errorf("clientVersionAllowed(%q) = %v, want %v", tc.version, got, tc.want)
+		}
+	}
+	if open := (&Server{Config: &config.ServerConfig{}}); !open.clientVersionAllowed("garbage") {
+		t.Errorf("Expected any version without min_client_version")
+	}
+}
+
+// TestServeReady checks that Ready waits for the keys to load, so a bad
+// key fails Serve without it, and that its check fails once Serve stops.
+func TestServeReady(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.key")
+	os.WriteFile(bad, []byte("not a key"), 0600)
+	cfg := config.DefaultServerConfig()
+	cfg.Security.PrivateKeyPath = bad
+	called := false
+	err := ServeWith(cfg, NewPipeListener("bad"), ServerOptions{Ready: func(func() error) { called = true }})
+	if err == nil || called {
+		t.Errorf("Expected a bad key to fail Serve before Ready, got %v (Ready called: %v)", err, called)
+	}
+
+	key, _ := keyFile(t, dir, "server.key")
+	cfg = config.DefaultServerConfig()
+	cfg.Security.PrivateKeyPath = key
+	ln := NewPipeListener("good")
+	ready := make(chan func() error, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeWith(cfg, ln, ServerOptions{Ready: func(healthy func() error) { ready <- healthy }})
+	}()
+	healthy := <-ready
+	if err := healthy(); err != nil {
+		t.Errorf("Expected a serving server to be healthy, got %v", err)
+	}
+	ln.Close()
+	<-done
+	if healthy() == nil {
+		t.Errorf("Expected the check to fail once Serve returned")
+	}
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"net"
+	"phoenix/pkg/config"
+	"sync"
+	"time"
+)
+
+// Defaults for config.SOCKS5Limits.
+const (
+	defaultSOCKSHandshakeTimeout = 10 * time.Second
+	defaultSOCKSMaxHandshakes    = 128
+	defaultSOCKSMaxPerSource     = 256
+)
+
+// socksGate applies socks5 limits to the client's SOCKS5 inbounds. Accept
+// loops take a handshake slot before accepting, so at most maxHandshakes
+// handshakes (and their goroutines) are in flight; a slot is returned when
+// the request has been read or the connection fails.
+type socksGate struct {
+	slots        chan struct{}
+	timeout      time.Duration
+	maxPerSource int
+
+	mu      sync.Mutex
+	sources map[string]int // Open connections by non-loopback source IP
+}
+
+func newSOCKSGate(cfg config.SOCKS5Limits) *socksGate {
+	g := &socksGate{
+		timeout:      cfg.HandshakeTimeout,
+		maxPerSource: cfg.MaxConnsPerSource,
+		sources:      make(map[string]int),
+	}
+	if g.timeout <= 0 {
+		g.timeout = defaultSOCKSHandshakeTimeout
+	}
+	if g.maxPerSource <= 0 {
+		g.maxPerSource = defaultSOCKSMaxPerSource
+	}
+	n := cfg.MaxHandshakes
+	if n <= 0 {
+		n = defaultSOCKSMaxHandshakes
+	}
+	g.slots = make(chan struct{}, n)
+	return g
+}
+
+// slot waits for a free handshake slot and returns the func that frees it,
+// which may be called more than once.
+func (g *socksGate) slot() func() {
+	g.slots <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-g.slots }) }
+}
+
+// admit counts a connection from addr against its source's limit. It
+// returns false when the source is at the limit; otherwise call release
+// when the connection ends.
+func (g *socksGate) admit(addr net.Addr) (release func(), ok bool) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return func() {}, true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return func() {}, true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sources[host] >= g.maxPerSource {
+		return nil, false
+	}
+	g.sources[host]++
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.sources[host]--; g.sources[host] <= 0 {
+			delete(g.sources, host)
+		}
+	}, true
+}
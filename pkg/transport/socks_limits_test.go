@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"phoenix/pkg/adapter/socks5"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"testing"
+	"time"
+)
+
+// sourceConn is a connection from a chosen source address.
+type sourceConn struct {
+	net.Conn
+	source net.Addr
+}
+
+func (c sourceConn) RemoteAddr() net.Addr { return c.source }
+
+// chanListener accepts the connections sent on it.
+type chanListener chan net.Conn
+
+func (l chanListener) Accept() (net.Conn, error) {
+	c, ok := <-l
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l chanListener) Close() error   { return nil }
+func (l chanListener) Addr() net.Addr { return pipeAddr("socks.test") }
+
+// TestSOCKSConnsPerSource checks that a LAN source over max_conns_per_source
+// is refused while other sources, and loopback, are served.
+func TestSOCKSConnsPerSource(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+	client.socks = newSOCKSGate(config.SOCKS5Limits{MaxConnsPerSource: 1})
+	ln := make(chanListener)
+	defer close(ln)
+	go client.serveInbound(config.ClientInbound{Protocol: protocol.ProtocolSOCKS5, LocalAddr: "0.0.0.0:1080"}, ln, nil)
+
+	connect := func(source string) net.Conn {
+		local, remote := net.Pipe()
+		t.Cleanup(func() { local.Close() })
+		addr, _ := net.ResolveTCPAddr("tcp", source)
+		ln <- sourceConn{Conn: remote, source: addr}
+		return local
+	}
+	// greet reports whether the inbound reads the SOCKS5 greeting; a
+	// refused connection is closed unread.
+	greet := func(conn net.Conn) bool {
+		_, err := conn.Write([]byte{0x05, 0x01, 0x00})
+		return err == nil
+	}
+
+	first := connect("192.0.2.1:40000")
+	if !greet(first) {
+		t.Fatalf("Expected the first connection of a source to be served")
+	}
+	if greet(connect("192.0.2.1:40001")) {
+		t.Errorf("Expected a second connection of the same source to be refused")
+	}
+	for _, source := range []string{"127.0.0.1:40000", "127.0.0.1:40001"} {
+		if !greet(connect(source)) {
+			t.Errorf("Expected loopback %s to be exempt", source)
+		}
+	}
+
+	// Another source still gets through to the target.
+	other := connect("192.0.2.2:40000")
+	tcp, _ := net.ResolveTCPAddr("tcp", target)
+	req := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01}
+	req = append(req, tcp.IP.To4()...)
+	req = append(req, byte(tcp.Port>>8), byte(tcp.Port))
+	go other.Write(req)
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(other, reply); err != nil || reply[3] != socks5.ReplySucceeded {
+		t.Fatalf("Expected another source's CONNECT to succeed, got %v %v", reply, err)
+	}
+	go other.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(other, echo); err != nil || string(echo) != "ping" {
+		t.Errorf("Expected the echo for another source, got %q, %v", echo, err)
+	}
+
+	// Closing the first connection frees its source's slot.
+	first.Close()
+	for i := 0; ; i++ {
+		if greet(connect("192.0.2.1:40002")) {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Expected the source to be served again once its connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamGuard enforces [streams] idle_timeout and max_lifetime on a server
+// stream. Every read or write counts as activity. On expiry the stream's
+// HTTP/2 deadlines are set in the past, which fails pending reads from the
+// client and resets the stream if a write is stuck on flow control, so the
+// handler and its target connection unwind.
+type streamGuard struct {
+	io.ReadWriteCloser
+	rc         *http.ResponseController
+	lastActive atomic.Int64 // unix nanoseconds
+	expired    atomic.Bool
+	done       chan struct{}
+	once       sync.Once
+}
+
+// guardStream wraps stream when a limit is set; stop must be called when the
+// handler returns.
+func guardStream(stream io.ReadWriteCloser, w http.ResponseWriter, idle, lifetime time.Duration, desc string) (io.ReadWriteCloser, func()) {
+	if idle <= 0 && lifetime <= 0 {
+		return stream, func() {}
+	}
+	g := &streamGuard{ReadWriteCloser: stream, rc: http.NewResponseController(w), done: make(chan struct{})}
+	g.touch()
+	go g.watch(idle, lifetime, desc)
+	return g, func() { g.once.Do(func() { close(g.done) }) }
+}
+
+func (g *streamGuard) Read(p []byte) (int, error) {
+	n, err := g.ReadWriteCloser.Read(p)
+	if n > 0 {
+		g.touch()
+	}
+	return n, err
+}
+
+func (g *streamGuard) Write(p []byte) (int, error) {
+	n, err := g.ReadWriteCloser.Write(p)
+	if n > 0 {
+		g.touch()
+	}
+	return n, err
+}
+
+func (g *streamGuard) touch() {
+	g.lastActive.Store(time.Now().UnixNano())
+}
+
+func (g *streamGuard) watch(idle, lifetime time.Duration, desc string) {
+	var expired <-chan time.Time
+	if lifetime > 0 {
+		t := time.NewTimer(lifetime)
+		defer t.Stop()
+		expired = t.C
+	}
+	var tick <-chan time.Time
+	if idle > 0 {
+		t := time.NewTicker(max(idle/4, time.Second))
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-expired:
+			log.Printf("[Stream] Closing %s: max lifetime %v reached", desc, lifetime)
+			g.abort()
+			return
+		case <-tick:
+			if since := time.Since(time.Unix(0, g.lastActive.Load())); since >= idle {
+				log.Printf("[Stream] Closing %s: idle for %v", desc, since.Round(time.Second))
+				g.abort()
+				return
+			}
+		}
+	}
+}
+
+func (g *streamGuard) abort() {
+	g.expired.Store(true)
+	now := time.Now()
+	g.rc.SetReadDeadline(now)
+	g.rc.SetWriteDeadline(now)
+	g.ReadWriteCloser.Close()
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"io"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func TestPipeStreamLimits(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	tests := []struct {
+		name           string
+		streams        config.StreamConfig
+		active         bool // keep the stream busy
+		minAge, maxAge time.Duration
+	}{
+		// The idle check ticks once a second at the earliest.
+		{"idle", config.StreamConfig{IdleTimeout: 200 * time.Millisecond}, false, 200 * time.Millisecond, 3 * time.Second},
+		// The server's clock starts before Dial returns.
+		{"max lifetime", config.StreamConfig{MaxLifetime: 300 * time.Millisecond}, true, 200 * time.Millisecond, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			serverCfg := config.DefaultServerConfig()
+			serverCfg.Security.EnableSOCKS5 = true
+			serverCfg.Streams = tt.streams
+			client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80"}, pipeServer(t, serverCfg))
+			stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer stream.Close()
+			start := time.Now()
+
+			ended := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, stream)
+				close(ended)
+			}()
+			var tick <-chan time.Time
+			if tt.active {
+				ticker := time.NewTicker(50 * time.Millisecond)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			timeout := time.After(tt.maxAge)
+			for {
+				select {
+				case <-tick:
+					stream.Write([]byte("ping"))
+					continue
+				case <-ended:
+					if age := time.Since(start); age < tt.minAge {
+						t.Errorf("Expected the stream to last at least %v, ended after %v", tt.minAge, age)
+					}
+				case <-timeout:
+					t.Errorf("Expected the server to close the stream within %v", tt.maxAge)
+				}
+				return
+			}
+		})
+	}
+}
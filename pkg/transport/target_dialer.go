@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"phoenix/pkg/protocol"
+	"time"
+)
+
+// targetDialTimeout bounds a TargetDialer's dial without [dial] timeout.
+const targetDialTimeout = 10 * time.Second
+
+// TargetDialer connects the server to the targets of streams, in place of
+// the direct dials, egress addresses and outbound_proxy of the config, so
+// embedders can pick routes of their own (per-tenant VRFs, SD-WAN paths).
+// The blocked ports, users' ACLs, acl rules and [dial] retries still apply
+// in front of it; UDP relay sessions are sent directly.
+//
+// ctx ends when the stream's request does or after [dial] timeout; it
+// bounds the dial, not the connection. DialTarget is called from many
+// goroutines at once.
+type TargetDialer interface {
+	DialTarget(ctx context.Context, target string, meta DialMeta) (net.Conn, error)
+}
+
+// DialMeta describes the stream a target is dialed for.
+type DialMeta struct {
+	User     string // The user the stream runs as; "" for server-wide credentials
+	Remote   string // The client's address
+	Protocol protocol.ProtocolType
+}
+
+// streamDialer adapts a TargetDialer to one stream.
+type streamDialer struct {
+	dialer  TargetDialer
+	ctx     context.Context
+	timeout time.Duration
+	meta    DialMeta
+}
+
+func (d *streamDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	timeout := d.timeout
+	if timeout <= 0 {
+		timeout = targetDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, timeout)
+	defer cancel()
+	conn, err := d.dialer.DialTarget(ctx, target, d.meta)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
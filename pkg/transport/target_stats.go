@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"phoenix/pkg/config"
+	"sort"
+	"sync"
+)
+
+const (
+	// maxTargetStats bounds the hosts kept; the traffic of further ones
+	// is counted under otherTargets.
+	maxTargetStats = 10000
+
+	otherTargets = "(other)"
+)
+
+// TargetStat is the traffic of one user to one destination host. Up is the
+// client-to-target direction.
+type TargetStat struct {
+	User        string `json:"user,omitempty"`
+	Host        string `json:"host"`
+	Connections int64  `json:"connections"`
+	BytesUp     int64  `json:"bytes_up"`
+	BytesDn     int64  `json:"bytes_down"`
+}
+
+type targetKey struct {
+	user, host string
+}
+
+// targetTable holds the per-target statistics of every server in the
+// process, folding in streams as they end.
+type targetTable struct {
+	mu    sync.Mutex
+	stats map[targetKey]*TargetStat
+}
+
+var targets = &targetTable{stats: map[targetKey]*TargetStat{}}
+
+// hostKey keys HashHosts hashes; it changes with every process.
+var hostKey = func() []byte {
+	k := make([]byte, 32)
+	rand.Read(k)
+	return k
+}()
+
+// targetKeyOf returns the statistics key of a stream to target, nil when
+// cfg doesn't count it.
+func targetKeyOf(cfg config.TargetStats, user, target string) *targetKey {
+	if !cfg.Enabled || target == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if cfg.HashHosts {
+		mac := hmac.New(sha256.New, hostKey)
+		mac.Write([]byte(host))
+		host = hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	if cfg.HideUsers {
+		user = ""
+	}
+	return &targetKey{user: user, host: host}
+}
+
+// add folds an ended stream into the statistics.
+func (t *targetTable) add(key targetKey, up, down int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.stats[key]
+	if st == nil {
+		if len(t.stats) >= maxTargetStats {
+			key.host = otherTargets
+			st = t.stats[key]
+		}
+		if st == nil {
+			st = &TargetStat{User: key.user, Host: key.host}
+			t.stats[key] = st
+		}
+	}
+	st.Connections++
+	st.BytesUp += up
+	st.BytesDn += down
+}
+
+// TopTargets returns the n destination hosts (all with n <= 0) with the
+// most traffic, or the most connections, of user (all users when empty),
+// live streams included. It is empty unless [target_stats] is enabled.
+func TopTargets(user string, n int, byConnections bool) []TargetStat {
+	merged := map[targetKey]TargetStat{}
+	targets.mu.Lock()
+	for k, st := range targets.stats {
+		merged[k] = *st
+	}
+	targets.mu.Unlock()
+	serverConns.mu.Lock()
+	for _, lc := range serverConns.live {
+		if lc.target == nil {
+			continue
+		}
+		k := *lc.target
+		st := merged[k]
+		st.User, st.Host = k.user, k.host
+		st.Connections++
+		st.BytesUp += lc.up.Load()
+		st.BytesDn += lc.down.Load()
+		merged[k] = st
+	}
+	serverConns.mu.Unlock()
+
+	list := make([]TargetStat, 0, len(merged))
+	for _, st := range merged {
+		if user == "" || st.User == user {
+			list = append(list, st)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if byConnections && a.Connections != b.Connections {
+			return a.Connections > b.Connections
+		}
+		if a.BytesUp+a.BytesDn != b.BytesUp+b.BytesDn {
+			return a.BytesUp+a.BytesDn > b.BytesUp+b.BytesDn
+		}
+		return a.Host < b.Host
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
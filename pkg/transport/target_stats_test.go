@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"io"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"testing"
+)
+
+func TestTargetKey(t *testing.T) {
+	if k := targetKeyOf(config.TargetStats{}, "alice", "example.com:443"); k != nil {
+		t.Errorf("Expected no key with the statistics off, got %+v", k)
+	}
+	k := targetKeyOf(config.TargetStats{Enabled: true}, "alice", "example.com:443")
+	if k == nil || *k != (targetKey{user: "alice", host: "example.com"}) {
+		t.Errorf("Expected alice and example.com, got %+v", k)
+	}
+	k = targetKeyOf(config.TargetStats{Enabled: true, HideUsers: true, HashHosts: true}, "alice", "example.com:443")
+	if k == nil || k.user != "" || k.host == "example.com" || len(k.host) != 16 {
+		t.Errorf("Expected a hashed host without user, got %+v", k)
+	}
+	if other := targetKeyOf(config.TargetStats{Enabled: true, HashHosts: true}, "bob", "example.com:80"); other.host != k.host {
+		t.Errorf("Expected the same host to hash the same, got %s and %s", other.host, k.host)
+	}
+}
+
+func TestPipeTopTargets(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Users = []config.User{{Name: "targets-test", Token: "secret"}}
+	serverCfg.TargetStats.Enabled = true
+	client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: "secret"}, pipeServer(t, serverCfg))
+
+	var before TargetStat // -count runs share the table
+	if top := TopTargets("targets-test", 0, false); len(top) > 0 {
+		before = top[0]
+	}
+	for i := 0; i < 3; i++ {
+		stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		stream.Write([]byte("ping"))
+		io.ReadFull(stream, make([]byte, 4))
+		stream.Close()
+	}
+	top := TopTargets("targets-test", 0, true)
+	if len(top) != 1 || top[0].Host != "127.0.0.1" || top[0].Connections-before.Connections != 3 || top[0].BytesUp-before.BytesUp != 12 {
+		t.Errorf("Expected 3 connections with 12 bytes up to 127.0.0.1, got %+v", top)
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo returns the kernel's statistics of the TCP socket under c.
+func readTCPInfo(c net.Conn) (tcpStats, bool) {
+	sc, ok := socketOf(c)
+	if !ok {
+		return tcpStats{}, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return tcpStats{}, false
+	}
+	var info *unix.TCPInfo
+	raw.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || info == nil {
+		return tcpStats{}, false
+	}
+	return tcpStats{
+		rtt:     time.Duration(info.Rtt) * time.Microsecond,
+		segsOut: info.Data_segs_out,
+		retrans: info.Total_retrans,
+	}, true
+}
+
+// socketOf unwraps TLS and the tunnel's connection wrappers down to the
+// socket.
+func socketOf(c net.Conn) (syscall.Conn, bool) {
+	for {
+		switch v := c.(type) {
+		case *net.TCPConn:
+			return v, true
+		case interface{ NetConn() net.Conn }: // crypto/tls and uTLS
+			c = v.NetConn()
+		case *trackedConn:
+			c = v.Conn
+		case *replayConn:
+			c = v.Conn
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package transport
+
+import "net"
+
+// readTCPInfo returns the kernel's statistics of the TCP socket under c.
+// Only Linux (and Android) exposes them.
+func readTCPInfo(c net.Conn) (tcpStats, bool) {
+	return tcpStats{}, false
+}
@@ -0,0 +1,309 @@
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"phoenix/pkg/config"
+	"phoenix/pkg/dns"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/userdb"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lookups of domain targets checked against deny networks are cached, so
+// streams and UDP datagrams to a name don't each resolve it.
+const (
+	maxACLLookups = 4096
+	aclLookupTTL  = time.Minute
+)
+
+// maxResolvedUsers caps the users an Authenticator returned that the table
+// keeps; the least recently used go first.
+const maxResolvedUsers = 4096
+
+// user is a resolved entry of the server's user table.
+type user struct {
+	cfg       config.User // As configured or returned by an Authenticator
+	name      string
+	token     string
+	publicKey string
+	protocols []protocol.ProtocolType
+	allow     *outbound.HostMatcher // nil = any destination
+	deny      *outbound.HostMatcher
+	lookups   *dns.Lookup     // Resolves domain targets for deny
+	dialer    outbound.Dialer // User's egress, with the destination ACL applied
+	quota     *quota          // nil = unlimited
+	used      atomic.Int64    // Last resolve (Unix nanoseconds), for eviction
+}
+
+// userTable looks users up by token or mTLS client key. A user_db table is
+// replaced whenever the database changes.
+type userTable struct {
+	cfg     *config.ServerConfig
+	base    outbound.Dialer
+	lookups *dns.Lookup // Shared by the users' ACLs
+
+	mu     sync.RWMutex
+	users  []*user
+	byName map[string]*user
+	byKey  map[string]*user
+	quotas map[string]*quota // By user name; usage survives reloads and quota changes
+
+	resolved map[string]*user // Authenticator users by name, at most maxResolvedUsers
+}
+
+// newUserTable resolves users. base is the server-wide dialer; users
+// with their own egress addresses get a pool instead (unless an
+// outbound_proxy is set).
+func newUserTable(cfg *config.ServerConfig, base outbound.Dialer, users []config.User) (*userTable, error) {
+	t := &userTable{cfg: cfg, base: base, lookups: dns.NewLookup(nil, nil, maxACLLookups, aclLookupTTL, aclLookupTTL), byName: map[string]*user{}, byKey: map[string]*user{}, quotas: map[string]*quota{}, resolved: map[string]*user{}}
+	if err := t.set(users); err != nil {
+		return nil, err
+	}
+	if cfg.Quota.StateFile != "" {
+		keepQuotas(cfg.Quota.StateFile, t.quotaList)
+	}
+	return t, nil
+}
+
+// set replaces the users of the table.
+func (t *userTable) set(users []config.User) error {
+	var list []*user
+	byName, byKey := map[string]*user{}, map[string]*user{}
+	// Users that are gone keep their usage, should they come back.
+	t.mu.RLock()
+	quotas := maps.Clone(t.quotas)
+	t.mu.RUnlock()
+	for _, cu := range users {
+		u, err := t.build(cu, quotas)
+		if err != nil {
+			return err
+		}
+		list = append(list, u)
+		byName[u.name] = u
+		if u.publicKey != "" {
+			byKey[u.publicKey] = u
+		}
+	}
+	t.mu.Lock()
+	t.users, t.byName, t.byKey, t.quotas = list, byName, byKey, quotas
+	t.mu.Unlock()
+	return nil
+}
+
+// resolve returns the table's user for cu, which an Authenticator vouched
+// for: a configured user as is, otherwise a cached one, built anew when cu
+// differs from it.
+func (t *userTable) resolve(cu config.User) (*user, error) {
+	t.mu.RLock()
+	u := t.byName[cu.Name]
+	if u == nil || !reflect.DeepEqual(u.cfg, cu) {
+		u = t.resolved[cu.Name]
+	}
+	t.mu.RUnlock()
+	if u != nil && reflect.DeepEqual(u.cfg, cu) {
+		u.used.Store(time.Now().UnixNano())
+		return u, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u := t.resolved[cu.Name]; u != nil && reflect.DeepEqual(u.cfg, cu) {
+		u.used.Store(time.Now().UnixNano())
+		return u, nil
+	}
+	u, err := t.build(cu, t.quotas)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.resolved[cu.Name]; !ok && len(t.resolved) >= maxResolvedUsers {
+		t.evictLocked()
+	}
+	u.used.Store(time.Now().UnixNano())
+	t.resolved[u.name] = u
+	return u, nil
+}
+
+// evictLocked drops the least recently resolved Authenticator user. Its
+// quota stays, so eviction does not reset usage.
+func (t *userTable) evictLocked() {
+	var oldest *user
+	for _, u := range t.resolved {
+		if oldest == nil || u.used.Load() < oldest.used.Load() {
+			oldest = u
+		}
+	}
+	if oldest != nil {
+		delete(t.resolved, oldest.name)
+	}
+}
+
+// build resolves cu, taking its quota from quotas (by user name) while the
+// quota settings are unchanged and updating quotas otherwise.
+func (t *userTable) build(cu config.User, quotas map[string]*quota) (*user, error) {
+	cfg := t.cfg
+	u := &user{cfg: cu, name: cu.Name, token: cu.Token, publicKey: cu.PublicKey, protocols: cu.Protocols, lookups: t.lookups}
+	old := quotas[cu.Name]
+	if old != nil && old.cfg == cu.Quota {
+		u.quota = old
+	} else if u.quota = newQuota(cu.Name, cu.Quota, cfg.Quota); u.quota != nil {
+		u.quota.carry(old)
+		quotas[cu.Name] = u.quota
+	} else {
+		delete(quotas, cu.Name)
+	}
+	var err error
+	if len(cu.Allow) > 0 {
+		if u.allow, err = outbound.ParseHostMatcher(cu.Allow); err != nil {
+			return nil, fmt.Errorf("user %q: %v", cu.Name, err)
+		}
+	}
+	if u.deny, err = outbound.ParseHostMatcher(cu.Deny); err != nil {
+		return nil, fmt.Errorf("user %q: %v", cu.Name, err)
+	}
+
+	next := t.base
+	if len(cu.Egress) > 0 && cfg.OutboundProxy == "" {
+		pool, err := outbound.ParsePool(cu.Egress)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %v", cu.Name, err)
+		}
+		pool.Timeout, pool.Socket = cfg.Dial.Timeout, outboundSocket(cfg)
+		next = pool
+	}
+	u.dialer = &aclDialer{user: u, next: next}
+	return u, nil
+}
+
+// quotaList returns the quotas of the current users.
+func (t *userTable) quotaList() []*quota {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	list := make([]*quota, 0, len(t.quotas))
+	for _, q := range t.quotas {
+		list = append(list, q)
+	}
+	return list
+}
+
+func (t *userTable) len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.users)
+}
+
+// keys returns the mTLS client keys of the users.
+func (t *userTable) keys() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return slices.Collect(maps.Keys(t.byKey))
+}
+
+// hasKey reports whether a user authenticates with the mTLS client key.
+func (t *userTable) hasKey(key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.byKey[key] != nil
+}
+
+// watch loads the users of db into the table now and after every change.
+func (t *userTable) watch(db *userdb.DB) error {
+	load := func() error {
+		users, err := db.Enabled()
+		if err != nil {
+			return err
+		}
+		return t.set(users)
+	}
+	db.OnChange(func() {
+		if err := load(); err != nil {
+			log.Printf("[Users] Failed to reload user_db: %v", err)
+		}
+	})
+	return load()
+}
+
+// lookup returns the user presenting token or owning the mTLS client key
+// (Base64).
+func (t *userTable) lookup(token, clientKey string) *user {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if token != "" {
+		for _, u := range t.users {
+			if u.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(u.token)) == 1 {
+				return u
+			}
+		}
+	}
+	if clientKey != "" {
+		return t.byKey[clientKey]
+	}
+	return nil
+}
+
+// allowsProtocol reports whether the user may open streams of proto.
+func (u *user) allowsProtocol(proto protocol.ProtocolType) bool {
+	if len(u.protocols) == 0 || controlProtocol(proto) {
+		return true
+	}
+	for _, p := range u.protocols {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsTarget applies the destination ACL to "host:port". Deny entries are
+// also checked against the addresses a domain resolves to, so a hostname
+// cannot be used to reach a denied network.
+func (u *user) allowsTarget(target string) bool {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	if u.deny.Match(host) {
+		return false
+	}
+	if net.ParseIP(host) == nil && u.deny.HasNetworks() {
+		ips, err := u.lookups.LookupIP(context.Background(), host)
+		if err != nil {
+			return false
+		}
+		for _, ip := range ips {
+			if u.deny.Match(ip.String()) {
+				return false
+			}
+		}
+	}
+	return u.allow == nil || u.allow.Match(host)
+}
+
+// aclDialer enforces a user's destination ACL on every dial, including
+// targets only known after a server-side SOCKS5 handshake.
+type aclDialer struct {
+	user *user
+	next outbound.Dialer
+}
+
+func (d *aclDialer) Dial(target string) (io.ReadWriteCloser, error) {
+	if !d.user.allowsTarget(target) {
+		return nil, fmt.Errorf("destination %s for user %s: %w", target, d.user.name, outbound.ErrNotAllowed)
+	}
+	return d.next.Dial(target)
+}
+
+// controlProtocol reports whether proto is a control stream, which reaches
+// no target and so is exempt from the user's protocols and quota.
+func controlProtocol(proto protocol.ProtocolType) bool {
+	return proto == protocolFeedback || proto == protocolNotices
+}
@@ -0,0 +1,280 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"phoenix/pkg/outbound"
+	"phoenix/pkg/protocol"
+	"phoenix/pkg/userdb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipeUserDB(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.UserDB = filepath.Join(t.TempDir(), "users.db")
+	db, err := userdb.Open(serverCfg.UserDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := pipeServer(t, serverCfg)
+	newClient := func(token string) *Client {
+		return NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: token}, ln)
+	}
+
+	e, err := db.Create(config.User{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(e.Token)
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Expected the new user to connect, got %v", err)
+	}
+	stream.Close()
+
+	if err := db.SetDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dial(protocol.ProtocolSOCKS5, target); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected the disabled user to be rejected, got %v", err)
+	}
+	db.SetDisabled("alice", false)
+	token, err := db.RotateToken("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dial(protocol.ProtocolSOCKS5, target); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected the old token to be rejected, got %v", err)
+	}
+	if stream, err := newClient(token).Dial(protocol.ProtocolSOCKS5, target); err != nil {
+		t.Errorf("Expected the rotated token to connect, got %v", err)
+	} else {
+		stream.Close()
+	}
+}
+
+// TestPipeUserDBKey checks that disabling a user_db user with a public key
+// refuses both new handshakes and streams of its open connection, even
+// though authorized_clients lets other keys in.
+func TestPipeUserDBKey(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	dir := t.TempDir()
+	serverKey, serverPub := keyFile(t, dir, "server.key")
+	clientKey, clientPub := keyFile(t, dir, "client.key")
+	_, otherPub := keyFile(t, dir, "other.key")
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Security.PrivateKeyPath = serverKey
+	serverCfg.Security.AuthorizedClientKeys = []string{otherPub}
+	serverCfg.UserDB = filepath.Join(dir, "users.db")
+	db, err := userdb.Open(serverCfg.UserDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Create(config.User{Name: "bob", PublicKey: clientPub}); err != nil {
+		t.Fatal(err)
+	}
+	ln := pipeServer(t, serverCfg)
+	newClient := func() *Client {
+		return NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:443", PrivateKeyPath: clientKey, ServerPublicKey: serverPub}, ln)
+	}
+
+	client := newClient()
+	stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+	if err != nil {
+		t.Fatalf("Expected the user's key to connect, got %v", err)
+	}
+	stream.Close()
+
+	if err := db.SetDisabled("bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dial(protocol.ProtocolSOCKS5, target); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected streams of the disabled user to be rejected, got %v", err)
+	}
+	if stream, err := newClient().Dial(protocol.ProtocolSOCKS5, target); err == nil {
+		stream.Close()
+		t.Errorf("Expected the handshake of the disabled user to be rejected")
+	}
+}
+
+// tokenAuth is an Authenticator of a fixed token → user map.
+type tokenAuth map[string]config.User
+
+func (a tokenAuth) ValidateToken(_ context.Context, token string, meta AuthMeta) (config.User, error) {
+	u, ok := a[token]
+	if !ok || meta.Protocol != protocol.ProtocolSOCKS5 {
+		return config.User{}, ErrUnauthorized
+	}
+	return u, nil
+}
+
+func TestPipeAuthenticator(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Security.EnableSSH = true
+	serverCfg.Security.AuthToken = "ignored"
+	ln := NewPipeListener("phoenix-server")
+	t.Cleanup(func() { ln.Close() })
+	auth := tokenAuth{
+		"open":     {},
+		"ssh-only": {Name: "bob", Protocols: []protocol.ProtocolType{protocol.ProtocolSSH}},
+	}
+	go ServeWith(serverCfg, ln, ServerOptions{Authenticator: auth})
+	dial := func(token string) error {
+		client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: token}, ln)
+		stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+		if err == nil {
+			stream.Close()
+		}
+		return err
+	}
+
+	if err := dial("open"); err != nil {
+		t.Errorf("Expected an accepted token to connect, got %v", err)
+	}
+	if err := dial("ignored"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected auth_token to be replaced by the Authenticator, got %v", err)
+	}
+	if err := dial("ssh-only"); err == nil || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected the user's protocols to apply, got %v", err)
+	}
+}
+
+// memRecorder keeps the recordings of a test, sending each as it ends.
+type memRecorder chan *memRecording
+
+type memRecording struct {
+	user, up, down string
+	content        bool
+	end            Connection
+	done           memRecorder
+}
+
+func (r *memRecording) Upload(p []byte)   { r.up += string(p) }
+func (r *memRecording) Download(p []byte) { r.down += string(p) }
+func (r *memRecording) End(conn Connection) {
+	r.end = conn
+	r.done <- r
+}
+
+func (r memRecorder) RecordStream(conn Connection, content bool) StreamRecording {
+	return &memRecording{user: conn.User, content: content, done: r}
+}
+
+func TestPipeRecorder(t *testing.T) {
+	t.Parallel()
+	target := echoTarget(t)
+	serverCfg := config.DefaultServerConfig()
+	serverCfg.Security.EnableSOCKS5 = true
+	serverCfg.Users = []config.User{
+		{Name: "alice", Token: "a", Record: "content"},
+		{Name: "bob", Token: "b", Record: "metadata"},
+		{Name: "carol", Token: "c"},
+	}
+	ln := NewPipeListener("phoenix-server")
+	t.Cleanup(func() { ln.Close() })
+	records := make(memRecorder, 3)
+	go ServeWith(serverCfg, ln, ServerOptions{Recorder: records})
+	echo := func(token string) {
+		client := NewPipeClient(&config.ClientConfig{RemoteAddr: "phoenix.test:80", AuthToken: token}, ln)
+		stream, err := client.Dial(protocol.ProtocolSOCKS5, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		stream.Write([]byte("ping"))
+		if _, err := io.ReadFull(stream, make([]byte, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, token := range []string{"a", "b", "c"} {
+		echo(token)
+	}
+	// Downloads start with the dial status.
+	for _, want := range []memRecording{{user: "alice", up: "ping", down: "ping", content: true}, {user: "bob"}} {
+		select {
+		case got := <-records:
+			if got.user != want.user || got.up != want.up || !strings.HasSuffix(got.down, want.down) || got.content != want.content || got.end.BytesUp != 4 {
+				t.Errorf("Expected recording %+v, got %+v", want, *got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected a recording for %s", want.user)
+		}
+	}
+	select {
+	case got := <-records:
+		t.Errorf("Expected no recording without consent, got %+v", *got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUserEgressSocket(t *testing.T) {
+	cfg := config.DefaultServerConfig()
+	cfg.OutboundInterface = "eth1"
+	cfg.FWMark = 7
+	users, err := newUserTable(cfg, outbound.Direct{}, []config.User{{Name: "alice", Token: "a", Egress: []string{"192.0.2.10"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, ok := users.lookup("a", "").dialer.(*aclDialer).next.(*outbound.Pool)
+	if !ok {
+		t.Fatalf("Expected the user to dial through an egress pool")
+	}
+	if want := (outbound.Socket{Interface: "eth1", Mark: 7}); pool.Socket != want {
+		t.Errorf("Expected the pool to inherit the server's socket %+v, got %+v", want, pool.Socket)
+	}
+}
+
+func TestUserTableResolveEvicts(t *testing.T) {
+	users, err := newUserTable(config.DefaultServerConfig(), outbound.Direct{}, []config.User{{Name: "alice", Token: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := users.resolve(config.User{Name: "first"})
+	for i := range maxResolvedUsers {
+		users.resolve(config.User{Name: fmt.Sprint("user", i)})
+		if i == 0 {
+			// Keep first in use, so user0 is the least recently used.
+			first.used.Store(time.Now().Add(time.Hour).UnixNano())
+		}
+	}
+	if n := len(users.resolved); n != maxResolvedUsers {
+		t.Errorf("Expected the resolved users to stay at %d, got %d", maxResolvedUsers, n)
+	}
+	if users.resolved["user0"] != nil || users.resolved["first"] != first {
+		t.Errorf("Expected the least recently used user to be evicted")
+	}
+	if u, _ := users.resolve(config.User{Name: "alice", Token: "a"}); u != users.lookup("a", "") || users.len() != 1 {
+		t.Errorf("Expected a configured user to resolve to its table entry")
+	}
+}
+
+func TestUserDenyLookupCached(t *testing.T) {
+	users, err := newUserTable(config.DefaultServerConfig(), outbound.Direct{}, []config.User{{Name: "guest", Token: "g", Deny: []string{"127.0.0.0/8"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := users.lookup("g", "")
+	for range 3 {
+		if u.allowsTarget("localhost:53") {
+			t.Fatalf("Expected a name resolving into a denied network to be refused")
+		}
+	}
+	if hits, misses, _ := users.lookups.Stats(); hits != 2 || misses != 1 {
+		t.Errorf("Expected one lookup for repeated targets, got %d hits, %d misses", hits, misses)
+	}
+}
@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"phoenix/pkg/config"
+	"strings"
+)
+
+// vhostRouter hands each stream to the endpoint of its server name: the
+// TLS SNI, which binds the name to the handshake and its client
+// certificate, or the Host header over h2c. Unknown names go to the
+// top-level endpoint.
+type vhostRouter struct {
+	def   *Server
+	hosts []*vhost
+}
+
+// vhost is one resolved [[vhosts]] entry.
+type vhost struct {
+	names []string // Lowercase; "*.example.com" matches subdomains
+	srv   *Server
+	tls   *tls.Config // nil for h2c
+}
+
+// newVHostRouter builds the endpoints of def.Config.VHosts. They share
+// def's outbound dialer, port policy, DNS resolver and flood limits.
+func newVHostRouter(def *Server) (*vhostRouter, error) {
+	r := &vhostRouter{def: def}
+	for _, v := range def.Config.VHosts {
+		cfg := vhostConfig(def.Config, v)
+		srv := *def
+		srv.Config = cfg
+		srv.users = nil
+		if len(cfg.Users) > 0 {
+			users, err := newUserTable(cfg, def.dialer, cfg.Users)
+			if err != nil {
+				return nil, err
+			}
+			srv.users = users
+		} else if srv.auth != nil {
+			srv.users, _ = newUserTable(cfg, def.dialer, nil)
+		}
+		tlsConfig, err := endpointTLS(cfg, srv.users)
+		if err != nil {
+			return nil, err
+		}
+		h := &vhost{srv: &srv, tls: tlsConfig}
+		for _, name := range v.ServerNames {
+			h.names = append(h.names, strings.ToLower(name))
+		}
+		r.hosts = append(r.hosts, h)
+		log.Printf("[VHost] %s: %s, %d users", strings.Join(h.names, ", "), securityMode(v.AuthToken, v.AuthorizedClientKeys, v.PrivateKeyPath), len(v.Users))
+	}
+	return r, nil
+}
+
+// vhostConfig is the server config with the top-level credentials
+// replaced by those of v.
+func vhostConfig(cfg *config.ServerConfig, v config.VHost) *config.ServerConfig {
+	c := *cfg
+	c.Security.PrivateKeyPath = v.PrivateKeyPath
+	c.Security.AuthToken = v.AuthToken
+	c.Security.AuthorizedClientKeys = v.AuthorizedClientKeys
+	c.Users = v.Users
+	c.UserDB = ""
+	c.Quota.StateFile = "" // The default endpoint's
+	c.VHosts = nil
+	return &c
+}
+
+// lookup returns the vhost serving name, preferring exact names over
+// wildcards, or nil.
+func (r *vhostRouter) lookup(name string) *vhost {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil
+	}
+	for _, h := range r.hosts {
+		for _, n := range h.names {
+			if n == name {
+				return h
+			}
+		}
+	}
+	for _, h := range r.hosts {
+		for _, n := range h.names {
+			if strings.HasPrefix(n, "*.") && strings.HasSuffix(name, n[1:]) {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// tlsConfig implements tls.Config.GetConfigForClient; nil keeps the
+// top-level endpoint's settings.
+func (r *vhostRouter) tlsConfig(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if h := r.lookup(hello.ServerName); h != nil {
+		return h.tls, nil
+	}
+	return nil, nil
+}
+
+func (r *vhostRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := req.Host
+	if req.TLS != nil {
+		name = req.TLS.ServerName
+	} else if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	if h := r.lookup(name); h != nil {
+		h.srv.ServeHTTP(w, req)
+		return
+	}
+	r.def.ServeHTTP(w, req)
+}
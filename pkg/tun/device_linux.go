@@ -0,0 +1,37 @@
+package tun
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+	gtun "gvisor.dev/gvisor/pkg/tcpip/link/tun"
+)
+
+// Open creates (or attaches to) the TUN device name with the given MTU.
+// Assigning its addresses and routes is left to the caller.
+func Open(name string, mtu int) (Device, error) {
+	fd, err := gtun.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMTU(name, mtu); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// The fd is non-blocking, so reads go through the runtime poller.
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+func setMTU(name string, mtu int) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint32(uint32(mtu))
+	return unix.IoctlIfreq(fd, unix.SIOCSIFMTU, ifr)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package tun
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Open is not supported on this platform.
+func Open(name string, mtu int) (Device, error) {
+	return nil, fmt.Errorf("creating TUN devices is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,72 @@
+//go:build darwin || windows
+
+package tun
+
+import (
+	"sync"
+
+	wgtun "golang.zx2c4.com/wireguard/tun"
+)
+
+// wgOffset is the headroom wireguard-go needs before each packet: utun
+// prefixes packets with their 4-byte address family.
+const wgOffset = 4
+
+// Open creates the TUN device name with the given MTU: a utun device on
+// macOS, where name must be "utun" or "utunN", and a WinTun adapter on
+// Windows, which needs wintun.dll next to the executable. Assigning its
+// addresses and routes is left to the caller.
+func Open(name string, mtu int) (Device, error) {
+	t, err := wgtun.CreateTUN(name, mtu)
+	if err != nil {
+		return nil, err
+	}
+	if actual, err := t.Name(); err == nil {
+		name = actual
+	}
+	return &wgDevice{
+		tun:   t,
+		name:  name,
+		rbuf:  make([]byte, wgOffset+65535),
+		wbuf:  make([]byte, wgOffset+65535),
+		sizes: make([]int, 1),
+	}, nil
+}
+
+// wgDevice adapts a wireguard-go device, which moves packets in batches,
+// to one packet per Read and Write.
+type wgDevice struct {
+	tun  wgtun.Device
+	name string
+
+	rmu, wmu   sync.Mutex
+	rbuf, wbuf []byte
+	sizes      []int
+}
+
+func (d *wgDevice) Read(p []byte) (int, error) {
+	d.rmu.Lock()
+	defer d.rmu.Unlock()
+	for {
+		n, err := d.tun.Read([][]byte{d.rbuf}, d.sizes, wgOffset)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			return copy(p, d.rbuf[wgOffset:wgOffset+d.sizes[0]]), nil
+		}
+	}
+}
+
+func (d *wgDevice) Write(p []byte) (int, error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	n := copy(d.wbuf[wgOffset:], p)
+	if _, err := d.tun.Write([][]byte{d.wbuf[:wgOffset+n]}, wgOffset); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (d *wgDevice) Close() error { return d.tun.Close() }
+func (d *wgDevice) Name() string { return d.name }
@@ -0,0 +1,113 @@
+package tun
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// IP plus TCP header sizes subtracted from the MTU for the automatic MSS.
+const (
+	ipv4TCPHeaders = 20 + 20
+	ipv6TCPHeaders = 40 + 20
+)
+
+// mssClamp rewrites the MSS option of TCP SYNs read from and written to a
+// TUN device, like an iptables TCPMSS rule. Both directions matter: the
+// SYN from an app tells the stack how large its segments to the app may
+// be, and the stack's SYN-ACK tells the app.
+type mssClamp struct {
+	rw         io.ReadWriter
+	mss4, mss6 uint16
+}
+
+func (c *mssClamp) Read(p []byte) (int, error) {
+	n, err := c.rw.Read(p)
+	if n > 0 {
+		clampMSS(p[:n], c.mss4, c.mss6)
+	}
+	return n, err
+}
+
+// Write may modify p; the stack hands it a freshly flattened packet.
+func (c *mssClamp) Write(p []byte) (int, error) {
+	clampMSS(p, c.mss4, c.mss6)
+	return c.rw.Write(p)
+}
+
+// clampMSS lowers the MSS option of a TCP SYN in the IP packet pkt to at
+// most mss4 (IPv4) or mss6 (IPv6) and patches the TCP checksum. It reports
+// whether pkt was changed. Fragments and IPv6 packets with extension
+// headers before TCP are left alone.
+func clampMSS(pkt []byte, mss4, mss6 uint16) bool {
+	if len(pkt) < 1 {
+		return false
+	}
+	var tcp []byte
+	var max uint16
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if len(pkt) < 20 || ihl < 20 || len(pkt) < ihl || pkt[9] != 6 {
+			return false
+		}
+		if binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+			return false // Not the first fragment
+		}
+		tcp, max = pkt[ihl:], mss4
+	case 6:
+		if len(pkt) < 40 || pkt[6] != 6 {
+			return false
+		}
+		tcp, max = pkt[40:], mss6
+	default:
+		return false
+	}
+
+	if len(tcp) < 20 || tcp[13]&0x02 == 0 {
+		return false // Not a SYN
+	}
+	off := int(tcp[12]>>4) * 4
+	if off < 20 || len(tcp) < off {
+		return false
+	}
+	opts := tcp[20:off]
+	for i := 0; i < len(opts); {
+		switch kind := opts[i]; kind {
+		case 0: // End of options
+			return false
+		case 1: // NOP
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			return false // Malformed
+		}
+		if opts[i] == 2 && opts[i+1] == 4 {
+			old := binary.BigEndian.Uint16(opts[i+2:])
+			if old <= max {
+				return false
+			}
+			binary.BigEndian.PutUint16(opts[i+2:], max)
+			if i%2 == 1 {
+				// The value straddles two checksum words: in one's
+				// complement arithmetic that is the byte-swapped word.
+				old, max = bits.ReverseBytes16(old), bits.ReverseBytes16(max)
+			}
+			sum := binary.BigEndian.Uint16(tcp[16:18])
+			binary.BigEndian.PutUint16(tcp[16:18], adjustChecksum(sum, old, max))
+			return true
+		}
+		i += int(opts[i+1])
+	}
+	return false
+}
+
+// adjustChecksum updates an Internet checksum for a 16-bit word changing
+// from old to new (RFC 1624, eqn. 3).
+func adjustChecksum(sum, old, new uint16) uint16 {
+	s := uint32(^sum) + uint32(^old) + uint32(new)
+	s = (s & 0xffff) + (s >> 16)
+	s = (s & 0xffff) + (s >> 16)
+	return ^uint16(s)
+}
@@ -0,0 +1,118 @@
+package tun
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// synPacket builds an IPv4 or IPv6 TCP SYN with a valid checksum. A NOP
+// before the MSS option puts its value at an odd offset, the harder case
+// for the checksum update.
+func synPacket(v6 bool, mss uint16) []byte {
+	return synPacketOpts(v6, []byte{1, 2, 4, byte(mss >> 8), byte(mss), 1, 1, 0})
+}
+
+// synPacketOpts builds a TCP SYN with the 8 bytes of options opts.
+func synPacketOpts(v6 bool, opts []byte) []byte {
+	tcp := make([]byte, 28)
+	binary.BigEndian.PutUint16(tcp[0:], 40000)
+	binary.BigEndian.PutUint16(tcp[2:], 443)
+	tcp[12] = 7 << 4 // 28-byte header
+	tcp[13] = 0x02   // SYN
+	copy(tcp[20:], opts)
+
+	var ip []byte
+	if v6 {
+		ip = make([]byte, 40)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[23], ip[39] = 1, 2
+	} else {
+		ip = make([]byte, 20)
+		ip[0] = 4<<4 | 5
+		ip[9] = 6
+		copy(ip[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	}
+	pkt := append(ip, tcp...)
+	binary.BigEndian.PutUint16(pkt[len(ip)+16:], tcpChecksum(pkt, v6))
+	return pkt
+}
+
+// tcpChecksum computes the TCP checksum of pkt from scratch.
+func tcpChecksum(pkt []byte, v6 bool) uint16 {
+	var pseudo []byte
+	var tcp []byte
+	if v6 {
+		tcp = pkt[40:]
+		pseudo = append(pseudo, pkt[8:40]...)
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(tcp)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+	} else {
+		tcp = pkt[20:]
+		pseudo = append(pseudo, pkt[12:20]...)
+		pseudo = append(pseudo, 0, 6)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	}
+	data := append(pseudo, tcp...)
+	data[len(pseudo)+16], data[len(pseudo)+17] = 0, 0
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func TestClampMSS(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		v6      bool
+		mss     uint16
+		want    uint16
+		changed bool
+	}{
+		{"ipv4 above", false, 1460, 1412, true},
+		{"ipv4 below", false, 1300, 1300, false},
+		{"ipv6 above", true, 1440, 1392, true},
+		{"ipv6 equal", true, 1392, 1392, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkt := synPacket(tc.v6, tc.mss)
+			if got := clampMSS(pkt, 1412, 1392); got != tc.changed {
+				t.Errorf("Expected changed=%v, got %v", tc.changed, got)
+			}
+			off := 20
+			if tc.v6 {
+				off = 40
+			}
+			if got := binary.BigEndian.Uint16(pkt[off+23:]); got != tc.want {
+				t.Errorf("Expected MSS %d, got %d", tc.want, got)
+			}
+			if got, want := binary.BigEndian.Uint16(pkt[off+16:]), tcpChecksum(pkt, tc.v6); got != want {
+				t.Errorf("Expected checksum %#04x, got %#04x", want, got)
+			}
+		})
+	}
+
+	// An MSS option at the start of the options is word-aligned.
+	pkt := synPacketOpts(false, []byte{2, 4, 0x05, 0xb4, 1, 1, 1, 0})
+	if !clampMSS(pkt, 1412, 1392) {
+		t.Errorf("Expected an aligned MSS option to be clamped")
+	}
+	if got, want := binary.BigEndian.Uint16(pkt[36:]), tcpChecksum(pkt, false); got != want {
+		t.Errorf("Expected checksum %#04x, got %#04x", want, got)
+	}
+
+	// Segments other than SYNs are never touched.
+	pkt = synPacket(false, 1460)
+	pkt[20+13] = 0x10 // ACK
+	if clampMSS(pkt, 1000, 1000) {
+		t.Errorf("Expected a non-SYN segment to be left alone")
+	}
+}
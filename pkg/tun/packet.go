@@ -0,0 +1,135 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// IP protocol numbers handled by the system stack.
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// ipPacket is the parsed network header of a packet read from the device.
+type ipPacket struct {
+	v6       bool
+	hdr      int // Header length; the transport header follows
+	proto    byte
+	src, dst netip.Addr
+}
+
+// parseIP parses the header of pkt. It rejects IPv4 fragments, whose
+// transport header is missing or incomplete, and IPv6 packets with
+// extension headers.
+func parseIP(pkt []byte) (ipPacket, bool) {
+	var ip ipPacket
+	if len(pkt) < 1 {
+		return ip, false
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		ip.hdr = int(pkt[0]&0x0f) * 4
+		if len(pkt) < 20 || ip.hdr < 20 || len(pkt) < ip.hdr {
+			return ip, false
+		}
+		if binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+			return ip, false // Fragment or more fragments follow
+		}
+		ip.proto = pkt[9]
+		ip.src = netip.AddrFrom4([4]byte(pkt[12:16]))
+		ip.dst = netip.AddrFrom4([4]byte(pkt[16:20]))
+	case 6:
+		if len(pkt) < 40 {
+			return ip, false
+		}
+		ip.v6, ip.hdr, ip.proto = true, 40, pkt[6]
+		ip.src = netip.AddrFrom16([16]byte(pkt[8:24]))
+		ip.dst = netip.AddrFrom16([16]byte(pkt[24:40]))
+	default:
+		return ip, false
+	}
+	return ip, true
+}
+
+// rewriteTCP replaces the addresses and ports of the TCP packet pkt and
+// updates its checksums incrementally, leaving the payload untouched.
+func rewriteTCP(pkt []byte, ip ipPacket, src, dst netip.AddrPort) {
+	addrs := pkt[12:20]
+	if ip.v6 {
+		addrs = pkt[8:40]
+	}
+	tcp := pkt[ip.hdr:]
+	oldAddrs := onesSum(addrs)
+	old := onesSum(addrs, tcp[0:4])
+
+	n := copy(addrs, src.Addr().AsSlice())
+	copy(addrs[n:], dst.Addr().AsSlice())
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+
+	sum := binary.BigEndian.Uint16(tcp[16:18])
+	binary.BigEndian.PutUint16(tcp[16:18], adjustChecksum(sum, old, onesSum(addrs, tcp[0:4])))
+	if !ip.v6 {
+		sum := binary.BigEndian.Uint16(pkt[10:12])
+		binary.BigEndian.PutUint16(pkt[10:12], adjustChecksum(sum, oldAddrs, onesSum(addrs)))
+	}
+}
+
+// buildUDP returns an IP packet carrying payload from src to dst.
+func buildUDP(src, dst netip.AddrPort, payload []byte) []byte {
+	hdr := 20
+	if src.Addr().Is6() {
+		hdr = 40
+	}
+	pkt := make([]byte, hdr+8+len(payload))
+	udp := pkt[hdr:]
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], payload)
+
+	var pseudo []byte
+	if hdr == 40 {
+		pkt[0] = 6 << 4
+		binary.BigEndian.PutUint16(pkt[4:], uint16(len(udp)))
+		pkt[6], pkt[7] = protoUDP, 64
+		copy(pkt[8:], src.Addr().AsSlice())
+		copy(pkt[24:], dst.Addr().AsSlice())
+		pseudo = binary.BigEndian.AppendUint32(append([]byte(nil), pkt[8:40]...), uint32(len(udp)))
+		pseudo = append(pseudo, 0, 0, 0, protoUDP)
+	} else {
+		pkt[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		pkt[8], pkt[9] = 64, protoUDP
+		copy(pkt[12:], src.Addr().AsSlice())
+		copy(pkt[16:], dst.Addr().AsSlice())
+		binary.BigEndian.PutUint16(pkt[10:], ^onesSum(pkt[:20]))
+		pseudo = append(append([]byte(nil), pkt[12:20]...), 0, protoUDP)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(udp)))
+	}
+	sum := ^onesSum(pseudo, udp)
+	if sum == 0 {
+		sum = 0xffff // Zero means "no checksum" in UDP
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return pkt
+}
+
+// onesSum is the folded one's complement sum of bufs as big-endian 16-bit
+// words. Every buffer but the last must have an even length.
+func onesSum(bufs ...[]byte) uint16 {
+	var s uint32
+	for _, b := range bufs {
+		for i := 0; i+1 < len(b); i += 2 {
+			s += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			s += uint32(b[len(b)-1]) << 8
+		}
+	}
+	for s > 0xffff {
+		s = (s & 0xffff) + (s >> 16)
+	}
+	return uint16(s)
+}
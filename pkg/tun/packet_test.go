@@ -0,0 +1,41 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+func TestRewriteTCP(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		pkt := synPacket(v6, 1460)
+		if !v6 {
+			binary.BigEndian.PutUint16(pkt[10:], ^onesSum(pkt[:20]))
+		}
+		ip, ok := parseIP(pkt)
+		if !ok || ip.proto != protoTCP {
+			t.Fatalf("Expected a TCP packet, got %+v", ip)
+		}
+		src := netip.MustParseAddrPort("10.233.233.2:10000")
+		dst := netip.MustParseAddrPort("10.233.233.1:41000")
+		if v6 {
+			src = netip.MustParseAddrPort("[fd00::2]:10000")
+			dst = netip.MustParseAddrPort("[fd00::1]:41000")
+		}
+		rewriteTCP(pkt, ip, src, dst)
+
+		got, _ := parseIP(pkt)
+		if got.src != src.Addr() || got.dst != dst.Addr() {
+			t.Errorf("Expected %v -> %v, got %v -> %v", src.Addr(), dst.Addr(), got.src, got.dst)
+		}
+		if p := binary.BigEndian.Uint16(pkt[ip.hdr+2:]); p != dst.Port() {
+			t.Errorf("Expected destination port %d, got %d", dst.Port(), p)
+		}
+		if got, want := binary.BigEndian.Uint16(pkt[ip.hdr+16:]), tcpChecksum(pkt, v6); got != want {
+			t.Errorf("Expected TCP checksum %#04x, got %#04x", want, got)
+		}
+		if !v6 && onesSum(pkt[:20]) != 0xffff {
+			t.Errorf("Expected a valid IPv4 header checksum")
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package tun
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+)
+
+// DefaultAddress is the device address assumed by the system stack when
+// tun.address is unset. It matches the Android VpnService.
+const DefaultAddress = "10.233.233.1/30"
+
+const (
+	natPortFirst   = 10000            // NAT source ports run from here to 65535
+	synTimeout     = 30 * time.Second // Forget sessions the kernel never accepted
+	closeGrace     = time.Minute      // Keep finished sessions for their last FIN/ACKs
+	dialTimeout    = 10 * time.Second
+	udpIdleTimeout = time.Minute
+	udpQueue       = 64 // Datagrams buffered while an association is set up
+)
+
+// systemStack lets the kernel terminate the device's TCP connections, like
+// a DNAT rule: a new connection from src to dst is rewritten to come from
+// the NAT address and go to a listener on the device's own address, and
+// the listener's replies are rewritten back. UDP is relayed in userspace
+// with one SOCKS5 association per source, so it behaves as a full-cone NAT.
+type systemStack struct {
+	dev        io.ReadWriter
+	socks      *proxy.Socks5
+	mtu        int
+	mss4, mss6 uint16 // 0 = no clamping
+	v4, v6     *natTable
+
+	mu  sync.Mutex
+	udp map[netip.AddrPort]*udpFlow
+}
+
+// natTable is the TCP NAT of one IP version.
+type natTable struct {
+	addr    netip.Addr // Device address the listener is bound to
+	natAddr netip.Addr // Source address of rewritten connections
+	port    uint16     // Listener port
+	ln      *net.TCPListener
+
+	mu       sync.Mutex
+	next     uint16
+	flows    map[flowKey]uint16
+	sessions map[uint16]*tcpSession
+}
+
+type flowKey struct{ src, dst netip.AddrPort }
+
+// tcpSession is one NATed connection, keyed by its NAT source port.
+type tcpSession struct {
+	flowKey
+	created  time.Time
+	accepted bool
+	expire   *time.Timer // Removes the session after closeGrace
+}
+
+func startSystem(dev io.ReadWriter, socks *proxy.Socks5, cfg systemConfig) error {
+	s := &systemStack{
+		dev:   dev,
+		socks: socks,
+		mtu:   cfg.mtu,
+		mss4:  cfg.mss4,
+		mss6:  cfg.mss6,
+		udp:   make(map[netip.AddrPort]*udpFlow),
+	}
+	var err error
+	if s.v4, err = newNATTable(cfg.address); err != nil {
+		return err
+	}
+	if cfg.address6.IsValid() {
+		if s.v6, err = newNATTable(cfg.address6); err != nil {
+			s.v4.ln.Close()
+			return err
+		}
+	}
+	for _, t := range []*natTable{s.v4, s.v6} {
+		if t != nil {
+			go s.serveTCP(t)
+			go t.expireUnaccepted()
+		}
+	}
+	go s.run()
+	return nil
+}
+
+// systemConfig is the part of the TUN settings the system stack uses.
+type systemConfig struct {
+	address, address6 netip.Prefix
+	mtu               int
+	mss4, mss6        uint16
+}
+
+// newNATTable listens on all addresses, since a device created by Open
+// only gets its address after the stack has started; relayTCP drops
+// connections that did not come through the NAT.
+func newNATTable(p netip.Prefix) (*natTable, error) {
+	addr := p.Addr()
+	network, unspec := "tcp4", netip.IPv4Unspecified()
+	if addr.Is6() {
+		network, unspec = "tcp6", netip.IPv6Unspecified()
+	}
+	ln, err := net.ListenTCP(network, net.TCPAddrFromAddrPort(netip.AddrPortFrom(unspec, 0)))
+	if err != nil {
+		return nil, fmt.Errorf("system stack listener for %s: %v", p, err)
+	}
+	return &natTable{
+		addr:     addr,
+		natAddr:  addr.Next(),
+		port:     uint16(ln.Addr().(*net.TCPAddr).Port),
+		ln:       ln,
+		next:     natPortFirst,
+		flows:    make(map[flowKey]uint16),
+		sessions: make(map[uint16]*tcpSession),
+	}, nil
+}
+
+// run reads packets from the device until it fails.
+func (s *systemStack) run() {
+	buf := make([]byte, 65535)
+	for {
+		n, err := s.dev.Read(buf)
+		if err != nil {
+			log.Printf("[TUN] Read failed, stopping: %v", err)
+			return
+		}
+		pkt := buf[:n]
+		ip, ok := parseIP(pkt)
+		if !ok {
+			continue
+		}
+		switch ip.proto {
+		case protoTCP:
+			s.handleTCP(pkt, ip)
+		case protoUDP:
+			s.handleUDP(pkt, ip)
+		}
+	}
+}
+
+func (s *systemStack) handleTCP(pkt []byte, ip ipPacket) {
+	t := s.v4
+	if ip.v6 {
+		t = s.v6
+	}
+	tcp := pkt[ip.hdr:]
+	if t == nil || len(tcp) < 20 {
+		return
+	}
+	src := netip.AddrPortFrom(ip.src, binary.BigEndian.Uint16(tcp[0:]))
+	dst := netip.AddrPortFrom(ip.dst, binary.BigEndian.Uint16(tcp[2:]))
+
+	if src.Addr() == t.addr && src.Port() == t.port && dst.Addr() == t.natAddr {
+		// The listener's side of a session: restore the original flow.
+		flow, ok := t.lookup(dst.Port())
+		if !ok {
+			return
+		}
+		rewriteTCP(pkt, ip, flow.dst, flow.src)
+	} else {
+		syn := tcp[13]&0x12 == 0x02 // SYN without ACK
+		port, ok := t.natPort(flowKey{src, dst}, syn)
+		if !ok {
+			return
+		}
+		rewriteTCP(pkt, ip, netip.AddrPortFrom(t.natAddr, port), netip.AddrPortFrom(t.addr, t.port))
+	}
+	if s.mss4 > 0 {
+		clampMSS(pkt, s.mss4, s.mss6)
+	}
+	s.dev.Write(pkt)
+}
+
+// natPort returns the NAT source port of flow, allocating one for a SYN.
+func (t *natTable) natPort(flow flowKey, syn bool) (uint16, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if port, ok := t.flows[flow]; ok {
+		return port, true
+	}
+	if !syn {
+		return 0, false
+	}
+	for range 65536 - natPortFirst {
+		port := t.next
+		if t.next++; t.next == 0 {
+			t.next = natPortFirst
+		}
+		if _, used := t.sessions[port]; !used {
+			t.flows[flow] = port
+			t.sessions[port] = &tcpSession{flowKey: flow, created: time.Now()}
+			return port, true
+		}
+	}
+	return 0, false // Every port is in use
+}
+
+func (t *natTable) lookup(port uint16) (flowKey, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sess, ok := t.sessions[port]
+	if !ok {
+		return flowKey{}, false
+	}
+	return sess.flowKey, true
+}
+
+// accept marks the session of port as accepted by the listener.
+func (t *natTable) accept(port uint16) (flowKey, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sess, ok := t.sessions[port]
+	if !ok {
+		return flowKey{}, false
+	}
+	sess.accepted = true
+	if sess.expire != nil {
+		sess.expire.Stop()
+		sess.expire = nil
+	}
+	return sess.flowKey, true
+}
+
+// release removes the session of port once the kernel is done with it.
+func (t *natTable) release(port uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sess, ok := t.sessions[port]
+	if !ok {
+		return
+	}
+	sess.expire = time.AfterFunc(closeGrace, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.sessions[port] == sess && sess.expire != nil {
+			t.remove(port, sess)
+		}
+	})
+}
+
+// remove deletes a session. Called with t.mu held.
+func (t *natTable) remove(port uint16, sess *tcpSession) {
+	delete(t.sessions, port)
+	delete(t.flows, sess.flowKey)
+}
+
+// expireUnaccepted periodically drops sessions whose SYN never turned into a
+// connection, e.g. because the app gave up.
+func (t *natTable) expireUnaccepted() {
+	for range time.Tick(synTimeout) {
+		t.mu.Lock()
+		for port, sess := range t.sessions {
+			if !sess.accepted && time.Since(sess.created) > synTimeout {
+				t.remove(port, sess)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (s *systemStack) serveTCP(t *natTable) {
+	for {
+		conn, err := t.ln.AcceptTCP()
+		if err != nil {
+			log.Printf("[TUN] Listener on %s failed: %v", t.addr, err)
+			return
+		}
+		go s.relayTCP(t, conn)
+	}
+}
+
+func (s *systemStack) relayTCP(t *natTable, conn *net.TCPConn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+	if remote.Addr().Unmap() != t.natAddr {
+		return
+	}
+	port := remote.Port()
+	flow, ok := t.accept(port)
+	if !ok {
+		return
+	}
+	defer t.release(port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	upstream, err := s.socks.DialContext(ctx, &M.Metadata{
+		Network: M.TCP,
+		SrcIP:   flow.src.Addr(),
+		SrcPort: flow.src.Port(),
+		DstIP:   flow.dst.Addr(),
+		DstPort: flow.dst.Port(),
+	})
+	cancel()
+	if err != nil {
+		log.Printf("[TUN] TCP %s: %v", flow.dst, err)
+		// Reset rather than close, as a refusing host would; apps retry or
+		// give up at once instead of reading an empty response.
+		conn.SetLinger(0)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, conn)
+		closeWrite(upstream)
+		close(done)
+	}()
+	io.Copy(conn, upstream)
+	conn.CloseWrite()
+	<-done
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		c.Close()
+	}
+}
+
+// udpFlow relays the datagrams of one source through a SOCKS5 association.
+type udpFlow struct {
+	src    netip.AddrPort
+	queue  chan udpDatagram
+	active atomic.Int64 // UnixNano of the last datagram
+}
+
+type udpDatagram struct {
+	dst     netip.AddrPort
+	payload []byte
+}
+
+func (s *systemStack) handleUDP(pkt []byte, ip ipPacket) {
+	udp := pkt[ip.hdr:]
+	if len(udp) < 8 {
+		return
+	}
+	n := int(binary.BigEndian.Uint16(udp[4:]))
+	if n < 8 || n > len(udp) {
+		return
+	}
+	src := netip.AddrPortFrom(ip.src, binary.BigEndian.Uint16(udp[0:]))
+	dst := netip.AddrPortFrom(ip.dst, binary.BigEndian.Uint16(udp[2:]))
+	d := udpDatagram{dst: dst, payload: append([]byte(nil), udp[8:n]...)}
+
+	s.mu.Lock()
+	f, ok := s.udp[src]
+	if !ok {
+		f = &udpFlow{src: src, queue: make(chan udpDatagram, udpQueue)}
+		s.udp[src] = f
+		go s.relayUDP(f, dst)
+	}
+	s.mu.Unlock()
+	f.active.Store(time.Now().UnixNano())
+	select {
+	case f.queue <- d:
+	default: // Queue full; drop like a congested link
+	}
+}
+
+// relayUDP sets up the association of f and sends its queued datagrams
+// until the association fails or has been idle for udpIdleTimeout.
+func (s *systemStack) relayUDP(f *udpFlow, first netip.AddrPort) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.udp, f.src)
+		s.mu.Unlock()
+	}()
+	pc, err := s.socks.DialUDP(&M.Metadata{
+		Network: M.UDP,
+		SrcIP:   f.src.Addr(),
+		SrcPort: f.src.Port(),
+		DstIP:   first.Addr(),
+		DstPort: first.Port(),
+	})
+	if err != nil {
+		log.Printf("[TUN] UDP %s: %v", first, err)
+		return
+	}
+	defer pc.Close()
+	readDone := make(chan struct{})
+	go func() {
+		s.readUDP(f, pc)
+		close(readDone)
+	}()
+
+	for {
+		select {
+		case d := <-f.queue:
+			pc.WriteTo(d.payload, net.UDPAddrFromAddrPort(d.dst))
+		case <-readDone:
+			return
+		}
+	}
+}
+
+// readUDP writes the association's replies to the device until reading
+// fails or the flow stays idle.
+func (s *systemStack) readUDP(f *udpFlow, pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		pc.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			idle := time.Since(time.Unix(0, f.active.Load()))
+			if os.IsTimeout(err) && idle < udpIdleTimeout {
+				continue
+			}
+			return
+		}
+		f.active.Store(time.Now().UnixNano())
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		src := addr.AddrPort()
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		if src.Addr().Is6() != f.src.Addr().Is6() {
+			continue
+		}
+		pkt := buildUDP(src, f.src, buf[:n])
+		if len(pkt) > s.mtu {
+			continue // Would need fragmentation
+		}
+		s.dev.Write(pkt)
+	}
+}
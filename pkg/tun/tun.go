@@ -0,0 +1,150 @@
+// Package tun runs the network stack of VPN mode: it reads IP packets from
+// a TUN device, terminates TCP and UDP, either in gVisor's userspace
+// netstack or by NATing TCP to the kernel, and sends every connection to a
+// local SOCKS5 proxy. The device is an fd handed over by the Android
+// VpnService, or one Open creates: /dev/net/tun on Linux, utun on macOS
+// and WinTun on Windows.
+package tun
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"phoenix/pkg/config"
+	"strconv"
+
+	"github.com/xjasonlyu/tun2socks/v2/core"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/fdbased"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/iobased"
+	tlog "github.com/xjasonlyu/tun2socks/v2/log"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// DefaultMTU is the MTU used when tun.mtu is unset.
+const DefaultMTU = 1500
+
+// Device is a TUN device: every Read and Write carries one IP packet.
+type Device interface {
+	io.ReadWriteCloser
+
+	// Name is the interface name, which the OS may have picked (utun7).
+	Name() string
+}
+
+// Start serves the TUN device fd with the settings of cfg, sending
+// connections to the SOCKS5 proxy at socksAddr ("host:port"). The stack
+// runs until the process exits.
+func Start(fd int, socksAddr string, cfg config.TUNConfig) error {
+	n, err := newNetstack(socksAddr, cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Stack != "system" && n.mss4 == 0 {
+		// Without clamping gVisor can read the fd itself.
+		ep, err := fdbased.Open(strconv.Itoa(fd), uint32(n.mtu), 0)
+		if err != nil {
+			return fmt.Errorf("failed to open TUN fd %d: %v", fd, err)
+		}
+		return n.startGVisor(ep)
+	}
+	return n.serve(os.NewFile(uintptr(fd), "tun"))
+}
+
+// StartDevice serves dev like Start serves an fd.
+func StartDevice(dev Device, socksAddr string, cfg config.TUNConfig) error {
+	n, err := newNetstack(socksAddr, cfg)
+	if err != nil {
+		return err
+	}
+	return n.serve(dev)
+}
+
+// netstack holds the settings shared by both stacks.
+type netstack struct {
+	cfg        config.TUNConfig
+	socks      *proxy.Socks5
+	mtu        int
+	mss4, mss6 int // 0 = no clamping
+}
+
+func newNetstack(socksAddr string, cfg config.TUNConfig) (*netstack, error) {
+	n := &netstack{cfg: cfg, mtu: MTU(cfg)}
+	if cfg.MSS >= 0 {
+		n.mss4, n.mss6 = n.mtu-ipv4TCPHeaders, n.mtu-ipv6TCPHeaders
+		if cfg.MSS > 0 {
+			n.mss4, n.mss6 = min(n.mss4, cfg.MSS), min(n.mss6, cfg.MSS-20)
+		}
+	}
+
+	if l, err := tlog.NewLeveled(tlog.WarnLevel); err == nil {
+		tlog.SetLogger(l)
+	}
+	socks, err := proxy.NewSocks5(socksAddr, "", "")
+	if err != nil {
+		return nil, err
+	}
+	n.socks = socks
+	return n, nil
+}
+
+// MTU returns the MTU of cfg, applying the default.
+func MTU(cfg config.TUNConfig) int {
+	if cfg.MTU == 0 {
+		return DefaultMTU
+	}
+	return cfg.MTU
+}
+
+// serve runs the configured stack on dev.
+func (n *netstack) serve(dev io.ReadWriter) error {
+	if n.cfg.Stack != "system" {
+		var rw io.ReadWriter = dev
+		if n.mss4 > 0 {
+			rw = &mssClamp{rw: dev, mss4: uint16(n.mss4), mss6: uint16(n.mss6)}
+		}
+		ep, err := iobased.New(rw, uint32(n.mtu), 0)
+		if err != nil {
+			return fmt.Errorf("failed to open TUN device: %v", err)
+		}
+		return n.startGVisor(ep)
+	}
+
+	sc := systemConfig{mtu: n.mtu, mss4: uint16(n.mss4), mss6: uint16(n.mss6)}
+	addr := n.cfg.Address
+	if addr == "" {
+		addr = DefaultAddress
+	}
+	var err error
+	if sc.address, err = netip.ParsePrefix(addr); err != nil {
+		return fmt.Errorf("invalid tun.address: %v", err)
+	}
+	if n.cfg.Address6 != "" {
+		if sc.address6, err = netip.ParsePrefix(n.cfg.Address6); err != nil {
+			return fmt.Errorf("invalid tun.address6: %v", err)
+		}
+	}
+	n.logStack("system")
+	return startSystem(dev, n.socks, sc)
+}
+
+func (n *netstack) startGVisor(ep stack.LinkEndpoint) error {
+	n.logStack("gvisor")
+	tunnel.T().SetDialer(n.socks)
+	_, err := core.CreateStack(&core.Config{
+		LinkEndpoint:     ep,
+		TransportHandler: tunnel.T(),
+	})
+	return err
+}
+
+func (n *netstack) logStack(name string) {
+	if n.mss4 == 0 {
+		log.Printf("[TUN] %s stack, MTU %d, MSS clamping off", name, n.mtu)
+		return
+	}
+	log.Printf("[TUN] %s stack, MTU %d, TCP MSS clamped to %d (IPv6 %d)", name, n.mtu, n.mss4, n.mss6)
+}
@@ -0,0 +1,249 @@
+// Package userdb keeps the server's user table in SQLite (user_db), so users
+// can be created, changed and disabled through the admin API while the
+// server runs.
+package userdb
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"phoenix/pkg/config"
+	"phoenix/pkg/protocol"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver; cross-compiles for Android and Windows
+)
+
+// ErrNotFound and ErrExists are returned for unknown and duplicate names.
+var (
+	ErrNotFound = errors.New("no such user")
+	ErrExists   = errors.New("user already exists")
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS users (
+	name       TEXT PRIMARY KEY,
+	token      TEXT NOT NULL DEFAULT '',
+	public_key TEXT NOT NULL DEFAULT '',
+	settings   TEXT NOT NULL DEFAULT '{}',
+	disabled   INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+)`
+
+// Entry is a user with its bookkeeping.
+type Entry struct {
+	config.User
+	Disabled bool // Rejected like an unknown token, but kept
+	Created  time.Time
+	Updated  time.Time
+}
+
+// settings are the columns of config.User without their own column, stored
+// as JSON.
+type settings struct {
+	Protocols []protocol.ProtocolType `json:"protocols,omitempty"`
+	Allow     []string                `json:"allow,omitempty"`
+	Deny      []string                `json:"deny,omitempty"`
+	Egress    []string                `json:"egress,omitempty"`
+	Quota     config.UserQuota        `json:"quota"`
+	Record    string                  `json:"record,omitempty"`
+}
+
+// DB is an open user database.
+type DB struct {
+	db *sql.DB
+
+	mu      sync.Mutex // Serializes writes, so Create's check holds, and protects watches
+	watches []func()
+}
+
+var (
+	openMu sync.Mutex
+	opened = map[string]*DB{}
+)
+
+// Open opens (or creates) the database at path. Within a process, every
+// Open of a path returns the same DB, so the server and its admin API see
+// each other's changes.
+func Open(path string) (*DB, error) {
+	openMu.Lock()
+	defer openMu.Unlock()
+	if d := opened[path]; d != nil {
+		return d, nil
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open user_db: %v", err)
+	}
+	db.SetMaxOpenConns(1) // SQLite has one writer; queueing beats SQLITE_BUSY
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open user_db: %v", err)
+	}
+	d := &DB{db: db}
+	opened[path] = d
+	return d, nil
+}
+
+// OnChange calls fn after every change to the users.
+func (d *DB) OnChange(fn func()) {
+	d.mu.Lock()
+	d.watches = append(d.watches, fn)
+	d.mu.Unlock()
+}
+
+func (d *DB) changed() {
+	d.mu.Lock()
+	watches := append([]func(){}, d.watches...)
+	d.mu.Unlock()
+	for _, fn := range watches {
+		fn()
+	}
+}
+
+// List returns every user, disabled ones included, by name.
+func (d *DB) List() ([]Entry, error) {
+	rows, err := d.db.Query(`SELECT name, token, public_key, settings, disabled, created_at, updated_at FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Entry
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Enabled returns the users that may connect.
+func (d *DB) Enabled() ([]config.User, error) {
+	list, err := d.List()
+	if err != nil {
+		return nil, err
+	}
+	var users []config.User
+	for _, e := range list {
+		if !e.Disabled {
+			users = append(users, e.User)
+		}
+	}
+	return users, nil
+}
+
+// Get returns the user called name.
+func (d *DB) Get(name string) (Entry, error) {
+	row := d.db.QueryRow(`SELECT name, token, public_key, settings, disabled, created_at, updated_at FROM users WHERE name = ?`, name)
+	e, err := scan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, ErrNotFound
+	}
+	return e, err
+}
+
+func scan(row interface{ Scan(...any) error }) (Entry, error) {
+	var e Entry
+	var raw string
+	var created, updated int64
+	if err := row.Scan(&e.Name, &e.Token, &e.PublicKey, &raw, &e.Disabled, &created, &updated); err != nil {
+		return Entry{}, err
+	}
+	var s settings
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return Entry{}, fmt.Errorf("user %q: invalid settings: %v", e.Name, err)
+	}
+	e.Protocols, e.Allow, e.Deny, e.Egress, e.Quota, e.Record = s.Protocols, s.Allow, s.Deny, s.Egress, s.Quota, s.Record
+	e.Created, e.Updated = time.Unix(created, 0), time.Unix(updated, 0)
+	return e, nil
+}
+
+func encodeSettings(u config.User) string {
+	raw, _ := json.Marshal(settings{Protocols: u.Protocols, Allow: u.Allow, Deny: u.Deny, Egress: u.Egress, Quota: u.Quota, Record: u.Record})
+	return string(raw)
+}
+
+// Create adds u. A user without token or public key gets a new token.
+func (d *DB) Create(u config.User) (Entry, error) {
+	if u.Token == "" && u.PublicKey == "" {
+		u.Token = NewToken()
+	}
+	if err := u.Validate(); err != nil {
+		return Entry{}, err
+	}
+	d.mu.Lock()
+	var exists bool
+	err := d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE name = ?)`, u.Name).Scan(&exists)
+	if err == nil && exists {
+		err = ErrExists
+	}
+	if err == nil {
+		now := time.Now().Unix()
+		_, err = d.db.Exec(`INSERT INTO users (name, token, public_key, settings, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			u.Name, u.Token, u.PublicKey, encodeSettings(u), now, now)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return Entry{}, err
+	}
+	d.changed()
+	return d.Get(u.Name)
+}
+
+// Update replaces the credentials and settings of the user called u.Name,
+// keeping whether it is disabled.
+func (d *DB) Update(u config.User) (Entry, error) {
+	if err := u.Validate(); err != nil {
+		return Entry{}, err
+	}
+	if err := d.exec(u.Name, `UPDATE users SET token = ?, public_key = ?, settings = ?, updated_at = ? WHERE name = ?`,
+		u.Token, u.PublicKey, encodeSettings(u), time.Now().Unix(), u.Name); err != nil {
+		return Entry{}, err
+	}
+	return d.Get(u.Name)
+}
+
+// RotateToken gives the user called name a new token and returns it. The
+// old one stops working at once.
+func (d *DB) RotateToken(name string) (string, error) {
+	token := NewToken()
+	return token, d.exec(name, `UPDATE users SET token = ?, updated_at = ? WHERE name = ?`, token, time.Now().Unix(), name)
+}
+
+// SetDisabled disables or re-enables the user called name.
+func (d *DB) SetDisabled(name string, disabled bool) error {
+	return d.exec(name, `UPDATE users SET disabled = ?, updated_at = ? WHERE name = ?`, disabled, time.Now().Unix(), name)
+}
+
+// Delete removes the user called name.
+func (d *DB) Delete(name string) error {
+	return d.exec(name, `DELETE FROM users WHERE name = ?`, name)
+}
+
+// exec runs a statement changing the user called name.
+func (d *DB) exec(name, query string, args ...any) error {
+	d.mu.Lock()
+	res, err := d.db.Exec(query, args...)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	d.changed()
+	return nil
+}
+
+// NewToken returns a random user token.
+func NewToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
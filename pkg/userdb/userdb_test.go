@@ -0,0 +1,52 @@
+package userdb
+
+import (
+	"errors"
+	"path/filepath"
+	"phoenix/pkg/config"
+	"testing"
+)
+
+func TestUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if again, _ := Open(path); again != db {
+		t.Errorf("Expected the same DB for the same path")
+	}
+	changes := 0
+	db.OnChange(func() { changes++ })
+
+	e, err := db.Create(config.User{Name: "alice", Allow: []string{"example.com"}, Quota: config.UserQuota{Bytes: 1 << 30}})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if e.Token == "" || e.Allow[0] != "example.com" || e.Quota.Bytes != 1<<30 {
+		t.Errorf("Expected a generated token and the settings back, got %+v", e)
+	}
+	if _, err := db.Create(config.User{Name: "alice", Token: "x"}); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected a duplicate name to fail with ErrExists, got %v", err)
+	}
+
+	token, err := db.RotateToken("alice")
+	if err != nil || token == e.Token {
+		t.Errorf("Expected a new token, got %q (%v)", token, err)
+	}
+	if err := db.SetDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if users, _ := db.Enabled(); len(users) != 0 {
+		t.Errorf("Expected no enabled users, got %+v", users)
+	}
+	if err := db.Delete("bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleting an unknown user to fail with ErrNotFound, got %v", err)
+	}
+	if err := db.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if changes != 4 {
+		t.Errorf("Expected 4 change notifications, got %d", changes)
+	}
+}
@@ -0,0 +1,102 @@
+// Package version holds build metadata shared by every phoenix subcommand.
+//
+// Release builds inject the values via -ldflags, e.g.:
+//
+//	go build -ldflags "-X phoenix/pkg/version.Version=v1.2.0 \
+//	  -X phoenix/pkg/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X phoenix/pkg/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/phoenix
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Version is the release version (e.g. "v1.2.0"); "dev" for local builds.
+	Version = "dev"
+	// Commit is the git revision the binary was built from.
+	Commit = ""
+	// BuildDate is the UTC build timestamp.
+	BuildDate = ""
+	// Features is a comma-separated list of optional capabilities in this build.
+	Features = "utls,shadowsocks,socks5-udp,tun2socks"
+)
+
+func init() {
+	// Fall back to the VCS stamp embedded by the Go toolchain for builds
+	// that didn't set the ldflags.
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if Commit == "" && len(s.Value) >= 7 {
+				Commit = s.Value[:7]
+			}
+		case "vcs.time":
+			if BuildDate == "" {
+				BuildDate = s.Value
+			}
+		}
+	}
+}
+
+// String returns a one-line human-readable description of the build.
+func String() string {
+	commit := Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	date := BuildDate
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("phoenix %s (commit %s, built %s, %s %s/%s) features: %s",
+		Version, commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH, Features)
+}
+
+// Compare compares two "vMAJOR.MINOR.PATCH" versions and returns -1, 0 or 1.
+// A pre-release suffix ("-rc1") is ignored. ok is false if either side
+// cannot be parsed (e.g. "dev"), in which case the result is meaningless.
+func Compare(a, b string) (result int, ok bool) {
+	pa, okA := parse(a)
+	pb, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := 0; i < 3; i++ {
+		switch {
+		case pa[i] < pb[i]:
+			return -1, true
+		case pa[i] > pb[i]:
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func parse(v string) ([3]int, bool) {
+	var out [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return out, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
@@ -0,0 +1,23 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"v1.2.0", "v1.2.0", 0, true},
+		{"v1.2.0", "v1.10.0", -1, true},
+		{"1.3", "v1.2.9", 1, true},
+		{"v2.0.0-rc1", "v2.0.0", 0, true},
+		{"dev", "v1.0.0", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := Compare(c.a, c.b)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("Compare(%q, %q) = %d, %v; expected %d, %v", c.a, c.b, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package wireguard
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultMTU is wg-quick's MTU for tunnels without one.
+const defaultMTU = 1420
+
+// Config is a WireGuard interface as written for wg-quick.
+type Config struct {
+	PrivateKey string       // Base64
+	Addresses  []netip.Addr // The interface's addresses, from Address
+	DNS        []netip.Addr // Resolvers reached through the tunnel; empty = resolve on this host
+	MTU        int
+	ListenPort int // UDP port of the tunnel; 0 = any
+	Peers      []Peer
+}
+
+// Peer is a [Peer] section.
+type Peer struct {
+	PublicKey           string // Base64
+	PresharedKey        string // Base64; optional
+	Endpoint            string // host:port
+	AllowedIPs          []netip.Prefix
+	PersistentKeepalive int // Seconds; 0 = off
+}
+
+// Load reads a wg-quick config file.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig reads a wg-quick config: an [Interface] section with
+// PrivateKey, Address, DNS, MTU and ListenPort, and [Peer] sections with
+// PublicKey, PresharedKey, Endpoint, AllowedIPs and PersistentKeepalive.
+// Keys only wg-quick acts on (PostUp, Table and the like) are ignored.
+func ParseConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{MTU: defaultMTU}
+	var section string
+	var peer *Peer
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+			case "peer":
+				cfg.Peers = append(cfg.Peers, Peer{})
+				peer = &cfg.Peers[len(cfg.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section [%s]", n, section)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		var err error
+		switch section {
+		case "interface":
+			err = cfg.set(key, value)
+		case "peer":
+			err = peer.set(key, value)
+		default:
+			err = fmt.Errorf("%s outside a section", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *Config) set(key, value string) error {
+	switch key {
+	case "privatekey":
+		c.PrivateKey = value
+	case "address":
+		for _, s := range list(value) {
+			p, err := parsePrefix(s)
+			if err != nil {
+				return fmt.Errorf("invalid Address %q", s)
+			}
+			c.Addresses = append(c.Addresses, p.Addr())
+		}
+	case "dns":
+		for _, s := range list(value) {
+			// Search domains are for wg-quick's resolv.conf.
+			if ip, err := netip.ParseAddr(s); err == nil {
+				c.DNS = append(c.DNS, ip)
+			}
+		}
+	case "mtu":
+		mtu, err := strconv.Atoi(value)
+		if err != nil || mtu < 576 || mtu > 65535 {
+			return fmt.Errorf("invalid MTU %q", value)
+		}
+		c.MTU = mtu
+	case "listenport":
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 {
+			return fmt.Errorf("invalid ListenPort %q", value)
+		}
+		c.ListenPort = port
+	}
+	return nil
+}
+
+func (p *Peer) set(key, value string) error {
+	switch key {
+	case "publickey":
+		p.PublicKey = value
+	case "presharedkey":
+		p.PresharedKey = value
+	case "endpoint":
+		p.Endpoint = value
+	case "allowedips":
+		for _, s := range list(value) {
+			prefix, err := parsePrefix(s)
+			if err != nil {
+				return fmt.Errorf("invalid AllowedIPs %q", s)
+			}
+			p.AllowedIPs = append(p.AllowedIPs, prefix)
+		}
+	case "persistentkeepalive":
+		if value == "off" {
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 65535 {
+			return fmt.Errorf("invalid PersistentKeepalive %q", value)
+		}
+		p.PersistentKeepalive = n
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if _, err := keyHex(c.PrivateKey); err != nil {
+		return fmt.Errorf("PrivateKey: %v", err)
+	}
+	if len(c.Addresses) == 0 {
+		return fmt.Errorf("Address is required")
+	}
+	if len(c.Peers) == 0 {
+		return fmt.Errorf("a [Peer] is required")
+	}
+	for i, p := range c.Peers {
+		if _, err := keyHex(p.PublicKey); err != nil {
+			return fmt.Errorf("peer %d: PublicKey: %v", i, err)
+		}
+		if p.PresharedKey != "" {
+			if _, err := keyHex(p.PresharedKey); err != nil {
+				return fmt.Errorf("peer %d: PresharedKey: %v", i, err)
+			}
+		}
+		if p.Endpoint == "" {
+			return fmt.Errorf("peer %d: Endpoint is required", i)
+		}
+		if _, _, err := net.SplitHostPort(p.Endpoint); err != nil {
+			return fmt.Errorf("peer %d: invalid Endpoint %q: %v", i, p.Endpoint, err)
+		}
+	}
+	return nil
+}
+
+// uapi renders the config in wireguard-go's configuration protocol,
+// resolving the peers' endpoints.
+func (c *Config) uapi() (string, error) {
+	var b strings.Builder
+	key, _ := keyHex(c.PrivateKey)
+	fmt.Fprintf(&b, "private_key=%s\n", key)
+	if c.ListenPort > 0 {
+		fmt.Fprintf(&b, "listen_port=%d\n", c.ListenPort)
+	}
+	for _, p := range c.Peers {
+		key, _ := keyHex(p.PublicKey)
+		fmt.Fprintf(&b, "public_key=%s\n", key)
+		if p.PresharedKey != "" {
+			psk, _ := keyHex(p.PresharedKey)
+			fmt.Fprintf(&b, "preshared_key=%s\n", psk)
+		}
+		addr, err := net.ResolveUDPAddr("udp", p.Endpoint)
+		if err != nil {
+			return "", fmt.Errorf("endpoint %s: %v", p.Endpoint, err)
+		}
+		ap := addr.AddrPort()
+		fmt.Fprintf(&b, "endpoint=%s\n", netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()))
+		if p.PersistentKeepalive > 0 {
+			fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", p.PersistentKeepalive)
+		}
+		for _, ip := range p.AllowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", ip)
+		}
+	}
+	return b.String(), nil
+}
+
+// keyHex converts a Base64 key to the hex of the UAPI.
+func keyHex(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("expected a Base64 32-byte key")
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// parsePrefix parses a CIDR, taking a bare address as a single host.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// list splits a comma-separated value.
+func list(value string) []string {
+	var out []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
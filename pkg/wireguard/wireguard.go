@@ -0,0 +1,96 @@
+// Package wireguard is a userspace WireGuard outbound: connections are made
+// by a gVisor netstack whose packets wireguard-go exchanges with the peers,
+// so no TUN device or privileges are needed.
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"phoenix/pkg/loglevel"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// dialTimeout bounds connecting to a target through the tunnel, the
+// WireGuard handshake included.
+const dialTimeout = 10 * time.Second
+
+// Dialer reaches targets through a WireGuard tunnel.
+type Dialer struct {
+	dev *device.Device
+	net *netstack.Net
+	dns bool // Names are resolved through the tunnel
+}
+
+// New brings the tunnel of cfg up. The handshake is made with the first
+// connection.
+func New(cfg *Config) (*Dialer, error) {
+	uapi, err := cfg.uapi()
+	if err != nil {
+		return nil, err
+	}
+	tdev, tnet, err := netstack.CreateNetTUN(cfg.Addresses, cfg.DNS, cfg.MTU)
+	if err != nil {
+		return nil, err
+	}
+	logger := &device.Logger{
+		Verbosef: func(format string, args ...any) { loglevel.Debugf("[WireGuard] "+format, args...) },
+		Errorf:   func(format string, args ...any) { log.Printf("[WireGuard] "+format, args...) },
+	}
+	dev := device.NewDevice(tdev, conn.NewDefaultBind(), logger)
+	if err := dev.IpcSet(uapi); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return &Dialer{dev: dev, net: tnet, dns: len(cfg.DNS) > 0}, nil
+}
+
+// Open loads the wg-quick config at path and brings its tunnel up.
+func Open(path string) (*Dialer, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// Dial connects to target over the tunnel. Without DNS servers in the
+// config, host names are resolved on this host.
+func (d *Dialer) Dial(target string) (io.ReadWriteCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if !d.dns {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			ips, err := net.DefaultResolver.LookupHost(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			target = net.JoinHostPort(ips[0], port)
+		}
+	}
+	c, err := d.net.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close takes the tunnel down, closing its connections.
+func (d *Dialer) Close() error {
+	d.dev.Close()
+	return nil
+}
@@ -0,0 +1,117 @@
+package wireguard
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// keypair returns a new Base64 WireGuard private and public key.
+func keypair(t *testing.T) (priv, pub string) {
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(k.Bytes()), base64.StdEncoding.EncodeToString(k.PublicKey().Bytes())
+}
+
+// freeUDPPort returns a UDP port nothing listens on now.
+func freeUDPPort(t *testing.T) int {
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestDial(t *testing.T) {
+	clientKey, clientPub := keypair(t)
+	peerKey, peerPub := keypair(t)
+	clientPort, peerPort := freeUDPPort(t), freeUDPPort(t)
+
+	parse := func(conf string) *Config {
+		cfg, err := ParseConfig(strings.NewReader(conf))
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", conf, err)
+		}
+		return cfg
+	}
+	peer, err := New(parse(fmt.Sprintf(`
+[Interface]
+PrivateKey = %s
+Address = 10.9.0.1/24
+ListenPort = %d
+
+[Peer]
+PublicKey = %s
+Endpoint = 127.0.0.1:%d
+AllowedIPs = 10.9.0.2/32
+`, peerKey, peerPort, clientPub, clientPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	ln, err := peer.net.ListenTCP(&net.TCPAddr{Port: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	client, err := New(parse(fmt.Sprintf(`
+[Interface]
+PrivateKey = %s
+Address = 10.9.0.2/32  # the client
+ListenPort = %d
+PostUp = ignored
+
+[Peer]
+PublicKey = %s
+Endpoint = 127.0.0.1:%d
+AllowedIPs = 0.0.0.0/0, ::/0
+`, clientKey, clientPort, peerPub, peerPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := client.Dial("10.9.0.1:80")
+	if err != nil {
+		t.Fatalf("Failed to dial through the tunnel: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the echo through the tunnel, got %q, %v", buf, err)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	_, pub := keypair(t)
+	for _, conf := range []string{
+		"[Interface]\nPrivateKey = short\nAddress = 10.0.0.2\n[Peer]\nPublicKey = " + pub + "\nEndpoint = 1.2.3.4:51820",
+		"[Interface]\nPrivateKey = " + pub + "\n[Peer]\nPublicKey = " + pub + "\nEndpoint = 1.2.3.4:51820",
+		"[Interface]\nPrivateKey = " + pub + "\nAddress = 10.0.0.2\n[Peer]\nPublicKey = " + pub,
+		"[Interface]\nPrivateKey = " + pub + "\nAddress = 10.0.0.2\nMTU = 100\n[Peer]\nPublicKey = " + pub + "\nEndpoint = 1.2.3.4:51820",
+		"[Wat]\n",
+	} {
+		if _, err := ParseConfig(strings.NewReader(conf)); err == nil {
+			t.Errorf("Expected an error for %q", conf)
+		}
+	}
+}